@@ -0,0 +1,171 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// AccountDataCache is an in-memory cache of global and room account data.
+//
+// When set in Client.AccountDataCache, it's used by GetAccountData and GetRoomAccountData, and updated by
+// SetAccountData and SetRoomAccountData. To keep it up to date with changes from other clients, it must also
+// receive sync responses, see Client.EnableAccountDataCache.
+type AccountDataCache struct {
+	global map[string]json.RawMessage
+	rooms  map[id.RoomID]map[string]json.RawMessage
+	lock   sync.RWMutex
+}
+
+// NewAccountDataCache creates a new empty account data cache.
+func NewAccountDataCache() *AccountDataCache {
+	return &AccountDataCache{
+		global: make(map[string]json.RawMessage),
+		rooms:  make(map[id.RoomID]map[string]json.RawMessage),
+	}
+}
+
+// Get returns the cached content of the given account data type. An empty room ID means global account data.
+func (adc *AccountDataCache) Get(roomID id.RoomID, evtType string) (json.RawMessage, bool) {
+	if adc == nil {
+		return nil, false
+	}
+	adc.lock.RLock()
+	defer adc.lock.RUnlock()
+	var data json.RawMessage
+	var ok bool
+	if roomID == "" {
+		data, ok = adc.global[evtType]
+	} else {
+		data, ok = adc.rooms[roomID][evtType]
+	}
+	return data, ok
+}
+
+// Set stores the content of the given account data type. An empty room ID means global account data.
+func (adc *AccountDataCache) Set(roomID id.RoomID, evtType string, data json.RawMessage) {
+	if adc == nil {
+		return
+	}
+	adc.lock.Lock()
+	defer adc.lock.Unlock()
+	if roomID == "" {
+		adc.global[evtType] = data
+	} else {
+		roomData, ok := adc.rooms[roomID]
+		if !ok {
+			roomData = make(map[string]json.RawMessage)
+			adc.rooms[roomID] = roomData
+		}
+		roomData[evtType] = data
+	}
+}
+
+// Invalidate removes the given account data type from the cache. An empty room ID means global account data.
+func (adc *AccountDataCache) Invalidate(roomID id.RoomID, evtType string) {
+	if adc == nil {
+		return
+	}
+	adc.lock.Lock()
+	defer adc.lock.Unlock()
+	if roomID == "" {
+		delete(adc.global, evtType)
+	} else {
+		delete(adc.rooms[roomID], evtType)
+	}
+}
+
+// Clear removes everything from the cache.
+func (adc *AccountDataCache) Clear() {
+	adc.lock.Lock()
+	adc.global = make(map[string]json.RawMessage)
+	adc.rooms = make(map[id.RoomID]map[string]json.RawMessage)
+	adc.lock.Unlock()
+}
+
+// ProcessSync is a sync handler that updates the cache with the account data in a sync response.
+func (adc *AccountDataCache) ProcessSync(_ context.Context, resp *RespSync, _ string) bool {
+	for _, evt := range resp.AccountData.Events {
+		if len(evt.Content.VeryRaw) > 0 {
+			adc.Set("", evt.Type.Type, evt.Content.VeryRaw)
+		}
+	}
+	for roomID, room := range resp.Rooms.Join {
+		for _, evt := range room.AccountData.Events {
+			if len(evt.Content.VeryRaw) > 0 {
+				adc.Set(roomID, evt.Type.Type, evt.Content.VeryRaw)
+			}
+		}
+	}
+	return true
+}
+
+var _ SyncHandler = (*AccountDataCache)(nil).ProcessSync
+
+// EnableAccountDataCache sets AccountDataCache to a new cache and registers it to receive sync responses
+// if the client's syncer is an ExtensibleSyncer. Room account data from streamed sync responses is only
+// cached if the syncer also supports OnRoomChunk (like DefaultSyncer).
+func (cli *Client) EnableAccountDataCache() {
+	cli.AccountDataCache = NewAccountDataCache()
+	if syncer, ok := cli.Syncer.(ExtensibleSyncer); ok {
+		syncer.OnSync(cli.AccountDataCache.ProcessSync)
+	}
+	if syncer, ok := cli.Syncer.(interface{ OnRoomChunk(SyncHandler) }); ok {
+		syncer.OnRoomChunk(cli.AccountDataCache.ProcessSync)
+	}
+}
+
+// GetAccountDataAs gets account data of the given type and unmarshals it into T.
+// If roomID is empty, global account data is fetched, otherwise the account data of the given room.
+func GetAccountDataAs[T any](ctx context.Context, cli *Client, roomID id.RoomID, evtType string) (output T, err error) {
+	if roomID == "" {
+		err = cli.GetAccountData(ctx, evtType, &output)
+	} else {
+		err = cli.GetRoomAccountData(ctx, roomID, evtType, &output)
+	}
+	return
+}
+
+// SetAccountDataAs sets account data of the given type.
+// If roomID is empty, global account data is set, otherwise the account data of the given room.
+func SetAccountDataAs[T any](ctx context.Context, cli *Client, roomID id.RoomID, evtType string, data T) error {
+	if roomID == "" {
+		return cli.SetAccountData(ctx, evtType, data)
+	}
+	return cli.SetRoomAccountData(ctx, roomID, evtType, data)
+}
+
+func (cli *Client) getAccountData(ctx context.Context, roomID id.RoomID, urlPath, evtType string, output any) error {
+	if cached, ok := cli.AccountDataCache.Get(roomID, evtType); ok {
+		return json.Unmarshal(cached, output)
+	}
+	body, err := cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, output)
+	if err == nil && cli.AccountDataCache != nil && json.Valid(body) {
+		cli.AccountDataCache.Set(roomID, evtType, body)
+	}
+	return err
+}
+
+func (cli *Client) setAccountData(ctx context.Context, roomID id.RoomID, urlPath, evtType string, data any) error {
+	_, err := cli.MakeRequest(ctx, http.MethodPut, urlPath, data, nil)
+	if err != nil {
+		return err
+	}
+	if cli.AccountDataCache != nil {
+		if raw, marshalErr := json.Marshal(data); marshalErr == nil {
+			cli.AccountDataCache.Set(roomID, evtType, raw)
+		} else {
+			cli.AccountDataCache.Invalidate(roomID, evtType)
+		}
+	}
+	return nil
+}
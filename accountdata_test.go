@@ -0,0 +1,63 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+)
+
+type testAccountData struct {
+	Value string `json:"value"`
+}
+
+func TestGetAccountDataAs_Cache(t *testing.T) {
+	var getCount int
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /_matrix/client/v3/user/{userID}/account_data/{type}", func(w http.ResponseWriter, r *http.Request) {
+		getCount++
+		_, _ = w.Write([]byte(`{"value": "global"}`))
+	})
+	mux.HandleFunc("PUT /_matrix/client/v3/user/{userID}/rooms/{roomID}/account_data/{type}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+	cli.EnableAccountDataCache()
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		data, err := mautrix.GetAccountDataAs[testAccountData](ctx, cli, "", "com.example.test")
+		require.NoError(t, err)
+		assert.Equal(t, "global", data.Value)
+	}
+	assert.Equal(t, 1, getCount)
+
+	err = mautrix.SetAccountDataAs(ctx, cli, "!room:example.com", "com.example.test", &testAccountData{Value: "room"})
+	require.NoError(t, err)
+	roomData, err := mautrix.GetAccountDataAs[*testAccountData](ctx, cli, "!room:example.com", "com.example.test")
+	require.NoError(t, err)
+	assert.Equal(t, "room", roomData.Value)
+
+	var resp mautrix.RespSync
+	require.NoError(t, json.Unmarshal([]byte(`{"account_data": {"events": [{"type": "com.example.test", "content": {"value": "updated"}}]}}`), &resp))
+	require.NoError(t, cli.Syncer.ProcessResponse(ctx, &resp, "since"))
+	data, err := mautrix.GetAccountDataAs[testAccountData](ctx, cli, "", "com.example.test")
+	require.NoError(t, err)
+	assert.Equal(t, "updated", data.Value)
+	assert.Equal(t, 1, getCount)
+}
@@ -7,7 +7,10 @@
 package appservice
 
 import (
+	"container/list"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net"
 	"net/http"
@@ -16,6 +19,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -45,7 +49,6 @@ func Create() *AppService {
 		StateStore: mautrix.NewMemoryStateStore().(StateStore),
 		Router:     mux.NewRouter(),
 		UserAgent:  mautrix.DefaultUserAgent,
-		txnIDC:     NewTransactionIDCache(128),
 		Live:       true,
 		Ready:      false,
 		ProcessID:  getDefaultProcessID(),
@@ -55,6 +58,8 @@ func Create() *AppService {
 		OTKCounts:      make(chan *mautrix.OTKCount, OTKChannelSize),
 		DeviceLists:    make(chan *mautrix.DeviceLists, EventChannelSize),
 		QueryHandler:   &QueryHandlerStub{},
+		TransactionIDs: NewTransactionIDCache(128),
+		Metrics:        NewMetrics(),
 
 		SpecVersions: &mautrix.RespVersions{},
 
@@ -65,6 +70,11 @@ func Create() *AppService {
 	as.Router.HandleFunc("/_matrix/app/v1/rooms/{roomAlias}", as.GetRoom).Methods(http.MethodGet)
 	as.Router.HandleFunc("/_matrix/app/v1/users/{userID}", as.GetUser).Methods(http.MethodGet)
 	as.Router.HandleFunc("/_matrix/app/v1/ping", as.PostPing).Methods(http.MethodPost)
+	as.Router.HandleFunc("/_matrix/app/v1/thirdparty/protocol/{protocol}", as.GetThirdPartyProtocol).Methods(http.MethodGet)
+	as.Router.HandleFunc("/_matrix/app/v1/thirdparty/location/{protocol}", as.GetThirdPartyLocation).Methods(http.MethodGet)
+	as.Router.HandleFunc("/_matrix/app/v1/thirdparty/location", as.GetThirdPartyLocation).Methods(http.MethodGet)
+	as.Router.HandleFunc("/_matrix/app/v1/thirdparty/user/{protocol}", as.GetThirdPartyUser).Methods(http.MethodGet)
+	as.Router.HandleFunc("/_matrix/app/v1/thirdparty/user", as.GetThirdPartyUser).Methods(http.MethodGet)
 	as.Router.HandleFunc("/_matrix/mau/live", as.GetLive).Methods(http.MethodGet)
 	as.Router.HandleFunc("/_matrix/mau/ready", as.GetReady).Methods(http.MethodGet)
 
@@ -83,6 +93,8 @@ type CreateOpts struct {
 	HostConfig HostConfig
 	// Optional, defaults to a memory state store
 	StateStore StateStore
+	// Optional, defaults to an in-memory cache of recent transaction IDs
+	TransactionIDStore TransactionIDStore
 }
 
 // CreateFull creates a fully configured appservice instance that can be [Start]ed and used directly.
@@ -107,6 +119,9 @@ func CreateFull(opts CreateOpts) (*AppService, error) {
 	} else {
 		as.StateStore = mautrix.NewMemoryStateStore().(StateStore)
 	}
+	if opts.TransactionIDStore != nil {
+		as.TransactionIDs = opts.TransactionIDStore
+	}
 	return as, nil
 }
 
@@ -151,7 +166,16 @@ type AppService struct {
 	Registration *Registration
 	Log          zerolog.Logger
 
-	txnIDC *TransactionIDCache
+	// TransactionIDs is used to deduplicate transactions retried by the homeserver.
+	// Defaults to an in-memory cache.
+	TransactionIDs TransactionIDStore
+	// ExtraServerTokens are accepted in addition to Registration.ServerToken when authenticating requests
+	// from the homeserver. This can be used to rotate the hs_token without downtime: add the new token here,
+	// update the registration on the homeserver, then swap the tokens in the config and remove the old one.
+	ExtraServerTokens []string
+	// Metrics contains statistics about transaction processing. It can be mounted as a HTTP handler to expose
+	// the metrics in the Prometheus format. Setting it to nil disables collection.
+	Metrics *Metrics
 
 	Events         chan *event.Event
 	ToDeviceEvents chan *event.Event
@@ -159,6 +183,8 @@ type AppService struct {
 	OTKCounts      chan *mautrix.OTKCount
 	QueryHandler   QueryHandler
 	StateStore     StateStore
+	// ThirdPartyHandler handles third-party protocol lookups. If nil, all lookups return M_NOT_FOUND.
+	ThirdPartyHandler ThirdPartyHandler
 
 	Router       *mux.Router
 	UserAgent    string
@@ -188,6 +214,33 @@ type AppService struct {
 	websocketRequestID    int32
 	// ProcessID is an identifier sent to the websocket proxy for debugging connections
 	ProcessID string
+	// WebsocketCompression enables permessage-deflate compression if the websocket proxy supports it.
+	WebsocketCompression bool
+	// WebsocketPingInterval is the interval for sending websocket-level pings. If no pong is received within
+	// two intervals, the connection is closed with ErrWebsocketPingTimeout. Zero disables pinging.
+	WebsocketPingInterval time.Duration
+	websocketQueue        []*WebsocketRequest
+	websocketQueueLock    sync.Mutex
+
+	stopping             atomic.Bool
+	inFlightTransactions atomic.Int64
+	undispatchedEvents   atomic.Int64
+	runningHandlers      atomic.Int64
+	runningProcessors    atomic.Int64
+
+	pingWaiters     map[string]chan struct{}
+	pingWaitersLock sync.Mutex
+
+	// IntentRateLimit is the maximum number of requests per second for each intent. Zero disables rate limiting.
+	IntentRateLimit float64
+	// IntentRateLimitBurst is the number of requests an intent can make before the rate limit kicks in.
+	IntentRateLimitBurst int
+
+	profileCache      map[id.UserID]*list.Element
+	profileCacheOrder *list.List
+	profileCacheLock  sync.Mutex
+	// ensureFlights deduplicates concurrent EnsureRegistered and EnsureJoined calls across all intents.
+	ensureFlights singleFlight
 
 	WebsocketTransactionHandler WebsocketTransactionHandler
 
@@ -224,6 +277,13 @@ type HostConfig struct {
 	Hostname string `yaml:"hostname"`
 	// Port is required when Hostname is an IP address, optional for unix sockets
 	Port uint16 `yaml:"port"`
+
+	// TLSCert and TLSKey are paths to a PEM certificate and private key. If set, the listener will use HTTPS.
+	TLSCert string `yaml:"tls_cert,omitempty"`
+	TLSKey  string `yaml:"tls_key,omitempty"`
+	// TLSClientCA is a path to PEM CA certificates. If set, the homeserver must present
+	// a client certificate signed by one of the CAs (i.e. mutual TLS). Requires TLSCert and TLSKey.
+	TLSClientCA string `yaml:"tls_client_ca,omitempty"`
 }
 
 // Address gets the whole address of the Appservice.
@@ -239,6 +299,36 @@ func (hc *HostConfig) IsConfigured() bool {
 	return hc.IsUnixSocket() || hc.Port != 0
 }
 
+// TLSConfig builds the TLS config for the listener. It returns nil if TLS isn't configured.
+func (hc *HostConfig) TLSConfig() (*tls.Config, error) {
+	if hc.TLSCert == "" && hc.TLSKey == "" {
+		if hc.TLSClientCA != "" {
+			return nil, fmt.Errorf("tls_client_ca requires tls_cert and tls_key to be set")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(hc.TLSCert, hc.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if hc.TLSClientCA != "" {
+		caData, err := os.ReadFile(hc.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS client CA: %w", err)
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificates found in TLS client CA file")
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
 // Save saves this config into a file at the given path.
 func (as *AppService) Save(path string) error {
 	data, err := yaml.Marshal(as)
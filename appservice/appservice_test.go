@@ -1,15 +1,25 @@
 package appservice
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"path"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 )
 
 func TestClient_UnixSocket(t *testing.T) {
@@ -40,3 +50,503 @@ func TestClient_UnixSocket(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "@joe:example.org", string(resp.UserID))
 }
+
+func TestAppService_EphemeralEvents(t *testing.T) {
+	as := Create()
+	as.Registration = &Registration{SoruEphemeralEvents: true}
+	var txn Transaction
+	err := json.Unmarshal([]byte(`{"events":[],"de.sorunome.msc2409.ephemeral":[
+		{"type":"m.typing","room_id":"!room:example.com","content":{"user_ids":["@user:example.com"]}}
+	]}`), &txn)
+	require.NoError(t, err)
+	ctx := context.Background()
+	as.handleTransaction(ctx, "txn1", &txn)
+	require.Len(t, as.Events, 1)
+	evt := <-as.Events
+	assert.Equal(t, event.EphemeralEventTyping, evt.Type)
+	assert.Equal(t, []id.UserID{"@user:example.com"}, evt.Content.AsTyping().UserIDs)
+
+	ep := NewEventProcessor(as)
+	ep.ExecMode = Sync
+	var calls []string
+	ep.OnEphemeral(func(ctx context.Context, evt *event.Event) {
+		calls = append(calls, "ephemeral")
+	})
+	ep.On(event.EphemeralEventTyping, func(ctx context.Context, evt *event.Event) {
+		calls = append(calls, "typing")
+	})
+	ep.Dispatch(ctx, evt)
+	assert.Equal(t, []string{"ephemeral", "typing"}, calls)
+}
+
+func TestEventProcessor_TypedEphemeralHandlers(t *testing.T) {
+	as := Create()
+	as.Registration = &Registration{EphemeralEvents: true}
+	var txn Transaction
+	err := json.Unmarshal([]byte(`{"events":[],"ephemeral":[
+		{"type":"m.typing","room_id":"!room:example.com","content":{"user_ids":["@user:example.com"]}},
+		{"type":"m.receipt","room_id":"!room:example.com","content":{"$event":{"m.read":{"@user:example.com":{"ts":123}}}}},
+		{"type":"m.presence","sender":"@user:example.com","content":{"presence":"online"}}
+	]}`), &txn)
+	require.NoError(t, err)
+	ctx := context.Background()
+	as.handleTransaction(ctx, "txn1", &txn)
+	require.Len(t, as.Events, 3)
+
+	ep := NewEventProcessor(as)
+	ep.ExecMode = Sync
+	var calls []string
+	ep.OnTyping(func(ctx context.Context, roomID id.RoomID, content *event.TypingEventContent) {
+		assert.Equal(t, id.RoomID("!room:example.com"), roomID)
+		assert.Equal(t, []id.UserID{"@user:example.com"}, content.UserIDs)
+		calls = append(calls, "typing")
+	})
+	ep.OnReceipt(func(ctx context.Context, roomID id.RoomID, content *event.ReceiptEventContent) {
+		assert.Equal(t, int64(123), (*content)["$event"][event.ReceiptTypeRead]["@user:example.com"].Timestamp.UnixMilli())
+		calls = append(calls, "receipt")
+	})
+	ep.OnPresence(func(ctx context.Context, userID id.UserID, content *event.PresenceEventContent) {
+		assert.Equal(t, id.UserID("@user:example.com"), userID)
+		assert.Equal(t, event.PresenceOnline, content.Presence)
+		calls = append(calls, "presence")
+	})
+	for len(as.Events) > 0 {
+		ep.Dispatch(ctx, <-as.Events)
+	}
+	assert.Equal(t, []string{"typing", "receipt", "presence"}, calls)
+}
+
+func TestAppService_ToDeviceWithoutEphemeral(t *testing.T) {
+	as := Create()
+	as.Registration = &Registration{MSC3202: true}
+	var txn Transaction
+	err := json.Unmarshal([]byte(`{"events":[],
+		"de.sorunome.msc2409.ephemeral":[{"type":"m.typing","room_id":"!room:example.com","content":{"user_ids":[]}}],
+		"de.sorunome.msc2409.to_device":[{"type":"m.room.encrypted","sender":"@user:example.com","to_user_id":"@bot:example.com","to_device_id":"DEVICE","content":{}}],
+		"org.matrix.msc3202.device_one_time_keys_count":{"@bot:example.com":{"DEVICE":{"signed_curve25519":5}}},
+		"org.matrix.msc3202.device_lists":{"changed":["@user:example.com"],"left":["@old:example.com"]}
+	}`), &txn)
+	require.NoError(t, err)
+	as.handleTransaction(context.Background(), "txn1", &txn)
+	assert.Len(t, as.Events, 0)
+	require.Len(t, as.ToDeviceEvents, 1)
+	evt := <-as.ToDeviceEvents
+	assert.Equal(t, event.ToDeviceEncrypted, evt.Type)
+	assert.Equal(t, id.DeviceID("DEVICE"), evt.ToDeviceID)
+	require.Len(t, as.OTKCounts, 1)
+	otk := <-as.OTKCounts
+	assert.Equal(t, 5, otk.SignedCurve25519)
+	assert.Equal(t, id.UserID("@bot:example.com"), otk.UserID)
+	assert.Equal(t, id.DeviceID("DEVICE"), otk.DeviceID)
+	require.Len(t, as.DeviceLists, 1)
+	dl := <-as.DeviceLists
+	assert.Equal(t, []id.UserID{"@user:example.com"}, dl.Changed)
+	assert.Equal(t, []id.UserID{"@old:example.com"}, dl.Left)
+}
+
+func TestAppService_Ping(t *testing.T) {
+	as := Create()
+	as.HomeserverDomain = "example.com"
+	as.Registration = &Registration{ID: "test", AppToken: "as_token", ServerToken: "hs_token", SenderLocalpart: "bot"}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_matrix/client/v1/appservice/test/ping", r.URL.Path)
+		var req mautrix.ReqAppservicePing
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		body, _ := json.Marshal(&req)
+		asReq := httptest.NewRequest(http.MethodPost, "/_matrix/app/v1/ping", bytes.NewReader(body))
+		asReq.Header.Set("Authorization", "Bearer hs_token")
+		asResp := httptest.NewRecorder()
+		as.Router.ServeHTTP(asResp, asReq)
+		assert.Equal(t, http.StatusOK, asResp.Code)
+		_, _ = w.Write([]byte(`{"duration_ms": 123}`))
+	}))
+	defer ts.Close()
+	require.NoError(t, as.SetHomeserverURL(ts.URL))
+
+	resp, err := as.Ping(context.Background())
+	require.NoError(t, err)
+	assert.True(t, resp.ReceivedByThisInstance)
+	assert.Equal(t, 123*time.Millisecond, resp.ServerDuration)
+	assert.NotEmpty(t, resp.TxnID)
+}
+
+func TestIntentAPI_SetDisplayName_Cache(t *testing.T) {
+	var gets, puts atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/_matrix/client/v3/register":
+			_, _ = w.Write([]byte(`{}`))
+		case r.Method == http.MethodGet:
+			gets.Add(1)
+			_, _ = w.Write([]byte(`{"displayname":"old"}`))
+		case r.Method == http.MethodPut:
+			puts.Add(1)
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	defer ts.Close()
+	as := Create()
+	as.HomeserverDomain = "example.com"
+	as.Registration = &Registration{AppToken: "as_token", SenderLocalpart: "bot"}
+	require.NoError(t, as.SetHomeserverURL(ts.URL))
+	ctx := context.Background()
+	intent := as.Intent("@ghost:example.com")
+
+	require.NoError(t, intent.SetDisplayName(ctx, "new"))
+	require.NoError(t, intent.SetDisplayName(ctx, "new"))
+	assert.Equal(t, int32(1), gets.Load())
+	assert.Equal(t, int32(1), puts.Load())
+
+	stateKey := intent.UserID.String()
+	as.checkProfileCache(&event.Event{
+		Type:     event.StateMember,
+		StateKey: &stateKey,
+		Content:  event.Content{Parsed: &event.MemberEventContent{Membership: event.MembershipJoin, Displayname: "changed"}},
+	})
+	require.NoError(t, intent.SetDisplayName(ctx, "new"))
+	assert.Equal(t, int32(2), gets.Load())
+	assert.Equal(t, int32(2), puts.Load())
+}
+
+func TestAppService_ProfileCacheSize(t *testing.T) {
+	origSize := ProfileCacheSize
+	ProfileCacheSize = 2
+	defer func() {
+		ProfileCacheSize = origSize
+	}()
+	as := Create()
+	setName := func(userID id.UserID, name string) {
+		as.updateEnsuredProfile(userID, func(profile *ensuredProfile) {
+			profile.Displayname = name
+			profile.HasDisplayname = true
+		})
+	}
+	setName("@a:example.com", "A")
+	setName("@b:example.com", "B")
+	// Using a makes b the least recently used entry
+	assert.Equal(t, "A", as.getEnsuredProfile("@a:example.com").Displayname)
+	setName("@c:example.com", "C")
+	assert.Len(t, as.profileCache, 2)
+	assert.False(t, as.getEnsuredProfile("@b:example.com").HasDisplayname)
+	assert.Equal(t, "A", as.getEnsuredProfile("@a:example.com").Displayname)
+	assert.Equal(t, "C", as.getEnsuredProfile("@c:example.com").Displayname)
+	as.InvalidateProfileCache("@a:example.com")
+	assert.False(t, as.getEnsuredProfile("@a:example.com").HasDisplayname)
+}
+
+func TestRateLimiter(t *testing.T) {
+	rl := NewRateLimiter(100, 2)
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		require.NoError(t, rl.Wait(ctx))
+	}
+	// The first two are allowed immediately by the burst, the next two need to wait 10ms each
+	assert.GreaterOrEqual(t, time.Since(start), 15*time.Millisecond)
+
+	slowRL := NewRateLimiter(1, 1)
+	require.NoError(t, slowRL.Wait(ctx))
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, slowRL.Wait(canceledCtx), context.Canceled)
+}
+
+type fakeThirdPartyHandler struct{}
+
+func (fakeThirdPartyHandler) GetProtocol(_ context.Context, protocol string) (*ThirdPartyProtocol, error) {
+	if protocol != "irc" {
+		return nil, nil
+	}
+	return &ThirdPartyProtocol{UserFields: []string{"nick"}, LocationFields: []string{"channel"}}, nil
+}
+
+func (fakeThirdPartyHandler) QueryLocation(_ context.Context, protocol string, fields map[string]string) ([]*ThirdPartyLocation, error) {
+	return []*ThirdPartyLocation{{Alias: id.RoomAlias("#irc_" + fields["channel"] + ":example.com"), Protocol: protocol, Fields: fields}}, nil
+}
+
+func (fakeThirdPartyHandler) QueryUser(_ context.Context, protocol string, fields map[string]string) ([]*ThirdPartyUser, error) {
+	return nil, Error{ErrorCode: "M_INVALID_PARAM", HTTPStatus: http.StatusBadRequest, Message: "Invalid nick"}
+}
+
+func (fakeThirdPartyHandler) GetLocationByAlias(_ context.Context, alias id.RoomAlias) ([]*ThirdPartyLocation, error) {
+	return nil, nil
+}
+
+func (fakeThirdPartyHandler) GetUserByID(_ context.Context, userID id.UserID) ([]*ThirdPartyUser, error) {
+	return []*ThirdPartyUser{{UserID: userID, Protocol: "irc", Fields: map[string]string{"nick": "foo"}}}, nil
+}
+
+func TestAppService_ThirdParty(t *testing.T) {
+	as := Create()
+	as.Registration = &Registration{ServerToken: "hs_token"}
+	request := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer hs_token")
+		resp := httptest.NewRecorder()
+		as.Router.ServeHTTP(resp, req)
+		return resp
+	}
+	assert.Equal(t, http.StatusNotFound, request("/_matrix/app/v1/thirdparty/protocol/irc").Code)
+
+	as.ThirdPartyHandler = fakeThirdPartyHandler{}
+	resp := request("/_matrix/app/v1/thirdparty/protocol/irc")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var protocol ThirdPartyProtocol
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &protocol))
+	assert.Equal(t, []string{"nick"}, protocol.UserFields)
+	assert.Equal(t, http.StatusNotFound, request("/_matrix/app/v1/thirdparty/protocol/xmpp").Code)
+
+	resp = request("/_matrix/app/v1/thirdparty/location/irc?channel=mautrix")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var locations []*ThirdPartyLocation
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &locations))
+	require.Len(t, locations, 1)
+	assert.Equal(t, id.RoomAlias("#irc_mautrix:example.com"), locations[0].Alias)
+	assert.Equal(t, http.StatusNotFound, request("/_matrix/app/v1/thirdparty/location?alias=%23foo:example.com").Code)
+	assert.Equal(t, http.StatusBadRequest, request("/_matrix/app/v1/thirdparty/location").Code)
+
+	assert.Equal(t, http.StatusBadRequest, request("/_matrix/app/v1/thirdparty/user/irc?nick=foo").Code)
+	resp = request("/_matrix/app/v1/thirdparty/user?userid=@irc_foo:example.com")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var users []*ThirdPartyUser
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &users))
+	require.Len(t, users, 1)
+	assert.Equal(t, id.UserID("@irc_foo:example.com"), users[0].UserID)
+}
+
+func TestAppService_Metrics(t *testing.T) {
+	as := Create()
+	as.Registration = &Registration{ServerToken: "hs_token"}
+	put := func(txnID, body string) {
+		req := httptest.NewRequest(http.MethodPut, "/_matrix/app/v1/transactions/"+txnID, bytes.NewReader([]byte(body)))
+		req.Header.Set("Authorization", "Bearer hs_token")
+		as.Router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	put("txn1", `{"events":[{"type":"m.room.message","room_id":"!room:example.com","event_id":"$1","content":{}}]}`)
+	put("txn1", `{"events":[]}`)
+	put("txn2", `not json`)
+	<-as.Events
+	assert.Equal(t, uint64(2), as.Metrics.TransactionsReceived.Load())
+	assert.Equal(t, uint64(1), as.Metrics.TransactionsDuplicate.Load())
+	assert.Equal(t, uint64(1), as.Metrics.EventsReceived.Load())
+	assert.Equal(t, map[string]uint64{"bad_json": 1}, as.Metrics.TransactionErrors())
+
+	ep := NewEventProcessor(as)
+	ep.ExecMode = Sync
+	ep.ExecSyncWarnTime = 0
+	ep.ExecSyncTimeout = 0
+	ep.On(event.EventMessage, func(ctx context.Context, evt *event.Event) {
+		panic("meow")
+	})
+	ep.Dispatch(context.Background(), &event.Event{Type: event.EventMessage})
+	assert.Equal(t, uint64(1), as.Metrics.HandlerPanics.Load())
+
+	resp := httptest.NewRecorder()
+	as.Metrics.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := resp.Body.String()
+	assert.Contains(t, body, "mautrix_appservice_transactions_total 2\n")
+	assert.Contains(t, body, "mautrix_appservice_transaction_errors_total{reason=\"bad_json\"} 1\n")
+	assert.Contains(t, body, "mautrix_appservice_transaction_events_bucket{le=\"1\"} 1\n")
+	assert.Contains(t, body, "mautrix_appservice_handler_duration_seconds_count 1\n")
+}
+
+func TestAppService_Stop(t *testing.T) {
+	as := Create()
+	as.Registration = &Registration{ServerToken: "hs_token"}
+	ep := NewEventProcessor(as)
+	release := make(chan struct{})
+	var handled atomic.Bool
+	ep.On(event.EventMessage, func(ctx context.Context, evt *event.Event) {
+		<-release
+		handled.Store(true)
+	})
+	ep.Start(context.Background())
+	defer ep.Stop()
+	put := func(txnID string) int {
+		req := httptest.NewRequest(http.MethodPut, "/_matrix/app/v1/transactions/"+txnID, bytes.NewReader([]byte(
+			`{"events":[{"type":"m.room.message","room_id":"!room:example.com","event_id":"$1","content":{}}]}`,
+		)))
+		req.Header.Set("Authorization", "Bearer hs_token")
+		resp := httptest.NewRecorder()
+		as.Router.ServeHTTP(resp, req)
+		return resp.Code
+	}
+	require.Equal(t, http.StatusOK, put("txn1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, as.Stop(ctx), context.DeadlineExceeded)
+	assert.True(t, as.IsStopping())
+	assert.Equal(t, http.StatusServiceUnavailable, put("txn2"))
+
+	close(release)
+	require.NoError(t, as.Stop(context.Background()))
+	assert.True(t, handled.Load())
+}
+
+func TestEventProcessor_DispatchCounters(t *testing.T) {
+	as := Create()
+	ep := NewEventProcessor(as)
+	ep.ExecMode = Sync
+	ep.On(event.EventMessage, func(ctx context.Context, evt *event.Event) {
+		panic("meow")
+	})
+	evt := &event.Event{Type: event.EventMessage}
+	as.undispatchedEvents.Add(2)
+	ep.dispatchQueued(context.Background(), evt)
+	ep.dispatchQueued(context.Background(), &event.Event{Type: event.EventReaction})
+	assert.True(t, as.eventsDrained())
+
+	// Unknown exec modes don't call handlers, so they must not be counted as running either
+	ep.ExecMode = ExecMode(255)
+	ep.Dispatch(context.Background(), evt)
+	assert.True(t, as.eventsDrained())
+}
+
+func TestIntentAPI_EnsureJoined_Deduplicated(t *testing.T) {
+	var registers, joins atomic.Int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_matrix/client/v3/register":
+			registers.Add(1)
+		case "/_matrix/client/v3/rooms/!room:example.com/join":
+			joins.Add(1)
+			<-release
+			_, _ = w.Write([]byte(`{"room_id": "!room:example.com"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+	as := Create()
+	as.HomeserverDomain = "example.com"
+	as.Registration = &Registration{AppToken: "as_token", SenderLocalpart: "bot"}
+	require.NoError(t, as.SetHomeserverURL(ts.URL))
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		// Use separate intent instances to make sure deduplication isn't tied to the instance
+		intent := as.NewIntentAPI("ghost")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, intent.EnsureJoined(ctx, "!room:example.com"))
+		}()
+	}
+	assert.Eventually(t, func() bool { return joins.Load() == 1 }, time.Second, 5*time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.EqualValues(t, 1, registers.Load())
+	assert.EqualValues(t, 1, joins.Load())
+	assert.True(t, as.StateStore.IsInRoom(ctx, "!room:example.com", "@ghost:example.com"))
+}
+
+func TestIntentAPI_EnsureJoined_CanceledCaller(t *testing.T) {
+	var joins atomic.Int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_matrix/client/v3/rooms/!room:example.com/join" {
+			joins.Add(1)
+			<-release
+			_, _ = w.Write([]byte(`{"room_id": "!room:example.com"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+	as := Create()
+	as.HomeserverDomain = "example.com"
+	as.Registration = &Registration{AppToken: "as_token", SenderLocalpart: "bot"}
+	require.NoError(t, as.SetHomeserverURL(ts.URL))
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leaderDone := make(chan error, 1)
+	go func() {
+		leaderDone <- as.NewIntentAPI("ghost").EnsureJoined(leaderCtx, "!room:example.com")
+	}()
+	require.Eventually(t, func() bool { return joins.Load() == 1 }, time.Second, 5*time.Millisecond)
+	waiterDone := make(chan error, 1)
+	go func() {
+		waiterDone <- as.NewIntentAPI("ghost").EnsureJoined(context.Background(), "!room:example.com")
+	}()
+	// Give the waiter time to start waiting for the leader's call
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-leaderDone, context.Canceled)
+	close(release)
+	assert.NoError(t, <-waiterDone, "the waiter shouldn't get the canceled caller's error")
+	assert.EqualValues(t, 1, joins.Load())
+	assert.True(t, as.StateStore.IsInRoom(context.Background(), "!room:example.com", "@ghost:example.com"))
+}
+
+func TestEnsureJoinedParams_FlightKey(t *testing.T) {
+	bot := &mautrix.Client{UserID: "@bot2:example.com"}
+	keys := map[string]struct{}{}
+	for _, params := range []EnsureJoinedParams{{}, {IgnoreCache: true}, {BotOverride: bot}} {
+		keys[params.flightKey("!room:example.com", "@ghost:example.com")] = struct{}{}
+	}
+	assert.Len(t, keys, 3, "calls with different params shouldn't be deduplicated together")
+}
+
+func TestIntentAPI_CreateDevice(t *testing.T) {
+	var lastQuery string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastQuery = r.URL.Query().Get("org.matrix.msc3202.device_id")
+		switch {
+		case r.URL.Path == "/_matrix/client/v3/register":
+		case r.Method == http.MethodPut:
+			assert.Equal(t, "/_matrix/client/v3/devices/DEVICE", r.URL.Path)
+		case r.Method == http.MethodDelete:
+			assert.Equal(t, "/_matrix/client/v3/devices/DEVICE", r.URL.Path)
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+	as := Create()
+	as.HomeserverDomain = "example.com"
+	as.Registration = &Registration{AppToken: "as_token", SenderLocalpart: "bot"}
+	require.NoError(t, as.SetHomeserverURL(ts.URL))
+	ctx := context.Background()
+	intent := as.Intent("@ghost:example.com")
+
+	_, err := intent.CreateDevice(ctx, "DEVICE", "Test device")
+	require.ErrorIs(t, err, ErrAppserviceDevicesNotSupported)
+	as.SpecVersions.UnstableFeatures = map[string]bool{mautrix.FeatureAppserviceDevices.UnstableFlag: true}
+
+	deviceID, err := intent.CreateDevice(ctx, "DEVICE", "Test device")
+	require.NoError(t, err)
+	assert.Equal(t, id.DeviceID("DEVICE"), deviceID)
+	assert.True(t, intent.SetAppServiceDeviceID)
+
+	require.NoError(t, intent.DeleteDevice(ctx, "DEVICE"))
+	assert.Equal(t, "DEVICE", lastQuery)
+	assert.Empty(t, intent.Client.DeviceID)
+	assert.False(t, intent.SetAppServiceDeviceID)
+}
+
+func TestAppService_ExtraServerTokens(t *testing.T) {
+	as := Create()
+	as.Registration = &Registration{ServerToken: "new_token"}
+	as.ExtraServerTokens = []string{"old_token"}
+	check := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/_matrix/app/v1/users/@user:example.com", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		as.Router.ServeHTTP(resp, req)
+		return resp.Code
+	}
+	assert.Equal(t, http.StatusNotFound, check("new_token"))
+	assert.Equal(t, http.StatusNotFound, check("old_token"))
+	assert.Equal(t, http.StatusForbidden, check("wrong_token"))
+	as.ExtraServerTokens = nil
+	assert.Equal(t, http.StatusForbidden, check("old_token"))
+
+	_, err := (&HostConfig{TLSClientCA: "/ca.pem"}).TLSConfig()
+	assert.Error(t, err)
+	tlsConfig, err := (&HostConfig{}).TLSConfig()
+	assert.NoError(t, err)
+	assert.Nil(t, tlsConfig)
+}
@@ -10,12 +10,14 @@ import (
 	"context"
 	"encoding/json"
 	"runtime/debug"
+	"slices"
 	"time"
 
 	"github.com/rs/zerolog"
 
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 )
 
 type ExecMode uint8
@@ -27,6 +29,9 @@ const (
 )
 
 type EventHandler = func(ctx context.Context, evt *event.Event)
+type TypingHandler = func(ctx context.Context, roomID id.RoomID, content *event.TypingEventContent)
+type ReceiptHandler = func(ctx context.Context, roomID id.RoomID, content *event.ReceiptEventContent)
+type PresenceHandler = func(ctx context.Context, userID id.UserID, content *event.PresenceEventContent)
 type OTKHandler = func(ctx context.Context, otk *mautrix.OTKCount)
 type DeviceListHandler = func(ctx context.Context, lists *mautrix.DeviceLists, since string)
 
@@ -40,6 +45,7 @@ type EventProcessor struct {
 	stop     chan struct{}
 	handlers map[event.Type][]EventHandler
 
+	ephemeralHandlers  []EventHandler
 	otkHandlers        []OTKHandler
 	deviceListHandlers []DeviceListHandler
 }
@@ -79,6 +85,35 @@ func (ep *EventProcessor) PrependHandler(evtType event.Type, handler EventHandle
 	ep.handlers[evtType] = handlers
 }
 
+// OnEphemeral registers a handler that is called for all ephemeral events (typing notifications, receipts and presence)
+// received in transactions, in addition to any handlers registered for the specific event type with On.
+//
+// Ephemeral events are only sent by the homeserver if they're enabled in the registration (see Registration.SetEphemeralEvents).
+func (ep *EventProcessor) OnEphemeral(handler EventHandler) {
+	ep.ephemeralHandlers = append(ep.ephemeralHandlers, handler)
+}
+
+// OnTyping registers a handler for typing notifications received in transactions.
+func (ep *EventProcessor) OnTyping(handler TypingHandler) {
+	ep.On(event.EphemeralEventTyping, func(ctx context.Context, evt *event.Event) {
+		handler(ctx, evt.RoomID, evt.Content.AsTyping())
+	})
+}
+
+// OnReceipt registers a handler for read receipts received in transactions.
+func (ep *EventProcessor) OnReceipt(handler ReceiptHandler) {
+	ep.On(event.EphemeralEventReceipt, func(ctx context.Context, evt *event.Event) {
+		handler(ctx, evt.RoomID, evt.Content.AsReceipt())
+	})
+}
+
+// OnPresence registers a handler for presence updates received in transactions.
+func (ep *EventProcessor) OnPresence(handler PresenceHandler) {
+	ep.On(event.EphemeralEventPresence, func(ctx context.Context, evt *event.Event) {
+		handler(ctx, evt.Sender, evt.Content.AsPresence())
+	})
+}
+
 func (ep *EventProcessor) OnOTK(handler OTKHandler) {
 	ep.otkHandlers = append(ep.otkHandlers, handler)
 }
@@ -89,6 +124,7 @@ func (ep *EventProcessor) OnDeviceList(handler DeviceListHandler) {
 
 func (ep *EventProcessor) recoverFunc(data interface{}) {
 	if err := recover(); err != nil {
+		ep.as.Metrics.countHandlerPanic()
 		d, _ := json.Marshal(data)
 		ep.as.Log.Error().
 			Str(zerolog.ErrorStackFieldName, string(debug.Stack())).
@@ -99,6 +135,11 @@ func (ep *EventProcessor) recoverFunc(data interface{}) {
 }
 
 func (ep *EventProcessor) callHandler(ctx context.Context, handler EventHandler, evt *event.Event) {
+	start := time.Now()
+	defer func() {
+		ep.as.Metrics.observeHandler(time.Since(start))
+		ep.as.runningHandlers.Add(-1)
+	}()
 	defer ep.recoverFunc(evt)
 	handler(ctx, evt)
 }
@@ -126,22 +167,28 @@ func (ep *EventProcessor) DispatchDeviceList(ctx context.Context, dl *mautrix.De
 }
 
 func (ep *EventProcessor) Dispatch(ctx context.Context, evt *event.Event) {
-	handlers, ok := ep.handlers[evt.Type]
-	if !ok {
+	handlers := ep.handlers[evt.Type]
+	if evt.Type.Class == event.EphemeralEventType && len(ep.ephemeralHandlers) > 0 {
+		handlers = append(slices.Clone(ep.ephemeralHandlers), handlers...)
+	}
+	if len(handlers) == 0 {
 		return
 	}
 	switch ep.ExecMode {
 	case AsyncHandlers:
 		for _, handler := range handlers {
+			ep.as.runningHandlers.Add(1)
 			go ep.callHandler(ctx, handler, evt)
 		}
 	case AsyncLoop:
+		ep.as.runningHandlers.Add(int64(len(handlers)))
 		go func() {
 			for _, handler := range handlers {
 				ep.callHandler(ctx, handler, evt)
 			}
 		}()
 	case Sync:
+		ep.as.runningHandlers.Add(int64(len(handlers)))
 		if ep.ExecSyncWarnTime == 0 && ep.ExecSyncTimeout == 0 {
 			for _, handler := range handlers {
 				ep.callHandler(ctx, handler, evt)
@@ -173,11 +220,19 @@ func (ep *EventProcessor) Dispatch(ctx context.Context, evt *event.Event) {
 		}
 	}
 }
+
+// dispatchQueued dispatches an event received from the AppService event channels
+// and marks it as dispatched for [AppService.Stop], even if Dispatch panics.
+func (ep *EventProcessor) dispatchQueued(ctx context.Context, evt *event.Event) {
+	defer ep.as.undispatchedEvents.Add(-1)
+	ep.Dispatch(ctx, evt)
+}
+
 func (ep *EventProcessor) startEvents(ctx context.Context) {
 	for {
 		select {
 		case evt := <-ep.as.Events:
-			ep.Dispatch(ctx, evt)
+			ep.dispatchQueued(ctx, evt)
 		case <-ep.stop:
 			return
 		}
@@ -188,7 +243,7 @@ func (ep *EventProcessor) startEncryption(ctx context.Context) {
 	for {
 		select {
 		case evt := <-ep.as.ToDeviceEvents:
-			ep.Dispatch(ctx, evt)
+			ep.dispatchQueued(ctx, evt)
 		case otk := <-ep.as.OTKCounts:
 			ep.DispatchOTK(ctx, otk)
 		case dl := <-ep.as.DeviceLists:
@@ -200,10 +255,14 @@ func (ep *EventProcessor) startEncryption(ctx context.Context) {
 }
 
 func (ep *EventProcessor) Start(ctx context.Context) {
+	ep.as.runningProcessors.Add(1)
 	go ep.startEvents(ctx)
 	go ep.startEncryption(ctx)
 }
 
+// Stop stops dispatching events. When shutting down gracefully, this should be called after [AppService.Stop]
+// so that queued events are still dispatched.
 func (ep *EventProcessor) Stop() {
+	ep.as.runningProcessors.Add(-1)
 	close(ep.stop)
 }
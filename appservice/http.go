@@ -8,6 +8,7 @@ package appservice
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"io"
@@ -27,10 +28,15 @@ import (
 
 // Start starts the HTTP server that listens for calls from the Matrix homeserver.
 func (as *AppService) Start() {
+	tlsConfig, err := as.Host.TLSConfig()
+	if err != nil {
+		as.Log.Error().Err(err).Msg("Failed to configure TLS for HTTP listener")
+		return
+	}
 	as.server = &http.Server{
-		Handler: as.Router,
+		Handler:   as.Router,
+		TLSConfig: tlsConfig,
 	}
-	var err error
 	if as.Host.IsUnixSocket() {
 		err = as.listenUnix()
 	} else {
@@ -54,24 +60,29 @@ func (as *AppService) listenUnix() error {
 	if err != nil {
 		return err
 	}
-	as.Log.Info().Str("socket", socket).Msg("Starting unix socket HTTP listener")
+	as.Log.Info().Str("socket", socket).Bool("tls", as.server.TLSConfig != nil).Msg("Starting unix socket HTTP listener")
+	if as.server.TLSConfig != nil {
+		return as.server.ServeTLS(listener, "", "")
+	}
 	return as.server.Serve(listener)
 }
 
 func (as *AppService) listenTCP() error {
-	as.Log.Info().Str("address", as.server.Addr).Msg("Starting HTTP listener")
+	as.Log.Info().Str("address", as.server.Addr).Bool("tls", as.server.TLSConfig != nil).Msg("Starting HTTP listener")
+	if as.server.TLSConfig != nil {
+		return as.server.ListenAndServeTLS("", "")
+	}
 	return as.server.ListenAndServe()
 }
 
-func (as *AppService) Stop() {
-	if as.server == nil {
-		return
+func (as *AppService) isValidServerToken(token string) bool {
+	valid := subtle.ConstantTimeCompare([]byte(token), []byte(as.Registration.ServerToken)) == 1
+	for _, extraToken := range as.ExtraServerTokens {
+		if extraToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(extraToken)) == 1 {
+			valid = true
+		}
 	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	_ = as.server.Shutdown(ctx)
-	as.server = nil
+	return valid
 }
 
 // CheckServerToken checks if the given request originated from the Matrix homeserver.
@@ -83,7 +94,7 @@ func (as *AppService) CheckServerToken(w http.ResponseWriter, r *http.Request) (
 			HTTPStatus: http.StatusForbidden,
 			Message:    "Missing access token",
 		}.Write(w)
-	} else if authHeader[len("Bearer "):] != as.Registration.ServerToken {
+	} else if !as.isValidServerToken(authHeader[len("Bearer "):]) {
 		Error{
 			ErrorCode:  ErrUnknownToken,
 			HTTPStatus: http.StatusForbidden,
@@ -101,6 +112,16 @@ func (as *AppService) PutTransaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !as.beginTransaction() {
+		Error{
+			ErrorCode:  ErrUnknown,
+			HTTPStatus: http.StatusServiceUnavailable,
+			Message:    ErrShuttingDown.Error(),
+		}.Write(w)
+		return
+	}
+	defer as.endTransaction()
+
 	vars := mux.Vars(r)
 	txnID := vars["txnID"]
 	if len(txnID) == 0 {
@@ -125,8 +146,18 @@ func (as *AppService) PutTransaction(w http.ResponseWriter, r *http.Request) {
 	// Don't use request context, handling shouldn't be stopped even if the request times out
 	ctx := context.Background()
 	ctx = log.WithContext(ctx)
-	if as.txnIDC.IsProcessed(txnID) {
+	if isProcessed, err := as.TransactionIDs.IsTransactionProcessed(ctx, txnID); err != nil {
+		log.Error().Err(err).Msg("Failed to check if transaction has been processed")
+		as.Metrics.countTransactionError("txn_store")
+		Error{
+			ErrorCode:  ErrUnknown,
+			HTTPStatus: http.StatusInternalServerError,
+			Message:    "Failed to check transaction status",
+		}.Write(w)
+		return
+	} else if isProcessed {
 		// Duplicate transaction ID: no-op
+		as.Metrics.countTransaction(true)
 		WriteBlankOK(w)
 		log.Debug().Msg("Ignoring duplicate transaction")
 		return
@@ -136,6 +167,7 @@ func (as *AppService) PutTransaction(w http.ResponseWriter, r *http.Request) {
 	err = json.Unmarshal(body, &txn)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to parse transaction content")
+		as.Metrics.countTransactionError("bad_json")
 		Error{
 			ErrorCode:  ErrBadJSON,
 			HTTPStatus: http.StatusBadRequest,
@@ -150,17 +182,21 @@ func (as *AppService) PutTransaction(w http.ResponseWriter, r *http.Request) {
 func (as *AppService) handleTransaction(ctx context.Context, id string, txn *Transaction) {
 	log := zerolog.Ctx(ctx)
 	log.Debug().Object("content", txn).Msg("Starting handling of transaction")
-	if as.Registration.EphemeralEvents {
+	start := time.Now()
+	as.Metrics.countTransaction(false)
+	if as.Registration.ReceivesEphemeralEvents() {
 		if txn.EphemeralEvents != nil {
 			as.handleEvents(ctx, txn.EphemeralEvents, event.EphemeralEventType)
 		} else if txn.MSC2409EphemeralEvents != nil {
 			as.handleEvents(ctx, txn.MSC2409EphemeralEvents, event.EphemeralEventType)
 		}
-		if txn.ToDeviceEvents != nil {
-			as.handleEvents(ctx, txn.ToDeviceEvents, event.ToDeviceEventType)
-		} else if txn.MSC2409ToDeviceEvents != nil {
-			as.handleEvents(ctx, txn.MSC2409ToDeviceEvents, event.ToDeviceEventType)
-		}
+	}
+	// To-device events were split out of MSC2409 into MSC4203, so they're not tied to the ephemeral event flag.
+	// The homeserver will only send them if the appservice has opted in (e.g. using MSC3202).
+	if txn.ToDeviceEvents != nil {
+		as.handleEvents(ctx, txn.ToDeviceEvents, event.ToDeviceEventType)
+	} else if txn.MSC2409ToDeviceEvents != nil {
+		as.handleEvents(ctx, txn.MSC2409ToDeviceEvents, event.ToDeviceEventType)
 	}
 	as.handleEvents(ctx, txn.Events, event.UnknownEventType)
 	if txn.DeviceLists != nil {
@@ -173,7 +209,14 @@ func (as *AppService) handleTransaction(ctx context.Context, id string, txn *Tra
 	} else if txn.MSC3202DeviceOTKCount != nil {
 		as.handleOTKCounts(ctx, txn.MSC3202DeviceOTKCount)
 	}
-	as.txnIDC.MarkProcessed(id)
+	if id != "" {
+		err := as.TransactionIDs.MarkTransactionProcessed(ctx, id)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to mark transaction as processed")
+			as.Metrics.countTransactionError("mark_processed")
+		}
+	}
+	as.Metrics.observeTransaction(txn.countEvents(), time.Since(start))
 	log.Debug().Msg("Finished dispatching events from transaction")
 }
 
@@ -232,6 +275,7 @@ func (as *AppService) handleEvents(ctx context.Context, evts []*event.Event, def
 
 		if evt.Type.IsState() {
 			mautrix.UpdateStateStore(ctx, as.StateStore, evt)
+			as.checkProfileCache(evt)
 		}
 		var ch chan *event.Event
 		if evt.Type.Class == event.ToDeviceEventType {
@@ -239,6 +283,7 @@ func (as *AppService) handleEvents(ctx context.Context, evts []*event.Event, def
 		} else {
 			ch = as.Events
 		}
+		as.undispatchedEvents.Add(1)
 		select {
 		case ch <- evt:
 		default:
@@ -307,6 +352,9 @@ func (as *AppService) PostPing(w http.ResponseWriter, r *http.Request) {
 	var txn mautrix.ReqAppservicePing
 	_ = json.Unmarshal(body, &txn)
 	as.Log.Debug().Str("txn_id", txn.TxnID).Msg("Received ping from homeserver")
+	if txn.TxnID != "" {
+		as.markPingReceived(txn.TxnID)
+	}
 
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
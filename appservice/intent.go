@@ -12,7 +12,6 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
-	"sync"
 
 	"github.com/rs/zerolog"
 
@@ -28,9 +27,10 @@ type IntentAPI struct {
 	Localpart string
 	UserID    id.UserID
 
-	registerLock sync.Mutex
-
 	IsCustomPuppet bool
+	// RateLimiter limits the number of requests this intent can make.
+	// It's created automatically if AppService.IntentRateLimit is set.
+	RateLimiter *RateLimiter
 }
 
 func (as *AppService) NewIntentAPI(localpart string) *IntentAPI {
@@ -39,7 +39,7 @@ func (as *AppService) NewIntentAPI(localpart string) *IntentAPI {
 	if userID == bot.UserID {
 		bot = nil
 	}
-	return &IntentAPI{
+	intent := &IntentAPI{
 		Client:    as.Client(userID),
 		bot:       bot,
 		as:        as,
@@ -48,6 +48,61 @@ func (as *AppService) NewIntentAPI(localpart string) *IntentAPI {
 
 		IsCustomPuppet: false,
 	}
+	if as.IntentRateLimit > 0 {
+		intent.RateLimiter = NewRateLimiter(as.IntentRateLimit, as.IntentRateLimitBurst)
+		prevHook := intent.Client.RequestHook
+		intent.Client.RequestHook = func(req *http.Request) {
+			err := intent.RateLimiter.Wait(req.Context())
+			if err != nil {
+				zerolog.Ctx(req.Context()).Warn().Err(err).Msg("Failed to wait for intent rate limit")
+			}
+			if prevHook != nil {
+				prevHook(req)
+			}
+		}
+	}
+	return intent
+}
+
+// SetDeviceID makes the intent masquerade as the given device using the MSC3202 device_id query parameter,
+// which is needed for appservice users to have their own encryption devices.
+//
+// The device must exist on the server, e.g. by creating it with [mautrix.Client.CreateDeviceMSC4190].
+// Passing an empty device ID disables device masquerading.
+func (intent *IntentAPI) SetDeviceID(deviceID id.DeviceID) {
+	intent.Client.DeviceID = deviceID
+	intent.Client.SetAppServiceDeviceID = deviceID != ""
+}
+
+// ErrAppserviceDevicesNotSupported is returned by CreateDevice and DeleteDevice
+// if the homeserver doesn't advertise support for MSC4190.
+var ErrAppserviceDevicesNotSupported = errors.New("homeserver doesn't support MSC4190 appservice device management")
+
+// CreateDevice creates a new device for the intent's user using MSC4190 and starts masquerading as it.
+// If the device ID is empty, a random one is generated. The homeserver must support MSC4190
+// (see [mautrix.FeatureAppserviceDevices]) and the registration must have it enabled.
+func (intent *IntentAPI) CreateDevice(ctx context.Context, deviceID id.DeviceID, initialDisplayName string) (id.DeviceID, error) {
+	if !intent.as.SpecVersions.Supports(mautrix.FeatureAppserviceDevices) {
+		return "", ErrAppserviceDevicesNotSupported
+	}
+	err := intent.EnsureRegistered(ctx)
+	if err != nil {
+		return "", err
+	}
+	err = intent.Client.CreateDeviceMSC4190(ctx, deviceID, initialDisplayName)
+	if err != nil {
+		return "", err
+	}
+	return intent.Client.DeviceID, nil
+}
+
+// DeleteDevice deletes a device of the intent's user using MSC4190.
+// If the intent is currently masquerading as the device, masquerading is disabled.
+func (intent *IntentAPI) DeleteDevice(ctx context.Context, deviceID id.DeviceID) error {
+	if !intent.as.SpecVersions.Supports(mautrix.FeatureAppserviceDevices) {
+		return ErrAppserviceDevicesNotSupported
+	}
+	return intent.Client.DeleteDeviceMSC4190(ctx, deviceID)
 }
 
 func (intent *IntentAPI) Register(ctx context.Context) error {
@@ -59,12 +114,18 @@ func (intent *IntentAPI) Register(ctx context.Context) error {
 	return err
 }
 
+// EnsureRegistered registers the intent's user if it's not already marked as registered in the state store.
+//
+// Concurrent calls for the same user (including from different IntentAPI instances) are deduplicated,
+// so only one register request is made.
 func (intent *IntentAPI) EnsureRegistered(ctx context.Context) error {
 	if intent.IsCustomPuppet {
 		return nil
 	}
-	intent.registerLock.Lock()
-	defer intent.registerLock.Unlock()
+	return intent.as.ensureFlights.Do(ctx, "register:"+intent.UserID.String(), intent.ensureRegistered)
+}
+
+func (intent *IntentAPI) ensureRegistered(ctx context.Context) error {
 	isRegistered, err := intent.as.StateStore.IsRegistered(ctx, intent.UserID)
 	if err != nil {
 		return fmt.Errorf("failed to check if user is registered: %w", err)
@@ -88,6 +149,10 @@ type EnsureJoinedParams struct {
 	BotOverride *mautrix.Client
 }
 
+// EnsureJoined joins the given room if the intent's user isn't already in it according to the state store.
+// If joining fails with M_FORBIDDEN, the bot user (or params.BotOverride) invites the user first.
+//
+// Concurrent calls for the same user and room are deduplicated, so only one join request is made.
 func (intent *IntentAPI) EnsureJoined(ctx context.Context, roomID id.RoomID, extra ...EnsureJoinedParams) error {
 	var params EnsureJoinedParams
 	if len(extra) > 1 {
@@ -98,7 +163,20 @@ func (intent *IntentAPI) EnsureJoined(ctx context.Context, roomID id.RoomID, ext
 	if intent.as.StateStore.IsInRoom(ctx, roomID, intent.UserID) && !params.IgnoreCache {
 		return nil
 	}
+	return intent.as.ensureFlights.Do(ctx, params.flightKey(roomID, intent.UserID), func(ctx context.Context) error {
+		return intent.ensureJoined(ctx, roomID, params)
+	})
+}
+
+func (params EnsureJoinedParams) flightKey(roomID id.RoomID, userID id.UserID) string {
+	var botOverride id.UserID
+	if params.BotOverride != nil {
+		botOverride = params.BotOverride.UserID
+	}
+	return fmt.Sprintf("join:%s:%s:%t:%s", roomID, userID, params.IgnoreCache, botOverride)
+}
 
+func (intent *IntentAPI) ensureJoined(ctx context.Context, roomID id.RoomID, params EnsureJoinedParams) error {
 	if err := intent.EnsureRegistered(ctx); err != nil {
 		return fmt.Errorf("failed to ensure joined: %w", err)
 	}
@@ -454,35 +532,53 @@ func (intent *IntentAPI) SetDisplayName(ctx context.Context, displayName string)
 	if err := intent.EnsureRegistered(ctx); err != nil {
 		return err
 	}
+	if cached := intent.as.getEnsuredProfile(intent.UserID); cached.HasDisplayname && cached.Displayname == displayName {
+		return nil
+	}
 	resp, err := intent.Client.GetOwnDisplayName(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to check current displayname: %w", err)
-	} else if resp.DisplayName == displayName {
-		// No need to update
-		return nil
+	} else if resp.DisplayName != displayName {
+		err = intent.Client.SetDisplayName(ctx, displayName)
+		if err != nil {
+			return err
+		}
 	}
-	return intent.Client.SetDisplayName(ctx, displayName)
+	intent.as.updateEnsuredProfile(intent.UserID, func(profile *ensuredProfile) {
+		profile.Displayname = displayName
+		profile.HasDisplayname = true
+	})
+	return nil
 }
 
 func (intent *IntentAPI) SetAvatarURL(ctx context.Context, avatarURL id.ContentURI) error {
 	if err := intent.EnsureRegistered(ctx); err != nil {
 		return err
 	}
+	if cached := intent.as.getEnsuredProfile(intent.UserID); cached.HasAvatarURL && cached.AvatarURL == avatarURL {
+		return nil
+	}
 	resp, err := intent.Client.GetOwnAvatarURL(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to check current avatar URL: %w", err)
-	} else if resp.FileID == avatarURL.FileID && resp.Homeserver == avatarURL.Homeserver {
-		// No need to update
-		return nil
-	}
-	if !avatarURL.IsEmpty() {
-		// Some homeservers require the avatar to be downloaded before setting it
-		resp, _ := intent.Download(ctx, avatarURL)
-		if resp != nil {
-			_ = resp.Body.Close()
+	} else if resp.FileID != avatarURL.FileID || resp.Homeserver != avatarURL.Homeserver {
+		if !avatarURL.IsEmpty() {
+			// Some homeservers require the avatar to be downloaded before setting it
+			resp, _ := intent.Download(ctx, avatarURL)
+			if resp != nil {
+				_ = resp.Body.Close()
+			}
+		}
+		err = intent.Client.SetAvatarURL(ctx, avatarURL)
+		if err != nil {
+			return err
 		}
 	}
-	return intent.Client.SetAvatarURL(ctx, avatarURL)
+	intent.as.updateEnsuredProfile(intent.UserID, func(profile *ensuredProfile) {
+		profile.AvatarURL = avatarURL
+		profile.HasAvatarURL = true
+	})
+	return nil
 }
 
 func (intent *IntentAPI) Whoami(ctx context.Context) (*mautrix.RespWhoami, error) {
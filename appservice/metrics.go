@@ -0,0 +1,210 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package appservice
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Histogram is a minimal Prometheus-style histogram with cumulative buckets.
+type Histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+	lock    sync.Mutex
+}
+
+// NewHistogram creates a histogram with the given upper bounds. The bounds must be sorted in ascending order.
+func NewHistogram(buckets ...float64) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+// Observe adds a single value to the histogram.
+func (h *Histogram) Observe(value float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// WriteMetric writes the histogram as a single unlabeled metric, including the HELP and TYPE lines.
+func (h *Histogram) WriteMetric(w io.Writer, name, help string) {
+	WriteMetricHeader(w, name, "histogram", help)
+	h.WriteSamples(w, name, "")
+}
+
+// WriteSamples writes the bucket, sum and count samples of the histogram in the Prometheus text format.
+// The HELP and TYPE lines are not included, so the same metric name can be written multiple times with different labels.
+//
+// The labels are inserted into each sample as-is, e.g. `method="send"`.
+func (h *Histogram) WriteSamples(w io.Writer, name, labels string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	labelPrefix := ""
+	if labels != "" {
+		labelPrefix = labels + ","
+	}
+	for i, bound := range h.buckets {
+		_, _ = fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, labelPrefix, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+	}
+	_, _ = fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labelPrefix, h.count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	_, _ = fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64), name, labels, h.count)
+}
+
+// Metrics contains counters and histograms about transaction processing.
+//
+// The metrics are always collected. To expose them, mount the struct as a HTTP handler
+// (e.g. on a separate internal listener, or on the appservice router with `as.Router.Handle("/metrics", as.Metrics)`),
+// which will respond with the Prometheus text exposition format.
+type Metrics struct {
+	TransactionsReceived  atomic.Uint64
+	TransactionsDuplicate atomic.Uint64
+	EventsReceived        atomic.Uint64
+	HandlerPanics         atomic.Uint64
+
+	EventsPerTransaction *Histogram
+	TransactionDuration  *Histogram
+	HandlerDuration      *Histogram
+
+	transactionErrors     map[string]uint64
+	transactionErrorsLock sync.Mutex
+}
+
+var _ http.Handler = (*Metrics)(nil)
+
+// NewMetrics creates a new Metrics instance with the default histogram buckets.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		EventsPerTransaction: NewHistogram(0, 1, 2, 5, 10, 20, 50, 100),
+		TransactionDuration:  NewHistogram(0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10),
+		HandlerDuration:      NewHistogram(0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60),
+		transactionErrors:    make(map[string]uint64),
+	}
+}
+
+func (m *Metrics) countTransaction(duplicate bool) {
+	if m == nil {
+		return
+	}
+	m.TransactionsReceived.Add(1)
+	if duplicate {
+		m.TransactionsDuplicate.Add(1)
+	}
+}
+
+func (m *Metrics) countTransactionError(reason string) {
+	if m == nil {
+		return
+	}
+	m.transactionErrorsLock.Lock()
+	m.transactionErrors[reason]++
+	m.transactionErrorsLock.Unlock()
+}
+
+func (m *Metrics) observeTransaction(events int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.EventsReceived.Add(uint64(events))
+	m.EventsPerTransaction.Observe(float64(events))
+	m.TransactionDuration.Observe(duration.Seconds())
+}
+
+func (m *Metrics) observeHandler(duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.HandlerDuration.Observe(duration.Seconds())
+}
+
+func (m *Metrics) countHandlerPanic() {
+	if m == nil {
+		return
+	}
+	m.HandlerPanics.Add(1)
+}
+
+// TransactionErrors returns a copy of the transaction error counts by reason.
+func (m *Metrics) TransactionErrors() map[string]uint64 {
+	m.transactionErrorsLock.Lock()
+	defer m.transactionErrorsLock.Unlock()
+	return maps.Clone(m.transactionErrors)
+}
+
+// WriteMetricHeader writes the HELP and TYPE lines of a metric in the Prometheus text exposition format.
+func WriteMetricHeader(w io.Writer, name, metricType, help string) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// WriteCounter writes an unlabeled counter in the Prometheus text exposition format.
+func WriteCounter(w io.Writer, name, help string, value uint64) {
+	WriteMetricHeader(w, name, "counter", help)
+	_, _ = fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+// PrometheusWriter is implemented by metric collections that can write themselves in the Prometheus text format.
+type PrometheusWriter interface {
+	WritePrometheus(w io.Writer)
+}
+
+// PrometheusHandler returns a HTTP handler that responds with all the given metrics
+// in the Prometheus text exposition format.
+func PrometheusHandler(metrics ...PrometheusWriter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.WriteHeader(http.StatusOK)
+		for _, m := range metrics {
+			m.WritePrometheus(w)
+		}
+	})
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	PrometheusHandler(m).ServeHTTP(w, r)
+}
+
+// WritePrometheus writes all metrics to the given writer in the Prometheus text exposition format.
+func (m *Metrics) WritePrometheus(w io.Writer) {
+	WriteCounter(w, "mautrix_appservice_transactions_total", "Number of transactions received from the homeserver", m.TransactionsReceived.Load())
+	WriteCounter(w, "mautrix_appservice_transactions_duplicate_total", "Number of transactions that were ignored as duplicates", m.TransactionsDuplicate.Load())
+	WriteCounter(w, "mautrix_appservice_events_total", "Number of events received in transactions", m.EventsReceived.Load())
+	WriteCounter(w, "mautrix_appservice_handler_panics_total", "Number of panics in event handlers", m.HandlerPanics.Load())
+	errs := m.TransactionErrors()
+	WriteMetricHeader(w, "mautrix_appservice_transaction_errors_total", "counter", "Number of errors while receiving transactions")
+	reasons := make([]string, 0, len(errs))
+	for reason := range errs {
+		reasons = append(reasons, reason)
+	}
+	slices.Sort(reasons)
+	for _, reason := range reasons {
+		_, _ = fmt.Fprintf(w, "mautrix_appservice_transaction_errors_total{reason=%q} %d\n", reason, errs[reason])
+	}
+	m.EventsPerTransaction.WriteMetric(w, "mautrix_appservice_transaction_events", "Number of events per transaction")
+	m.TransactionDuration.WriteMetric(w, "mautrix_appservice_transaction_duration_seconds", "Time taken to dispatch the events of a transaction")
+	m.HandlerDuration.WriteMetric(w, "mautrix_appservice_handler_duration_seconds", "Time taken by EventProcessor handlers")
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package appservice
+
+import (
+	"context"
+	"time"
+)
+
+// PingResult contains the timing results of a homeserver -> appservice ping.
+type PingResult struct {
+	TxnID string
+	// ServerDuration is the duration of the homeserver's request to the appservice as reported by the homeserver.
+	ServerDuration time.Duration
+	// TotalDuration is the duration of the whole ping request, including the appservice -> homeserver request.
+	TotalDuration time.Duration
+	// ReceivedByThisInstance is true if the homeserver's ping request was received by this AppService instance.
+	// If it's false, the homeserver is likely configured to send transactions to a different address
+	// (e.g. another instance of the same appservice).
+	ReceivedByThisInstance bool
+}
+
+func (as *AppService) addPingWaiter(txnID string) chan struct{} {
+	as.pingWaitersLock.Lock()
+	defer as.pingWaitersLock.Unlock()
+	if as.pingWaiters == nil {
+		as.pingWaiters = make(map[string]chan struct{})
+	}
+	ch := make(chan struct{})
+	as.pingWaiters[txnID] = ch
+	return ch
+}
+
+func (as *AppService) removePingWaiter(txnID string) {
+	as.pingWaitersLock.Lock()
+	delete(as.pingWaiters, txnID)
+	as.pingWaitersLock.Unlock()
+}
+
+func (as *AppService) markPingReceived(txnID string) {
+	as.pingWaitersLock.Lock()
+	defer as.pingWaitersLock.Unlock()
+	if ch, ok := as.pingWaiters[txnID]; ok {
+		close(ch)
+		delete(as.pingWaiters, txnID)
+	}
+}
+
+// Ping asks the homeserver to ping this appservice using the MSC2659 ping endpoint
+// (https://spec.matrix.org/v1.7/client-server-api/#post_matrixclientv1appserviceappserviceidping).
+//
+// This is meant to be used at startup to verify that the homeserver can reach the appservice.
+// Errors from the homeserver (e.g. M_CONNECTION_FAILED) are returned as-is.
+func (as *AppService) Ping(ctx context.Context) (*PingResult, error) {
+	client := as.BotClient()
+	txnID := client.TxnID()
+	received := as.addPingWaiter(txnID)
+	defer as.removePingWaiter(txnID)
+	start := time.Now()
+	resp, err := client.AppservicePing(ctx, as.Registration.ID, txnID)
+	if err != nil {
+		return nil, err
+	}
+	result := &PingResult{
+		TxnID:          txnID,
+		ServerDuration: time.Duration(resp.DurationMS) * time.Millisecond,
+		TotalDuration:  time.Since(start),
+	}
+	select {
+	case <-received:
+		result.ReceivedByThisInstance = true
+	default:
+	}
+	return result, nil
+}
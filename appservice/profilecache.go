@@ -0,0 +1,92 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package appservice
+
+import (
+	"container/list"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// ProfileCacheSize is the maximum number of users whose ensured profile is cached.
+// When the cache is full, the least recently used entries are evicted.
+var ProfileCacheSize = 4096
+
+// ensuredProfile contains the global profile values that an intent has ensured are set.
+type ensuredProfile struct {
+	Displayname    string
+	HasDisplayname bool
+	AvatarURL      id.ContentURI
+	HasAvatarURL   bool
+}
+
+type profileCacheEntry struct {
+	userID  id.UserID
+	profile ensuredProfile
+}
+
+func (as *AppService) getEnsuredProfile(userID id.UserID) ensuredProfile {
+	as.profileCacheLock.Lock()
+	defer as.profileCacheLock.Unlock()
+	elem, ok := as.profileCache[userID]
+	if !ok {
+		return ensuredProfile{}
+	}
+	as.profileCacheOrder.MoveToFront(elem)
+	return elem.Value.(*profileCacheEntry).profile
+}
+
+func (as *AppService) updateEnsuredProfile(userID id.UserID, update func(profile *ensuredProfile)) {
+	as.profileCacheLock.Lock()
+	defer as.profileCacheLock.Unlock()
+	if as.profileCache == nil {
+		as.profileCache = make(map[id.UserID]*list.Element)
+		as.profileCacheOrder = list.New()
+	}
+	elem, ok := as.profileCache[userID]
+	if ok {
+		as.profileCacheOrder.MoveToFront(elem)
+	} else {
+		elem = as.profileCacheOrder.PushFront(&profileCacheEntry{userID: userID})
+		as.profileCache[userID] = elem
+		for as.profileCacheOrder.Len() > max(ProfileCacheSize, 1) {
+			oldest := as.profileCacheOrder.Back()
+			as.profileCacheOrder.Remove(oldest)
+			delete(as.profileCache, oldest.Value.(*profileCacheEntry).userID)
+		}
+	}
+	update(&elem.Value.(*profileCacheEntry).profile)
+}
+
+// InvalidateProfileCache forgets the cached profile of the given user,
+// which means the next IntentAPI.SetDisplayName or SetAvatarURL call will check the current profile from the server.
+func (as *AppService) InvalidateProfileCache(userID id.UserID) {
+	as.profileCacheLock.Lock()
+	defer as.profileCacheLock.Unlock()
+	if elem, ok := as.profileCache[userID]; ok {
+		as.profileCacheOrder.Remove(elem)
+		delete(as.profileCache, userID)
+	}
+}
+
+func (as *AppService) checkProfileCache(evt *event.Event) {
+	if evt.Type != event.StateMember || evt.StateKey == nil {
+		return
+	}
+	userID := id.UserID(*evt.StateKey)
+	content, ok := evt.Content.Parsed.(*event.MemberEventContent)
+	if !ok || content.Membership != event.MembershipJoin {
+		return
+	}
+	cached := as.getEnsuredProfile(userID)
+	avatarURL, _ := content.AvatarURL.Parse()
+	if (cached.HasDisplayname && cached.Displayname != content.Displayname) ||
+		(cached.HasAvatarURL && cached.AvatarURL != avatarURL) {
+		as.InvalidateProfileCache(userID)
+	}
+}
@@ -101,6 +101,22 @@ func (txn *Transaction) ContentString() string {
 	return strings.Join(parts, ", ")
 }
 
+// countEvents returns the total number of PDUs, EDUs and to-device events in the transaction.
+func (txn *Transaction) countEvents() int {
+	count := len(txn.Events)
+	if txn.EphemeralEvents != nil {
+		count += len(txn.EphemeralEvents)
+	} else {
+		count += len(txn.MSC2409EphemeralEvents)
+	}
+	if txn.ToDeviceEvents != nil {
+		count += len(txn.ToDeviceEvents)
+	} else {
+		count += len(txn.MSC2409ToDeviceEvents)
+	}
+	return count
+}
+
 // EventListener is a function that receives events.
 type EventListener func(evt *event.Event)
 
@@ -123,12 +139,18 @@ func Respond(w http.ResponseWriter, data interface{}) error {
 }
 
 // Error represents a Matrix protocol error.
+//
+// It can also be returned from handlers (e.g. ThirdPartyHandler) to respond with a specific error.
 type Error struct {
 	HTTPStatus int       `json:"-"`
 	ErrorCode  ErrorCode `json:"errcode"`
 	Message    string    `json:"error"`
 }
 
+func (err Error) Error() string {
+	return fmt.Sprintf("%s: %s", err.ErrorCode, err.Message)
+}
+
 func (err Error) Write(w http.ResponseWriter) {
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(err.HTTPStatus)
@@ -144,6 +166,8 @@ const (
 	ErrBadJSON      ErrorCode = "M_BAD_JSON"
 	ErrNotJSON      ErrorCode = "M_NOT_JSON"
 	ErrUnknown      ErrorCode = "M_UNKNOWN"
+	ErrNotFound     ErrorCode = "M_NOT_FOUND"
+	ErrMissingParam ErrorCode = "M_MISSING_PARAM"
 )
 
 // Custom ErrorCodes
@@ -0,0 +1,68 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package appservice
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a simple token bucket rate limiter.
+type RateLimiter struct {
+	perSecond float64
+	burst     float64
+
+	tokens     float64
+	lastUpdate time.Time
+	lock       sync.Mutex
+}
+
+// NewRateLimiter creates a rate limiter that allows perSecond events per second with the given burst size.
+func NewRateLimiter(perSecond float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		perSecond:  perSecond,
+		burst:      float64(burst),
+		tokens:     float64(burst),
+		lastUpdate: time.Now(),
+	}
+}
+
+func (rl *RateLimiter) reserve() time.Duration {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	now := time.Now()
+	rl.tokens = min(rl.burst, rl.tokens+now.Sub(rl.lastUpdate).Seconds()*rl.perSecond)
+	rl.lastUpdate = now
+	rl.tokens--
+	if rl.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-rl.tokens / rl.perSecond * float64(time.Second))
+}
+
+// Wait blocks until an event is allowed or the context is canceled.
+func (rl *RateLimiter) Wait(ctx context.Context) error {
+	if rl == nil {
+		return nil
+	}
+	delay := rl.reserve()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package appservice
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// NamespaceRegex builds an anchored namespace regex for identifiers with the given sigil (e.g. @ for user IDs).
+//
+// Everything in the localpart template except the placeholder is escaped, and the placeholder is replaced with
+// the given (unescaped) matcher. If the template doesn't contain the placeholder, it's matched exactly.
+// For example, NamespaceRegex('@', "whatsapp_{{.}}", "{{.}}", ".+", "example.com") returns ^@whatsapp_.+:example\.com$
+func NamespaceRegex(sigil byte, localpartTemplate, placeholder, matcher, serverName string) *regexp.Regexp {
+	var localpart string
+	if placeholder != "" && strings.Contains(localpartTemplate, placeholder) {
+		parts := strings.SplitN(localpartTemplate, placeholder, 2)
+		localpart = regexp.QuoteMeta(parts[0]) + matcher + regexp.QuoteMeta(parts[1])
+	} else {
+		localpart = regexp.QuoteMeta(localpartTemplate)
+	}
+	return regexp.MustCompile(fmt.Sprintf("^%s%s:%s$", regexp.QuoteMeta(string(sigil)), localpart, regexp.QuoteMeta(serverName)))
+}
+
+// UserIDRegex returns a regex that matches user IDs generated from the given localpart template.
+// The placeholder in the template can be replaced with any non-empty string.
+func UserIDRegex(localpartTemplate, placeholder, serverName string) *regexp.Regexp {
+	return NamespaceRegex('@', localpartTemplate, placeholder, ".+", serverName)
+}
+
+// ExactUserIDRegex returns a regex that only matches the given user ID.
+func ExactUserIDRegex(localpart, serverName string) *regexp.Regexp {
+	return NamespaceRegex('@', localpart, "", "", serverName)
+}
+
+// RoomAliasRegex returns a regex that matches room aliases generated from the given alias localpart template.
+func RoomAliasRegex(aliasTemplate, placeholder, serverName string) *regexp.Regexp {
+	return NamespaceRegex('#', aliasTemplate, placeholder, ".+", serverName)
+}
+
+// Validate checks that the registration contains all the required fields and that the namespace regexes are valid.
+func (reg *Registration) Validate() error {
+	var errs []error
+	if reg.ID == "" {
+		errs = append(errs, errors.New("missing ID"))
+	}
+	if reg.AppToken == "" {
+		errs = append(errs, errors.New("missing as_token"))
+	}
+	if reg.ServerToken == "" {
+		errs = append(errs, errors.New("missing hs_token"))
+	} else if reg.ServerToken == reg.AppToken {
+		errs = append(errs, errors.New("as_token and hs_token must be different"))
+	}
+	if reg.SenderLocalpart == "" {
+		errs = append(errs, errors.New("missing sender_localpart"))
+	} else if err := id.ValidateUserLocalpart(reg.SenderLocalpart); err != nil {
+		errs = append(errs, fmt.Errorf("invalid sender_localpart: %w", err))
+	}
+	if reg.URL != "" {
+		if parsed, err := url.Parse(reg.URL); err != nil {
+			errs = append(errs, fmt.Errorf("invalid url: %w", err))
+		} else if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			errs = append(errs, fmt.Errorf("invalid url: unsupported scheme %q", parsed.Scheme))
+		}
+	}
+	for name, nsl := range map[string]NamespaceList{
+		"users":   reg.Namespaces.UserIDs,
+		"aliases": reg.Namespaces.RoomAliases,
+		"rooms":   reg.Namespaces.RoomIDs,
+	} {
+		for _, ns := range nsl {
+			if _, err := regexp.Compile(ns.Regex); err != nil {
+				errs = append(errs, fmt.Errorf("invalid regex %q in %s namespace: %w", ns.Regex, name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RegistrationBuilder is a helper for generating appservice registrations programmatically.
+type RegistrationBuilder struct {
+	Registration *Registration
+	ServerName   string
+}
+
+// NewRegistrationBuilder creates a new registration builder with random tokens.
+func NewRegistrationBuilder(id, url, serverName string) *RegistrationBuilder {
+	reg := CreateRegistration()
+	reg.ID = id
+	reg.URL = url
+	return &RegistrationBuilder{Registration: reg, ServerName: serverName}
+}
+
+// SenderLocalpart sets the localpart of the appservice's own user and adds it to the exclusive user namespace.
+func (rb *RegistrationBuilder) SenderLocalpart(localpart string) *RegistrationBuilder {
+	rb.Registration.SenderLocalpart = localpart
+	return rb.ExclusiveUser(localpart)
+}
+
+// ExclusiveUser adds a single user ID with the given localpart to the exclusive user namespace.
+func (rb *RegistrationBuilder) ExclusiveUser(localpart string) *RegistrationBuilder {
+	rb.Registration.Namespaces.UserIDs.Register(ExactUserIDRegex(localpart, rb.ServerName), true)
+	return rb
+}
+
+// UserNamespace adds user IDs matching the given localpart template to the user namespace.
+func (rb *RegistrationBuilder) UserNamespace(localpartTemplate, placeholder string, exclusive bool) *RegistrationBuilder {
+	rb.Registration.Namespaces.UserIDs.Register(UserIDRegex(localpartTemplate, placeholder, rb.ServerName), exclusive)
+	return rb
+}
+
+// AliasNamespace adds room aliases matching the given alias template to the alias namespace.
+func (rb *RegistrationBuilder) AliasNamespace(aliasTemplate, placeholder string, exclusive bool) *RegistrationBuilder {
+	rb.Registration.Namespaces.RoomAliases.Register(RoomAliasRegex(aliasTemplate, placeholder, rb.ServerName), exclusive)
+	return rb
+}
+
+// RoomIDNamespace adds the given room IDs to the room namespace.
+func (rb *RegistrationBuilder) RoomIDNamespace(exclusive bool, roomIDs ...id.RoomID) *RegistrationBuilder {
+	for _, roomID := range roomIDs {
+		rb.Registration.Namespaces.RoomIDs.Register(regexp.MustCompile("^"+regexp.QuoteMeta(roomID.String())+"$"), exclusive)
+	}
+	return rb
+}
+
+// EphemeralEvents sets whether the appservice should receive ephemeral events.
+func (rb *RegistrationBuilder) EphemeralEvents(enabled bool) *RegistrationBuilder {
+	rb.Registration.SetEphemeralEvents(enabled)
+	return rb
+}
+
+// RateLimited sets whether the appservice users should be rate limited by the homeserver.
+func (rb *RegistrationBuilder) RateLimited(rateLimited bool) *RegistrationBuilder {
+	rb.Registration.RateLimited = &rateLimited
+	return rb
+}
+
+// Protocols sets the third-party protocols that the appservice provides.
+func (rb *RegistrationBuilder) Protocols(protocols ...string) *RegistrationBuilder {
+	rb.Registration.Protocols = protocols
+	return rb
+}
+
+// Build validates and returns the registration.
+func (rb *RegistrationBuilder) Build() (*Registration, error) {
+	if err := rb.Registration.Validate(); err != nil {
+		return nil, err
+	}
+	return rb.Registration, nil
+}
+
+// YAML validates the registration and returns it in YAML format.
+func (rb *RegistrationBuilder) YAML() (string, error) {
+	reg, err := rb.Build()
+	if err != nil {
+		return "", err
+	}
+	return reg.YAML()
+}
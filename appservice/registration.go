@@ -40,6 +40,18 @@ func CreateRegistration() *Registration {
 	}
 }
 
+// SetEphemeralEvents enables or disables receiving ephemeral events (typing notifications, receipts and presence)
+// in transactions. Both the stable field and the unstable MSC2409 field are set for compatibility with older servers.
+func (reg *Registration) SetEphemeralEvents(enabled bool) {
+	reg.EphemeralEvents = enabled
+	reg.SoruEphemeralEvents = enabled
+}
+
+// ReceivesEphemeralEvents returns true if either the stable or unstable ephemeral event flag is set.
+func (reg *Registration) ReceivesEphemeralEvents() bool {
+	return reg.EphemeralEvents || reg.SoruEphemeralEvents
+}
+
 // LoadRegistration loads a YAML file and turns it into a Registration.
 func LoadRegistration(path string) (*Registration, error) {
 	data, err := os.ReadFile(path)
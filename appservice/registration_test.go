@@ -0,0 +1,57 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package appservice
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestNamespaceRegex(t *testing.T) {
+	userRegex := UserIDRegex("whatsapp_{{.}}", "{{.}}", "example.com")
+	assert.Equal(t, `^@whatsapp_.+:example\.com$`, userRegex.String())
+	assert.True(t, userRegex.MatchString("@whatsapp_123:example.com"))
+	assert.False(t, userRegex.MatchString("@whatsapp_:example.com"))
+	assert.False(t, userRegex.MatchString("@whatsapp_123:exampleXcom"))
+
+	exactRegex := ExactUserIDRegex("bot.user+1", "example.com")
+	assert.True(t, exactRegex.MatchString("@bot.user+1:example.com"))
+	assert.False(t, exactRegex.MatchString("@botXuser+1:example.com"))
+	assert.False(t, exactRegex.MatchString("@bot.userr1:example.com"))
+
+	aliasRegex := RoomAliasRegex("[bridge] {{.}}", "{{.}}", "example.com")
+	assert.True(t, aliasRegex.MatchString("#[bridge] meow:example.com"))
+	assert.False(t, aliasRegex.MatchString("#b meow:example.com"))
+}
+
+func TestRegistrationBuilder(t *testing.T) {
+	data, err := NewRegistrationBuilder("whatsapp", "http://localhost:29318", "example.com").
+		SenderLocalpart("whatsappbot").
+		UserNamespace("whatsapp_{{.}}", "{{.}}", true).
+		EphemeralEvents(true).
+		RateLimited(false).
+		YAML()
+	require.NoError(t, err)
+	var reg Registration
+	require.NoError(t, yaml.Unmarshal([]byte(data), &reg))
+	assert.Equal(t, "whatsapp", reg.ID)
+	assert.Equal(t, "whatsappbot", reg.SenderLocalpart)
+	assert.True(t, reg.EphemeralEvents)
+	assert.True(t, reg.SoruEphemeralEvents)
+	assert.Equal(t, NamespaceList{
+		{Regex: `^@whatsappbot:example\.com$`, Exclusive: true},
+		{Regex: `^@whatsapp_.+:example\.com$`, Exclusive: true},
+	}, reg.Namespaces.UserIDs)
+
+	_, err = NewRegistrationBuilder("", "ftp://localhost", "example.com").SenderLocalpart("Invalid User").Build()
+	assert.ErrorContains(t, err, "missing ID")
+	assert.ErrorContains(t, err, "invalid sender_localpart")
+	assert.ErrorContains(t, err, "unsupported scheme")
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package appservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrShuttingDown is returned to the homeserver for transactions received after Stop has been called.
+var ErrShuttingDown = errors.New("appservice is shutting down")
+
+// ServerShutdownTimeout is the maximum time to wait for the HTTP server to shut down
+// if the context passed to Stop has already expired.
+var ServerShutdownTimeout = 5 * time.Second
+
+const shutdownPollInterval = 10 * time.Millisecond
+
+// IsStopping returns true if Stop has been called.
+func (as *AppService) IsStopping() bool {
+	return as.stopping.Load()
+}
+
+func (as *AppService) beginTransaction() bool {
+	if as.stopping.Load() {
+		return false
+	}
+	as.inFlightTransactions.Add(1)
+	// Check again in case Stop was called concurrently and already saw zero in-flight transactions.
+	if as.stopping.Load() {
+		as.inFlightTransactions.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (as *AppService) endTransaction() {
+	as.inFlightTransactions.Add(-1)
+}
+
+func waitUntil(ctx context.Context, cond func() bool) error {
+	if cond() {
+		return nil
+	}
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if cond() {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (as *AppService) eventsDrained() bool {
+	return as.undispatchedEvents.Load() <= 0 && as.runningHandlers.Load() <= 0
+}
+
+// Stop gracefully shuts down the appservice.
+//
+// New transactions are rejected immediately, so the homeserver will retry them later (e.g. after a rolling restart).
+// Transactions that are already being received are allowed to finish, and if an [EventProcessor] is running,
+// Stop waits for it to dispatch all queued events and for the handlers to return. Queued outgoing websocket
+// commands (see QueueWebsocket) are flushed if the websocket is connected. Finally, the HTTP server is shut down.
+//
+// The EventProcessor should only be stopped after this returns. If the context is canceled before
+// everything is drained, the server is shut down anyway and the context error is returned.
+func (as *AppService) Stop(ctx context.Context) error {
+	as.stopping.Store(true)
+	log := as.Log.With().Str("action", "stop appservice").Logger()
+	var errs []error
+
+	err := waitUntil(ctx, func() bool {
+		return as.inFlightTransactions.Load() <= 0
+	})
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to wait for in-flight transactions: %w", err))
+	} else if as.runningProcessors.Load() > 0 {
+		log.Debug().Msg("Waiting for event handlers to finish")
+		err = waitUntil(ctx, as.eventsDrained)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to wait for event handlers: %w", err))
+		}
+	} else if undispatched := as.undispatchedEvents.Load(); undispatched > 0 {
+		log.Warn().Int64("count", undispatched).Msg("No event processor running, not waiting for queued events")
+	}
+
+	as.flushWebsocketQueue()
+	as.websocketQueueLock.Lock()
+	if queued := len(as.websocketQueue); queued > 0 {
+		errs = append(errs, fmt.Errorf("%d queued websocket commands weren't sent", queued))
+	}
+	as.websocketQueueLock.Unlock()
+
+	if as.server != nil {
+		shutdownCtx := ctx
+		if ctx.Err() != nil {
+			var cancel context.CancelFunc
+			shutdownCtx, cancel = context.WithTimeout(context.Background(), ServerShutdownTimeout)
+			defer cancel()
+		}
+		err = as.server.Shutdown(shutdownCtx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to shut down HTTP server: %w", err))
+		}
+		as.server = nil
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package appservice
+
+import (
+	"context"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// singleFlight deduplicates concurrent calls with the same key, so that e.g. many goroutines calling
+// EnsureJoined for the same user and room at once only make one join request. The zero value is ready to use.
+type singleFlight struct {
+	group singleflight.Group
+}
+
+// Do calls fn unless there's already a call with the same key in progress, in which case it waits for
+// that call and returns its error instead.
+//
+// The shared call runs with a context that isn't canceled when the caller that started it gives up,
+// so one canceled caller doesn't fail everyone else. Each caller stops waiting when its own context is done.
+func (sf *singleFlight) Do(ctx context.Context, key string, fn func(ctx context.Context) error) error {
+	ch := sf.group.DoChan(key, func() (any, error) {
+		return nil, fn(context.WithoutCancel(ctx))
+	})
+	select {
+	case res := <-ch:
+		return res.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package appservice
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// ThirdPartyFieldType describes a field used in third-party user and location queries.
+type ThirdPartyFieldType struct {
+	Regexp      string `json:"regexp"`
+	Placeholder string `json:"placeholder"`
+}
+
+// ThirdPartyProtocolInstance is a single instance (e.g. a network) of a third-party protocol.
+type ThirdPartyProtocolInstance struct {
+	Desc      string         `json:"desc"`
+	Icon      string         `json:"icon,omitempty"`
+	Fields    map[string]any `json:"fields"`
+	NetworkID string         `json:"network_id"`
+}
+
+// ThirdPartyProtocol contains the metadata of a third-party protocol.
+// See https://spec.matrix.org/v1.12/application-service-api/#get_matrixappv1thirdpartyprotocolprotocol
+type ThirdPartyProtocol struct {
+	UserFields     []string                       `json:"user_fields"`
+	LocationFields []string                       `json:"location_fields"`
+	Icon           string                         `json:"icon"`
+	FieldTypes     map[string]ThirdPartyFieldType `json:"field_types"`
+	Instances      []ThirdPartyProtocolInstance   `json:"instances"`
+}
+
+// ThirdPartyUser is a Matrix user ID that represents a user in a third-party protocol.
+type ThirdPartyUser struct {
+	UserID   id.UserID         `json:"userid"`
+	Protocol string            `json:"protocol"`
+	Fields   map[string]string `json:"fields"`
+}
+
+// ThirdPartyLocation is a Matrix room alias that represents a location (e.g. a channel) in a third-party protocol.
+type ThirdPartyLocation struct {
+	Alias    id.RoomAlias      `json:"alias"`
+	Protocol string            `json:"protocol"`
+	Fields   map[string]string `json:"fields"`
+}
+
+// ThirdPartyHandler handles third-party lookup queries from the homeserver.
+//
+// Methods can return an [Error] to respond with a specific error code. Returning nil or an empty list
+// will respond with M_NOT_FOUND.
+type ThirdPartyHandler interface {
+	GetProtocol(ctx context.Context, protocol string) (*ThirdPartyProtocol, error)
+	QueryLocation(ctx context.Context, protocol string, fields map[string]string) ([]*ThirdPartyLocation, error)
+	QueryUser(ctx context.Context, protocol string, fields map[string]string) ([]*ThirdPartyUser, error)
+	GetLocationByAlias(ctx context.Context, alias id.RoomAlias) ([]*ThirdPartyLocation, error)
+	GetUserByID(ctx context.Context, userID id.UserID) ([]*ThirdPartyUser, error)
+}
+
+func queryFields(r *http.Request) map[string]string {
+	query := r.URL.Query()
+	fields := make(map[string]string, len(query))
+	for key, values := range query {
+		if key == "access_token" || len(values) == 0 {
+			continue
+		}
+		fields[key] = values[0]
+	}
+	return fields
+}
+
+func (as *AppService) respondThirdParty(w http.ResponseWriter, r *http.Request, fn func(ctx context.Context, handler ThirdPartyHandler) (any, bool, error)) {
+	if !as.CheckServerToken(w, r) {
+		return
+	}
+	notFound := Error{
+		ErrorCode:  ErrNotFound,
+		HTTPStatus: http.StatusNotFound,
+		Message:    "No results found",
+	}
+	if as.ThirdPartyHandler == nil {
+		notFound.Write(w)
+		return
+	}
+	log := as.Log.With().Str("path", r.URL.Path).Logger()
+	ctx := log.WithContext(r.Context())
+	resp, found, err := fn(ctx, as.ThirdPartyHandler)
+	var respErr Error
+	if errors.As(err, &respErr) {
+		respErr.Write(w)
+	} else if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("Failed to handle third-party query")
+		Error{
+			ErrorCode:  ErrUnknown,
+			HTTPStatus: http.StatusInternalServerError,
+			Message:    "Failed to handle query",
+		}.Write(w)
+	} else if !found {
+		notFound.Write(w)
+	} else {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = Respond(w, resp)
+	}
+}
+
+// GetThirdPartyProtocol handles a /thirdparty/protocol GET call from the homeserver.
+func (as *AppService) GetThirdPartyProtocol(w http.ResponseWriter, r *http.Request) {
+	as.respondThirdParty(w, r, func(ctx context.Context, handler ThirdPartyHandler) (any, bool, error) {
+		resp, err := handler.GetProtocol(ctx, mux.Vars(r)["protocol"])
+		return resp, resp != nil, err
+	})
+}
+
+// GetThirdPartyLocation handles a /thirdparty/location GET call from the homeserver.
+func (as *AppService) GetThirdPartyLocation(w http.ResponseWriter, r *http.Request) {
+	as.respondThirdParty(w, r, func(ctx context.Context, handler ThirdPartyHandler) (any, bool, error) {
+		var resp []*ThirdPartyLocation
+		var err error
+		if protocol, ok := mux.Vars(r)["protocol"]; ok {
+			resp, err = handler.QueryLocation(ctx, protocol, queryFields(r))
+		} else if alias := r.URL.Query().Get("alias"); alias != "" {
+			resp, err = handler.GetLocationByAlias(ctx, id.RoomAlias(alias))
+		} else {
+			err = Error{ErrorCode: ErrMissingParam, HTTPStatus: http.StatusBadRequest, Message: "Missing alias parameter"}
+		}
+		return resp, len(resp) > 0, err
+	})
+}
+
+// GetThirdPartyUser handles a /thirdparty/user GET call from the homeserver.
+func (as *AppService) GetThirdPartyUser(w http.ResponseWriter, r *http.Request) {
+	as.respondThirdParty(w, r, func(ctx context.Context, handler ThirdPartyHandler) (any, bool, error) {
+		var resp []*ThirdPartyUser
+		var err error
+		if protocol, ok := mux.Vars(r)["protocol"]; ok {
+			resp, err = handler.QueryUser(ctx, protocol, queryFields(r))
+		} else if userID := r.URL.Query().Get("userid"); userID != "" {
+			resp, err = handler.GetUserByID(ctx, id.UserID(userID))
+		} else {
+			err = Error{ErrorCode: ErrMissingParam, HTTPStatus: http.StatusBadRequest, Message: "Missing userid parameter"}
+		}
+		return resp, len(resp) > 0, err
+	})
+}
@@ -6,8 +6,22 @@
 
 package appservice
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
+// TransactionIDStore is used to deduplicate transactions, as the homeserver will retry
+// sending a transaction if it didn't receive a response (e.g. because the appservice was restarting).
+//
+// The default implementation is an in-memory TransactionIDCache. A persistent implementation
+// (e.g. [sqlstatestore.SQLStateStore]) can be used to avoid double-processing transactions after restarts.
+type TransactionIDStore interface {
+	IsTransactionProcessed(ctx context.Context, txnID string) (bool, error)
+	MarkTransactionProcessed(ctx context.Context, txnID string) error
+}
+
+// TransactionIDCache is an in-memory TransactionIDStore that remembers a fixed number of recent transaction IDs.
 type TransactionIDCache struct {
 	array    []string
 	arrayPtr int
@@ -41,3 +55,14 @@ func (txnIDC *TransactionIDCache) MarkProcessed(txnID string) {
 	txnIDC.array[txnIDC.arrayPtr] = txnID
 	txnIDC.lock.Unlock()
 }
+
+func (txnIDC *TransactionIDCache) IsTransactionProcessed(_ context.Context, txnID string) (bool, error) {
+	return txnIDC.IsProcessed(txnID), nil
+}
+
+func (txnIDC *TransactionIDCache) MarkTransactionProcessed(_ context.Context, txnID string) error {
+	txnIDC.MarkProcessed(txnID)
+	return nil
+}
+
+var _ TransactionIDStore = (*TransactionIDCache)(nil)
@@ -117,6 +117,8 @@ var (
 
 	ErrWebsocketNotConnected = errors.New("websocket not connected")
 	ErrWebsocketClosed       = errors.New("websocket closed before response received")
+	ErrWebsocketPingTimeout  = errors.New("websocket didn't respond to ping in time")
+	ErrWebsocketQueueFull    = errors.New("websocket outbound queue is full")
 )
 
 func (mwcc MeowWebsocketCloseCode) String() string {
@@ -268,7 +270,19 @@ func (as *AppService) SetWebsocketCommandHandler(cmd string, handler WebsocketHa
 type WebsocketTransactionHandler func(ctx context.Context, msg WebsocketMessage) (bool, any)
 
 func (as *AppService) defaultHandleWebsocketTransaction(ctx context.Context, msg WebsocketMessage) (bool, any) {
-	if msg.TxnID == "" || !as.txnIDC.IsProcessed(msg.TxnID) {
+	if !as.beginTransaction() {
+		return false, ErrShuttingDown
+	}
+	defer as.endTransaction()
+	var isProcessed bool
+	if msg.TxnID != "" {
+		var err error
+		isProcessed, err = as.TransactionIDs.IsTransactionProcessed(ctx, msg.TxnID)
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("Failed to check if transaction has been processed")
+		}
+	}
+	if !isProcessed {
 		as.handleTransaction(ctx, msg.TxnID, &msg.Transaction)
 	} else {
 		zerolog.Ctx(ctx).Debug().
@@ -346,6 +360,10 @@ func (as *AppService) consumeWebsocket(stopFunc func(error), ws *websocket.Conn)
 }
 
 func (as *AppService) StartWebsocket(baseURL string, onConnect func()) error {
+	return as.startWebsocket(context.Background(), baseURL, onConnect)
+}
+
+func (as *AppService) startWebsocket(ctx context.Context, baseURL string, onConnect func()) error {
 	var parsed *url.URL
 	if baseURL != "" {
 		var err error
@@ -363,7 +381,9 @@ func (as *AppService) StartWebsocket(baseURL string, onConnect func()) error {
 	} else if parsed.Scheme == "https" {
 		parsed.Scheme = "wss"
 	}
-	ws, resp, err := websocket.DefaultDialer.Dial(parsed.String(), http.Header{
+	dialer := *websocket.DefaultDialer
+	dialer.EnableCompression = as.WebsocketCompression
+	ws, resp, err := dialer.DialContext(ctx, parsed.String(), http.Header{
 		"Authorization": []string{fmt.Sprintf("Bearer %s", as.Registration.AppToken)},
 		"User-Agent":    []string{as.BotClient().UserAgent},
 
@@ -404,7 +424,14 @@ func (as *AppService) StartWebsocket(baseURL string, onConnect func()) error {
 	as.PrepareWebsocket()
 	as.Log.Debug().Msg("Appservice transaction websocket opened")
 
+	stopPinger := make(chan struct{})
+	defer close(stopPinger)
+	if as.WebsocketPingInterval > 0 {
+		lastPong := setWebsocketPongHandler(ws)
+		go as.pingWebsocket(ws, lastPong, stopFunc, stopPinger)
+	}
 	go as.consumeWebsocket(stopFunc, ws)
+	as.flushWebsocketQueue()
 
 	var onConnectDone atomic.Bool
 	if onConnect != nil {
@@ -416,7 +443,14 @@ func (as *AppService) StartWebsocket(baseURL string, onConnect func()) error {
 		onConnectDone.Store(true)
 	}
 
-	closeErr := <-closeChan
+	var closeErr error
+	select {
+	case closeErr = <-closeChan:
+	case <-ctx.Done():
+		closeErr = ctx.Err()
+		// Make sure nothing tries to send to the close channel after this
+		closeChanOnce.Do(func() {})
+	}
 	if !onConnectDone.Load() {
 		as.Log.Warn().Msg("Websocket closed before onConnect returned, things may explode")
 	}
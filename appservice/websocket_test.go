@@ -0,0 +1,84 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package appservice
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppService_RunWebsocket(t *testing.T) {
+	origBackoff := WebsocketReconnectBackoff
+	WebsocketReconnectBackoff = 10 * time.Millisecond
+	defer func() {
+		WebsocketReconnectBackoff = origBackoff
+	}()
+
+	upgrader := websocket.Upgrader{EnableCompression: true}
+	var connCount atomic.Int32
+	received := make(chan string, 4)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_matrix/client/unstable/fi.mau.as_sync", r.URL.Path)
+		assert.Equal(t, "Bearer as_token", r.Header.Get("Authorization"))
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		if connCount.Add(1) == 1 {
+			var req WebsocketRequest
+			require.NoError(t, conn.ReadJSON(&req))
+			received <- req.Command
+			// Drop the connection without a close message to trigger a reconnect
+			return
+		}
+		received <- "reconnected"
+		for {
+			if _, _, err = conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	as := Create()
+	as.HomeserverDomain = "example.com"
+	as.Registration = &Registration{AppToken: "as_token", SenderLocalpart: "bot"}
+	as.WebsocketCompression = true
+	as.WebsocketPingInterval = 50 * time.Millisecond
+	require.NoError(t, as.SetHomeserverURL(ts.URL))
+	require.NoError(t, as.QueueWebsocket(&WebsocketRequest{Command: "queued"}))
+	require.NoError(t, as.QueueWebsocket(&WebsocketRequest{Command: "queued2"}))
+	assert.Len(t, as.websocketQueue, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- as.RunWebsocket(ctx, "", nil)
+	}()
+	for _, expected := range []string{"queued", "reconnected"} {
+		select {
+		case cmd := <-received:
+			assert.Equal(t, expected, cmd)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s", expected)
+		}
+	}
+	cancel()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunWebsocket didn't return after context was canceled")
+	}
+}
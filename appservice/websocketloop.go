@@ -0,0 +1,152 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package appservice
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Backoff settings for RunWebsocket.
+var (
+	WebsocketReconnectBackoff    = 2 * time.Second
+	WebsocketMaxReconnectBackoff = 2 * time.Minute
+	// WebsocketBackoffReset is how long the connection has to stay up for the backoff to be reset.
+	WebsocketBackoffReset = 5 * time.Minute
+)
+
+// WebsocketQueueSize is the maximum number of outbound commands queued by QueueWebsocket while disconnected.
+var WebsocketQueueSize = 1024
+
+// setWebsocketPongHandler installs a pong handler that stores the time of the last pong.
+// It must be called before the read loop is started, as gorilla/websocket doesn't allow
+// changing handlers concurrently with reads.
+func setWebsocketPongHandler(ws *websocket.Conn) *atomic.Int64 {
+	var lastPong atomic.Int64
+	lastPong.Store(time.Now().UnixNano())
+	ws.SetPongHandler(func(string) error {
+		lastPong.Store(time.Now().UnixNano())
+		return nil
+	})
+	return &lastPong
+}
+
+func (as *AppService) pingWebsocket(ws *websocket.Conn, lastPong *atomic.Int64, stopFunc func(error), stop <-chan struct{}) {
+	ticker := time.NewTicker(as.WebsocketPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if time.Since(time.Unix(0, lastPong.Load())) > 2*as.WebsocketPingInterval {
+			as.Log.Warn().Msg("Websocket didn't respond to pings, closing connection")
+			stopFunc(ErrWebsocketPingTimeout)
+			return
+		}
+		err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(as.WebsocketPingInterval))
+		if err != nil {
+			as.Log.Warn().Err(err).Msg("Failed to send websocket ping")
+		}
+	}
+}
+
+// QueueWebsocket sends the given command to the websocket, or queues it to be sent
+// once the websocket is reconnected if it's not currently connected.
+//
+// Unlike SendWebsocket, this doesn't return ErrWebsocketNotConnected.
+// If the queue is full, ErrWebsocketQueueFull is returned instead.
+func (as *AppService) QueueWebsocket(cmd *WebsocketRequest) error {
+	if cmd == nil {
+		return nil
+	}
+	// The queue lock is held while sending to keep the order of commands:
+	// nothing is sent directly while older commands are still waiting in the queue.
+	as.websocketQueueLock.Lock()
+	defer as.websocketQueueLock.Unlock()
+	if len(as.websocketQueue) == 0 {
+		err := as.SendWebsocket(cmd)
+		if !errors.Is(err, ErrWebsocketNotConnected) {
+			return err
+		}
+	}
+	if len(as.websocketQueue) >= WebsocketQueueSize {
+		return ErrWebsocketQueueFull
+	}
+	as.websocketQueue = append(as.websocketQueue, cmd)
+	return nil
+}
+
+func (as *AppService) flushWebsocketQueue() {
+	as.websocketQueueLock.Lock()
+	defer as.websocketQueueLock.Unlock()
+	for i, cmd := range as.websocketQueue {
+		err := as.SendWebsocket(cmd)
+		if err != nil {
+			as.Log.Warn().Err(err).
+				Int("remaining", len(as.websocketQueue)-i).
+				Msg("Failed to send queued websocket commands")
+			as.websocketQueue = as.websocketQueue[i:]
+			return
+		}
+	}
+	if len(as.websocketQueue) > 0 {
+		as.Log.Debug().Int("count", len(as.websocketQueue)).Msg("Sent queued websocket commands")
+	}
+	as.websocketQueue = nil
+}
+
+// IsWebsocketCloseFatal returns true if the given error returned by StartWebsocket means
+// that the websocket should not be reconnected.
+func IsWebsocketCloseFatal(err error) bool {
+	if errors.Is(err, ErrWebsocketManualStop) {
+		return true
+	}
+	var closeCommand *CloseCommand
+	return errors.As(err, &closeCommand) && closeCommand.Status == MeowConnectionReplaced
+}
+
+// RunWebsocket calls StartWebsocket in a loop, reconnecting with exponential backoff whenever the connection drops.
+//
+// The loop stops when the context is canceled, StopWebsocket is called with ErrWebsocketManualStop,
+// or the connection is replaced by another instance (in which case the close error is returned).
+// Transactions that weren't acknowledged before the disconnection will be resent by the server after reconnecting,
+// and commands sent with QueueWebsocket while disconnected are sent once the new connection is open.
+func (as *AppService) RunWebsocket(ctx context.Context, baseURL string, onConnect func()) error {
+	backoff := WebsocketReconnectBackoff
+	for {
+		connectedAt := time.Now()
+		err := as.startWebsocket(ctx, baseURL, onConnect)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if errors.Is(err, ErrWebsocketManualStop) {
+			return nil
+		} else if IsWebsocketCloseFatal(err) {
+			return err
+		} else if err != nil {
+			as.Log.Error().Err(err).Msg("Error in appservice websocket")
+		}
+		if time.Since(connectedAt) > WebsocketBackoffReset {
+			backoff = WebsocketReconnectBackoff
+		} else {
+			backoff = min(backoff*2, WebsocketMaxReconnectBackoff)
+		}
+		as.Log.Info().
+			Int("backoff_seconds", int(backoff.Seconds())).
+			Msg("Websocket disconnected, reconnecting...")
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
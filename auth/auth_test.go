@@ -0,0 +1,131 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package auth_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/auth"
+)
+
+type testServer struct {
+	*httptest.Server
+	codeChallenge string
+	accessToken   string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	ts := &testServer{accessToken: "token1"}
+	mux := http.NewServeMux()
+	writeJSON := func(w http.ResponseWriter, status int, data any) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(data)
+	}
+	mux.HandleFunc("GET /_matrix/client/unstable/org.matrix.msc2965/auth_issuer", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"issuer": ts.URL + "/"})
+	})
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, &auth.ServerMetadata{
+			Issuer:                ts.URL + "/",
+			AuthorizationEndpoint: ts.URL + "/authorize",
+			TokenEndpoint:         ts.URL + "/token",
+			RegistrationEndpoint:  ts.URL + "/register",
+		})
+	})
+	mux.HandleFunc("POST /register", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusCreated, map[string]string{"client_id": "client1"})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		switch r.PostForm.Get("grant_type") {
+		case auth.GrantTypeAuthorizationCode:
+			challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+			if r.PostForm.Get("code") != "code1" || base64.RawURLEncoding.EncodeToString(challenge[:]) != ts.codeChallenge {
+				writeJSON(w, http.StatusBadRequest, &auth.Error{Code: "invalid_grant"})
+				return
+			}
+			writeJSON(w, http.StatusOK, &auth.TokenResponse{AccessToken: "token1", RefreshToken: "refresh1", ExpiresIn: 300})
+		case auth.GrantTypeRefreshToken:
+			ts.accessToken = "token2"
+			writeJSON(w, http.StatusOK, &auth.TokenResponse{AccessToken: "token2", RefreshToken: "refresh2", ExpiresIn: 300})
+		}
+	})
+	mux.HandleFunc("GET /_matrix/client/v3/account/whoami", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+ts.accessToken {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"errcode": "M_UNKNOWN_TOKEN", "error": "Token expired"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"user_id": "@user:example.com"})
+	})
+	ts.Server = httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestServer(t)
+	cli, err := mautrix.NewClient(ts.URL, "", "")
+	require.NoError(t, err)
+
+	metadata, err := auth.Discover(ctx, cli, "")
+	require.NoError(t, err)
+	assert.Equal(t, ts.URL+"/token", metadata.TokenEndpoint)
+
+	oauthClient := auth.NewClient(metadata, "")
+	err = oauthClient.Register(ctx, &auth.ClientMetadata{
+		ClientName:   "mautrix-go test",
+		ClientURI:    "https://example.com",
+		RedirectURIs: []string{"http://localhost/callback"},
+		GrantTypes:   []string{auth.GrantTypeAuthorizationCode, auth.GrantTypeRefreshToken},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "client1", oauthClient.ClientID)
+
+	authReq, err := oauthClient.StartAuthorizationCode("http://localhost/callback", "")
+	require.NoError(t, err)
+	authURL, err := url.Parse(authReq.URL)
+	require.NoError(t, err)
+	assert.Equal(t, auth.Scope(authReq.DeviceID), authURL.Query().Get("scope"))
+	ts.codeChallenge = authURL.Query().Get("code_challenge")
+
+	_, err = oauthClient.CompleteAuthorizationCode(ctx, authReq, &url.URL{RawQuery: "state=wrong&code=code1"})
+	assert.ErrorIs(t, err, auth.ErrStateMismatch)
+	sess, err := oauthClient.CompleteAuthorizationCode(ctx, authReq, &url.URL{RawQuery: url.Values{
+		"state": {authReq.State},
+		"code":  {"code1"},
+	}.Encode()})
+	require.NoError(t, err)
+	assert.Equal(t, "token1", sess.AccessToken)
+
+	var refreshed bool
+	sess.OnRefresh = func(ctx context.Context, session *auth.Session) {
+		refreshed = true
+	}
+	sess.Apply(cli)
+	assert.Equal(t, authReq.DeviceID, cli.DeviceID)
+	// Make the server reject the current token to trigger a refresh
+	ts.accessToken = "invalid"
+	resp, err := cli.Whoami(ctx)
+	require.NoError(t, err)
+	assert.EqualValues(t, "@user:example.com", resp.UserID)
+	assert.True(t, refreshed)
+	assert.Equal(t, "token2", cli.AccessToken)
+	assert.Equal(t, "refresh2", sess.RefreshToken)
+}
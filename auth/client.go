@@ -0,0 +1,215 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"maunium.net/go/mautrix"
+)
+
+// OAuth 2.0 grant types used by Matrix clients.
+const (
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypeDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+var ErrNotRegistered = errors.New("client hasn't been registered")
+
+// Error is an error response from an OAuth 2.0 endpoint.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	StatusCode  int    `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("%s: %s", e.Code, e.Description)
+	}
+	return e.Code
+}
+
+func (e *Error) Is(other error) bool {
+	otherErr, ok := other.(*Error)
+	return ok && otherErr.Code == e.Code
+}
+
+// Common OAuth 2.0 errors that can be compared with errors.Is.
+var (
+	ErrAuthorizationPending = &Error{Code: "authorization_pending"}
+	ErrSlowDown             = &Error{Code: "slow_down"}
+	ErrAccessDenied         = &Error{Code: "access_denied"}
+	ErrExpiredToken         = &Error{Code: "expired_token"}
+	ErrInvalidGrant         = &Error{Code: "invalid_grant"}
+)
+
+// Client is an OAuth 2.0 client for a single authentication issuer.
+type Client struct {
+	HTTP     *http.Client
+	Metadata *ServerMetadata
+	// ClientID is the ID of the client at the issuer. It's set by Register,
+	// or it can be set manually if the client has been registered before.
+	ClientID string
+}
+
+// NewClient creates a new OAuth 2.0 client for the given issuer metadata.
+func NewClient(metadata *ServerMetadata, clientID string) *Client {
+	return &Client{
+		HTTP:     &http.Client{},
+		Metadata: metadata,
+		ClientID: clientID,
+	}
+}
+
+// ClientMetadata is the metadata of a client sent in dynamic client registration requests (RFC 7591).
+type ClientMetadata struct {
+	ClientName              string   `json:"client_name,omitempty"`
+	ClientURI               string   `json:"client_uri"`
+	LogoURI                 string   `json:"logo_uri,omitempty"`
+	TOSURI                  string   `json:"tos_uri,omitempty"`
+	PolicyURI               string   `json:"policy_uri,omitempty"`
+	RedirectURIs            []string `json:"redirect_uris,omitempty"`
+	GrantTypes              []string `json:"grant_types"`
+	ResponseTypes           []string `json:"response_types,omitempty"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+	ApplicationType         string   `json:"application_type,omitempty"`
+}
+
+type respRegister struct {
+	ClientID string `json:"client_id"`
+}
+
+// Register registers the client with the issuer using dynamic client registration and stores the client ID.
+//
+// If TokenEndpointAuthMethod isn't set, it defaults to "none", as Matrix clients are public clients.
+func (c *Client) Register(ctx context.Context, metadata *ClientMetadata) error {
+	if c.Metadata.RegistrationEndpoint == "" {
+		return errors.New("server doesn't support dynamic client registration")
+	}
+	if metadata.TokenEndpointAuthMethod == "" {
+		metadata.TokenEndpointAuthMethod = "none"
+	}
+	var resp respRegister
+	err := doJSON(ctx, c.HTTP, http.MethodPost, c.Metadata.RegistrationEndpoint, metadata, &resp)
+	if err != nil {
+		return fmt.Errorf("failed to register client: %w", err)
+	} else if resp.ClientID == "" {
+		return errors.New("registration response didn't contain a client ID")
+	}
+	c.ClientID = resp.ClientID
+	return nil
+}
+
+// TokenResponse is the response to a successful token request.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int    `json:"expires_in,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+func (c *Client) requestToken(ctx context.Context, form url.Values) (*TokenResponse, error) {
+	if c.ClientID == "" {
+		return nil, ErrNotRegistered
+	}
+	form.Set("client_id", c.ClientID)
+	var resp TokenResponse
+	err := doForm(ctx, c.HTTP, c.Metadata.TokenEndpoint, form, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RefreshToken exchanges a refresh token for a new access token.
+func (c *Client) RefreshToken(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+	return c.requestToken(ctx, url.Values{
+		"grant_type":    {GrantTypeRefreshToken},
+		"refresh_token": {refreshToken},
+	})
+}
+
+// RevokeToken revokes an access or refresh token, e.g. when logging out.
+func (c *Client) RevokeToken(ctx context.Context, token, tokenTypeHint string) error {
+	if c.Metadata.RevocationEndpoint == "" {
+		return errors.New("server doesn't support token revocation")
+	}
+	form := url.Values{"token": {token}, "client_id": {c.ClientID}}
+	if tokenTypeHint != "" {
+		form.Set("token_type_hint", tokenTypeHint)
+	}
+	return doForm(ctx, c.HTTP, c.Metadata.RevocationEndpoint, form, nil)
+}
+
+func doForm(ctx context.Context, httpClient *http.Client, endpoint string, form url.Values, respData any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doRequest(httpClient, req, respData)
+}
+
+func doJSON(ctx context.Context, httpClient *http.Client, method, endpoint string, reqData, respData any) error {
+	var body io.Reader
+	if reqData != nil {
+		data, err := json.Marshal(reqData)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	if reqData != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return doRequest(httpClient, req, respData)
+}
+
+func doRequest(httpClient *http.Client, req *http.Request, respData any) error {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", mautrix.DefaultUserAgent)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var oauthErr Error
+		if json.Unmarshal(data, &oauthErr) != nil || oauthErr.Code == "" {
+			return fmt.Errorf("unexpected HTTP %d response", resp.StatusCode)
+		}
+		oauthErr.StatusCode = resp.StatusCode
+		return &oauthErr
+	} else if respData != nil {
+		if err = json.Unmarshal(data, respData); err != nil {
+			return fmt.Errorf("failed to parse response: %w", err)
+		}
+	}
+	return nil
+}
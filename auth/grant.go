@@ -0,0 +1,200 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mau.fi/util/random"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// ScopeClientAPI grants full access to the client-server API.
+	ScopeClientAPI = "urn:matrix:org.matrix.msc2967.client:api:*"
+	// ScopeDevicePrefix is the prefix of the scope that binds the session to a device ID.
+	ScopeDevicePrefix = "urn:matrix:org.matrix.msc2967.client:device:"
+)
+
+var (
+	ErrStateMismatch          = errors.New("state in callback doesn't match authorization request")
+	ErrMissingCode            = errors.New("callback doesn't contain an authorization code")
+	ErrDeviceAuthExpired      = errors.New("device authorization expired before it was completed")
+	ErrNoDeviceAuthEndpoint   = errors.New("server doesn't support the device authorization grant")
+	ErrAuthorizationCancelled = errors.New("authorization was cancelled")
+)
+
+// GenerateDeviceID generates a random device ID for a new session.
+func GenerateDeviceID() id.DeviceID {
+	return id.DeviceID(random.String(10))
+}
+
+// Scope returns the scope to request for a session with full client API access using the given device ID.
+func Scope(deviceID id.DeviceID) string {
+	return ScopeClientAPI + " " + ScopeDevicePrefix + string(deviceID)
+}
+
+// DeviceAuthorization is the response to a device authorization request (RFC 8628).
+// The user must open VerificationURI and enter UserCode, or open VerificationURIComplete directly.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval,omitempty"`
+
+	DeviceID  id.DeviceID `json:"-"`
+	expiresAt time.Time
+}
+
+// StartDeviceAuthorization starts a device authorization grant for the given device ID.
+// If the device ID is empty, a random one is generated.
+func (c *Client) StartDeviceAuthorization(ctx context.Context, deviceID id.DeviceID) (*DeviceAuthorization, error) {
+	if c.Metadata.DeviceAuthorizationEndpoint == "" {
+		return nil, ErrNoDeviceAuthEndpoint
+	} else if c.ClientID == "" {
+		return nil, ErrNotRegistered
+	}
+	if deviceID == "" {
+		deviceID = GenerateDeviceID()
+	}
+	var resp DeviceAuthorization
+	err := doForm(ctx, c.HTTP, c.Metadata.DeviceAuthorizationEndpoint, url.Values{
+		"client_id": {c.ClientID},
+		"scope":     {Scope(deviceID)},
+	}, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start device authorization: %w", err)
+	}
+	resp.DeviceID = deviceID
+	resp.expiresAt = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	return &resp, nil
+}
+
+// PollDeviceAuthorization polls the token endpoint until the user completes or denies the device authorization,
+// the authorization expires or the context is cancelled.
+func (c *Client) PollDeviceAuthorization(ctx context.Context, auth *DeviceAuthorization) (*Session, error) {
+	interval := time.Duration(auth.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	for {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		resp, err := c.requestToken(ctx, url.Values{
+			"grant_type":  {GrantTypeDeviceCode},
+			"device_code": {auth.DeviceCode},
+		})
+		switch {
+		case err == nil:
+			return c.NewSession(auth.DeviceID, resp), nil
+		case errors.Is(err, ErrAuthorizationPending):
+		case errors.Is(err, ErrSlowDown):
+			interval += 5 * time.Second
+		case errors.Is(err, ErrExpiredToken):
+			return nil, ErrDeviceAuthExpired
+		default:
+			return nil, err
+		}
+		if !auth.expiresAt.IsZero() && auth.ExpiresIn > 0 && time.Now().After(auth.expiresAt) {
+			return nil, ErrDeviceAuthExpired
+		}
+	}
+}
+
+// AuthorizationRequest is an in-progress authorization code grant.
+// It must be kept until the user is redirected back to the client.
+type AuthorizationRequest struct {
+	// URL is the authorization URL that the user should be sent to.
+	URL          string
+	State        string
+	CodeVerifier string
+	RedirectURI  string
+	DeviceID     id.DeviceID
+}
+
+// StartAuthorizationCode prepares an authorization code grant with PKCE for the given device ID.
+// If the device ID is empty, a random one is generated.
+func (c *Client) StartAuthorizationCode(redirectURI string, deviceID id.DeviceID) (*AuthorizationRequest, error) {
+	if c.ClientID == "" {
+		return nil, ErrNotRegistered
+	}
+	authURL, err := url.Parse(c.Metadata.AuthorizationEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+	if deviceID == "" {
+		deviceID = GenerateDeviceID()
+	}
+	req := &AuthorizationRequest{
+		State:        random.String(16),
+		CodeVerifier: random.String(64),
+		RedirectURI:  redirectURI,
+		DeviceID:     deviceID,
+	}
+	challenge := sha256.Sum256([]byte(req.CodeVerifier))
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("response_mode", "query")
+	query.Set("client_id", c.ClientID)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("scope", Scope(deviceID))
+	query.Set("state", req.State)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+	req.URL = authURL.String()
+	return req, nil
+}
+
+// CompleteAuthorizationCode exchanges the authorization code in the redirect URL for tokens.
+func (c *Client) CompleteAuthorizationCode(ctx context.Context, req *AuthorizationRequest, callbackURL *url.URL) (*Session, error) {
+	query := callbackURL.Query()
+	if query.Get("state") != req.State {
+		return nil, ErrStateMismatch
+	} else if errCode := query.Get("error"); errCode == ErrAccessDenied.Code {
+		return nil, ErrAuthorizationCancelled
+	} else if errCode != "" {
+		return nil, &Error{Code: errCode, Description: query.Get("error_description")}
+	}
+	code := query.Get("code")
+	if code == "" {
+		return nil, ErrMissingCode
+	}
+	resp, err := c.requestToken(ctx, url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {req.RedirectURI},
+		"code_verifier": {req.CodeVerifier},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+	return c.NewSession(req.DeviceID, resp), nil
+}
+
+// DeviceIDFromScope extracts the device ID from a scope string.
+func DeviceIDFromScope(scope string) id.DeviceID {
+	for _, part := range strings.Fields(scope) {
+		if deviceID, found := strings.CutPrefix(part, ScopeDevicePrefix); found {
+			return id.DeviceID(deviceID)
+		}
+	}
+	return ""
+}
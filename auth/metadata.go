@@ -0,0 +1,114 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package auth implements next-generation OAuth 2.0 based authentication for Matrix clients (MSC3861).
+//
+// The usual flow is to discover the server metadata with [Discover], register a client with [Client.Register],
+// obtain tokens with either the device authorization grant ([Client.StartDeviceAuthorization]) or the
+// authorization code grant ([Client.StartAuthorizationCode]), and then attach the resulting [Session]
+// to a [mautrix.Client] with [Session.Apply].
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"maunium.net/go/mautrix"
+)
+
+var (
+	ErrNoIssuer             = errors.New("homeserver doesn't advertise an authentication issuer")
+	ErrIssuerMismatch       = errors.New("issuer in server metadata doesn't match discovered issuer")
+	ErrGrantTypeUnsupported = errors.New("grant type is not supported by the server")
+)
+
+// ServerMetadata is the OpenID Connect discovery document of an authentication issuer.
+type ServerMetadata struct {
+	Issuer                      string `json:"issuer"`
+	AuthorizationEndpoint       string `json:"authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	RegistrationEndpoint        string `json:"registration_endpoint,omitempty"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint,omitempty"`
+	RevocationEndpoint          string `json:"revocation_endpoint,omitempty"`
+	AccountManagementURI        string `json:"account_management_uri,omitempty"`
+
+	ResponseTypesSupported        []string `json:"response_types_supported,omitempty"`
+	GrantTypesSupported           []string `json:"grant_types_supported,omitempty"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported,omitempty"`
+	PromptValuesSupported         []string `json:"prompt_values_supported,omitempty"`
+}
+
+// SupportsGrantType returns whether the server advertises support for the given grant type.
+// If the server doesn't list supported grant types, the OAuth 2.0 defaults are assumed.
+func (sm *ServerMetadata) SupportsGrantType(grantType string) bool {
+	if len(sm.GrantTypesSupported) == 0 {
+		return grantType == GrantTypeAuthorizationCode
+	}
+	for _, supported := range sm.GrantTypesSupported {
+		if supported == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+type respAuthIssuer struct {
+	Issuer string `json:"issuer"`
+}
+
+// DiscoverIssuer finds the authentication issuer of the homeserver.
+//
+// The issuer is first requested from the homeserver's auth_issuer endpoint. If that fails and serverName
+// is set, the org.matrix.msc2965.authentication section of the server's .well-known file is used instead.
+func DiscoverIssuer(ctx context.Context, cli *mautrix.Client, serverName string) (string, error) {
+	var resp respAuthIssuer
+	_, err := cli.MakeFullRequest(ctx, mautrix.FullRequest{
+		Method:       http.MethodGet,
+		URL:          cli.BuildURL(mautrix.ClientURLPath{"unstable", "org.matrix.msc2965", "auth_issuer"}),
+		ResponseJSON: &resp,
+		MaxAttempts:  1,
+	})
+	if err == nil && resp.Issuer != "" {
+		return resp.Issuer, nil
+	} else if serverName == "" {
+		if err == nil {
+			err = ErrNoIssuer
+		}
+		return "", err
+	}
+	wellKnown, wkErr := mautrix.DiscoverClientAPI(ctx, serverName)
+	if wkErr != nil {
+		return "", fmt.Errorf("failed to fetch .well-known: %w", wkErr)
+	} else if wellKnown == nil || wellKnown.Authentication == nil || wellKnown.Authentication.Issuer == "" {
+		return "", ErrNoIssuer
+	}
+	return wellKnown.Authentication.Issuer, nil
+}
+
+// FetchServerMetadata fetches the OpenID Connect discovery document of the given issuer.
+func FetchServerMetadata(ctx context.Context, httpClient *http.Client, issuer string) (*ServerMetadata, error) {
+	var metadata ServerMetadata
+	err := doJSON(ctx, httpClient, http.MethodGet, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", nil, &metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch server metadata: %w", err)
+	} else if strings.TrimSuffix(metadata.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, fmt.Errorf("%w (expected %s, got %s)", ErrIssuerMismatch, issuer, metadata.Issuer)
+	}
+	return &metadata, nil
+}
+
+// Discover finds the authentication issuer of the homeserver and fetches its metadata.
+// See [DiscoverIssuer] for the meaning of serverName.
+func Discover(ctx context.Context, cli *mautrix.Client, serverName string) (*ServerMetadata, error) {
+	issuer, err := DiscoverIssuer(ctx, cli, serverName)
+	if err != nil {
+		return nil, err
+	}
+	return FetchServerMetadata(ctx, cli.Client, issuer)
+}
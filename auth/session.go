@@ -0,0 +1,100 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+var ErrNoRefreshToken = errors.New("session doesn't have a refresh token")
+
+// Session contains the tokens of a logged-in device.
+type Session struct {
+	Client       *Client     `json:"-"`
+	DeviceID     id.DeviceID `json:"device_id"`
+	AccessToken  string      `json:"access_token"`
+	RefreshToken string      `json:"refresh_token,omitempty"`
+	ExpiresAt    time.Time   `json:"expires_at"`
+
+	// OnRefresh is called after the tokens have been refreshed, so that the new tokens can be persisted.
+	OnRefresh func(ctx context.Context, session *Session) `json:"-"`
+
+	lock sync.Mutex
+}
+
+// NewSession creates a session from a token response.
+func (c *Client) NewSession(deviceID id.DeviceID, resp *TokenResponse) *Session {
+	sess := &Session{Client: c, DeviceID: deviceID}
+	sess.update(resp)
+	return sess
+}
+
+func (s *Session) update(resp *TokenResponse) {
+	s.AccessToken = resp.AccessToken
+	if resp.RefreshToken != "" {
+		s.RefreshToken = resp.RefreshToken
+	}
+	if resp.ExpiresIn > 0 {
+		s.ExpiresAt = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	} else {
+		s.ExpiresAt = time.Time{}
+	}
+}
+
+// Refresh gets a new access token using the refresh token. If the current access token is different
+// from oldToken, it has already been refreshed by another caller and is returned as-is.
+//
+// This matches the signature of [mautrix.Client.RefreshAccessToken].
+func (s *Session) Refresh(ctx context.Context, oldToken string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if oldToken != "" && s.AccessToken != oldToken {
+		return s.AccessToken, nil
+	} else if s.RefreshToken == "" {
+		return "", ErrNoRefreshToken
+	}
+	resp, err := s.Client.RefreshToken(ctx, s.RefreshToken)
+	if err != nil {
+		return "", err
+	}
+	s.update(resp)
+	if s.OnRefresh != nil {
+		s.OnRefresh(ctx, s)
+	}
+	return s.AccessToken, nil
+}
+
+// Apply sets the access token and device ID of the given Matrix client and makes it refresh
+// the access token using this session when the homeserver rejects it.
+//
+// The user ID is not known from the token response, so it should be fetched with [mautrix.Client.Whoami]
+// after applying the session if it's not known yet.
+func (s *Session) Apply(cli *mautrix.Client) {
+	s.lock.Lock()
+	cli.SetAccessToken(s.AccessToken)
+	s.lock.Unlock()
+	cli.DeviceID = s.DeviceID
+	cli.RefreshAccessToken = s.Refresh
+}
+
+// Logout revokes the refresh and access tokens of the session.
+func (s *Session) Logout(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.RefreshToken != "" {
+		if err := s.Client.RevokeToken(ctx, s.RefreshToken, "refresh_token"); err != nil {
+			return err
+		}
+	}
+	return s.Client.RevokeToken(ctx, s.AccessToken, "access_token")
+}
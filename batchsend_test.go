@@ -0,0 +1,92 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func makeBatchSendServer(t *testing.T, requests *[]*mautrix.ReqBeeperBatchSend) *mautrix.Client {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /_matrix/client/unstable/com.beeper.backfill/rooms/{roomID}/batch_send", func(w http.ResponseWriter, r *http.Request) {
+		var req mautrix.ReqBeeperBatchSend
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		*requests = append(*requests, &req)
+		var resp mautrix.RespBeeperBatchSend
+		for _, evt := range req.Events {
+			resp.EventIDs = append(resp.EventIDs, id.EventID("$"+evt.Content.Raw["body"].(string)))
+		}
+		_ = json.NewEncoder(w).Encode(&resp)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@bot:example.com", "token")
+	require.NoError(t, err)
+	return cli
+}
+
+func makeBatchEvents(bodies ...string) []*event.Event {
+	evts := make([]*event.Event, len(bodies))
+	for i, body := range bodies {
+		evts[i] = &event.Event{
+			Sender:    "@ghost:example.com",
+			Type:      event.EventMessage,
+			Timestamp: int64(i + 1),
+			Content:   event.Content{Raw: map[string]any{"msgtype": "m.text", "body": body}},
+		}
+	}
+	return evts
+}
+
+func TestClient_BeeperBatchSendChunked_Backward(t *testing.T) {
+	var requests []*mautrix.ReqBeeperBatchSend
+	cli := makeBatchSendServer(t, &requests)
+	resp, err := cli.BeeperBatchSendChunked(context.Background(), "!room:example.com", &mautrix.ReqBeeperBatchSend{
+		ForwardIfNoMessages: true,
+		MarkReadBy:          "@user:example.com",
+		Events:              makeBatchEvents("a", "b", "c", "d", "e"),
+	}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []id.EventID{"$a", "$b", "$c", "$d", "$e"}, resp.EventIDs)
+	require.Len(t, requests, 3)
+	// Backward backfilling must send the newest chunk first
+	assert.Len(t, requests[0].Events, 1)
+	assert.True(t, requests[0].ForwardIfNoMessages)
+	assert.Equal(t, id.UserID("@user:example.com"), requests[0].MarkReadBy)
+	assert.Equal(t, "c", requests[1].Events[0].Content.Raw["body"])
+	assert.False(t, requests[1].ForwardIfNoMessages)
+	assert.Empty(t, requests[1].MarkReadBy)
+	assert.Equal(t, id.UserID("@ghost:example.com"), requests[2].Events[0].Sender)
+	assert.Equal(t, int64(1), requests[2].Events[0].Timestamp)
+}
+
+func TestClient_BeeperBatchSendChunked_Forward(t *testing.T) {
+	var requests []*mautrix.ReqBeeperBatchSend
+	cli := makeBatchSendServer(t, &requests)
+	resp, err := cli.BeeperBatchSendChunked(context.Background(), "!room:example.com", &mautrix.ReqBeeperBatchSend{
+		Forward:    true,
+		MarkReadBy: "@user:example.com",
+		Events:     makeBatchEvents("a", "b", "c"),
+	}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []id.EventID{"$a", "$b", "$c"}, resp.EventIDs)
+	require.Len(t, requests, 2)
+	assert.Equal(t, "a", requests[0].Events[0].Content.Raw["body"])
+	assert.Empty(t, requests[0].MarkReadBy)
+	assert.Equal(t, id.UserID("@user:example.com"), requests[1].MarkReadBy)
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// ActiveBeacon is a live location share that is being tracked by a BeaconTracker.
+type ActiveBeacon struct {
+	RoomID   id.RoomID
+	Sender   id.UserID
+	StateKey string
+	// The event ID of the beacon_info state event. Location updates reference this event.
+	EventID id.EventID
+	Info    *event.BeaconInfoEventContent
+	// The most recent location update, or nil if no updates have been received yet.
+	LatestLocation *event.BeaconEventContent
+}
+
+// BeaconTracker keeps track of active live location beacons (MSC3672) in rooms.
+//
+// Use Register to receive events from a syncer, or call HandleEvent manually with beacon_info state events
+// and beacon message events. Expired beacons are removed when they're queried.
+type BeaconTracker struct {
+	rooms map[id.RoomID]map[string]*ActiveBeacon
+	lock  sync.Mutex
+}
+
+// NewBeaconTracker creates a new empty beacon tracker.
+func NewBeaconTracker() *BeaconTracker {
+	return &BeaconTracker{
+		rooms: make(map[id.RoomID]map[string]*ActiveBeacon),
+	}
+}
+
+// Register adds the tracker's event handler to the given syncer for beacon_info and beacon events.
+func (bt *BeaconTracker) Register(syncer ExtensibleSyncer) {
+	syncer.OnEventType(event.StateUnstableBeaconInfo, bt.HandleEvent)
+	syncer.OnEventType(event.EventUnstableBeacon, bt.HandleEvent)
+}
+
+// HandleEvent updates the tracker with a beacon_info state event or a beacon location update.
+// Other events are ignored.
+func (bt *BeaconTracker) HandleEvent(_ context.Context, evt *event.Event) {
+	_ = evt.Content.ParseRaw(evt.Type)
+	switch content := evt.Content.Parsed.(type) {
+	case *event.BeaconInfoEventContent:
+		if evt.StateKey != nil {
+			bt.updateInfo(evt, content)
+		}
+	case *event.BeaconEventContent:
+		bt.updateLocation(evt, content)
+	}
+}
+
+func (bt *BeaconTracker) updateInfo(evt *event.Event, content *event.BeaconInfoEventContent) {
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	room, ok := bt.rooms[evt.RoomID]
+	if !content.IsActive(time.Now()) {
+		if ok {
+			delete(room, *evt.StateKey)
+		}
+		return
+	} else if !ok {
+		room = make(map[string]*ActiveBeacon)
+		bt.rooms[evt.RoomID] = room
+	}
+	room[*evt.StateKey] = &ActiveBeacon{
+		RoomID:   evt.RoomID,
+		Sender:   evt.Sender,
+		StateKey: *evt.StateKey,
+		EventID:  evt.ID,
+		Info:     content,
+	}
+}
+
+func (bt *BeaconTracker) updateLocation(evt *event.Event, content *event.BeaconEventContent) {
+	infoID := content.BeaconInfoEventID()
+	if infoID == "" {
+		return
+	}
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	for _, beacon := range bt.rooms[evt.RoomID] {
+		if beacon.EventID != infoID {
+			continue
+		}
+		// Location updates can only be sent by the owner of the beacon
+		if beacon.Sender == evt.Sender && (beacon.LatestLocation == nil || beacon.LatestLocation.Timestamp <= content.Timestamp) {
+			beacon.LatestLocation = content
+		}
+		return
+	}
+}
+
+// ActiveBeacons returns the currently active beacons in the given room.
+func (bt *BeaconTracker) ActiveBeacons(roomID id.RoomID) []*ActiveBeacon {
+	now := time.Now()
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	room := bt.rooms[roomID]
+	beacons := make([]*ActiveBeacon, 0, len(room))
+	for stateKey, beacon := range room {
+		if !beacon.Info.IsActive(now) {
+			delete(room, stateKey)
+			continue
+		}
+		beacons = append(beacons, beacon)
+	}
+	return beacons
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestBeaconTracker(t *testing.T) {
+	ctx := context.Background()
+	tracker := mautrix.NewBeaconTracker()
+	now := time.Now().UnixMilli()
+	addInfo := func(sender id.UserID, eventID id.EventID, ts, timeout int64) {
+		stateKey := sender.String()
+		tracker.HandleEvent(ctx, &event.Event{
+			Type:     event.StateUnstableBeaconInfo,
+			RoomID:   "!room:example.com",
+			Sender:   sender,
+			StateKey: &stateKey,
+			ID:       eventID,
+			Content: event.Content{Parsed: &event.BeaconInfoEventContent{
+				Live:      true,
+				Timestamp: ts,
+				Timeout:   timeout,
+			}},
+		})
+	}
+	addInfo("@alice:example.com", "$alice", now, time.Hour.Milliseconds())
+	addInfo("@bob:example.com", "$bob", now-2*time.Hour.Milliseconds(), time.Hour.Milliseconds())
+
+	sendLocation := func(sender id.UserID, uri string, ts int64) {
+		tracker.HandleEvent(ctx, &event.Event{
+			Type:   event.EventUnstableBeacon,
+			RoomID: "!room:example.com",
+			Sender: sender,
+			Content: event.Content{Parsed: &event.BeaconEventContent{
+				RelatesTo: event.RelatesTo{Type: event.RelReference, EventID: "$alice"},
+				Location:  event.LocationBlock{URI: uri},
+				Timestamp: ts,
+			}},
+		})
+	}
+	sendLocation("@alice:example.com", "geo:1,2", now+2000)
+	sendLocation("@alice:example.com", "geo:0,0", now+1000)
+	sendLocation("@mallory:example.com", "geo:6,6", now+3000)
+
+	beacons := tracker.ActiveBeacons("!room:example.com")
+	require.Len(t, beacons, 1)
+	assert.Equal(t, id.UserID("@alice:example.com"), beacons[0].Sender)
+	require.NotNil(t, beacons[0].LatestLocation)
+	assert.Equal(t, "geo:1,2", beacons[0].LatestLocation.Location.URI)
+
+	stateKey := "@alice:example.com"
+	tracker.HandleEvent(ctx, &event.Event{
+		Type:     event.StateUnstableBeaconInfo,
+		RoomID:   "!room:example.com",
+		Sender:   "@alice:example.com",
+		StateKey: &stateKey,
+		Content:  event.Content{Parsed: &event.BeaconInfoEventContent{Live: false, Timestamp: now}},
+	})
+	assert.Empty(t, tracker.ActiveBeacons("!room:example.com"))
+}
@@ -51,6 +51,7 @@ var ignoreUnsupportedServer = flag.Make().LongKey("ignore-unsupported-server").U
 var wantHelp, _ = flag.MakeHelpFlag()
 
 var _ appservice.StateStore = (*sqlstatestore.SQLStateStore)(nil)
+var _ appservice.TransactionIDStore = (*sqlstatestore.SQLStateStore)(nil)
 
 type Portal interface {
 	IsEncrypted() bool
@@ -617,16 +618,21 @@ func (br *Bridge) init() {
 
 	br.ZLog.Debug().Msg("Initializing state store")
 	br.StateStore = sqlstatestore.NewSQLStateStore(br.DB, dbutil.ZeroLogger(br.ZLog.With().Str("db_section", "matrix_state").Logger()), true)
+	br.StateStore.AppServiceID = br.Config.AppService.ID
 
 	br.AS, err = appservice.CreateFull(appservice.CreateOpts{
 		Registration:     br.Config.AppService.GetRegistration(),
 		HomeserverDomain: br.Config.Homeserver.Domain,
 		HomeserverURL:    br.Config.Homeserver.Address,
 		HostConfig: appservice.HostConfig{
-			Hostname: br.Config.AppService.Hostname,
-			Port:     br.Config.AppService.Port,
+			Hostname:    br.Config.AppService.Hostname,
+			Port:        br.Config.AppService.Port,
+			TLSCert:     br.Config.AppService.TLSCert,
+			TLSKey:      br.Config.AppService.TLSKey,
+			TLSClientCA: br.Config.AppService.TLSClientCA,
 		},
-		StateStore: br.StateStore,
+		StateStore:         br.StateStore,
+		TransactionIDStore: br.StateStore,
 	})
 	if err != nil {
 		br.ZLog.WithLevel(zerolog.FatalLevel).Err(err).
@@ -634,6 +640,7 @@ func (br *Bridge) init() {
 		os.Exit(15)
 	}
 	br.AS.Log = *br.ZLog
+	br.AS.ExtraServerTokens = br.Config.AppService.ExtraHSTokens
 	br.AS.DoublePuppetValue = br.Name
 	br.AS.GetProfile = br.getProfile
 	br.Bot = br.AS.BotIntent()
@@ -822,12 +829,17 @@ func (br *Bridge) stop() {
 		br.AS.StopWebsocket(appservice.ErrWebsocketManualStop)
 		waitForWS = true
 	}
-	br.AS.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	err := br.AS.Stop(ctx)
+	cancel()
+	if err != nil {
+		br.ZLog.Warn().Err(err).Msg("Appservice didn't stop cleanly")
+	}
 	sendStopSignal(br.wsStopPinger)
 	sendStopSignal(br.wsShortCircuitReconnectBackoff)
 	br.EventProcessor.Stop()
 	br.Child.Stop()
-	err := br.DB.Close()
+	err = br.DB.Close()
 	if err != nil {
 		br.ZLog.Warn().Err(err).Msg("Error closing database")
 	}
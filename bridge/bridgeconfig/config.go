@@ -60,13 +60,18 @@ type AppserviceConfig struct {
 	Hostname string `yaml:"hostname"`
 	Port     uint16 `yaml:"port"`
 
+	TLSCert     string `yaml:"tls_cert"`
+	TLSKey      string `yaml:"tls_key"`
+	TLSClientCA string `yaml:"tls_client_ca"`
+
 	Database dbutil.Config `yaml:"database"`
 
 	ID  string        `yaml:"id"`
 	Bot BotUserConfig `yaml:"bot"`
 
-	ASToken string `yaml:"as_token"`
-	HSToken string `yaml:"hs_token"`
+	ASToken       string   `yaml:"as_token"`
+	HSToken       string   `yaml:"hs_token"`
+	ExtraHSTokens []string `yaml:"extra_hs_tokens"`
 
 	EphemeralEvents   bool `yaml:"ephemeral_events"`
 	AsyncTransactions bool `yaml:"async_transactions"`
@@ -91,10 +96,7 @@ func (config *BaseConfig) GenerateRegistration() *appservice.Registration {
 	config.AppService.copyToRegistration(registration)
 
 	registration.SenderLocalpart = random.String(32)
-	botRegex := regexp.MustCompile(fmt.Sprintf("^@%s:%s$",
-		regexp.QuoteMeta(config.AppService.Bot.Username),
-		regexp.QuoteMeta(config.Homeserver.Domain)))
-	registration.Namespaces.UserIDs.Register(botRegex, true)
+	registration.Namespaces.UserIDs.Register(appservice.ExactUserIDRegex(config.AppService.Bot.Username, config.Homeserver.Domain), true)
 	registration.Namespaces.UserIDs.Register(config.MakeUserIDRegex(".*"), true)
 
 	return registration
@@ -106,6 +108,10 @@ func (config *BaseConfig) MakeAppService() *appservice.AppService {
 	_ = as.SetHomeserverURL(config.Homeserver.Address)
 	as.Host.Hostname = config.AppService.Hostname
 	as.Host.Port = config.AppService.Port
+	as.Host.TLSCert = config.AppService.TLSCert
+	as.Host.TLSKey = config.AppService.TLSKey
+	as.Host.TLSClientCA = config.AppService.TLSClientCA
+	as.ExtraServerTokens = config.AppService.ExtraHSTokens
 	as.Registration = config.AppService.GetRegistration()
 	return as
 }
@@ -126,8 +132,7 @@ func (asc *AppserviceConfig) copyToRegistration(registration *appservice.Registr
 	registration.URL = asc.Address
 	falseVal := false
 	registration.RateLimited = &falseVal
-	registration.EphemeralEvents = asc.EphemeralEvents
-	registration.SoruEphemeralEvents = asc.EphemeralEvents
+	registration.SetEphemeralEvents(asc.EphemeralEvents)
 }
 
 type BotUserConfig struct {
@@ -241,6 +246,9 @@ func doUpgrade(helper up.Helper) {
 	helper.Copy(up.Str|up.Null, "appservice", "address")
 	helper.Copy(up.Str|up.Null, "appservice", "hostname")
 	helper.Copy(up.Int|up.Null, "appservice", "port")
+	helper.Copy(up.Str|up.Null, "appservice", "tls_cert")
+	helper.Copy(up.Str|up.Null, "appservice", "tls_key")
+	helper.Copy(up.Str|up.Null, "appservice", "tls_client_ca")
 	if dbType, ok := helper.Get(up.Str, "appservice", "database", "type"); ok && dbType == "sqlite3" {
 		helper.Set(up.Str, "sqlite3-fk-wal", "appservice", "database", "type")
 	} else {
@@ -259,6 +267,7 @@ func doUpgrade(helper up.Helper) {
 	helper.Copy(up.Bool, "appservice", "async_transactions")
 	helper.Copy(up.Str, "appservice", "as_token")
 	helper.Copy(up.Str, "appservice", "hs_token")
+	helper.Copy(up.List, "appservice", "extra_hs_tokens")
 
 	if helper.GetNode("logging", "writers") == nil && (helper.GetNode("logging", "print_level") != nil || helper.GetNode("logging", "file_name_format") != nil) {
 		_, _ = fmt.Fprintln(os.Stderr, "Migrating legacy log config")
@@ -8,6 +8,7 @@ package bridgev2
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"runtime/debug"
 	"time"
@@ -15,6 +16,7 @@ import (
 	"github.com/rs/zerolog"
 
 	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
 )
 
 const BackfillMinBackoffAfterRoomCreate = 1 * time.Minute
@@ -28,6 +30,39 @@ func (br *Bridge) WakeupBackfillQueue() {
 	}
 }
 
+// ErrBackfillQueueDisabled is returned by RequestBackfill if the backfill queue is not enabled.
+var ErrBackfillQueueDisabled = errors.New("backfill queue is not enabled")
+
+// RequestBackfill asks the backfill queue to fetch a batch of older messages in the portal as soon as possible.
+// This is meant to be called when a Matrix client paginates past the oldest bridged message in the room.
+//
+// Requested batches bypass the max_batches limit in the config, so each call allows at most one extra batch.
+// The return value is false if the portal has no backfill task, e.g. if the room hasn't been created yet.
+func (br *Bridge) RequestBackfill(ctx context.Context, portal *Portal) (bool, error) {
+	if !br.Config.Backfill.Queue.Enabled || !br.Config.Backfill.Enabled || !br.Matrix.GetCapabilities().BatchSending {
+		return false, ErrBackfillQueueDisabled
+	}
+	br.onDemandBackfillLock.Lock()
+	defer br.onDemandBackfillLock.Unlock()
+	found, err := br.DB.BackfillTask.Request(ctx, portal.PortalKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to update backfill task: %w", err)
+	} else if !found {
+		return false, nil
+	}
+	br.onDemandBackfill[portal.PortalKey] = struct{}{}
+	br.WakeupBackfillQueue()
+	return true, nil
+}
+
+func (br *Bridge) takeOnDemandBackfill(portalKey networkid.PortalKey) bool {
+	br.onDemandBackfillLock.Lock()
+	defer br.onDemandBackfillLock.Unlock()
+	_, requested := br.onDemandBackfill[portalKey]
+	delete(br.onDemandBackfill, portalKey)
+	return requested
+}
+
 func (br *Bridge) RunBackfillQueue() {
 	if !br.Config.Backfill.Queue.Enabled || !br.Config.Backfill.Enabled {
 		return
@@ -226,7 +261,8 @@ func (br *Bridge) actuallyDoBackfillTask(ctx context.Context, task *database.Bac
 	if ok {
 		maxBatches = limiterAPI.GetBackfillMaxBatchCount(ctx, portal, task)
 	}
-	if maxBatches < 0 || maxBatches > task.BatchCount {
+	onDemand := br.takeOnDemandBackfill(task.PortalKey)
+	if maxBatches < 0 || maxBatches > task.BatchCount || onDemand {
 		err = portal.DoBackwardsBackfill(ctx, login, task)
 		if err != nil {
 			return false, fmt.Errorf("failed to backfill: %w", err)
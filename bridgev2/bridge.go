@@ -37,6 +37,7 @@ type Bridge struct {
 	Config   *bridgeconfig.BridgeConfig
 
 	DisappearLoop *DisappearLoop
+	Metrics       *Metrics
 
 	usersByMXID    map[id.UserID]*User
 	userLoginsByID map[networkid.UserLoginID]*UserLogin
@@ -47,6 +48,19 @@ type Bridge struct {
 
 	wakeupBackfillQueue chan struct{}
 	stopBackfillQueue   chan struct{}
+	stopGhostSync       chan struct{}
+	wakeupOutgoingQueue chan struct{}
+	stopOutgoingQueue   chan struct{}
+
+	onDemandBackfill     map[networkid.PortalKey]struct{}
+	onDemandBackfillLock sync.Mutex
+
+	mediaTransfers     map[mediaTransferKey]*mediaTransfer
+	mediaTransfersLock sync.Mutex
+	stopMediaRetries   chan struct{}
+
+	matrixMessageMiddleware []MatrixMessageMiddleware
+	remoteMessageMiddleware []RemoteMessageMiddleware
 }
 
 func NewBridge(
@@ -66,6 +80,7 @@ func NewBridge(
 		Matrix:  matrix,
 		Network: network,
 		Config:  cfg,
+		Metrics: NewMetrics(),
 
 		usersByMXID:    make(map[id.UserID]*User),
 		userLoginsByID: make(map[networkid.UserLoginID]*UserLogin),
@@ -75,6 +90,12 @@ func NewBridge(
 
 		wakeupBackfillQueue: make(chan struct{}),
 		stopBackfillQueue:   make(chan struct{}),
+		stopGhostSync:       make(chan struct{}),
+		wakeupOutgoingQueue: make(chan struct{}, 1),
+		stopOutgoingQueue:   make(chan struct{}),
+		onDemandBackfill:    make(map[networkid.PortalKey]struct{}),
+		mediaTransfers:      make(map[mediaTransferKey]*mediaTransfer),
+		stopMediaRetries:    make(chan struct{}),
 	}
 	if br.Config == nil {
 		br.Config = &bridgeconfig.BridgeConfig{CommandPrefix: "!bridge"}
@@ -196,6 +217,8 @@ func (br *Bridge) StartLogins() error {
 		br.SendGlobalBridgeState(status.BridgeState{StateEvent: status.StateUnconfigured})
 	}
 	go br.RunBackfillQueue()
+	go br.RunGhostSync()
+	go br.RunOutgoingQueue()
 
 	br.Log.Info().Msg("Bridge started")
 	return nil
@@ -204,6 +227,10 @@ func (br *Bridge) StartLogins() error {
 func (br *Bridge) Stop() {
 	br.Log.Info().Msg("Shutting down bridge")
 	close(br.stopBackfillQueue)
+	close(br.stopGhostSync)
+	close(br.stopOutgoingQueue)
+	close(br.stopMediaRetries)
+	br.flushPendingReceipts()
 	br.Matrix.Stop()
 	br.cacheLock.Lock()
 	var wg sync.WaitGroup
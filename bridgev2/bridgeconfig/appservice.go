@@ -26,11 +26,16 @@ type AppserviceConfig struct {
 	Hostname      string `yaml:"hostname"`
 	Port          uint16 `yaml:"port"`
 
+	TLSCert     string `yaml:"tls_cert"`
+	TLSKey      string `yaml:"tls_key"`
+	TLSClientCA string `yaml:"tls_client_ca"`
+
 	ID  string        `yaml:"id"`
 	Bot BotUserConfig `yaml:"bot"`
 
-	ASToken string `yaml:"as_token"`
-	HSToken string `yaml:"hs_token"`
+	ASToken       string   `yaml:"as_token"`
+	HSToken       string   `yaml:"hs_token"`
+	ExtraHSTokens []string `yaml:"extra_hs_tokens"`
 
 	EphemeralEvents   bool `yaml:"ephemeral_events"`
 	AsyncTransactions bool `yaml:"async_transactions"`
@@ -75,8 +80,7 @@ func (asc *AppserviceConfig) copyToRegistration(registration *appservice.Registr
 	registration.URL = asc.Address
 	falseVal := false
 	registration.RateLimited = &falseVal
-	registration.EphemeralEvents = asc.EphemeralEvents
-	registration.SoruEphemeralEvents = asc.EphemeralEvents
+	registration.SetEphemeralEvents(asc.EphemeralEvents)
 }
 
 func (ec *EncryptionConfig) applyUnstableFlags(registration *appservice.Registration) {
@@ -93,10 +97,7 @@ func (config *Config) GenerateRegistration() *appservice.Registration {
 	config.Encryption.applyUnstableFlags(registration)
 
 	registration.SenderLocalpart = random.String(32)
-	botRegex := regexp.MustCompile(fmt.Sprintf("^@%s:%s$",
-		regexp.QuoteMeta(config.AppService.Bot.Username),
-		regexp.QuoteMeta(config.Homeserver.Domain)))
-	registration.Namespaces.UserIDs.Register(botRegex, true)
+	registration.Namespaces.UserIDs.Register(appservice.ExactUserIDRegex(config.AppService.Bot.Username, config.Homeserver.Domain), true)
 	registration.Namespaces.UserIDs.Register(config.MakeUserIDRegex(".*"), true)
 
 	return registration
@@ -108,6 +109,10 @@ func (config *Config) MakeAppService() *appservice.AppService {
 	_ = as.SetHomeserverURL(config.Homeserver.Address)
 	as.Host.Hostname = config.AppService.Hostname
 	as.Host.Port = config.AppService.Port
+	as.Host.TLSCert = config.AppService.TLSCert
+	as.Host.TLSKey = config.AppService.TLSKey
+	as.Host.TLSClientCA = config.AppService.TLSClientCA
+	as.ExtraServerTokens = config.AppService.ExtraHSTokens
 	as.Registration = config.AppService.GetRegistration()
 	config.Encryption.applyUnstableFlags(as.Registration)
 	return as
@@ -23,6 +23,7 @@ type Config struct {
 	AppService   AppserviceConfig   `yaml:"appservice"`
 	Matrix       MatrixConfig       `yaml:"matrix"`
 	Analytics    AnalyticsConfig    `yaml:"analytics"`
+	Metrics      MetricsConfig      `yaml:"metrics"`
 	Provisioning ProvisioningConfig `yaml:"provisioning"`
 	PublicMedia  PublicMediaConfig  `yaml:"public_media"`
 	DirectMedia  DirectMediaConfig  `yaml:"direct_media"`
@@ -58,21 +59,45 @@ type CleanupOnLogouts struct {
 }
 
 type BridgeConfig struct {
-	CommandPrefix           string           `yaml:"command_prefix"`
-	PersonalFilteringSpaces bool             `yaml:"personal_filtering_spaces"`
-	PrivateChatPortalMeta   bool             `yaml:"private_chat_portal_meta"`
-	AsyncEvents             bool             `yaml:"async_events"`
-	SplitPortals            bool             `yaml:"split_portals"`
-	ResendBridgeInfo        bool             `yaml:"resend_bridge_info"`
-	BridgeMatrixLeave       bool             `yaml:"bridge_matrix_leave"`
-	TagOnlyOnCreate         bool             `yaml:"tag_only_on_create"`
-	OnlyBridgeTags          []event.RoomTag  `yaml:"only_bridge_tags"`
-	MuteOnlyOnCreate        bool             `yaml:"mute_only_on_create"`
-	OutgoingMessageReID     bool             `yaml:"outgoing_message_re_id"`
-	CleanupOnLogout         CleanupOnLogouts `yaml:"cleanup_on_logout"`
-	Relay                   RelayConfig      `yaml:"relay"`
-	Permissions             PermissionConfig `yaml:"permissions"`
-	Backfill                BackfillConfig   `yaml:"backfill"`
+	CommandPrefix           string              `yaml:"command_prefix"`
+	PersonalFilteringSpaces bool                `yaml:"personal_filtering_spaces"`
+	PrivateChatPortalMeta   bool                `yaml:"private_chat_portal_meta"`
+	AsyncEvents             bool                `yaml:"async_events"`
+	SplitPortals            bool                `yaml:"split_portals"`
+	ResendBridgeInfo        bool                `yaml:"resend_bridge_info"`
+	BridgeMatrixLeave       bool                `yaml:"bridge_matrix_leave"`
+	BridgeStatusNotices     string              `yaml:"bridge_status_notices"`
+	TagOnlyOnCreate         bool                `yaml:"tag_only_on_create"`
+	OnlyBridgeTags          []event.RoomTag     `yaml:"only_bridge_tags"`
+	MuteOnlyOnCreate        bool                `yaml:"mute_only_on_create"`
+	OutgoingMessageReID     bool                `yaml:"outgoing_message_re_id"`
+	ReadReceipts            ReadReceiptConfig   `yaml:"read_receipts"`
+	GhostSync               GhostSyncConfig     `yaml:"ghost_sync"`
+	OutgoingQueue           OutgoingQueueConfig `yaml:"outgoing_queue"`
+	CleanupOnLogout         CleanupOnLogouts    `yaml:"cleanup_on_logout"`
+	Relay                   RelayConfig         `yaml:"relay"`
+	Permissions             PermissionConfig    `yaml:"permissions"`
+	Backfill                BackfillConfig      `yaml:"backfill"`
+}
+
+type ReadReceiptConfig struct {
+	MatrixDelay  int `yaml:"matrix_delay"`
+	RemoteDelay  int `yaml:"remote_delay"`
+	MaxBatchSize int `yaml:"max_batch_size"`
+}
+
+type GhostSyncConfig struct {
+	Enabled      bool `yaml:"enabled"`
+	Interval     int  `yaml:"interval"`
+	BatchSize    int  `yaml:"batch_size"`
+	RequestDelay int  `yaml:"request_delay"`
+}
+
+type OutgoingQueueConfig struct {
+	Enabled        bool `yaml:"enabled"`
+	MaxAttempts    int  `yaml:"max_attempts"`
+	InitialBackoff int  `yaml:"initial_backoff"`
+	MaxBackoff     int  `yaml:"max_backoff"`
 }
 
 type MatrixConfig struct {
@@ -90,6 +115,11 @@ type AnalyticsConfig struct {
 	UserID string `yaml:"user_id"`
 }
 
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Listen  string `yaml:"listen"`
+}
+
 type ProvisioningConfig struct {
 	Prefix         string `yaml:"prefix"`
 	SharedSecret   string `yaml:"shared_secret"`
@@ -31,9 +31,21 @@ func doUpgrade(helper up.Helper) {
 	helper.Copy(up.Bool, "bridge", "split_portals")
 	helper.Copy(up.Bool, "bridge", "resend_bridge_info")
 	helper.Copy(up.Bool, "bridge", "bridge_matrix_leave")
+	helper.Copy(up.Str, "bridge", "bridge_status_notices")
 	helper.Copy(up.Bool, "bridge", "tag_only_on_create")
 	helper.Copy(up.List, "bridge", "only_bridge_tags")
 	helper.Copy(up.Bool, "bridge", "mute_only_on_create")
+	helper.Copy(up.Int, "bridge", "read_receipts", "matrix_delay")
+	helper.Copy(up.Int, "bridge", "read_receipts", "remote_delay")
+	helper.Copy(up.Int, "bridge", "read_receipts", "max_batch_size")
+	helper.Copy(up.Bool, "bridge", "ghost_sync", "enabled")
+	helper.Copy(up.Int, "bridge", "ghost_sync", "interval")
+	helper.Copy(up.Int, "bridge", "ghost_sync", "batch_size")
+	helper.Copy(up.Int, "bridge", "ghost_sync", "request_delay")
+	helper.Copy(up.Bool, "bridge", "outgoing_queue", "enabled")
+	helper.Copy(up.Int, "bridge", "outgoing_queue", "max_attempts")
+	helper.Copy(up.Int, "bridge", "outgoing_queue", "initial_backoff")
+	helper.Copy(up.Int, "bridge", "outgoing_queue", "max_backoff")
 	helper.Copy(up.Bool, "bridge", "cleanup_on_logout", "enabled")
 	helper.Copy(up.Str, "bridge", "cleanup_on_logout", "manual", "private")
 	helper.Copy(up.Str, "bridge", "cleanup_on_logout", "manual", "relayed")
@@ -76,6 +88,9 @@ func doUpgrade(helper up.Helper) {
 	helper.Copy(up.Str|up.Null, "appservice", "public_address")
 	helper.Copy(up.Str|up.Null, "appservice", "hostname")
 	helper.Copy(up.Int|up.Null, "appservice", "port")
+	helper.Copy(up.Str|up.Null, "appservice", "tls_cert")
+	helper.Copy(up.Str|up.Null, "appservice", "tls_key")
+	helper.Copy(up.Str|up.Null, "appservice", "tls_client_ca")
 	helper.Copy(up.Str, "appservice", "id")
 	helper.Copy(up.Str, "appservice", "bot", "username")
 	helper.Copy(up.Str, "appservice", "bot", "displayname")
@@ -84,6 +99,7 @@ func doUpgrade(helper up.Helper) {
 	helper.Copy(up.Bool, "appservice", "async_transactions")
 	helper.Copy(up.Str, "appservice", "as_token")
 	helper.Copy(up.Str, "appservice", "hs_token")
+	helper.Copy(up.List, "appservice", "extra_hs_tokens")
 	helper.Copy(up.Str, "appservice", "username_template")
 
 	helper.Copy(up.Bool, "matrix", "message_status_events")
@@ -97,6 +113,9 @@ func doUpgrade(helper up.Helper) {
 	helper.Copy(up.Str|up.Null, "analytics", "url")
 	helper.Copy(up.Str|up.Null, "analytics", "user_id")
 
+	helper.Copy(up.Bool, "metrics", "enabled")
+	helper.Copy(up.Str, "metrics", "listen")
+
 	helper.Copy(up.Str, "provisioning", "prefix")
 	if secret, ok := helper.Get(up.Str, "provisioning", "shared_secret"); !ok || secret == "generate" {
 		sharedSecret := random.String(64)
@@ -179,6 +198,9 @@ func doUpgrade(helper up.Helper) {
 var SpacedBlocks = [][]string{
 	{"bridge"},
 	{"bridge", "bridge_matrix_leave"},
+	{"bridge", "read_receipts"},
+	{"bridge", "ghost_sync"},
+	{"bridge", "outgoing_queue"},
 	{"bridge", "cleanup_on_logout"},
 	{"bridge", "relay"},
 	{"bridge", "permissions"},
@@ -194,6 +216,7 @@ var SpacedBlocks = [][]string{
 	{"appservice", "username_template"},
 	{"matrix"},
 	{"analytics"},
+	{"metrics"},
 	{"provisioning"},
 	{"public_media"},
 	{"direct_media"},
@@ -8,30 +8,38 @@ package bridgev2
 
 import (
 	"context"
+	"fmt"
 	"runtime/debug"
 	"time"
 
 	"github.com/rs/zerolog"
 
 	"maunium.net/go/mautrix/bridge/status"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
 )
 
 type BridgeStateQueue struct {
 	prevUnsent *status.BridgeState
 	prevSent   *status.BridgeState
+	errorSent  bool
 	ch         chan status.BridgeState
 	bridge     *Bridge
-	user       status.StandaloneCustomBridgeStateFiller
+	login      *UserLogin
 }
 
 func (br *Bridge) SendGlobalBridgeState(state status.BridgeState) {
 	state = state.Fill(nil)
+	retryIn := 2
 	for {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		if err := br.Matrix.SendBridgeStatus(ctx, &state); err != nil {
-			br.Log.Warn().Err(err).Msg("Failed to update global bridge state")
+			br.Log.Warn().Err(err).
+				Int("retry_in_seconds", retryIn).
+				Msg("Failed to update global bridge state")
 			cancel()
-			time.Sleep(5 * time.Second)
+			time.Sleep(time.Duration(retryIn) * time.Second)
+			retryIn = min(retryIn*2, 64)
 			continue
 		} else {
 			br.Log.Debug().Any("bridge_state", state).Msg("Sent new global bridge state")
@@ -41,11 +49,11 @@ func (br *Bridge) SendGlobalBridgeState(state status.BridgeState) {
 	}
 }
 
-func (br *Bridge) NewBridgeStateQueue(user status.StandaloneCustomBridgeStateFiller) *BridgeStateQueue {
+func (br *Bridge) NewBridgeStateQueue(login *UserLogin) *BridgeStateQueue {
 	bsq := &BridgeStateQueue{
 		ch:     make(chan status.BridgeState, 10),
 		bridge: br,
-		user:   user,
+		login:  login,
 	}
 	go bsq.loop()
 	return bsq
@@ -70,15 +78,53 @@ func (bsq *BridgeStateQueue) loop() {
 	}
 }
 
+func (bsq *BridgeStateQueue) sendNotice(ctx context.Context, state status.BridgeState) {
+	noticeConfig := bsq.bridge.Config.BridgeStatusNotices
+	isError := state.StateEvent == status.StateBadCredentials || state.StateEvent == status.StateUnknownError
+	sendNotice := noticeConfig == "all" || (noticeConfig == "errors" &&
+		(isError || (bsq.errorSent && state.StateEvent == status.StateConnected)))
+	if !sendNotice {
+		return
+	}
+	// Management rooms aren't created just for state notices, users who haven't talked to the bot won't get them
+	managementRoom := bsq.login.User.ManagementRoom
+	if managementRoom == "" {
+		bsq.bridge.Log.Debug().
+			Str("state_event", string(state.StateEvent)).
+			Msg("Not sending bridge state notice as user doesn't have a management room")
+		return
+	}
+	message := fmt.Sprintf("State update for %s: `%s`", bsq.login.RemoteName, state.StateEvent)
+	if state.Error != "" {
+		message += fmt.Sprintf(" (`%s`)", state.Error)
+	}
+	if state.Message != "" {
+		message += fmt.Sprintf(": %s", state.Message)
+	}
+	content := format.RenderMarkdown(message, true, false)
+	content.MsgType = event.MsgNotice
+	_, err := bsq.bridge.Bot.SendMessage(ctx, managementRoom, event.EventMessage, &event.Content{Parsed: &content}, nil)
+	if err != nil {
+		bsq.bridge.Log.Err(err).Msg("Failed to send bridge state notice")
+	} else {
+		bsq.errorSent = isError
+	}
+}
+
 func (bsq *BridgeStateQueue) immediateSendBridgeState(state status.BridgeState) {
 	retryIn := 2
-	for {
+	for attempt := 1; ; attempt++ {
 		if bsq.prevSent != nil && bsq.prevSent.ShouldDeduplicate(&state) {
 			bsq.bridge.Log.Debug().
 				Str("state_event", string(state.StateEvent)).
 				Msg("Not sending bridge state as it's a duplicate")
 			return
 		}
+		if attempt == 1 {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			bsq.sendNotice(ctx, state)
+			cancel()
+		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		err := bsq.bridge.Matrix.SendBridgeStatus(ctx, &state)
@@ -108,7 +154,7 @@ func (bsq *BridgeStateQueue) Send(state status.BridgeState) {
 		return
 	}
 
-	state = state.Fill(bsq.user)
+	state = state.Fill(bsq.login)
 	bsq.prevUnsent = &state
 
 	if len(bsq.ch) >= 8 {
@@ -44,7 +44,7 @@ func NewProcessor(bridge *bridgev2.Bridge) bridgev2.CommandProcessor {
 		CommandHelp, CommandCancel,
 		CommandRegisterPush, CommandDeletePortal, CommandDeleteAllPortals,
 		CommandLogin, CommandRelogin, CommandListLogins, CommandLogout, CommandSetPreferredLogin,
-		CommandSetRelay, CommandUnsetRelay,
+		CommandSetRelay, CommandUnsetRelay, CommandRetry,
 		CommandResolveIdentifier, CommandStartChat, CommandSearch,
 		CommandSudo, CommandDoIn,
 	)
@@ -7,15 +7,10 @@
 package commands
 
 import (
-	"golang.org/x/exp/slices"
-
 	"maunium.net/go/mautrix/bridgev2"
 	"maunium.net/go/mautrix/bridgev2/networkid"
-	"maunium.net/go/mautrix/event"
 )
 
-var fakeEvtSetRelay = event.Type{Type: "fi.mau.bridge.set_relay", Class: event.StateEventType}
-
 var CommandSetRelay = &FullHandler{
 	Func: fnSetRelay,
 	Name: "set-relay",
@@ -77,16 +72,12 @@ func fnSetRelay(ce *Event) {
 		if relay == nil {
 			ce.Reply("User login with ID `%s` not found", ce.Args[0])
 			return
-		} else if slices.Contains(ce.Bridge.Config.Relay.DefaultRelays, relay.ID) {
-			// All good
-		} else if relay.UserMXID != ce.User.MXID && !ce.User.Permissions.Admin {
-			ce.Reply("Only bridge admins can set another user's login as the relay")
-			return
-		} else if onlySetDefaultRelays {
-			ce.Reply("You're not allowed to use yourself as relay")
-			return
 		}
 	}
+	if err := ce.Portal.CheckRelayLogin(ce.User, relay); err != nil {
+		ce.Reply("Can't use `%s` as the relay: %v", relay.ID, err)
+		return
+	}
 	err := ce.Portal.SetRelay(ce.Ctx, relay)
 	if err != nil {
 		ce.Log.Err(err).Msg("Failed to unset relay")
@@ -130,17 +121,5 @@ func fnUnsetRelay(ce *Event) {
 }
 
 func canManageRelay(ce *Event) bool {
-	return ce.User.Permissions.ManageRelay &&
-		(ce.User.Permissions.Admin ||
-			(ce.Portal.Relay != nil && ce.Portal.Relay.UserMXID == ce.User.MXID) ||
-			hasRelayRoomPermissions(ce))
-}
-
-func hasRelayRoomPermissions(ce *Event) bool {
-	levels, err := ce.Bridge.Matrix.GetPowerLevels(ce.Ctx, ce.RoomID)
-	if err != nil {
-		ce.Log.Err(err).Msg("Failed to check room power levels")
-		return false
-	}
-	return levels.GetUserLevel(ce.User.MXID) >= levels.GetEventLevel(fakeEvtSetRelay)
+	return ce.Portal.CanManageRelay(ce.Ctx, ce.User)
 }
@@ -0,0 +1,39 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package commands
+
+import (
+	"maunium.net/go/mautrix/id"
+)
+
+var CommandRetry = &FullHandler{
+	Func: fnRetry,
+	Name: "retry",
+	Help: HelpMeta{
+		Section:     HelpSectionChats,
+		Description: "Retry bridging a message that failed to be sent. The command must be sent as a reply to the failed message.",
+		Args:        "[_event ID_]",
+	},
+	RequiresPortal: true,
+}
+
+func fnRetry(ce *Event) {
+	target := ce.ReplyTo
+	if len(ce.Args) > 0 {
+		target = id.EventID(ce.Args[0])
+	}
+	if target == "" {
+		ce.Reply("Usage: reply to a failed message with `$cmdprefix retry`, or use `$cmdprefix retry <event ID>`")
+		return
+	}
+	err := ce.Portal.RetryMatrixEvent(ce.Ctx, ce.User, target)
+	if err != nil {
+		ce.Reply("Failed to retry message: %v", err)
+		return
+	}
+	ce.React("✅️")
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/bridgev2/networkid"
+)
+
+// MatrixMessageHandler sends a Matrix message to the remote network.
+type MatrixMessageHandler func(ctx context.Context, msg *MatrixMessage) (*MatrixMessageResponse, error)
+
+// MatrixMessageMiddleware wraps the handling of Matrix messages. The middleware may modify the message
+// before calling next, modify the response after calling it, or return an error without calling it at all.
+//
+// The middleware is also called for polls and edits. For polls, msg.Content is nil and the poll content
+// can be found in msg.Event. For edits, msg.Content is the new content of the message and next returns
+// an empty response.
+type MatrixMessageMiddleware func(ctx context.Context, msg *MatrixMessage, next MatrixMessageHandler) (*MatrixMessageResponse, error)
+
+// RemoteMessageConverter converts a remote message into Matrix events.
+type RemoteMessageConverter func(ctx context.Context, portal *Portal, intent MatrixAPI) (*ConvertedMessage, error)
+
+// RemoteMessageMiddleware wraps the conversion of remote messages. The middleware may modify the converted
+// message returned by next, or return [ErrIgnoringRemoteEvent] to drop the message entirely.
+//
+// The middleware is also called for edits. In that case, next returns the modified and added parts of the edit
+// in the same message, and dropping the message will cause the edit to be ignored.
+type RemoteMessageMiddleware func(ctx context.Context, portal *Portal, intent MatrixAPI, next RemoteMessageConverter) (*ConvertedMessage, error)
+
+// AddMatrixMessageMiddleware registers middleware that will be called for every Matrix message
+// that is sent to the remote network. Middleware is called in the order it was registered,
+// i.e. the first registered middleware is the outermost one.
+//
+// This must be called before the bridge is started.
+func (br *Bridge) AddMatrixMessageMiddleware(middleware ...MatrixMessageMiddleware) {
+	br.matrixMessageMiddleware = append(br.matrixMessageMiddleware, middleware...)
+}
+
+// AddRemoteMessageMiddleware registers middleware that will be called for every remote message
+// (including backfilled messages) that is bridged to Matrix. Middleware is called in the order it was registered,
+// i.e. the first registered middleware is the outermost one.
+//
+// This must be called before the bridge is started.
+func (br *Bridge) AddRemoteMessageMiddleware(middleware ...RemoteMessageMiddleware) {
+	br.remoteMessageMiddleware = append(br.remoteMessageMiddleware, middleware...)
+}
+
+func (br *Bridge) wrapMatrixMessageHandler(handler MatrixMessageHandler) MatrixMessageHandler {
+	for i := len(br.matrixMessageMiddleware) - 1; i >= 0; i-- {
+		middleware, next := br.matrixMessageMiddleware[i], handler
+		handler = func(ctx context.Context, msg *MatrixMessage) (*MatrixMessageResponse, error) {
+			return middleware(ctx, msg, next)
+		}
+	}
+	return handler
+}
+
+func (br *Bridge) wrapRemoteMessageConverter(converter RemoteMessageConverter) RemoteMessageConverter {
+	for i := len(br.remoteMessageMiddleware) - 1; i >= 0; i-- {
+		middleware, next := br.remoteMessageMiddleware[i], converter
+		converter = func(ctx context.Context, portal *Portal, intent MatrixAPI) (*ConvertedMessage, error) {
+			return middleware(ctx, portal, intent, next)
+		}
+	}
+	return converter
+}
+
+func (br *Bridge) handleMatrixEditWithMiddleware(ctx context.Context, api EditHandlingNetworkAPI, edit *MatrixEdit) error {
+	if len(br.matrixMessageMiddleware) == 0 {
+		return api.HandleMatrixEdit(ctx, edit)
+	}
+	_, err := br.wrapMatrixMessageHandler(func(ctx context.Context, msg *MatrixMessage) (*MatrixMessageResponse, error) {
+		edit.MatrixEventBase = msg.MatrixEventBase
+		return &MatrixMessageResponse{}, api.HandleMatrixEdit(ctx, edit)
+	})(ctx, &MatrixMessage{MatrixEventBase: edit.MatrixEventBase})
+	return err
+}
+
+// convertRemoteEditWithMiddleware passes the parts of a converted remote edit through the remote message middleware.
+// Parts are matched back to the edit by their part IDs, modified parts that the middleware removed won't be bridged.
+func (br *Bridge) convertRemoteEditWithMiddleware(
+	ctx context.Context, portal *Portal, intent MatrixAPI, convert func(context.Context) (*ConvertedEdit, error),
+) (*ConvertedEdit, error) {
+	if len(br.remoteMessageMiddleware) == 0 {
+		return convert(ctx)
+	}
+	var edit *ConvertedEdit
+	converted, err := br.wrapRemoteMessageConverter(func(ctx context.Context, portal *Portal, intent MatrixAPI) (*ConvertedMessage, error) {
+		var err error
+		edit, err = convert(ctx)
+		if err != nil {
+			return nil, err
+		}
+		msg := &ConvertedMessage{}
+		if edit.AddedParts != nil {
+			*msg = *edit.AddedParts
+		}
+		msg.Parts = make([]*ConvertedMessagePart, 0, len(edit.ModifiedParts))
+		for _, part := range edit.ModifiedParts {
+			msg.Parts = append(msg.Parts, &ConvertedMessagePart{
+				ID:         part.Part.PartID,
+				Type:       part.Type,
+				Content:    part.Content,
+				Extra:      part.Extra,
+				DontBridge: part.DontBridge,
+			})
+		}
+		if edit.AddedParts != nil {
+			msg.Parts = append(msg.Parts, edit.AddedParts.Parts...)
+		}
+		return msg, nil
+	})(ctx, portal, intent)
+	if err != nil {
+		return nil, err
+	}
+	partsByID := make(map[networkid.PartID]*ConvertedMessagePart, len(converted.Parts))
+	for _, part := range converted.Parts {
+		partsByID[part.ID] = part
+	}
+	for _, part := range edit.ModifiedParts {
+		newPart, ok := partsByID[part.Part.PartID]
+		if !ok {
+			part.DontBridge = true
+			continue
+		}
+		delete(partsByID, part.Part.PartID)
+		part.Type = newPart.Type
+		part.Content = newPart.Content
+		part.Extra = newPart.Extra
+		part.DontBridge = newPart.DontBridge
+	}
+	if edit.AddedParts != nil {
+		edit.AddedParts.Parts = slices.DeleteFunc(converted.Parts, func(part *ConvertedMessagePart) bool {
+			_, isAdded := partsByID[part.ID]
+			return !isAdded
+		})
+	}
+	return edit, nil
+}
+
+// applyBackfillMiddleware passes already converted backfill messages through the remote message middleware.
+// Messages that the middleware rejects are removed from the returned list.
+func (portal *Portal) applyBackfillMiddleware(ctx context.Context, source *UserLogin, messages []*BackfillMessage) []*BackfillMessage {
+	if len(portal.Bridge.remoteMessageMiddleware) == 0 {
+		return messages
+	}
+	filtered := make([]*BackfillMessage, 0, len(messages))
+	for _, msg := range messages {
+		intent := portal.GetIntentFor(ctx, msg.Sender, source, RemoteEventMessage)
+		converted, err := portal.Bridge.wrapRemoteMessageConverter(msg.ConvertMessage)(ctx, portal, intent)
+		if err != nil {
+			if !errors.Is(err, ErrIgnoringRemoteEvent) {
+				zerolog.Ctx(ctx).Err(err).
+					Str("message_id", string(msg.ID)).
+					Msg("Remote message middleware failed for backfill message")
+			}
+			continue
+		}
+		msg.ConvertedMessage = converted
+		filtered = append(filtered, msg)
+	}
+	return filtered
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+)
+
+// CustomEmoji is the information about a remote custom emoji returned by CustomEmojiHandlingNetworkAPI.
+type CustomEmoji struct {
+	ID networkid.EmojiID
+	// The short name of the emoji without colons. This is used as the fallback reaction key
+	// if the image can't be reuploaded.
+	Shortcode string
+	// If true, the emoji is available in all chats and the mapping will be shared by all portals.
+	Global bool
+	// A function to download the emoji image. If nil, the shortcode will be used as the reaction key.
+	Download MediaDownloadFunc
+}
+
+// CustomEmojiHandlingNetworkAPI is an optional interface that network connectors can implement
+// to have the bridge map remote custom emojis to Matrix reaction keys.
+//
+// The bridge calls GetCustomEmoji when a remote reaction has an emoji ID, but an empty emoji string.
+// The emoji image is reuploaded to Matrix and the resulting mxc:// URI is used as the reaction key.
+// Mappings are stored in the database, so GetCustomEmoji is only called once for each emoji.
+type CustomEmojiHandlingNetworkAPI interface {
+	NetworkAPI
+	GetCustomEmoji(ctx context.Context, portal *Portal, emojiID networkid.EmojiID) (*CustomEmoji, error)
+}
+
+// GetCustomEmojiMapping finds the stored mapping for the given remote custom emoji in this portal.
+func (portal *Portal) GetCustomEmojiMapping(ctx context.Context, emojiID networkid.EmojiID) (*database.CustomEmoji, error) {
+	return portal.Bridge.DB.CustomEmoji.GetByID(ctx, portal.PortalKey, emojiID)
+}
+
+// GetCustomEmojiByReactionKey finds the remote custom emoji that was previously mapped to the given Matrix reaction key.
+func (portal *Portal) GetCustomEmojiByReactionKey(ctx context.Context, key string) (*database.CustomEmoji, error) {
+	return portal.Bridge.DB.CustomEmoji.GetByMatrixKey(ctx, portal.PortalKey, key)
+}
+
+func shortcodeReactionKey(shortcode string) string {
+	return fmt.Sprintf(":%s:", strings.Trim(shortcode, ":"))
+}
+
+// getReactionKey returns the Matrix reaction key for a remote reaction.
+// Custom emojis are resolved using resolveRemoteEmoji if the network connector didn't provide an emoji string.
+func (portal *Portal) getReactionKey(ctx context.Context, source *UserLogin, emoji string, emojiID networkid.EmojiID) string {
+	if emoji != "" || emojiID == "" {
+		return emoji
+	}
+	return portal.resolveRemoteEmoji(ctx, source, emojiID)
+}
+
+// resolveRemoteEmoji converts a remote custom emoji into a Matrix reaction key.
+//
+// The fallback order is: an existing mapping in the database, a reuploaded image,
+// the shortcode of the emoji and finally the emoji ID itself.
+func (portal *Portal) resolveRemoteEmoji(ctx context.Context, source *UserLogin, emojiID networkid.EmojiID) string {
+	log := zerolog.Ctx(ctx).With().Str("emoji_id", string(emojiID)).Logger()
+	existing, err := portal.GetCustomEmojiMapping(ctx, emojiID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get custom emoji mapping from database")
+	} else if existing != nil {
+		return existing.MatrixKey
+	}
+	emojiAPI, ok := source.Client.(CustomEmojiHandlingNetworkAPI)
+	if !ok {
+		return string(emojiID)
+	}
+	info, err := emojiAPI.GetCustomEmoji(ctx, portal, emojiID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get custom emoji info")
+		return string(emojiID)
+	} else if info == nil {
+		return string(emojiID)
+	}
+	mapping := &database.CustomEmoji{
+		EmojiID:   emojiID,
+		Shortcode: info.Shortcode,
+	}
+	if !info.Global {
+		mapping.Room = portal.PortalKey
+	}
+	if info.Download != nil {
+		// Reaction keys are visible in unencrypted form to the server anyway, so always upload the image unencrypted
+		media, err := portal.Bridge.ReuploadMedia(ctx, portal.Bridge.Bot, "", networkid.RemoteMediaID("emoji:"+emojiID), info.Download)
+		if err != nil {
+			log.Err(err).Msg("Failed to reupload custom emoji")
+		} else {
+			mapping.MatrixKey = string(media.MXC)
+		}
+	}
+	if mapping.MatrixKey == "" {
+		if info.Shortcode == "" {
+			return string(emojiID)
+		}
+		mapping.MatrixKey = shortcodeReactionKey(info.Shortcode)
+	}
+	err = portal.Bridge.DB.CustomEmoji.Put(ctx, mapping)
+	if err != nil {
+		log.Err(err).Msg("Failed to save custom emoji mapping")
+	}
+	return mapping.MatrixKey
+}
@@ -86,6 +86,10 @@ const (
 		WHERE bridge_id = $1 AND next_dispatch_min_ts < $2 AND is_done = false AND user_login_id <> ''
 		ORDER BY next_dispatch_min_ts LIMIT 1
 	`
+	requestBackfillQuery = `
+		UPDATE backfill_task SET is_done=false, next_dispatch_min_ts=0
+		WHERE bridge_id = $1 AND portal_id = $2 AND portal_receiver = $3 AND user_login_id <> ''
+	`
 	deleteBackfillQueueQuery = `
 		DELETE FROM backfill_task
 		WHERE bridge_id = $1 AND portal_id = $2 AND portal_receiver = $3
@@ -124,6 +128,17 @@ func (btq *BackfillTaskQuery) GetNext(ctx context.Context) (*BackfillTask, error
 	return btq.QueryOne(ctx, getNextBackfillQuery, btq.BridgeID, time.Now().UnixNano())
 }
 
+// Request marks the backfill task of the given portal as not done and moves it to the front of the queue.
+// It returns false if the portal doesn't have a backfill task with a user login.
+func (btq *BackfillTaskQuery) Request(ctx context.Context, portalKey networkid.PortalKey) (bool, error) {
+	res, err := btq.GetDB().Exec(ctx, requestBackfillQuery, btq.BridgeID, portalKey.ID, portalKey.Receiver)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
 func (btq *BackfillTaskQuery) Delete(ctx context.Context, portalKey networkid.PortalKey) error {
 	return btq.Exec(ctx, deleteBackfillQueueQuery, btq.BridgeID, portalKey.ID, portalKey.Receiver)
 }
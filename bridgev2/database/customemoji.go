@@ -0,0 +1,89 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+
+	"go.mau.fi/util/dbutil"
+
+	"maunium.net/go/mautrix/bridgev2/networkid"
+)
+
+type CustomEmojiQuery struct {
+	BridgeID networkid.BridgeID
+	*dbutil.QueryHelper[*CustomEmoji]
+}
+
+// CustomEmoji is a mapping between a custom emoji on the remote network and a Matrix reaction key.
+//
+// If Room is empty, the mapping applies to all portals.
+type CustomEmoji struct {
+	BridgeID  networkid.BridgeID
+	Room      networkid.PortalKey
+	EmojiID   networkid.EmojiID
+	MatrixKey string
+	Shortcode string
+}
+
+const (
+	getCustomEmojiBaseQuery = `
+		SELECT bridge_id, room_id, room_receiver, emoji_id, matrix_key, shortcode FROM custom_emoji
+	`
+	// Portal-specific mappings are preferred over global ones, which have an empty room ID and therefore sort last.
+	getCustomEmojiByIDQuery = getCustomEmojiBaseQuery + `
+		WHERE bridge_id=$1 AND ((room_id=$2 AND room_receiver=$3) OR (room_id='' AND room_receiver='')) AND emoji_id=$4
+		ORDER BY room_id DESC LIMIT 1
+	`
+	getCustomEmojiByMatrixKeyQuery = getCustomEmojiBaseQuery + `
+		WHERE bridge_id=$1 AND ((room_id=$2 AND room_receiver=$3) OR (room_id='' AND room_receiver='')) AND matrix_key=$4
+		ORDER BY room_id DESC LIMIT 1
+	`
+	getAllCustomEmojisInRoomQuery = getCustomEmojiBaseQuery + `WHERE bridge_id=$1 AND room_id=$2 AND room_receiver=$3`
+	upsertCustomEmojiQuery        = `
+		INSERT INTO custom_emoji (bridge_id, room_id, room_receiver, emoji_id, matrix_key, shortcode)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (bridge_id, room_id, room_receiver, emoji_id) DO UPDATE
+			SET matrix_key=excluded.matrix_key, shortcode=excluded.shortcode
+	`
+	deleteCustomEmojiQuery = `
+		DELETE FROM custom_emoji WHERE bridge_id=$1 AND room_id=$2 AND room_receiver=$3 AND emoji_id=$4
+	`
+)
+
+func (ceq *CustomEmojiQuery) GetByID(ctx context.Context, room networkid.PortalKey, emojiID networkid.EmojiID) (*CustomEmoji, error) {
+	return ceq.QueryOne(ctx, getCustomEmojiByIDQuery, ceq.BridgeID, room.ID, room.Receiver, emojiID)
+}
+
+func (ceq *CustomEmojiQuery) GetByMatrixKey(ctx context.Context, room networkid.PortalKey, key string) (*CustomEmoji, error) {
+	return ceq.QueryOne(ctx, getCustomEmojiByMatrixKeyQuery, ceq.BridgeID, room.ID, room.Receiver, key)
+}
+
+func (ceq *CustomEmojiQuery) GetAllInRoom(ctx context.Context, room networkid.PortalKey) ([]*CustomEmoji, error) {
+	return ceq.QueryMany(ctx, getAllCustomEmojisInRoomQuery, ceq.BridgeID, room.ID, room.Receiver)
+}
+
+func (ceq *CustomEmojiQuery) Put(ctx context.Context, emoji *CustomEmoji) error {
+	ensureBridgeIDMatches(&emoji.BridgeID, ceq.BridgeID)
+	return ceq.Exec(ctx, upsertCustomEmojiQuery, emoji.sqlVariables()...)
+}
+
+func (ceq *CustomEmojiQuery) Delete(ctx context.Context, room networkid.PortalKey, emojiID networkid.EmojiID) error {
+	return ceq.Exec(ctx, deleteCustomEmojiQuery, ceq.BridgeID, room.ID, room.Receiver, emojiID)
+}
+
+func (ce *CustomEmoji) Scan(row dbutil.Scannable) (*CustomEmoji, error) {
+	err := row.Scan(&ce.BridgeID, &ce.Room.ID, &ce.Room.Receiver, &ce.EmojiID, &ce.MatrixKey, &ce.Shortcode)
+	if err != nil {
+		return nil, err
+	}
+	return ce, nil
+}
+
+func (ce *CustomEmoji) sqlVariables() []any {
+	return []any{ce.BridgeID, ce.Room.ID, ce.Room.Receiver, ce.EmojiID, ce.MatrixKey, ce.Shortcode}
+}
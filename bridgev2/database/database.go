@@ -33,7 +33,13 @@ type Database struct {
 	UserLogin           *UserLoginQuery
 	UserPortal          *UserPortalQuery
 	BackfillTask        *BackfillTaskQuery
+	Media               *MediaQuery
+	CustomEmoji         *CustomEmojiQuery
+	OutgoingQueue       *OutgoingQueueQuery
+	FailedEvent         *FailedEventQuery
 	KV                  *KVQuery
+
+	eventCipher *storedEventCipher
 }
 
 type MetaMerger interface {
@@ -75,9 +81,11 @@ func New(bridgeID networkid.BridgeID, mt MetaTypes, db *dbutil.Database) *Databa
 		mt.UserLogin = blankMetaCreator
 	}
 	db.UpgradeTable = upgrades.Table
+	eventCipher := &storedEventCipher{}
 	return &Database{
-		Database: db,
-		BridgeID: bridgeID,
+		Database:    db,
+		BridgeID:    bridgeID,
+		eventCipher: eventCipher,
 		Portal: &PortalQuery{
 			BridgeID: bridgeID,
 			MetaType: mt.Portal,
@@ -137,6 +145,32 @@ func New(bridgeID networkid.BridgeID, mt MetaTypes, db *dbutil.Database) *Databa
 				return &BackfillTask{}
 			}),
 		},
+		Media: &MediaQuery{
+			BridgeID: bridgeID,
+			QueryHelper: dbutil.MakeQueryHelper(db, func(_ *dbutil.QueryHelper[*Media]) *Media {
+				return &Media{}
+			}),
+		},
+		CustomEmoji: &CustomEmojiQuery{
+			BridgeID: bridgeID,
+			QueryHelper: dbutil.MakeQueryHelper(db, func(_ *dbutil.QueryHelper[*CustomEmoji]) *CustomEmoji {
+				return &CustomEmoji{}
+			}),
+		},
+		OutgoingQueue: &OutgoingQueueQuery{
+			BridgeID: bridgeID,
+			QueryHelper: dbutil.MakeQueryHelper(db, func(_ *dbutil.QueryHelper[*OutgoingQueueEntry]) *OutgoingQueueEntry {
+				return &OutgoingQueueEntry{cipher: eventCipher}
+			}),
+			cipher: eventCipher,
+		},
+		FailedEvent: &FailedEventQuery{
+			BridgeID: bridgeID,
+			QueryHelper: dbutil.MakeQueryHelper(db, func(_ *dbutil.QueryHelper[*FailedEvent]) *FailedEvent {
+				return &FailedEvent{cipher: eventCipher}
+			}),
+			cipher: eventCipher,
+		},
 		KV: &KVQuery{
 			BridgeID: bridgeID,
 			Database: db,
@@ -0,0 +1,105 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type FailedEventQuery struct {
+	BridgeID networkid.BridgeID
+	*dbutil.QueryHelper[*FailedEvent]
+	cipher *storedEventCipher
+}
+
+// FailedEvent is a Matrix event that failed to be bridged with a retriable error and can be retried by the user.
+type FailedEvent struct {
+	BridgeID   networkid.BridgeID
+	EventID    id.EventID
+	Room       networkid.PortalKey
+	Event      *event.Event
+	RetryCount int
+	FailedAt   time.Time
+
+	cipher *storedEventCipher
+}
+
+const (
+	getFailedEventQuery = `
+		SELECT bridge_id, event_id, room_id, room_receiver, event, retry_count, failed_at
+		FROM failed_event
+		WHERE bridge_id=$1 AND event_id=$2
+	`
+	upsertFailedEventQuery = `
+		INSERT INTO failed_event (bridge_id, event_id, room_id, room_receiver, event, retry_count, failed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (bridge_id, event_id) DO UPDATE
+			SET retry_count=excluded.retry_count, failed_at=excluded.failed_at
+	`
+	deleteFailedEventQuery     = `DELETE FROM failed_event WHERE bridge_id=$1 AND event_id=$2 RETURNING retry_count`
+	deleteOldFailedEventsQuery = `DELETE FROM failed_event WHERE bridge_id=$1 AND failed_at<$2`
+)
+
+func (feq *FailedEventQuery) Get(ctx context.Context, eventID id.EventID) (*FailedEvent, error) {
+	return feq.QueryOne(ctx, getFailedEventQuery, feq.BridgeID, eventID)
+}
+
+func (feq *FailedEventQuery) Put(ctx context.Context, fe *FailedEvent) error {
+	ensureBridgeIDMatches(&fe.BridgeID, feq.BridgeID)
+	storedEvent, err := feq.cipher.encrypt(fe.Event)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt event: %w", err)
+	}
+	return feq.Exec(ctx, upsertFailedEventQuery, fe.sqlVariables(storedEvent)...)
+}
+
+// Delete deletes the given failed event and returns the number of times it was retried.
+// If the event isn't in the database, the returned retry count is zero.
+func (feq *FailedEventQuery) Delete(ctx context.Context, eventID id.EventID) (retryCount int, err error) {
+	err = feq.GetDB().QueryRow(ctx, deleteFailedEventQuery, feq.BridgeID, eventID).Scan(&retryCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	return
+}
+
+// DeleteOlderThan deletes all failed events that failed before the given time.
+func (feq *FailedEventQuery) DeleteOlderThan(ctx context.Context, ts time.Time) error {
+	return feq.Exec(ctx, deleteOldFailedEventsQuery, feq.BridgeID, ts.UnixNano())
+}
+
+func (fe *FailedEvent) Scan(row dbutil.Scannable) (*FailedEvent, error) {
+	var failedAt int64
+	var storedEvent []byte
+	err := row.Scan(
+		&fe.BridgeID, &fe.EventID, &fe.Room.ID, &fe.Room.Receiver, &storedEvent, &fe.RetryCount, &failedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	fe.FailedAt = time.Unix(0, failedAt)
+	// Events that can't be decrypted are left nil, which makes them impossible to retry
+	fe.Event, _ = fe.cipher.decrypt(storedEvent)
+	return fe, nil
+}
+
+func (fe *FailedEvent) sqlVariables(storedEvent json.RawMessage) []any {
+	return []any{
+		fe.BridgeID, fe.EventID, fe.Room.ID, fe.Room.Receiver, string(storedEvent), fe.RetryCount, fe.FailedAt.UnixNano(),
+	}
+}
@@ -9,6 +9,7 @@ package database
 import (
 	"context"
 	"encoding/hex"
+	"time"
 
 	"go.mau.fi/util/dbutil"
 
@@ -36,28 +37,32 @@ type Ghost struct {
 	IsBot          bool
 	Identifiers    []string
 	Metadata       any
+	InfoSyncedAt   time.Time
 }
 
 const (
 	getGhostBaseQuery = `
 		SELECT bridge_id, id, name, avatar_id, avatar_hash, avatar_mxc,
-		       name_set, avatar_set, contact_info_set, is_bot, identifiers, metadata
+		       name_set, avatar_set, contact_info_set, is_bot, identifiers, metadata, info_synced_at
 		FROM ghost
 	`
 	getGhostByIDQuery       = getGhostBaseQuery + `WHERE bridge_id=$1 AND id=$2`
 	getGhostByMetadataQuery = getGhostBaseQuery + `WHERE bridge_id=$1 AND metadata->>$2=$3`
+	getGhostsToSyncQuery    = getGhostBaseQuery + `WHERE bridge_id=$1 AND info_synced_at<$2 ORDER BY info_synced_at LIMIT $3`
 	insertGhostQuery        = `
 		INSERT INTO ghost (
 			bridge_id, id, name, avatar_id, avatar_hash, avatar_mxc,
-			name_set, avatar_set, contact_info_set, is_bot, identifiers, metadata
+			name_set, avatar_set, contact_info_set, is_bot, identifiers, metadata, info_synced_at
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 	`
 	updateGhostQuery = `
 		UPDATE ghost SET name=$3, avatar_id=$4, avatar_hash=$5, avatar_mxc=$6,
-		                 name_set=$7, avatar_set=$8, contact_info_set=$9, is_bot=$10, identifiers=$11, metadata=$12
+		                 name_set=$7, avatar_set=$8, contact_info_set=$9, is_bot=$10, identifiers=$11, metadata=$12,
+		                 info_synced_at=$13
 		WHERE bridge_id=$1 AND id=$2
 	`
+	updateGhostInfoSyncedAtQuery = `UPDATE ghost SET info_synced_at=$3 WHERE bridge_id=$1 AND id=$2`
 )
 
 func (gq *GhostQuery) GetByID(ctx context.Context, id networkid.UserID) (*Ghost, error) {
@@ -70,6 +75,11 @@ func (gq *GhostQuery) GetByMetadata(ctx context.Context, key string, value any)
 	return gq.QueryMany(ctx, getGhostByMetadataQuery, gq.BridgeID, key, value)
 }
 
+// GetNextToSync returns the ghosts whose info was last synced before the given time, least recently synced first.
+func (gq *GhostQuery) GetNextToSync(ctx context.Context, syncedBefore time.Time, limit int) ([]*Ghost, error) {
+	return gq.QueryMany(ctx, getGhostsToSyncQuery, gq.BridgeID, syncedBefore.UnixNano(), limit)
+}
+
 func (gq *GhostQuery) Insert(ctx context.Context, ghost *Ghost) error {
 	ensureBridgeIDMatches(&ghost.BridgeID, gq.BridgeID)
 	return gq.Exec(ctx, insertGhostQuery, ghost.ensureHasMetadata(gq.MetaType).sqlVariables()...)
@@ -80,17 +90,26 @@ func (gq *GhostQuery) Update(ctx context.Context, ghost *Ghost) error {
 	return gq.Exec(ctx, updateGhostQuery, ghost.ensureHasMetadata(gq.MetaType).sqlVariables()...)
 }
 
+// UpdateInfoSyncedAt only updates the info sync timestamp of the given ghost.
+func (gq *GhostQuery) UpdateInfoSyncedAt(ctx context.Context, id networkid.UserID, ts time.Time) error {
+	return gq.Exec(ctx, updateGhostInfoSyncedAtQuery, gq.BridgeID, id, ts.UnixNano())
+}
+
 func (g *Ghost) Scan(row dbutil.Scannable) (*Ghost, error) {
 	var avatarHash string
+	var infoSyncedAt int64
 	err := row.Scan(
 		&g.BridgeID, &g.ID,
 		&g.Name, &g.AvatarID, &avatarHash, &g.AvatarMXC,
 		&g.NameSet, &g.AvatarSet, &g.ContactInfoSet, &g.IsBot,
-		dbutil.JSON{Data: &g.Identifiers}, dbutil.JSON{Data: g.Metadata},
+		dbutil.JSON{Data: &g.Identifiers}, dbutil.JSON{Data: g.Metadata}, &infoSyncedAt,
 	)
 	if err != nil {
 		return nil, err
 	}
+	if infoSyncedAt != 0 {
+		g.InfoSyncedAt = time.Unix(0, infoSyncedAt)
+	}
 	if avatarHash != "" {
 		data, _ := hex.DecodeString(avatarHash)
 		if len(data) == 32 {
@@ -112,10 +131,14 @@ func (g *Ghost) sqlVariables() []any {
 	if g.AvatarHash != [32]byte{} {
 		avatarHash = hex.EncodeToString(g.AvatarHash[:])
 	}
+	var infoSyncedAt int64
+	if !g.InfoSyncedAt.IsZero() {
+		infoSyncedAt = g.InfoSyncedAt.UnixNano()
+	}
 	return []any{
 		g.BridgeID, g.ID,
 		g.Name, g.AvatarID, avatarHash, g.AvatarMXC,
 		g.NameSet, g.AvatarSet, g.ContactInfoSet, g.IsBot,
-		dbutil.JSON{Data: &g.Identifiers}, dbutil.JSON{Data: g.Metadata},
+		dbutil.JSON{Data: &g.Identifiers}, dbutil.JSON{Data: g.Metadata}, infoSyncedAt,
 	}
 }
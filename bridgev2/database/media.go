@@ -0,0 +1,95 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type MediaQuery struct {
+	BridgeID networkid.BridgeID
+	*dbutil.QueryHelper[*Media]
+}
+
+// Media is a remote file that has been reuploaded to Matrix.
+type Media struct {
+	BridgeID  networkid.BridgeID
+	RemoteID  networkid.RemoteMediaID
+	Encrypted bool // Whether the media was uploaded for an encrypted room, even if File is nil
+	SHA256    [32]byte
+	MXC       id.ContentURIString
+	File      *event.EncryptedFileInfo
+	FileName  string
+	MimeType  string
+	Size      int64
+	CreatedAt time.Time
+}
+
+const (
+	getMediaBaseQuery = `
+		SELECT bridge_id, remote_id, encrypted, sha256, mxc, file, file_name, mime_type, size, created_at FROM media
+	`
+	getMediaByRemoteIDQuery = getMediaBaseQuery + `WHERE bridge_id=$1 AND remote_id=$2 AND encrypted=$3`
+	getMediaByHashQuery     = getMediaBaseQuery + `WHERE bridge_id=$1 AND sha256=$2 AND encrypted=$3 ORDER BY created_at DESC LIMIT 1`
+	upsertMediaQuery        = `
+		INSERT INTO media (bridge_id, remote_id, encrypted, sha256, mxc, file, file_name, mime_type, size, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (bridge_id, remote_id, encrypted) DO UPDATE
+			SET sha256=excluded.sha256, mxc=excluded.mxc, file=excluded.file, file_name=excluded.file_name,
+				mime_type=excluded.mime_type, size=excluded.size, created_at=excluded.created_at
+	`
+	deleteMediaQuery = `DELETE FROM media WHERE bridge_id=$1 AND remote_id=$2`
+)
+
+func (mq *MediaQuery) GetByRemoteID(ctx context.Context, remoteID networkid.RemoteMediaID, encrypted bool) (*Media, error) {
+	return mq.QueryOne(ctx, getMediaByRemoteIDQuery, mq.BridgeID, remoteID, encrypted)
+}
+
+func (mq *MediaQuery) GetByHash(ctx context.Context, hash [32]byte, encrypted bool) (*Media, error) {
+	return mq.QueryOne(ctx, getMediaByHashQuery, mq.BridgeID, hash[:], encrypted)
+}
+
+func (mq *MediaQuery) Put(ctx context.Context, media *Media) error {
+	ensureBridgeIDMatches(&media.BridgeID, mq.BridgeID)
+	return mq.Exec(ctx, upsertMediaQuery, media.sqlVariables()...)
+}
+
+// Delete removes both the encrypted and unencrypted entries for the given remote ID.
+func (mq *MediaQuery) Delete(ctx context.Context, remoteID networkid.RemoteMediaID) error {
+	return mq.Exec(ctx, deleteMediaQuery, mq.BridgeID, remoteID)
+}
+
+func (m *Media) Scan(row dbutil.Scannable) (*Media, error) {
+	var hash []byte
+	var createdAt int64
+	err := row.Scan(
+		&m.BridgeID, &m.RemoteID, &m.Encrypted, &hash, &m.MXC, dbutil.JSON{Data: &m.File},
+		&m.FileName, &m.MimeType, &m.Size, &createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(hash) == len(m.SHA256) {
+		m.SHA256 = [32]byte(hash)
+	}
+	m.CreatedAt = time.Unix(0, createdAt)
+	return m, nil
+}
+
+func (m *Media) sqlVariables() []any {
+	return []any{
+		m.BridgeID, m.RemoteID, m.Encrypted, m.SHA256[:], m.MXC, dbutil.JSONPtr(m.File),
+		m.FileName, m.MimeType, m.Size, m.CreatedAt.UnixNano(),
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type OutgoingQueueQuery struct {
+	BridgeID networkid.BridgeID
+	*dbutil.QueryHelper[*OutgoingQueueEntry]
+	cipher *storedEventCipher
+}
+
+// OutgoingQueueEntry is a Matrix event that failed to be sent to the remote network and will be retried.
+type OutgoingQueueEntry struct {
+	BridgeID      networkid.BridgeID
+	EventID       id.EventID
+	Room          networkid.PortalKey
+	SenderMXID    id.UserID
+	Event         *event.Event
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+
+	cipher *storedEventCipher
+}
+
+const (
+	getOutgoingQueueBaseQuery = `
+		SELECT bridge_id, event_id, room_id, room_receiver, sender_mxid, event,
+		       attempts, next_attempt_at, last_error, created_at
+		FROM outgoing_queue
+	`
+	getOutgoingQueueEntryQuery = getOutgoingQueueBaseQuery + `WHERE bridge_id=$1 AND event_id=$2`
+	// Only the oldest entry of each portal is returned to ensure messages are sent in order.
+	getNextDueOutgoingQueueQuery = getOutgoingQueueBaseQuery + `
+		WHERE bridge_id=$1 AND next_attempt_at<=$2 AND NOT EXISTS(
+			SELECT 1 FROM outgoing_queue older
+			WHERE older.bridge_id=outgoing_queue.bridge_id
+				AND older.room_id=outgoing_queue.room_id
+				AND older.room_receiver=outgoing_queue.room_receiver
+				AND older.created_at<outgoing_queue.created_at
+		)
+		ORDER BY next_attempt_at
+		LIMIT $3
+	`
+	hasPendingOutgoingQueueQuery = `
+		SELECT EXISTS(SELECT 1 FROM outgoing_queue WHERE bridge_id=$1 AND room_id=$2 AND room_receiver=$3)
+	`
+	upsertOutgoingQueueQuery = `
+		INSERT INTO outgoing_queue (
+			bridge_id, event_id, room_id, room_receiver, sender_mxid, event,
+			attempts, next_attempt_at, last_error, created_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (bridge_id, event_id) DO UPDATE
+			SET attempts=excluded.attempts, next_attempt_at=excluded.next_attempt_at, last_error=excluded.last_error
+	`
+	deleteOutgoingQueueQuery = `DELETE FROM outgoing_queue WHERE bridge_id=$1 AND event_id=$2`
+)
+
+func (oqq *OutgoingQueueQuery) Get(ctx context.Context, eventID id.EventID) (*OutgoingQueueEntry, error) {
+	return oqq.QueryOne(ctx, getOutgoingQueueEntryQuery, oqq.BridgeID, eventID)
+}
+
+// GetNextDue returns entries whose next attempt is due, at most one per portal.
+func (oqq *OutgoingQueueQuery) GetNextDue(ctx context.Context, limit int) ([]*OutgoingQueueEntry, error) {
+	return oqq.QueryMany(ctx, getNextDueOutgoingQueueQuery, oqq.BridgeID, time.Now().UnixNano(), limit)
+}
+
+// HasPending checks if the given portal has any entries in the queue.
+func (oqq *OutgoingQueueQuery) HasPending(ctx context.Context, portal networkid.PortalKey) (exists bool, err error) {
+	err = oqq.GetDB().QueryRow(ctx, hasPendingOutgoingQueueQuery, oqq.BridgeID, portal.ID, portal.Receiver).Scan(&exists)
+	return
+}
+
+func (oqq *OutgoingQueueQuery) Put(ctx context.Context, entry *OutgoingQueueEntry) error {
+	ensureBridgeIDMatches(&entry.BridgeID, oqq.BridgeID)
+	storedEvent, err := oqq.cipher.encrypt(entry.Event)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt event: %w", err)
+	}
+	return oqq.Exec(ctx, upsertOutgoingQueueQuery, entry.sqlVariables(storedEvent)...)
+}
+
+func (oqq *OutgoingQueueQuery) Delete(ctx context.Context, eventID id.EventID) error {
+	return oqq.Exec(ctx, deleteOutgoingQueueQuery, oqq.BridgeID, eventID)
+}
+
+func (oqe *OutgoingQueueEntry) Scan(row dbutil.Scannable) (*OutgoingQueueEntry, error) {
+	var nextAttemptAt, createdAt int64
+	var storedEvent []byte
+	err := row.Scan(
+		&oqe.BridgeID, &oqe.EventID, &oqe.Room.ID, &oqe.Room.Receiver, &oqe.SenderMXID, &storedEvent,
+		&oqe.Attempts, &nextAttemptAt, &oqe.LastError, &createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	oqe.NextAttemptAt = time.Unix(0, nextAttemptAt)
+	oqe.CreatedAt = time.Unix(0, createdAt)
+	// Events that can't be decrypted (e.g. because the key changed) are left nil so the entry gets dropped
+	oqe.Event, err = oqe.cipher.decrypt(storedEvent)
+	if err != nil {
+		oqe.LastError = err.Error()
+	}
+	return oqe, nil
+}
+
+func (oqe *OutgoingQueueEntry) sqlVariables(storedEvent json.RawMessage) []any {
+	return []any{
+		oqe.BridgeID, oqe.EventID, oqe.Room.ID, oqe.Room.Receiver, oqe.SenderMXID, string(storedEvent),
+		oqe.Attempts, oqe.NextAttemptAt.UnixNano(), oqe.LastError, oqe.CreatedAt.UnixNano(),
+	}
+}
@@ -54,7 +54,10 @@ type Portal struct {
 	InSpace      bool
 	RoomType     RoomType
 	Disappear    DisappearingSetting
-	Metadata     any
+	SpaceOrder   string
+	// RoomFeaturesHash is the SHA-256 hash of the last com.beeper.room_features event sent to the room.
+	RoomFeaturesHash [32]byte
+	Metadata         any
 }
 
 const (
@@ -62,7 +65,7 @@ const (
 		SELECT bridge_id, id, receiver, mxid, parent_id, parent_receiver, relay_login_id, other_user_id,
 		       name, topic, avatar_id, avatar_hash, avatar_mxc,
 		       name_set, topic_set, avatar_set, name_is_custom, in_space,
-		       room_type, disappear_type, disappear_timer,
+		       room_type, disappear_type, disappear_timer, space_order, room_features_hash,
 		       metadata
 		FROM portal
 	`
@@ -82,10 +85,10 @@ const (
 			parent_id, parent_receiver, relay_login_id, other_user_id,
 			name, topic, avatar_id, avatar_hash, avatar_mxc,
 			name_set, avatar_set, topic_set, name_is_custom, in_space,
-			room_type, disappear_type, disappear_timer,
+			room_type, disappear_type, disappear_timer, space_order, room_features_hash,
 			metadata, relay_bridge_id
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, cast($7 AS TEXT), $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22,
+			$1, $2, $3, $4, $5, $6, cast($7 AS TEXT), $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24,
 			CASE WHEN cast($7 AS TEXT) IS NULL THEN NULL ELSE $1 END
 		)
 	`
@@ -95,7 +98,8 @@ const (
 		    relay_login_id=cast($7 AS TEXT), relay_bridge_id=CASE WHEN cast($7 AS TEXT) IS NULL THEN NULL ELSE bridge_id END,
 		    other_user_id=$8, name=$9, topic=$10, avatar_id=$11, avatar_hash=$12, avatar_mxc=$13,
 		    name_set=$14, avatar_set=$15, topic_set=$16, name_is_custom=$17, in_space=$18,
-		    room_type=$19, disappear_type=$20, disappear_timer=$21, metadata=$22
+		    room_type=$19, disappear_type=$20, disappear_timer=$21,
+		    space_order=$22, room_features_hash=$23, metadata=$24
 		WHERE bridge_id=$1 AND id=$2 AND receiver=$3
 	`
 	deletePortalQuery = `
@@ -182,13 +186,13 @@ func (pq *PortalQuery) MigrateToSplitPortals(ctx context.Context) (int64, error)
 func (p *Portal) Scan(row dbutil.Scannable) (*Portal, error) {
 	var mxid, parentID, parentReceiver, relayLoginID, otherUserID, disappearType sql.NullString
 	var disappearTimer sql.NullInt64
-	var avatarHash string
+	var avatarHash, roomFeaturesHash string
 	err := row.Scan(
 		&p.BridgeID, &p.ID, &p.Receiver, &mxid,
 		&parentID, &parentReceiver, &relayLoginID, &otherUserID,
 		&p.Name, &p.Topic, &p.AvatarID, &avatarHash, &p.AvatarMXC,
 		&p.NameSet, &p.TopicSet, &p.AvatarSet, &p.NameIsCustom, &p.InSpace,
-		&p.RoomType, &disappearType, &disappearTimer,
+		&p.RoomType, &disappearType, &disappearTimer, &p.SpaceOrder, &roomFeaturesHash,
 		dbutil.JSON{Data: p.Metadata},
 	)
 	if err != nil {
@@ -200,6 +204,12 @@ func (p *Portal) Scan(row dbutil.Scannable) (*Portal, error) {
 			p.AvatarHash = *(*[32]byte)(data)
 		}
 	}
+	if roomFeaturesHash != "" {
+		data, _ := hex.DecodeString(roomFeaturesHash)
+		if len(data) == 32 {
+			p.RoomFeaturesHash = *(*[32]byte)(data)
+		}
+	}
 	if disappearType.Valid {
 		p.Disappear = DisappearingSetting{
 			Type:  DisappearingType(disappearType.String),
@@ -226,16 +236,19 @@ func (p *Portal) ensureHasMetadata(metaType MetaTypeCreator) *Portal {
 }
 
 func (p *Portal) sqlVariables() []any {
-	var avatarHash string
+	var avatarHash, roomFeaturesHash string
 	if p.AvatarHash != [32]byte{} {
 		avatarHash = hex.EncodeToString(p.AvatarHash[:])
 	}
+	if p.RoomFeaturesHash != [32]byte{} {
+		roomFeaturesHash = hex.EncodeToString(p.RoomFeaturesHash[:])
+	}
 	return []any{
 		p.BridgeID, p.ID, p.Receiver, dbutil.StrPtr(p.MXID),
 		dbutil.StrPtr(p.ParentKey.ID), p.ParentKey.Receiver, dbutil.StrPtr(p.RelayLoginID), dbutil.StrPtr(p.OtherUserID),
 		p.Name, p.Topic, p.AvatarID, avatarHash, p.AvatarMXC,
 		p.NameSet, p.TopicSet, p.AvatarSet, p.NameIsCustom, p.InSpace,
-		p.RoomType, dbutil.StrPtr(p.Disappear.Type), dbutil.NumPtr(p.Disappear.Timer),
+		p.RoomType, dbutil.StrPtr(p.Disappear.Type), dbutil.NumPtr(p.Disappear.Timer), p.SpaceOrder, roomFeaturesHash,
 		dbutil.JSON{Data: p.Metadata},
 	}
 }
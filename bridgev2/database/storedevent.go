@@ -0,0 +1,114 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"golang.org/x/crypto/hkdf"
+
+	"maunium.net/go/mautrix/event"
+)
+
+var ErrStoredEventKeyMissing = errors.New("stored event is encrypted, but no key is set")
+
+const storedEventKDFInfo = "fi.mau.bridge.stored_event"
+
+// storedEventEnvelope is the database representation of an encrypted Matrix event.
+type storedEventEnvelope struct {
+	Ciphertext []byte `json:"fi.mau.encrypted_event"`
+}
+
+// storedEventCipher encrypts Matrix events that are stored in the database (the outgoing queue and
+// failed events), so that decrypted copies of end-to-bridge encrypted events aren't left in the database.
+type storedEventCipher struct {
+	aead atomic.Pointer[cipher.AEAD]
+}
+
+// SetStoredEventKey sets the secret used to encrypt Matrix events stored in the database.
+// The actual encryption key is derived from the secret using HKDF-SHA256.
+//
+// If the secret is empty, events are stored as plaintext JSON.
+func (db *Database) SetStoredEventKey(secret []byte) error {
+	if len(secret) == 0 {
+		db.eventCipher.aead.Store(nil)
+		return nil
+	}
+	key := make([]byte, 32)
+	_, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte(storedEventKDFInfo)), key)
+	if err != nil {
+		return fmt.Errorf("failed to derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	db.eventCipher.aead.Store(&aead)
+	return nil
+}
+
+func (sec *storedEventCipher) encrypt(evt *event.Event) (json.RawMessage, error) {
+	plaintext, err := json.Marshal(evt)
+	if err != nil {
+		return nil, err
+	}
+	aead := sec.aead.Load()
+	if aead == nil || evt == nil {
+		return plaintext, nil
+	}
+	nonce := make([]byte, (*aead).NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&storedEventEnvelope{Ciphertext: (*aead).Seal(nonce, nonce, plaintext, nil)})
+}
+
+func (sec *storedEventCipher) decrypt(data []byte) (*event.Event, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	var envelope storedEventEnvelope
+	err := json.Unmarshal(data, &envelope)
+	if err != nil {
+		return nil, err
+	}
+	if envelope.Ciphertext != nil {
+		aead := sec.aead.Load()
+		if aead == nil {
+			return nil, ErrStoredEventKeyMissing
+		}
+		nonceSize := (*aead).NonceSize()
+		if len(envelope.Ciphertext) < nonceSize {
+			return nil, fmt.Errorf("encrypted event is too short")
+		}
+		data, err = (*aead).Open(nil, envelope.Ciphertext[:nonceSize], envelope.Ciphertext[nonceSize:], nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt stored event: %w", err)
+		}
+	}
+	var evt event.Event
+	err = json.Unmarshal(data, &evt)
+	if err != nil {
+		return nil, err
+	}
+	// Parse errors are ignored here, the content will still be available in the raw map
+	_ = evt.Content.ParseRaw(evt.Type)
+	return &evt, nil
+}
@@ -1,4 +1,4 @@
--- v0 -> v18 (compatible with v9+): Latest revision
+-- v0 -> v25 (compatible with v9+): Latest revision
 CREATE TABLE "user" (
 	bridge_id       TEXT NOT NULL,
 	mxid            TEXT NOT NULL,
@@ -51,6 +51,8 @@ CREATE TABLE portal (
 	room_type       TEXT    NOT NULL,
 	disappear_type  TEXT,
 	disappear_timer BIGINT,
+	space_order     TEXT    NOT NULL DEFAULT '',
+	room_features_hash TEXT NOT NULL DEFAULT '',
 	metadata        jsonb   NOT NULL,
 
 	PRIMARY KEY (bridge_id, id, receiver),
@@ -77,6 +79,7 @@ CREATE TABLE ghost (
 	is_bot           BOOLEAN NOT NULL,
 	identifiers      jsonb   NOT NULL,
 	metadata         jsonb   NOT NULL,
+	info_synced_at   BIGINT  NOT NULL DEFAULT 0,
 
 	PRIMARY KEY (bridge_id, id)
 );
@@ -206,3 +209,65 @@ CREATE TABLE kv_store (
 
 	PRIMARY KEY (bridge_id, key)
 );
+
+CREATE TABLE media (
+	bridge_id  TEXT    NOT NULL,
+	remote_id  TEXT    NOT NULL,
+	encrypted  BOOLEAN NOT NULL,
+	sha256     bytea   NOT NULL,
+	mxc        TEXT    NOT NULL,
+	file       jsonb,
+	file_name  TEXT    NOT NULL,
+	mime_type  TEXT    NOT NULL,
+	size       BIGINT  NOT NULL,
+	created_at BIGINT  NOT NULL,
+
+	PRIMARY KEY (bridge_id, remote_id, encrypted)
+);
+CREATE INDEX media_sha256_idx ON media (bridge_id, sha256, encrypted);
+
+CREATE TABLE custom_emoji (
+	bridge_id     TEXT NOT NULL,
+	room_id       TEXT NOT NULL,
+	room_receiver TEXT NOT NULL,
+	emoji_id      TEXT NOT NULL,
+	matrix_key    TEXT NOT NULL,
+	shortcode     TEXT NOT NULL,
+
+	PRIMARY KEY (bridge_id, room_id, room_receiver, emoji_id)
+);
+CREATE INDEX custom_emoji_matrix_key_idx ON custom_emoji (bridge_id, matrix_key);
+
+CREATE TABLE outgoing_queue (
+	bridge_id       TEXT    NOT NULL,
+	event_id        TEXT    NOT NULL,
+	room_id         TEXT    NOT NULL,
+	room_receiver   TEXT    NOT NULL,
+	sender_mxid     TEXT    NOT NULL,
+	event           jsonb   NOT NULL,
+	attempts        INTEGER NOT NULL,
+	next_attempt_at BIGINT  NOT NULL,
+	last_error      TEXT    NOT NULL,
+	created_at      BIGINT  NOT NULL,
+
+	PRIMARY KEY (bridge_id, event_id),
+	CONSTRAINT outgoing_queue_portal_fkey FOREIGN KEY (bridge_id, room_id, room_receiver)
+		REFERENCES portal (bridge_id, id, receiver)
+		ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX outgoing_queue_portal_idx ON outgoing_queue (bridge_id, room_id, room_receiver, created_at);
+
+CREATE TABLE failed_event (
+	bridge_id     TEXT    NOT NULL,
+	event_id      TEXT    NOT NULL,
+	room_id       TEXT    NOT NULL,
+	room_receiver TEXT    NOT NULL,
+	event         jsonb   NOT NULL,
+	retry_count   INTEGER NOT NULL,
+	failed_at     BIGINT  NOT NULL,
+
+	PRIMARY KEY (bridge_id, event_id),
+	CONSTRAINT failed_event_portal_fkey FOREIGN KEY (bridge_id, room_id, room_receiver)
+		REFERENCES portal (bridge_id, id, receiver)
+		ON DELETE CASCADE ON UPDATE CASCADE
+);
@@ -0,0 +1,16 @@
+-- v19 (compatible with v9+): Add table for reuploaded media
+CREATE TABLE media (
+	bridge_id  TEXT    NOT NULL,
+	remote_id  TEXT    NOT NULL,
+	encrypted  BOOLEAN NOT NULL,
+	sha256     bytea   NOT NULL,
+	mxc        TEXT    NOT NULL,
+	file       jsonb,
+	file_name  TEXT    NOT NULL,
+	mime_type  TEXT    NOT NULL,
+	size       BIGINT  NOT NULL,
+	created_at BIGINT  NOT NULL,
+
+	PRIMARY KEY (bridge_id, remote_id, encrypted)
+);
+CREATE INDEX media_sha256_idx ON media (bridge_id, sha256, encrypted);
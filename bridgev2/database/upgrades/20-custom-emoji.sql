@@ -0,0 +1,12 @@
+-- v20 (compatible with v9+): Add table for custom emoji mappings
+CREATE TABLE custom_emoji (
+	bridge_id     TEXT NOT NULL,
+	room_id       TEXT NOT NULL,
+	room_receiver TEXT NOT NULL,
+	emoji_id      TEXT NOT NULL,
+	matrix_key    TEXT NOT NULL,
+	shortcode     TEXT NOT NULL,
+
+	PRIMARY KEY (bridge_id, room_id, room_receiver, emoji_id)
+);
+CREATE INDEX custom_emoji_matrix_key_idx ON custom_emoji (bridge_id, matrix_key);
@@ -0,0 +1,2 @@
+-- v21 (compatible with v9+): Save when ghost info was last synced
+ALTER TABLE ghost ADD COLUMN info_synced_at BIGINT NOT NULL DEFAULT 0;
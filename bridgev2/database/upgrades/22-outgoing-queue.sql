@@ -0,0 +1,19 @@
+-- v22 (compatible with v9+): Add persistent queue for outgoing messages
+CREATE TABLE outgoing_queue (
+	bridge_id       TEXT    NOT NULL,
+	event_id        TEXT    NOT NULL,
+	room_id         TEXT    NOT NULL,
+	room_receiver   TEXT    NOT NULL,
+	sender_mxid     TEXT    NOT NULL,
+	event           jsonb   NOT NULL,
+	attempts        INTEGER NOT NULL,
+	next_attempt_at BIGINT  NOT NULL,
+	last_error      TEXT    NOT NULL,
+	created_at      BIGINT  NOT NULL,
+
+	PRIMARY KEY (bridge_id, event_id),
+	CONSTRAINT outgoing_queue_portal_fkey FOREIGN KEY (bridge_id, room_id, room_receiver)
+		REFERENCES portal (bridge_id, id, receiver)
+		ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX outgoing_queue_portal_idx ON outgoing_queue (bridge_id, room_id, room_receiver, created_at);
@@ -0,0 +1,2 @@
+-- v23 (compatible with v9+): Save hash of last sent room features
+ALTER TABLE portal ADD COLUMN room_features_hash TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- v24 (compatible with v9+): Save order of portals in spaces
+ALTER TABLE portal ADD COLUMN space_order TEXT NOT NULL DEFAULT '';
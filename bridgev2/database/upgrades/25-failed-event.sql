@@ -0,0 +1,15 @@
+-- v25 (compatible with v9+): Save failed Matrix events so they can be retried after restarts
+CREATE TABLE failed_event (
+	bridge_id     TEXT    NOT NULL,
+	event_id      TEXT    NOT NULL,
+	room_id       TEXT    NOT NULL,
+	room_receiver TEXT    NOT NULL,
+	event         jsonb   NOT NULL,
+	retry_count   INTEGER NOT NULL,
+	failed_at     BIGINT  NOT NULL,
+
+	PRIMARY KEY (bridge_id, event_id),
+	CONSTRAINT failed_event_portal_fkey FOREIGN KEY (bridge_id, room_id, room_receiver)
+		REFERENCES portal (bridge_id, id, receiver)
+		ON DELETE CASCADE ON UPDATE CASCADE
+);
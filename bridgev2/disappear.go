@@ -85,7 +85,11 @@ func (dl *DisappearLoop) Add(ctx context.Context, dm *database.DisappearingMessa
 
 func (dl *DisappearLoop) sleepAndDisappear(ctx context.Context, dms ...*database.DisappearingMessage) {
 	for _, msg := range dms {
-		time.Sleep(time.Until(msg.DisappearAt))
+		select {
+		case <-time.After(time.Until(msg.DisappearAt)):
+		case <-ctx.Done():
+			return
+		}
 		resp, err := dl.br.Bot.SendMessage(ctx, msg.RoomID, event.EventRedaction, &event.Content{
 			Parsed: &event.RedactionEventContent{
 				Redacts: msg.EventID,
@@ -99,6 +103,7 @@ func (dl *DisappearLoop) sleepAndDisappear(ctx context.Context, dms ...*database
 				Stringer("target_event_id", msg.EventID).
 				Stringer("redaction_event_id", resp.EventID).
 				Msg("Disappeared message")
+			dl.disappearRemote(ctx, msg)
 		}
 		err = dl.br.DB.DisappearingMessage.Delete(ctx, msg.EventID)
 		if err != nil {
@@ -108,3 +113,39 @@ func (dl *DisappearLoop) sleepAndDisappear(ctx context.Context, dms ...*database
 		}
 	}
 }
+
+func (dl *DisappearLoop) disappearRemote(ctx context.Context, dm *database.DisappearingMessage) {
+	log := zerolog.Ctx(ctx).With().Stringer("target_event_id", dm.EventID).Logger()
+	message, err := dl.br.DB.Message.GetPartByMXID(ctx, dm.EventID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get disappeared message from database")
+		return
+	} else if message == nil {
+		return
+	}
+	portal, err := dl.br.GetExistingPortalByKey(ctx, message.Room)
+	if err != nil {
+		log.Err(err).Msg("Failed to get portal of disappeared message")
+		return
+	} else if portal == nil {
+		return
+	}
+	logins, err := dl.br.GetUserLoginsInPortal(ctx, portal.PortalKey)
+	if err != nil {
+		log.Err(err).Msg("Failed to get user logins to delete disappeared message on remote network")
+		return
+	}
+	for _, login := range logins {
+		api, ok := login.Client.(DisappearingMessageDeletingNetworkAPI)
+		if !ok || !login.Client.IsLoggedIn() {
+			continue
+		}
+		err = api.HandleDisappearedMessage(ctx, portal, message)
+		if err != nil {
+			log.Err(err).Str("login_id", string(login.ID)).Msg("Failed to delete disappeared message on remote network")
+		} else {
+			log.Debug().Str("login_id", string(login.ID)).Msg("Deleted disappeared message on remote network")
+			return
+		}
+	}
+}
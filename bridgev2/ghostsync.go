@@ -0,0 +1,119 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/bridgev2/database"
+)
+
+const GhostSyncErrorBackoff = 1 * time.Minute
+const GhostSyncIdleDelay = 10 * time.Minute
+
+func (br *Bridge) getAnyLoggedInLogin() *UserLogin {
+	br.cacheLock.Lock()
+	defer br.cacheLock.Unlock()
+	for _, login := range br.userLoginsByID {
+		if login.Client != nil && login.Client.IsLoggedIn() {
+			return login
+		}
+	}
+	return nil
+}
+
+// RunGhostSync periodically refreshes the profiles of ghosts whose info hasn't been synced within the configured interval.
+func (br *Bridge) RunGhostSync() {
+	cfg := &br.Config.GhostSync
+	if !cfg.Enabled || cfg.Interval <= 0 {
+		return
+	}
+	log := br.Log.With().Str("component", "ghost sync").Logger()
+	ctx, cancel := context.WithCancel(log.WithContext(context.Background()))
+	go func() {
+		<-br.stopGhostSync
+		cancel()
+	}()
+	interval := time.Duration(cfg.Interval) * time.Hour
+	requestDelay := time.Duration(cfg.RequestDelay) * time.Second
+	batchSize := max(cfg.BatchSize, 1)
+	log.Info().Stringer("interval", interval).Msg("Ghost sync starting")
+	for {
+		nextDelay := requestDelay
+		ghosts, err := br.DB.Ghost.GetNextToSync(ctx, time.Now().Add(-interval), batchSize)
+		if err != nil {
+			log.Err(err).Msg("Failed to get ghosts to sync")
+			nextDelay = GhostSyncErrorBackoff
+		} else if len(ghosts) == 0 {
+			nextDelay = GhostSyncIdleDelay
+		} else if !br.syncGhostBatch(ctx, ghosts, requestDelay) {
+			nextDelay = GhostSyncIdleDelay
+		}
+		select {
+		case <-time.After(nextDelay):
+		case <-ctx.Done():
+			log.Info().Msg("Stopping ghost sync")
+			return
+		}
+	}
+}
+
+func (br *Bridge) syncGhostBatch(ctx context.Context, ghosts []*database.Ghost, requestDelay time.Duration) bool {
+	for i, dbGhost := range ghosts {
+		login := br.getAnyLoggedInLogin()
+		if login == nil {
+			zerolog.Ctx(ctx).Debug().Msg("No logged in user logins found to sync ghosts with")
+			return false
+		}
+		br.syncGhost(ctx, login, dbGhost)
+		if i == len(ghosts)-1 || requestDelay <= 0 {
+			continue
+		}
+		jitter := time.Duration(rand.Int64N(int64(requestDelay/2) + 1))
+		select {
+		case <-time.After(requestDelay + jitter):
+		case <-ctx.Done():
+			return true
+		}
+	}
+	return true
+}
+
+func (br *Bridge) syncGhost(ctx context.Context, login *UserLogin, dbGhost *database.Ghost) {
+	log := zerolog.Ctx(ctx).With().
+		Str("ghost_id", string(dbGhost.ID)).
+		Str("login_id", string(login.ID)).
+		Logger()
+	ctx = log.WithContext(ctx)
+	// The sync timestamp is bumped even if syncing fails to avoid retrying broken ghosts in a loop.
+	syncedAt := time.Now()
+	ghost, err := br.GetGhostByID(ctx, dbGhost.ID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get ghost to sync")
+		err = br.DB.Ghost.UpdateInfoSyncedAt(ctx, dbGhost.ID, syncedAt)
+		if err != nil {
+			log.Err(err).Msg("Failed to save ghost sync timestamp")
+		}
+		return
+	}
+	ghost.InfoSyncedAt = syncedAt
+	info, err := login.Client.GetUserInfo(ctx, ghost)
+	if err != nil {
+		log.Err(err).Msg("Failed to get user info to sync ghost")
+	} else if info != nil {
+		log.Debug().Msg("Syncing ghost info")
+		ghost.UpdateInfo(ctx, info)
+	}
+	err = br.DB.Ghost.Update(ctx, ghost.Ghost)
+	if err != nil {
+		log.Err(err).Msg("Failed to save ghost sync timestamp")
+	}
+}
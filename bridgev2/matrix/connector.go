@@ -13,6 +13,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"regexp"
@@ -86,6 +87,9 @@ type Connector struct {
 
 	EventProcessor *appservice.EventProcessor
 
+	metricsServer     *http.Server
+	metricsServerLock sync.Mutex
+
 	userIDRegex *regexp.Regexp
 
 	Websocket                      bool
@@ -107,6 +111,7 @@ var (
 	_ bridgev2.MatrixConnectorWithNameDisambiguation     = (*Connector)(nil)
 	_ bridgev2.MatrixConnectorWithURLPreviews            = (*Connector)(nil)
 	_ bridgev2.MatrixConnectorWithAnalytics              = (*Connector)(nil)
+	_ bridgev2.MatrixConnectorWithEncryptionState        = (*Connector)(nil)
 )
 
 func NewConnector(cfg *bridgeconfig.Config) *Connector {
@@ -127,6 +132,8 @@ func (br *Connector) Init(bridge *bridgev2.Bridge) {
 	br.AS = br.Config.MakeAppService()
 	br.AS.Log = bridge.Log
 	br.AS.StateStore = br.StateStore
+	br.StateStore.AppServiceID = br.Config.AppService.ID
+	br.AS.TransactionIDs = br.StateStore
 	br.EventProcessor = appservice.NewEventProcessor(br.AS)
 	if !br.Config.AppService.AsyncTransactions {
 		br.EventProcessor.ExecMode = appservice.Sync
@@ -157,6 +164,11 @@ func (br *Connector) Init(bridge *bridgev2.Bridge) {
 	br.Provisioning = &ProvisioningAPI{br: br}
 	br.DoublePuppet = newDoublePuppetUtil(br)
 	br.deterministicEventIDServer = "backfill." + br.Config.Homeserver.Domain
+	// Events stored in the outgoing queue may have been decrypted, so encrypt them with a key derived from the pickle key
+	err := br.Bridge.DB.SetStoredEventKey([]byte(br.Config.Encryption.PickleKey))
+	if err != nil {
+		br.Log.Err(err).Msg("Failed to set stored event encryption key")
+	}
 }
 
 func (br *Connector) Start(ctx context.Context) error {
@@ -199,6 +211,9 @@ func (br *Connector) Start(ctx context.Context) error {
 		}
 	}
 	br.EventProcessor.Start(ctx)
+	if br.Config.Metrics.Enabled {
+		br.startMetrics()
+	}
 	go br.UpdateBotProfile(ctx)
 	if br.Crypto != nil {
 		go br.Crypto.Start()
@@ -235,11 +250,17 @@ func (br *Connector) GetCapabilities() *bridgev2.MatrixCapabilities {
 
 func (br *Connector) Stop() {
 	br.stopping = true
-	br.AS.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	err := br.AS.Stop(ctx)
+	cancel()
+	if err != nil {
+		br.Log.Warn().Err(err).Msg("Appservice didn't stop cleanly")
+	}
 	br.EventProcessor.Stop()
 	if br.Crypto != nil {
 		br.Crypto.Stop()
 	}
+	br.stopMetrics()
 }
 
 var MinSpecVersion = mautrix.SpecV14
@@ -319,13 +340,11 @@ func (br *Connector) ensureConnection(ctx context.Context) {
 		br.Log.Debug().Msg("Homeserver does not support checking status of homeserver -> bridge connection")
 		return
 	}
-	var pingResp *mautrix.RespAppservicePing
-	var txnID string
+	var pingResp *appservice.PingResult
 	var retryCount int
 	const maxRetries = 6
 	for {
-		txnID = br.Bot.TxnID()
-		pingResp, err = br.Bot.AppservicePing(ctx, br.Config.AppService.ID, txnID)
+		pingResp, err = br.AS.Ping(ctx)
 		if err == nil {
 			break
 		}
@@ -341,7 +360,7 @@ func (br *Connector) ensureConnection(ctx context.Context) {
 		if outOfRetries {
 			level = zerolog.FatalLevel
 		}
-		evt := br.Log.WithLevel(level).Err(err).Str("txn_id", txnID)
+		evt := br.Log.WithLevel(level).Err(err)
 		if pingErrBody != "" {
 			bodyBytes := []byte(pingErrBody)
 			if json.Valid(bodyBytes) {
@@ -359,9 +378,15 @@ func (br *Connector) ensureConnection(ctx context.Context) {
 		time.Sleep(5 * time.Second)
 		retryCount++
 	}
+	if !pingResp.ReceivedByThisInstance {
+		br.Log.Warn().
+			Str("txn_id", pingResp.TxnID).
+			Msg("Homeserver -> bridge ping succeeded, but the ping request wasn't received by this bridge instance. Is the address in the registration correct?")
+	}
 	br.Log.Debug().
-		Str("txn_id", txnID).
-		Int64("duration_ms", pingResp.DurationMS).
+		Str("txn_id", pingResp.TxnID).
+		Dur("server_duration", pingResp.ServerDuration).
+		Dur("total_duration", pingResp.TotalDuration).
 		Msg("Homeserver -> bridge connection works")
 }
 
@@ -575,6 +600,10 @@ func (br *Connector) IsConfusableName(ctx context.Context, roomID id.RoomID, use
 	return br.AS.StateStore.IsConfusableName(ctx, roomID, userID, name)
 }
 
+func (br *Connector) IsRoomEncrypted(ctx context.Context, roomID id.RoomID) (bool, error) {
+	return br.StateStore.IsEncrypted(ctx, roomID)
+}
+
 func (br *Connector) BatchSend(ctx context.Context, roomID id.RoomID, req *mautrix.ReqBeeperBatchSend, extras []*bridgev2.MatrixSendExtra) (*mautrix.RespBeeperBatchSend, error) {
 	if encrypted, err := br.StateStore.IsEncrypted(ctx, roomID); err != nil {
 		return nil, fmt.Errorf("failed to check if room is encrypted: %w", err)
@@ -244,6 +244,9 @@ func (helper *CryptoHelper) loginBot(ctx context.Context) (*mautrix.Client, bool
 
 	initialDeviceDisplayName := fmt.Sprintf("%s bridge", helper.bridge.Bridge.Network.GetName().DisplayName)
 	if helper.bridge.Config.Encryption.MSC4190 {
+		if !helper.bridge.SpecVersions.Supports(mautrix.FeatureAppserviceDevices) {
+			return nil, deviceID != "", fmt.Errorf("encryption.msc4190 is enabled, but the homeserver doesn't advertise MSC4190 support")
+		}
 		helper.log.Debug().Msg("Creating bot device with MSC4190")
 		err = client.CreateDeviceMSC4190(ctx, deviceID, initialDeviceDisplayName)
 		if err != nil {
@@ -355,15 +358,23 @@ func (helper *CryptoHelper) Reset(ctx context.Context, startAfterReset bool) {
 	helper.Stop()
 	helper.log.Debug().Msg("Crypto syncer stopped, clearing database")
 	helper.clearDatabase(ctx)
-	helper.log.Debug().Msg("Crypto database cleared, logging out of all sessions")
-	_, err := helper.client.LogoutAll(ctx)
-	if err != nil {
-		helper.log.Warn().Err(err).Msg("Failed to log out all devices")
+	if helper.bridge.Config.Encryption.MSC4190 {
+		helper.log.Debug().Msg("Crypto database cleared, deleting device")
+		err := helper.client.DeleteDeviceMSC4190(ctx, helper.client.DeviceID)
+		if err != nil {
+			helper.log.Warn().Err(err).Msg("Failed to delete device")
+		}
+	} else {
+		helper.log.Debug().Msg("Crypto database cleared, logging out of all sessions")
+		_, err := helper.client.LogoutAll(ctx)
+		if err != nil {
+			helper.log.Warn().Err(err).Msg("Failed to log out all devices")
+		}
 	}
 	helper.client = nil
 	helper.store = nil
 	helper.mach = nil
-	err = helper.Init(ctx)
+	err := helper.Init(ctx)
 	if err != nil {
 		helper.log.WithLevel(zerolog.FatalLevel).Err(err).Msg("Error reinitializing end-to-bridge encryption")
 		os.Exit(50)
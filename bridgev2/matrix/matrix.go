@@ -103,6 +103,7 @@ func (br *Connector) handleEncryptedEvent(ctx context.Context, evt *event.Event)
 	}
 	if err != nil {
 		log.Warn().Err(err).Msg("Failed to decrypt event")
+		br.Bridge.Metrics.CountDecryptionError()
 		go br.sendCryptoStatusError(ctx, evt, err, nil, decryptionRetryCount, true)
 		return
 	}
@@ -122,6 +123,7 @@ func (br *Connector) waitLongerForSession(ctx context.Context, evt *event.Event,
 
 	if !br.Crypto.WaitForSession(ctx, evt.RoomID, content.SenderKey, content.SessionID, extendedSessionWaitTimeout) {
 		log.Debug().Msg("Didn't get session, giving up trying to decrypt event")
+		br.Bridge.Metrics.CountDecryptionError()
 		go br.sendCryptoStatusError(ctx, evt, errNoDecryptionKeys, errorEventID, 2, true)
 		return
 	}
@@ -130,6 +132,7 @@ func (br *Connector) waitLongerForSession(ctx context.Context, evt *event.Event,
 	decrypted, err := br.Crypto.Decrypt(ctx, evt)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to decrypt event")
+		br.Bridge.Metrics.CountDecryptionError()
 		go br.sendCryptoStatusError(ctx, evt, err, errorEventID, 2, true)
 		return
 	}
@@ -0,0 +1,52 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package matrix
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"maunium.net/go/mautrix/appservice"
+)
+
+func (br *Connector) startMetrics() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", appservice.PrometheusHandler(br.Bridge.Metrics, br.AS.Metrics))
+	server := &http.Server{
+		Addr:              br.Config.Metrics.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	br.metricsServerLock.Lock()
+	br.metricsServer = server
+	br.metricsServerLock.Unlock()
+	go func() {
+		br.Log.Info().Str("listen", br.Config.Metrics.Listen).Msg("Starting metrics listener")
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			br.Log.Err(err).Msg("Metrics listener failed")
+		}
+	}()
+}
+
+func (br *Connector) stopMetrics() {
+	br.metricsServerLock.Lock()
+	server := br.metricsServer
+	br.metricsServer = nil
+	br.metricsServerLock.Unlock()
+	if server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		br.Log.Warn().Err(err).Msg("Failed to stop metrics listener")
+	}
+}
@@ -119,6 +119,7 @@ func (prov *ProvisioningAPI) Init() {
 	prov.Router.Path("/v3/login/start/{flowID}").Methods(http.MethodPost, http.MethodOptions).HandlerFunc(prov.PostLoginStart)
 	prov.Router.Path("/v3/login/step/{loginProcessID}/{stepID}/{stepType:user_input|cookies}").Methods(http.MethodPost, http.MethodOptions).HandlerFunc(prov.PostLoginSubmitInput)
 	prov.Router.Path("/v3/login/step/{loginProcessID}/{stepID}/{stepType:display_and_wait}").Methods(http.MethodPost, http.MethodOptions).HandlerFunc(prov.PostLoginWait)
+	prov.Router.Path("/v3/login/stream/{flowID}").Methods(http.MethodGet).HandlerFunc(prov.GetLoginStream)
 	prov.Router.Path("/v3/logout/{loginID}").Methods(http.MethodPost, http.MethodOptions).HandlerFunc(prov.PostLogout)
 	prov.Router.Path("/v3/logins").Methods(http.MethodGet, http.MethodOptions).HandlerFunc(prov.GetLogins)
 	prov.Router.Path("/v3/contacts").Methods(http.MethodGet, http.MethodOptions).HandlerFunc(prov.GetContactList)
@@ -126,6 +127,9 @@ func (prov *ProvisioningAPI) Init() {
 	prov.Router.Path("/v3/resolve_identifier/{identifier}").Methods(http.MethodGet, http.MethodOptions).HandlerFunc(prov.GetResolveIdentifier)
 	prov.Router.Path("/v3/create_dm/{identifier}").Methods(http.MethodPost, http.MethodOptions).HandlerFunc(prov.PostCreateDM)
 	prov.Router.Path("/v3/create_group").Methods(http.MethodPost, http.MethodOptions).HandlerFunc(prov.PostCreateGroup)
+	prov.Router.Path("/v3/relay/{roomID}").Methods(http.MethodGet, http.MethodOptions).HandlerFunc(prov.GetRelay)
+	prov.Router.Path("/v3/relay/{roomID}").Methods(http.MethodPut, http.MethodDelete).HandlerFunc(prov.PutRelay)
+	prov.Router.Path("/v3/backfill/{roomID}").Methods(http.MethodPost, http.MethodOptions).HandlerFunc(prov.PostRequestBackfill)
 
 	if prov.br.Config.Provisioning.DebugEndpoints {
 		prov.log.Debug().Msg("Enabling debug API at /debug")
@@ -780,6 +784,157 @@ func (prov *ProvisioningAPI) PostCreateDM(w http.ResponseWriter, r *http.Request
 	prov.doResolveIdentifier(w, r, true)
 }
 
+func (prov *ProvisioningAPI) getPortalForRequest(w http.ResponseWriter, r *http.Request) *bridgev2.Portal {
+	roomID := id.RoomID(mux.Vars(r)["roomID"])
+	portal, err := prov.br.Bridge.GetPortalByMXID(r.Context(), roomID)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Err(err).Msg("Failed to get portal")
+		RespondWithError(w, err, "Internal error getting portal")
+		return nil
+	} else if portal == nil {
+		jsonResponse(w, http.StatusNotFound, &mautrix.RespError{
+			Err:     "Room is not a portal",
+			ErrCode: mautrix.MNotFound.ErrCode,
+		})
+		return nil
+	}
+	return portal
+}
+
+type RespRequestBackfill struct {
+	Queued bool `json:"queued"`
+}
+
+// PostRequestBackfill asks the bridge to backfill older messages in a portal room,
+// e.g. when the user's client has paginated past the oldest bridged message.
+func (prov *ProvisioningAPI) PostRequestBackfill(w http.ResponseWriter, r *http.Request) {
+	portal := prov.getPortalForRequest(w, r)
+	if portal == nil {
+		return
+	}
+	login, _, err := portal.FindPreferredLogin(r.Context(), prov.GetUser(r), false)
+	if err != nil && !errors.Is(err, bridgev2.ErrNotLoggedIn) {
+		zerolog.Ctx(r.Context()).Err(err).Msg("Failed to find login for portal")
+		RespondWithError(w, err, "Internal error finding login")
+		return
+	} else if login == nil {
+		jsonResponse(w, http.StatusForbidden, &mautrix.RespError{
+			Err:     "You're not logged into that portal",
+			ErrCode: mautrix.MForbidden.ErrCode,
+		})
+		return
+	}
+	queued, err := prov.br.Bridge.RequestBackfill(r.Context(), portal)
+	if errors.Is(err, bridgev2.ErrBackfillQueueDisabled) {
+		jsonResponse(w, http.StatusNotImplemented, &mautrix.RespError{
+			Err:     "Backfilling history is not enabled on this bridge",
+			ErrCode: mautrix.MUnrecognized.ErrCode,
+		})
+		return
+	} else if err != nil {
+		zerolog.Ctx(r.Context()).Err(err).Msg("Failed to request backfill")
+		RespondWithError(w, err, "Internal error requesting backfill")
+		return
+	}
+	jsonResponse(w, http.StatusOK, &RespRequestBackfill{Queued: queued})
+}
+
+type RespRelay struct {
+	LoginID    networkid.UserLoginID `json:"login_id"`
+	UserID     id.UserID             `json:"user_id"`
+	RemoteName string                `json:"remote_name"`
+}
+
+type RespGetRelay struct {
+	Enabled bool       `json:"enabled"`
+	Relay   *RespRelay `json:"relay"`
+}
+
+type ReqPutRelay struct {
+	LoginID networkid.UserLoginID `json:"login_id"`
+}
+
+func makeRespRelay(login *bridgev2.UserLogin) *RespRelay {
+	if login == nil {
+		return nil
+	}
+	return &RespRelay{
+		LoginID:    login.ID,
+		UserID:     login.UserMXID,
+		RemoteName: login.RemoteName,
+	}
+}
+
+// GetRelay returns the current relay login of a portal room.
+func (prov *ProvisioningAPI) GetRelay(w http.ResponseWriter, r *http.Request) {
+	portal := prov.getPortalForRequest(w, r)
+	if portal == nil {
+		return
+	}
+	jsonResponse(w, http.StatusOK, &RespGetRelay{
+		Enabled: prov.br.Bridge.Config.Relay.Enabled,
+		Relay:   makeRespRelay(portal.Relay),
+	})
+}
+
+// PutRelay sets (PUT) or removes (DELETE) the relay login of a portal room.
+func (prov *ProvisioningAPI) PutRelay(w http.ResponseWriter, r *http.Request) {
+	portal := prov.getPortalForRequest(w, r)
+	if portal == nil {
+		return
+	}
+	user := prov.GetUser(r)
+	if !prov.br.Bridge.Config.Relay.Enabled {
+		jsonResponse(w, http.StatusNotImplemented, &mautrix.RespError{
+			Err:     "This bridge does not allow relay mode",
+			ErrCode: mautrix.MUnrecognized.ErrCode,
+		})
+		return
+	} else if !portal.CanManageRelay(r.Context(), user) {
+		jsonResponse(w, http.StatusForbidden, &mautrix.RespError{
+			Err:     "You don't have permission to manage the relay in this room",
+			ErrCode: mautrix.MForbidden.ErrCode,
+		})
+		return
+	}
+	var relay *bridgev2.UserLogin
+	if r.Method == http.MethodPut {
+		var req ReqPutRelay
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			jsonResponse(w, http.StatusBadRequest, &mautrix.RespError{
+				Err:     "Failed to decode request body",
+				ErrCode: mautrix.MNotJSON.ErrCode,
+			})
+			return
+		}
+		relay = prov.br.Bridge.GetCachedUserLoginByID(req.LoginID)
+		if relay == nil {
+			jsonResponse(w, http.StatusNotFound, &mautrix.RespError{
+				Err:     "Login not found",
+				ErrCode: mautrix.MNotFound.ErrCode,
+			})
+			return
+		} else if err = portal.CheckRelayLogin(user, relay); err != nil {
+			jsonResponse(w, http.StatusForbidden, &mautrix.RespError{
+				Err:     err.Error(),
+				ErrCode: mautrix.MForbidden.ErrCode,
+			})
+			return
+		}
+	}
+	err := portal.SetRelay(r.Context(), relay)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Err(err).Msg("Failed to save relay settings")
+		RespondWithError(w, err, "Internal error saving relay settings")
+		return
+	}
+	jsonResponse(w, http.StatusOK, &RespGetRelay{
+		Enabled: true,
+		Relay:   makeRespRelay(relay),
+	})
+}
+
 func (prov *ProvisioningAPI) PostCreateGroup(w http.ResponseWriter, r *http.Request) {
 	login := prov.GetLoginForRequest(w, r)
 	if login == nil {
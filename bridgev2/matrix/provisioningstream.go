@@ -0,0 +1,232 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/rs/xid"
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/bridgev2"
+)
+
+// LoginStreamMessageType is the type of message sent by the bridge in a login stream.
+type LoginStreamMessageType string
+
+const (
+	// LoginStreamMessageStep contains the next step of the login. If the step type is complete,
+	// the bridge will close the connection after sending it.
+	LoginStreamMessageStep LoginStreamMessageType = "step"
+	// LoginStreamMessageError means the login failed. The bridge will close the connection after sending it.
+	LoginStreamMessageError LoginStreamMessageType = "error"
+)
+
+// LoginStreamMessage is a message sent by the bridge to the client in a login stream.
+type LoginStreamMessage struct {
+	Type    LoginStreamMessageType `json:"type"`
+	LoginID string                 `json:"login_id"`
+
+	Step  *bridgev2.LoginStep `json:"step,omitempty"`
+	Error *mautrix.RespError  `json:"error,omitempty"`
+}
+
+// LoginStreamInput is a message sent by the client to the bridge in a login stream
+// to submit the data requested by a user_input or cookies step.
+type LoginStreamInput struct {
+	StepID string            `json:"step_id"`
+	Input  map[string]string `json:"input"`
+}
+
+const loginStreamWriteTimeout = 10 * time.Second
+
+var loginStreamUpgrader = websocket.Upgrader{
+	// Auth is done using tokens rather than cookies, so checking the origin isn't necessary.
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+type loginStream struct {
+	conn    *websocket.Conn
+	loginID string
+	input   chan *LoginStreamInput
+	log     *zerolog.Logger
+}
+
+func (ls *loginStream) send(msg *LoginStreamMessage) error {
+	msg.LoginID = ls.loginID
+	_ = ls.conn.SetWriteDeadline(time.Now().Add(loginStreamWriteTimeout))
+	return ls.conn.WriteJSON(msg)
+}
+
+func (ls *loginStream) sendError(err error, message string) {
+	var respErr mautrix.RespError
+	var bridgeRespErr bridgev2.RespError
+	if errors.As(err, &bridgeRespErr) {
+		respErr = mautrix.RespError(bridgeRespErr)
+	} else if !errors.As(err, &respErr) {
+		respErr = mautrix.RespError{
+			Err:     message,
+			ErrCode: "M_UNKNOWN",
+		}
+	}
+	sendErr := ls.send(&LoginStreamMessage{Type: LoginStreamMessageError, Error: &respErr})
+	if sendErr != nil {
+		ls.log.Debug().Err(sendErr).Msg("Failed to send error to login stream")
+	}
+}
+
+func (ls *loginStream) readLoop(ctx context.Context, cancel context.CancelFunc) {
+	defer cancel()
+	for {
+		var msg LoginStreamInput
+		err := ls.conn.ReadJSON(&msg)
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				ls.log.Debug().Err(err).Msg("Failed to read from login stream")
+			}
+			return
+		}
+		select {
+		case ls.input <- &msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (ls *loginStream) waitForInput(ctx context.Context, step *bridgev2.LoginStep) (map[string]string, error) {
+	select {
+	case msg := <-ls.input:
+		if msg.StepID != step.StepID {
+			ls.log.Warn().
+				Str("request_step_id", msg.StepID).
+				Str("expected_step_id", step.StepID).
+				Msg("Step ID in login stream input does not match")
+			return nil, mautrix.MBadState.WithMessage("Step ID does not match")
+		}
+		return msg.Input, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// GetLoginStream runs an entire login process over a websocket.
+//
+// The bridge sends each step as a LoginStreamMessage. Steps of type display_and_wait are waited for automatically,
+// while user_input and cookies steps require the client to send a LoginStreamInput with the requested data.
+// The login process is cancelled if the client disconnects before it's complete.
+func (prov *ProvisioningAPI) GetLoginStream(w http.ResponseWriter, r *http.Request) {
+	overrideLogin, failed := prov.GetExplicitLoginForRequest(w, r)
+	if failed {
+		return
+	}
+	login, err := prov.net.CreateLogin(r.Context(), prov.GetUser(r), mux.Vars(r)["flowID"])
+	if err != nil {
+		zerolog.Ctx(r.Context()).Err(err).Msg("Failed to create login process")
+		RespondWithError(w, err, "Internal error creating login process")
+		return
+	}
+	conn, err := loginStreamUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Err(err).Msg("Failed to upgrade login stream connection")
+		login.Cancel()
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	loginID := xid.New().String()
+	log := zerolog.Ctx(r.Context()).With().Str("login_id", loginID).Logger()
+	ctx, cancel := context.WithCancel(log.WithContext(r.Context()))
+	defer cancel()
+	ls := &loginStream{
+		conn:    conn,
+		loginID: loginID,
+		input:   make(chan *LoginStreamInput),
+		log:     &log,
+	}
+	go ls.readLoop(ctx, cancel)
+
+	var step *bridgev2.LoginStep
+	overridable, ok := login.(bridgev2.LoginProcessWithOverride)
+	if ok && overrideLogin != nil {
+		step, err = overridable.StartWithOverride(ctx, overrideLogin)
+	} else {
+		step, err = login.Start(ctx)
+	}
+	if err != nil {
+		log.Err(err).Msg("Failed to start login")
+		ls.sendError(err, "Internal error starting login")
+		return
+	}
+	provLogin := &ProvLogin{
+		ID:       loginID,
+		Process:  login,
+		NextStep: step,
+		Override: overrideLogin,
+	}
+	for {
+		err = ls.send(&LoginStreamMessage{Type: LoginStreamMessageStep, Step: step})
+		if err != nil {
+			log.Err(err).Msg("Failed to send login step")
+			login.Cancel()
+			return
+		} else if step.Type == bridgev2.LoginStepTypeComplete {
+			prov.handleCompleteStep(ctx, provLogin, step)
+			_ = conn.WriteControl(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+				time.Now().Add(loginStreamWriteTimeout),
+			)
+			return
+		}
+		step, err = prov.doLoginStreamStep(ctx, ls, login, step)
+		if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+			log.Debug().Msg("Login stream closed before login was complete, cancelling login")
+			login.Cancel()
+			return
+		} else if err != nil {
+			log.Err(err).Msg("Failed to execute login step")
+			ls.sendError(err, "Internal error in login step")
+			login.Cancel()
+			return
+		}
+		provLogin.NextStep = step
+	}
+}
+
+func (prov *ProvisioningAPI) doLoginStreamStep(
+	ctx context.Context, ls *loginStream, login bridgev2.LoginProcess, step *bridgev2.LoginStep,
+) (*bridgev2.LoginStep, error) {
+	switch step.Type {
+	case bridgev2.LoginStepTypeDisplayAndWait:
+		return login.(bridgev2.LoginProcessDisplayAndWait).Wait(ctx)
+	case bridgev2.LoginStepTypeUserInput:
+		input, err := ls.waitForInput(ctx, step)
+		if err != nil {
+			return nil, err
+		}
+		return login.(bridgev2.LoginProcessUserInput).SubmitUserInput(ctx, input)
+	case bridgev2.LoginStepTypeCookies:
+		input, err := ls.waitForInput(ctx, step)
+		if err != nil {
+			return nil, err
+		}
+		return login.(bridgev2.LoginProcessCookies).SubmitCookies(ctx, input)
+	default:
+		return nil, fmt.Errorf("unknown login step type %q", step.Type)
+	}
+}
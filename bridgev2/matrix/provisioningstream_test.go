@@ -0,0 +1,74 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package matrix
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/bridgev2"
+)
+
+type fakeUserInputLogin struct {
+	submitted []map[string]string
+}
+
+func (fl *fakeUserInputLogin) Start(ctx context.Context) (*bridgev2.LoginStep, error) {
+	return nil, nil
+}
+
+func (fl *fakeUserInputLogin) Cancel() {}
+
+func (fl *fakeUserInputLogin) SubmitUserInput(ctx context.Context, input map[string]string) (*bridgev2.LoginStep, error) {
+	fl.submitted = append(fl.submitted, input)
+	return &bridgev2.LoginStep{Type: bridgev2.LoginStepTypeComplete, StepID: "done"}, nil
+}
+
+func newTestLoginStream() *loginStream {
+	log := zerolog.Nop()
+	return &loginStream{
+		loginID: "test",
+		input:   make(chan *LoginStreamInput, 1),
+		log:     &log,
+	}
+}
+
+func TestLoginStream_UserInput(t *testing.T) {
+	ls := newTestLoginStream()
+	login := &fakeUserInputLogin{}
+	step := &bridgev2.LoginStep{Type: bridgev2.LoginStepTypeUserInput, StepID: "phone"}
+	ls.input <- &LoginStreamInput{StepID: "phone", Input: map[string]string{"phone": "+123"}}
+
+	nextStep, err := (&ProvisioningAPI{}).doLoginStreamStep(context.Background(), ls, login, step)
+	require.NoError(t, err)
+	assert.Equal(t, bridgev2.LoginStepTypeComplete, nextStep.Type)
+	assert.Equal(t, []map[string]string{{"phone": "+123"}}, login.submitted)
+}
+
+func TestLoginStream_StepMismatch(t *testing.T) {
+	ls := newTestLoginStream()
+	login := &fakeUserInputLogin{}
+	step := &bridgev2.LoginStep{Type: bridgev2.LoginStepTypeUserInput, StepID: "phone"}
+	ls.input <- &LoginStreamInput{StepID: "code", Input: map[string]string{"code": "123"}}
+
+	_, err := (&ProvisioningAPI{}).doLoginStreamStep(context.Background(), ls, login, step)
+	assert.ErrorIs(t, err, mautrix.MBadState)
+	assert.Empty(t, login.submitted)
+}
+
+func TestLoginStream_Cancelled(t *testing.T) {
+	ls := newTestLoginStream()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := ls.waitForInput(ctx, &bridgev2.LoginStep{Type: bridgev2.LoginStepTypeUserInput, StepID: "phone"})
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -77,6 +77,10 @@ type MatrixConnectorWithPostRoomBridgeHandling interface {
 	HandleNewlyBridgedRoom(ctx context.Context, roomID id.RoomID) error
 }
 
+type MatrixConnectorWithEncryptionState interface {
+	IsRoomEncrypted(ctx context.Context, roomID id.RoomID) (bool, error)
+}
+
 type MatrixConnectorWithAnalytics interface {
 	TrackAnalytics(userID id.UserID, event string, properties map[string]any)
 }
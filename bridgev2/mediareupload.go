@@ -0,0 +1,243 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/id"
+)
+
+// MediaReuploadRetries is the number of times ReuploadMedia will retry in the background after a failed download or upload.
+const MediaReuploadRetries = 3
+
+// MediaReuploadRetryBackoff is the delay before the first retry in ReuploadMedia. It's doubled after each retry.
+const MediaReuploadRetryBackoff = 2 * time.Second
+
+// ErrMediaNotRetryable can be wrapped in errors returned by MediaDownloadFunc to make ReuploadMedia fail immediately.
+var ErrMediaNotRetryable = errors.New("media reupload can't be retried")
+
+// ErrMediaReuploadPending is returned by ReuploadMedia if a previous attempt to reupload the same file failed
+// and it's currently being retried in the background.
+var ErrMediaReuploadPending = errors.New("media reupload is being retried in the background")
+
+// MediaDownloadFunc downloads a file from the remote network for ReuploadMedia.
+type MediaDownloadFunc func(ctx context.Context) (data []byte, fileName, mimeType string, err error)
+
+type mediaTransferKey struct {
+	remoteID  networkid.RemoteMediaID
+	encrypted bool
+}
+
+type mediaTransfer struct {
+	done     chan struct{}
+	media    *database.Media
+	err      error
+	retrying bool
+}
+
+func (br *Bridge) isRoomEncrypted(ctx context.Context, roomID id.RoomID) (encrypted, ok bool, err error) {
+	if roomID == "" {
+		return false, true, nil
+	}
+	checker, ok := br.Matrix.(MatrixConnectorWithEncryptionState)
+	if !ok {
+		return false, false, nil
+	}
+	encrypted, err = checker.IsRoomEncrypted(ctx, roomID)
+	return encrypted, err == nil, err
+}
+
+// ReuploadMedia reuploads a file from the remote network to Matrix, reusing previous uploads when possible.
+//
+// If the file with the given remote ID has already been uploaded (for a room with the same encryption state),
+// the previous upload is returned without calling download. Otherwise, the file is downloaded and hashed,
+// and if a file with the same hash has already been uploaded, that upload is reused. Concurrent calls with
+// the same remote ID are deduplicated.
+//
+// If the download or upload fails, the error is returned immediately, and the reupload is retried in the background
+// with exponential backoff (see MediaReuploadRetries). The download function may therefore be called after this
+// method has returned. Successful background retries are stored in the database, so later calls with the same
+// remote ID (e.g. for edits or forwards) will reuse the upload. While the retries are running,
+// calls with the same remote ID return ErrMediaReuploadPending.
+//
+// The remote ID can be empty, in which case files are only deduplicated by hash and failures aren't retried.
+func (br *Bridge) ReuploadMedia(
+	ctx context.Context,
+	intent MatrixAPI,
+	roomID id.RoomID,
+	remoteID networkid.RemoteMediaID,
+	download MediaDownloadFunc,
+) (*database.Media, error) {
+	encrypted, ok, err := br.isRoomEncrypted(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if room is encrypted: %w", err)
+	} else if !ok {
+		// The encryption state is necessary to find reusable uploads, so just upload the file normally
+		return br.downloadAndReuploadMedia(ctx, intent, roomID, nil, download)
+	}
+	key := mediaTransferKey{remoteID: remoteID, encrypted: encrypted}
+	if remoteID == "" {
+		return br.doReuploadMedia(ctx, intent, roomID, key, download)
+	}
+	br.mediaTransfersLock.Lock()
+	transfer, alreadyRunning := br.mediaTransfers[key]
+	if alreadyRunning && transfer.retrying {
+		err = transfer.err
+		br.mediaTransfersLock.Unlock()
+		return nil, fmt.Errorf("%w (last error: %w)", ErrMediaReuploadPending, err)
+	} else if !alreadyRunning {
+		transfer = &mediaTransfer{done: make(chan struct{})}
+		br.mediaTransfers[key] = transfer
+	}
+	br.mediaTransfersLock.Unlock()
+	if alreadyRunning {
+		select {
+		case <-transfer.done:
+			return transfer.media, transfer.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	media, err := br.doReuploadMedia(ctx, intent, roomID, key, download)
+	br.mediaTransfersLock.Lock()
+	transfer.media, transfer.err = media, err
+	if err != nil && !errors.Is(err, ErrMediaNotRetryable) && ctx.Err() == nil {
+		transfer.retrying = true
+		go br.retryReuploadMedia(context.WithoutCancel(ctx), intent, roomID, key, download)
+	} else {
+		delete(br.mediaTransfers, key)
+	}
+	br.mediaTransfersLock.Unlock()
+	close(transfer.done)
+	return media, err
+}
+
+func (br *Bridge) retryReuploadMedia(
+	ctx context.Context, intent MatrixAPI, roomID id.RoomID, key mediaTransferKey, download MediaDownloadFunc,
+) {
+	log := zerolog.Ctx(ctx).With().
+		Str("action", "retry media reupload").
+		Str("remote_media_id", string(key.remoteID)).
+		Logger()
+	ctx = log.WithContext(ctx)
+	defer func() {
+		br.mediaTransfersLock.Lock()
+		delete(br.mediaTransfers, key)
+		br.mediaTransfersLock.Unlock()
+	}()
+	backoff := MediaReuploadRetryBackoff
+	for attempt := 1; attempt <= MediaReuploadRetries; attempt++ {
+		select {
+		case <-time.After(backoff):
+		case <-br.stopMediaRetries:
+			return
+		}
+		_, err := br.downloadAndReuploadMedia(ctx, intent, roomID, &key, download)
+		if err == nil {
+			log.Debug().Int("attempt", attempt).Msg("Media reupload succeeded after retrying")
+			return
+		} else if errors.Is(err, ErrMediaNotRetryable) {
+			log.Warn().Err(err).Int("attempt", attempt).Msg("Media reupload failed, not retrying")
+			return
+		}
+		log.Warn().Err(err).
+			Int("attempt", attempt).
+			Stringer("retry_in", backoff*2).
+			Msg("Failed to reupload media")
+		br.mediaTransfersLock.Lock()
+		br.mediaTransfers[key].err = err
+		br.mediaTransfersLock.Unlock()
+		backoff *= 2
+	}
+	log.Error().Msg("Giving up on reuploading media")
+}
+
+func (br *Bridge) doReuploadMedia(
+	ctx context.Context, intent MatrixAPI, roomID id.RoomID, key mediaTransferKey, download MediaDownloadFunc,
+) (*database.Media, error) {
+	log := zerolog.Ctx(ctx).With().
+		Str("action", "reupload media").
+		Str("remote_media_id", string(key.remoteID)).
+		Logger()
+	ctx = log.WithContext(ctx)
+	if key.remoteID != "" {
+		existing, err := br.DB.Media.GetByRemoteID(ctx, key.remoteID, key.encrypted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get existing media from database: %w", err)
+		} else if existing != nil {
+			log.Debug().Str("mxc", string(existing.MXC)).Msg("Reusing previously uploaded media")
+			return existing, nil
+		}
+	}
+	return br.downloadAndReuploadMedia(ctx, intent, roomID, &key, download)
+}
+
+func (br *Bridge) downloadAndReuploadMedia(
+	ctx context.Context, intent MatrixAPI, roomID id.RoomID, key *mediaTransferKey, download MediaDownloadFunc,
+) (*database.Media, error) {
+	data, fileName, mimeType, err := download(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download media: %w", err)
+	}
+	hash := sha256.Sum256(data)
+	var media *database.Media
+	if key != nil {
+		media, err = br.DB.Media.GetByHash(ctx, hash, key.encrypted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get media by hash from database: %w", err)
+		} else if media != nil {
+			zerolog.Ctx(ctx).Debug().
+				Str("mxc", string(media.MXC)).
+				Str("original_remote_media_id", string(media.RemoteID)).
+				Msg("Found previously uploaded media with same hash")
+		}
+	}
+	if media == nil {
+		media = &database.Media{
+			SHA256:   hash,
+			FileName: fileName,
+			MimeType: mimeType,
+			Size:     int64(len(data)),
+		}
+		media.MXC, media.File, err = intent.UploadMedia(ctx, roomID, data, fileName, mimeType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload media: %w", err)
+		}
+		if key != nil {
+			// Store the room encryption state that lookups use, even if the upload itself wasn't encrypted
+			media.Encrypted = key.encrypted
+		} else {
+			media.Encrypted = media.File != nil
+		}
+	} else {
+		reused := *media
+		media = &reused
+	}
+	media.CreatedAt = time.Now()
+	if key == nil {
+		return media, nil
+	}
+	media.RemoteID = key.remoteID
+	if media.RemoteID == "" {
+		media.RemoteID = networkid.RemoteMediaID("sha256:" + hex.EncodeToString(hash[:]))
+	}
+	err = br.DB.Media.Put(ctx, media)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to save reuploaded media to database")
+	}
+	return media, nil
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// MaxMessageRetryAge is the maximum age of a failed Matrix event that can still be retried using Portal.RetryMatrixEvent.
+const MaxMessageRetryAge = 24 * time.Hour
+
+// MaxMessageRetries is the maximum number of times a single failed Matrix event can be retried.
+const MaxMessageRetries = 5
+
+var (
+	ErrRetryTargetNotFound = errors.New("event not found in failed event list")
+	ErrRetryTargetTooOld   = errors.New("event is too old to be retried")
+	ErrTooManyRetries      = errors.New("event has been retried too many times")
+	ErrRetryNotAllowed     = errors.New("only the sender of the event can retry it")
+)
+
+func (portal *Portal) rememberFailedEvent(ctx context.Context, evt *event.Event, status *MessageStatus) {
+	if evt.ID == "" || evt.Mautrix.EventSource&event.SourceEphemeral != 0 || status.Status != event.MessageStatusRetriable {
+		return
+	}
+	log := zerolog.Ctx(ctx)
+	portal.failedEventsLock.Lock()
+	defer portal.failedEventsLock.Unlock()
+	now := time.Now()
+	err := portal.Bridge.DB.FailedEvent.DeleteOlderThan(ctx, now.Add(-MaxMessageRetryAge))
+	if err != nil {
+		log.Err(err).Msg("Failed to delete old failed events")
+	}
+	failed, err := portal.Bridge.DB.FailedEvent.Get(ctx, evt.ID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get previous failure of event")
+		return
+	} else if failed == nil {
+		failed = &database.FailedEvent{
+			EventID: evt.ID,
+			Room:    portal.PortalKey,
+			Event:   evt,
+		}
+	}
+	failed.FailedAt = now
+	err = portal.Bridge.DB.FailedEvent.Put(ctx, failed)
+	if err != nil {
+		log.Err(err).Msg("Failed to save failed event")
+	}
+	status.RetryNum = failed.RetryCount
+}
+
+func (portal *Portal) forgetFailedEvent(ctx context.Context, evtID id.EventID) int {
+	portal.failedEventsLock.Lock()
+	defer portal.failedEventsLock.Unlock()
+	retryCount, err := portal.Bridge.DB.FailedEvent.Delete(ctx, evtID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("failed_event_id", evtID).Msg("Failed to delete failed event")
+	}
+	return retryCount
+}
+
+// RetryMatrixEvent re-queues a Matrix event that previously failed to be bridged with a retriable error.
+//
+// The user must be the original sender of the event or a bridge admin.
+func (portal *Portal) RetryMatrixEvent(ctx context.Context, user *User, evtID id.EventID) error {
+	portal.failedEventsLock.Lock()
+	failed, err := portal.Bridge.DB.FailedEvent.Get(ctx, evtID)
+	if err != nil {
+		portal.failedEventsLock.Unlock()
+		return fmt.Errorf("failed to get failed event from database: %w", err)
+	} else if failed == nil || failed.Room != portal.PortalKey || failed.Event == nil {
+		portal.failedEventsLock.Unlock()
+		return ErrRetryTargetNotFound
+	} else if failed.Event.Sender != user.MXID && !user.Permissions.Admin {
+		portal.failedEventsLock.Unlock()
+		return ErrRetryNotAllowed
+	} else if time.Since(time.UnixMilli(failed.Event.Timestamp)) > MaxMessageRetryAge {
+		_, err = portal.Bridge.DB.FailedEvent.Delete(ctx, evtID)
+		portal.failedEventsLock.Unlock()
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Msg("Failed to delete expired failed event")
+		}
+		return ErrRetryTargetTooOld
+	} else if failed.RetryCount >= MaxMessageRetries {
+		portal.failedEventsLock.Unlock()
+		return ErrTooManyRetries
+	}
+	failed.RetryCount++
+	err = portal.Bridge.DB.FailedEvent.Put(ctx, failed)
+	portal.failedEventsLock.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to save retry count: %w", err)
+	}
+	sender := user
+	if failed.Event.Sender != user.MXID {
+		sender, err = portal.Bridge.GetUserByMXID(ctx, failed.Event.Sender)
+		if err != nil {
+			return fmt.Errorf("failed to get sender of event: %w", err)
+		}
+	}
+	portal.Bridge.Matrix.SendMessageStatus(ctx, &MessageStatus{
+		Status:   event.MessageStatusPending,
+		RetryNum: failed.RetryCount,
+	}, StatusEventInfoFromEvent(failed.Event))
+	portal.queueEvent(ctx, &portalMatrixEvent{
+		evt:    failed.Event,
+		sender: sender,
+	})
+	return nil
+}
@@ -26,6 +26,9 @@ type MessageStatusEventInfo struct {
 	Sender        id.UserID
 	ThreadRoot    id.EventID
 	StreamOrder   int64
+
+	OriginalEventID  id.EventID
+	ManualRetryCount int
 }
 
 func StatusEventInfoFromEvent(evt *event.Event) *MessageStatusEventInfo {
@@ -33,7 +36,7 @@ func StatusEventInfoFromEvent(evt *event.Event) *MessageStatusEventInfo {
 	if relatable, ok := evt.Content.Parsed.(event.Relatable); ok {
 		threadRoot = relatable.OptionalGetRelatesTo().GetThreadParent()
 	}
-	return &MessageStatusEventInfo{
+	info := &MessageStatusEventInfo{
 		RoomID:        evt.RoomID,
 		SourceEventID: evt.ID,
 		EventType:     evt.Type,
@@ -41,6 +44,11 @@ func StatusEventInfoFromEvent(evt *event.Event) *MessageStatusEventInfo {
 		Sender:        evt.Sender,
 		ThreadRoot:    threadRoot,
 	}
+	if retryMeta := evt.Content.AsMessage().MessageSendRetry; retryMeta != nil {
+		info.OriginalEventID = retryMeta.OriginalEventID
+		info.ManualRetryCount = retryMeta.RetryCount
+	}
+	return info
 }
 
 // MessageStatus represents the status of a message. It also implements the error interface to allow network connectors
@@ -159,6 +167,9 @@ func (ms *MessageStatus) ToCheckpoint(evt *MessageStatusEventInfo) *status.Messa
 		ReportedBy:  status.MsgReportedByBridge,
 		EventType:   evt.EventType,
 		MessageType: evt.MessageType,
+
+		OriginalEventID:  evt.OriginalEventID,
+		ManualRetryCount: evt.ManualRetryCount,
 	}
 	if ms.InternalError != nil {
 		checkpoint.Info = ms.InternalError.Error()
@@ -0,0 +1,148 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"maunium.net/go/mautrix/appservice"
+)
+
+type MessageDirection string
+
+const (
+	DirectionMatrixToRemote MessageDirection = "matrix_to_remote"
+	DirectionRemoteToMatrix MessageDirection = "remote_to_matrix"
+)
+
+// Metrics contains counters and histograms about the bridge's operation.
+//
+// The metrics are always collected and can be exposed for Prometheus scraping by mounting the struct as a HTTP handler.
+// When using the default Matrix connector, this is done automatically if metrics are enabled in the config.
+type Metrics struct {
+	DecryptionErrors   atomic.Uint64
+	BackfilledMessages atomic.Uint64
+	BackfillBatches    atomic.Uint64
+
+	BackfillBatchDuration *appservice.Histogram
+
+	messages           map[MessageDirection]uint64
+	conversionFailures map[MessageDirection]uint64
+	remoteAPI          map[string]*appservice.Histogram
+	lock               sync.Mutex
+}
+
+var _ http.Handler = (*Metrics)(nil)
+
+var remoteAPIBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		BackfillBatchDuration: appservice.NewHistogram(0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120),
+
+		messages:           make(map[MessageDirection]uint64),
+		conversionFailures: make(map[MessageDirection]uint64),
+		remoteAPI:          make(map[string]*appservice.Histogram),
+	}
+}
+
+// CountMessage counts a message that was successfully bridged in the given direction.
+func (m *Metrics) CountMessage(direction MessageDirection) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	m.messages[direction]++
+	m.lock.Unlock()
+}
+
+// CountConversionFailure counts a message that failed to be converted or sent in the given direction.
+func (m *Metrics) CountConversionFailure(direction MessageDirection) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	m.conversionFailures[direction]++
+	m.lock.Unlock()
+}
+
+// CountDecryptionError counts a Matrix event that the bridge failed to decrypt.
+func (m *Metrics) CountDecryptionError() {
+	if m == nil {
+		return
+	}
+	m.DecryptionErrors.Add(1)
+}
+
+// ObserveRemoteAPI records the duration of a call to the network connector.
+func (m *Metrics) ObserveRemoteAPI(method string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	hist, ok := m.remoteAPI[method]
+	if !ok {
+		hist = appservice.NewHistogram(remoteAPIBuckets...)
+		m.remoteAPI[method] = hist
+	}
+	m.lock.Unlock()
+	hist.Observe(duration.Seconds())
+}
+
+// ObserveBackfillBatch records a batch of backfilled messages that was sent to Matrix.
+func (m *Metrics) ObserveBackfillBatch(messageCount int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.BackfillBatches.Add(1)
+	m.BackfilledMessages.Add(uint64(messageCount))
+	m.BackfillBatchDuration.Observe(duration.Seconds())
+}
+
+func writeDirectionCounter(w io.Writer, name, help string, values map[MessageDirection]uint64) {
+	appservice.WriteMetricHeader(w, name, "counter", help)
+	for _, direction := range []MessageDirection{DirectionMatrixToRemote, DirectionRemoteToMatrix} {
+		_, _ = fmt.Fprintf(w, "%s{direction=%q} %d\n", name, direction, values[direction])
+	}
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	appservice.PrometheusHandler(m).ServeHTTP(w, r)
+}
+
+// WritePrometheus writes all metrics to the given writer in the Prometheus text exposition format.
+func (m *Metrics) WritePrometheus(w io.Writer) {
+	m.lock.Lock()
+	writeDirectionCounter(w, "mautrix_bridge_messages_total", "Number of messages bridged", m.messages)
+	writeDirectionCounter(w, "mautrix_bridge_conversion_failures_total", "Number of messages that failed to be converted or sent", m.conversionFailures)
+	methods := make([]string, 0, len(m.remoteAPI))
+	for method := range m.remoteAPI {
+		methods = append(methods, method)
+	}
+	remoteAPI := make([]*appservice.Histogram, len(methods))
+	slices.Sort(methods)
+	for i, method := range methods {
+		remoteAPI[i] = m.remoteAPI[method]
+	}
+	m.lock.Unlock()
+
+	appservice.WriteCounter(w, "mautrix_bridge_decryption_errors_total", "Number of Matrix events that the bridge failed to decrypt", m.DecryptionErrors.Load())
+	appservice.WriteMetricHeader(w, "mautrix_bridge_remote_api_duration_seconds", "histogram", "Time taken by calls to the remote network")
+	for i, method := range methods {
+		remoteAPI[i].WriteSamples(w, "mautrix_bridge_remote_api_duration_seconds", fmt.Sprintf("method=%q", method))
+	}
+	appservice.WriteCounter(w, "mautrix_bridge_backfill_batches_total", "Number of backfill batches sent to Matrix", m.BackfillBatches.Load())
+	appservice.WriteCounter(w, "mautrix_bridge_backfill_messages_total", "Number of backfilled messages sent to Matrix", m.BackfilledMessages.Load())
+	m.BackfillBatchDuration.WriteMetric(w, "mautrix_bridge_backfill_batch_duration_seconds", "Time taken to send a backfill batch to Matrix")
+}
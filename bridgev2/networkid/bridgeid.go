@@ -139,3 +139,10 @@ type EmojiID string
 // to generate a content URI from a media ID. Then, when the Matrix connector wants to download the media,
 // it will parse the content URI and ask the network connector for the data using the media ID.
 type MediaID []byte
+
+// RemoteMediaID is a stable identifier for a file on the remote network.
+//
+// It's used to remember which Matrix content URI a remote file was reuploaded to,
+// so that the same file doesn't have to be downloaded and reuploaded again when it's
+// sent to another chat, forwarded or included in an edit.
+type RemoteMediaID string
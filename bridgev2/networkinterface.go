@@ -9,6 +9,7 @@ package bridgev2
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -340,6 +341,57 @@ type NetworkRoomCapabilities struct {
 	AllowedReactions []string
 }
 
+// ToRoomFeatures converts the capabilities into a com.beeper.room_features state event content.
+func (caps *NetworkRoomCapabilities) ToRoomFeatures() *event.RoomFeaturesEventContent {
+	features := &event.RoomFeaturesEventContent{
+		MessageTypes:     []event.MessageType{event.MsgText, event.MsgNotice, event.MsgEmote},
+		FormattedText:    caps.FormattedText,
+		UserMentions:     caps.UserMentions,
+		RoomMentions:     caps.RoomMentions,
+		Captions:         caps.Captions,
+		Polls:            caps.Polls,
+		MaxTextLength:    caps.MaxTextLength,
+		MaxCaptionLength: caps.MaxCaptionLength,
+		Threads:          caps.Threads,
+		Replies:          caps.Replies,
+		Edits:            caps.Edits,
+		EditMaxCount:     caps.EditMaxCount,
+		EditMaxAge:       int64(caps.EditMaxAge.Seconds()),
+		Deletes:          caps.Deletes,
+		DeleteMaxAge:     int64(caps.DeleteMaxAge.Seconds()),
+		ReadReceipts:     caps.ReadReceipts,
+		Reactions:        caps.Reactions,
+		ReactionCount:    caps.ReactionCount,
+		AllowedReactions: caps.AllowedReactions,
+	}
+	if caps.LocationMessages {
+		features.MessageTypes = append(features.MessageTypes, event.MsgLocation)
+	}
+	fileTypes := []event.MessageType{event.MsgImage, event.MsgVideo, event.MsgAudio, event.MsgFile}
+	if len(caps.Files) > 0 {
+		fileTypes = fileTypes[:0]
+		for msgType := range caps.Files {
+			fileTypes = append(fileTypes, msgType)
+		}
+		slices.Sort(fileTypes)
+	}
+	features.Files = make(map[event.MessageType]*event.RoomFeaturesFileLimits, len(fileTypes))
+	for _, msgType := range fileTypes {
+		features.MessageTypes = append(features.MessageTypes, msgType)
+		restriction, ok := caps.Files[msgType]
+		if !ok && caps.DefaultFileRestriction != nil {
+			restriction, ok = *caps.DefaultFileRestriction, true
+		}
+		if ok {
+			features.Files[msgType] = &event.RoomFeaturesFileLimits{
+				MaxSize:   restriction.MaxSize,
+				MimeTypes: restriction.MimeTypes,
+			}
+		}
+	}
+	return features
+}
+
 // NetworkAPI is an interface representing a remote network client for a single user login.
 //
 // Implementations of this interface are stored in [UserLogin.Client].
@@ -566,6 +618,16 @@ type RedactionHandlingNetworkAPI interface {
 	HandleMatrixMessageRemove(ctx context.Context, msg *MatrixMessageRemove) error
 }
 
+// DisappearingMessageDeletingNetworkAPI is an optional interface that network connectors can implement
+// if the remote network doesn't delete disappearing messages by itself.
+type DisappearingMessageDeletingNetworkAPI interface {
+	NetworkAPI
+	// HandleDisappearedMessage is called after a disappearing message timer expires and the message has been
+	// redacted on Matrix. The connector should delete the message on the remote network if necessary.
+	// The message part is the one the timer was stored for, other parts may exist in the database.
+	HandleDisappearedMessage(ctx context.Context, portal *Portal, msg *database.Message) error
+}
+
 // ReadReceiptHandlingNetworkAPI is an optional interface that network connectors can implement to handle read receipts.
 type ReadReceiptHandlingNetworkAPI interface {
 	NetworkAPI
@@ -1154,6 +1216,9 @@ type MatrixReaction struct {
 	MatrixEventBase[*event.ReactionEventContent]
 	TargetMessage *database.Message
 	PreHandleResp *MatrixReactionPreResponse
+	// If the reaction key is a custom emoji that was previously bridged from the remote network,
+	// this is the stored mapping, which can be used to find the remote emoji ID.
+	CustomEmoji *database.CustomEmoji
 
 	// When EmojiID is blank and there's already an existing reaction, this is the old reaction that is being overridden.
 	ReactionToOverride *database.Reaction
@@ -0,0 +1,269 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/event"
+)
+
+// OutgoingQueueLease is how long a dispatched queue entry is reserved for the portal event loop.
+// If the event doesn't produce a success or error status within this time, it will be dispatched again.
+// Every dispatch counts as an attempt, so entries whose leases keep expiring are dropped after MaxAttempts.
+const OutgoingQueueLease = 10 * time.Minute
+const OutgoingQueuePollInterval = 5 * time.Second
+const OutgoingQueueBatchSize = 50
+
+var queueableEventTypes = []event.Type{
+	event.EventMessage, event.EventSticker, event.EventReaction, event.EventRedaction,
+	event.EventUnstablePollStart, event.EventUnstablePollResponse,
+}
+
+func isQueueableEvent(evt *event.Event) bool {
+	return evt.ID != "" &&
+		evt.StateKey == nil &&
+		evt.Mautrix.EventSource&event.SourceEphemeral == 0 &&
+		slices.Contains(queueableEventTypes, evt.Type)
+}
+
+func (br *Bridge) WakeupOutgoingQueue() {
+	select {
+	case br.wakeupOutgoingQueue <- struct{}{}:
+	default:
+	}
+}
+
+func (br *Bridge) RunOutgoingQueue() {
+	if !br.Config.OutgoingQueue.Enabled {
+		return
+	}
+	log := br.Log.With().Str("component", "outgoing queue").Logger()
+	ctx := log.WithContext(context.Background())
+	log.Info().Msg("Outgoing message queue starting")
+	for {
+		br.dispatchOutgoingQueue(ctx)
+		select {
+		case <-br.wakeupOutgoingQueue:
+		case <-time.After(OutgoingQueuePollInterval):
+		case <-br.stopOutgoingQueue:
+			log.Info().Msg("Stopping outgoing message queue")
+			return
+		}
+	}
+}
+
+func (br *Bridge) dispatchOutgoingQueue(ctx context.Context) {
+	defer func() {
+		err := recover()
+		if err != nil {
+			zerolog.Ctx(ctx).Error().
+				Bytes(zerolog.ErrorStackFieldName, debug.Stack()).
+				Any(zerolog.ErrorFieldName, err).
+				Msg("Panic in outgoing message queue")
+		}
+	}()
+	entries, err := br.DB.OutgoingQueue.GetNextDue(ctx, OutgoingQueueBatchSize)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get next outgoing queue entries")
+		return
+	}
+	for _, entry := range entries {
+		br.dispatchOutgoingQueueEntry(ctx, entry)
+	}
+}
+
+func (br *Bridge) dispatchOutgoingQueueEntry(ctx context.Context, entry *database.OutgoingQueueEntry) {
+	log := zerolog.Ctx(ctx).With().
+		Stringer("event_id", entry.EventID).
+		Object("portal_key", entry.Room).
+		Int("attempts", entry.Attempts).
+		Logger()
+	portal, err := br.GetExistingPortalByKey(ctx, entry.Room)
+	if err != nil {
+		log.Err(err).Msg("Failed to get portal for outgoing queue entry")
+		return
+	}
+	var sender *User
+	if portal != nil && portal.MXID != "" && entry.Event != nil {
+		sender, err = br.GetUserByMXID(ctx, entry.SenderMXID)
+		if err != nil {
+			log.Err(err).Msg("Failed to get sender for outgoing queue entry")
+			return
+		}
+	}
+	if sender == nil {
+		log.Warn().Msg("Dropping outgoing queue entry as the portal or event is gone")
+		err = br.DB.OutgoingQueue.Delete(ctx, entry.EventID)
+		if err != nil {
+			log.Err(err).Msg("Failed to delete outgoing queue entry")
+		}
+		return
+	}
+	if entry.Attempts >= max(br.Config.OutgoingQueue.MaxAttempts, 1) {
+		// Attempts are counted on dispatch, so getting here means the last lease expired without a result
+		log.Warn().Str("last_error", entry.LastError).Msg("Giving up on queued outgoing event after lease expired")
+		err = br.DB.OutgoingQueue.Delete(ctx, entry.EventID)
+		if err != nil {
+			log.Err(err).Msg("Failed to delete expired event from outgoing queue")
+			return
+		}
+		br.Matrix.SendMessageStatus(ctx, &MessageStatus{
+			Status:      event.MessageStatusFail,
+			ErrorReason: event.MessageStatusGenericError,
+			RetryNum:    entry.Attempts,
+			Message:     fmt.Sprintf("Message failed to send after %d attempts", entry.Attempts),
+		}, StatusEventInfoFromEvent(entry.Event))
+		return
+	}
+	entry.Attempts++
+	entry.NextAttemptAt = time.Now().Add(OutgoingQueueLease)
+	err = br.DB.OutgoingQueue.Put(ctx, entry)
+	if err != nil {
+		log.Err(err).Msg("Failed to mark outgoing queue entry as dispatched")
+		return
+	}
+	log.Debug().Msg("Dispatching outgoing queue entry")
+	portal.outgoingQueueLock.Lock()
+	portal.outgoingQueueEntries[entry.EventID] = entry
+	portal.outgoingQueueLock.Unlock()
+	portal.queueEvent(ctx, &portalMatrixEvent{
+		evt:       entry.Event,
+		sender:    sender,
+		fromQueue: true,
+	})
+}
+
+func (br *Bridge) outgoingQueueBackoff(attempts int) time.Duration {
+	cfg := &br.Config.OutgoingQueue
+	backoff := time.Duration(max(cfg.InitialBackoff, 1)) * time.Second
+	maxBackoff := time.Duration(max(cfg.MaxBackoff, 1)) * time.Second
+	for i := 1; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxBackoff)
+}
+
+// enqueueIfBlocked adds the event to the end of the outgoing queue if the portal already has queued events,
+// so that it won't be sent before the older messages.
+func (portal *Portal) enqueueIfBlocked(ctx context.Context, sender *User, evt *event.Event) bool {
+	if !portal.Bridge.Config.OutgoingQueue.Enabled || !isQueueableEvent(evt) {
+		return false
+	}
+	blocked, err := portal.Bridge.DB.OutgoingQueue.HasPending(ctx, portal.PortalKey)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to check if portal has pending outgoing messages")
+		return false
+	} else if !blocked {
+		return false
+	}
+	now := time.Now()
+	err = portal.Bridge.DB.OutgoingQueue.Put(ctx, &database.OutgoingQueueEntry{
+		EventID:       evt.ID,
+		Room:          portal.PortalKey,
+		SenderMXID:    sender.MXID,
+		Event:         evt,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to add event to outgoing queue")
+		return false
+	}
+	zerolog.Ctx(ctx).Debug().Msg("Portal has pending outgoing messages, added event to queue")
+	portal.Bridge.Matrix.SendMessageStatus(ctx, &MessageStatus{
+		Status:  event.MessageStatusPending,
+		Message: "Waiting for previous messages to be sent",
+	}, StatusEventInfoFromEvent(evt))
+	portal.Bridge.WakeupOutgoingQueue()
+	return true
+}
+
+func (portal *Portal) takeOutgoingQueueEntry(evt *event.Event) *database.OutgoingQueueEntry {
+	portal.outgoingQueueLock.Lock()
+	defer portal.outgoingQueueLock.Unlock()
+	entry, ok := portal.outgoingQueueEntries[evt.ID]
+	if ok {
+		delete(portal.outgoingQueueEntries, evt.ID)
+	}
+	return entry
+}
+
+func (portal *Portal) markOutgoingQueueSuccess(ctx context.Context, evt *event.Event) {
+	entry := portal.takeOutgoingQueueEntry(evt)
+	if entry == nil {
+		return
+	}
+	err := portal.Bridge.DB.OutgoingQueue.Delete(ctx, entry.EventID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to delete sent event from outgoing queue")
+	}
+	portal.Bridge.WakeupOutgoingQueue()
+}
+
+// updateOutgoingQueue stores a failed event in the outgoing queue, or updates the existing queue entry.
+// The status is changed to pending if the event will be retried, or to a permanent failure if it won't.
+func (portal *Portal) updateOutgoingQueue(ctx context.Context, evt *event.Event, status *MessageStatus) {
+	cfg := &portal.Bridge.Config.OutgoingQueue
+	entry := portal.takeOutgoingQueueEntry(evt)
+	if entry == nil {
+		// Errors that are certain are usually caused by the event itself, so retrying won't help
+		if !cfg.Enabled || status.Status != event.MessageStatusRetriable || status.IsCertain || !isQueueableEvent(evt) {
+			return
+		}
+		sender, err := portal.Bridge.GetUserByMXID(ctx, evt.Sender)
+		if err != nil || sender == nil {
+			return
+		}
+		entry = &database.OutgoingQueueEntry{
+			EventID:    evt.ID,
+			Room:       portal.PortalKey,
+			SenderMXID: evt.Sender,
+			Event:      evt,
+			// The initial send counts as the first attempt
+			Attempts:  1,
+			CreatedAt: time.Now(),
+		}
+	}
+	// Entries from the queue already include the failed attempt, as attempts are counted when dispatching
+	log := zerolog.Ctx(ctx).With().Int("attempts", entry.Attempts).Logger()
+	if status.InternalError != nil {
+		entry.LastError = status.InternalError.Error()
+	}
+	if status.Status != event.MessageStatusRetriable || entry.Attempts >= max(cfg.MaxAttempts, 1) {
+		log.Warn().Str("last_error", entry.LastError).Msg("Giving up on queued outgoing event")
+		err := portal.Bridge.DB.OutgoingQueue.Delete(ctx, entry.EventID)
+		if err != nil {
+			log.Err(err).Msg("Failed to delete failed event from outgoing queue")
+		}
+		status.Status = event.MessageStatusFail
+		status.RetryNum = entry.Attempts
+		if status.Message == "" {
+			status.Message = fmt.Sprintf("Message failed to send after %d attempts", entry.Attempts)
+		}
+		portal.Bridge.WakeupOutgoingQueue()
+		return
+	}
+	backoff := portal.Bridge.outgoingQueueBackoff(entry.Attempts)
+	entry.NextAttemptAt = time.Now().Add(backoff)
+	err := portal.Bridge.DB.OutgoingQueue.Put(ctx, entry)
+	if err != nil {
+		log.Err(err).Msg("Failed to save event to outgoing queue")
+		return
+	}
+	log.Debug().Stringer("retry_in", backoff).Msg("Added failed event to outgoing queue")
+	status.Status = event.MessageStatusPending
+	status.RetryNum = entry.Attempts
+	status.SendNotice = false
+}
@@ -9,6 +9,8 @@ package bridgev2
 import (
 	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"runtime/debug"
@@ -33,8 +35,9 @@ import (
 )
 
 type portalMatrixEvent struct {
-	evt    *event.Event
-	sender *User
+	evt       *event.Event
+	sender    *User
+	fromQueue bool
 }
 
 type portalRemoteEvent struct {
@@ -81,6 +84,20 @@ type Portal struct {
 
 	roomCreateLock sync.Mutex
 
+	roomFeaturesLock sync.Mutex
+
+	failedEventsLock sync.Mutex
+
+	// droppedBackfillAnchor is the oldest message of the previous backwards backfill batch
+	// if it wasn't saved to the database (e.g. because middleware dropped it).
+	droppedBackfillAnchor atomic.Pointer[database.Message]
+
+	matrixReceipts *receiptBatcher
+	remoteReceipts *receiptBatcher
+
+	outgoingQueueEntries map[id.EventID]*database.OutgoingQueueEntry
+	outgoingQueueLock    sync.Mutex
+
 	events chan portalEvent
 }
 
@@ -110,7 +127,9 @@ func (br *Bridge) loadPortal(ctx context.Context, dbPortal *database.Portal, que
 		events:                make(chan portalEvent, PortalEventBuffer),
 		currentlyTypingLogins: make(map[id.UserID]*UserLogin),
 		outgoingMessages:      make(map[networkid.TransactionID]outgoingMessage),
+		outgoingQueueEntries:  make(map[id.EventID]*database.OutgoingQueueEntry),
 	}
+	portal.initReceiptBatchers()
 	br.portalsByKey[portal.PortalKey] = portal
 	if portal.MXID != "" {
 		br.portalsByMXID[portal.MXID] = portal
@@ -350,6 +369,10 @@ func (portal *Portal) getEventCtxWithLog(rawEvt any, idx int) context.Context {
 		logWith = evt.evt.AddLogContext(logWith)
 	case *portalCreateEvent:
 		return evt.ctx
+	case *portalReceiptFlushEvent:
+		logWith = portal.Log.With().Int("event_loop_index", idx).
+			Str("action", "flush read receipt").
+			Stringer("receipt_user_id", evt.key.userID)
 	}
 	return logWith.Logger().WithContext(context.Background())
 }
@@ -380,11 +403,16 @@ func (portal *Portal) handleSingleEvent(ctx context.Context, rawEvt any, doneCal
 	}()
 	switch evt := rawEvt.(type) {
 	case *portalMatrixEvent:
+		if !evt.fromQueue && portal.enqueueIfBlocked(ctx, evt.sender, evt.evt) {
+			return
+		}
 		portal.handleMatrixEvent(ctx, evt.sender, evt.evt)
 	case *portalRemoteEvent:
 		portal.handleRemoteEvent(ctx, evt.source, evt.evtType, evt.evt)
 	case *portalCreateEvent:
 		evt.cb(portal.createMatrixRoomInLoop(evt.ctx, evt.source, evt.info, nil))
+	case *portalReceiptFlushEvent:
+		evt.batcher.flush(evt.key, evt.pending)
 	default:
 		panic(fmt.Errorf("illegal type %T in eventLoop", evt))
 	}
@@ -446,7 +474,11 @@ func (portal *Portal) sendSuccessStatus(ctx context.Context, evt *event.Event, s
 	if newEventID != evt.ID {
 		info.NewEventID = newEventID
 	}
-	portal.Bridge.Matrix.SendMessageStatus(ctx, &MessageStatus{Status: event.MessageStatusSuccess}, info)
+	portal.markOutgoingQueueSuccess(ctx, evt)
+	portal.Bridge.Matrix.SendMessageStatus(ctx, &MessageStatus{
+		Status:   event.MessageStatusSuccess,
+		RetryNum: portal.forgetFailedEvent(ctx, evt.ID),
+	}, info)
 }
 
 func (portal *Portal) sendErrorStatus(ctx context.Context, evt *event.Event, err error) {
@@ -460,6 +492,8 @@ func (portal *Portal) sendErrorStatus(ctx context.Context, evt *event.Event, err
 	if status.InternalError == nil {
 		status.InternalError = err
 	}
+	portal.updateOutgoingQueue(ctx, evt, &status)
+	portal.rememberFailedEvent(ctx, evt, &status)
 	portal.Bridge.Matrix.SendMessageStatus(ctx, &status, StatusEventInfoFromEvent(evt))
 }
 
@@ -579,7 +613,9 @@ func (portal *Portal) handleMatrixReceipts(ctx context.Context, evt *event.Event
 				// TODO log
 				return
 			}
-			portal.handleMatrixReadReceipt(ctx, sender, evtID, receipt)
+			portal.matrixReceipts.add(receiptKey{userID: userID, threadID: receipt.ThreadID}, func() {
+				portal.handleMatrixReadReceipt(ctx, sender, evtID, receipt)
+			})
 		}
 	}
 }
@@ -795,6 +831,10 @@ func (portal *Portal) handleMatrixMessage(ctx context.Context, sender *UserLogin
 		return
 	}
 	caps := sender.Client.GetCapabilities(ctx, portal)
+	if msgContent != nil && msgContent.MessageSendRetry != nil {
+		// The client resent a failed message as a new event, so the original shouldn't be retried by the bridge anymore
+		portal.forgetFailedEvent(ctx, msgContent.MessageSendRetry.OriginalEventID)
+	}
 
 	if relatesTo.GetReplaceID() != "" {
 		if msgContent == nil {
@@ -896,30 +936,39 @@ func (portal *Portal) handleMatrixMessage(ctx context.Context, sender *UserLogin
 		ThreadRoot: threadRoot,
 		ReplyTo:    replyTo,
 	}
-	var resp *MatrixMessageResponse
+	var handler MatrixMessageHandler
 	if msgContent != nil {
-		resp, err = sender.Client.HandleMatrixMessage(ctx, wrappedMsgEvt)
+		handler = sender.Client.HandleMatrixMessage
 	} else if pollContent != nil {
-		resp, err = sender.Client.(PollHandlingNetworkAPI).HandleMatrixPollStart(ctx, &MatrixPollStart{
-			MatrixMessage: *wrappedMsgEvt,
-			Content:       pollContent,
-		})
+		handler = func(ctx context.Context, msg *MatrixMessage) (*MatrixMessageResponse, error) {
+			return sender.Client.(PollHandlingNetworkAPI).HandleMatrixPollStart(ctx, &MatrixPollStart{
+				MatrixMessage: *msg,
+				Content:       pollContent,
+			})
+		}
 	} else if pollResponseContent != nil {
-		resp, err = sender.Client.(PollHandlingNetworkAPI).HandleMatrixPollVote(ctx, &MatrixPollVote{
-			MatrixMessage: *wrappedMsgEvt,
-			VoteTo:        voteTo,
-			Content:       pollResponseContent,
-		})
+		handler = func(ctx context.Context, msg *MatrixMessage) (*MatrixMessageResponse, error) {
+			return sender.Client.(PollHandlingNetworkAPI).HandleMatrixPollVote(ctx, &MatrixPollVote{
+				MatrixMessage: *msg,
+				VoteTo:        voteTo,
+				Content:       pollResponseContent,
+			})
+		}
 	} else {
 		log.Error().Msg("Failed to handle Matrix message: all contents are nil?")
 		portal.sendErrorStatus(ctx, evt, fmt.Errorf("all contents are nil"))
 		return
 	}
+	handleStart := time.Now()
+	resp, err := portal.Bridge.wrapMatrixMessageHandler(handler)(ctx, wrappedMsgEvt)
+	portal.Bridge.Metrics.ObserveRemoteAPI("handle_matrix_message", time.Since(handleStart))
 	if err != nil {
 		log.Err(err).Msg("Failed to handle Matrix message")
+		portal.Bridge.Metrics.CountConversionFailure(DirectionMatrixToRemote)
 		portal.sendErrorStatus(ctx, evt, err)
 		return
 	}
+	portal.Bridge.Metrics.CountMessage(DirectionMatrixToRemote)
 	message := wrappedMsgEvt.fillDBMessage(resp.DB)
 	if !resp.Pending {
 		if resp.DB == nil {
@@ -1077,7 +1126,7 @@ func (portal *Portal) handleMatrixEdit(ctx context.Context, sender *UserLogin, o
 	log.UpdateContext(func(c zerolog.Context) zerolog.Context {
 		return c.Str("edit_target_remote_id", string(editTarget.ID))
 	})
-	err = editingAPI.HandleMatrixEdit(ctx, &MatrixEdit{
+	err = portal.Bridge.handleMatrixEditWithMiddleware(ctx, editingAPI, &MatrixEdit{
 		MatrixEventBase: MatrixEventBase[*event.MessageEventContent]{
 			Event:      evt,
 			Content:    content,
@@ -1137,6 +1186,10 @@ func (portal *Portal) handleMatrixReaction(ctx context.Context, sender *UserLogi
 		},
 		TargetMessage: reactionTarget,
 	}
+	react.CustomEmoji, err = portal.GetCustomEmojiByReactionKey(ctx, content.RelatesTo.Key)
+	if err != nil {
+		log.Err(err).Msg("Failed to check if reaction key is a custom emoji")
+	}
 	preResp, err := reactingAPI.PreHandleMatrixReaction(ctx, react)
 	if err != nil {
 		log.Err(err).Msg("Failed to pre-handle Matrix reaction")
@@ -2000,16 +2053,18 @@ func (portal *Portal) handleRemoteMessage(ctx context.Context, source *UserLogin
 		return
 	}
 	ts := getEventTS(evt)
-	converted, err := evt.ConvertMessage(ctx, portal, intent)
+	converted, err := portal.Bridge.wrapRemoteMessageConverter(evt.ConvertMessage)(ctx, portal, intent)
 	if err != nil {
 		if errors.Is(err, ErrIgnoringRemoteEvent) {
 			log.Debug().Err(err).Msg("Remote message handling was cancelled by convert function")
 		} else {
 			log.Err(err).Msg("Failed to convert remote message")
+			portal.Bridge.Metrics.CountConversionFailure(DirectionRemoteToMatrix)
 			portal.sendRemoteErrorNotice(ctx, intent, err, ts, "message")
 		}
 		return
 	}
+	portal.Bridge.Metrics.CountMessage(DirectionRemoteToMatrix)
 	portal.sendConvertedMessage(ctx, evt.GetID(), intent, evt.GetSender().Sender, converted, ts, getStreamOrder(evt), nil)
 }
 
@@ -2057,7 +2112,9 @@ func (portal *Portal) handleRemoteEdit(ctx context.Context, source *UserLogin, e
 		return
 	}
 	ts := getEventTS(evt)
-	converted, err := evt.ConvertEdit(ctx, portal, intent, existing)
+	converted, err := portal.Bridge.convertRemoteEditWithMiddleware(ctx, portal, intent, func(ctx context.Context) (*ConvertedEdit, error) {
+		return evt.ConvertEdit(ctx, portal, intent, existing)
+	})
 	if errors.Is(err, ErrIgnoringRemoteEvent) {
 		log.Debug().Err(err).Msg("Remote edit handling was cancelled by convert function")
 		return
@@ -2217,7 +2274,7 @@ func (portal *Portal) handleRemoteReactionSync(ctx context.Context, source *User
 	doAddReaction := func(new *BackfillReaction) MatrixAPI {
 		intent := portal.GetIntentFor(ctx, new.Sender, source, RemoteEventReactionSync)
 		portal.sendConvertedReaction(
-			ctx, new.Sender.Sender, intent, targetMessage, new.EmojiID, new.Emoji,
+			ctx, new.Sender.Sender, intent, targetMessage, new.EmojiID, portal.getReactionKey(ctx, source, new.Emoji, new.EmojiID),
 			new.Timestamp, new.DBMetadata, new.ExtraContent,
 			func(z *zerolog.Event) *zerolog.Event {
 				return z.
@@ -2323,6 +2380,7 @@ func (portal *Portal) handleRemoteReaction(ctx context.Context, source *UserLogi
 		return
 	}
 	emoji, emojiID := evt.GetReactionEmoji()
+	emoji = portal.getReactionKey(ctx, source, emoji, emojiID)
 	existingReaction, err := portal.Bridge.DB.Reaction.GetByID(ctx, portal.Receiver, targetMessage.ID, targetMessage.PartID, evt.GetSender().Sender, emojiID)
 	if err != nil {
 		log.Err(err).Msg("Failed to check if reaction is a duplicate")
@@ -2549,15 +2607,18 @@ func (portal *Portal) handleRemoteReadReceipt(ctx context.Context, source *UserL
 	}
 	sender := evt.GetSender()
 	intent := portal.GetIntentFor(ctx, sender, source, RemoteEventReadReceipt)
-	err = intent.MarkRead(ctx, portal.MXID, lastTarget.MXID, getEventTS(evt))
-	if err != nil {
-		log.Err(err).Stringer("target_mxid", lastTarget.MXID).Msg("Failed to bridge read receipt")
-	} else {
-		log.Debug().Stringer("target_mxid", lastTarget.MXID).Msg("Bridged read receipt")
-	}
-	if sender.IsFromMe {
-		portal.Bridge.DisappearLoop.StartAll(ctx, portal.MXID)
-	}
+	ts := getEventTS(evt)
+	portal.remoteReceipts.add(receiptKey{userID: intent.GetMXID()}, func() {
+		err := intent.MarkRead(ctx, portal.MXID, lastTarget.MXID, ts)
+		if err != nil {
+			log.Err(err).Stringer("target_mxid", lastTarget.MXID).Msg("Failed to bridge read receipt")
+		} else {
+			log.Debug().Stringer("target_mxid", lastTarget.MXID).Msg("Bridged read receipt")
+		}
+		if sender.IsFromMe {
+			portal.Bridge.DisappearLoop.StartAll(ctx, portal.MXID)
+		}
+	})
 }
 
 func (portal *Portal) handleRemoteMarkUnread(ctx context.Context, source *UserLogin, evt RemoteMarkUnread) {
@@ -2832,6 +2893,9 @@ type ChatInfo struct {
 	Type      *database.RoomType
 	Disappear *database.DisappearingSetting
 	ParentID  *networkid.PortalID
+	// The position of the portal among the children of its parent space. Spaces are sorted by this
+	// string lexicographically, so it should only contain ASCII characters between 0x20 and 0x7E.
+	SpaceOrder *string
 
 	UserLocal *UserLocalPortalInfo
 
@@ -2969,6 +3033,67 @@ func (portal *Portal) UpdateBridgeInfo(ctx context.Context) {
 	portal.sendRoomMeta(ctx, nil, time.Now(), event.StateHalfShotBridge, stateKey, &bridgeInfo)
 }
 
+// getCapabilitiesSource returns the login whose capabilities are advertised in the portal.
+// To avoid the room features flipping back and forth depending on which login happened to handle an event,
+// the logged-in login with the lowest ID is always used. The fallback is only used if no such login is found.
+func (portal *Portal) getCapabilitiesSource(ctx context.Context, fallback *UserLogin) *UserLogin {
+	logins, err := portal.Bridge.GetUserLoginsInPortal(ctx, portal.PortalKey)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get user logins in portal to find capabilities source")
+		return fallback
+	}
+	var source *UserLogin
+	for _, login := range logins {
+		if login.Client.IsLoggedIn() && (source == nil || login.ID < source.ID) {
+			source = login
+		}
+	}
+	if source == nil {
+		return fallback
+	}
+	return source
+}
+
+func (portal *Portal) getRoomFeatures(ctx context.Context, fallbackSource *UserLogin) (*event.RoomFeaturesEventContent, [32]byte, bool) {
+	source := portal.getCapabilitiesSource(ctx, fallbackSource)
+	if source == nil {
+		return nil, [32]byte{}, false
+	}
+	features := source.Client.GetCapabilities(ctx, portal).ToRoomFeatures()
+	featuresJSON, err := json.Marshal(features)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to marshal room features")
+		return nil, [32]byte{}, false
+	}
+	return features, sha256.Sum256(featuresJSON), true
+}
+
+// UpdateCapabilities sends a com.beeper.room_features state event to tell clients what can be bridged
+// in the portal. The capabilities are always taken from the same login (see getCapabilitiesSource),
+// with the given login only being used as a fallback.
+//
+// The event is only sent if the capabilities changed since the last event that was sent. The hash of the
+// last event is stored in the portal, so the caller must save the portal if this returns true.
+func (portal *Portal) UpdateCapabilities(ctx context.Context, source *UserLogin) bool {
+	if portal.MXID == "" {
+		return false
+	}
+	features, hash, ok := portal.getRoomFeatures(ctx, source)
+	if !ok {
+		return false
+	}
+	portal.roomFeaturesLock.Lock()
+	defer portal.roomFeaturesLock.Unlock()
+	if portal.RoomFeaturesHash == hash {
+		return false
+	}
+	if !portal.sendRoomMeta(ctx, nil, time.Now(), event.StateBeeperRoomFeatures, "", features) {
+		return false
+	}
+	portal.RoomFeaturesHash = hash
+	return true
+}
+
 func (portal *Portal) sendStateWithIntentOrBot(ctx context.Context, sender MatrixAPI, eventType event.Type, stateKey string, content *event.Content, ts time.Time) (resp *mautrix.RespSendEvent, err error) {
 	if sender == nil {
 		sender = portal.Bridge.Bot
@@ -3347,7 +3472,7 @@ func (portal *Portal) updateParent(ctx context.Context, newParentID networkid.Po
 	}
 	var err error
 	if portal.MXID != "" && portal.InSpace && portal.Parent != nil && portal.Parent.MXID != "" {
-		err = portal.toggleSpace(ctx, portal.Parent.MXID, false, true)
+		err = portal.toggleSpace(ctx, portal.Parent.MXID, false, true, "")
 		if err != nil {
 			zerolog.Ctx(ctx).Err(err).Stringer("old_space_mxid", portal.Parent.MXID).Msg("Failed to remove portal from old space")
 		}
@@ -3427,6 +3552,9 @@ func (portal *Portal) UpdateInfo(ctx context.Context, info *ChatInfo, source *Us
 	if info.ParentID != nil {
 		changed = portal.updateParent(ctx, *info.ParentID, source) || changed
 	}
+	if info.SpaceOrder != nil {
+		changed = portal.updateSpaceOrder(ctx, *info.SpaceOrder) || changed
+	}
 	if info.JoinRule != nil {
 		// TODO change detection instead of spamming this every time?
 		portal.sendRoomMeta(ctx, sender, ts, event.StateJoinRules, "", info.JoinRule)
@@ -3455,6 +3583,7 @@ func (portal *Portal) UpdateInfo(ctx context.Context, info *ChatInfo, source *Us
 	if source != nil {
 		source.MarkInPortal(ctx, portal)
 		portal.updateUserLocalInfo(ctx, info.UserLocal, source, false)
+		changed = portal.UpdateCapabilities(ctx, source) || changed
 	}
 	if info.CanBackfill && source != nil && portal.MXID != "" {
 		err := portal.Bridge.DB.BackfillTask.EnsureExists(ctx, portal.PortalKey, source.ID)
@@ -3587,6 +3716,15 @@ func (portal *Portal) createMatrixRoomInLoop(ctx context.Context, source *UserLo
 		Type:     event.StateBridge,
 		Content:  event.Content{Parsed: &bridgeInfo},
 	})
+	if roomFeatures, roomFeaturesHash, ok := portal.getRoomFeatures(ctx, source); ok {
+		req.InitialState = append(req.InitialState, &event.Event{
+			Type:    event.StateBeeperRoomFeatures,
+			Content: event.Content{Parsed: roomFeatures},
+		})
+		portal.roomFeaturesLock.Lock()
+		portal.RoomFeaturesHash = roomFeaturesHash
+		portal.roomFeaturesLock.Unlock()
+	}
 	if req.Topic == "" {
 		// Add explicit topic event if topic is empty to ensure the event is set.
 		// This ensures that there won't be an extra event later if PUT /state/... is called.
@@ -3708,6 +3846,7 @@ func (portal *Portal) createMatrixRoomInLoop(ctx context.Context, source *UserLo
 
 func (portal *Portal) Delete(ctx context.Context) error {
 	portal.removeInPortalCache(ctx)
+	portal.removeFromParentSpace(ctx)
 	err := portal.Bridge.DB.Portal.Delete(ctx, portal.PortalKey)
 	if err != nil {
 		return err
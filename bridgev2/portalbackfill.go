@@ -45,6 +45,7 @@ func (portal *Portal) doForwardBackfill(ctx context.Context, source *UserLogin,
 		return
 	}
 	logEvt.Msg("Fetching messages for forward backfill")
+	fetchStart := time.Now()
 	resp, err := api.FetchMessages(ctx, FetchMessagesParams{
 		Portal:        portal,
 		ThreadRoot:    "",
@@ -53,6 +54,7 @@ func (portal *Portal) doForwardBackfill(ctx context.Context, source *UserLogin,
 		Count:         limit,
 		BundledData:   bundledData,
 	})
+	portal.Bridge.Metrics.ObserveRemoteAPI("fetch_messages", time.Since(fetchStart))
 	if err != nil {
 		log.Err(err).Msg("Failed to fetch messages for forward backfill")
 		return
@@ -99,16 +101,26 @@ func (portal *Portal) DoBackwardsBackfill(ctx context.Context, source *UserLogin
 	} else {
 		logEvt = logEvt.Str("db_oldest_message_id", "")
 	}
+	anchorMessage := firstMessage
+	if dropped := portal.droppedBackfillAnchor.Load(); dropped != nil && dropped.ID == task.OldestMessageID &&
+		(firstMessage == nil || dropped.Timestamp.Before(firstMessage.Timestamp)) {
+		// The oldest messages of the previous batch weren't saved, so use the oldest dropped message as the anchor
+		// to avoid fetching the same batch again.
+		logEvt = logEvt.Str("dropped_anchor_message_id", string(dropped.ID))
+		anchorMessage = dropped
+	}
 	logEvt.Msg("Fetching messages for backward backfill")
+	fetchStart := time.Now()
 	resp, err := api.FetchMessages(ctx, FetchMessagesParams{
 		Portal:        portal,
 		ThreadRoot:    "",
 		Forward:       false,
 		Cursor:        task.Cursor,
-		AnchorMessage: firstMessage,
+		AnchorMessage: anchorMessage,
 		Count:         portal.Bridge.Config.Backfill.Queue.BatchSize,
 		Task:          task,
 	})
+	portal.Bridge.Metrics.ObserveRemoteAPI("fetch_messages", time.Since(fetchStart))
 	if err != nil {
 		return fmt.Errorf("failed to fetch messages for backward backfill: %w", err)
 	} else if resp == nil {
@@ -136,7 +148,7 @@ func (portal *Portal) DoBackwardsBackfill(ctx context.Context, source *UserLogin
 		}
 		return nil
 	}
-	resp.Messages = portal.cutoffMessages(ctx, resp.Messages, resp.AggressiveDeduplication, false, firstMessage)
+	resp.Messages = portal.cutoffMessages(ctx, resp.Messages, resp.AggressiveDeduplication, false, anchorMessage)
 	if len(resp.Messages) == 0 {
 		if resp.CompleteCallback != nil {
 			resp.CompleteCallback()
@@ -146,12 +158,32 @@ func (portal *Portal) DoBackwardsBackfill(ctx context.Context, source *UserLogin
 	portal.sendBackfill(ctx, source, resp.Messages, false, resp.MarkRead, false, resp.CompleteCallback)
 	if len(resp.Messages) > 0 {
 		task.OldestMessageID = resp.Messages[0].ID
+		portal.updateDroppedBackfillAnchor(ctx, resp.Messages[0])
 	}
 	return nil
 }
 
+func (portal *Portal) updateDroppedBackfillAnchor(ctx context.Context, oldest *BackfillMessage) {
+	saved, err := portal.Bridge.DB.Message.GetFirstPartByID(ctx, portal.Receiver, oldest.ID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to check if oldest backfilled message was saved")
+		return
+	} else if saved != nil {
+		portal.droppedBackfillAnchor.Store(nil)
+		return
+	}
+	portal.droppedBackfillAnchor.Store(&database.Message{
+		BridgeID:  portal.BridgeID,
+		ID:        oldest.ID,
+		Room:      portal.PortalKey,
+		SenderID:  oldest.Sender.Sender,
+		Timestamp: oldest.Timestamp,
+	})
+}
+
 func (portal *Portal) fetchThreadBackfill(ctx context.Context, source *UserLogin, anchor *database.Message) *FetchMessagesResponse {
 	log := zerolog.Ctx(ctx)
+	fetchStart := time.Now()
 	resp, err := source.Client.(BackfillingNetworkAPI).FetchMessages(ctx, FetchMessagesParams{
 		Portal:        portal,
 		ThreadRoot:    anchor.ID,
@@ -159,6 +191,7 @@ func (portal *Portal) fetchThreadBackfill(ctx context.Context, source *UserLogin
 		AnchorMessage: anchor,
 		Count:         portal.Bridge.Config.Backfill.Threads.MaxInitialMessages,
 	})
+	portal.Bridge.Metrics.ObserveRemoteAPI("fetch_messages", time.Since(fetchStart))
 	if err != nil {
 		log.Err(err).Msg("Failed to fetch messages for thread backfill")
 		return nil
@@ -278,6 +311,13 @@ func (portal *Portal) sendBackfill(
 	inThread bool,
 	done func(),
 ) {
+	messages = portal.applyBackfillMiddleware(ctx, source, messages)
+	if len(messages) == 0 {
+		if done != nil {
+			done()
+		}
+		return
+	}
 	canBatchSend := portal.Bridge.Matrix.GetCapabilities().BatchSending
 	unreadThreshold := time.Duration(portal.Bridge.Config.Backfill.UnreadHoursThreshold) * time.Hour
 	forceMarkRead := unreadThreshold > 0 && time.Since(messages[len(messages)-1].Timestamp) > unreadThreshold
@@ -287,11 +327,13 @@ func (portal *Portal) sendBackfill(
 		Bool("mark_read", markRead).
 		Bool("mark_read_past_threshold", forceMarkRead).
 		Msg("Sending backfill messages")
+	sendStart := time.Now()
 	if canBatchSend {
 		portal.sendBatch(ctx, source, messages, forceForward, markRead || forceMarkRead, inThread)
 	} else {
 		portal.sendLegacyBackfill(ctx, source, messages, markRead || forceMarkRead)
 	}
+	portal.Bridge.Metrics.ObserveBackfillBatch(len(messages), time.Since(sendStart))
 	if done != nil {
 		done()
 	}
@@ -390,6 +432,7 @@ func (portal *Portal) compileBatchMessage(ctx context.Context, source *UserLogin
 		if reaction.Timestamp.IsZero() {
 			reaction.Timestamp = msg.Timestamp.Add(10 * time.Millisecond)
 		}
+		reaction.Emoji = portal.getReactionKey(ctx, source, reaction.Emoji, reaction.EmojiID)
 		targetPart, ok := partMap[*reaction.TargetPart]
 		if !ok {
 			// TODO warning log and/or skip reaction?
@@ -531,6 +574,7 @@ func (portal *Portal) sendLegacyBackfill(ctx context.Context, source *UserLogin,
 						// TODO warning log and/or skip reaction?
 					}
 				}
+				reaction.Emoji = portal.getReactionKey(ctx, source, reaction.Emoji, reaction.EmojiID)
 				portal.sendConvertedReaction(
 					ctx, reaction.Sender.Sender, reactionIntent, targetPart, reaction.EmojiID, reaction.Emoji,
 					reaction.Timestamp, reaction.DBMetadata, reaction.ExtraContent,
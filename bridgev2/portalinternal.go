@@ -341,8 +341,8 @@ func (portal *PortalInternals) AddToParentSpaceAndSave(ctx context.Context, save
 	(*Portal)(portal).addToParentSpaceAndSave(ctx, save)
 }
 
-func (portal *PortalInternals) ToggleSpace(ctx context.Context, spaceID id.RoomID, canonical, remove bool) error {
-	return (*Portal)(portal).toggleSpace(ctx, spaceID, canonical, remove)
+func (portal *PortalInternals) ToggleSpace(ctx context.Context, spaceID id.RoomID, canonical, remove bool, order string) error {
+	return (*Portal)(portal).toggleSpace(ctx, spaceID, canonical, remove, order)
 }
 
 func (portal *PortalInternals) SetMXIDToExistingRoom(roomID id.RoomID) bool {
@@ -0,0 +1,145 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// receiptKey identifies a receipt that replaces previous receipts with the same key.
+// Thread receipts (MSC3771) are separate from the main timeline receipt of the same user.
+type receiptKey struct {
+	userID   id.UserID
+	threadID event.ThreadID
+}
+
+type pendingReceipt struct {
+	count int
+	timer *time.Timer
+	send  func()
+}
+
+// portalReceiptFlushEvent is queued in the portal event loop when a pending receipt's delay runs out,
+// so that receipts are sent in order with other events in the portal.
+type portalReceiptFlushEvent struct {
+	batcher *receiptBatcher
+	key     receiptKey
+	pending *pendingReceipt
+}
+
+func (prf *portalReceiptFlushEvent) isPortalEvent() {}
+
+// receiptBatcher delays bridging read receipts so that only the latest receipt of each user and thread
+// within the delay is actually sent.
+type receiptBatcher struct {
+	portal   *Portal
+	delay    time.Duration
+	maxBatch int
+	pending  map[receiptKey]*pendingReceipt
+	lock     sync.Mutex
+}
+
+func newReceiptBatcher(portal *Portal, delaySeconds, maxBatch int) *receiptBatcher {
+	return &receiptBatcher{
+		portal:   portal,
+		delay:    time.Duration(delaySeconds) * time.Second,
+		maxBatch: maxBatch,
+		pending:  make(map[receiptKey]*pendingReceipt),
+	}
+}
+
+// add queues the given send function, replacing any previous pending receipt with the same key.
+// If batching is disabled, the function is called immediately.
+func (rb *receiptBatcher) add(key receiptKey, send func()) {
+	if rb == nil || rb.delay <= 0 {
+		send()
+		return
+	}
+	rb.lock.Lock()
+	pending, ok := rb.pending[key]
+	if !ok {
+		pending = &pendingReceipt{}
+		rb.pending[key] = pending
+		pending.timer = time.AfterFunc(rb.delay, func() {
+			rb.portal.queueEvent(context.Background(), &portalReceiptFlushEvent{
+				batcher: rb,
+				key:     key,
+				pending: pending,
+			})
+		})
+	}
+	pending.send = send
+	pending.count++
+	if rb.maxBatch > 0 && pending.count >= rb.maxBatch {
+		pending.timer.Stop()
+		delete(rb.pending, key)
+		rb.lock.Unlock()
+		send()
+		return
+	}
+	rb.lock.Unlock()
+}
+
+// flush sends the given pending receipt unless it was already sent or replaced by a new batch.
+func (rb *receiptBatcher) flush(key receiptKey, pending *pendingReceipt) {
+	rb.lock.Lock()
+	if rb.pending[key] != pending {
+		rb.lock.Unlock()
+		return
+	}
+	delete(rb.pending, key)
+	send := pending.send
+	rb.lock.Unlock()
+	send()
+}
+
+// flushAll immediately sends all pending receipts. This is used when the bridge is stopping.
+func (rb *receiptBatcher) flushAll() {
+	if rb == nil {
+		return
+	}
+	rb.lock.Lock()
+	pending := rb.pending
+	rb.pending = make(map[receiptKey]*pendingReceipt)
+	rb.lock.Unlock()
+	for _, receipt := range pending {
+		receipt.timer.Stop()
+		receipt.send()
+	}
+}
+
+func (portal *Portal) initReceiptBatchers() {
+	cfg := &portal.Bridge.Config.ReadReceipts
+	if cfg.MatrixDelay > 0 {
+		portal.matrixReceipts = newReceiptBatcher(portal, cfg.MatrixDelay, cfg.MaxBatchSize)
+	}
+	if cfg.RemoteDelay > 0 {
+		portal.remoteReceipts = newReceiptBatcher(portal, cfg.RemoteDelay, cfg.MaxBatchSize)
+	}
+}
+
+func (portal *Portal) flushPendingReceipts() {
+	portal.matrixReceipts.flushAll()
+	portal.remoteReceipts.flushAll()
+}
+
+func (br *Bridge) flushPendingReceipts() {
+	br.cacheLock.Lock()
+	portals := make([]*Portal, 0, len(br.portalsByKey))
+	for _, portal := range br.portalsByKey {
+		portals = append(portals, portal)
+	}
+	br.cacheLock.Unlock()
+	for _, portal := range portals {
+		portal.flushPendingReceipts()
+	}
+}
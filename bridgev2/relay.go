@@ -0,0 +1,64 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bridgev2
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/event"
+)
+
+// FakeEventSetRelay is the event type whose power level is required to manage the relay of a portal
+// for users who aren't bridge admins.
+var FakeEventSetRelay = event.Type{Type: "fi.mau.bridge.set_relay", Class: event.StateEventType}
+
+var (
+	ErrRelayNotAllowed        = errors.New("this bridge does not allow relay mode")
+	ErrRelayOtherUsersLogin   = errors.New("only bridge admins can set another user's login as the relay")
+	ErrRelayOnlyDefaultRelays = errors.New("you're not allowed to use yourself as relay")
+	ErrRelayLoginNotLoggedIn  = errors.New("the relay login is not logged in")
+)
+
+// CanManageRelay checks if the given user is allowed to set or unset the relay of this portal.
+//
+// Bridge admins and the owner of the current relay can always manage it, other users need the ManageRelay
+// permission and a high enough power level in the room (see [FakeEventSetRelay]).
+func (portal *Portal) CanManageRelay(ctx context.Context, user *User) bool {
+	if !user.Permissions.ManageRelay {
+		return false
+	} else if user.Permissions.Admin || (portal.Relay != nil && portal.Relay.UserMXID == user.MXID) {
+		return true
+	}
+	levels, err := portal.Bridge.Matrix.GetPowerLevels(ctx, portal.MXID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to check room power levels")
+		return false
+	}
+	return levels.GetUserLevel(user.MXID) >= levels.GetEventLevel(FakeEventSetRelay)
+}
+
+// CheckRelayLogin checks if the given user is allowed to use the given login as the relay of this portal.
+// The caller is expected to have checked CanManageRelay first.
+func (portal *Portal) CheckRelayLogin(user *User, login *UserLogin) error {
+	cfg := &portal.Bridge.Config.Relay
+	if !cfg.Enabled {
+		return ErrRelayNotAllowed
+	} else if !login.Client.IsLoggedIn() {
+		return ErrRelayLoginNotLoggedIn
+	} else if slices.Contains(cfg.DefaultRelays, login.ID) {
+		return nil
+	} else if login.UserMXID != user.MXID && !user.Permissions.Admin {
+		return ErrRelayOtherUsersLogin
+	} else if !user.Permissions.Admin && cfg.AdminOnly {
+		return ErrRelayOnlyDefaultRelays
+	}
+	return nil
+}
@@ -65,7 +65,7 @@ func (ul *UserLogin) AddPortalToSpace(ctx context.Context, portal *Portal, userP
 	} else if spaceRoom == "" {
 		return nil
 	}
-	err = portal.toggleSpace(ctx, spaceRoom, false, false)
+	err = portal.toggleSpace(ctx, spaceRoom, false, false, "")
 	if err != nil {
 		return fmt.Errorf("failed to add portal to space: %w", err)
 	}
@@ -89,7 +89,7 @@ func (portal *Portal) createParentAndAddToSpace(ctx context.Context, source *Use
 }
 
 func (portal *Portal) addToParentSpaceAndSave(ctx context.Context, save bool) {
-	err := portal.toggleSpace(ctx, portal.Parent.MXID, true, false)
+	err := portal.toggleSpace(ctx, portal.Parent.MXID, true, false, portal.SpaceOrder)
 	if err != nil {
 		zerolog.Ctx(ctx).Err(err).Stringer("space_mxid", portal.Parent.MXID).Msg("Failed to add portal to space")
 	} else {
@@ -103,14 +103,42 @@ func (portal *Portal) addToParentSpaceAndSave(ctx context.Context, save bool) {
 	}
 }
 
-func (portal *Portal) toggleSpace(ctx context.Context, spaceID id.RoomID, canonical, remove bool) error {
+func (portal *Portal) updateSpaceOrder(ctx context.Context, order string) bool {
+	if portal.SpaceOrder == order {
+		return false
+	}
+	portal.SpaceOrder = order
+	if portal.MXID != "" && portal.InSpace && portal.Parent != nil && portal.Parent.MXID != "" {
+		err := portal.toggleSpace(ctx, portal.Parent.MXID, false, false, order)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Stringer("space_mxid", portal.Parent.MXID).Msg("Failed to update order in space")
+		}
+	}
+	return true
+}
+
+func (portal *Portal) removeFromParentSpace(ctx context.Context) {
+	if portal.MXID == "" || !portal.InSpace || portal.Parent == nil || portal.Parent.MXID == "" {
+		return
+	}
+	err := portal.toggleSpace(ctx, portal.Parent.MXID, false, true, "")
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("space_mxid", portal.Parent.MXID).Msg("Failed to remove deleted portal from space")
+	} else {
+		portal.InSpace = false
+	}
+}
+
+func (portal *Portal) toggleSpace(ctx context.Context, spaceID id.RoomID, canonical, remove bool, order string) error {
 	via := []string{portal.Bridge.Matrix.ServerName()}
 	if remove {
 		via = nil
+		order = ""
 	}
 	_, err := portal.Bridge.Bot.SendState(ctx, spaceID, event.StateSpaceChild, portal.MXID.String(), &event.Content{
 		Parsed: &event.SpaceChildEventContent{
-			Via: via,
+			Via:   via,
+			Order: order,
 		},
 	}, time.Now())
 	if err != nil {
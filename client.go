@@ -16,6 +16,7 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -76,10 +77,10 @@ type VerificationHelper interface {
 
 // Client represents a Matrix client.
 type Client struct {
-	HomeserverURL *url.URL     // The base homeserver URL
+	HomeserverURL *url.URL     // The base homeserver URL. Use GetHomeserverURL and SetHomeserverURL while requests may be running.
 	UserID        id.UserID    // The user ID of the client. Used for forming HTTP paths which use the client's user ID.
 	DeviceID      id.DeviceID  // The device ID of the client.
-	AccessToken   string       // The access_token for the client.
+	AccessToken   string       // The access_token for the client. Use GetAccessToken and SetAccessToken while requests may be running.
 	UserAgent     string       // The value for the User-Agent header
 	Client        *http.Client // The underlying HTTP client which will be used to make HTTP requests.
 	Syncer        Syncer       // The thing which can process /sync responses
@@ -91,14 +92,61 @@ type Client struct {
 
 	Log zerolog.Logger
 
+	// RequestHook is called before each request is sent. See also AddRequestHook for a more flexible middleware API.
 	RequestHook  func(req *http.Request)
 	ResponseHook func(req *http.Request, resp *http.Response, err error, duration time.Duration)
+	// Instrumentation receives traces and metrics for requests, syncs and crypto operations.
+	Instrumentation Instrumentation
+	// AccountDataCache is an optional cache for account data, see EnableAccountDataCache.
+	AccountDataCache *AccountDataCache
+
+	// The server name whose .well-known file the homeserver URL was discovered from. If set, the .well-known file
+	// is re-resolved after RediscoverAfterConnectionFailures consecutive connection failures (and again with
+	// increasing delays if the failures continue), and HomeserverURL is updated if the base URL has changed.
+	// Requests that are already in progress (including retries) keep using the old URL.
+	WellKnownServerName string
+	// The cache used for .well-known lookups. If nil, DefaultWellKnownCache is used.
+	WellKnownCache *WellKnownCache
+
+	homeserverURLLock  sync.RWMutex
+	rediscoverLock     sync.Mutex
+	rediscoverAttempts int
+	nextRediscover     time.Time
+
+	connectionFailures atomic.Int32
+	middlewares        []RequestMiddleware
 
 	UpdateRequestOnRetry func(req *http.Request, cause error) *http.Request
+	// RefreshAccessToken is called when a request fails with M_UNKNOWN_TOKEN. If it returns a new access token,
+	// the token is stored in AccessToken and the request is retried once. The old token is passed as a parameter
+	// so that implementations can avoid refreshing multiple times when concurrent requests fail.
+	//
+	// If this is not set, but RefreshToken is, the built-in /refresh support is used instead.
+	RefreshAccessToken func(ctx context.Context, oldToken string) (newToken string, err error)
+
+	// The refresh token from login, which is used to get a new access token when the current one expires.
+	//
+	// Like AccessToken, this is updated by requests when the token is refreshed, so it shouldn't be
+	// accessed directly while requests are running. Use SetTokens and GetTokens instead.
+	RefreshToken string
+	// The time when the current access token expires. If set, the token is refreshed shortly before it expires.
+	AccessTokenExpiresAt time.Time
+	// OnTokensRefreshed is called after the built-in refresh support gets a new access token,
+	// so that applications can persist the new tokens.
+	OnTokensRefreshed func(ctx context.Context, resp *RespRefresh)
+
+	// refreshLock makes sure only one request refreshes the access token at a time,
+	// tokenLock guards AccessToken, RefreshToken and AccessTokenExpiresAt.
+	refreshLock sync.Mutex
+	tokenLock   sync.RWMutex
 
 	SyncPresence event.Presence
 	SyncTraceLog bool
 
+	// If set, sync responses are streamed to a temporary file instead of being read into memory when the previous
+	// successful sync is older than this. If the Syncer implements StreamingSyncer and has room streaming enabled
+	// (e.g. DefaultSyncer.StreamRooms), the streamed response is also decoded and processed room by room rather
+	// than being unmarshaled all at once.
 	StreamSyncMinAge time.Duration
 
 	// Number of times that mautrix will retry any HTTP request
@@ -108,6 +156,8 @@ type Client struct {
 	DefaultHTTPBackoff time.Duration
 	// Set to true to disable automatically sleeping on 429 errors.
 	IgnoreRateLimit bool
+	// Optional policy for per-endpoint retry counts, backoff jitter and circuit breaking.
+	RetryPolicy *RetryPolicy
 
 	txnID int32
 
@@ -122,8 +172,22 @@ type Client struct {
 }
 
 type ClientWellKnown struct {
-	Homeserver     HomeserverInfo     `json:"m.homeserver"`
-	IdentityServer IdentityServerInfo `json:"m.identity_server"`
+	Homeserver     HomeserverInfo      `json:"m.homeserver"`
+	IdentityServer IdentityServerInfo  `json:"m.identity_server"`
+	Authentication *AuthenticationInfo `json:"org.matrix.msc2965.authentication,omitempty"`
+	// https://github.com/matrix-org/matrix-spec-proposals/pull/3575
+	SlidingSyncProxy *SlidingSyncProxyInfo `json:"org.matrix.msc3575.proxy,omitempty"`
+	// https://github.com/matrix-org/matrix-spec-proposals/pull/3488
+	TileServer         *TileServerInfo `json:"m.tile_server,omitempty"`
+	UnstableTileServer *TileServerInfo `json:"org.matrix.msc3488.tile_server,omitempty"`
+}
+
+// GetTileServer returns the stable tile server info if set, or the unstable one otherwise.
+func (wk *ClientWellKnown) GetTileServer() *TileServerInfo {
+	if wk.TileServer != nil {
+		return wk.TileServer
+	}
+	return wk.UnstableTileServer
 }
 
 type HomeserverInfo struct {
@@ -134,6 +198,20 @@ type IdentityServerInfo struct {
 	BaseURL string `json:"base_url"`
 }
 
+type SlidingSyncProxyInfo struct {
+	URL string `json:"url"`
+}
+
+type TileServerInfo struct {
+	MapStyleURL string `json:"map_style_url"`
+}
+
+// AuthenticationInfo contains the OAuth 2.0 issuer of a homeserver that uses next-generation auth (MSC2965).
+type AuthenticationInfo struct {
+	Issuer  string `json:"issuer"`
+	Account string `json:"account,omitempty"`
+}
+
 // DiscoverClientAPI resolves the client API URL from a Matrix server name.
 // Use ParseUserID to extract the server name from a user ID.
 // https://spec.matrix.org/v1.2/client-server-api/#server-discovery
@@ -181,17 +259,48 @@ func DiscoverClientAPI(ctx context.Context, serverName string) (*ClientWellKnown
 //
 // Deprecated: use the StoreCredentials field in ReqLogin instead.
 func (cli *Client) SetCredentials(userID id.UserID, accessToken string) {
-	cli.AccessToken = accessToken
+	cli.SetAccessToken(accessToken)
 	cli.UserID = userID
 }
 
 // ClearCredentials removes the user ID and access token on this client instance.
 func (cli *Client) ClearCredentials() {
-	cli.AccessToken = ""
+	cli.SetTokens("", "", time.Time{})
 	cli.UserID = ""
 	cli.DeviceID = ""
 }
 
+// GetAccessToken returns the current access token. Unlike reading the AccessToken field directly,
+// this is safe to call while other requests may be refreshing the token.
+func (cli *Client) GetAccessToken() string {
+	cli.tokenLock.RLock()
+	defer cli.tokenLock.RUnlock()
+	return cli.AccessToken
+}
+
+// SetAccessToken replaces the current access token without touching the refresh token or expiry.
+func (cli *Client) SetAccessToken(accessToken string) {
+	cli.tokenLock.Lock()
+	cli.AccessToken = accessToken
+	cli.tokenLock.Unlock()
+}
+
+// GetTokens returns the current access token, refresh token and access token expiry time.
+func (cli *Client) GetTokens() (accessToken, refreshToken string, expiresAt time.Time) {
+	cli.tokenLock.RLock()
+	defer cli.tokenLock.RUnlock()
+	return cli.AccessToken, cli.RefreshToken, cli.AccessTokenExpiresAt
+}
+
+// SetTokens replaces the current access token, refresh token and access token expiry time.
+func (cli *Client) SetTokens(accessToken, refreshToken string, expiresAt time.Time) {
+	cli.tokenLock.Lock()
+	cli.AccessToken = accessToken
+	cli.RefreshToken = refreshToken
+	cli.AccessTokenExpiresAt = expiresAt
+	cli.tokenLock.Unlock()
+}
+
 // Sync starts syncing with the provided Homeserver. If Sync() is called twice then the first sync will be stopped and the
 // error will be nil.
 //
@@ -244,15 +353,32 @@ func (cli *Client) SyncWithContext(ctx context.Context) error {
 		if isFailing || nextBatch == "" {
 			timeout = 0
 		}
-		resSync, err := cli.FullSyncRequest(ctx, ReqSync{
+		req := ReqSync{
 			Timeout:        timeout,
 			Since:          nextBatch,
 			FilterID:       filterID,
 			FullState:      false,
 			SetPresence:    cli.SyncPresence,
 			StreamResponse: streamResp,
-		})
+		}
+		var resSync *RespSync
+		var streamed *streamedSync
+		streamingSyncer, canStreamRooms := cli.Syncer.(StreamingSyncer)
+		canStreamRooms = canStreamRooms && streamingSyncer.CanStreamRooms()
+		syncInfo := &SyncInfo{Since: nextBatch, Streamed: streamResp}
+		requestStart := time.Now()
+		if streamResp && canStreamRooms {
+			streamed, err = cli.streamingSyncRequest(ctx, req)
+			if streamed != nil {
+				resSync = streamed.top
+			}
+		} else {
+			resSync, err = cli.FullSyncRequest(ctx, req)
+		}
+		syncInfo.RequestDuration = time.Since(requestStart)
 		if err != nil {
+			syncInfo.Error = err
+			cli.observeSync(ctx, syncInfo)
 			isFailing = true
 			if ctx.Err() != nil {
 				return ctx.Err()
@@ -278,6 +404,9 @@ func (cli *Client) SyncWithContext(ctx context.Context) error {
 		// Either because we've stopped syncing or another sync has been started.
 		// We discard the response from our sync.
 		if cli.getSyncingID() != syncingID {
+			if streamed != nil {
+				streamed.close()
+			}
 			return nil
 		}
 
@@ -286,9 +415,22 @@ func (cli *Client) SyncWithContext(ctx context.Context) error {
 		// a malformed/buggy event which keeps making us panic.
 		err = cli.Store.SaveNextBatch(ctx, cli.UserID, resSync.NextBatch)
 		if err != nil {
+			if streamed != nil {
+				streamed.close()
+			}
 			return err
 		}
-		if err = cli.Syncer.ProcessResponse(ctx, resSync, nextBatch); err != nil {
+		processStart := time.Now()
+		if streamed != nil {
+			err = streamed.process(ctx, streamingSyncer, nextBatch)
+			streamed.close()
+		} else {
+			err = cli.Syncer.ProcessResponse(ctx, resSync, nextBatch)
+		}
+		syncInfo.ProcessDuration = time.Since(processStart)
+		syncInfo.Error = err
+		cli.observeSync(ctx, syncInfo)
+		if err != nil {
 			return err
 		}
 
@@ -465,9 +607,6 @@ func (cli *Client) MakeFullRequest(ctx context.Context, params FullRequest) ([]b
 }
 
 func (cli *Client) MakeFullRequestWithResp(ctx context.Context, params FullRequest) ([]byte, *http.Response, error) {
-	if params.MaxAttempts == 0 {
-		params.MaxAttempts = 1 + cli.DefaultHTTPRetries
-	}
 	if params.BackoffDuration == 0 {
 		if cli.DefaultHTTPBackoff == 0 {
 			params.BackoffDuration = 4 * time.Second
@@ -478,10 +617,19 @@ func (cli *Client) MakeFullRequestWithResp(ctx context.Context, params FullReque
 	if params.Logger == nil {
 		params.Logger = &cli.Log
 	}
+	cli.maybeRefreshExpiringToken(ctx)
 	req, err := params.compileRequest(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
+	if params.MaxAttempts == 0 {
+		params.MaxAttempts = 1 + cli.DefaultHTTPRetries
+		if cli.RetryPolicy != nil {
+			if retries, ok := cli.RetryPolicy.getEndpointRetries(req.URL.Path); ok {
+				params.MaxAttempts = 1 + retries
+			}
+		}
+	}
 	if params.Handler == nil {
 		if params.DontReadResponse {
 			params.Handler = noopHandleResponse
@@ -490,8 +638,8 @@ func (cli *Client) MakeFullRequestWithResp(ctx context.Context, params FullReque
 		}
 	}
 	req.Header.Set("User-Agent", cli.UserAgent)
-	if len(cli.AccessToken) > 0 {
-		req.Header.Set("Authorization", "Bearer "+cli.AccessToken)
+	if accessToken := cli.GetAccessToken(); len(accessToken) > 0 {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
 	}
 	if params.Client == nil {
 		params.Client = cli.Client
@@ -507,35 +655,135 @@ func (cli *Client) cliOrContextLog(ctx context.Context) *zerolog.Logger {
 	return log
 }
 
-func (cli *Client) doRetry(req *http.Request, cause error, retries int, backoff time.Duration, responseJSON any, handler ClientResponseHandler, dontReadResponse bool, client *http.Client) ([]byte, *http.Response, error) {
+func resetRequestBody(req *http.Request) bool {
+	if req.Body == nil {
+		return true
+	}
 	log := zerolog.Ctx(req.Context())
-	if req.Body != nil {
-		var err error
-		if req.GetBody != nil {
-			req.Body, err = req.GetBody()
-			if err != nil {
-				log.Warn().Err(err).Msg("Failed to get new body to retry request")
-				return nil, nil, cause
-			}
-		} else if bodySeeker, ok := req.Body.(io.ReadSeeker); ok {
-			_, err = bodySeeker.Seek(0, io.SeekStart)
-			if err != nil {
-				log.Warn().Err(err).Msg("Failed to seek to beginning of request body")
-				return nil, nil, cause
-			}
-		} else {
-			log.Warn().Msg("Failed to get new body to retry request: GetBody is nil and Body is not an io.ReadSeeker")
-			return nil, nil, cause
+	var err error
+	if req.GetBody != nil {
+		req.Body, err = req.GetBody()
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to get new body to retry request")
+			return false
 		}
+	} else if bodySeeker, ok := req.Body.(io.ReadSeeker); ok {
+		_, err = bodySeeker.Seek(0, io.SeekStart)
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to seek to beginning of request body")
+			return false
+		}
+	} else {
+		log.Warn().Msg("Failed to get new body to retry request: GetBody is nil and Body is not an io.ReadSeeker")
+		return false
+	}
+	return true
+}
+
+type refreshedTokenContextKey struct{}
+type refreshingTokenContextKey struct{}
+
+// accessTokenRefreshMargin is how long before expiry the access token is refreshed proactively.
+const accessTokenRefreshMargin = 30 * time.Second
+
+func (cli *Client) shouldRefreshToken(req *http.Request, err error) bool {
+	if req.Header.Get("Authorization") == "" || req.Context().Value(refreshedTokenContextKey{}) != nil || req.Context().Value(refreshingTokenContextKey{}) != nil {
+		return false
+	}
+	var httpErr HTTPError
+	if !errors.As(err, &httpErr) || httpErr.RespError == nil || httpErr.RespError.ErrCode != MUnknownToken.ErrCode {
+		return false
+	} else if cli.RefreshAccessToken != nil {
+		return true
+	}
+	// The built-in refresh support only refreshes when the server says it's a soft logout
+	softLogout, _ := httpErr.RespError.ExtraData["soft_logout"].(bool)
+	_, refreshToken, _ := cli.GetTokens()
+	return softLogout && refreshToken != ""
+}
+
+func (cli *Client) refreshAccessToken(ctx context.Context, oldToken string) (string, error) {
+	if cli.RefreshAccessToken != nil {
+		return cli.RefreshAccessToken(ctx, oldToken)
+	}
+	cli.refreshLock.Lock()
+	defer cli.refreshLock.Unlock()
+	accessToken, refreshToken, _ := cli.GetTokens()
+	if accessToken != oldToken {
+		// Another request already refreshed the token
+		return accessToken, nil
+	} else if refreshToken == "" {
+		return "", errors.New("no refresh token")
+	}
+	resp, err := cli.Refresh(ctx, refreshToken)
+	if err != nil {
+		return "", err
 	}
+	if resp.RefreshToken != "" {
+		refreshToken = resp.RefreshToken
+	}
+	cli.SetTokens(resp.AccessToken, refreshToken, expiresInMSToTime(resp.ExpiresInMS))
+	if cli.OnTokensRefreshed != nil {
+		cli.OnTokensRefreshed(ctx, resp)
+	}
+	return resp.AccessToken, nil
+}
+
+func (cli *Client) maybeRefreshExpiringToken(ctx context.Context) {
+	if cli.RefreshAccessToken != nil || ctx.Value(refreshingTokenContextKey{}) != nil {
+		return
+	}
+	accessToken, refreshToken, expiresAt := cli.GetTokens()
+	if refreshToken == "" || expiresAt.IsZero() || time.Until(expiresAt) > accessTokenRefreshMargin {
+		return
+	}
+	_, err := cli.refreshAccessToken(ctx, accessToken)
+	if err != nil {
+		cli.cliOrContextLog(ctx).Warn().Err(err).Msg("Failed to refresh expiring access token")
+	}
+}
+
+func expiresInMSToTime(expiresInMS int64) time.Time {
+	if expiresInMS <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(expiresInMS) * time.Millisecond)
+}
+
+func (cli *Client) retryWithRefreshedToken(req *http.Request, cause error, retries int, backoff time.Duration, responseJSON any, handler ClientResponseHandler, dontReadResponse bool, client *http.Client) ([]byte, *http.Response, error) {
+	log := zerolog.Ctx(req.Context())
+	oldToken := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	newToken, err := cli.refreshAccessToken(req.Context(), oldToken)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to refresh access token")
+		return nil, nil, cause
+	} else if !resetRequestBody(req) {
+		return nil, nil, cause
+	}
+	log.Debug().Msg("Refreshed access token, retrying request")
+	cli.SetAccessToken(newToken)
+	req = req.WithContext(context.WithValue(req.Context(), refreshedTokenContextKey{}, true))
+	req.Header.Set("Authorization", "Bearer "+newToken)
+	return cli.executeCompiledRequest(req, retries, backoff, responseJSON, handler, dontReadResponse, client)
+}
+
+func (cli *Client) doRetry(req *http.Request, cause error, retries int, backoff time.Duration, responseJSON any, handler ClientResponseHandler, dontReadResponse bool, client *http.Client) ([]byte, *http.Response, error) {
+	log := zerolog.Ctx(req.Context())
+	if !resetRequestBody(req) {
+		return nil, nil, cause
+	}
+	sleep := cli.RetryPolicy.addJitter(backoff)
 	log.Warn().Err(cause).
-		Int("retry_in_seconds", int(backoff.Seconds())).
+		Int("retry_in_seconds", int(sleep.Seconds())).
 		Msg("Request failed, retrying")
-	time.Sleep(backoff)
+	if cli.RetryPolicy != nil && cli.RetryPolicy.OnRetry != nil {
+		cli.RetryPolicy.OnRetry(req, cause, retries, sleep)
+	}
+	time.Sleep(sleep)
 	if cli.UpdateRequestOnRetry != nil {
 		req = cli.UpdateRequestOnRetry(req, cause)
 	}
-	return cli.executeCompiledRequest(req, retries-1, backoff*2, responseJSON, handler, dontReadResponse, client)
+	return cli.executeCompiledRequest(req, retries-1, cli.RetryPolicy.nextBackoff(backoff), responseJSON, handler, dontReadResponse, client)
 }
 
 func readResponseBody(req *http.Request, res *http.Response) ([]byte, error) {
@@ -624,14 +872,38 @@ func ParseErrorResponse(req *http.Request, res *http.Response) ([]byte, error) {
 }
 
 func (cli *Client) executeCompiledRequest(req *http.Request, retries int, backoff time.Duration, responseJSON any, handler ClientResponseHandler, dontReadResponse bool, client *http.Client) ([]byte, *http.Response, error) {
-	cli.RequestStart(req)
+	breaker := cli.RetryPolicy.getCircuitBreaker()
+	if breaker != nil {
+		if err := breaker.Allow(); err != nil {
+			return nil, nil, HTTPError{
+				Request:      req,
+				Message:      "request not sent",
+				WrappedError: err,
+			}
+		}
+	}
+	tracedReq, finishTrace := cli.instrumentRequest(req)
+	cli.RequestStart(tracedReq)
 	startTime := time.Now()
-	res, err := client.Do(req)
+	res, err := cli.roundTrip(client, tracedReq)
 	duration := time.Now().Sub(startTime)
 	if res != nil && !dontReadResponse {
 		defer res.Body.Close()
 	}
+	if breaker != nil {
+		if errors.Is(err, context.Canceled) {
+			breaker.RecordCanceled()
+		} else if err != nil || res.StatusCode == http.StatusBadGateway || res.StatusCode == http.StatusServiceUnavailable || res.StatusCode == http.StatusGatewayTimeout {
+			breaker.RecordFailure()
+		} else {
+			breaker.RecordSuccess()
+		}
+	}
+	if !errors.Is(err, context.Canceled) {
+		cli.recordConnectionResult(err != nil)
+	}
 	if err != nil {
+		finishTrace(res, err, duration)
 		if retries > 0 && !errors.Is(err, context.Canceled) {
 			return cli.doRetry(req, err, retries, backoff, responseJSON, handler, dontReadResponse, client)
 		}
@@ -642,22 +914,32 @@ func (cli *Client) executeCompiledRequest(req *http.Request, retries int, backof
 			Message:      "request error",
 			WrappedError: err,
 		}
-		cli.LogRequestDone(req, res, err, nil, 0, duration)
+		cli.LogRequestDone(tracedReq, res, err, nil, 0, duration)
 		return nil, res, err
 	}
 
 	if retries > 0 && retryafter.Should(res.StatusCode, !cli.IgnoreRateLimit) {
-		backoff = retryafter.Parse(res.Header.Get("Retry-After"), backoff)
+		finishTrace(res, nil, duration)
+		if retryAfterHeader := res.Header.Get("Retry-After"); retryAfterHeader != "" {
+			backoff = retryafter.Parse(retryAfterHeader, backoff)
+		} else if retryAfterMS, ok := parseRetryAfterMS(res); ok {
+			backoff = retryAfterMS
+		}
 		return cli.doRetry(req, fmt.Errorf("HTTP %d", res.StatusCode), retries, backoff, responseJSON, handler, dontReadResponse, client)
 	}
 
 	var body []byte
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		body, err = ParseErrorResponse(req, res)
-		cli.LogRequestDone(req, res, nil, nil, len(body), duration)
+		body, err = ParseErrorResponse(tracedReq, res)
+		cli.LogRequestDone(tracedReq, res, nil, nil, len(body), duration)
+		finishTrace(res, err, time.Since(startTime))
+		if res.StatusCode == http.StatusUnauthorized && cli.shouldRefreshToken(req, err) {
+			return cli.retryWithRefreshedToken(req, err, retries, backoff, responseJSON, handler, dontReadResponse, client)
+		}
 	} else {
-		body, err = handler(req, res, responseJSON)
-		cli.LogRequestDone(req, res, nil, err, len(body), duration)
+		body, err = handler(tracedReq, res, responseJSON)
+		cli.LogRequestDone(tracedReq, res, nil, err, len(body), duration)
+		finishTrace(res, err, time.Since(startTime))
 	}
 	return body, res, err
 }
@@ -677,6 +959,24 @@ func (cli *Client) CreateFilter(ctx context.Context, filter *Filter) (resp *Resp
 	return
 }
 
+// SetSyncFilter uploads the given filter and stores its ID in the sync store, so that the next call to Sync uses it
+// instead of a previously cached filter ID. If the syncer is a DefaultSyncer, its FilterJSON is updated too,
+// so the same filter will be recreated if the stored filter ID is lost.
+func (cli *Client) SetSyncFilter(ctx context.Context, filter *Filter) (string, error) {
+	resp, err := cli.CreateFilter(ctx, filter)
+	if err != nil {
+		return "", err
+	}
+	err = cli.Store.SaveFilterID(ctx, cli.UserID, resp.FilterID)
+	if err != nil {
+		return "", fmt.Errorf("failed to save filter ID: %w", err)
+	}
+	if syncer, ok := cli.Syncer.(*DefaultSyncer); ok {
+		syncer.FilterJSON = filter
+	}
+	return resp.FilterID, nil
+}
+
 // SyncRequest makes an HTTP request according to https://spec.matrix.org/v1.2/client-server-api/#get_matrixclientv3sync
 func (cli *Client) SyncRequest(ctx context.Context, timeout int, since, filterID string, fullState bool, setPresence event.Presence) (resp *RespSync, err error) {
 	return cli.FullSyncRequest(ctx, ReqSync{
@@ -725,20 +1025,27 @@ func (req *ReqSync) BuildQuery() map[string]string {
 
 // FullSyncRequest makes an HTTP request according to https://spec.matrix.org/v1.2/client-server-api/#get_matrixclientv3sync
 func (cli *Client) FullSyncRequest(ctx context.Context, req ReqSync) (resp *RespSync, err error) {
+	var handler ClientResponseHandler
+	if req.StreamResponse {
+		handler = streamResponse
+	}
+	err = cli.doSyncRequest(ctx, req, &resp, handler)
+	return
+}
+
+func (cli *Client) doSyncRequest(ctx context.Context, req ReqSync, responseJSON any, handler ClientResponseHandler) error {
 	urlPath := cli.BuildURLWithQuery(ClientURLPath{"v3", "sync"}, req.BuildQuery())
 	fullReq := FullRequest{
 		Method:       http.MethodGet,
 		URL:          urlPath,
-		ResponseJSON: &resp,
+		ResponseJSON: responseJSON,
 		Client:       req.Client,
+		Handler:      handler,
 		// We don't want automatic retries for SyncRequest, the Sync() wrapper handles those.
 		MaxAttempts: 1,
 	}
-	if req.StreamResponse {
-		fullReq.Handler = streamResponse
-	}
 	start := time.Now()
-	_, err = cli.MakeFullRequest(ctx, fullReq)
+	_, err := cli.MakeFullRequest(ctx, fullReq)
 	duration := time.Now().Sub(start)
 	timeout := time.Duration(req.Timeout) * time.Millisecond
 	buffer := 10 * time.Second
@@ -752,7 +1059,7 @@ func (cli *Client) FullSyncRequest(ctx context.Context, req ReqSync) (resp *Resp
 			Dur("timeout", timeout).
 			Msg("Sync request took unusually long")
 	}
-	return
+	return err
 }
 
 // RegisterAvailable checks if a username is valid and available for registration on the server.
@@ -877,8 +1184,8 @@ func (cli *Client) Login(ctx context.Context, req *ReqLogin) (resp *RespLogin, e
 	})
 	if req.StoreCredentials && err == nil {
 		cli.DeviceID = resp.DeviceID
-		cli.AccessToken = resp.AccessToken
 		cli.UserID = resp.UserID
+		cli.SetTokens(resp.AccessToken, resp.RefreshToken, expiresInMSToTime(resp.ExpiresInMS))
 
 		cli.Log.Debug().
 			Str("user_id", cli.UserID.String()).
@@ -886,16 +1193,16 @@ func (cli *Client) Login(ctx context.Context, req *ReqLogin) (resp *RespLogin, e
 			Msg("Stored credentials after login")
 	}
 	if req.StoreHomeserverURL && err == nil && resp.WellKnown != nil && len(resp.WellKnown.Homeserver.BaseURL) > 0 {
-		var urlErr error
-		cli.HomeserverURL, urlErr = url.Parse(resp.WellKnown.Homeserver.BaseURL)
+		hsURL, urlErr := url.Parse(resp.WellKnown.Homeserver.BaseURL)
 		if urlErr != nil {
 			cli.Log.Warn().
 				Err(urlErr).
 				Str("homeserver_url", resp.WellKnown.Homeserver.BaseURL).
 				Msg("Failed to parse homeserver URL in login response")
 		} else {
+			cli.SetHomeserverURL(hsURL)
 			cli.Log.Debug().
-				Str("homeserver_url", cli.HomeserverURL.String()).
+				Str("homeserver_url", hsURL.String()).
 				Msg("Updated homeserver URL after login")
 		}
 	}
@@ -918,6 +1225,19 @@ func (cli *Client) CreateDeviceMSC4190(ctx context.Context, deviceID id.DeviceID
 	return nil
 }
 
+// DeleteDeviceMSC4190 deletes a device of an appservice user using MSC4190, which doesn't require user-interactive auth.
+func (cli *Client) DeleteDeviceMSC4190(ctx context.Context, deviceID id.DeviceID) error {
+	_, err := cli.MakeRequest(ctx, http.MethodDelete, cli.BuildClientURL("v3", "devices", deviceID), nil, nil)
+	if err != nil {
+		return err
+	}
+	if cli.DeviceID == deviceID && cli.SetAppServiceDeviceID {
+		cli.DeviceID = ""
+		cli.SetAppServiceDeviceID = false
+	}
+	return nil
+}
+
 // Logout the current user. See https://spec.matrix.org/v1.2/client-server-api/#post_matrixclientv3logout
 // This does not clear the credentials from the client instance. See ClearCredentials() instead.
 func (cli *Client) Logout(ctx context.Context) (resp *RespLogout, err error) {
@@ -926,6 +1246,22 @@ func (cli *Client) Logout(ctx context.Context) (resp *RespLogout, err error) {
 	return
 }
 
+// Refresh gets a new access token using a refresh token. See https://spec.matrix.org/v1.11/client-server-api/#post_matrixclientv3refresh
+//
+// Requests made with this client refresh the access token automatically if RefreshToken is set,
+// so this doesn't usually need to be called manually.
+func (cli *Client) Refresh(ctx context.Context, refreshToken string) (resp *RespRefresh, err error) {
+	ctx = context.WithValue(ctx, refreshingTokenContextKey{}, true)
+	_, err = cli.MakeFullRequest(ctx, FullRequest{
+		Method:           http.MethodPost,
+		URL:              cli.BuildClientURL("v3", "refresh"),
+		RequestJSON:      &ReqRefresh{RefreshToken: refreshToken},
+		ResponseJSON:     &resp,
+		SensitiveContent: true,
+	})
+	return
+}
+
 // LogoutAll logs out all the devices of the current user. See https://spec.matrix.org/v1.2/client-server-api/#post_matrixclientv3logoutall
 // This does not clear the credentials from the client instance. See ClearCredentials() instead.
 func (cli *Client) LogoutAll(ctx context.Context) (resp *RespLogout, err error) {
@@ -988,6 +1324,36 @@ func (cli *Client) JoinRoomByID(ctx context.Context, roomID id.RoomID) (resp *Re
 	return
 }
 
+// KnockRoom knocks on a room ID or alias to request an invite. See https://spec.matrix.org/v1.13/client-server-api/#post_matrixclientv3knockroomidoralias
+//
+// The last parameter contains optional extra fields and can be left nil.
+// A pending knock can be retracted by leaving the room with LeaveRoom.
+func (cli *Client) KnockRoom(ctx context.Context, roomIDorAlias string, req *ReqKnockRoom) (resp *RespKnockRoom, err error) {
+	if req == nil {
+		req = &ReqKnockRoom{}
+	}
+	urlPath := cli.BuildURLWithFullQuery(ClientURLPath{"v3", "knock", roomIDorAlias}, func(q url.Values) {
+		if len(req.Via) > 0 {
+			q["via"] = req.Via
+			// server_name is the deprecated name of the via parameter
+			q["server_name"] = req.Via
+		}
+	})
+	_, err = cli.MakeRequest(ctx, http.MethodPost, urlPath, req, &resp)
+	if err == nil && cli.StateStore != nil {
+		err = cli.StateStore.SetMembership(ctx, resp.RoomID, cli.UserID, event.MembershipKnock)
+		if err != nil {
+			err = fmt.Errorf("failed to update state store: %w", err)
+		}
+	}
+	return
+}
+
+// Knock is a shorthand for KnockRoom with an optional reason and list of servers to knock via.
+func (cli *Client) Knock(ctx context.Context, roomIDorAlias, reason string, via ...string) (*RespKnockRoom, error) {
+	return cli.KnockRoom(ctx, roomIDorAlias, &ReqKnockRoom{Via: via, Reason: reason})
+}
+
 func (cli *Client) GetProfile(ctx context.Context, mxid id.UserID) (resp *RespUserProfile, err error) {
 	urlPath := cli.BuildClientURL("v3", "profile", mxid)
 	_, err = cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, &resp)
@@ -1103,37 +1469,25 @@ func (cli *Client) BeeperUpdateProfile(ctx context.Context, data any) (err error
 // GetAccountData gets the user's account data of this type. See https://spec.matrix.org/v1.2/client-server-api/#get_matrixclientv3useruseridaccount_datatype
 func (cli *Client) GetAccountData(ctx context.Context, name string, output interface{}) (err error) {
 	urlPath := cli.BuildClientURL("v3", "user", cli.UserID, "account_data", name)
-	_, err = cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, output)
-	return
+	return cli.getAccountData(ctx, "", urlPath, name, output)
 }
 
 // SetAccountData sets the user's account data of this type. See https://spec.matrix.org/v1.2/client-server-api/#put_matrixclientv3useruseridaccount_datatype
 func (cli *Client) SetAccountData(ctx context.Context, name string, data interface{}) (err error) {
 	urlPath := cli.BuildClientURL("v3", "user", cli.UserID, "account_data", name)
-	_, err = cli.MakeRequest(ctx, http.MethodPut, urlPath, data, nil)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return cli.setAccountData(ctx, "", urlPath, name, data)
 }
 
 // GetRoomAccountData gets the user's account data of this type in a specific room. See https://spec.matrix.org/v1.2/client-server-api/#put_matrixclientv3useruseridaccount_datatype
 func (cli *Client) GetRoomAccountData(ctx context.Context, roomID id.RoomID, name string, output interface{}) (err error) {
 	urlPath := cli.BuildClientURL("v3", "user", cli.UserID, "rooms", roomID, "account_data", name)
-	_, err = cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, output)
-	return
+	return cli.getAccountData(ctx, roomID, urlPath, name, output)
 }
 
 // SetRoomAccountData sets the user's account data of this type in a specific room. See https://spec.matrix.org/v1.2/client-server-api/#put_matrixclientv3useruseridroomsroomidaccount_datatype
 func (cli *Client) SetRoomAccountData(ctx context.Context, roomID id.RoomID, name string, data interface{}) (err error) {
 	urlPath := cli.BuildClientURL("v3", "user", cli.UserID, "rooms", roomID, "account_data", name)
-	_, err = cli.MakeRequest(ctx, http.MethodPut, urlPath, data, nil)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return cli.setAccountData(ctx, roomID, urlPath, name, data)
 }
 
 type ReqSendEvent struct {
@@ -1550,9 +1904,22 @@ func (cli *Client) StateAsArray(ctx context.Context, roomID id.RoomID) (state []
 	return
 }
 
+// UseAuthenticatedMedia returns whether media should be downloaded using the authenticated media endpoints
+// added in Matrix v1.11. If the supported spec versions haven't been fetched, authenticated media is assumed.
+func (cli *Client) UseAuthenticatedMedia() bool {
+	return cli.SpecVersions == nil || len(cli.SpecVersions.Versions) == 0 || cli.SpecVersions.Supports(FeatureAuthenticatedMedia)
+}
+
+func (cli *Client) buildMediaURL(query map[string]string, path ...any) string {
+	if cli.UseAuthenticatedMedia() {
+		return cli.BuildURLWithQuery(append(ClientURLPath{"v1", "media"}, path...), query)
+	}
+	return cli.BuildURLWithQuery(append(MediaURLPath{"v3"}, path...), query)
+}
+
 // GetMediaConfig fetches the configuration of the content repository, such as upload limitations.
 func (cli *Client) GetMediaConfig(ctx context.Context) (resp *RespMediaConfig, err error) {
-	_, err = cli.MakeRequest(ctx, http.MethodGet, cli.BuildClientURL("v1", "media", "config"), nil, &resp)
+	_, err = cli.MakeRequest(ctx, http.MethodGet, cli.buildMediaURL(nil, "config"), nil, &resp)
 	return
 }
 
@@ -1578,10 +1945,77 @@ func (cli *Client) UploadLink(ctx context.Context, link string) (*RespMediaUploa
 	return cli.Upload(ctx, res.Body, res.Header.Get("Content-Type"), res.ContentLength)
 }
 
+// GetDownloadURL returns the URL and headers that can be used to download the given media
+// without going through this client, e.g. in a media player or a browser.
+//
+// If the server supports authenticated media, the headers include the access token,
+// so they must not be shared with anything that isn't trusted with the token.
+func (cli *Client) GetDownloadURL(mxcURL id.ContentURI) (string, http.Header) {
+	return cli.buildMediaURL(nil, "download", mxcURL.Homeserver, mxcURL.FileID), cli.mediaHeaders()
+}
+
+// GetThumbnailURL returns the URL and headers that can be used to download a thumbnail of the given media
+// without going through this client. See GetDownloadURL for details.
+func (cli *Client) GetThumbnailURL(mxcURL id.ContentURI, params *ReqThumbnail) (string, http.Header) {
+	return cli.buildMediaURL(params.query(), "thumbnail", mxcURL.Homeserver, mxcURL.FileID), cli.mediaHeaders()
+}
+
+func (cli *Client) mediaHeaders() http.Header {
+	headers := make(http.Header)
+	if accessToken := cli.GetAccessToken(); cli.UseAuthenticatedMedia() && accessToken != "" {
+		headers.Set("Authorization", "Bearer "+accessToken)
+	}
+	return headers
+}
+
+// Download downloads the given media. The caller must close the response body.
+//
+// The authenticated media endpoint is used if the server supports it, otherwise the legacy unauthenticated
+// endpoint is used. See https://spec.matrix.org/v1.11/client-server-api/#get_matrixclientv1mediadownloadservernamemediaid
 func (cli *Client) Download(ctx context.Context, mxcURL id.ContentURI) (*http.Response, error) {
 	_, resp, err := cli.MakeFullRequestWithResp(ctx, FullRequest{
 		Method:           http.MethodGet,
-		URL:              cli.BuildClientURL("v1", "media", "download", mxcURL.Homeserver, mxcURL.FileID),
+		URL:              cli.buildMediaURL(nil, "download", mxcURL.Homeserver, mxcURL.FileID),
+		DontReadResponse: true,
+	})
+	return resp, err
+}
+
+// DownloadWithTimeout downloads the given media, waiting up to the given duration for the content to be uploaded
+// if the media was created with CreateMXC and hasn't been uploaded yet. If the content isn't uploaded in time,
+// the error will be MNotYetUploaded. The caller must close the response body.
+//
+// See https://spec.matrix.org/v1.11/client-server-api/#get_matrixclientv1mediadownloadservernamemediaid
+func (cli *Client) DownloadWithTimeout(ctx context.Context, mxcURL id.ContentURI, timeout time.Duration) (*http.Response, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		query := map[string]string{"timeout_ms": strconv.FormatInt(max(time.Until(deadline).Milliseconds(), 0), 10)}
+		_, resp, err := cli.MakeFullRequestWithResp(ctx, FullRequest{
+			Method:           http.MethodGet,
+			URL:              cli.buildMediaURL(query, "download", mxcURL.Homeserver, mxcURL.FileID),
+			DontReadResponse: true,
+			MaxAttempts:      1,
+		})
+		if !errors.Is(err, MNotYetUploaded) || time.Until(deadline) <= 0 {
+			return resp, err
+		}
+		_ = resp.Body.Close()
+		// The server may have a lower maximum timeout, so wait a bit and try again
+		select {
+		case <-time.After(min(1*time.Second, time.Until(deadline))):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// DownloadThumbnail downloads a thumbnail of the given media. The caller must close the response body.
+//
+// See https://spec.matrix.org/v1.11/client-server-api/#get_matrixclientv1mediathumbnailservernamemediaid
+func (cli *Client) DownloadThumbnail(ctx context.Context, mxcURL id.ContentURI, params *ReqThumbnail) (*http.Response, error) {
+	_, resp, err := cli.MakeFullRequestWithResp(ctx, FullRequest{
+		Method:           http.MethodGet,
+		URL:              cli.buildMediaURL(params.query(), "thumbnail", mxcURL.Homeserver, mxcURL.FileID),
 		DontReadResponse: true,
 	})
 	return resp, err
@@ -1631,10 +2065,9 @@ func (cli *Client) UploadAsync(ctx context.Context, req ReqUploadMedia) (*RespCr
 		req.DoneCallback()
 		return nil, err
 	}
-	req.MXC = resp.ContentURI
 	req.UnstableUploadURL = resp.UnstableUploadURL
 	go func() {
-		_, err = cli.UploadMedia(ctx, req)
+		_, err = cli.UploadToMXC(ctx, resp.ContentURI, req)
 		if err != nil {
 			cli.Log.Error().Str("mxc", req.MXC.String()).Err(err).Msg("Async upload of media failed")
 		}
@@ -1642,6 +2075,27 @@ func (cli *Client) UploadAsync(ctx context.Context, req ReqUploadMedia) (*RespCr
 	return resp, nil
 }
 
+// UploadToMXC uploads content to a content URI that was created with CreateMXC.
+//
+// If the server says that the content has already been uploaded, e.g. because the response to a previous attempt
+// was lost, the upload is treated as successful.
+//
+// See https://spec.matrix.org/v1.7/client-server-api/#put_matrixmediav3uploadservernamemediaid
+func (cli *Client) UploadToMXC(ctx context.Context, mxc id.ContentURI, req ReqUploadMedia) (*RespMediaUpload, error) {
+	req.MXC = mxc
+	resp, err := cli.UploadMedia(ctx, req)
+	if errors.Is(err, MCannotOverwriteMedia) {
+		cli.cliOrContextLog(ctx).Debug().Stringer("mxc", mxc).Msg("Media was already uploaded to MXC")
+		return &RespMediaUpload{ContentURI: mxc}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if resp.ContentURI.IsEmpty() {
+		resp.ContentURI = mxc
+	}
+	return resp, nil
+}
+
 func (cli *Client) UploadBytes(ctx context.Context, data []byte, contentType string) (*RespMediaUpload, error) {
 	return cli.UploadBytesWithName(ctx, data, contentType, "")
 }
@@ -1803,9 +2257,9 @@ func (cli *Client) UploadMedia(ctx context.Context, data ReqUploadMedia) (*RespM
 //
 // See https://spec.matrix.org/v1.2/client-server-api/#get_matrixmediav3preview_url
 func (cli *Client) GetURLPreview(ctx context.Context, url string) (*RespPreviewURL, error) {
-	reqURL := cli.BuildURLWithQuery(ClientURLPath{"v1", "media", "preview_url"}, map[string]string{
+	reqURL := cli.buildMediaURL(map[string]string{
 		"url": url,
-	})
+	}, "preview_url")
 	var output RespPreviewURL
 	_, err := cli.MakeRequest(ctx, http.MethodGet, reqURL, nil, &output)
 	return &output, err
@@ -1950,6 +2404,24 @@ func (cli *Client) TimestampToEvent(ctx context.Context, roomID id.RoomID, times
 	return
 }
 
+// GetRelations returns the events that relate to the given event.
+// See https://spec.matrix.org/v1.13/client-server-api/#get_matrixclientv1roomsroomidrelationseventid
+//
+// The request parameter can be used to filter by relation type and event type, and to paginate. It may be nil.
+func (cli *Client) GetRelations(ctx context.Context, roomID id.RoomID, eventID id.EventID, req *ReqGetRelations) (resp *RespGetRelations, err error) {
+	urlPath := cli.BuildURLWithQuery(append(ClientURLPath{"v1", "rooms", roomID, "relations", eventID}, req.PathSuffix()...), req.Query())
+	_, err = cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, &resp)
+	return
+}
+
+// GetThreads returns the thread roots in a room, ordered by the most recent activity.
+// See https://spec.matrix.org/v1.13/client-server-api/#get_matrixclientv1roomsroomidthreads
+func (cli *Client) GetThreads(ctx context.Context, roomID id.RoomID, req *ReqGetThreads) (resp *RespGetThreads, err error) {
+	urlPath := cli.BuildURLWithQuery(ClientURLPath{"v1", "rooms", roomID, "threads"}, req.Query())
+	_, err = cli.MakeRequest(ctx, http.MethodGet, urlPath, nil, &resp)
+	return
+}
+
 // Context returns a number of events that happened just before and after the
 // specified event. It use pagination query parameters to paginate history in
 // the room.
@@ -2369,18 +2841,70 @@ func (cli *Client) PutPushRule(ctx context.Context, scope string, kind pushrules
 	return err
 }
 
+// SetPushRuleEnabled enables or disables the given push rule.
+func (cli *Client) SetPushRuleEnabled(ctx context.Context, scope string, kind pushrules.PushRuleType, ruleID string, enabled bool) error {
+	urlPath := cli.BuildClientURL("v3", "pushrules", scope, kind, ruleID, "enabled")
+	_, err := cli.MakeRequest(ctx, http.MethodPut, urlPath, &ReqSetPushRuleEnabled{Enabled: enabled}, nil)
+	return err
+}
+
+// SetPushRuleActions replaces the actions of the given push rule.
+func (cli *Client) SetPushRuleActions(ctx context.Context, scope string, kind pushrules.PushRuleType, ruleID string, actions pushrules.PushActionArray) error {
+	if actions == nil {
+		actions = pushrules.PushActionArray{}
+	}
+	urlPath := cli.BuildClientURL("v3", "pushrules", scope, kind, ruleID, "actions")
+	_, err := cli.MakeRequest(ctx, http.MethodPut, urlPath, &ReqSetPushRuleActions{Actions: actions}, nil)
+	return err
+}
+
+// ApplyPushRuleChange sends a push rule change created with the mutation methods of pushrules.PushRuleset to the server.
+func (cli *Client) ApplyPushRuleChange(ctx context.Context, scope string, change *pushrules.PushRuleChange) error {
+	urlPath := cli.BuildURLWithQuery(append(ClientURLPath{"v3", "pushrules", scope}, change.PathComponents()...), change.Query())
+	_, err := cli.MakeRequest(ctx, change.Method(), urlPath, change.Body(), nil)
+	return err
+}
+
+// ApplySyncedPushRuleChanges applies the given changes to the synced ruleset and sends them to the server.
+// The ruleset should be kept up to date by registering SyncedRuleset.HandleEvent as a handler for
+// m.push_rules account data events. If sending a change fails, it is reverted locally and the remaining changes are not applied.
+func (cli *Client) ApplySyncedPushRuleChanges(ctx context.Context, rs *pushrules.SyncedRuleset, changes ...*pushrules.PushRuleChange) error {
+	for i, change := range changes {
+		err := rs.Apply(change)
+		if err == nil {
+			err = cli.ApplyPushRuleChange(ctx, "global", change)
+			if err != nil {
+				rs.Revert(change)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply change #%d (%s): %w", i+1, change, err)
+		}
+	}
+	return nil
+}
+
+// ReportEvent reports an event to the homeserver admins. See https://spec.matrix.org/v1.13/client-server-api/#post_matrixclientv3roomsroomidreporteventid
 func (cli *Client) ReportEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID, reason string) error {
 	urlPath := cli.BuildClientURL("v3", "rooms", roomID, "report", eventID)
 	_, err := cli.MakeRequest(ctx, http.MethodPost, urlPath, &ReqReport{Reason: reason, Score: -100}, nil)
 	return err
 }
 
+// ReportRoom reports a room to the homeserver admins. See https://spec.matrix.org/v1.13/client-server-api/#post_matrixclientv3roomsroomidreport
 func (cli *Client) ReportRoom(ctx context.Context, roomID id.RoomID, reason string) error {
 	urlPath := cli.BuildClientURL("v3", "rooms", roomID, "report")
 	_, err := cli.MakeRequest(ctx, http.MethodPost, urlPath, &ReqReport{Reason: reason, Score: -100}, nil)
 	return err
 }
 
+// ReportUser reports a user to the homeserver admins. See https://spec.matrix.org/v1.14/client-server-api/#post_matrixclientv3usersuseridreport
+func (cli *Client) ReportUser(ctx context.Context, userID id.UserID, reason string) error {
+	urlPath := cli.BuildClientURL("v3", "users", userID, "report")
+	_, err := cli.MakeRequest(ctx, http.MethodPost, urlPath, &ReqReport{Reason: reason}, nil)
+	return err
+}
+
 // BatchSend sends a batch of historical events into a room. This is only available for appservices.
 //
 // Deprecated: MSC2716 has been abandoned, so this is now Beeper-specific. BeeperBatchSend should be used instead.
@@ -2420,6 +2944,51 @@ func (cli *Client) BeeperBatchSend(ctx context.Context, roomID id.RoomID, req *R
 	return
 }
 
+// DefaultBatchSendChunkSize is the default maximum number of events per request in BeeperBatchSendChunked.
+const DefaultBatchSendChunkSize = 100
+
+// BeeperBatchSendChunked sends an ordered (oldest first) list of events using BeeperBatchSend,
+// splitting it into multiple requests of at most chunkSize events if necessary.
+//
+// Chunks are sent in an order that preserves the order of events: oldest first when forward backfilling and
+// newest first when backward backfilling. MarkReadBy is only included in the request with the newest events.
+//
+// The event IDs in the response are in the same order as the events in the request. If a request fails,
+// the returned response contains the event IDs of the chunks that were already sent, with empty IDs for the rest.
+func (cli *Client) BeeperBatchSendChunked(ctx context.Context, roomID id.RoomID, req *ReqBeeperBatchSend, chunkSize int) (*RespBeeperBatchSend, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultBatchSendChunkSize
+	}
+	resp := &RespBeeperBatchSend{EventIDs: make([]id.EventID, len(req.Events))}
+	chunkCount := (len(req.Events) + chunkSize - 1) / chunkSize
+	for i := 0; i < chunkCount; i++ {
+		chunkIndex := i
+		if !req.Forward {
+			chunkIndex = chunkCount - i - 1
+		}
+		start := chunkIndex * chunkSize
+		end := min(start+chunkSize, len(req.Events))
+		chunkReq := &ReqBeeperBatchSend{
+			// Only the first request can be forward backfilled due to there being no messages, the rest will go backwards
+			ForwardIfNoMessages: req.ForwardIfNoMessages && i == 0,
+			Forward:             req.Forward,
+			SendNotification:    req.SendNotification,
+			Events:              req.Events[start:end],
+		}
+		if chunkIndex == chunkCount-1 {
+			chunkReq.MarkReadBy = req.MarkReadBy
+		}
+		chunkResp, err := cli.BeeperBatchSend(ctx, roomID, chunkReq)
+		if err != nil {
+			return resp, fmt.Errorf("failed to send chunk %d/%d: %w", i+1, chunkCount, err)
+		} else if len(chunkResp.EventIDs) != end-start {
+			return resp, fmt.Errorf("server returned %d event IDs for chunk %d/%d with %d events", len(chunkResp.EventIDs), i+1, chunkCount, end-start)
+		}
+		copy(resp.EventIDs[start:end], chunkResp.EventIDs)
+	}
+	return resp, nil
+}
+
 func (cli *Client) BeeperMergeRooms(ctx context.Context, req *ReqBeeperMergeRoom) (resp *RespBeeperMergeRoom, err error) {
 	urlPath := cli.BuildClientURL("unstable", "com.beeper.chatmerging", "merge")
 	_, err = cli.MakeRequest(ctx, http.MethodPost, urlPath, req, &resp)
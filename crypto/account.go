@@ -8,6 +8,8 @@ package crypto
 
 import (
 	"encoding/json"
+	"errors"
+	"time"
 
 	"github.com/tidwall/sjson"
 
@@ -18,12 +20,20 @@ import (
 	"maunium.net/go/mautrix/id"
 )
 
+var ErrKeyProviderNotSupported = errors.New("olm account implementation doesn't support key providers")
+
 type OlmAccount struct {
 	Internal         olm.Account
 	signingKey       id.SigningKey
 	identityKey      id.IdentityKey
 	Shared           bool
 	KeyBackupVersion id.KeyBackupVersion
+
+	// State of the fallback key for the rotation policy. These are only used if the
+	// OlmMachine has a FallbackKeyRotationPolicy and the account supports fallback keys.
+	FallbackKeyCreatedAt     time.Time
+	FallbackKeyUses          int
+	PrevFallbackKeyExpiresAt time.Time
 }
 
 func NewOlmAccount() *OlmAccount {
@@ -36,6 +46,55 @@ func NewOlmAccount() *OlmAccount {
 	}
 }
 
+// Wipe zeroes the private keys of the account. The account can't be used or pickled after this.
+func (account *OlmAccount) Wipe() {
+	wipeInternal(account.Internal)
+}
+
+// NewOlmAccountWithKeyProvider creates a new account whose identity keys are held by the given provider.
+func NewOlmAccountWithKeyProvider(provider olm.KeyProvider) (*OlmAccount, error) {
+	account := &OlmAccount{Internal: olm.NewBlankAccount()}
+	return account, account.SetKeyProvider(provider)
+}
+
+// SetKeyProvider delegates all identity key operations of the account to the given provider.
+// The provider's public keys must match the account's existing identity keys (if any).
+//
+// Private identity keys aren't stored in pickles of accounts using a provider,
+// so the provider must be set again every time the account is loaded.
+// If the account still has its private identity keys, [olm.ErrPrivateKeysPresent] is returned
+// and MigrateToKeyProvider must be used instead.
+func (account *OlmAccount) SetKeyProvider(provider olm.KeyProvider) error {
+	return account.setKeyProvider(provider, false)
+}
+
+// MigrateToKeyProvider is like SetKeyProvider, but wipes the private identity keys of the account
+// if it still has them. The keys must have been imported into the provider beforehand.
+func (account *OlmAccount) MigrateToKeyProvider(provider olm.KeyProvider) error {
+	return account.setKeyProvider(provider, true)
+}
+
+// NeedsKeyProvider returns true if the account was migrated to a key provider,
+// but no provider has been set after loading it.
+func (account *OlmAccount) NeedsKeyProvider() bool {
+	kpAccount, ok := account.Internal.(olm.KeyProviderAccount)
+	return ok && kpAccount.NeedsKeyProvider()
+}
+
+func (account *OlmAccount) setKeyProvider(provider olm.KeyProvider, migrate bool) error {
+	kpAccount, ok := account.Internal.(olm.KeyProviderAccount)
+	if !ok {
+		return ErrKeyProviderNotSupported
+	}
+	err := kpAccount.SetKeyProvider(provider, migrate)
+	if err != nil {
+		return err
+	}
+	account.signingKey = ""
+	account.identityKey = ""
+	return nil
+}
+
 func (account *OlmAccount) Keys() (id.SigningKey, id.IdentityKey) {
 	if len(account.signingKey) == 0 || len(account.identityKey) == 0 {
 		var err error
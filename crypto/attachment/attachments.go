@@ -191,8 +191,13 @@ func (r *encryptingReader) Seek(offset int64, whence int) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	block, _ := aes.NewCipher(r.file.decoded.key[:])
-	r.stream = cipher.NewCTR(block, r.file.decoded.iv[:])
+	if r.isDecrypting {
+		// The stream will be recreated on the next Read call
+		r.stream = nil
+	} else {
+		block, _ := aes.NewCipher(r.file.decoded.key[:])
+		r.stream = cipher.NewCTR(block, r.file.decoded.iv[:])
+	}
 	r.hash.Reset()
 	return n, nil
 }
@@ -200,14 +205,22 @@ func (r *encryptingReader) Seek(offset int64, whence int) (int64, error) {
 func (r *encryptingReader) Read(dst []byte) (n int, err error) {
 	if r.closed {
 		return 0, ReaderClosed
-	} else if r.isDecrypting && r.file.decoded == nil {
+	} else if r.isDecrypting && r.stream == nil {
 		if err = r.file.PrepareForDecryption(); err != nil {
 			return
 		}
+		block, _ := aes.NewCipher(r.file.decoded.key[:])
+		r.stream = cipher.NewCTR(block, r.file.decoded.iv[:])
 	}
 	n, err = r.source.Read(dst)
-	r.stream.XORKeyStream(dst[:n], dst[:n])
-	r.hash.Write(dst[:n])
+	if r.isDecrypting {
+		// The hash is of the ciphertext, so it must be calculated before decrypting
+		r.hash.Write(dst[:n])
+		r.stream.XORKeyStream(dst[:n], dst[:n])
+	} else {
+		r.stream.XORKeyStream(dst[:n], dst[:n])
+		r.hash.Write(dst[:n])
+	}
 	return
 }
 
@@ -217,8 +230,11 @@ func (r *encryptingReader) Close() (err error) {
 		err = closer.Close()
 	}
 	if r.isDecrypting {
+		if prepErr := r.file.PrepareForDecryption(); prepErr != nil {
+			return prepErr
+		}
 		var downloadedChecksum [utils.SHAHashLength]byte
-		r.hash.Sum(downloadedChecksum[:])
+		r.hash.Sum(downloadedChecksum[:0])
 		if downloadedChecksum != r.file.decoded.sha256 {
 			return HashMismatch
 		}
@@ -290,11 +306,11 @@ func (ef *EncryptedFile) DecryptInPlace(data []byte) error {
 // The Close call will validate the hash and return an error if it doesn't match.
 // In this case, the written data should be considered compromised and should not be used further.
 func (ef *EncryptedFile) DecryptStream(reader io.Reader) io.ReadSeekCloser {
-	block, _ := aes.NewCipher(ef.decoded.key[:])
 	return &encryptingReader{
-		stream: cipher.NewCTR(block, ef.decoded.iv[:]),
 		hash:   sha256.New(),
 		source: reader,
 		file:   ef,
+
+		isDecrypting: true,
 	}
 }
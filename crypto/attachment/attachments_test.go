@@ -3,6 +3,8 @@ package attachment
 import (
 	"encoding/base64"
 	"encoding/json"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -83,3 +85,21 @@ func TestTooShortHash(t *testing.T) {
 	err := file.DecryptInPlace([]byte(helloWorldCiphertext))
 	assert.ErrorIs(t, err, InvalidHash)
 }
+
+func TestDecryptStreamHelloWorld(t *testing.T) {
+	file := parseHelloWorld()
+	reader := file.DecryptStream(strings.NewReader(helloWorldCiphertext))
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+	assert.NoError(t, reader.Close())
+}
+
+func TestDecryptStreamHashMismatch(t *testing.T) {
+	file := parseHelloWorld()
+	file.Hashes.SHA256 = base64.RawStdEncoding.EncodeToString([]byte(random32Bytes))
+	reader := file.DecryptStream(strings.NewReader(helloWorldCiphertext))
+	_, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, reader.Close(), HashMismatch)
+}
@@ -18,6 +18,7 @@ import (
 
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/backup"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 	"maunium.net/go/mautrix/sqlstatestore"
@@ -33,6 +34,7 @@ type CryptoHelper struct {
 	managedStateStore    *sqlstatestore.SQLStateStore
 	unmanagedCryptoStore crypto.Store
 	dbForManagedStores   *dbutil.Database
+	ownsDB               bool
 
 	DecryptErrorCallback func(*event.Event, error)
 
@@ -41,9 +43,21 @@ type CryptoHelper struct {
 	ASEventProcessor  crypto.ASEventProcessor
 	CustomPostDecrypt func(context.Context, *event.Event)
 
+	// DBAccountID is the account ID used for the managed crypto store. When multiple clients share the same
+	// database, each one must have a unique account ID (e.g. the user ID) so that their crypto data is kept separate.
 	DBAccountID string
+
+	// OnDeviceWiped is called by Init if the olm account is marked as shared, but the device keys have disappeared
+	// from the server, which usually means the device was logged out elsewhere. Init will return ErrDeviceWiped
+	// after the callback returns, so the callback can e.g. clear local data and prepare to log in again.
+	OnDeviceWiped func(ctx context.Context)
+	// OnKeyBackupRestored is called after keys have been successfully restored from key backup with RestoreKeyBackup.
+	OnKeyBackupRestored func(ctx context.Context, version id.KeyBackupVersion)
 }
 
+// ErrDeviceWiped is returned by Init if the device keys have disappeared from the server.
+var ErrDeviceWiped = errors.New("olm account is marked as shared, keys seem to have disappeared from the server")
+
 var _ mautrix.CryptoHelper = (*CryptoHelper)(nil)
 
 // NewCryptoHelper creates a struct that helps a mautrix client struct with Matrix e2ee operations.
@@ -55,7 +69,10 @@ var _ mautrix.CryptoHelper = (*CryptoHelper)(nil)
 //
 // The same database may be shared across multiple clients, but note that doing that will allow all clients access to
 // decryption keys received by any one of the clients. For that reason, the pickle key must also be same for all clients
-// using the same database.
+// using the same database, and DBAccountID must be set to a unique value for each client before calling Init.
+//
+// If a path is passed, the helper owns the created database and Close will close it. Databases and stores passed
+// directly are owned by the caller and are not closed by the helper.
 func NewCryptoHelper(cli *mautrix.Client, pickleKey []byte, store any) (*CryptoHelper, error) {
 	if len(pickleKey) == 0 {
 		return nil, fmt.Errorf("pickle key must be provided")
@@ -68,6 +85,7 @@ func NewCryptoHelper(cli *mautrix.Client, pickleKey []byte, store any) (*CryptoH
 	var managedStateStore *sqlstatestore.SQLStateStore
 	var dbForManagedStores *dbutil.Database
 	var unmanagedCryptoStore crypto.Store
+	var ownsDB bool
 	switch typedStore := store.(type) {
 	case crypto.Store:
 		if cli.StateStore == nil {
@@ -82,6 +100,7 @@ func NewCryptoHelper(cli *mautrix.Client, pickleKey []byte, store any) (*CryptoH
 			return nil, err
 		}
 		dbForManagedStores = db
+		ownsDB = true
 	case *dbutil.Database:
 		dbForManagedStores = typedStore
 	default:
@@ -103,6 +122,7 @@ func NewCryptoHelper(cli *mautrix.Client, pickleKey []byte, store any) (*CryptoH
 		unmanagedCryptoStore: unmanagedCryptoStore,
 		managedStateStore:    managedStateStore,
 		dbForManagedStores:   dbForManagedStores,
+		ownsDB:               ownsDB,
 
 		DecryptErrorCallback: func(_ *event.Event, _ error) {},
 	}, nil
@@ -231,7 +251,10 @@ func (helper *CryptoHelper) Init(ctx context.Context) error {
 }
 
 func (helper *CryptoHelper) Close() error {
-	if helper != nil && helper.dbForManagedStores != nil {
+	if helper != nil && helper.mach != nil {
+		helper.mach.Close()
+	}
+	if helper != nil && helper.dbForManagedStores != nil && helper.ownsDB {
 		err := helper.dbForManagedStores.Close()
 		if err != nil {
 			return err
@@ -262,7 +285,10 @@ func (helper *CryptoHelper) verifyDeviceKeysOnServer(ctx context.Context) error
 	device, ok := resp.DeviceKeys[helper.client.UserID][helper.client.DeviceID]
 	if !ok || len(device.Keys) == 0 {
 		if isShared {
-			return fmt.Errorf("olm account is marked as shared, keys seem to have disappeared from the server")
+			if helper.OnDeviceWiped != nil {
+				helper.OnDeviceWiped(ctx)
+			}
+			return ErrDeviceWiped
 		} else {
 			helper.log.Debug().Msg("Olm account not shared and keys not on server, so device is probably fine")
 			return nil
@@ -280,6 +306,21 @@ func (helper *CryptoHelper) verifyDeviceKeysOnServer(ctx context.Context) error
 	return nil
 }
 
+// RestoreKeyBackup downloads the latest key backup from the server, decrypts it with the given key
+// and stores the sessions in the crypto store. OnKeyBackupRestored is called if the restore succeeds.
+func (helper *CryptoHelper) RestoreKeyBackup(ctx context.Context, megolmBackupKey *backup.MegolmBackupKey) (id.KeyBackupVersion, error) {
+	if helper == nil {
+		return "", fmt.Errorf("crypto helper is nil")
+	}
+	version, err := helper.mach.DownloadAndStoreLatestKeyBackup(ctx, megolmBackupKey)
+	if err != nil {
+		return version, err
+	} else if version != "" && helper.OnKeyBackupRestored != nil {
+		helper.OnKeyBackupRestored(ctx, version)
+	}
+	return version, nil
+}
+
 var NoSessionFound = crypto.NoSessionFound
 
 const initialSessionWaitTimeout = 3 * time.Second
@@ -417,6 +458,12 @@ func (helper *CryptoHelper) EncryptWithStateKey(ctx context.Context, roomID id.R
 			Msg("Got session error while encrypting event, sharing group session and trying again")
 		var users []id.UserID
 		users, err = helper.client.StateStore.GetRoomJoinedOrInvitedMembers(ctx, roomID)
+		if errors.Is(err, mautrix.ErrLRUStateEvicted) {
+			// The cached member list is incomplete, refetch it so that the session is shared with everyone
+			if _, err = helper.client.Members(ctx, roomID); err == nil {
+				users, err = helper.client.StateStore.GetRoomJoinedOrInvitedMembers(ctx, roomID)
+			}
+		}
 		if err != nil {
 			err = fmt.Errorf("failed to get room member list: %w", err)
 		} else if err = helper.mach.ShareGroupSession(ctx, roomID, users); err != nil {
@@ -12,12 +12,14 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
 	"go.mau.fi/util/exgjson"
 
+	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/crypto/olm"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
@@ -46,7 +48,8 @@ var (
 )
 
 // DecryptMegolmEvent decrypts an m.room.encrypted event where the algorithm is m.megolm.v1.aes-sha2
-func (mach *OlmMachine) DecryptMegolmEvent(ctx context.Context, evt *event.Event) (*event.Event, error) {
+func (mach *OlmMachine) DecryptMegolmEvent(ctx context.Context, evt *event.Event) (_ *event.Event, err error) {
+	defer mach.observeCryptoOperation(ctx, mautrix.CryptoOpDecryptMegolm, time.Now(), &err)
 	content, ok := evt.Content.Parsed.(*event.EncryptedEventContent)
 	if !ok {
 		return nil, IncorrectEncryptedContentType
@@ -113,6 +116,10 @@ func (mach *OlmMachine) DecryptMegolmEvent(ctx context.Context, evt *event.Event
 				if err != nil {
 					return nil, err
 				}
+			} else if sess.ForwarderTrust != id.TrustStateUnset {
+				// The key was forwarded by another user (e.g. shared history keys), so use the trust
+				// of the forwarding device, but never report it as more trusted than a forwarded key.
+				trustLevel = min(sess.ForwarderTrust, id.TrustStateForwarded)
 			} else {
 				log.Debug().
 					Str("forward_last_sender_key", lastChainItem).
@@ -221,7 +228,9 @@ func (mach *OlmMachine) actuallyDecryptMegolmEvent(ctx context.Context, evt *eve
 	defer mach.megolmDecryptLock.Unlock()
 
 	sess, err := mach.CryptoStore.GetGroupSession(ctx, encryptionRoomID, content.SessionID)
-	if err != nil {
+	if errors.Is(err, ErrGroupSessionWithheld) {
+		return nil, nil, 0, fmt.Errorf("failed to decrypt megolm event: %w", err)
+	} else if err != nil {
 		return nil, nil, 0, fmt.Errorf("failed to get group session: %w", err)
 	} else if sess == nil {
 		return nil, nil, 0, fmt.Errorf("%w (ID %s)", NoSessionFound, content.SessionID)
@@ -241,6 +250,14 @@ func (mach *OlmMachine) actuallyDecryptMegolmEvent(ctx context.Context, evt *eve
 		return sess, nil, messageIndex, fmt.Errorf("%w %d", DuplicateMessageIndex, messageIndex)
 	}
 
+	// Store the session again if decrypting advanced its ratchet a lot, so it doesn't have to be
+	// re-ratcheted from the old state every time it's loaded (goolm only).
+	if persister, ok := sess.Internal.(interface{ NeedsPersisting() bool }); ok && persister.NeedsPersisting() {
+		if err = mach.CryptoStore.PutGroupSession(ctx, sess); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to store group session after advancing ratchet")
+		}
+	}
+
 	// Normal clients don't care about tracking the ratchet state, so let them bypass the rest of the function
 	if mach.DisableRatchetTracking {
 		return sess, plaintext, messageIndex, nil
@@ -18,6 +18,7 @@ import (
 
 	"github.com/rs/zerolog"
 
+	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 )
@@ -50,7 +51,8 @@ type DecryptedOlmEvent struct {
 	Content event.Content `json:"content"`
 }
 
-func (mach *OlmMachine) decryptOlmEvent(ctx context.Context, evt *event.Event) (*DecryptedOlmEvent, error) {
+func (mach *OlmMachine) decryptOlmEvent(ctx context.Context, evt *event.Event) (_ *DecryptedOlmEvent, err error) {
+	defer mach.observeCryptoOperation(ctx, mautrix.CryptoOpDecryptOlm, time.Now(), &err)
 	content, ok := evt.Content.Parsed.(*event.EncryptedEventContent)
 	if !ok {
 		return nil, IncorrectEncryptedContentType
@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
 	"go.mau.fi/util/exzerolog"
@@ -178,6 +179,7 @@ func (mach *OlmMachine) storeDeviceSelfSignatures(ctx context.Context, userID id
 // whose device lists have been stored with the PutDevices function on the
 // [Store]. See the FilterTrackedUsers function on [Store] for details.
 func (mach *OlmMachine) FetchKeys(ctx context.Context, users []id.UserID, includeUntracked bool) (data map[id.UserID]map[id.DeviceID]*id.Device, err error) {
+	defer mach.observeCryptoOperation(ctx, mautrix.CryptoOpFetchKeys, time.Now(), &err)
 	req := &mautrix.ReqQueryKeys{
 		DeviceKeys: mautrix.DeviceKeysRequest{},
 		Timeout:    10 * 1000,
@@ -246,6 +248,7 @@ func (mach *OlmMachine) FetchKeys(ctx context.Context, users []id.UserID, includ
 
 		changed = changed || len(newDevices) != len(existingDevices)
 		if changed {
+			mach.dispatchDeviceListChange(ctx, userID, existingDevices, newDevices)
 			if mach.DeleteKeysOnDeviceDelete {
 				for deviceID := range newDevices {
 					delete(existingDevices, deviceID)
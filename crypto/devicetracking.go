@@ -0,0 +1,213 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package crypto
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mau.fi/util/exzerolog"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// deviceListQueryBatchSize is the maximum number of users to include in a single /keys/query request
+	// when updating outdated device lists.
+	deviceListQueryBatchSize = 100
+	deviceListMinRetryDelay  = 5 * time.Second
+	deviceListMaxRetryDelay  = 10 * time.Minute
+)
+
+// DeviceListTrackingState is the state of the device list of a user.
+type DeviceListTrackingState int
+
+const (
+	// DeviceListUntracked means the device list of the user isn't tracked.
+	DeviceListUntracked DeviceListTrackingState = iota
+	// DeviceListUpToDate means the device list is tracked and up to date.
+	DeviceListUpToDate
+	// DeviceListOutdated means the device list is tracked, but the server has reported changes
+	// that haven't been fetched yet.
+	DeviceListOutdated
+)
+
+func (dlts DeviceListTrackingState) String() string {
+	switch dlts {
+	case DeviceListUntracked:
+		return "untracked"
+	case DeviceListUpToDate:
+		return "up to date"
+	case DeviceListOutdated:
+		return "outdated"
+	default:
+		return fmt.Sprintf("DeviceListTrackingState(%d)", int(dlts))
+	}
+}
+
+// DeviceListChange describes a change in the device list of a user.
+type DeviceListChange struct {
+	UserID  id.UserID
+	Added   []*id.Device
+	Removed []*id.Device
+}
+
+// DeviceListChangeFunc is called when devices are added to or removed from the device list of a user.
+type DeviceListChangeFunc func(ctx context.Context, change *DeviceListChange)
+
+type deviceListListener struct {
+	fn DeviceListChangeFunc
+}
+
+type deviceListBackoff struct {
+	failures int
+	retryAt  time.Time
+}
+
+func deviceListRetryDelay(failures int) time.Duration {
+	return min(deviceListMinRetryDelay<<min(failures-1, 16), deviceListMaxRetryDelay)
+}
+
+// OnDeviceListChange adds a function that is called whenever devices are added to or removed
+// from the device list of the given user. The returned function removes the listener.
+func (mach *OlmMachine) OnDeviceListChange(userID id.UserID, fn DeviceListChangeFunc) (remove func()) {
+	listener := &deviceListListener{fn: fn}
+	mach.deviceListListenersLock.Lock()
+	if mach.deviceListListeners == nil {
+		mach.deviceListListeners = make(map[id.UserID][]*deviceListListener)
+	}
+	mach.deviceListListeners[userID] = append(mach.deviceListListeners[userID], listener)
+	mach.deviceListListenersLock.Unlock()
+	return func() {
+		mach.deviceListListenersLock.Lock()
+		listeners := slices.DeleteFunc(mach.deviceListListeners[userID], func(l *deviceListListener) bool {
+			return l == listener
+		})
+		if len(listeners) == 0 {
+			delete(mach.deviceListListeners, userID)
+		} else {
+			mach.deviceListListeners[userID] = listeners
+		}
+		mach.deviceListListenersLock.Unlock()
+	}
+}
+
+func (mach *OlmMachine) dispatchDeviceListChange(ctx context.Context, userID id.UserID, oldDevices, newDevices map[id.DeviceID]*id.Device) {
+	mach.deviceListListenersLock.RLock()
+	listeners := slices.Clone(mach.deviceListListeners[userID])
+	mach.deviceListListenersLock.RUnlock()
+	if len(listeners) == 0 {
+		return
+	}
+	change := &DeviceListChange{UserID: userID}
+	for deviceID, device := range newDevices {
+		if _, ok := oldDevices[deviceID]; !ok {
+			change.Added = append(change.Added, device)
+		}
+	}
+	for deviceID, device := range oldDevices {
+		if _, ok := newDevices[deviceID]; !ok {
+			change.Removed = append(change.Removed, device)
+		}
+	}
+	if len(change.Added) == 0 && len(change.Removed) == 0 {
+		return
+	}
+	for _, listener := range listeners {
+		listener.fn(ctx, change)
+	}
+}
+
+// GetDeviceListTrackingState returns whether the device list of the given user is tracked and up to date.
+func (mach *OlmMachine) GetDeviceListTrackingState(ctx context.Context, userID id.UserID) (DeviceListTrackingState, error) {
+	tracked, err := mach.CryptoStore.FilterTrackedUsers(ctx, []id.UserID{userID})
+	if err != nil {
+		return DeviceListUntracked, fmt.Errorf("failed to check if user is tracked: %w", err)
+	} else if len(tracked) == 0 {
+		return DeviceListUntracked, nil
+	}
+	outdated, err := mach.CryptoStore.GetOutdatedTrackedUsers(ctx)
+	if err != nil {
+		return DeviceListUntracked, fmt.Errorf("failed to get outdated users: %w", err)
+	} else if slices.Contains(outdated, userID) {
+		return DeviceListOutdated, nil
+	}
+	return DeviceListUpToDate, nil
+}
+
+// UpdateOutdatedDeviceLists fetches the device lists of all tracked users that have been marked as outdated.
+//
+// Users are queried in batches. If a query fails, the remaining users stay outdated and further
+// updates are skipped until an exponentially increasing retry delay has passed. Users that the
+// server doesn't return any keys for stay outdated too, but they're backed off individually so
+// that they don't get queried again on every sync.
+//
+// This is called automatically by HandleDeviceLists and usually doesn't need to be called manually.
+func (mach *OlmMachine) UpdateOutdatedDeviceLists(ctx context.Context) error {
+	mach.deviceListUpdateLock.Lock()
+	defer mach.deviceListUpdateLock.Unlock()
+	now := time.Now()
+	if now.Before(mach.deviceListRetryAt) {
+		return nil
+	}
+	users, err := mach.CryptoStore.GetOutdatedTrackedUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get outdated users: %w", err)
+	}
+	users = slices.DeleteFunc(users, func(userID id.UserID) bool {
+		backoff, ok := mach.deviceListUserBackoff[userID]
+		return ok && now.Before(backoff.retryAt)
+	})
+	log := mach.machOrContextLog(ctx)
+	for len(users) > 0 {
+		batch := users[:min(len(users), deviceListQueryBatchSize)]
+		users = users[len(batch):]
+		var data map[id.UserID]map[id.DeviceID]*id.Device
+		data, err = mach.FetchKeys(ctx, batch, true)
+		if err != nil {
+			mach.deviceListFailures++
+			delay := deviceListRetryDelay(mach.deviceListFailures)
+			mach.deviceListRetryAt = time.Now().Add(delay)
+			log.Err(err).
+				Array("users", exzerolog.ArrayOfStrs(batch)).
+				Int("remaining_count", len(users)).
+				Stringer("retry_in", delay).
+				Msg("Failed to update outdated device lists")
+			return err
+		}
+		mach.backoffMissingDeviceLists(ctx, batch, data)
+	}
+	mach.deviceListFailures = 0
+	return nil
+}
+
+func (mach *OlmMachine) backoffMissingDeviceLists(ctx context.Context, batch []id.UserID, data map[id.UserID]map[id.DeviceID]*id.Device) {
+	for _, userID := range batch {
+		if _, ok := data[userID]; ok {
+			delete(mach.deviceListUserBackoff, userID)
+			continue
+		}
+		if mach.deviceListUserBackoff == nil {
+			mach.deviceListUserBackoff = make(map[id.UserID]*deviceListBackoff)
+		}
+		backoff, ok := mach.deviceListUserBackoff[userID]
+		if !ok {
+			backoff = &deviceListBackoff{}
+			mach.deviceListUserBackoff[userID] = backoff
+		}
+		backoff.failures++
+		delay := deviceListRetryDelay(backoff.failures)
+		backoff.retryAt = time.Now().Add(delay)
+		mach.machOrContextLog(ctx).Debug().
+			Stringer("user_id", userID).
+			Int("failures", backoff.failures).
+			Stringer("retry_in", delay).
+			Msg("Server didn't return keys for outdated user, backing off")
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package crypto
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestDeviceListTrackingState(t *testing.T) {
+	ctx := context.TODO()
+	mach := newMachine(t, "@mautrix1:example.com")
+	userID := id.UserID("@alice:example.com")
+
+	state, err := mach.GetDeviceListTrackingState(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, DeviceListUntracked, state)
+
+	err = mach.CryptoStore.PutDevices(ctx, userID, map[id.DeviceID]*id.Device{})
+	require.NoError(t, err)
+	state, err = mach.GetDeviceListTrackingState(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, DeviceListUpToDate, state)
+
+	err = mach.CryptoStore.MarkTrackedUsersOutdated(ctx, []id.UserID{userID})
+	require.NoError(t, err)
+	state, err = mach.GetDeviceListTrackingState(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, DeviceListOutdated, state)
+}
+
+func TestOnDeviceListChange(t *testing.T) {
+	ctx := context.TODO()
+	mach := newMachine(t, "@mautrix1:example.com")
+	userID := id.UserID("@alice:example.com")
+	oldDevices := map[id.DeviceID]*id.Device{
+		"DEVICE1": {UserID: userID, DeviceID: "DEVICE1"},
+		"DEVICE2": {UserID: userID, DeviceID: "DEVICE2"},
+	}
+	newDevices := map[id.DeviceID]*id.Device{
+		"DEVICE2": oldDevices["DEVICE2"],
+		"DEVICE3": {UserID: userID, DeviceID: "DEVICE3"},
+	}
+
+	var changes []*DeviceListChange
+	remove := mach.OnDeviceListChange(userID, func(ctx context.Context, change *DeviceListChange) {
+		changes = append(changes, change)
+	})
+	mach.dispatchDeviceListChange(ctx, "@bob:example.com", oldDevices, newDevices)
+	assert.Len(t, changes, 0, "listener shouldn't be called for other users")
+	mach.dispatchDeviceListChange(ctx, userID, oldDevices, newDevices)
+	require.Len(t, changes, 1)
+	assert.Equal(t, userID, changes[0].UserID)
+	assert.Equal(t, []*id.Device{newDevices["DEVICE3"]}, changes[0].Added)
+	assert.Equal(t, []*id.Device{oldDevices["DEVICE1"]}, changes[0].Removed)
+
+	mach.dispatchDeviceListChange(ctx, userID, newDevices, newDevices)
+	assert.Len(t, changes, 1, "listener shouldn't be called when nothing changed")
+
+	remove()
+	mach.dispatchDeviceListChange(ctx, userID, oldDevices, newDevices)
+	assert.Len(t, changes, 1, "listener shouldn't be called after removal")
+	assert.Empty(t, mach.deviceListListeners)
+}
+
+func TestUpdateOutdatedDeviceLists(t *testing.T) {
+	ctx := context.TODO()
+	mach := newMachine(t, "@mautrix1:example.com")
+	missingUser := id.UserID("@missing:example.com")
+	var queries [][]id.UserID
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req mautrix.ReqQueryKeys
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		resp := mautrix.RespQueryKeys{DeviceKeys: make(map[id.UserID]map[id.DeviceID]mautrix.DeviceKeys)}
+		var users []id.UserID
+		for userID := range req.DeviceKeys {
+			users = append(users, userID)
+			if userID != missingUser {
+				resp.DeviceKeys[userID] = map[id.DeviceID]mautrix.DeviceKeys{}
+			}
+		}
+		queries = append(queries, users)
+		_ = json.NewEncoder(w).Encode(&resp)
+	}))
+	defer ts.Close()
+	var err error
+	mach.Client.HomeserverURL, err = url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	users := []id.UserID{missingUser}
+	for i := 0; i < deviceListQueryBatchSize+10; i++ {
+		users = append(users, id.UserID(fmt.Sprintf("@user%d:example.com", i)))
+	}
+	for _, userID := range users {
+		require.NoError(t, mach.CryptoStore.PutDevices(ctx, userID, map[id.DeviceID]*id.Device{}))
+	}
+	require.NoError(t, mach.CryptoStore.MarkTrackedUsersOutdated(ctx, users))
+
+	require.NoError(t, mach.UpdateOutdatedDeviceLists(ctx))
+	require.Len(t, queries, 2, "outdated users should be queried in batches")
+	assert.Len(t, queries[0], deviceListQueryBatchSize)
+	assert.Len(t, queries[1], 11)
+	outdated, err := mach.CryptoStore.GetOutdatedTrackedUsers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []id.UserID{missingUser}, outdated)
+
+	require.NoError(t, mach.UpdateOutdatedDeviceLists(ctx))
+	assert.Len(t, queries, 2, "user missing from the response should be backed off")
+
+	mach.deviceListUserBackoff[missingUser].retryAt = time.Now().Add(-time.Second)
+	require.NoError(t, mach.UpdateOutdatedDeviceLists(ctx))
+	require.Len(t, queries, 3, "user should be queried again after the backoff")
+	assert.Equal(t, []id.UserID{missingUser}, queries[2])
+	assert.Equal(t, 2, mach.deviceListUserBackoff[missingUser].failures)
+	assert.Equal(t, 2*deviceListMinRetryDelay, deviceListRetryDelay(2))
+}
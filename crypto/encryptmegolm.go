@@ -13,6 +13,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/tidwall/gjson"
@@ -102,6 +104,37 @@ func ParseMegolmMessageIndex(ciphertext []byte) (uint, error) {
 	return uint(index), nil
 }
 
+type roomLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockMegolmEncrypt locks outbound group session operations in the given room.
+// The returned function must be called to unlock the room.
+func (mach *OlmMachine) lockMegolmEncrypt(roomID id.RoomID) func() {
+	mach.megolmEncryptLocksLock.Lock()
+	if mach.megolmEncryptLocks == nil {
+		mach.megolmEncryptLocks = make(map[id.RoomID]*roomLock)
+	}
+	lock, ok := mach.megolmEncryptLocks[roomID]
+	if !ok {
+		lock = &roomLock{}
+		mach.megolmEncryptLocks[roomID] = lock
+	}
+	lock.refs++
+	mach.megolmEncryptLocksLock.Unlock()
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		mach.megolmEncryptLocksLock.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(mach.megolmEncryptLocks, roomID)
+		}
+		mach.megolmEncryptLocksLock.Unlock()
+	}
+}
+
 // EncryptMegolmEvent encrypts data with the m.megolm.v1.aes-sha2 algorithm.
 //
 // If you use the event.Content struct, make sure you pass a pointer to the struct,
@@ -114,9 +147,9 @@ func (mach *OlmMachine) EncryptMegolmEvent(ctx context.Context, roomID id.RoomID
 //
 // If you use the event.Content struct, make sure you pass a pointer to the struct,
 // as JSON serialization will not work correctly otherwise.
-func (mach *OlmMachine) EncryptMegolmEventWithStateKey(ctx context.Context, roomID id.RoomID, evtType event.Type, stateKey *string, content interface{}) (*event.EncryptedEventContent, error) {
-	mach.megolmEncryptLock.Lock()
-	defer mach.megolmEncryptLock.Unlock()
+func (mach *OlmMachine) EncryptMegolmEventWithStateKey(ctx context.Context, roomID id.RoomID, evtType event.Type, stateKey *string, content interface{}) (_ *event.EncryptedEventContent, err error) {
+	defer mach.observeCryptoOperation(ctx, mautrix.CryptoOpEncryptMegolm, time.Now(), &err)
+	defer mach.lockMegolmEncrypt(roomID)()
 	session, err := mach.CryptoStore.GetOutboundGroupSession(ctx, roomID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get outbound group session: %w", err)
@@ -171,6 +204,21 @@ func (mach *OlmMachine) EncryptMegolmEventWithStateKey(ctx context.Context, room
 	return encrypted, nil
 }
 
+// SetMegolmRotationOverride sets the rotation settings for outbound Megolm sessions in the given room,
+// which take precedence over the rotation period in the room's m.room.encryption event.
+// This can be used to enforce stricter rotation in sensitive rooms, but not to loosen the rotation
+// requested by the room. Passing nil removes the override.
+//
+// The current outbound session of the room is discarded, so the next message will use a new session
+// with the updated settings.
+func (mach *OlmMachine) SetMegolmRotationOverride(ctx context.Context, roomID id.RoomID, override *MegolmRotationOverride) error {
+	err := mach.CryptoStore.PutMegolmRotationOverride(ctx, roomID, override)
+	if err != nil {
+		return fmt.Errorf("failed to store rotation override: %w", err)
+	}
+	return mach.CryptoStore.RemoveOutboundGroupSession(ctx, roomID)
+}
+
 func (mach *OlmMachine) newOutboundGroupSession(ctx context.Context, roomID id.RoomID) (*OutboundGroupSession, error) {
 	encryptionEvent, err := mach.StateStore.GetEncryptionEvent(ctx, roomID)
 	if err != nil {
@@ -183,9 +231,22 @@ func (mach *OlmMachine) newOutboundGroupSession(ctx context.Context, roomID id.R
 	if err != nil {
 		return nil, err
 	}
+	override, err := mach.CryptoStore.GetMegolmRotationOverride(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rotation override for room %s: %w", roomID, err)
+	} else if override != nil {
+		session.applyRotationOverride(override)
+	}
+	if hvStore, ok := mach.StateStore.(HistoryVisibilityStateStore); ok {
+		visibility, err := hvStore.GetHistoryVisibility(ctx, roomID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get history visibility of room %s: %w", roomID, err)
+		}
+		session.SharedHistory = visibility == event.HistoryVisibilityShared || visibility == event.HistoryVisibilityWorldReadable
+	}
 	if !mach.DontStoreOutboundKeys {
 		signingKey, idKey := mach.account.Keys()
-		err := mach.createGroupSession(ctx, idKey, signingKey, roomID, session.ID(), session.Internal.Key(), session.MaxAge, session.MaxMessages, false)
+		err := mach.createGroupSession(ctx, idKey, signingKey, roomID, session.ID(), session.Internal.Key(), session.MaxAge, session.MaxMessages, false, session.SharedHistory)
 		if err != nil {
 			return nil, err
 		}
@@ -199,17 +260,23 @@ type deviceSessionWrapper struct {
 }
 
 // ShareGroupSession shares a group session for a specific room with all the devices of the given user list.
+// If the current session has already been shared with all known devices of the users, AlreadyShared is returned.
 //
 // For devices with TrustStateBlacklisted, a m.room_key.withheld event with code=m.blacklisted is sent.
 // If AllowUnverifiedDevices is false, a similar event with code=m.unverified is sent to devices with TrustStateUnset
-func (mach *OlmMachine) ShareGroupSession(ctx context.Context, roomID id.RoomID, users []id.UserID) error {
-	mach.megolmEncryptLock.Lock()
-	defer mach.megolmEncryptLock.Unlock()
+func (mach *OlmMachine) ShareGroupSession(ctx context.Context, roomID id.RoomID, users []id.UserID) (err error) {
+	defer mach.observeCryptoOperation(ctx, mautrix.CryptoOpShareGroupSession, time.Now(), &err)
+	defer mach.lockMegolmEncrypt(roomID)()
 	session, err := mach.CryptoStore.GetOutboundGroupSession(ctx, roomID)
 	if err != nil {
 		return fmt.Errorf("failed to get previous outbound group session: %w", err)
 	} else if session != nil && session.Shared && !session.Expired() {
-		return AlreadyShared
+		// Users who joined after the session was shared still need to receive it
+		if sharedWithAll, err := mach.isSharedWithAll(ctx, session, users); err != nil {
+			return fmt.Errorf("failed to check if group session is shared with all users: %w", err)
+		} else if sharedWithAll {
+			return AlreadyShared
+		}
 	}
 	log := mach.machOrContextLog(ctx).With().
 		Str("room_id", roomID.String()).
@@ -344,6 +411,26 @@ func (mach *OlmMachine) ShareGroupSession(ctx context.Context, roomID id.RoomID,
 	return mach.CryptoStore.AddOutboundGroupSession(ctx, session)
 }
 
+// isSharedWithAll checks if the given outbound group session has been shared with (or intentionally
+// not shared with) all known devices of the given users.
+func (mach *OlmMachine) isSharedWithAll(ctx context.Context, session *OutboundGroupSession, users []id.UserID) (bool, error) {
+	for _, userID := range users {
+		devices, err := mach.CryptoStore.GetDevices(ctx, userID)
+		if err != nil {
+			return false, err
+		} else if devices == nil {
+			// The devices of the user haven't been fetched yet
+			return false, nil
+		}
+		for deviceID := range devices {
+			if session.Users[UserDevice{UserID: userID, DeviceID: deviceID}] == OGSNotShared {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
 func (mach *OlmMachine) encryptAndSendGroupSession(ctx context.Context, session *OutboundGroupSession, olmSessions map[id.UserID]map[id.DeviceID]deviceSessionWrapper) error {
 	mach.olmLock.Lock()
 	defer mach.olmLock.Unlock()
@@ -363,7 +450,10 @@ func (mach *OlmMachine) encryptAndSendGroupSession(ctx context.Context, session
 				Stringer("target_device_id", deviceID).
 				Stringer("target_identity_key", device.identity.IdentityKey).
 				Msg("Encrypting group session for device")
-			content := mach.encryptOlmEvent(ctx, device.session, device.identity, event.ToDeviceRoomKey, session.ShareContent())
+			content, err := mach.encryptOlmEvent(ctx, device.session, device.identity, event.ToDeviceRoomKey, session.ShareContent())
+			if err != nil {
+				return fmt.Errorf("failed to encrypt group session for %s/%s: %w", userID, deviceID, err)
+			}
 			output[deviceID] = &event.Content{Parsed: content}
 			deviceCount++
 			log.Debug().
@@ -0,0 +1,143 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package crypto
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/olm"
+	"maunium.net/go/mautrix/crypto/signatures"
+	"maunium.net/go/mautrix/id"
+)
+
+// FallbackKeyRotationPolicy configures when the OlmMachine generates and uploads new fallback keys.
+type FallbackKeyRotationPolicy struct {
+	// MaxUses is the number of times the fallback key can be used before it's rotated.
+	// Zero means the key isn't rotated based on the number of uses.
+	MaxUses int
+	// MaxAge is how old the fallback key can be before it's rotated.
+	// Zero means the key isn't rotated based on age.
+	MaxAge time.Duration
+	// GracePeriod is how long the previous fallback key stays valid after rotation, so that
+	// devices which fetched the old key shortly before the rotation can still create sessions.
+	GracePeriod time.Duration
+}
+
+// DefaultFallbackKeyRotationPolicy rotates the fallback key as soon as it's used or when it's a week old.
+var DefaultFallbackKeyRotationPolicy = FallbackKeyRotationPolicy{
+	MaxUses:     1,
+	MaxAge:      7 * 24 * time.Hour,
+	GracePeriod: 1 * time.Hour,
+}
+
+func (account *OlmAccount) shouldRotateFallbackKey(policy *FallbackKeyRotationPolicy) bool {
+	return account.FallbackKeyCreatedAt.IsZero() ||
+		(policy.MaxUses > 0 && account.FallbackKeyUses >= policy.MaxUses) ||
+		(policy.MaxAge > 0 && time.Since(account.FallbackKeyCreatedAt) > policy.MaxAge)
+}
+
+func (account *OlmAccount) rotateFallbackKey(fbAccount olm.FallbackKeyAccount, gracePeriod time.Duration) error {
+	err := fbAccount.GenFallbackKey()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if !account.FallbackKeyCreatedAt.IsZero() {
+		account.PrevFallbackKeyExpiresAt = now.Add(gracePeriod)
+	}
+	account.FallbackKeyCreatedAt = now
+	account.FallbackKeyUses = 0
+	return nil
+}
+
+func (account *OlmAccount) forgetExpiredFallbackKey(fbAccount olm.FallbackKeyAccount) bool {
+	if account.PrevFallbackKeyExpiresAt.IsZero() || time.Now().Before(account.PrevFallbackKeyExpiresAt) {
+		return false
+	}
+	fbAccount.ForgetOldFallbackKey()
+	account.PrevFallbackKeyExpiresAt = time.Time{}
+	return true
+}
+
+func (account *OlmAccount) getFallbackKeys(userID id.UserID, deviceID id.DeviceID, fbAccount olm.FallbackKeyAccount) map[id.KeyID]mautrix.OneTimeKey {
+	fallbackKeys := make(map[id.KeyID]mautrix.OneTimeKey)
+	for keyID, key := range fbAccount.FallbackKeyUnpublished() {
+		key := mautrix.OneTimeKey{Key: key, Fallback: true}
+		signature, _ := account.SignJSON(key)
+		key.Signatures = signatures.NewSingleSignature(userID, id.KeyAlgorithmEd25519, deviceID.String(), signature)
+		key.IsSigned = true
+		fallbackKeys[id.NewKeyID(id.KeyAlgorithmSignedCurve25519, keyID)] = key
+	}
+	return fallbackKeys
+}
+
+func (mach *OlmMachine) fallbackKeyAccount() (olm.FallbackKeyAccount, bool) {
+	if mach.FallbackKeyRotation == nil {
+		return nil, false
+	}
+	fbAccount, ok := mach.account.Internal.(olm.FallbackKeyAccount)
+	return fbAccount, ok
+}
+
+// prepareFallbackKeys rotates the fallback key if necessary and returns the fallback keys that should be uploaded.
+// The caller must hold otkUploadLock.
+func (mach *OlmMachine) prepareFallbackKeys() (map[id.KeyID]mautrix.OneTimeKey, error) {
+	fbAccount, ok := mach.fallbackKeyAccount()
+	if !ok {
+		return nil, nil
+	}
+	if mach.account.shouldRotateFallbackKey(mach.FallbackKeyRotation) {
+		err := mach.account.rotateFallbackKey(fbAccount, mach.FallbackKeyRotation.GracePeriod)
+		if err != nil {
+			return nil, err
+		}
+		mach.Log.Debug().Msg("Generated new fallback key")
+	}
+	return mach.account.getFallbackKeys(mach.Client.UserID, mach.Client.DeviceID, fbAccount), nil
+}
+
+// fallbackKeyNeedsUpdate checks whether the fallback key should be rotated
+// and forgets the previous fallback key if its grace period has passed.
+func (mach *OlmMachine) fallbackKeyNeedsUpdate(ctx context.Context) bool {
+	fbAccount, ok := mach.fallbackKeyAccount()
+	if !ok {
+		return false
+	}
+	mach.otkUploadLock.Lock()
+	defer mach.otkUploadLock.Unlock()
+	if mach.account.forgetExpiredFallbackKey(fbAccount) {
+		mach.machOrContextLog(ctx).Debug().Msg("Forgot previous fallback key after grace period")
+		_ = mach.saveAccount(ctx)
+	}
+	return mach.account.shouldRotateFallbackKey(mach.FallbackKeyRotation)
+}
+
+// HandleUnusedFallbackKeyTypes handles the list of unused fallback key types from a /sync response.
+//
+// If the server says the signed_curve25519 fallback key has been used, it's counted as a use for the rotation policy.
+// A nil slice means the server didn't include the field, in which case nothing is done.
+func (mach *OlmMachine) HandleUnusedFallbackKeyTypes(ctx context.Context, unusedTypes []id.KeyAlgorithm) {
+	fbAccount, ok := mach.fallbackKeyAccount()
+	if !ok || unusedTypes == nil || slices.Contains(unusedTypes, id.KeyAlgorithmSignedCurve25519) {
+		return
+	}
+	mach.otkUploadLock.Lock()
+	// Only count the use if the current fallback key has actually been uploaded
+	changed := !mach.account.FallbackKeyCreatedAt.IsZero() && mach.account.FallbackKeyUses == 0 &&
+		len(fbAccount.FallbackKeyUnpublished()) == 0
+	if changed {
+		mach.account.FallbackKeyUses = 1
+	}
+	mach.otkUploadLock.Unlock()
+	if changed {
+		mach.machOrContextLog(ctx).Debug().Msg("Server reported fallback key as used")
+		_ = mach.saveAccount(ctx)
+	}
+}
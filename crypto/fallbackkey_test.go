@@ -0,0 +1,71 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package crypto
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/crypto/olm"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestFallbackKeyRotation(t *testing.T) {
+	account := NewOlmAccount()
+	fbAccount, ok := account.Internal.(olm.FallbackKeyAccount)
+	if !ok {
+		t.Skip("Account implementation doesn't support fallback keys")
+	}
+	policy := &FallbackKeyRotationPolicy{MaxUses: 2, MaxAge: time.Hour}
+
+	assert.True(t, account.shouldRotateFallbackKey(policy))
+	require.NoError(t, account.rotateFallbackKey(fbAccount, policy.GracePeriod))
+	assert.False(t, account.shouldRotateFallbackKey(policy))
+	assert.True(t, account.PrevFallbackKeyExpiresAt.IsZero())
+
+	fallbackKeys := account.getFallbackKeys("@user:example.com", "DEVICE", fbAccount)
+	require.Len(t, fallbackKeys, 1)
+	for keyID, key := range fallbackKeys {
+		algorithm, _ := keyID.Parse()
+		assert.Equal(t, id.KeyAlgorithmSignedCurve25519, algorithm)
+		assert.True(t, key.Fallback)
+		assert.True(t, key.IsSigned)
+	}
+	account.Internal.MarkKeysAsPublished()
+	assert.Empty(t, account.getFallbackKeys("@user:example.com", "DEVICE", fbAccount))
+
+	account.FallbackKeyUses = 2
+	assert.True(t, account.shouldRotateFallbackKey(policy))
+	account.FallbackKeyUses = 0
+	account.FallbackKeyCreatedAt = time.Now().Add(-2 * time.Hour)
+	assert.True(t, account.shouldRotateFallbackKey(policy))
+
+	require.NoError(t, account.rotateFallbackKey(fbAccount, policy.GracePeriod))
+	assert.False(t, account.PrevFallbackKeyExpiresAt.IsZero())
+	assert.Len(t, account.getFallbackKeys("@user:example.com", "DEVICE", fbAccount), 1)
+	assert.True(t, account.forgetExpiredFallbackKey(fbAccount))
+	assert.False(t, account.forgetExpiredFallbackKey(fbAccount))
+}
+
+func TestStoreFallbackKeyState(t *testing.T) {
+	store := getCryptoStores(t)["sql"].(*SQLCryptoStore)
+	account := NewOlmAccount()
+	account.FallbackKeyCreatedAt = time.UnixMilli(time.Now().UnixMilli())
+	account.FallbackKeyUses = 3
+	require.NoError(t, store.PutAccount(context.TODO(), account))
+
+	store.Account = nil
+	retrieved, err := store.GetAccount(context.TODO())
+	require.NoError(t, err)
+	assert.True(t, account.FallbackKeyCreatedAt.Equal(retrieved.FallbackKeyCreatedAt))
+	assert.Equal(t, 3, retrieved.FallbackKeyUses)
+	assert.True(t, retrieved.PrevFallbackKeyExpiresAt.IsZero())
+}
@@ -35,10 +35,107 @@ type Account struct {
 	PrevFallbackKey    crypto.OneTimeKey   `json:"prev_fallback_key,omitempty"`
 	NextOneTimeKeyID   uint32              `json:"next_one_time_key_id,omitempty"`
 	NumFallbackKeys    uint8               `json:"number_fallback_keys"`
+
+	keyProvider olm.KeyProvider
+	wiped       bool
+}
+
+// Ensure that Account adheres to the optional olm.Account interfaces.
+var (
+	_ olm.KeyProviderAccount = (*Account)(nil)
+	_ olm.FallbackKeyAccount = (*Account)(nil)
+)
+
+// providerCurve25519Key is a Curve25519 identity key whose private part is held by a [olm.KeyProvider].
+type providerCurve25519Key struct {
+	publicKey crypto.Curve25519PublicKey
+	provider  olm.KeyProvider
+}
+
+func (k providerCurve25519Key) GetPublicKey() crypto.Curve25519PublicKey {
+	return k.publicKey
+}
+
+func (k providerCurve25519Key) SharedSecret(pubKey crypto.Curve25519PublicKey) ([]byte, error) {
+	return k.provider.SharedSecret(pubKey)
+}
+
+// SetKeyProvider makes the Account use the given provider for all identity key operations.
+// The private identity keys aren't included in pickles of the Account, so the provider must be
+// set again after unpickling the Account.
+//
+// If the Account already has identity keys, the public keys of the provider must match them.
+// Private identity keys are only wiped if migrate is true, otherwise [olm.ErrPrivateKeysPresent] is returned.
+func (a *Account) SetKeyProvider(provider olm.KeyProvider, migrate bool) error {
+	ed25519Key, curve25519Key, err := provider.IdentityKeys()
+	if err != nil {
+		return fmt.Errorf("failed to get identity keys from provider: %w", err)
+	}
+	ed25519Decoded, err := base64.RawStdEncoding.DecodeString(string(ed25519Key))
+	if err != nil {
+		return fmt.Errorf("failed to decode ed25519 identity key: %w", err)
+	}
+	curve25519Decoded, err := base64.RawStdEncoding.DecodeString(string(curve25519Key))
+	if err != nil {
+		return fmt.Errorf("failed to decode curve25519 identity key: %w", err)
+	}
+	existingEd25519, existingCurve25519, _ := a.IdentityKeys()
+	if len(a.IdKeys.Ed25519.PublicKey) > 0 && existingEd25519 != ed25519Key {
+		return fmt.Errorf("%w (ed25519 key is %s, provider has %s)", olm.ErrKeyProviderMismatch, existingEd25519, ed25519Key)
+	} else if len(a.IdKeys.Curve25519.PublicKey) > 0 && existingCurve25519 != curve25519Key {
+		return fmt.Errorf("%w (curve25519 key is %s, provider has %s)", olm.ErrKeyProviderMismatch, existingCurve25519, curve25519Key)
+	} else if a.hasPrivateIdentityKeys() && !migrate {
+		return olm.ErrPrivateKeysPresent
+	}
+	a.IdKeys.Ed25519.Wipe()
+	a.IdKeys.Curve25519.Wipe()
+	a.IdKeys.Ed25519 = crypto.Ed25519KeyPair{PublicKey: ed25519Decoded}
+	a.IdKeys.Curve25519 = crypto.Curve25519KeyPair{PublicKey: curve25519Decoded}
+	a.keyProvider = provider
+	return nil
 }
 
-// Ensure that Account adheres to the olm.Account interface.
-var _ olm.Account = (*Account)(nil)
+// NeedsKeyProvider returns true if the Account has public identity keys, but the private keys have
+// been removed by migrating to a key provider and no provider has been set yet.
+func (a *Account) NeedsKeyProvider() bool {
+	return a.keyProvider == nil && len(a.IdKeys.Ed25519.PublicKey) > 0 && !a.hasPrivateIdentityKeys()
+}
+
+func (a *Account) hasPrivateIdentityKeys() bool {
+	return !isZero(a.IdKeys.Ed25519.PrivateKey) || !isZero(a.IdKeys.Curve25519.PrivateKey)
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *Account) identityCurve25519() (crypto.Curve25519Key, error) {
+	if a.keyProvider != nil {
+		return providerCurve25519Key{publicKey: a.IdKeys.Curve25519.PublicKey, provider: a.keyProvider}, nil
+	} else if a.wiped {
+		return nil, olm.ErrWiped
+	} else if a.NeedsKeyProvider() {
+		return nil, olm.ErrKeyProviderRequired
+	}
+	return a.IdKeys.Curve25519, nil
+}
+
+// Wipe zeroes all private keys in the account. The account can't be used after this.
+func (a *Account) Wipe() {
+	a.wiped = true
+	a.IdKeys.Ed25519.Wipe()
+	a.IdKeys.Curve25519.Wipe()
+	for i := range a.OTKeys {
+		a.OTKeys[i].Wipe()
+	}
+	a.CurrentFallbackKey.Wipe()
+	a.PrevFallbackKey.Wipe()
+}
 
 // AccountFromJSONPickled loads the Account details from a pickled base64 string. The input is decrypted with the supplied key.
 func AccountFromJSONPickled(pickled, key []byte) (*Account, error) {
@@ -109,13 +206,23 @@ func (a *Account) IdentityKeys() (id.Ed25519, id.Curve25519, error) {
 // Sign returns the base64-encoded signature of a message using the Ed25519 key
 // for this Account.
 func (a *Account) Sign(message []byte) ([]byte, error) {
+	var signature []byte
+	var err error
 	if len(message) == 0 {
 		return nil, fmt.Errorf("sign: %w", olm.ErrEmptyInput)
-	} else if signature, err := a.IdKeys.Ed25519.Sign(message); err != nil {
-		return nil, err
+	} else if a.keyProvider != nil {
+		signature, err = a.keyProvider.Sign(message)
+	} else if a.wiped {
+		return nil, olm.ErrWiped
+	} else if a.NeedsKeyProvider() {
+		return nil, olm.ErrKeyProviderRequired
 	} else {
-		return []byte(base64.RawStdEncoding.EncodeToString(signature)), nil
+		signature, err = a.IdKeys.Ed25519.Sign(message)
 	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.RawStdEncoding.EncodeToString(signature)), nil
 }
 
 // OneTimeKeys returns the public parts of the unpublished one time keys of the Account.
@@ -179,7 +286,11 @@ func (a *Account) NewOutboundSession(theirIdentityKey, theirOneTimeKey id.Curve2
 	if err != nil {
 		return nil, err
 	}
-	return session.NewOutboundOlmSession(a.IdKeys.Curve25519, theirIdentityKeyDecoded, theirOneTimeKeyDecoded)
+	identityKey, err := a.identityCurve25519()
+	if err != nil {
+		return nil, err
+	}
+	return session.NewOutboundOlmSession(identityKey, theirIdentityKeyDecoded, theirOneTimeKeyDecoded)
 }
 
 // NewInboundSession creates a new in-bound session for sending/receiving
@@ -203,7 +314,11 @@ func (a *Account) NewInboundSessionFrom(theirIdentityKey *id.Curve25519, oneTime
 		theirIdentityKeyDecoded = &theirIdentityKeyCurve
 	}
 
-	return session.NewInboundOlmSession(theirIdentityKeyDecoded, []byte(oneTimeKeyMsg), a.searchOTKForOur, a.IdKeys.Curve25519)
+	identityKey, err := a.identityCurve25519()
+	if err != nil {
+		return nil, err
+	}
+	return session.NewInboundOlmSession(theirIdentityKeyDecoded, []byte(oneTimeKeyMsg), a.searchOTKForOur, identityKey)
 }
 
 func (a *Account) searchOTKForOur(toFind crypto.Curve25519PublicKey) *crypto.OneTimeKey {
@@ -227,6 +342,7 @@ func (a *Account) RemoveOneTimeKeys(s olm.Session) error {
 	for curIndex := range a.OTKeys {
 		if a.OTKeys[curIndex].Key.PublicKey.Equal(toFind) {
 			//Remove and return
+			a.OTKeys[curIndex].Wipe()
 			a.OTKeys[curIndex] = a.OTKeys[len(a.OTKeys)-1]
 			a.OTKeys = a.OTKeys[:len(a.OTKeys)-1]
 			return nil
@@ -239,6 +355,7 @@ func (a *Account) RemoveOneTimeKeys(s olm.Session) error {
 // GenFallbackKey generates a new fallback key. The old fallback key is stored
 // in a.PrevFallbackKey overwriting any previous PrevFallbackKey.
 func (a *Account) GenFallbackKey() error {
+	a.PrevFallbackKey.Wipe()
 	a.PrevFallbackKey = a.CurrentFallbackKey
 	key := crypto.OneTimeKey{
 		Published: false,
@@ -311,10 +428,16 @@ func (a *Account) FallbackKeyUnpublishedJSON() ([]byte, error) {
 	return json.Marshal(res)
 }
 
+// IsCurrentFallbackKeySession returns true if the given inbound session was created using the current fallback key.
+func (a *Account) IsCurrentFallbackKeySession(s olm.Session) bool {
+	return a.NumFallbackKeys >= 1 && a.CurrentFallbackKey.Key.PublicKey.Equal(s.(*session.OlmSession).BobOneTimeKey)
+}
+
 // ForgetOldFallbackKey resets the previous fallback key in the account.
 func (a *Account) ForgetOldFallbackKey() {
 	if a.NumFallbackKeys >= 2 {
 		a.NumFallbackKeys = 1
+		a.PrevFallbackKey.Wipe()
 		a.PrevFallbackKey = crypto.OneTimeKey{}
 	}
 }
@@ -407,7 +530,9 @@ func (a *Account) UnpickleLibOlm(buf []byte) error {
 
 // Pickle returns a base64 encoded and with key encrypted pickled account using PickleLibOlm().
 func (a *Account) Pickle(key []byte) ([]byte, error) {
-	if len(key) == 0 {
+	if a.wiped {
+		return nil, olm.ErrWiped
+	} else if len(key) == 0 {
 		return nil, olm.ErrNoKeyProvided
 	}
 	return cipher.Pickle(key, a.PickleLibOlm())
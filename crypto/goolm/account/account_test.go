@@ -1,14 +1,18 @@
 package account_test
 
 import (
+	"bytes"
 	"encoding/base64"
+	"slices"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"maunium.net/go/mautrix/id"
 
 	"maunium.net/go/mautrix/crypto/goolm/account"
+	"maunium.net/go/mautrix/crypto/goolm/crypto"
 	"maunium.net/go/mautrix/crypto/olm"
 	"maunium.net/go/mautrix/crypto/signatures"
 )
@@ -365,3 +369,106 @@ func TestAccountSign(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, verified)
 }
+
+type testKeyProvider struct {
+	ed25519    crypto.Ed25519KeyPair
+	curve25519 crypto.Curve25519KeyPair
+}
+
+func (kp *testKeyProvider) IdentityKeys() (id.Ed25519, id.Curve25519, error) {
+	return kp.ed25519.B64Encoded(), kp.curve25519.B64Encoded(), nil
+}
+
+func (kp *testKeyProvider) Sign(message []byte) ([]byte, error) {
+	return kp.ed25519.Sign(message)
+}
+
+func (kp *testKeyProvider) SharedSecret(theirKey []byte) ([]byte, error) {
+	return kp.curve25519.SharedSecret(theirKey)
+}
+
+func TestAccountKeyProvider(t *testing.T) {
+	accountA, err := account.NewAccount()
+	require.NoError(t, err)
+	provider := &testKeyProvider{ed25519: accountA.IdKeys.Ed25519, curve25519: accountA.IdKeys.Curve25519}
+	accountA.IdKeys.Ed25519 = crypto.Ed25519KeyPair{PublicKey: provider.ed25519.PublicKey}
+	accountA.IdKeys.Curve25519 = crypto.Curve25519KeyPair{PublicKey: provider.curve25519.PublicKey}
+	require.NoError(t, accountA.SetKeyProvider(provider, false))
+	require.NoError(t, accountA.GenOneTimeKeys(1))
+
+	accountB, err := account.NewAccount()
+	require.NoError(t, err)
+	require.NoError(t, accountB.GenOneTimeKeys(1))
+	assert.ErrorIs(t, accountB.SetKeyProvider(provider, true), olm.ErrKeyProviderMismatch)
+
+	plainText := []byte("Hello, World")
+	signatureB64, err := accountA.Sign(plainText)
+	require.NoError(t, err)
+	signature, err := base64.RawStdEncoding.DecodeString(string(signatureB64))
+	require.NoError(t, err)
+	verified, err := signatures.VerifySignature(plainText, provider.ed25519.B64Encoded(), signature)
+	assert.NoError(t, err)
+	assert.True(t, verified)
+
+	// Outbound session using the provider's identity key
+	aliceSession, err := accountA.NewOutboundSession(accountB.IdKeys.Curve25519.B64Encoded(), accountB.OTKeys[0].Key.B64Encoded())
+	require.NoError(t, err)
+	msgType, message, err := aliceSession.Encrypt(plainText)
+	require.NoError(t, err)
+	bobSession, err := accountB.NewInboundSession(string(message))
+	require.NoError(t, err)
+	decrypted, err := bobSession.Decrypt(string(message), msgType)
+	require.NoError(t, err)
+	assert.Equal(t, plainText, decrypted)
+
+	// Inbound session using the provider's identity key
+	bobSession, err = accountB.NewOutboundSession(accountA.IdKeys.Curve25519.B64Encoded(), accountA.OTKeys[0].Key.B64Encoded())
+	require.NoError(t, err)
+	msgType, message, err = bobSession.Encrypt(plainText)
+	require.NoError(t, err)
+	aliceSession, err = accountA.NewInboundSession(string(message))
+	require.NoError(t, err)
+	decrypted, err = aliceSession.Decrypt(string(message), msgType)
+	require.NoError(t, err)
+	assert.Equal(t, plainText, decrypted)
+
+	// Private identity keys must not be included in pickles
+	pickled, err := accountA.Pickle([]byte("key"))
+	require.NoError(t, err)
+	unpickled, err := account.AccountFromPickled(pickled, []byte("key"))
+	require.NoError(t, err)
+	assert.Empty(t, bytes.Trim(unpickled.IdKeys.Ed25519.PrivateKey, "\x00"))
+	assert.Empty(t, bytes.Trim(unpickled.IdKeys.Curve25519.PrivateKey, "\x00"))
+	assert.NoError(t, unpickled.SetKeyProvider(provider, false))
+}
+
+func TestAccountKeyProviderMigration(t *testing.T) {
+	acc, err := account.NewAccount()
+	require.NoError(t, err)
+	require.NoError(t, acc.GenOneTimeKeys(1))
+	provider := &testKeyProvider{ed25519: acc.IdKeys.Ed25519, curve25519: acc.IdKeys.Curve25519}
+	provider.ed25519.PrivateKey = slices.Clone(provider.ed25519.PrivateKey)
+	provider.curve25519.PrivateKey = slices.Clone(provider.curve25519.PrivateKey)
+
+	// Private keys are only wiped when explicitly migrating
+	assert.ErrorIs(t, acc.SetKeyProvider(provider, false), olm.ErrPrivateKeysPresent)
+	assert.NotEmpty(t, bytes.Trim(acc.IdKeys.Ed25519.PrivateKey, "\x00"))
+	require.NoError(t, acc.SetKeyProvider(provider, true))
+	assert.False(t, acc.NeedsKeyProvider())
+
+	// Loading a migrated account without a provider must not use the zeroed keys
+	pickled, err := acc.Pickle([]byte("key"))
+	require.NoError(t, err)
+	unpickled, err := account.AccountFromPickled(pickled, []byte("key"))
+	require.NoError(t, err)
+	assert.True(t, unpickled.NeedsKeyProvider())
+	_, err = unpickled.Sign([]byte("Hello, World"))
+	assert.ErrorIs(t, err, olm.ErrKeyProviderRequired)
+	_, err = unpickled.NewOutboundSession(acc.IdKeys.Curve25519.B64Encoded(), acc.OTKeys[0].Key.B64Encoded())
+	assert.ErrorIs(t, err, olm.ErrKeyProviderRequired)
+
+	require.NoError(t, unpickled.SetKeyProvider(provider, false))
+	assert.False(t, unpickled.NeedsKeyProvider())
+	_, err = unpickled.Sign([]byte("Hello, World"))
+	assert.NoError(t, err)
+}
@@ -39,6 +39,8 @@ func Unpickle(key, input []byte) ([]byte, error) {
 	ciphertext, err := goolmbase64.Decode(input)
 	if err != nil {
 		return nil, err
+	} else if len(ciphertext) < PickleBlockSize()+pickleMACLength {
+		return nil, fmt.Errorf("decrypt pickle: %w", olm.ErrInputToSmall)
 	}
 	//remove mac and check
 	verified, err := pickleCipher.Verify(key, ciphertext[:len(ciphertext)-pickleMACLength], ciphertext[len(ciphertext)-pickleMACLength:])
@@ -5,8 +5,11 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"maunium.net/go/mautrix/crypto/goolm/cipher"
+	"maunium.net/go/mautrix/crypto/goolm/goolmbase64"
+	"maunium.net/go/mautrix/crypto/olm"
 )
 
 func TestEncoding(t *testing.T) {
@@ -26,3 +29,18 @@ func TestEncoding(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, toEncrypt, decoded)
 }
+
+func TestUnpickleTruncated(t *testing.T) {
+	key := []byte("test key")
+	encoded, err := cipher.Pickle(key, make([]byte, aes.BlockSize*2))
+	require.NoError(t, err)
+	decoded, err := goolmbase64.Decode(encoded)
+	require.NoError(t, err)
+
+	for _, length := range []int{0, 4, 8, aes.BlockSize, aes.BlockSize + 7} {
+		_, err = cipher.Unpickle(key, goolmbase64.Encode(decoded[:length]))
+		assert.ErrorIs(t, err, olm.ErrInputToSmall, "length %d", length)
+	}
+	_, err = cipher.Unpickle([]byte("wrong key"), encoded)
+	assert.ErrorIs(t, err, olm.ErrBadMAC)
+}
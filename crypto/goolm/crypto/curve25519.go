@@ -22,6 +22,26 @@ type Curve25519KeyPair struct {
 	PublicKey  Curve25519PublicKey  `json:"public,omitempty"`
 }
 
+// Curve25519Key is a Curve25519 key that can be used to compute shared secrets. It's implemented
+// by [Curve25519KeyPair], but the private key may also be held outside of process memory.
+type Curve25519Key interface {
+	GetPublicKey() Curve25519PublicKey
+	SharedSecret(pubKey Curve25519PublicKey) ([]byte, error)
+}
+
+var _ Curve25519Key = Curve25519KeyPair{}
+
+// GetPublicKey returns the public key of the pair.
+func (c Curve25519KeyPair) GetPublicKey() Curve25519PublicKey {
+	return c.PublicKey
+}
+
+// Wipe zeroes the private key of the pair. The key pair can't be used for anything except
+// public key operations after this.
+func (c *Curve25519KeyPair) Wipe() {
+	c.PrivateKey.Wipe()
+}
+
 // Curve25519GenerateKey creates a new curve25519 key pair.
 func Curve25519GenerateKey() (Curve25519KeyPair, error) {
 	privateKeyByte := make([]byte, Curve25519PrivateKeyLength)
@@ -86,6 +106,11 @@ func (c Curve25519PrivateKey) Equal(x Curve25519PrivateKey) bool {
 	return subtle.ConstantTimeCompare(c, x) == 1
 }
 
+// Wipe zeroes the private key bytes in place.
+func (c Curve25519PrivateKey) Wipe() {
+	clear(c)
+}
+
 // PubKey returns the public key derived from the private key.
 func (c Curve25519PrivateKey) PubKey() (Curve25519PublicKey, error) {
 	return curve25519.X25519(c, curve25519.Basepoint)
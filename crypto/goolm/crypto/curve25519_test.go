@@ -121,3 +121,12 @@ func TestCurve25519PicklePrivKeyOnly(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, keyPair, unpickledKeyPair)
 }
+
+func TestCurve25519Wipe(t *testing.T) {
+	keyPair, err := crypto.Curve25519GenerateKey()
+	assert.NoError(t, err)
+	publicKey := append(crypto.Curve25519PublicKey{}, keyPair.PublicKey...)
+	keyPair.Wipe()
+	assert.Equal(t, make(crypto.Curve25519PrivateKey, crypto.Curve25519PrivateKeyLength), keyPair.PrivateKey)
+	assert.Equal(t, publicKey, keyPair.PublicKey)
+}
@@ -44,6 +44,11 @@ type Ed25519KeyPair struct {
 	PublicKey  Ed25519PublicKey  `json:"public,omitempty"`
 }
 
+// Wipe zeroes the private key of the pair. The key pair can't be used for signing after this.
+func (c *Ed25519KeyPair) Wipe() {
+	c.PrivateKey.Wipe()
+}
+
 // B64Encoded returns a base64 encoded string of the public key.
 func (c Ed25519KeyPair) B64Encoded() id.Ed25519 {
 	return id.Ed25519(base64.RawStdEncoding.EncodeToString(c.PublicKey))
@@ -89,6 +94,11 @@ func (c Ed25519PrivateKey) Equal(x Ed25519PrivateKey) bool {
 	return ed25519.PrivateKey(c).Equal(ed25519.PrivateKey(x))
 }
 
+// Wipe zeroes the private key bytes in place.
+func (c Ed25519PrivateKey) Wipe() {
+	clear(c)
+}
+
 // PubKey returns the public key derived from the private key.
 func (c Ed25519PrivateKey) PubKey() Ed25519PublicKey {
 	publicKey := ed25519.PrivateKey(c).Public()
@@ -87,3 +87,10 @@ func TestEd25519PicklePrivKeyOnly(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, keyPair, unpickledKeyPair)
 }
+
+func TestEd25519Wipe(t *testing.T) {
+	keyPair, err := crypto.Ed25519GenerateKey()
+	assert.NoError(t, err)
+	keyPair.Wipe()
+	assert.Equal(t, make(crypto.Ed25519PrivateKey, len(keyPair.PrivateKey)), keyPair.PrivateKey)
+}
@@ -14,6 +14,11 @@ type OneTimeKey struct {
 	Key       Curve25519KeyPair `json:"key,omitempty"`
 }
 
+// Wipe zeroes the private part of the one time key.
+func (otk *OneTimeKey) Wipe() {
+	otk.Key.Wipe()
+}
+
 // Equal compares the one time key to the given one.
 func (otk OneTimeKey) Equal(other OneTimeKey) bool {
 	return otk.ID == other.ID &&
@@ -44,6 +44,12 @@ type Ratchet struct {
 	Counter uint32                                 `json:"counter"`
 }
 
+// Wipe zeroes the ratchet data.
+func (m *Ratchet) Wipe() {
+	clear(m.Data[:])
+	m.Counter = 0
+}
+
 // New creates a new ratchet with counter set to counter and the ratchet data set to data.
 func New(counter uint32, data [RatchetParts * RatchetPartLength]byte) (*Ratchet, error) {
 	m := &Ratchet{
@@ -23,10 +23,15 @@ type chainKey struct {
 func (c *chainKey) advance() {
 	hash := hmac.New(sha256.New, c.Key)
 	hash.Write([]byte{chainKeySeed})
+	clear(c.Key)
 	c.Key = hash.Sum(nil)
 	c.Index++
 }
 
+func (c *chainKey) wipe() {
+	clear(c.Key)
+}
+
 // UnpickleLibOlm unpickles the unencryted value and populates the chain key accordingly.
 func (r *chainKey) UnpickleLibOlm(decoder *libolmpickle.Decoder) error {
 	err := r.Key.UnpickleLibOlm(decoder)
@@ -62,6 +67,11 @@ func newSenderChain(key crypto.Curve25519PublicKey, ratchet crypto.Curve25519Key
 	}
 }
 
+func (s *senderChain) wipe() {
+	s.RKey.Wipe()
+	s.CKey.wipe()
+}
+
 // advance advances the chain
 func (s *senderChain) advance() {
 	s.CKey.advance()
@@ -114,6 +124,10 @@ func newReceiverChain(chain crypto.Curve25519PublicKey, ratchet crypto.Curve2551
 	}
 }
 
+func (s *receiverChain) wipe() {
+	s.CKey.wipe()
+}
+
 // advance advances the chain
 func (s *receiverChain) advance() {
 	s.CKey.advance()
@@ -149,6 +163,10 @@ type messageKey struct {
 	Key   []byte `json:"key"`
 }
 
+func (m *messageKey) wipe() {
+	clear(m.Key)
+}
+
 // UnpickleLibOlm unpickles the unencryted value and populates the message key
 // accordingly.
 func (m *messageKey) UnpickleLibOlm(decoder *libolmpickle.Decoder) (err error) {
@@ -72,6 +72,18 @@ func New() *Ratchet {
 	return r
 }
 
+// Wipe zeroes all key material in the ratchet. The ratchet can't be used after this.
+func (r *Ratchet) Wipe() {
+	clear(r.RootKey)
+	r.SenderChains.wipe()
+	for i := range r.ReceiverChains {
+		r.ReceiverChains[i].wipe()
+	}
+	for i := range r.SkippedMessageKeys {
+		r.SkippedMessageKeys[i].MKey.wipe()
+	}
+}
+
 // InitializeAsBob initializes this ratchet from a receiving point of view (only first message).
 func (r *Ratchet) InitializeAsBob(sharedSecret []byte, theirRatchetKey crypto.Curve25519PublicKey) error {
 	derivedSecretsReader := hkdf.New(sha256.New, sharedSecret, nil, KdfInfo.Root)
@@ -178,6 +190,7 @@ func (r *Ratchet) Decrypt(input []byte) ([]byte, error) {
 			} else if len(result) != 0 {
 				// Remove the key from the skipped keys now that we've
 				// decoded the message it corresponds to.
+				r.SkippedMessageKeys[curSkippedIndex].MKey.wipe()
 				r.SkippedMessageKeys[curSkippedIndex] = r.SkippedMessageKeys[len(r.SkippedMessageKeys)-1]
 				r.SkippedMessageKeys = r.SkippedMessageKeys[:len(r.SkippedMessageKeys)-1]
 				return result, nil
@@ -269,6 +282,7 @@ func (r *Ratchet) decryptForNewChain(message *message.Message, rawMessage []byte
 		We can discard our previous ephemeral ratchet key.
 		We will generate a new key when we send the next message.
 	*/
+	r.SenderChains.wipe()
 	r.SenderChains = senderChain{}
 
 	return r.decryptForExistingChain(&r.ReceiverChains[0], message, rawMessage)
@@ -3,6 +3,7 @@ package session
 import (
 	"encoding/base64"
 	"fmt"
+	"sync"
 
 	"maunium.net/go/mautrix/crypto/goolm/cipher"
 	"maunium.net/go/mautrix/crypto/goolm/crypto"
@@ -20,18 +21,98 @@ const (
 	megolmInboundSessionPickleVersionLibOlm uint32 = 2
 )
 
+const (
+	// maxCachedRatchets is the maximum number of derived ratchet states kept in memory per inbound session.
+	maxCachedRatchets = 128
+	// persistRatchetInterval is the number of messages the current ratchet can be advanced by
+	// before the session should be pickled and stored again (see [MegolmInboundSession.NeedsPersisting]).
+	persistRatchetInterval = 100
+)
+
 // MegolmInboundSession stores information about the sessions of receive.
 type MegolmInboundSession struct {
 	Ratchet            megolm.Ratchet          `json:"ratchet"`
 	SigningKey         crypto.Ed25519PublicKey `json:"signing_key"`
 	InitialRatchet     megolm.Ratchet          `json:"initial_ratchet"`
 	SigningKeyVerified bool                    `json:"signing_key_verified"` //not used for now
+
+	// ratchetCache contains ratchet states for recently decrypted message indices, so that
+	// out-of-order and repeated messages don't need to be re-ratcheted from InitialRatchet.
+	// The cache is bounded by maxCachedRatchets and is not included in pickles.
+	ratchetCache      map[uint32]*megolm.Ratchet
+	ratchetCacheOrder []uint32
+	// advancedSincePickle is the number of messages Ratchet has been advanced by since the last pickle.
+	advancedSincePickle uint32
+
+	wiped bool
+	// lock protects the ratchets and the cache, as decrypting and exporting modify them.
+	lock sync.Mutex
 }
 
 // Ensure that MegolmInboundSession implements the [olm.InboundGroupSession]
 // interface.
 var _ olm.InboundGroupSession = (*MegolmInboundSession)(nil)
 
+// Wipe zeroes the ratchets of the session. The session can't be used after this.
+func (o *MegolmInboundSession) Wipe() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.wiped = true
+	o.Ratchet.Wipe()
+	o.InitialRatchet.Wipe()
+	o.clearRatchetCache()
+}
+
+// clearRatchetCache wipes and removes all cached ratchet states.
+func (o *MegolmInboundSession) clearRatchetCache() {
+	for index, ratchet := range o.ratchetCache {
+		ratchet.Wipe()
+		delete(o.ratchetCache, index)
+	}
+	o.ratchetCacheOrder = o.ratchetCacheOrder[:0]
+}
+
+// NeedsPersisting returns true if the current ratchet has been advanced significantly since the
+// session was last pickled, which means the session should be stored again to avoid re-ratcheting
+// from the old state after it's loaded next time.
+func (o *MegolmInboundSession) NeedsPersisting() bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.advancedSincePickle >= persistRatchetInterval
+}
+
+// cacheRatchet stores the given ratchet in the cache, evicting the oldest entry if the cache is full.
+// The cache takes ownership of the ratchet, so it must not be modified afterwards.
+func (o *MegolmInboundSession) cacheRatchet(ratchet *megolm.Ratchet) {
+	if o.ratchetCache == nil {
+		o.ratchetCache = make(map[uint32]*megolm.Ratchet)
+	}
+	if _, ok := o.ratchetCache[ratchet.Counter]; ok {
+		return
+	}
+	if len(o.ratchetCacheOrder) >= maxCachedRatchets {
+		o.ratchetCache[o.ratchetCacheOrder[0]].Wipe()
+		delete(o.ratchetCache, o.ratchetCacheOrder[0])
+		o.ratchetCacheOrder = o.ratchetCacheOrder[1:]
+	}
+	o.ratchetCache[ratchet.Counter] = ratchet
+	o.ratchetCacheOrder = append(o.ratchetCacheOrder, ratchet.Counter)
+}
+
+// closestRatchet returns the cached ratchet closest to but not after messageIndex,
+// or the initial ratchet if there's no such cached ratchet.
+func (o *MegolmInboundSession) closestRatchet(messageIndex uint32) *megolm.Ratchet {
+	closest := &o.InitialRatchet
+	distance := messageIndex - o.InitialRatchet.Counter
+	for _, cached := range o.ratchetCache {
+		if cachedDistance := messageIndex - cached.Counter; cachedDistance < distance {
+			closest = cached
+			distance = cachedDistance
+		}
+	}
+	return closest
+}
+
 // NewMegolmInboundSession creates a new MegolmInboundSession from a base64 encoded session sharing message.
 func NewMegolmInboundSession(input []byte) (*MegolmInboundSession, error) {
 	var err error
@@ -93,26 +174,41 @@ func MegolmInboundSessionFromPickled(pickled, key []byte) (*MegolmInboundSession
 }
 
 // getRatchet tries to find the correct ratchet for a messageIndex.
+// The returned ratchet is owned by the session, so it must not be modified and may only be used while holding the lock.
 func (o *MegolmInboundSession) getRatchet(messageIndex uint32) (*megolm.Ratchet, error) {
 	// pick a megolm instance to use. if we are at or beyond the latest ratchet value, use that
 	if (messageIndex - o.Ratchet.Counter) < uint32(1<<31) {
-		o.Ratchet.AdvanceTo(messageIndex)
+		if messageIndex != o.Ratchet.Counter {
+			o.advancedSincePickle += messageIndex - o.Ratchet.Counter
+			o.Ratchet.AdvanceTo(messageIndex)
+			cached := o.Ratchet
+			o.cacheRatchet(&cached)
+		}
 		return &o.Ratchet, nil
 	}
 	if (messageIndex - o.InitialRatchet.Counter) >= uint32(1<<31) {
 		// the counter is before our initial ratchet - we can't decode this
 		return nil, fmt.Errorf("decrypt: %w", olm.ErrRatchetNotAvailable)
 	}
-	// otherwise, start from the initial ratchet. Take a copy so that we don't overwrite the initial ratchet
-	copiedRatchet := o.InitialRatchet
-	copiedRatchet.AdvanceTo(messageIndex)
-	return &copiedRatchet, nil
-
+	// otherwise, start from a copy of the closest cached ratchet (or the initial ratchet),
+	// so advancing it doesn't modify the cached states.
+	closest := o.closestRatchet(messageIndex)
+	if closest.Counter == messageIndex {
+		return closest, nil
+	}
+	advanced := *closest
+	advanced.AdvanceTo(messageIndex)
+	o.cacheRatchet(&advanced)
+	return &advanced, nil
 }
 
 // Decrypt decrypts a base64 encoded group message.
 func (o *MegolmInboundSession) Decrypt(ciphertext []byte) ([]byte, uint, error) {
-	if len(ciphertext) == 0 {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.wiped {
+		return nil, 0, olm.ErrWiped
+	} else if len(ciphertext) == 0 {
 		return nil, 0, olm.ErrEmptyInput
 	}
 	if o.SigningKey == nil {
@@ -161,11 +257,18 @@ func (o *MegolmInboundSession) ID() id.SessionID {
 
 // PickleAsJSON returns an MegolmInboundSession as a base64 string encrypted using the supplied key. The unencrypted representation of the Account is in JSON format.
 func (o *MegolmInboundSession) PickleAsJSON(key []byte) ([]byte, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.advancedSincePickle = 0
 	return utilities.PickleAsJSON(o, megolmInboundSessionPickleVersionJSON, key)
 }
 
 // UnpickleAsJSON updates an MegolmInboundSession by a base64 encrypted string using the supplied key. The unencrypted representation has to be in JSON format.
 func (o *MegolmInboundSession) UnpickleAsJSON(pickled, key []byte) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.clearRatchetCache()
+	o.advancedSincePickle = 0
 	return utilities.UnpickleAsJSON(o, pickled, key, megolmInboundSessionPickleVersionJSON)
 }
 
@@ -177,6 +280,11 @@ func (o *MegolmInboundSession) UnpickleAsJSON(pickled, key []byte) error {
 // sent before the session key was shared with us) the error will be
 // returned.
 func (o *MegolmInboundSession) Export(messageIndex uint32) ([]byte, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.wiped {
+		return nil, olm.ErrWiped
+	}
 	ratchet, err := o.getRatchet(messageIndex)
 	if err != nil {
 		return nil, err
@@ -202,6 +310,10 @@ func (o *MegolmInboundSession) Unpickle(pickled, key []byte) error {
 // UnpickleLibOlm unpickles the unencryted value and populates the [Session]
 // accordingly.
 func (o *MegolmInboundSession) UnpickleLibOlm(value []byte) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.clearRatchetCache()
+	o.advancedSincePickle = 0
 	decoder := libolmpickle.NewDecoder(value)
 	pickledVersion, err := decoder.ReadUInt32()
 	if err != nil {
@@ -231,14 +343,25 @@ func (o *MegolmInboundSession) UnpickleLibOlm(value []byte) error {
 
 // Pickle returns a base64 encoded and with key encrypted pickled MegolmInboundSession using PickleLibOlm().
 func (o *MegolmInboundSession) Pickle(key []byte) ([]byte, error) {
-	if len(key) == 0 {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.wiped {
+		return nil, olm.ErrWiped
+	} else if len(key) == 0 {
 		return nil, olm.ErrNoKeyProvided
 	}
-	return cipher.Pickle(key, o.PickleLibOlm())
+	return cipher.Pickle(key, o.pickleLibOlm())
 }
 
 // PickleLibOlm pickles the session returning the raw bytes.
 func (o *MegolmInboundSession) PickleLibOlm() []byte {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.pickleLibOlm()
+}
+
+func (o *MegolmInboundSession) pickleLibOlm() []byte {
+	o.advancedSincePickle = 0
 	encoder := libolmpickle.NewEncoder()
 	encoder.WriteUInt32(megolmInboundSessionPickleVersionLibOlm)
 	o.InitialRatchet.PickleLibOlm(encoder)
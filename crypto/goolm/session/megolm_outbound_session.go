@@ -26,10 +26,19 @@ const (
 type MegolmOutboundSession struct {
 	Ratchet    megolm.Ratchet        `json:"ratchet"`
 	SigningKey crypto.Ed25519KeyPair `json:"signing_key"`
+
+	wiped bool
 }
 
 var _ olm.OutboundGroupSession = (*MegolmOutboundSession)(nil)
 
+// Wipe zeroes the ratchet and the signing key of the session. The session can't be used after this.
+func (o *MegolmOutboundSession) Wipe() {
+	o.wiped = true
+	o.Ratchet.Wipe()
+	o.SigningKey.Wipe()
+}
+
 // NewMegolmOutboundSession creates a new MegolmOutboundSession.
 func NewMegolmOutboundSession() (*MegolmOutboundSession, error) {
 	o := &MegolmOutboundSession{}
@@ -63,7 +72,9 @@ func MegolmOutboundSessionFromPickled(pickled, key []byte) (*MegolmOutboundSessi
 
 // Encrypt encrypts the plaintext as a base64 encoded group message.
 func (o *MegolmOutboundSession) Encrypt(plaintext []byte) ([]byte, error) {
-	if len(plaintext) == 0 {
+	if o.wiped {
+		return nil, olm.ErrWiped
+	} else if len(plaintext) == 0 {
 		return nil, olm.ErrEmptyInput
 	}
 	encrypted, err := o.Ratchet.Encrypt(plaintext, &o.SigningKey)
@@ -114,7 +125,9 @@ func (o *MegolmOutboundSession) UnpickleLibOlm(buf []byte) error {
 
 // Pickle returns a base64 encoded and with key encrypted pickled MegolmOutboundSession using PickleLibOlm().
 func (o *MegolmOutboundSession) Pickle(key []byte) ([]byte, error) {
-	if len(key) == 0 {
+	if o.wiped {
+		return nil, olm.ErrWiped
+	} else if len(key) == 0 {
 		return nil, olm.ErrNoKeyProvided
 	}
 	return cipher.Pickle(key, o.PickleLibOlm())
@@ -130,6 +143,9 @@ func (o *MegolmOutboundSession) PickleLibOlm() []byte {
 }
 
 func (o *MegolmOutboundSession) SessionSharingMessage() ([]byte, error) {
+	if o.wiped {
+		return nil, olm.ErrWiped
+	}
 	return o.Ratchet.SessionSharingMessage(o.SigningKey)
 }
 
@@ -3,6 +3,8 @@ package session_test
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -88,6 +90,92 @@ func TestGroupSendReceive(t *testing.T) {
 	assert.Equal(t, plainText, decoded)
 }
 
+func TestGroupSessionWipe(t *testing.T) {
+	outboundSession, err := session.NewMegolmOutboundSession()
+	assert.NoError(t, err)
+	sessionSharing, err := outboundSession.SessionSharingMessage()
+	assert.NoError(t, err)
+	ciphertext, err := outboundSession.Encrypt([]byte("Message"))
+	assert.NoError(t, err)
+	inboundSession, err := session.NewMegolmInboundSession(sessionSharing)
+	assert.NoError(t, err)
+
+	outboundSession.Wipe()
+	assert.Equal(t, [megolm.RatchetParts * megolm.RatchetPartLength]byte{}, outboundSession.Ratchet.Data)
+	_, err = outboundSession.Encrypt([]byte("Message"))
+	assert.ErrorIs(t, err, olm.ErrWiped)
+	_, err = outboundSession.Pickle([]byte("secretKey"))
+	assert.ErrorIs(t, err, olm.ErrWiped)
+
+	inboundSession.Wipe()
+	_, _, err = inboundSession.Decrypt(ciphertext)
+	assert.ErrorIs(t, err, olm.ErrWiped)
+	_, err = inboundSession.Export(0)
+	assert.ErrorIs(t, err, olm.ErrWiped)
+	_, err = inboundSession.Pickle([]byte("secretKey"))
+	assert.ErrorIs(t, err, olm.ErrWiped)
+}
+
+func TestGroupReceiveOutOfOrder(t *testing.T) {
+	outboundSession, err := session.NewMegolmOutboundSession()
+	assert.NoError(t, err)
+	sessionSharing, err := outboundSession.SessionSharingMessage()
+	assert.NoError(t, err)
+	inboundSession, err := session.NewMegolmInboundSession(sessionSharing)
+	assert.NoError(t, err)
+
+	ciphertexts := make([][]byte, 300)
+	for i := range ciphertexts {
+		ciphertexts[i], err = outboundSession.Encrypt([]byte(fmt.Sprintf("Message %d", i)))
+		assert.NoError(t, err)
+	}
+
+	for _, index := range []int{299, 5, 200, 4, 5, 250, 0, 299, 1, 298} {
+		decrypted, messageIndex, err := inboundSession.Decrypt(ciphertexts[index])
+		assert.NoError(t, err)
+		assert.EqualValues(t, index, messageIndex)
+		assert.Equal(t, []byte(fmt.Sprintf("Message %d", index)), decrypted)
+	}
+	assert.EqualValues(t, 299, inboundSession.Ratchet.Counter)
+	assert.EqualValues(t, 0, inboundSession.InitialRatchet.Counter)
+	assert.True(t, inboundSession.NeedsPersisting())
+	_, err = inboundSession.Pickle([]byte("secretKey"))
+	assert.NoError(t, err)
+	assert.False(t, inboundSession.NeedsPersisting())
+}
+
+func TestGroupReceiveConcurrent(t *testing.T) {
+	outboundSession, err := session.NewMegolmOutboundSession()
+	assert.NoError(t, err)
+	sessionSharing, err := outboundSession.SessionSharingMessage()
+	assert.NoError(t, err)
+	inboundSession, err := session.NewMegolmInboundSession(sessionSharing)
+	assert.NoError(t, err)
+
+	ciphertexts := make([][]byte, 50)
+	for i := range ciphertexts {
+		ciphertexts[i], err = outboundSession.Encrypt([]byte(fmt.Sprintf("Message %d", i)))
+		assert.NoError(t, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range ciphertexts {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			decrypted, _, err := inboundSession.Decrypt(ciphertexts[len(ciphertexts)-1-i])
+			assert.NoError(t, err)
+			assert.Equal(t, []byte(fmt.Sprintf("Message %d", len(ciphertexts)-1-i)), decrypted)
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := inboundSession.Export(uint32(i))
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+}
+
 func TestGroupSessionExportImport(t *testing.T) {
 	plaintext := []byte("Message")
 	sessionKey := []byte(
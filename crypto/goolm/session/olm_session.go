@@ -13,6 +13,7 @@ import (
 	"maunium.net/go/mautrix/crypto/goolm/libolmpickle"
 	"maunium.net/go/mautrix/crypto/goolm/message"
 	"maunium.net/go/mautrix/crypto/goolm/ratchet"
+	"maunium.net/go/mautrix/crypto/goolm/suite"
 	"maunium.net/go/mautrix/crypto/goolm/utilities"
 	"maunium.net/go/mautrix/crypto/olm"
 	"maunium.net/go/mautrix/id"
@@ -34,10 +35,18 @@ type OlmSession struct {
 	AliceBaseKey     crypto.Curve25519PublicKey `json:"alice_base_key"`
 	BobOneTimeKey    crypto.Curve25519PublicKey `json:"bob_one_time_key"`
 	Ratchet          ratchet.Ratchet            `json:"ratchet"`
+
+	wiped bool
 }
 
 var _ olm.Session = (*OlmSession)(nil)
 
+// Wipe zeroes all key material in the session. The session can't be used after this.
+func (s *OlmSession) Wipe() {
+	s.wiped = true
+	s.Ratchet.Wipe()
+}
+
 // SearchOTKFunc is used to retrieve a crypto.OneTimeKey from a public key.
 type SearchOTKFunc = func(crypto.Curve25519PublicKey) *crypto.OneTimeKey
 
@@ -69,7 +78,28 @@ func NewOlmSession() *OlmSession {
 
 // NewOutboundOlmSession creates a new outbound session for sending the first message to a
 // given curve25519 identityKey and oneTimeKey.
-func NewOutboundOlmSession(identityKeyAlice crypto.Curve25519KeyPair, identityKeyBob crypto.Curve25519PublicKey, oneTimeKeyBob crypto.Curve25519PublicKey) (*OlmSession, error) {
+func NewOutboundOlmSession(identityKeyAlice crypto.Curve25519Key, identityKeyBob crypto.Curve25519PublicKey, oneTimeKeyBob crypto.Curve25519PublicKey) (*OlmSession, error) {
+	return newOutboundOlmSession(identityKeyAlice, identityKeyBob, oneTimeKeyBob, nil)
+}
+
+// NewOutboundHybridOlmSession creates a new outbound session like [NewOutboundOlmSession],
+// but also mixes a shared secret encapsulated to Bob's KEM public key into the initial root key.
+//
+// The returned KEM ciphertext is not part of the Olm message format, so it must be delivered to
+// Bob alongside the first message and passed to [NewInboundHybridOlmSession].
+func NewOutboundHybridOlmSession(identityKeyAlice crypto.Curve25519Key, identityKeyBob crypto.Curve25519PublicKey, oneTimeKeyBob crypto.Curve25519PublicKey, kemSuite suite.Suite, kemKeyBob []byte) (*OlmSession, []byte, error) {
+	kemSecret, kemCiphertext, err := kemSuite.Encapsulate(kemKeyBob)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := newOutboundOlmSession(identityKeyAlice, identityKeyBob, oneTimeKeyBob, kemSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, kemCiphertext, nil
+}
+
+func newOutboundOlmSession(identityKeyAlice crypto.Curve25519Key, identityKeyBob crypto.Curve25519PublicKey, oneTimeKeyBob crypto.Curve25519PublicKey, kemSecret []byte) (*OlmSession, error) {
 	s := NewOlmSession()
 	//generate E_A
 	baseKey, err := crypto.Curve25519GenerateKey()
@@ -102,16 +132,32 @@ func NewOutboundOlmSession(identityKeyAlice crypto.Curve25519KeyPair, identityKe
 	secret = append(secret, idSecret...)
 	secret = append(secret, baseIdSecret...)
 	secret = append(secret, baseOneTimeSecret...)
+	secret = append(secret, kemSecret...)
 	//Init Ratchet
 	s.Ratchet.InitializeAsAlice(secret, ratchetKey)
-	s.AliceIdentityKey = identityKeyAlice.PublicKey
+	s.AliceIdentityKey = identityKeyAlice.GetPublicKey()
 	s.AliceBaseKey = baseKey.PublicKey
 	s.BobOneTimeKey = oneTimeKeyBob
 	return s, nil
 }
 
 // NewInboundOlmSession creates a new inbound session from receiving the first message.
-func NewInboundOlmSession(identityKeyAlice *crypto.Curve25519PublicKey, receivedOTKMsg []byte, searchBobOTK SearchOTKFunc, identityKeyBob crypto.Curve25519KeyPair) (*OlmSession, error) {
+func NewInboundOlmSession(identityKeyAlice *crypto.Curve25519PublicKey, receivedOTKMsg []byte, searchBobOTK SearchOTKFunc, identityKeyBob crypto.Curve25519Key) (*OlmSession, error) {
+	return newInboundOlmSession(identityKeyAlice, receivedOTKMsg, searchBobOTK, identityKeyBob, nil)
+}
+
+// NewInboundHybridOlmSession creates a new inbound session like [NewInboundOlmSession] from a
+// session created with [NewOutboundHybridOlmSession]. The KEM ciphertext is decapsulated with
+// Bob's KEM private key and the shared secret is mixed into the initial root key.
+func NewInboundHybridOlmSession(identityKeyAlice *crypto.Curve25519PublicKey, receivedOTKMsg []byte, searchBobOTK SearchOTKFunc, identityKeyBob crypto.Curve25519Key, kemSuite suite.Suite, kemPrivateKeyBob, kemCiphertext []byte) (*OlmSession, error) {
+	kemSecret, err := kemSuite.Decapsulate(kemPrivateKeyBob, kemCiphertext)
+	if err != nil {
+		return nil, err
+	}
+	return newInboundOlmSession(identityKeyAlice, receivedOTKMsg, searchBobOTK, identityKeyBob, kemSecret)
+}
+
+func newInboundOlmSession(identityKeyAlice *crypto.Curve25519PublicKey, receivedOTKMsg []byte, searchBobOTK SearchOTKFunc, identityKeyBob crypto.Curve25519Key, kemSecret []byte) (*OlmSession, error) {
 	decodedOTKMsg, err := goolmbase64.Decode(receivedOTKMsg)
 	if err != nil {
 		return nil, err
@@ -166,6 +212,7 @@ func NewInboundOlmSession(identityKeyAlice *crypto.Curve25519PublicKey, received
 	secret = append(secret, idSecret...)
 	secret = append(secret, baseIdSecret...)
 	secret = append(secret, baseOneTimeSecret...)
+	secret = append(secret, kemSecret...)
 	//decode message
 	msg := message.Message{}
 	err = msg.Decode(oneTimeMsg.Message)
@@ -294,7 +341,9 @@ func (s *OlmSession) EncryptMsgType() id.OlmMsgType {
 
 // Encrypt encrypts a message using the Session. Returns the encrypted message base64 encoded.
 func (s *OlmSession) Encrypt(plaintext []byte) (id.OlmMsgType, []byte, error) {
-	if len(plaintext) == 0 {
+	if s.wiped {
+		return 0, nil, olm.ErrWiped
+	} else if len(plaintext) == 0 {
 		return 0, nil, fmt.Errorf("encrypt: %w", olm.ErrEmptyInput)
 	}
 	messageType := s.EncryptMsgType()
@@ -324,7 +373,9 @@ func (s *OlmSession) Encrypt(plaintext []byte) (id.OlmMsgType, []byte, error) {
 
 // Decrypt decrypts a base64 encoded message using the Session.
 func (s *OlmSession) Decrypt(crypttext string, msgType id.OlmMsgType) ([]byte, error) {
-	if len(crypttext) == 0 {
+	if s.wiped {
+		return nil, olm.ErrWiped
+	} else if len(crypttext) == 0 {
 		return nil, fmt.Errorf("decrypt: %w", olm.ErrEmptyInput)
 	}
 	decodedCrypttext, err := goolmbase64.Decode([]byte(crypttext))
@@ -393,7 +444,9 @@ func (o *OlmSession) UnpickleLibOlm(buf []byte) error {
 // Pickle returns a base64 encoded and with key encrypted pickled olmSession
 // using PickleLibOlm().
 func (s *OlmSession) Pickle(key []byte) ([]byte, error) {
-	if len(key) == 0 {
+	if s.wiped {
+		return nil, olm.ErrWiped
+	} else if len(key) == 0 {
 		return nil, olm.ErrNoKeyProvided
 	}
 	return cipher.Pickle(key, s.PickleLibOlm())
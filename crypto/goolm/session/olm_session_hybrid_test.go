@@ -0,0 +1,59 @@
+//go:build go1.24
+
+package session_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/crypto/goolm/crypto"
+	"maunium.net/go/mautrix/crypto/goolm/session"
+	"maunium.net/go/mautrix/crypto/goolm/suite"
+)
+
+func TestHybridOlmSession(t *testing.T) {
+	kemSuite, err := suite.Get(suite.AlgorithmX25519MLKEM768)
+	require.NoError(t, err)
+	aliceKeyPair, err := crypto.Curve25519GenerateKey()
+	require.NoError(t, err)
+	bobKeyPair, err := crypto.Curve25519GenerateKey()
+	require.NoError(t, err)
+	bobOneTimeKey, err := crypto.Curve25519GenerateKey()
+	require.NoError(t, err)
+	bobKEMPrivateKey, bobKEMPublicKey, err := kemSuite.GenerateKey()
+	require.NoError(t, err)
+	searchFunc := func(target crypto.Curve25519PublicKey) *crypto.OneTimeKey {
+		if target.Equal(bobOneTimeKey.PublicKey) {
+			return &crypto.OneTimeKey{Key: bobOneTimeKey, ID: 1}
+		}
+		return nil
+	}
+
+	aliceSession, kemCiphertext, err := session.NewOutboundHybridOlmSession(aliceKeyPair, bobKeyPair.PublicKey, bobOneTimeKey.PublicKey, kemSuite, bobKEMPublicKey)
+	require.NoError(t, err)
+	plaintext := []byte("Test message from Alice to Bob")
+	msgType, message, err := aliceSession.Encrypt(plaintext)
+	require.NoError(t, err)
+
+	bobSession, err := session.NewInboundHybridOlmSession(nil, message, searchFunc, bobKeyPair, kemSuite, bobKEMPrivateKey, kemCiphertext)
+	require.NoError(t, err)
+	decrypted, err := bobSession.Decrypt(string(message), msgType)
+	assert.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	// A session created without the KEM secret must not be able to decrypt the message
+	classicSession, err := session.NewInboundOlmSession(nil, message, searchFunc, bobKeyPair)
+	require.NoError(t, err)
+	_, err = classicSession.Decrypt(string(message), msgType)
+	assert.Error(t, err)
+
+	// Neither can a session with a different KEM key
+	otherKEMPrivateKey, _, err := kemSuite.GenerateKey()
+	require.NoError(t, err)
+	otherSession, err := session.NewInboundHybridOlmSession(nil, message, searchFunc, bobKeyPair, kemSuite, otherKEMPrivateKey, kemCiphertext)
+	require.NoError(t, err)
+	_, err = otherSession.Decrypt(string(message), msgType)
+	assert.Error(t, err)
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build go1.24
+
+package suite
+
+import (
+	"crypto/mlkem"
+	"fmt"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// AlgorithmX25519MLKEM768 is the algorithm name of the hybrid suite that combines the
+// Olm X25519 handshake with ML-KEM-768.
+const AlgorithmX25519MLKEM768 id.Algorithm = "org.mautrix.olm.v1.x25519-mlkem768.aes-sha2"
+
+func init() {
+	Register(MLKEM768{})
+}
+
+// MLKEM768 is a [Suite] that uses ML-KEM-768 as specified in FIPS 203.
+//
+// Private keys are stored as the 64-byte seed form.
+type MLKEM768 struct{}
+
+var _ Suite = MLKEM768{}
+
+func (MLKEM768) Algorithm() id.Algorithm {
+	return AlgorithmX25519MLKEM768
+}
+
+func (MLKEM768) GenerateKey() (privateKey, publicKey []byte, err error) {
+	dk, err := mlkem.GenerateKey768()
+	if err != nil {
+		return nil, nil, err
+	}
+	return dk.Bytes(), dk.EncapsulationKey().Bytes(), nil
+}
+
+func (MLKEM768) Encapsulate(publicKey []byte) (sharedSecret, ciphertext []byte, err error) {
+	ek, err := mlkem.NewEncapsulationKey768(publicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidKEMPublicKey, err)
+	}
+	sharedSecret, ciphertext = ek.Encapsulate()
+	return sharedSecret, ciphertext, nil
+}
+
+func (MLKEM768) Decapsulate(privateKey, ciphertext []byte) (sharedSecret []byte, err error) {
+	dk, err := mlkem.NewDecapsulationKey768(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKEMPrivateKey, err)
+	}
+	return dk.Decapsulate(ciphertext)
+}
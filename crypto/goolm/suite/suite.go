@@ -0,0 +1,75 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package suite provides experimental key agreement suites that can be mixed into the
+// Olm triple Diffie-Hellman handshake.
+//
+// Suites are not part of the Matrix specification. They are negotiated using custom
+// algorithm names, so they're only useful for deployments that control both ends.
+package suite
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	ErrUnknownSuite         = errors.New("unknown key agreement suite")
+	ErrInvalidKEMPublicKey  = errors.New("invalid KEM public key")
+	ErrInvalidKEMPrivateKey = errors.New("invalid KEM private key")
+)
+
+// Suite is a key encapsulation mechanism whose shared secret is combined with the
+// classical X25519 triple Diffie-Hellman secret when creating an Olm session.
+type Suite interface {
+	// Algorithm returns the custom algorithm name used to negotiate the suite.
+	Algorithm() id.Algorithm
+	// GenerateKey generates a new KEM key pair. The private key is returned in a
+	// serialized form that can be stored and later passed to Decapsulate.
+	GenerateKey() (privateKey, publicKey []byte, err error)
+	// Encapsulate generates a shared secret for the given public key and returns it
+	// along with the ciphertext that must be delivered to the owner of the key.
+	Encapsulate(publicKey []byte) (sharedSecret, ciphertext []byte, err error)
+	// Decapsulate recovers the shared secret from a ciphertext using the private key.
+	Decapsulate(privateKey, ciphertext []byte) (sharedSecret []byte, err error)
+}
+
+var (
+	suites     = make(map[id.Algorithm]Suite)
+	suitesLock sync.RWMutex
+)
+
+// Register adds a suite to the registry, replacing any existing suite with the same algorithm name.
+func Register(s Suite) {
+	suitesLock.Lock()
+	suites[s.Algorithm()] = s
+	suitesLock.Unlock()
+}
+
+// Get returns the registered suite with the given algorithm name.
+func Get(algorithm id.Algorithm) (Suite, error) {
+	suitesLock.RLock()
+	s, ok := suites[algorithm]
+	suitesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownSuite, algorithm)
+	}
+	return s, nil
+}
+
+// Algorithms returns the algorithm names of all registered suites.
+func Algorithms() []id.Algorithm {
+	suitesLock.RLock()
+	defer suitesLock.RUnlock()
+	algorithms := make([]id.Algorithm, 0, len(suites))
+	for algorithm := range suites {
+		algorithms = append(algorithms, algorithm)
+	}
+	return algorithms
+}
@@ -0,0 +1,14 @@
+package suite_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"maunium.net/go/mautrix/crypto/goolm/suite"
+)
+
+func TestGetUnknownSuite(t *testing.T) {
+	_, err := suite.Get("org.example.unknown")
+	assert.ErrorIs(t, err, suite.ErrUnknownSuite)
+}
@@ -17,8 +17,9 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
-	"math"
+	"io"
 
+	"go.mau.fi/util/dbutil"
 	"go.mau.fi/util/random"
 	"golang.org/x/crypto/pbkdf2"
 
@@ -37,6 +38,7 @@ type ExportedSession struct {
 	SenderClaimedKeys SenderClaimedKeys `json:"sender_claimed_keys"`
 	SessionID         id.SessionID      `json:"session_id"`
 	SessionKey        string            `json:"session_key"`
+	SharedHistory     bool              `json:"org.matrix.msc3061.shared_history,omitempty"`
 }
 
 // The default number of pbkdf2 rounds to use when exporting keys
@@ -78,67 +80,79 @@ func makeExportKeys(passphrase string) (encryptionKey, hashKey, salt, iv []byte)
 	return
 }
 
-func exportSessions(sessions []*InboundGroupSession) ([]ExportedSession, error) {
-	export := make([]ExportedSession, len(sessions))
-	for i, session := range sessions {
-		key, err := session.Internal.Export(session.Internal.FirstKnownIndex())
-		if err != nil {
-			return nil, fmt.Errorf("failed to export session: %w", err)
-		}
-		export[i] = ExportedSession{
-			Algorithm:         id.AlgorithmMegolmV1,
-			ForwardingChains:  session.ForwardingChains,
-			RoomID:            session.RoomID,
-			SenderKey:         session.SenderKey,
-			SenderClaimedKeys: SenderClaimedKeys{},
-			SessionID:         session.ID(),
-			SessionKey:        string(key),
-		}
-	}
-	return export, nil
-}
-
-func exportSessionsJSON(sessions []*InboundGroupSession) ([]byte, error) {
-	exportedSessions, err := exportSessions(sessions)
+func exportSession(session *InboundGroupSession) (*ExportedSession, error) {
+	key, err := session.Internal.Export(session.Internal.FirstKnownIndex())
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to export session: %w", err)
 	}
-	return json.Marshal(exportedSessions)
+	return &ExportedSession{
+		Algorithm:         id.AlgorithmMegolmV1,
+		ForwardingChains:  session.ForwardingChains,
+		RoomID:            session.RoomID,
+		SenderKey:         session.SenderKey,
+		SenderClaimedKeys: SenderClaimedKeys{},
+		SessionID:         session.ID(),
+		SessionKey:        string(key),
+		SharedHistory:     session.SharedHistory,
+	}, nil
 }
 
-func formatKeyExportData(data []byte) []byte {
-	base64Data := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
-	base64.StdEncoding.Encode(base64Data, data)
-
-	// Prefix + data and newline for each 76 characters of data + suffix
-	outputLength := len(exportPrefix) +
-		len(base64Data) + int(math.Ceil(float64(len(base64Data))/exportLineLengthLimit)) +
-		len(exportSuffix)
+// lineWrapWriter inserts a newline after every exportLineLengthLimit bytes.
+type lineWrapWriter struct {
+	w   io.Writer
+	col int
+}
 
-	var buf bytes.Buffer
-	buf.Grow(outputLength)
-	buf.WriteString(exportPrefix)
-	for ptr := 0; ptr < len(base64Data); ptr += exportLineLengthLimit {
-		buf.Write(base64Data[ptr:min(ptr+exportLineLengthLimit, len(base64Data))])
-		buf.WriteRune('\n')
+func (lww *lineWrapWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := p[:min(len(p), exportLineLengthLimit-lww.col)]
+		var written int
+		written, err = lww.w.Write(chunk)
+		n += written
+		lww.col += written
+		if err != nil {
+			return
+		}
+		p = p[written:]
+		if lww.col == exportLineLengthLimit {
+			if _, err = lww.w.Write([]byte{'\n'}); err != nil {
+				return
+			}
+			lww.col = 0
+		}
 	}
-	buf.WriteString(exportSuffix)
-	if buf.Len() != buf.Cap() || buf.Len() != outputLength {
-		panic(fmt.Errorf("unexpected length %d / %d / %d", buf.Len(), buf.Cap(), outputLength))
+	return
+}
+
+// Close writes the final newline if the last line is incomplete.
+func (lww *lineWrapWriter) Close() error {
+	if lww.col > 0 {
+		lww.col = 0
+		_, err := lww.w.Write([]byte{'\n'})
+		return err
 	}
-	return buf.Bytes()
+	return nil
 }
 
 // ExportKeys exports the given Megolm sessions with the format specified in the Matrix spec.
 // See https://spec.matrix.org/v1.2/client-server-api/#key-exports
 func ExportKeys(passphrase string, sessions []*InboundGroupSession) ([]byte, error) {
-	// Make all the keys necessary for exporting
-	encryptionKey, hashKey, salt, iv := makeExportKeys(passphrase)
-	// Export all the given sessions and put them in JSON
-	unencryptedData, err := exportSessionsJSON(sessions)
+	var buf bytes.Buffer
+	_, err := ExportKeysStream(&buf, passphrase, dbutil.NewSliceIter(sessions), nil)
 	if err != nil {
 		return nil, err
 	}
+	return buf.Bytes(), nil
+}
+
+// ExportKeysStream exports the Megolm sessions from the given iterator into the writer using the same format as ExportKeys.
+//
+// Sessions are encrypted and written one by one, so the memory usage doesn't depend on the number of sessions.
+// If progress is non-nil, it's called with the number of sessions written so far after each session.
+// The returned integer is the total number of exported sessions.
+func ExportKeysStream(w io.Writer, passphrase string, sessions dbutil.RowIter[*InboundGroupSession], progress func(exported int)) (int, error) {
+	// Make all the keys necessary for exporting
+	encryptionKey, hashKey, salt, iv := makeExportKeys(passphrase)
 
 	// The export data consists of:
 	// 1 byte of export format version
@@ -147,25 +161,67 @@ func ExportKeys(passphrase string, sessions []*InboundGroupSession) ([]byte, err
 	// 4 bytes of the number of rounds
 	// the encrypted export data
 	// 32 bytes of the hash of all the data above
+	// All of it is base64-encoded and wrapped between the prefix and suffix lines.
+	_, err := io.WriteString(w, exportPrefix)
+	if err != nil {
+		return 0, err
+	}
+	lineWriter := &lineWrapWriter{w: w}
+	base64Writer := base64.NewEncoder(base64.StdEncoding, lineWriter)
+	mac := hmac.New(sha256.New, hashKey)
+	dataWriter := io.MultiWriter(base64Writer, mac)
+
+	header := make([]byte, exportHeaderLength)
+	header[0] = exportVersion1
+	copy(header[1:17], salt)
+	copy(header[17:33], iv)
+	binary.BigEndian.PutUint32(header[33:37], defaultPassphraseRounds)
+	if _, err = dataWriter.Write(header); err != nil {
+		return 0, err
+	}
 
-	exportData := make([]byte, exportHeaderLength+len(unencryptedData)+exportHashLength)
-	dataWithoutHashLength := len(exportData) - exportHashLength
-
-	// Create the header for the export data
-	exportData[0] = exportVersion1
-	copy(exportData[1:17], salt)
-	copy(exportData[17:33], iv)
-	binary.BigEndian.PutUint32(exportData[33:37], defaultPassphraseRounds)
-
-	// Encrypt data with AES-256-CTR
+	// Encrypt the JSON array of sessions with AES-256-CTR
 	block, _ := aes.NewCipher(encryptionKey)
-	cipher.NewCTR(block, iv).XORKeyStream(exportData[exportHeaderLength:dataWithoutHashLength], unencryptedData)
-
-	// Hash all the data with HMAC-SHA256 and put it at the end
-	mac := hmac.New(sha256.New, hashKey)
-	mac.Write(exportData[:dataWithoutHashLength])
-	mac.Sum(exportData[:dataWithoutHashLength])
+	encryptedWriter := &cipher.StreamWriter{S: cipher.NewCTR(block, iv), W: dataWriter}
+	if _, err = encryptedWriter.Write([]byte{'['}); err != nil {
+		return 0, err
+	}
+	count := 0
+	err = sessions.Iter(func(session *InboundGroupSession) (bool, error) {
+		exported, err := exportSession(session)
+		if err != nil {
+			return false, err
+		}
+		data, err := json.Marshal(exported)
+		if err != nil {
+			return false, err
+		}
+		if count > 0 {
+			data = append([]byte{','}, data...)
+		}
+		if _, err = encryptedWriter.Write(data); err != nil {
+			return false, err
+		}
+		count++
+		if progress != nil {
+			progress(count)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return count, err
+	} else if _, err = encryptedWriter.Write([]byte{']'}); err != nil {
+		return count, err
+	}
 
-	// Format the export (prefix, base64'd exportData, suffix) and return
-	return formatKeyExportData(exportData), nil
+	// Write the HMAC-SHA256 of all the data above at the end
+	if _, err = base64Writer.Write(mac.Sum(nil)); err != nil {
+		return count, err
+	} else if err = base64Writer.Close(); err != nil {
+		return count, err
+	} else if err = lineWriter.Close(); err != nil {
+		return count, err
+	}
+	_, err = io.WriteString(w, exportSuffix)
+	return count, err
 }
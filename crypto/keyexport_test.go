@@ -0,0 +1,75 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/dbutil"
+
+	"maunium.net/go/mautrix/id"
+)
+
+func TestExportImportKeysStream(t *testing.T) {
+	machineOut := newMachine(t, "user1")
+	var sessions []*InboundGroupSession
+	for _, roomID := range []id.RoomID{"room1", "room2", "room3"} {
+		outSess, err := machineOut.newOutboundGroupSession(context.TODO(), roomID)
+		require.NoError(t, err)
+		inSess, err := machineOut.CryptoStore.GetGroupSession(context.TODO(), roomID, outSess.ID())
+		require.NoError(t, err)
+		sessions = append(sessions, inSess)
+	}
+
+	var buf bytes.Buffer
+	var exportProgress []int
+	count, err := ExportKeysStream(&buf, "meow", dbutil.NewSliceIter(sessions), func(exported int) {
+		exportProgress = append(exportProgress, exported)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	assert.Equal(t, []int{1, 2, 3}, exportProgress)
+
+	machineIn := newMachine(t, "user2")
+	_, _, err = machineIn.ImportKeys(context.TODO(), "wrong", buf.Bytes())
+	assert.ErrorIs(t, err, ErrMismatchingExportHash)
+	_, _, err = machineIn.ImportKeys(context.TODO(), "meow", buf.Bytes()[:buf.Len()-5])
+	assert.ErrorIs(t, err, ErrMissingExportSuffix)
+
+	var importProgress []int
+	imported, total, err := machineIn.ImportKeysStream(context.TODO(), "meow", bytes.NewReader(buf.Bytes()), func(imported, processed int) {
+		importProgress = append(importProgress, processed)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, imported)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, []int{1, 2, 3}, importProgress)
+	for _, sess := range sessions {
+		importedSess, err := machineIn.CryptoStore.GetGroupSession(context.TODO(), sess.RoomID, sess.ID())
+		require.NoError(t, err)
+		require.NotNil(t, importedSess)
+	}
+
+	// Importing the same sessions again shouldn't override them
+	imported, total, err = machineIn.ImportKeys(context.TODO(), "meow", buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, 0, imported)
+	assert.Equal(t, 3, total)
+}
+
+func TestExportKeys_Empty(t *testing.T) {
+	export, err := ExportKeys("meow", nil)
+	require.NoError(t, err)
+	imported, total, err := newMachine(t, "user1").ImportKeys(context.TODO(), "meow", export)
+	require.NoError(t, err)
+	assert.Equal(t, 0, imported)
+	assert.Equal(t, 0, total)
+}
@@ -7,6 +7,7 @@
 package crypto
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/aes"
@@ -18,6 +19,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"time"
 
 	"maunium.net/go/mautrix/crypto/olm"
@@ -33,63 +36,129 @@ var (
 	ErrMismatchingExportedSessionID = errors.New("imported session has different ID than expected")
 )
 
-var exportPrefixBytes, exportSuffixBytes = []byte(exportPrefix), []byte(exportSuffix)
+// keyExportBodyReader reads the base64 body of a key export, stopping at the start of the suffix line.
+type keyExportBodyReader struct {
+	r *bufio.Reader
+}
 
-func decodeKeyExport(data []byte) ([]byte, error) {
-	// If the valid prefix and suffix aren't there, it's probably not a Matrix key export
-	if !bytes.HasPrefix(data, exportPrefixBytes) {
-		return nil, ErrMissingExportPrefix
-	} else if !bytes.HasSuffix(data, exportSuffixBytes) {
-		return nil, ErrMissingExportSuffix
+func (kebr *keyExportBodyReader) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		var b byte
+		b, err = kebr.r.ReadByte()
+		if err != nil {
+			return
+		} else if b == exportSuffix[0] {
+			_ = kebr.r.UnreadByte()
+			return n, io.EOF
+		}
+		p[n] = b
+		n++
 	}
-	// Remove the prefix and suffix, we don't care about them anymore
-	data = data[len(exportPrefix) : len(data)-len(exportSuffix)]
+	return
+}
 
-	// Allocate space for the decoded data. Ignore newlines when counting the length
-	exportData := make([]byte, base64.StdEncoding.DecodedLen(len(data)-bytes.Count(data, []byte{'\n'})))
-	n, err := base64.StdEncoding.Decode(exportData, data)
-	if err != nil {
-		return nil, err
+// hashTrailerReader passes through everything except the last exportHashLength bytes of the underlying reader,
+// which are stored in trailer after EOF is reached.
+type hashTrailerReader struct {
+	r   io.Reader
+	buf []byte
+	eof bool
+}
+
+func (htr *hashTrailerReader) Read(p []byte) (int, error) {
+	need := len(p) + exportHashLength
+	if cap(htr.buf) < need {
+		newBuf := make([]byte, len(htr.buf), need)
+		copy(newBuf, htr.buf)
+		htr.buf = newBuf
+	}
+	for !htr.eof && len(htr.buf) < need {
+		n, err := htr.r.Read(htr.buf[len(htr.buf):need])
+		htr.buf = htr.buf[:len(htr.buf)+n]
+		if errors.Is(err, io.EOF) {
+			htr.eof = true
+		} else if err != nil {
+			return 0, err
+		}
 	}
+	available := len(htr.buf) - exportHashLength
+	if available <= 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, htr.buf[:min(available, len(p))])
+	htr.buf = htr.buf[:copy(htr.buf, htr.buf[n:])]
+	return n, nil
+}
 
-	return exportData[:n], nil
+func (htr *hashTrailerReader) trailer() []byte {
+	return htr.buf
 }
 
-func decryptKeyExport(passphrase string, exportData []byte) ([]ExportedSession, error) {
-	if exportData[0] != exportVersion1 {
-		return nil, ErrUnsupportedExportVersion
+// keyExportStream decrypts a key export on the fly. The hash can only be verified after reading the entire stream,
+// so the plaintext must not be trusted before finish returns without an error.
+type keyExportStream struct {
+	src       *bufio.Reader
+	trailer   *hashTrailerReader
+	mac       hash.Hash
+	plaintext io.Reader
+}
+
+func openKeyExportStream(r io.Reader, deriveKeys func(salt []byte, rounds int) (encryptionKey, hashKey []byte)) (*keyExportStream, error) {
+	src := bufio.NewReader(r)
+	prefix := make([]byte, len(exportPrefix))
+	// If the valid prefix isn't there, it's probably not a Matrix key export
+	if _, err := io.ReadFull(src, prefix); err != nil || string(prefix) != exportPrefix {
+		return nil, ErrMissingExportPrefix
 	}
+	// The base64 decoder ignores newlines, so they don't need to be removed separately
+	exportData := base64.NewDecoder(base64.StdEncoding, &keyExportBodyReader{r: src})
 
-	// Get all the different parts of the export
-	salt := exportData[1:17]
-	iv := exportData[17:33]
-	passphraseRounds := binary.BigEndian.Uint32(exportData[33:37])
-	dataWithoutHashLength := len(exportData) - exportHashLength
-	encryptedData := exportData[exportHeaderLength:dataWithoutHashLength]
-	hash := exportData[dataWithoutHashLength:]
+	header := make([]byte, exportHeaderLength)
+	if _, err := io.ReadFull(exportData, header); err != nil {
+		return nil, fmt.Errorf("failed to read export header: %w", err)
+	} else if header[0] != exportVersion1 {
+		return nil, ErrUnsupportedExportVersion
+	}
+	// Get all the different parts of the header
+	salt := header[1:17]
+	iv := header[17:33]
+	passphraseRounds := binary.BigEndian.Uint32(header[33:37])
 
 	// Compute the encryption and hash keys from the passphrase and salt
-	encryptionKey, hashKey := computeKey(passphrase, salt, int(passphraseRounds))
+	encryptionKey, hashKey := deriveKeys(salt, int(passphraseRounds))
 
-	// Compute and verify the hash. If it doesn't match, the passphrase is probably wrong
 	mac := hmac.New(sha256.New, hashKey)
-	mac.Write(exportData[:dataWithoutHashLength])
-	if !bytes.Equal(hash, mac.Sum(nil)) {
-		return nil, ErrMismatchingExportHash
-	}
-
-	// Decrypt the export
+	mac.Write(header)
+	trailer := &hashTrailerReader{r: exportData}
 	block, _ := aes.NewCipher(encryptionKey)
-	unencryptedData := make([]byte, len(exportData)-exportHashLength-exportHeaderLength)
-	cipher.NewCTR(block, iv).XORKeyStream(unencryptedData, encryptedData)
+	return &keyExportStream{
+		src:     src,
+		trailer: trailer,
+		mac:     mac,
+		plaintext: &cipher.StreamReader{
+			S: cipher.NewCTR(block, iv),
+			R: io.TeeReader(trailer, mac),
+		},
+	}, nil
+}
 
-	// Parse the decrypted JSON
-	var sessionsJSON []ExportedSession
-	err := json.Unmarshal(unencryptedData, &sessionsJSON)
+// finish reads the rest of the stream and verifies the hash and suffix.
+func (kes *keyExportStream) finish() error {
+	if _, err := io.Copy(io.Discard, kes.plaintext); err != nil {
+		return err
+	} else if len(kes.trailer.trailer()) != exportHashLength {
+		return fmt.Errorf("failed to read export hash: %w", io.ErrUnexpectedEOF)
+	} else if !hmac.Equal(kes.trailer.trailer(), kes.mac.Sum(nil)) {
+		// If the hash doesn't match, the passphrase is probably wrong
+		return ErrMismatchingExportHash
+	}
+	suffix, err := io.ReadAll(io.LimitReader(kes.src, int64(len(exportSuffix)+1)))
 	if err != nil {
-		return nil, fmt.Errorf("invalid export json: %w", err)
+		return err
+	} else if string(suffix) != exportSuffix {
+		return ErrMissingExportSuffix
 	}
-	return sessionsJSON, nil
+	return nil
 }
 
 func (mach *OlmMachine) importExportedRoomKey(ctx context.Context, session ExportedSession) (bool, error) {
@@ -111,7 +180,8 @@ func (mach *OlmMachine) importExportedRoomKey(ctx context.Context, session Expor
 		// TODO should we add something here to mark the signing key as unverified like key requests do?
 		ForwardingChains: session.ForwardingChains,
 
-		ReceivedAt: time.Now().UTC(),
+		ReceivedAt:    time.Now().UTC(),
+		SharedHistory: session.SharedHistory,
 	}
 	existingIGS, _ := mach.CryptoStore.GetGroupSession(ctx, igs.RoomID, igs.ID())
 	firstKnownIndex := igs.Internal.FirstKnownIndex()
@@ -130,17 +200,58 @@ func (mach *OlmMachine) importExportedRoomKey(ctx context.Context, session Expor
 // ImportKeys imports data that was exported with the format specified in the Matrix spec.
 // See https://spec.matrix.org/v1.2/client-server-api/#key-exports
 func (mach *OlmMachine) ImportKeys(ctx context.Context, passphrase string, data []byte) (int, int, error) {
-	exportData, err := decodeKeyExport(data)
+	return mach.ImportKeysStream(ctx, passphrase, bytes.NewReader(data), nil)
+}
+
+// ImportKeysStream imports a key export from the given reader without loading the entire export into memory.
+//
+// The export is read twice: first to verify the hash, and then to decrypt and import the sessions one by one,
+// which is why the reader must be seekable. If progress is non-nil, it's called after each processed session
+// with the number of sessions imported and processed so far.
+//
+// The returned integers are the number of sessions imported and the total number of sessions in the export.
+func (mach *OlmMachine) ImportKeysStream(ctx context.Context, passphrase string, r io.ReadSeeker, progress func(imported, processed int)) (int, int, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
 	if err != nil {
 		return 0, 0, err
 	}
-	sessions, err := decryptKeyExport(passphrase, exportData)
+	var encryptionKey, hashKey []byte
+	deriveKeys := func(salt []byte, rounds int) ([]byte, []byte) {
+		if encryptionKey == nil {
+			encryptionKey, hashKey = computeKey(passphrase, salt, rounds)
+		}
+		return encryptionKey, hashKey
+	}
+
+	// Verify the hash of the whole export before trusting any of the data
+	stream, err := openKeyExportStream(r, deriveKeys)
+	if err != nil {
+		return 0, 0, err
+	} else if err = stream.finish(); err != nil {
+		return 0, 0, err
+	} else if _, err = r.Seek(start, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	stream, err = openKeyExportStream(r, deriveKeys)
 	if err != nil {
 		return 0, 0, err
 	}
 
-	count := 0
-	for _, session := range sessions {
+	// Parse the decrypted JSON array one session at a time
+	decoder := json.NewDecoder(stream.plaintext)
+	if token, err := decoder.Token(); err != nil {
+		return 0, 0, fmt.Errorf("invalid export json: %w", err)
+	} else if token != json.Delim('[') {
+		return 0, 0, fmt.Errorf("invalid export json: expected array, got %v", token)
+	}
+	count, total := 0, 0
+	for decoder.More() {
+		var session ExportedSession
+		err = decoder.Decode(&session)
+		if err != nil {
+			return count, total, fmt.Errorf("invalid export json: %w", err)
+		}
+		total++
 		log := mach.Log.With().
 			Str("room_id", session.RoomID.String()).
 			Str("session_id", session.SessionID.String()).
@@ -148,7 +259,7 @@ func (mach *OlmMachine) ImportKeys(ctx context.Context, passphrase string, data
 		imported, err := mach.importExportedRoomKey(ctx, session)
 		if err != nil {
 			if ctx.Err() != nil {
-				return count, len(sessions), ctx.Err()
+				return count, total, ctx.Err()
 			}
 			log.Error().Err(err).Msg("Failed to import Megolm session from file")
 		} else if imported {
@@ -157,6 +268,14 @@ func (mach *OlmMachine) ImportKeys(ctx context.Context, passphrase string, data
 		} else {
 			log.Debug().Msg("Skipped Megolm session which is already in the store")
 		}
+		if progress != nil {
+			progress(count, total)
+		}
+	}
+	if _, err = decoder.Token(); err != nil {
+		return count, total, fmt.Errorf("invalid export json: %w", err)
+	} else if err = stream.finish(); err != nil {
+		return count, total, err
 	}
-	return count, len(sessions), nil
+	return count, total, nil
 }
@@ -135,6 +135,20 @@ func (mach *OlmMachine) SendRoomKeyRequest(ctx context.Context, roomID id.RoomID
 	return err
 }
 
+// resolveForwarderTrust returns the trust level of the device that sent the given forwarded room key.
+func (mach *OlmMachine) resolveForwarderTrust(ctx context.Context, evt *DecryptedOlmEvent) id.TrustState {
+	device, err := mach.CryptoStore.FindDeviceByKey(ctx, evt.Sender, evt.SenderKey)
+	if err != nil || device == nil {
+		return id.TrustStateUnknownDevice
+	}
+	trust, err := mach.ResolveTrustContext(ctx, device)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to resolve trust of room key forwarder")
+		return id.TrustStateUnknownDevice
+	}
+	return trust
+}
+
 func (mach *OlmMachine) importForwardedRoomKey(ctx context.Context, evt *DecryptedOlmEvent, content *event.ForwardedRoomKeyEventContent) bool {
 	log := zerolog.Ctx(ctx).With().
 		Str("session_id", content.SessionID.String()).
@@ -189,6 +203,9 @@ func (mach *OlmMachine) importForwardedRoomKey(ctx context.Context, evt *Decrypt
 		MaxAge:      maxAge.Milliseconds(),
 		MaxMessages: maxMessages,
 		IsScheduled: content.IsScheduled,
+
+		SharedHistory:  content.SharedHistory,
+		ForwarderTrust: mach.resolveForwarderTrust(ctx, evt),
 	}
 	existingIGS, _ := mach.CryptoStore.GetGroupSession(ctx, igs.RoomID, igs.ID())
 	if existingIGS != nil && existingIGS.Internal.FirstKnownIndex() <= igs.Internal.FirstKnownIndex() {
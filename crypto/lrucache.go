@@ -0,0 +1,74 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package crypto
+
+import (
+	"container/list"
+)
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// lruCache is a simple size-bounded map that evicts the least recently used entries first.
+// It is not safe for concurrent use, callers must handle locking.
+type lruCache[K comparable, V any] struct {
+	// The maximum number of entries to keep. Zero or negative means unlimited.
+	maxSize int
+	items   map[K]*list.Element
+	order   *list.List
+}
+
+func newLRUCache[K comparable, V any](maxSize int) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		maxSize: maxSize,
+		items:   make(map[K]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *lruCache[K, V]) Get(key K) (value V, ok bool) {
+	elem, ok := c.items[key]
+	if !ok {
+		return
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry[K, V]).value, true
+}
+
+func (c *lruCache[K, V]) Put(key K, value V) {
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*lruEntry[K, V]).value = value
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry[K, V]{key: key, value: value})
+	for c.maxSize > 0 && c.order.Len() > c.maxSize {
+		c.Remove(c.order.Back().Value.(*lruEntry[K, V]).key)
+	}
+}
+
+func (c *lruCache[K, V]) Remove(key K) {
+	if elem, ok := c.items[key]; ok {
+		c.order.Remove(elem)
+		delete(c.items, key)
+	}
+}
+
+// Values returns all values in the cache in no particular order.
+func (c *lruCache[K, V]) Values() []V {
+	values := make([]V, 0, len(c.items))
+	for _, elem := range c.items {
+		values = append(values, elem.Value.(*lruEntry[K, V]).value)
+	}
+	return values
+}
+
+func (c *lruCache[K, V]) Len() int {
+	return c.order.Len()
+}
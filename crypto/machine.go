@@ -19,6 +19,7 @@ import (
 	"go.mau.fi/util/exzerolog"
 
 	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/olm"
 	"maunium.net/go/mautrix/crypto/ssss"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
@@ -48,6 +49,21 @@ type OlmMachine struct {
 
 	AllowKeyShare func(context.Context, *id.Device, event.RequestedKeyInfo) *KeyShareRejection
 
+	// FallbackKeyRotation enables uploading fallback keys and configures when they're rotated.
+	// If nil, fallback keys aren't used. Fallback keys are only supported with goolm.
+	FallbackKeyRotation *FallbackKeyRotationPolicy
+
+	// GroupSessionPreSharing enables sharing outbound group sessions in the background before they're needed.
+	GroupSessionPreSharing *GroupSessionPreSharing
+
+	// KeyProvider is an optional external backend for the identity keys of the Olm account.
+	// It must be set before calling Load.
+	KeyProvider olm.KeyProvider
+	// MigrateToKeyProvider allows Load to move an existing account that still has private identity keys
+	// to KeyProvider. The private keys are permanently removed from the crypto store, so they must have
+	// been imported into the provider beforehand. Without this, Load refuses to remove the keys.
+	MigrateToKeyProvider bool
+
 	account *OlmAccount
 
 	roomKeyRequestFilled            *sync.Map
@@ -68,13 +84,20 @@ type OlmMachine struct {
 	olmHashSavePointLock sync.Mutex
 
 	olmLock           sync.Mutex
-	megolmEncryptLock sync.Mutex
 	megolmDecryptLock sync.Mutex
 
+	// Outbound group session operations are locked per room, so that slow session sharing
+	// (e.g. pre-sharing in the background) doesn't block sending messages in other rooms.
+	megolmEncryptLocks     map[id.RoomID]*roomLock
+	megolmEncryptLocksLock sync.Mutex
+
 	otkUploadLock       sync.Mutex
 	lastOTKUpload       time.Time
 	receivedOTKsForSelf atomic.Bool
 
+	preShareRunning atomic.Bool
+	lastPreShare    time.Time
+
 	CrossSigningKeys    *CrossSigningKeysCache
 	crossSigningPubkeys *CrossSigningPublicKeysCache
 
@@ -90,6 +113,13 @@ type OlmMachine struct {
 
 	DisableDeviceChangeKeyRotation bool
 
+	deviceListListeners     map[id.UserID][]*deviceListListener
+	deviceListListenersLock sync.RWMutex
+	deviceListUpdateLock    sync.Mutex
+	deviceListFailures      int
+	deviceListRetryAt       time.Time
+	deviceListUserBackoff   map[id.UserID]*deviceListBackoff
+
 	secretLock      sync.Mutex
 	secretListeners map[string]chan<- string
 }
@@ -104,6 +134,14 @@ type StateStore interface {
 	FindSharedRooms(context.Context, id.UserID) ([]id.RoomID, error)
 }
 
+// HistoryVisibilityStateStore is an optional extension to StateStore. If the state store implements it,
+// outbound group sessions in rooms with shared or world-readable history are marked as shareable
+// with users who are invited later (MSC3061).
+type HistoryVisibilityStateStore interface {
+	// GetHistoryVisibility returns the history visibility of a room.
+	GetHistoryVisibility(context.Context, id.RoomID) (event.HistoryVisibility, error)
+}
+
 // NewOlmMachine creates an OlmMachine with the given client, logger and stores.
 func NewOlmMachine(client *mautrix.Client, log *zerolog.Logger, cryptoStore Store, stateStore StateStore) *OlmMachine {
 	if log == nil {
@@ -132,6 +170,8 @@ func NewOlmMachine(client *mautrix.Client, log *zerolog.Logger, cryptoStore Stor
 		secretListeners:  make(map[string]chan<- string),
 	}
 	mach.AllowKeyShare = mach.defaultAllowKeyShare
+	// Secrets are only cached if they're added to SSSS.CachedSecrets
+	mach.SSSS.Cache = cryptoStore
 	return mach
 }
 
@@ -143,6 +183,12 @@ func (mach *OlmMachine) machOrContextLog(ctx context.Context) *zerolog.Logger {
 	return log
 }
 
+// observeCryptoOperation reports an operation to the client's instrumentation. It's meant to be deferred
+// at the start of the operation, which is why the error is passed as a pointer.
+func (mach *OlmMachine) observeCryptoOperation(ctx context.Context, op mautrix.CryptoOperation, start time.Time, err *error) {
+	mach.Client.ObserveCryptoOperation(ctx, op, start, *err)
+}
+
 // Load loads the Olm account information from the crypto store. If there's no olm account, a new one is created.
 // This must be called before using the machine.
 func (mach *OlmMachine) Load(ctx context.Context) (err error) {
@@ -150,8 +196,23 @@ func (mach *OlmMachine) Load(ctx context.Context) (err error) {
 	if err != nil {
 		return
 	}
-	if mach.account == nil {
+	if mach.account == nil && mach.KeyProvider != nil {
+		mach.account, err = NewOlmAccountWithKeyProvider(mach.KeyProvider)
+	} else if mach.account == nil {
 		mach.account = NewOlmAccount()
+	} else if mach.KeyProvider != nil && mach.MigrateToKeyProvider {
+		err = mach.account.MigrateToKeyProvider(mach.KeyProvider)
+		if err == nil {
+			err = mach.saveAccount(ctx)
+		}
+	} else if mach.KeyProvider != nil {
+		err = mach.account.SetKeyProvider(mach.KeyProvider)
+	} else if mach.account.NeedsKeyProvider() {
+		err = olm.ErrKeyProviderRequired
+	}
+	if err != nil {
+		mach.account = nil
+		return fmt.Errorf("failed to set key provider: %w", err)
 	}
 	return nil
 }
@@ -174,6 +235,17 @@ func (mach *OlmMachine) SetKeyBackupVersion(ctx context.Context, version id.KeyB
 }
 
 // FlushStore calls the Flush method of the CryptoStore.
+// Close wipes the account keys and any cached sessions from memory. The machine can't be used after this.
+// The crypto store itself is not closed.
+func (mach *OlmMachine) Close() {
+	if wiper, ok := mach.CryptoStore.(interface{ WipeCaches() }); ok {
+		wiper.WipeCaches()
+	}
+	if mach.account != nil {
+		mach.account.Wipe()
+	}
+}
+
 func (mach *OlmMachine) FlushStore(ctx context.Context) error {
 	return mach.CryptoStore.Flush(ctx)
 }
@@ -231,6 +303,7 @@ func (mach *OlmMachine) AddAppserviceListener(ep ASEventProcessor) {
 	ep.On(event.ToDeviceRoomKeyWithheld, mach.HandleToDeviceEvent)
 	ep.On(event.ToDeviceBeeperRoomKeyAck, mach.HandleToDeviceEvent)
 	ep.On(event.ToDeviceOrgMatrixRoomKeyWithheld, mach.HandleToDeviceEvent)
+	ep.On(event.ToDeviceSecretRequest, mach.HandleToDeviceEvent)
 	ep.On(event.ToDeviceVerificationRequest, mach.HandleToDeviceEvent)
 	ep.On(event.ToDeviceVerificationStart, mach.HandleToDeviceEvent)
 	ep.On(event.ToDeviceVerificationAccept, mach.HandleToDeviceEvent)
@@ -242,16 +315,20 @@ func (mach *OlmMachine) AddAppserviceListener(ep ASEventProcessor) {
 	mach.Log.Debug().Msg("Added listeners for encryption data coming from appservice transactions")
 }
 
+// HandleDeviceLists marks the device lists of changed users as outdated and then fetches all outdated device lists.
 func (mach *OlmMachine) HandleDeviceLists(ctx context.Context, dl *mautrix.DeviceLists, since string) {
 	if len(dl.Changed) > 0 {
-		traceID := time.Now().Format("15:04:05.000000")
 		mach.Log.Debug().
-			Str("trace_id", traceID).
 			Interface("changes", dl.Changed).
 			Msg("Device list changes in /sync")
-		mach.FetchKeys(ctx, dl.Changed, false)
-		mach.Log.Debug().Str("trace_id", traceID).Msg("Finished handling device list changes")
+		err := mach.CryptoStore.MarkTrackedUsersOutdated(ctx, dl.Changed)
+		if err != nil {
+			mach.Log.Err(err).Msg("Failed to mark changed device lists as outdated")
+			mach.FetchKeys(ctx, dl.Changed, false)
+			return
+		}
 	}
+	_ = mach.UpdateOutdatedDeviceLists(ctx)
 }
 
 func (mach *OlmMachine) otkCountIsForCrossSigningKey(otkCount *mautrix.OTKCount) bool {
@@ -280,7 +357,7 @@ func (mach *OlmMachine) HandleOTKCounts(ctx context.Context, otkCount *mautrix.O
 	}
 
 	minCount := mach.account.Internal.MaxNumberOfOneTimeKeys() / 2
-	if otkCount.SignedCurve25519 < int(minCount) {
+	if otkCount.SignedCurve25519 < int(minCount) || mach.fallbackKeyNeedsUpdate(ctx) {
 		traceID := time.Now().Format("15:04:05.000000")
 		log := mach.Log.With().Str("trace_id", traceID).Logger()
 		ctx = log.WithContext(ctx)
@@ -314,8 +391,10 @@ func (mach *OlmMachine) ProcessSyncResponse(ctx context.Context, resp *mautrix.R
 		mach.HandleToDeviceEvent(ctx, evt)
 	}
 
+	mach.HandleUnusedFallbackKeyTypes(ctx, resp.FallbackKeys)
 	mach.HandleOTKCounts(ctx, &resp.DeviceOTKCount)
 	mach.MarkOlmHashSavePoint(ctx)
+	mach.maybePreShareGroupSessions()
 	return true
 }
 
@@ -539,7 +618,10 @@ func (mach *OlmMachine) SendEncryptedToDevice(ctx context.Context, device *id.De
 		return fmt.Errorf("didn't find created outbound session for device %s of %s", device.DeviceID, device.UserID)
 	}
 
-	encrypted := mach.encryptOlmEvent(ctx, olmSess, device, evtType, content)
+	encrypted, err := mach.encryptOlmEvent(ctx, olmSess, device, evtType, content)
+	if err != nil {
+		return err
+	}
 	encryptedContent := &event.Content{Parsed: &encrypted}
 
 	mach.machOrContextLog(ctx).Debug().
@@ -562,12 +644,14 @@ func (mach *OlmMachine) SendEncryptedToDevice(ctx context.Context, device *id.De
 	return err
 }
 
-func (mach *OlmMachine) createGroupSession(ctx context.Context, senderKey id.SenderKey, signingKey id.Ed25519, roomID id.RoomID, sessionID id.SessionID, sessionKey string, maxAge time.Duration, maxMessages int, isScheduled bool) error {
+func (mach *OlmMachine) createGroupSession(ctx context.Context, senderKey id.SenderKey, signingKey id.Ed25519, roomID id.RoomID, sessionID id.SessionID, sessionKey string, maxAge time.Duration, maxMessages int, isScheduled, sharedHistory bool) error {
 	log := zerolog.Ctx(ctx)
 	igs, err := NewInboundGroupSession(senderKey, signingKey, roomID, sessionKey, maxAge, maxMessages, isScheduled)
 	if err != nil {
 		return fmt.Errorf("failed to create inbound group session: %w", err)
-	} else if igs.ID() != sessionID {
+	}
+	igs.SharedHistory = sharedHistory
+	if igs.ID() != sessionID {
 		log.Warn().
 			Str("expected_session_id", sessionID.String()).
 			Str("actual_session_id", igs.ID().String()).
@@ -586,6 +670,7 @@ func (mach *OlmMachine) createGroupSession(ctx context.Context, senderKey id.Sen
 		Str("max_age", maxAge.String()).
 		Int("max_messages", maxMessages).
 		Bool("is_scheduled", isScheduled).
+		Bool("shared_history", sharedHistory).
 		Msg("Received inbound group session")
 	return nil
 }
@@ -675,16 +760,22 @@ func (mach *OlmMachine) receiveRoomKey(ctx context.Context, evt *DecryptedOlmEve
 				Msg("Redacted previous megolm sessions")
 		}
 	}
-	err = mach.createGroupSession(ctx, evt.SenderKey, evt.Keys.Ed25519, content.RoomID, content.SessionID, content.SessionKey, maxAge, maxMessages, content.IsScheduled)
+	err = mach.createGroupSession(ctx, evt.SenderKey, evt.Keys.Ed25519, content.RoomID, content.SessionID, content.SessionKey, maxAge, maxMessages, content.IsScheduled, content.SharedHistory)
 	if err != nil {
 		log.Err(err).Msg("Failed to create inbound group session")
 	}
 }
 
+// HandleRoomKeyWithheld stores the withheld code of a Megolm session, so that decrypting events
+// encrypted with that session fails with the withheld content as the error instead of NoSessionFound.
 func (mach *OlmMachine) HandleRoomKeyWithheld(ctx context.Context, content *event.RoomKeyWithheldEventContent) {
 	if content.Algorithm != id.AlgorithmMegolmV1 {
 		zerolog.Ctx(ctx).Debug().Interface("content", content).Msg("Non-megolm room key withheld event")
 		return
+	} else if content.SessionID == "" || content.RoomID == "" {
+		// m.no_olm is sent once per device rather than per session, so there's nothing to store
+		zerolog.Ctx(ctx).Debug().Interface("content", content).Msg("Room key withheld event without session")
+		return
 	}
 	// TODO log if there's a conflict? (currently ignored)
 	err := mach.CryptoStore.PutWithheldGroupSession(ctx, *content)
@@ -730,7 +821,11 @@ func (mach *OlmMachine) ShareKeys(ctx context.Context, currentOTKCount int) erro
 		log.Debug().Msg("Going to upload initial account keys")
 	}
 	oneTimeKeys := mach.account.getOneTimeKeys(mach.Client.UserID, mach.Client.DeviceID, currentOTKCount)
-	if len(oneTimeKeys) == 0 && deviceKeys == nil {
+	fallbackKeys, err := mach.prepareFallbackKeys()
+	if err != nil {
+		return fmt.Errorf("failed to generate fallback key: %w", err)
+	}
+	if len(oneTimeKeys) == 0 && len(fallbackKeys) == 0 && deviceKeys == nil {
 		log.Debug().Msg("No one-time keys nor device keys got when trying to share keys")
 		return nil
 	}
@@ -740,11 +835,15 @@ func (mach *OlmMachine) ShareKeys(ctx context.Context, currentOTKCount int) erro
 		return err
 	}
 	req := &mautrix.ReqUploadKeys{
-		DeviceKeys:  deviceKeys,
-		OneTimeKeys: oneTimeKeys,
+		DeviceKeys:   deviceKeys,
+		OneTimeKeys:  oneTimeKeys,
+		FallbackKeys: fallbackKeys,
 	}
-	log.Debug().Int("count", len(oneTimeKeys)).Msg("Uploading one-time keys")
-	_, err := mach.Client.UploadKeys(ctx, req)
+	log.Debug().
+		Int("count", len(oneTimeKeys)).
+		Int("fallback_count", len(fallbackKeys)).
+		Msg("Uploading one-time keys")
+	_, err = mach.Client.UploadKeys(ctx, req)
 	if err != nil {
 		return err
 	}
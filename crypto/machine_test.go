@@ -9,6 +9,7 @@ package crypto
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -66,6 +67,79 @@ func TestRatchetMegolmSession(t *testing.T) {
 	assert.Equal(t, uint32(10), inSess.Internal.FirstKnownIndex())
 }
 
+func TestMegolmRotationOverride(t *testing.T) {
+	mach := newMachine(t, "user1")
+	outSess, err := mach.newOutboundGroupSession(context.TODO(), "meow")
+	require.NoError(t, err)
+	// mockStateStore returns an encryption event with a rotation period of 3 messages
+	assert.Equal(t, 3, outSess.MaxMessages)
+
+	err = mach.SetMegolmRotationOverride(context.TODO(), "meow", &MegolmRotationOverride{MaxMessages: 1, MaxAge: time.Hour})
+	require.NoError(t, err)
+	outSess, err = mach.newOutboundGroupSession(context.TODO(), "meow")
+	require.NoError(t, err)
+	assert.Equal(t, 1, outSess.MaxMessages)
+	assert.Equal(t, time.Hour, outSess.MaxAge)
+
+	// Overrides can't loosen the room's rotation settings
+	err = mach.SetMegolmRotationOverride(context.TODO(), "meow", &MegolmRotationOverride{MaxMessages: 1000, MaxAge: 365 * 24 * time.Hour})
+	require.NoError(t, err)
+	outSess, err = mach.newOutboundGroupSession(context.TODO(), "meow")
+	require.NoError(t, err)
+	assert.Equal(t, 3, outSess.MaxMessages)
+	assert.Equal(t, 7*24*time.Hour, outSess.MaxAge)
+
+	err = mach.SetMegolmRotationOverride(context.TODO(), "meow", nil)
+	require.NoError(t, err)
+	outSess, err = mach.newOutboundGroupSession(context.TODO(), "meow")
+	require.NoError(t, err)
+	assert.Equal(t, 3, outSess.MaxMessages)
+}
+
+func TestGroupSessionSharedWithAll(t *testing.T) {
+	ctx := context.Background()
+	mach := newMachine(t, "@user1:example.com")
+	outSess, err := mach.newOutboundGroupSession(ctx, "meow")
+	require.NoError(t, err)
+	outSess.Users[UserDevice{UserID: "@user2:example.com", DeviceID: "A"}] = OGSAlreadyShared
+	require.NoError(t, mach.CryptoStore.PutDevices(ctx, "@user2:example.com", map[id.DeviceID]*id.Device{
+		"A": {UserID: "@user2:example.com", DeviceID: "A"},
+	}))
+
+	sharedWithAll, err := mach.isSharedWithAll(ctx, outSess, []id.UserID{"@user2:example.com"})
+	require.NoError(t, err)
+	assert.True(t, sharedWithAll)
+	// Users whose devices haven't been fetched yet need the session
+	sharedWithAll, err = mach.isSharedWithAll(ctx, outSess, []id.UserID{"@user2:example.com", "@user3:example.com"})
+	require.NoError(t, err)
+	assert.False(t, sharedWithAll)
+	// As do new devices of existing users
+	require.NoError(t, mach.CryptoStore.PutDevices(ctx, "@user2:example.com", map[id.DeviceID]*id.Device{
+		"A": {UserID: "@user2:example.com", DeviceID: "A"},
+		"B": {UserID: "@user2:example.com", DeviceID: "B"},
+	}))
+	sharedWithAll, err = mach.isSharedWithAll(ctx, outSess, []id.UserID{"@user2:example.com"})
+	require.NoError(t, err)
+	assert.False(t, sharedWithAll)
+}
+
+func TestMegolmEncryptLockPerRoom(t *testing.T) {
+	mach := newMachine(t, "@user1:example.com")
+	unlockRoom1 := mach.lockMegolmEncrypt("!room1:example.com")
+	locked := make(chan struct{})
+	go func() {
+		mach.lockMegolmEncrypt("!room2:example.com")()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Locking another room was blocked")
+	}
+	unlockRoom1()
+	assert.Empty(t, mach.megolmEncryptLocks)
+}
+
 func TestOlmMachineOlmMegolmSessions(t *testing.T) {
 	machineOut := newMachine(t, "user1")
 	machineIn := newMachine(t, "user2")
@@ -110,7 +184,8 @@ func TestOlmMachineOlmMegolmSessions(t *testing.T) {
 		SigningKey:  machineIn.account.SigningKey(),
 	}
 	wrapped := wrapSession(olmSession)
-	content := machineOut.encryptOlmEvent(context.TODO(), wrapped, deviceIdentity, event.ToDeviceRoomKey, megolmOutSession.ShareContent())
+	content, err := machineOut.encryptOlmEvent(context.TODO(), wrapped, deviceIdentity, event.ToDeviceRoomKey, megolmOutSession.ShareContent())
+	require.NoError(t, err)
 
 	senderKey := machineOut.account.IdentityKey()
 	signingKey := machineOut.account.SigningKey()
@@ -87,6 +87,58 @@ type Account interface {
 	RemoveOneTimeKeys(s Session) error
 }
 
+// KeyProvider performs operations with the identity keys of an Account. It can be used to keep
+// the private identity keys outside of process memory, e.g. in a TPM, Secure Enclave or HSM.
+type KeyProvider interface {
+	// IdentityKeys returns the public parts of the Ed25519 and Curve25519 identity keys.
+	IdentityKeys() (id.Ed25519, id.Curve25519, error)
+
+	// Sign returns the raw Ed25519 signature of a message using the identity key.
+	Sign(message []byte) ([]byte, error)
+
+	// SharedSecret returns the Curve25519 Diffie-Hellman shared secret of the identity key and
+	// the given raw public key.
+	SharedSecret(theirKey []byte) ([]byte, error)
+}
+
+// KeyProviderAccount is implemented by Account implementations that support delegating identity
+// key operations to a KeyProvider.
+type KeyProviderAccount interface {
+	Account
+
+	// SetKeyProvider makes the Account use the given KeyProvider for all identity key operations.
+	// If the Account already has identity keys, the public keys of the provider must match them.
+	//
+	// If the Account still has private identity keys, they are only wiped if migrate is true,
+	// otherwise ErrPrivateKeysPresent is returned. The keys must have been imported into the
+	// provider before migrating, as they can't be recovered afterwards.
+	SetKeyProvider(provider KeyProvider, migrate bool) error
+
+	// NeedsKeyProvider returns true if the private identity keys of the Account were removed
+	// when migrating to a KeyProvider, but no provider has been set since loading the Account.
+	NeedsKeyProvider() bool
+}
+
+// FallbackKeyAccount is implemented by Account implementations that support fallback keys.
+type FallbackKeyAccount interface {
+	Account
+
+	// GenFallbackKey generates a new fallback key. The previous fallback key stays valid
+	// until ForgetOldFallbackKey is called.
+	GenFallbackKey() error
+
+	// FallbackKeyUnpublished returns the public part of the current fallback key if it hasn't
+	// been marked as published yet. The returned map is from key ID to Curve25519 key.
+	FallbackKeyUnpublished() map[string]id.Curve25519
+
+	// ForgetOldFallbackKey removes the previous fallback key.
+	ForgetOldFallbackKey()
+
+	// IsCurrentFallbackKeySession returns true if the given inbound session was created using
+	// the current fallback key.
+	IsCurrentFallbackKeySession(s Session) bool
+}
+
 var InitBlankAccount func() Account
 var InitNewAccount func() (Account, error)
 var InitNewAccountFromPickled func(pickled, key []byte) (Account, error)
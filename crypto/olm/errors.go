@@ -28,6 +28,10 @@ var (
 	ErrWrongPickleVersion   = errors.New("wrong pickle version")
 	ErrInputToSmall         = errors.New("input too small (truncated?)")
 	ErrOverflow             = errors.New("overflow")
+	ErrKeyProviderMismatch  = errors.New("key provider identity keys don't match account")
+	ErrWiped                = errors.New("key material has been wiped")
+	ErrKeyProviderRequired  = errors.New("account identity keys are held by a key provider, but no provider is set")
+	ErrPrivateKeysPresent   = errors.New("account has private identity keys, which are only removed when explicitly migrating to a key provider")
 )
 
 // Error codes from go-olm
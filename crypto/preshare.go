@@ -0,0 +1,81 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package crypto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// GroupSessionPreSharing configures sharing outbound group sessions in the background, so that
+// sending the first message in a room doesn't have to wait for claiming one-time keys and
+// sending the session to every device.
+type GroupSessionPreSharing struct {
+	// GetRooms returns the rooms whose group sessions should be pre-shared (e.g. recently active rooms)
+	// along with the users to share each session with.
+	GetRooms func(ctx context.Context) (map[id.RoomID][]id.UserID, error)
+	// Interval is the minimum time between automatic pre-sharing runs after syncs.
+	Interval time.Duration
+}
+
+// PreShareGroupSessions creates and shares outbound group sessions for the rooms returned by
+// GroupSessionPreSharing.GetRooms. Rooms whose current session has already been shared with all
+// devices of the returned users are skipped, while users who joined later receive the existing session.
+//
+// This is called automatically in the background after syncs when GroupSessionPreSharing is set,
+// but it can also be called manually, e.g. right after startup.
+func (mach *OlmMachine) PreShareGroupSessions(ctx context.Context) error {
+	if mach.GroupSessionPreSharing == nil || mach.GroupSessionPreSharing.GetRooms == nil {
+		return nil
+	}
+	rooms, err := mach.GroupSessionPreSharing.GetRooms(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get rooms to pre-share group sessions in: %w", err)
+	}
+	log := mach.machOrContextLog(ctx)
+	sharedCount := 0
+	for roomID, users := range rooms {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err = mach.ShareGroupSession(ctx, roomID, users)
+		if errors.Is(err, AlreadyShared) {
+			continue
+		} else if err != nil {
+			log.Warn().Err(err).Stringer("room_id", roomID).Msg("Failed to pre-share group session")
+		} else {
+			sharedCount++
+		}
+	}
+	if sharedCount > 0 {
+		log.Debug().Int("room_count", sharedCount).Msg("Pre-shared group sessions")
+	}
+	return nil
+}
+
+// maybePreShareGroupSessions starts a background pre-sharing run if pre-sharing is enabled,
+// another run isn't already in progress and the configured interval has passed.
+func (mach *OlmMachine) maybePreShareGroupSessions() {
+	if mach.GroupSessionPreSharing == nil || !mach.preShareRunning.CompareAndSwap(false, true) {
+		return
+	} else if time.Since(mach.lastPreShare) < mach.GroupSessionPreSharing.Interval {
+		mach.preShareRunning.Store(false)
+		return
+	}
+	mach.lastPreShare = time.Now()
+	go func() {
+		defer mach.preShareRunning.Store(false)
+		err := mach.PreShareGroupSessions(mach.BackgroundCtx)
+		if err != nil {
+			mach.Log.Err(err).Msg("Failed to pre-share group sessions")
+		}
+	}()
+}
@@ -8,6 +8,7 @@ package crypto
 
 import (
 	"errors"
+	"slices"
 	"time"
 
 	"maunium.net/go/mautrix/crypto/olm"
@@ -54,6 +55,19 @@ func (session *OlmSession) Describe() string {
 	return session.Internal.Describe()
 }
 
+// wipeInternal zeroes the key material of an olm object if the implementation supports it
+// (currently only goolm does). Wiped objects return olm.ErrWiped instead of using zeroed keys.
+func wipeInternal(internal any) {
+	if wiper, ok := internal.(interface{ Wipe() }); ok {
+		wiper.Wipe()
+	}
+}
+
+// Wipe zeroes the key material of the session. The session can't be used after this.
+func (session *OlmSession) Wipe() {
+	wipeInternal(session.Internal)
+}
+
 func wrapSession(session olm.Session) *OlmSession {
 	return &OlmSession{
 		Internal: session,
@@ -72,6 +86,9 @@ func (account *OlmAccount) NewInboundSessionFrom(senderKey id.Curve25519, cipher
 	if err != nil {
 		return nil, err
 	}
+	if fbAccount, ok := account.Internal.(olm.FallbackKeyAccount); ok && fbAccount.IsCurrentFallbackKeySession(session) {
+		account.FallbackKeyUses++
+	}
 	_ = account.Internal.RemoveOneTimeKeys(session)
 	return wrapSession(session), nil
 }
@@ -95,6 +112,12 @@ type RatchetSafety struct {
 	LostIndices   []uint `json:"lost_indices,omitempty"`
 }
 
+func (rs RatchetSafety) clone() RatchetSafety {
+	rs.MissedIndices = slices.Clone(rs.MissedIndices)
+	rs.LostIndices = slices.Clone(rs.LostIndices)
+	return rs
+}
+
 type InboundGroupSession struct {
 	Internal olm.InboundGroupSession
 
@@ -110,6 +133,12 @@ type InboundGroupSession struct {
 	MaxMessages      int
 	IsScheduled      bool
 	KeyBackupVersion id.KeyBackupVersion
+	// SharedHistory is true if the session can be shared with users invited to the room later (MSC3061).
+	// Only sessions received directly from the sender (i.e. without a forwarding chain) are shared onwards.
+	SharedHistory bool
+	// ForwarderTrust is the trust level of the device that forwarded the session, resolved when the
+	// forwarded session was imported. It's unset for sessions that weren't received as forwards.
+	ForwarderTrust id.TrustState
 
 	id id.SessionID
 }
@@ -132,6 +161,11 @@ func NewInboundGroupSession(senderKey id.SenderKey, signingKey id.Ed25519, roomI
 	}, nil
 }
 
+// Wipe zeroes the key material of the session. The session can't be used after this.
+func (igs *InboundGroupSession) Wipe() {
+	wipeInternal(igs.Internal)
+}
+
 func (igs *InboundGroupSession) ID() id.SessionID {
 	if igs.id == "" {
 		igs.id = igs.Internal.ID()
@@ -175,6 +209,9 @@ type OutboundGroupSession struct {
 	Users  map[UserDevice]OGSState
 	RoomID id.RoomID
 	Shared bool
+	// SharedHistory is true if the room's history is visible to new members,
+	// which means the session can be shared with users invited later (MSC3061).
+	SharedHistory bool
 
 	id      id.SessionID
 	content *event.RoomKeyEventContent
@@ -213,6 +250,23 @@ func NewOutboundGroupSession(roomID id.RoomID, encryptionContent *event.Encrypti
 	return ogs, nil
 }
 
+// MegolmRotationOverride overrides the rotation settings of outbound Megolm sessions in a room.
+// Zero values mean the corresponding setting from the room's m.room.encryption event is used.
+// Overrides can only make rotation stricter: values looser than the room's settings are ignored.
+type MegolmRotationOverride struct {
+	MaxAge      time.Duration
+	MaxMessages int
+}
+
+func (ogs *OutboundGroupSession) applyRotationOverride(override *MegolmRotationOverride) {
+	if override.MaxAge > 0 {
+		ogs.MaxAge = min(ogs.MaxAge, override.MaxAge)
+	}
+	if override.MaxMessages > 0 {
+		ogs.MaxMessages = min(ogs.MaxMessages, override.MaxMessages)
+	}
+}
+
 func (ogs *OutboundGroupSession) ShareContent() event.Content {
 	if ogs.content == nil {
 		ogs.content = &event.RoomKeyEventContent{
@@ -220,11 +274,18 @@ func (ogs *OutboundGroupSession) ShareContent() event.Content {
 			RoomID:     ogs.RoomID,
 			SessionID:  ogs.ID(),
 			SessionKey: ogs.Internal.Key(),
+
+			SharedHistory: ogs.SharedHistory,
 		}
 	}
 	return event.Content{Parsed: ogs.content}
 }
 
+// Wipe zeroes the key material of the session. The session can't be used after this.
+func (ogs *OutboundGroupSession) Wipe() {
+	wipeInternal(ogs.Internal)
+}
+
 func (ogs *OutboundGroupSession) ID() id.SessionID {
 	if ogs.id == "" {
 		ogs.id = ogs.Internal.ID()
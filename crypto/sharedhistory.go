@@ -0,0 +1,108 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package crypto
+
+import (
+	"context"
+	"fmt"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func (mach *OlmMachine) getSharedHistoryRecipients(ctx context.Context, userID id.UserID) ([]*id.Device, error) {
+	devices, err := mach.CryptoStore.GetDevices(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get devices of %s: %w", userID, err)
+	} else if devices == nil {
+		keys, err := mach.FetchKeys(ctx, []id.UserID{userID}, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch devices of %s: %w", userID, err)
+		}
+		devices = keys[userID]
+	}
+	recipients := make([]*id.Device, 0, len(devices))
+	for _, device := range devices {
+		if userID == mach.Client.UserID && device.DeviceID == mach.Client.DeviceID {
+			continue
+		} else if device.Trust == id.TrustStateBlacklisted || mach.ResolveTrust(device) < mach.SendKeysMinTrust {
+			continue
+		}
+		recipients = append(recipients, device)
+	}
+	return recipients, nil
+}
+
+// ShareHistoricalRoomKeys forwards the inbound group sessions of a room that are marked as
+// shared history to all devices of the given user (MSC3061).
+//
+// This should be called after inviting a user to a room with shared or world-readable history
+// visibility. Only sessions created while the room's history was shared are forwarded, which
+// requires the state store to implement [HistoryVisibilityStateStore]. Sessions that were
+// themselves received as forwards aren't shared again.
+func (mach *OlmMachine) ShareHistoricalRoomKeys(ctx context.Context, roomID id.RoomID, userID id.UserID) error {
+	log := mach.machOrContextLog(ctx).With().
+		Str("action", "share historical room keys").
+		Stringer("room_id", roomID).
+		Stringer("target_user_id", userID).
+		Logger()
+	ctx = log.WithContext(ctx)
+	sessions, err := mach.CryptoStore.GetGroupSessionsForRoom(ctx, roomID).AsList()
+	if err != nil {
+		return fmt.Errorf("failed to get group sessions: %w", err)
+	}
+	var contents []*event.ForwardedRoomKeyEventContent
+	for _, igs := range sessions {
+		// Sessions that were forwarded to us are never forwarded onwards, as the shared history flag
+		// of forwarded keys is only the forwarder's claim rather than the original sender's.
+		if !igs.SharedHistory || len(igs.ForwardingChains) > 0 {
+			continue
+		}
+		exportedKey, err := igs.Internal.Export(igs.Internal.FirstKnownIndex())
+		if err != nil {
+			log.Err(err).Stringer("session_id", igs.ID()).Msg("Failed to export group session")
+			continue
+		}
+		contents = append(contents, &event.ForwardedRoomKeyEventContent{
+			RoomKeyEventContent: event.RoomKeyEventContent{
+				Algorithm:     id.AlgorithmMegolmV1,
+				RoomID:        igs.RoomID,
+				SessionID:     igs.ID(),
+				SessionKey:    string(exportedKey),
+				SharedHistory: true,
+			},
+			SenderKey:          igs.SenderKey,
+			ForwardingKeyChain: []string{},
+			SenderClaimedKey:   igs.SigningKey,
+		})
+	}
+	if len(contents) == 0 {
+		log.Debug().Msg("No shared history sessions to forward")
+		return nil
+	}
+	devices, err := mach.getSharedHistoryRecipients(ctx, userID)
+	if err != nil {
+		return err
+	}
+	log.Debug().
+		Int("session_count", len(contents)).
+		Int("device_count", len(devices)).
+		Msg("Forwarding shared history sessions")
+	for _, device := range devices {
+		for _, content := range contents {
+			err = mach.SendEncryptedToDevice(ctx, device, event.ToDeviceForwardedRoomKey, event.Content{Parsed: content})
+			if err != nil {
+				log.Err(err).
+					Stringer("target_device_id", device.DeviceID).
+					Stringer("session_id", content.SessionID).
+					Msg("Failed to forward shared history session")
+				break
+			}
+		}
+	}
+	return nil
+}
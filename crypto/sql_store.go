@@ -13,6 +13,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -43,9 +44,30 @@ type SQLCryptoStore struct {
 	PickleKey []byte
 	Account   *OlmAccount
 
-	olmSessionCache     map[id.SenderKey]map[id.SessionID]*OlmSession
+	// The maximum number of sender keys whose olm sessions are kept in memory.
+	// Changes only take effect when InitFields is called.
+	OlmSessionCacheSize int
+	// The maximum number of inbound megolm sessions to keep in memory.
+	// Changes only take effect when InitFields is called.
+	GroupSessionCacheSize int
+
+	// Evicted olm sessions are not wiped: callers like the megolm sharing code keep using the
+	// returned pointers outside the store locks, so they're left for the garbage collector.
+	olmSessionCache     *lruCache[id.SenderKey, map[id.SessionID]*OlmSession]
 	olmSessionCacheLock sync.Mutex
-}
+	// Inbound group sessions are cached pickled and every read returns a separately unpickled copy,
+	// so callers never share the ratchet state of a session.
+	groupSessionCache     *lruCache[id.SessionID, *cachedGroupSession]
+	groupSessionCacheLock sync.Mutex
+	// groupSessionGeneration is incremented whenever group sessions are stored or redacted, so that sessions
+	// read from the database concurrently aren't cached if they may be outdated.
+	groupSessionGeneration uint64
+}
+
+const (
+	DefaultOlmSessionCacheSize   = 1024
+	DefaultGroupSessionCacheSize = 4096
+)
 
 var _ Store = (*SQLCryptoStore)(nil)
 
@@ -57,13 +79,42 @@ func NewSQLCryptoStore(db *dbutil.Database, log dbutil.DatabaseLogger, accountID
 		PickleKey: pickleKey,
 		AccountID: accountID,
 		DeviceID:  deviceID,
+
+		OlmSessionCacheSize:   DefaultOlmSessionCacheSize,
+		GroupSessionCacheSize: DefaultGroupSessionCacheSize,
 	}
 	store.InitFields()
 	return store
 }
 
 func (store *SQLCryptoStore) InitFields() {
-	store.olmSessionCache = make(map[id.SenderKey]map[id.SessionID]*OlmSession)
+	store.olmSessionCache = newLRUCache[id.SenderKey, map[id.SessionID]*OlmSession](store.OlmSessionCacheSize)
+	store.groupSessionCache = newLRUCache[id.SessionID, *cachedGroupSession](store.GroupSessionCacheSize)
+}
+
+// WipeCaches wipes and removes all cached sessions and the cached account from memory.
+// The data in the database is not affected, but olm sessions previously returned by the store can't be used anymore.
+// This is meant to be called when shutting down.
+func (store *SQLCryptoStore) WipeCaches() {
+	store.olmSessionCacheLock.Lock()
+	for _, sessions := range store.olmSessionCache.Values() {
+		for _, sess := range sessions {
+			sess.Wipe()
+		}
+	}
+	store.olmSessionCache = newLRUCache[id.SenderKey, map[id.SessionID]*OlmSession](store.OlmSessionCacheSize)
+	store.olmSessionCacheLock.Unlock()
+	store.groupSessionCacheLock.Lock()
+	for _, cached := range store.groupSessionCache.Values() {
+		cached.wipe()
+	}
+	store.groupSessionCache = newLRUCache[id.SessionID, *cachedGroupSession](store.GroupSessionCacheSize)
+	store.groupSessionGeneration++
+	store.groupSessionCacheLock.Unlock()
+	if store.Account != nil {
+		store.Account.Wipe()
+		store.Account = nil
+	}
 }
 
 // Flush does nothing for this implementation as data is already persisted in the database.
@@ -132,26 +183,43 @@ func (store *SQLCryptoStore) PutAccount(ctx context.Context, account *OlmAccount
 		return err
 	}
 	_, err = store.DB.Exec(ctx, `
-		INSERT INTO crypto_account (device_id, shared, sync_token, account, account_id, key_backup_version) VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO crypto_account (
+			device_id, shared, sync_token, account, account_id, key_backup_version,
+			fallback_key_created_at, fallback_key_uses, prev_fallback_key_expires_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		ON CONFLICT (account_id) DO UPDATE SET shared=excluded.shared, sync_token=excluded.sync_token,
 											   account=excluded.account, account_id=excluded.account_id,
-											   key_backup_version=excluded.key_backup_version
-	`, store.DeviceID, account.Shared, store.SyncToken, bytes, store.AccountID, account.KeyBackupVersion)
+											   key_backup_version=excluded.key_backup_version,
+											   fallback_key_created_at=excluded.fallback_key_created_at,
+											   fallback_key_uses=excluded.fallback_key_uses,
+											   prev_fallback_key_expires_at=excluded.prev_fallback_key_expires_at
+	`, store.DeviceID, account.Shared, store.SyncToken, bytes, store.AccountID, account.KeyBackupVersion,
+		unixMilliOrZero(account.FallbackKeyCreatedAt), account.FallbackKeyUses, unixMilliOrZero(account.PrevFallbackKeyExpiresAt))
 	return err
 }
 
 // GetAccount retrieves an OlmAccount from the database.
 func (store *SQLCryptoStore) GetAccount(ctx context.Context) (*OlmAccount, error) {
 	if store.Account == nil {
-		row := store.DB.QueryRow(ctx, "SELECT shared, sync_token, account, key_backup_version FROM crypto_account WHERE account_id=$1", store.AccountID)
+		row := store.DB.QueryRow(ctx, `
+			SELECT shared, sync_token, account, key_backup_version,
+			       fallback_key_created_at, fallback_key_uses, prev_fallback_key_expires_at
+			FROM crypto_account WHERE account_id=$1
+		`, store.AccountID)
 		acc := &OlmAccount{Internal: olm.NewBlankAccount()}
 		var accountBytes []byte
-		err := row.Scan(&acc.Shared, &store.SyncToken, &accountBytes, &acc.KeyBackupVersion)
+		var fallbackKeyCreatedAt, prevFallbackKeyExpiresAt int64
+		err := row.Scan(
+			&acc.Shared, &store.SyncToken, &accountBytes, &acc.KeyBackupVersion,
+			&fallbackKeyCreatedAt, &acc.FallbackKeyUses, &prevFallbackKeyExpiresAt,
+		)
 		if err == sql.ErrNoRows {
 			return nil, nil
 		} else if err != nil {
 			return nil, err
 		}
+		acc.FallbackKeyCreatedAt = timeFromUnixMilliOrZero(fallbackKeyCreatedAt)
+		acc.PrevFallbackKeyExpiresAt = timeFromUnixMilliOrZero(prevFallbackKeyExpiresAt)
 		err = acc.Internal.Unpickle(accountBytes, store.PickleKey)
 		if err != nil {
 			return nil, err
@@ -164,7 +232,7 @@ func (store *SQLCryptoStore) GetAccount(ctx context.Context) (*OlmAccount, error
 // HasSession returns whether there is an Olm session for the given sender key.
 func (store *SQLCryptoStore) HasSession(ctx context.Context, key id.SenderKey) bool {
 	store.olmSessionCacheLock.Lock()
-	cache, ok := store.olmSessionCache[key]
+	cache, ok := store.olmSessionCache.Get(key)
 	store.olmSessionCacheLock.Unlock()
 	if ok && len(cache) > 0 {
 		return true
@@ -211,10 +279,10 @@ func (store *SQLCryptoStore) GetSessions(ctx context.Context, key id.SenderKey)
 }
 
 func (store *SQLCryptoStore) getOlmSessionCache(key id.SenderKey) map[id.SessionID]*OlmSession {
-	data, ok := store.olmSessionCache[key]
+	data, ok := store.olmSessionCache.Get(key)
 	if !ok {
 		data = make(map[id.SessionID]*OlmSession)
-		store.olmSessionCache[key] = data
+		store.olmSessionCache.Put(key, data)
 	}
 	return data
 }
@@ -284,8 +352,16 @@ func (store *SQLCryptoStore) UpdateSession(ctx context.Context, _ id.SenderKey,
 	return err
 }
 
-func (store *SQLCryptoStore) DeleteSession(ctx context.Context, _ id.SenderKey, session *OlmSession) error {
+func (store *SQLCryptoStore) DeleteSession(ctx context.Context, key id.SenderKey, session *OlmSession) error {
 	_, err := store.DB.Exec(ctx, "DELETE FROM crypto_olm_session WHERE session_id=$1 AND account_id=$2", session.ID(), store.AccountID)
+	store.olmSessionCacheLock.Lock()
+	if cache, ok := store.olmSessionCache.Get(key); ok {
+		delete(cache, session.ID())
+	}
+	store.olmSessionCacheLock.Unlock()
+	if err == nil {
+		session.Wipe()
+	}
 	return err
 }
 
@@ -319,6 +395,20 @@ func datePtr(t time.Time) *time.Time {
 	return &t
 }
 
+func unixMilliOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+func timeFromUnixMilliOrZero(ts int64) time.Time {
+	if ts == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ts)
+}
+
 // PutGroupSession stores an inbound Megolm group session for a room, sender and session.
 func (store *SQLCryptoStore) PutGroupSession(ctx context.Context, session *InboundGroupSession) error {
 	sessionBytes, err := session.Internal.Pickle(store.PickleKey)
@@ -345,36 +435,141 @@ func (store *SQLCryptoStore) PutGroupSession(ctx context.Context, session *Inbou
 	_, err = store.DB.Exec(ctx, `
 		INSERT INTO crypto_megolm_inbound_session (
 			session_id, sender_key, signing_key, room_id, session, forwarding_chains,
-			ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version, account_id
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version, shared_history, forwarder_trust, account_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
 		ON CONFLICT (session_id, account_id) DO UPDATE
 		    SET withheld_code=NULL, withheld_reason=NULL, sender_key=excluded.sender_key, signing_key=excluded.signing_key,
 		        room_id=excluded.room_id, session=excluded.session, forwarding_chains=excluded.forwarding_chains,
 		        ratchet_safety=excluded.ratchet_safety, received_at=excluded.received_at,
 		        max_age=excluded.max_age, max_messages=excluded.max_messages, is_scheduled=excluded.is_scheduled,
-		        key_backup_version=excluded.key_backup_version
+		        key_backup_version=excluded.key_backup_version, shared_history=excluded.shared_history,
+		        forwarder_trust=excluded.forwarder_trust
 	`,
 		session.ID(), session.SenderKey, session.SigningKey, session.RoomID, sessionBytes, forwardingChains,
 		ratchetSafety, datePtr(session.ReceivedAt), dbutil.NumPtr(session.MaxAge), dbutil.NumPtr(session.MaxMessages),
-		session.IsScheduled, session.KeyBackupVersion, store.AccountID,
+		session.IsScheduled, session.KeyBackupVersion, session.SharedHistory, session.ForwarderTrust, store.AccountID,
 	)
+	store.groupSessionCacheLock.Lock()
+	store.groupSessionGeneration++
+	if err == nil {
+		store.groupSessionCache.Put(session.ID(), newCachedGroupSession(session, sessionBytes))
+	} else {
+		store.groupSessionCache.Remove(session.ID())
+	}
+	store.groupSessionCacheLock.Unlock()
 	return err
 }
 
+// PutGroupSessions stores multiple inbound Megolm group sessions in a single transaction.
+func (store *SQLCryptoStore) PutGroupSessions(ctx context.Context, sessions []*InboundGroupSession) error {
+	err := store.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		for _, session := range sessions {
+			err := store.PutGroupSession(ctx, session)
+			if err != nil {
+				return fmt.Errorf("failed to store session %s: %w", session.ID(), err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// The transaction was rolled back, so don't keep any of the sessions in the cache
+		sessionIDs := make([]id.SessionID, len(sessions))
+		for i, session := range sessions {
+			sessionIDs[i] = session.ID()
+		}
+		store.uncacheGroupSessions(sessionIDs...)
+	}
+	return err
+}
+
+// cachedGroupSession is an inbound group session in the cache. The metadata is kept as a session
+// without Internal, and the session itself as a pickle.
+type cachedGroupSession struct {
+	session InboundGroupSession
+	pickled []byte
+}
+
+func newCachedGroupSession(sess *InboundGroupSession, pickled []byte) *cachedGroupSession {
+	meta := *sess
+	meta.id = sess.ID()
+	meta.Internal = nil
+	meta.ForwardingChains = slices.Clone(sess.ForwardingChains)
+	meta.RatchetSafety = sess.RatchetSafety.clone()
+	return &cachedGroupSession{session: meta, pickled: pickled}
+}
+
+func (cgs *cachedGroupSession) wipe() {
+	clear(cgs.pickled)
+}
+
+// getCachedGroupSession returns a copy of the cached session with the given ID, or nil if it's not cached.
+// The current cache generation is returned too, which must be passed to cacheGroupSession if the session
+// is read from the database instead.
+func (store *SQLCryptoStore) getCachedGroupSession(roomID id.RoomID, sessionID id.SessionID) (*InboundGroupSession, uint64, error) {
+	store.groupSessionCacheLock.Lock()
+	cached, ok := store.groupSessionCache.Get(sessionID)
+	generation := store.groupSessionGeneration
+	if !ok || cached.session.RoomID != roomID {
+		store.groupSessionCacheLock.Unlock()
+		return nil, generation, nil
+	}
+	sess := cached.session
+	pickled := slices.Clone(cached.pickled)
+	store.groupSessionCacheLock.Unlock()
+	var err error
+	sess.Internal, err = olm.InboundGroupSessionFromPickled(pickled, store.PickleKey)
+	if err != nil {
+		return nil, generation, err
+	}
+	sess.ForwardingChains = slices.Clone(sess.ForwardingChains)
+	sess.RatchetSafety = sess.RatchetSafety.clone()
+	return &sess, generation, nil
+}
+
+// cacheGroupSession caches a session that was read from the database, unless sessions have been stored
+// or redacted since the given generation, in which case the read data may already be outdated.
+func (store *SQLCryptoStore) cacheGroupSession(sess *InboundGroupSession, pickled []byte, generation uint64) {
+	store.groupSessionCacheLock.Lock()
+	if store.groupSessionGeneration == generation {
+		store.groupSessionCache.Put(sess.ID(), newCachedGroupSession(sess, pickled))
+	}
+	store.groupSessionCacheLock.Unlock()
+}
+
+// uncacheGroupSessions removes the given sessions from the cache.
+func (store *SQLCryptoStore) uncacheGroupSessions(sessionIDs ...id.SessionID) {
+	store.groupSessionCacheLock.Lock()
+	store.groupSessionGeneration++
+	for _, sessionID := range sessionIDs {
+		if cached, ok := store.groupSessionCache.Get(sessionID); ok {
+			cached.wipe()
+		}
+		store.groupSessionCache.Remove(sessionID)
+	}
+	store.groupSessionCacheLock.Unlock()
+}
+
 // GetGroupSession retrieves an inbound Megolm group session for a room, sender and session.
+//
+// Every call returns a separate instance, so callers can use the session without coordinating with each other.
 func (store *SQLCryptoStore) GetGroupSession(ctx context.Context, roomID id.RoomID, sessionID id.SessionID) (*InboundGroupSession, error) {
+	cached, generation, err := store.getCachedGroupSession(roomID, sessionID)
+	if cached != nil || err != nil {
+		return cached, err
+	}
 	var senderKey, signingKey, forwardingChains, withheldCode, withheldReason sql.NullString
 	var sessionBytes, ratchetSafetyBytes []byte
 	var receivedAt sql.NullTime
 	var maxAge, maxMessages sql.NullInt64
-	var isScheduled bool
+	var isScheduled, sharedHistory bool
 	var version id.KeyBackupVersion
-	err := store.DB.QueryRow(ctx, `
-		SELECT sender_key, signing_key, session, forwarding_chains, withheld_code, withheld_reason, ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version
+	var forwarderTrust id.TrustState
+	err = store.DB.QueryRow(ctx, `
+		SELECT sender_key, signing_key, session, forwarding_chains, withheld_code, withheld_reason, ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version, shared_history, forwarder_trust
 		FROM crypto_megolm_inbound_session
 		WHERE room_id=$1 AND session_id=$2 AND account_id=$3`,
 		roomID, sessionID, store.AccountID,
-	).Scan(&senderKey, &signingKey, &sessionBytes, &forwardingChains, &withheldCode, &withheldReason, &ratchetSafetyBytes, &receivedAt, &maxAge, &maxMessages, &isScheduled, &version)
+	).Scan(&senderKey, &signingKey, &sessionBytes, &forwardingChains, &withheldCode, &withheldReason, &ratchetSafetyBytes, &receivedAt, &maxAge, &maxMessages, &isScheduled, &version, &sharedHistory, &forwarderTrust)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	} else if err != nil {
@@ -393,7 +588,7 @@ func (store *SQLCryptoStore) GetGroupSession(ctx context.Context, roomID id.Room
 	if err != nil {
 		return nil, err
 	}
-	return &InboundGroupSession{
+	sess := &InboundGroupSession{
 		Internal:         igs,
 		SigningKey:       id.Ed25519(signingKey.String),
 		SenderKey:        id.Curve25519(senderKey.String),
@@ -405,10 +600,15 @@ func (store *SQLCryptoStore) GetGroupSession(ctx context.Context, roomID id.Room
 		MaxMessages:      int(maxMessages.Int64),
 		IsScheduled:      isScheduled,
 		KeyBackupVersion: version,
-	}, nil
+		SharedHistory:    sharedHistory,
+		ForwarderTrust:   forwarderTrust,
+	}
+	store.cacheGroupSession(sess, sessionBytes, generation)
+	return sess, nil
 }
 
 func (store *SQLCryptoStore) RedactGroupSession(ctx context.Context, _ id.RoomID, sessionID id.SessionID, reason string) error {
+	defer store.uncacheGroupSessions(sessionID)
 	_, err := store.DB.Exec(ctx, `
 		UPDATE crypto_megolm_inbound_session
 		SET withheld_code=$1, withheld_reason=$2, session=NULL, forwarding_chains=NULL
@@ -431,7 +631,12 @@ func (store *SQLCryptoStore) RedactGroupSessions(ctx context.Context, roomID id.
 	if err != nil {
 		return nil, err
 	}
-	return dbutil.NewRowIter(res, dbutil.ScanSingleColumn[id.SessionID]).AsList()
+	return store.uncacheRedactedGroupSessions(dbutil.NewRowIter(res, dbutil.ScanSingleColumn[id.SessionID]).AsList())
+}
+
+func (store *SQLCryptoStore) uncacheRedactedGroupSessions(sessionIDs []id.SessionID, err error) ([]id.SessionID, error) {
+	store.uncacheGroupSessions(sessionIDs...)
+	return sessionIDs, err
 }
 
 func (store *SQLCryptoStore) RedactExpiredGroupSessions(ctx context.Context) ([]id.SessionID, error) {
@@ -462,7 +667,7 @@ func (store *SQLCryptoStore) RedactExpiredGroupSessions(ctx context.Context) ([]
 	if err != nil {
 		return nil, err
 	}
-	return dbutil.NewRowIter(res, dbutil.ScanSingleColumn[id.SessionID]).AsList()
+	return store.uncacheRedactedGroupSessions(dbutil.NewRowIter(res, dbutil.ScanSingleColumn[id.SessionID]).AsList())
 }
 
 func (store *SQLCryptoStore) RedactOutdatedGroupSessions(ctx context.Context) ([]id.SessionID, error) {
@@ -475,7 +680,7 @@ func (store *SQLCryptoStore) RedactOutdatedGroupSessions(ctx context.Context) ([
 	if err != nil {
 		return nil, err
 	}
-	return dbutil.NewRowIter(res, dbutil.ScanSingleColumn[id.SessionID]).AsList()
+	return store.uncacheRedactedGroupSessions(dbutil.NewRowIter(res, dbutil.ScanSingleColumn[id.SessionID]).AsList())
 }
 
 func (store *SQLCryptoStore) PutWithheldGroupSession(ctx context.Context, content event.RoomKeyWithheldEventContent) error {
@@ -529,20 +734,26 @@ func (store *SQLCryptoStore) postScanInboundGroupSession(sessionBytes, ratchetSa
 }
 
 func (store *SQLCryptoStore) scanInboundGroupSession(rows dbutil.Scannable) (*InboundGroupSession, error) {
+	sess, _, err := store.scanInboundGroupSessionWithPickle(rows)
+	return sess, err
+}
+
+func (store *SQLCryptoStore) scanInboundGroupSessionWithPickle(rows dbutil.Scannable) (*InboundGroupSession, []byte, error) {
 	var roomID id.RoomID
 	var signingKey, senderKey, forwardingChains sql.NullString
 	var sessionBytes, ratchetSafetyBytes []byte
 	var receivedAt sql.NullTime
 	var maxAge, maxMessages sql.NullInt64
-	var isScheduled bool
+	var isScheduled, sharedHistory bool
 	var version id.KeyBackupVersion
-	err := rows.Scan(&roomID, &senderKey, &signingKey, &sessionBytes, &forwardingChains, &ratchetSafetyBytes, &receivedAt, &maxAge, &maxMessages, &isScheduled, &version)
+	var forwarderTrust id.TrustState
+	err := rows.Scan(&roomID, &senderKey, &signingKey, &sessionBytes, &forwardingChains, &ratchetSafetyBytes, &receivedAt, &maxAge, &maxMessages, &isScheduled, &version, &sharedHistory, &forwarderTrust)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	igs, chains, rs, err := store.postScanInboundGroupSession(sessionBytes, ratchetSafetyBytes, forwardingChains.String)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	return &InboundGroupSession{
 		Internal:         igs,
@@ -556,21 +767,61 @@ func (store *SQLCryptoStore) scanInboundGroupSession(rows dbutil.Scannable) (*In
 		MaxMessages:      int(maxMessages.Int64),
 		IsScheduled:      isScheduled,
 		KeyBackupVersion: version,
-	}, nil
+		SharedHistory:    sharedHistory,
+		ForwarderTrust:   forwarderTrust,
+	}, sessionBytes, nil
 }
 
 func (store *SQLCryptoStore) GetGroupSessionsForRoom(ctx context.Context, roomID id.RoomID) dbutil.RowIter[*InboundGroupSession] {
 	rows, err := store.DB.Query(ctx, `
-		SELECT room_id, sender_key, signing_key, session, forwarding_chains, ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version
+		SELECT room_id, sender_key, signing_key, session, forwarding_chains, ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version, shared_history, forwarder_trust
 		FROM crypto_megolm_inbound_session WHERE room_id=$1 AND account_id=$2 AND session IS NOT NULL`,
 		roomID, store.AccountID,
 	)
 	return dbutil.NewRowIterWithError(rows, store.scanInboundGroupSession, err)
 }
 
+// GetGroupSessionsForRooms returns the inbound Megolm sessions of multiple rooms with a single query.
+// The returned sessions are also added to the in-memory cache, so this can be used to preload sessions
+// before decrypting a batch of events.
+func (store *SQLCryptoStore) GetGroupSessionsForRooms(ctx context.Context, roomIDs []id.RoomID) dbutil.RowIter[*InboundGroupSession] {
+	var rows dbutil.Rows
+	var err error
+	store.groupSessionCacheLock.Lock()
+	generation := store.groupSessionGeneration
+	store.groupSessionCacheLock.Unlock()
+	if store.DB.Dialect == dbutil.Postgres && PostgresArrayWrapper != nil {
+		rows, err = store.DB.Query(ctx, `
+			SELECT room_id, sender_key, signing_key, session, forwarding_chains, ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version, shared_history, forwarder_trust
+			FROM crypto_megolm_inbound_session WHERE room_id = ANY($1) AND account_id=$2 AND session IS NOT NULL`,
+			PostgresArrayWrapper(roomIDs), store.AccountID,
+		)
+	} else {
+		placeholders := make([]string, len(roomIDs))
+		params := make([]any, len(roomIDs)+1)
+		params[0] = store.AccountID
+		for i, roomID := range roomIDs {
+			placeholders[i] = fmt.Sprintf("$%d", i+2)
+			params[i+1] = roomID
+		}
+		rows, err = store.DB.Query(ctx, `
+			SELECT room_id, sender_key, signing_key, session, forwarding_chains, ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version, shared_history, forwarder_trust
+			FROM crypto_megolm_inbound_session WHERE room_id IN (`+strings.Join(placeholders, ",")+`) AND account_id=$1 AND session IS NOT NULL`,
+			params...,
+		)
+	}
+	return dbutil.NewRowIterWithError(rows, func(row dbutil.Scannable) (*InboundGroupSession, error) {
+		sess, pickled, err := store.scanInboundGroupSessionWithPickle(row)
+		if err == nil {
+			store.cacheGroupSession(sess, pickled, generation)
+		}
+		return sess, err
+	}, err)
+}
+
 func (store *SQLCryptoStore) GetAllGroupSessions(ctx context.Context) dbutil.RowIter[*InboundGroupSession] {
 	rows, err := store.DB.Query(ctx, `
-		SELECT room_id, sender_key, signing_key, session, forwarding_chains, ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version
+		SELECT room_id, sender_key, signing_key, session, forwarding_chains, ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version, shared_history, forwarder_trust
 		FROM crypto_megolm_inbound_session WHERE account_id=$1 AND session IS NOT NULL`,
 		store.AccountID,
 	)
@@ -579,7 +830,7 @@ func (store *SQLCryptoStore) GetAllGroupSessions(ctx context.Context) dbutil.Row
 
 func (store *SQLCryptoStore) GetGroupSessionsWithoutKeyBackupVersion(ctx context.Context, version id.KeyBackupVersion) dbutil.RowIter[*InboundGroupSession] {
 	rows, err := store.DB.Query(ctx, `
-		SELECT room_id, sender_key, signing_key, session, forwarding_chains, ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version
+		SELECT room_id, sender_key, signing_key, session, forwarding_chains, ratchet_safety, received_at, max_age, max_messages, is_scheduled, key_backup_version, shared_history, forwarder_trust
 		FROM crypto_megolm_inbound_session WHERE account_id=$1 AND session IS NOT NULL AND key_backup_version != $2`,
 		store.AccountID, version,
 	)
@@ -594,14 +845,15 @@ func (store *SQLCryptoStore) AddOutboundGroupSession(ctx context.Context, sessio
 	}
 	_, err = store.DB.Exec(ctx, `
 		INSERT INTO crypto_megolm_outbound_session
-			(room_id, session_id, session, shared, max_messages, message_count, max_age, created_at, last_used, account_id)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			(room_id, session_id, session, shared, max_messages, message_count, max_age, created_at, last_used, shared_history, account_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 		ON CONFLICT (account_id, room_id) DO UPDATE
 			SET session_id=excluded.session_id, session=excluded.session, shared=excluded.shared,
 				max_messages=excluded.max_messages, message_count=excluded.message_count, max_age=excluded.max_age,
-				created_at=excluded.created_at, last_used=excluded.last_used, account_id=excluded.account_id
+				created_at=excluded.created_at, last_used=excluded.last_used, shared_history=excluded.shared_history,
+				account_id=excluded.account_id
 	`, session.RoomID, session.ID(), sessionBytes, session.Shared, session.MaxMessages, session.MessageCount,
-		session.MaxAge.Milliseconds(), session.CreationTime, session.LastEncryptedTime, store.AccountID)
+		session.MaxAge.Milliseconds(), session.CreationTime, session.LastEncryptedTime, session.SharedHistory, store.AccountID)
 	return err
 }
 
@@ -622,10 +874,10 @@ func (store *SQLCryptoStore) GetOutboundGroupSession(ctx context.Context, roomID
 	var sessionBytes []byte
 	var maxAgeMS int64
 	err := store.DB.QueryRow(ctx, `
-		SELECT session, shared, max_messages, message_count, max_age, created_at, last_used
+		SELECT session, shared, max_messages, message_count, max_age, created_at, last_used, shared_history
 		FROM crypto_megolm_outbound_session WHERE room_id=$1 AND account_id=$2`,
 		roomID, store.AccountID,
-	).Scan(&sessionBytes, &ogs.Shared, &ogs.MaxMessages, &ogs.MessageCount, &maxAgeMS, &ogs.CreationTime, &ogs.LastEncryptedTime)
+	).Scan(&sessionBytes, &ogs.Shared, &ogs.MaxMessages, &ogs.MessageCount, &maxAgeMS, &ogs.CreationTime, &ogs.LastEncryptedTime, &ogs.SharedHistory)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	} else if err != nil {
@@ -649,6 +901,34 @@ func (store *SQLCryptoStore) RemoveOutboundGroupSession(ctx context.Context, roo
 	return err
 }
 
+// PutMegolmRotationOverride stores or removes the outbound Megolm session rotation override for the given room.
+func (store *SQLCryptoStore) PutMegolmRotationOverride(ctx context.Context, roomID id.RoomID, override *MegolmRotationOverride) error {
+	if override == nil {
+		_, err := store.DB.Exec(ctx, "DELETE FROM crypto_megolm_rotation_override WHERE account_id=$1 AND room_id=$2", store.AccountID, roomID)
+		return err
+	}
+	_, err := store.DB.Exec(ctx, `
+		INSERT INTO crypto_megolm_rotation_override (account_id, room_id, max_age, max_messages) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (account_id, room_id) DO UPDATE SET max_age=excluded.max_age, max_messages=excluded.max_messages
+	`, store.AccountID, roomID, override.MaxAge.Milliseconds(), override.MaxMessages)
+	return err
+}
+
+// GetMegolmRotationOverride retrieves the outbound Megolm session rotation override for the given room.
+func (store *SQLCryptoStore) GetMegolmRotationOverride(ctx context.Context, roomID id.RoomID) (*MegolmRotationOverride, error) {
+	var override MegolmRotationOverride
+	var maxAgeMS int64
+	err := store.DB.QueryRow(ctx, "SELECT max_age, max_messages FROM crypto_megolm_rotation_override WHERE account_id=$1 AND room_id=$2",
+		store.AccountID, roomID).Scan(&maxAgeMS, &override.MaxMessages)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	override.MaxAge = time.Duration(maxAgeMS) * time.Millisecond
+	return &override, nil
+}
+
 func (store *SQLCryptoStore) MarkOutboundGroupSessionShared(ctx context.Context, userID id.UserID, identityKey id.IdentityKey, sessionID id.SessionID) error {
 	_, err := store.DB.Exec(ctx, "INSERT INTO crypto_megolm_outbound_session_shared (user_id, identity_key, session_id) VALUES ($1, $2, $3)", userID, identityKey, sessionID)
 	return err
@@ -1,11 +1,15 @@
--- v0 -> v17 (compatible with v15+): Latest revision
+-- v0 -> v21 (compatible with v15+): Latest revision
 CREATE TABLE IF NOT EXISTS crypto_account (
 	account_id         TEXT    PRIMARY KEY,
 	device_id          TEXT    NOT NULL,
 	shared             BOOLEAN NOT NULL,
 	sync_token         TEXT    NOT NULL,
 	account            bytea   NOT NULL,
-	key_backup_version TEXT    NOT NULL DEFAULT ''
+	key_backup_version TEXT    NOT NULL DEFAULT '',
+
+	fallback_key_created_at      BIGINT  NOT NULL DEFAULT 0,
+	fallback_key_uses            INTEGER NOT NULL DEFAULT 0,
+	prev_fallback_key_expires_at BIGINT  NOT NULL DEFAULT 0
 );
 
 CREATE TABLE IF NOT EXISTS crypto_message_index (
@@ -71,20 +75,32 @@ CREATE TABLE IF NOT EXISTS crypto_megolm_inbound_session (
 	max_messages       INTEGER,
 	is_scheduled       BOOLEAN NOT NULL DEFAULT false,
 	key_backup_version TEXT NOT NULL DEFAULT '',
+	shared_history     BOOLEAN NOT NULL DEFAULT false,
+	forwarder_trust    INTEGER NOT NULL DEFAULT 0,
 	PRIMARY KEY (account_id, session_id)
 );
 
 CREATE TABLE IF NOT EXISTS crypto_megolm_outbound_session (
-	account_id    TEXT,
-	room_id       TEXT,
-	session_id    CHAR(43)  NOT NULL UNIQUE,
-	session       bytea     NOT NULL,
-	shared        BOOLEAN   NOT NULL,
-	max_messages  INTEGER   NOT NULL,
-	message_count INTEGER   NOT NULL,
-	max_age       BIGINT    NOT NULL,
-	created_at    timestamp NOT NULL,
-	last_used     timestamp NOT NULL,
+	account_id     TEXT,
+	room_id        TEXT,
+	session_id     CHAR(43)  NOT NULL UNIQUE,
+	session        bytea     NOT NULL,
+	shared         BOOLEAN   NOT NULL,
+	max_messages   INTEGER   NOT NULL,
+	message_count  INTEGER   NOT NULL,
+	max_age        BIGINT    NOT NULL,
+	created_at     timestamp NOT NULL,
+	last_used      timestamp NOT NULL,
+	shared_history BOOLEAN   NOT NULL DEFAULT false,
+	PRIMARY KEY (account_id, room_id)
+);
+
+CREATE TABLE IF NOT EXISTS crypto_megolm_rotation_override (
+	account_id   TEXT    NOT NULL,
+	room_id      TEXT    NOT NULL,
+	max_age      BIGINT  NOT NULL,
+	max_messages INTEGER NOT NULL,
+
 	PRIMARY KEY (account_id, room_id)
 );
 
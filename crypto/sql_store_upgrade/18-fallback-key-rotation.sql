@@ -0,0 +1,4 @@
+-- v18 (compatible with v15+): Add fallback key rotation state to account
+ALTER TABLE crypto_account ADD COLUMN fallback_key_created_at BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE crypto_account ADD COLUMN fallback_key_uses INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE crypto_account ADD COLUMN prev_fallback_key_expires_at BIGINT NOT NULL DEFAULT 0;
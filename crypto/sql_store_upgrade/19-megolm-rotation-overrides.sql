@@ -0,0 +1,9 @@
+-- v19 (compatible with v15+): Add table for per-room megolm rotation overrides
+CREATE TABLE crypto_megolm_rotation_override (
+	account_id   TEXT    NOT NULL,
+	room_id      TEXT    NOT NULL,
+	max_age      BIGINT  NOT NULL,
+	max_messages INTEGER NOT NULL,
+
+	PRIMARY KEY (account_id, room_id)
+);
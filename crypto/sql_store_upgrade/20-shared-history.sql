@@ -0,0 +1,3 @@
+-- v20 (compatible with v15+): Add shared history flag to megolm sessions (MSC3061)
+ALTER TABLE crypto_megolm_inbound_session ADD COLUMN shared_history BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE crypto_megolm_outbound_session ADD COLUMN shared_history BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,2 @@
+-- v21 (compatible with v15+): Store trust of the forwarding device of megolm sessions
+ALTER TABLE crypto_megolm_inbound_session ADD COLUMN forwarder_trust INTEGER NOT NULL DEFAULT 0;
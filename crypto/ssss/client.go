@@ -10,14 +10,28 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 )
 
 // Machine contains utility methods for interacting with SSSS data on the server.
 type Machine struct {
 	Client *mautrix.Client
+	// Cache is used to store decrypted secrets. If nil, secrets are always fetched from the server.
+	Cache SecretCache
+	// CachedSecrets is the list of secrets that are stored in Cache. It's empty by default, which means
+	// nothing is cached. Cached secrets are stored unencrypted and may be shared with other devices of the
+	// user that request them, so the cross-signing master key should usually not be included here
+	// (see [DefaultCachedSecrets]).
+	CachedSecrets []id.Secret
+	// GetKeyCallback is called by GetSecret when none of the added keys can decrypt a secret.
+	GetKeyCallback GetKeyFunc
+
+	keys     map[string]*Key
+	keysLock sync.RWMutex
 }
 
 func NewSSSSMachine(client *mautrix.Client) *Machine {
@@ -92,7 +106,11 @@ func (mach *Machine) SetEncryptedAccountData(ctx context.Context, eventType even
 	for _, key := range keys {
 		encrypted[key.ID] = key.Encrypt(eventType.Type, data)
 	}
-	return mach.Client.SetAccountData(ctx, eventType.Type, &EncryptedAccountDataEventContent{Encrypted: encrypted})
+	err := mach.Client.SetAccountData(ctx, eventType.Type, &EncryptedAccountDataEventContent{Encrypted: encrypted})
+	if err == nil {
+		mach.cacheSecret(ctx, eventType, data)
+	}
+	return err
 }
 
 // GenerateAndUploadKey generates a new SSSS key and stores the metadata on the server.
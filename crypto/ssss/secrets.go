@@ -0,0 +1,221 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package ssss
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// SecretCache stores decrypted secrets locally. Values are in the unpadded base64 format
+// that is used inside secret storage and when sharing secrets between devices.
+//
+// The crypto store implements this interface.
+type SecretCache interface {
+	PutSecret(ctx context.Context, name id.Secret, value string) error
+	GetSecret(ctx context.Context, name id.Secret) (string, error)
+	DeleteSecret(ctx context.Context, name id.Secret) error
+}
+
+// DefaultCachedSecrets is a suggested value for [Machine.CachedSecrets], which contains all the
+// standard secrets except the cross-signing master key.
+var DefaultCachedSecrets = []id.Secret{id.SecretXSSelfSigning, id.SecretXSUserSigning, id.SecretMegolmBackupV1}
+
+// GetKeyFunc is called when a secret is needed, but none of the keys added to the machine can decrypt it.
+//
+// The key IDs are the keys the secret is encrypted with, with the default key first if it's one of them.
+// Implementations will usually prompt the user for the passphrase or recovery key and return the result
+// of [KeyMetadata.VerifyPassphrase] or [KeyMetadata.VerifyRecoveryKey].
+type GetKeyFunc func(ctx context.Context, keyIDs []string) (*Key, error)
+
+// AddKey adds a key to the in-memory key list, which is used to decrypt secrets in [Machine.GetSecret].
+func (mach *Machine) AddKey(key *Key) {
+	mach.keysLock.Lock()
+	defer mach.keysLock.Unlock()
+	if mach.keys == nil {
+		mach.keys = make(map[string]*Key)
+	}
+	mach.keys[key.ID] = key
+}
+
+// GetKey returns a previously added key, or nil if the key hasn't been added.
+func (mach *Machine) GetKey(keyID string) *Key {
+	mach.keysLock.RLock()
+	defer mach.keysLock.RUnlock()
+	return mach.keys[keyID]
+}
+
+// RemoveKey removes a key from the in-memory key list.
+func (mach *Machine) RemoveKey(keyID string) {
+	mach.keysLock.Lock()
+	defer mach.keysLock.Unlock()
+	delete(mach.keys, keyID)
+}
+
+func (mach *Machine) getEncryptedAccountData(ctx context.Context, eventType event.Type) (*EncryptedAccountDataEventContent, error) {
+	var encData EncryptedAccountDataEventContent
+	err := mach.Client.GetAccountData(ctx, eventType.Type, &encData)
+	if errors.Is(err, mautrix.MNotFound) || (err == nil && len(encData.Encrypted) == 0) {
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, eventType.Type)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get %s from account data: %w", eventType.Type, err)
+	}
+	return &encData, nil
+}
+
+func (mach *Machine) getKeyFromCallback(ctx context.Context, encData *EncryptedAccountDataEventContent) (*Key, error) {
+	keyIDs := make([]string, 0, len(encData.Encrypted))
+	for keyID := range encData.Encrypted {
+		keyIDs = append(keyIDs, keyID)
+	}
+	slices.Sort(keyIDs)
+	if defaultKeyID, err := mach.GetDefaultKeyID(ctx); err == nil {
+		if idx := slices.Index(keyIDs, defaultKeyID); idx > 0 {
+			keyIDs = slices.Delete(keyIDs, idx, idx+1)
+			keyIDs = slices.Insert(keyIDs, 0, defaultKeyID)
+		}
+	}
+	key, err := mach.GetKeyCallback(ctx, keyIDs)
+	if err != nil {
+		return nil, err
+	} else if key == nil {
+		return nil, ErrNoKeyForSecret
+	}
+	mach.AddKey(key)
+	return key, nil
+}
+
+// GetSecret returns the decrypted value of the secret stored in the given account data event type.
+//
+// If the secret is in the cache, it's returned without contacting the server. Otherwise, the secret
+// is fetched from account data and decrypted using any key added with [Machine.AddKey]. If none of
+// the keys can decrypt the secret, GetKeyCallback is called to get a key. Decrypted secrets listed in
+// CachedSecrets are stored in the cache until they're invalidated with [Machine.InvalidateSecret].
+func (mach *Machine) GetSecret(ctx context.Context, eventType event.Type) ([]byte, error) {
+	if mach.shouldCache(eventType) {
+		cached, err := mach.Cache.GetSecret(ctx, id.Secret(eventType.Type))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get cached secret: %w", err)
+		} else if cached != "" {
+			return base64.RawStdEncoding.DecodeString(strings.TrimRight(cached, "="))
+		}
+	}
+	encData, err := mach.getEncryptedAccountData(ctx, eventType)
+	if err != nil {
+		return nil, err
+	}
+	var decrypted []byte
+	for keyID, data := range encData.Encrypted {
+		if key := mach.GetKey(keyID); key != nil {
+			decrypted, err = key.Decrypt(eventType.Type, data)
+			if err == nil {
+				break
+			}
+		}
+	}
+	if decrypted == nil {
+		if mach.GetKeyCallback == nil {
+			return nil, fmt.Errorf("%w: %s", ErrNoKeyForSecret, eventType.Type)
+		}
+		key, err := mach.getKeyFromCallback(ctx, encData)
+		if err != nil {
+			return nil, err
+		}
+		decrypted, err = encData.Decrypt(eventType.Type, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	mach.cacheSecret(ctx, eventType, decrypted)
+	return decrypted, nil
+}
+
+func (mach *Machine) shouldCache(eventType event.Type) bool {
+	return mach.Cache != nil && slices.Contains(mach.CachedSecrets, id.Secret(eventType.Type))
+}
+
+func (mach *Machine) cacheSecret(ctx context.Context, eventType event.Type, secret []byte) {
+	if !mach.shouldCache(eventType) {
+		return
+	}
+	err := mach.Cache.PutSecret(ctx, id.Secret(eventType.Type), base64.RawStdEncoding.EncodeToString(secret))
+	if err != nil {
+		mach.Client.Log.Warn().Err(err).Str("secret", eventType.Type).Msg("Failed to cache decrypted secret")
+	}
+}
+
+// InvalidateSecret removes the given secrets from the cache, so that the next [Machine.GetSecret]
+// call fetches them from the server again. This should be called when the account data events
+// of the secrets change.
+func (mach *Machine) InvalidateSecret(ctx context.Context, eventTypes ...event.Type) error {
+	if mach.Cache == nil {
+		return nil
+	}
+	for _, eventType := range eventTypes {
+		if err := mach.Cache.DeleteSecret(ctx, id.Secret(eventType.Type)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetSecretKeyIDs returns the IDs of the keys the given secret is encrypted with.
+func (mach *Machine) GetSecretKeyIDs(ctx context.Context, eventType event.Type) ([]string, error) {
+	encData, err := mach.getEncryptedAccountData(ctx, eventType)
+	if err != nil {
+		return nil, err
+	}
+	keyIDs := make([]string, 0, len(encData.Encrypted))
+	for keyID := range encData.Encrypted {
+		keyIDs = append(keyIDs, keyID)
+	}
+	slices.Sort(keyIDs)
+	return keyIDs, nil
+}
+
+// AddKeysToSecret encrypts an existing secret with additional keys, keeping the existing encrypted
+// copies for other keys. The secret is decrypted the same way as in [Machine.GetSecret].
+func (mach *Machine) AddKeysToSecret(ctx context.Context, eventType event.Type, keys ...*Key) error {
+	if len(keys) == 0 {
+		return ErrNoKeyGiven
+	}
+	secret, err := mach.GetSecret(ctx, eventType)
+	if err != nil {
+		return err
+	}
+	encData, err := mach.getEncryptedAccountData(ctx, eventType)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		encData.Encrypted[key.ID] = key.Encrypt(eventType.Type, secret)
+	}
+	return mach.Client.SetAccountData(ctx, eventType.Type, encData)
+}
+
+// RemoveKeyFromSecret removes the encrypted copy of a secret for the given key. The last remaining
+// key can't be removed, as the secret would become unreadable.
+func (mach *Machine) RemoveKeyFromSecret(ctx context.Context, eventType event.Type, keyID string) error {
+	encData, err := mach.getEncryptedAccountData(ctx, eventType)
+	if err != nil {
+		return err
+	} else if _, ok := encData.Encrypted[keyID]; !ok {
+		return nil
+	} else if len(encData.Encrypted) == 1 {
+		return ErrCantRemoveLastKey
+	}
+	delete(encData.Encrypted, keyID)
+	return mach.Client.SetAccountData(ctx, eventType.Type, encData)
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package ssss_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/ssss"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type mapSecretCache map[id.Secret]string
+
+func (c mapSecretCache) PutSecret(_ context.Context, name id.Secret, value string) error {
+	c[name] = value
+	return nil
+}
+
+func (c mapSecretCache) GetSecret(_ context.Context, name id.Secret) (string, error) {
+	return c[name], nil
+}
+
+func (c mapSecretCache) DeleteSecret(_ context.Context, name id.Secret) error {
+	delete(c, name)
+	return nil
+}
+
+func newAccountDataServer(t *testing.T) (*ssss.Machine, map[string]json.RawMessage) {
+	var lock sync.Mutex
+	accountData := make(map[string]json.RawMessage)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /_matrix/client/v3/user/{userID}/account_data/{type}", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		data, ok := accountData[r.PathValue("type")]
+		lock.Unlock()
+		if !ok {
+			mautrix.MNotFound.WithMessage("Account data not found").Write(w)
+			return
+		}
+		_, _ = w.Write(data)
+	})
+	mux.HandleFunc("PUT /_matrix/client/v3/user/{userID}/account_data/{type}", func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		lock.Lock()
+		accountData[r.PathValue("type")] = data
+		lock.Unlock()
+		_, _ = w.Write([]byte("{}"))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	client, err := mautrix.NewClient(server.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+	return ssss.NewSSSSMachine(client), accountData
+}
+
+func TestMachine_GetSecret(t *testing.T) {
+	ctx := context.Background()
+	mach, accountData := newAccountDataServer(t)
+	cache := make(mapSecretCache)
+	mach.Cache = cache
+	mach.CachedSecrets = ssss.DefaultCachedSecrets
+	key1, err := ssss.NewKey("")
+	require.NoError(t, err)
+	key2, err := ssss.NewKey("")
+	require.NoError(t, err)
+	secret := []byte("meow")
+
+	err = mach.SetEncryptedAccountData(ctx, event.AccountDataMegolmBackupKey, secret, key1)
+	require.NoError(t, err)
+	assert.NotEmpty(t, cache[id.SecretMegolmBackupV1])
+
+	// Cached secrets are returned without any keys
+	decrypted, err := mach.GetSecret(ctx, event.AccountDataMegolmBackupKey)
+	require.NoError(t, err)
+	assert.Equal(t, secret, decrypted)
+
+	// After invalidation, a key is needed
+	require.NoError(t, mach.InvalidateSecret(ctx, event.AccountDataMegolmBackupKey))
+	_, err = mach.GetSecret(ctx, event.AccountDataMegolmBackupKey)
+	assert.ErrorIs(t, err, ssss.ErrNoKeyForSecret)
+
+	var callbackKeyIDs []string
+	mach.GetKeyCallback = func(ctx context.Context, keyIDs []string) (*ssss.Key, error) {
+		callbackKeyIDs = keyIDs
+		return key1, nil
+	}
+	decrypted, err = mach.GetSecret(ctx, event.AccountDataMegolmBackupKey)
+	require.NoError(t, err)
+	assert.Equal(t, secret, decrypted)
+	assert.Equal(t, []string{key1.ID}, callbackKeyIDs)
+	assert.Equal(t, key1, mach.GetKey(key1.ID))
+
+	// Add a second key and check that it can decrypt the secret on its own
+	err = mach.AddKeysToSecret(ctx, event.AccountDataMegolmBackupKey, key2)
+	require.NoError(t, err)
+	keyIDs, err := mach.GetSecretKeyIDs(ctx, event.AccountDataMegolmBackupKey)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{key1.ID, key2.ID}, keyIDs)
+
+	require.NoError(t, mach.RemoveKeyFromSecret(ctx, event.AccountDataMegolmBackupKey, key1.ID))
+	assert.ErrorIs(t, mach.RemoveKeyFromSecret(ctx, event.AccountDataMegolmBackupKey, key2.ID), ssss.ErrCantRemoveLastKey)
+	mach.RemoveKey(key1.ID)
+	mach.AddKey(key2)
+	mach.GetKeyCallback = nil
+	require.NoError(t, mach.InvalidateSecret(ctx, event.AccountDataMegolmBackupKey))
+	decrypted, err = mach.GetSecret(ctx, event.AccountDataMegolmBackupKey)
+	require.NoError(t, err)
+	assert.Equal(t, secret, decrypted)
+	assert.Contains(t, string(accountData[event.AccountDataMegolmBackupKey.Type]), key2.ID)
+
+	_, err = mach.GetSecret(ctx, event.AccountDataCrossSigningMaster)
+	assert.ErrorIs(t, err, ssss.ErrSecretNotFound)
+
+	// Secrets that aren't in CachedSecrets are never cached
+	err = mach.SetEncryptedAccountData(ctx, event.AccountDataCrossSigningMaster, secret, key2)
+	require.NoError(t, err)
+	decrypted, err = mach.GetSecret(ctx, event.AccountDataCrossSigningMaster)
+	require.NoError(t, err)
+	assert.Equal(t, secret, decrypted)
+	assert.NotContains(t, cache, id.SecretXSMaster)
+}
@@ -26,6 +26,9 @@ var (
 	ErrUnsupportedPassphraseAlgorithm = errors.New("unsupported passphrase KDF algorithm")
 	ErrIncorrectSSSSKey               = errors.New("incorrect SSSS key")
 	ErrInvalidRecoveryKey             = errors.New("invalid recovery key")
+	ErrSecretNotFound                 = errors.New("secret not found in account data")
+	ErrNoKeyForSecret                 = errors.New("no key available to decrypt secret")
+	ErrCantRemoveLastKey              = errors.New("can't remove the only key a secret is encrypted with")
 )
 
 // Algorithm is the identifier for an SSSS encryption algorithm.
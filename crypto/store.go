@@ -107,6 +107,12 @@ type Store interface {
 	MarkOutboundGroupSessionShared(context.Context, id.UserID, id.IdentityKey, id.SessionID) error
 	// IsOutboutGroupSessionShared checks if the specified session has been shared with the device.
 	IsOutboundGroupSessionShared(context.Context, id.UserID, id.IdentityKey, id.SessionID) (bool, error)
+	// PutMegolmRotationOverride stores the outbound Megolm session rotation override for the given room.
+	// If the override is nil, any stored override should be removed.
+	PutMegolmRotationOverride(context.Context, id.RoomID, *MegolmRotationOverride) error
+	// GetMegolmRotationOverride gets the outbound Megolm session rotation override for the given room,
+	// or nil if there is no override.
+	GetMegolmRotationOverride(context.Context, id.RoomID) (*MegolmRotationOverride, error)
 
 	// ValidateMessageIndex validates that the given message details aren't from a replay attack.
 	//
@@ -180,6 +186,7 @@ type MemoryStore struct {
 	GroupSessions         map[id.RoomID]map[id.SessionID]*InboundGroupSession
 	WithheldGroupSessions map[id.RoomID]map[id.SessionID]*event.RoomKeyWithheldEventContent
 	OutGroupSessions      map[id.RoomID]*OutboundGroupSession
+	RotationOverrides     map[id.RoomID]*MegolmRotationOverride
 	SharedGroupSessions   map[id.UserID]map[id.IdentityKey]map[id.SessionID]struct{}
 	MessageIndices        map[messageIndexKey]messageIndexValue
 	Devices               map[id.UserID]map[id.DeviceID]*id.Device
@@ -203,6 +210,7 @@ func NewMemoryStore(saveCallback func() error) *MemoryStore {
 		GroupSessions:         make(map[id.RoomID]map[id.SessionID]*InboundGroupSession),
 		WithheldGroupSessions: make(map[id.RoomID]map[id.SessionID]*event.RoomKeyWithheldEventContent),
 		OutGroupSessions:      make(map[id.RoomID]*OutboundGroupSession),
+		RotationOverrides:     make(map[id.RoomID]*MegolmRotationOverride),
 		SharedGroupSessions:   make(map[id.UserID]map[id.IdentityKey]map[id.SessionID]struct{}),
 		MessageIndices:        make(map[messageIndexKey]messageIndexValue),
 		Devices:               make(map[id.UserID]map[id.DeviceID]*id.Device),
@@ -261,6 +269,7 @@ func (gs *MemoryStore) DeleteSession(ctx context.Context, senderKey id.SenderKey
 	gs.Sessions[senderKey] = slices.DeleteFunc(sessions, func(session *OlmSession) bool {
 		return session == target
 	})
+	target.Wipe()
 	return gs.save()
 }
 
@@ -335,7 +344,8 @@ func (gs *MemoryStore) GetGroupSession(_ context.Context, roomID id.RoomID, sess
 	if !ok {
 		withheld, ok := gs.getWithheldGroupSessions(roomID)[sessionID]
 		if ok {
-			return nil, fmt.Errorf("%w (%s)", ErrGroupSessionWithheld, withheld.Code)
+			withheldCopy := *withheld
+			return nil, &withheldCopy
 		}
 		return nil, nil
 	}
@@ -345,7 +355,11 @@ func (gs *MemoryStore) GetGroupSession(_ context.Context, roomID id.RoomID, sess
 func (gs *MemoryStore) RedactGroupSession(_ context.Context, roomID id.RoomID, sessionID id.SessionID, reason string) error {
 	gs.lock.Lock()
 	defer gs.lock.Unlock()
-	delete(gs.getGroupSessions(roomID), sessionID)
+	sessions := gs.getGroupSessions(roomID)
+	if session, ok := sessions[sessionID]; ok {
+		session.Wipe()
+		delete(sessions, sessionID)
+	}
 	return gs.save()
 }
 
@@ -358,6 +372,7 @@ func (gs *MemoryStore) RedactGroupSessions(_ context.Context, roomID id.RoomID,
 		for sessionID, session := range sessions {
 			if session.SenderKey == senderKey {
 				sessionIDs = append(sessionIDs, sessionID)
+				session.Wipe()
 				delete(sessions, sessionID)
 			}
 		}
@@ -366,12 +381,16 @@ func (gs *MemoryStore) RedactGroupSessions(_ context.Context, roomID id.RoomID,
 			for sessionID, session := range room {
 				if session.SenderKey == senderKey {
 					sessionIDs = append(sessionIDs, sessionID)
+					session.Wipe()
 					delete(room, sessionID)
 				}
 			}
 		}
 	} else if roomID != "" {
 		sessionIDs = maps.Keys(gs.GroupSessions[roomID])
+		for _, session := range gs.GroupSessions[roomID] {
+			session.Wipe()
+		}
 		delete(gs.GroupSessions, roomID)
 	} else {
 		return nil, fmt.Errorf("room ID or sender key must be provided for redacting sessions")
@@ -480,6 +499,23 @@ func (gs *MemoryStore) RemoveOutboundGroupSession(_ context.Context, roomID id.R
 	return nil
 }
 
+func (gs *MemoryStore) PutMegolmRotationOverride(_ context.Context, roomID id.RoomID, override *MegolmRotationOverride) error {
+	gs.lock.Lock()
+	defer gs.lock.Unlock()
+	if override == nil {
+		delete(gs.RotationOverrides, roomID)
+	} else {
+		gs.RotationOverrides[roomID] = override
+	}
+	return gs.save()
+}
+
+func (gs *MemoryStore) GetMegolmRotationOverride(_ context.Context, roomID id.RoomID) (*MegolmRotationOverride, error) {
+	gs.lock.RLock()
+	defer gs.lock.RUnlock()
+	return gs.RotationOverrides[roomID], nil
+}
+
 func (gs *MemoryStore) MarkOutboundGroupSessionShared(_ context.Context, userID id.UserID, identityKey id.IdentityKey, sessionID id.SessionID) error {
 	gs.lock.Lock()
 	defer gs.lock.Unlock()
@@ -9,14 +9,18 @@ package crypto
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"strconv"
 	"testing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.mau.fi/util/dbutil"
 
 	"maunium.net/go/mautrix/crypto/olm"
+	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 )
 
@@ -163,6 +167,9 @@ func TestStoreMegolmSession(t *testing.T) {
 				SigningKey: acc.SigningKey(),
 				SenderKey:  acc.IdentityKey(),
 				RoomID:     "room1",
+
+				SharedHistory:  true,
+				ForwarderTrust: id.TrustStateForwarded,
 			}
 
 			err = store.PutGroupSession(context.TODO(), igs)
@@ -180,6 +187,132 @@ func TestStoreMegolmSession(t *testing.T) {
 			} else if string(pickled) != groupSession {
 				t.Error("Pickled inbound group session does not match original")
 			}
+			if !retrieved.SharedHistory {
+				t.Error("Shared history flag was not stored")
+			}
+			roomSessions, err := store.GetGroupSessionsForRoom(context.TODO(), "room1").AsList()
+			if err != nil {
+				t.Fatalf("Error retrieving inbound group sessions of room: %v", err)
+			} else if len(roomSessions) != 1 || roomSessions[0].ForwarderTrust != id.TrustStateForwarded {
+				t.Error("Forwarder trust was not stored")
+			}
+		})
+	}
+}
+
+func makeTestInboundGroupSession(t *testing.T, roomID id.RoomID) *InboundGroupSession {
+	t.Helper()
+	ogs, err := NewOutboundGroupSession(roomID, nil)
+	require.NoError(t, err)
+	acc := NewOlmAccount()
+	igs, err := NewInboundGroupSession(acc.IdentityKey(), acc.SigningKey(), roomID, ogs.Internal.Key(), 0, 0, false)
+	require.NoError(t, err)
+	return igs
+}
+
+func TestSQLStoreGroupSessionCache(t *testing.T) {
+	store := getCryptoStores(t)["sql"].(*SQLCryptoStore)
+	ctx := context.TODO()
+	sess1 := makeTestInboundGroupSession(t, "!room1:example.com")
+	sess2 := makeTestInboundGroupSession(t, "!room2:example.com")
+	sess3 := makeTestInboundGroupSession(t, "!room3:example.com")
+	require.NoError(t, store.PutGroupSessions(ctx, []*InboundGroupSession{sess1, sess2, sess3}))
+
+	assert.Equal(t, 3, store.groupSessionCache.Len())
+	retrieved, err := store.GetGroupSession(ctx, sess1.RoomID, sess1.ID())
+	require.NoError(t, err)
+	assert.Equal(t, sess1.ID(), retrieved.ID())
+	// Every read must return a separate instance
+	assert.NotSame(t, sess1, retrieved)
+	assert.NotSame(t, sess1.Internal, retrieved.Internal)
+	retrieved.RatchetSafety.MissedIndices = append(retrieved.RatchetSafety.MissedIndices, 5)
+	retrieved2, err := store.GetGroupSession(ctx, sess1.RoomID, sess1.ID())
+	require.NoError(t, err)
+	assert.NotSame(t, retrieved.Internal, retrieved2.Internal)
+	assert.Empty(t, retrieved2.RatchetSafety.MissedIndices)
+	retrieved, err = store.GetGroupSession(ctx, "!wrongroom:example.com", sess1.ID())
+	require.NoError(t, err)
+	assert.Nil(t, retrieved)
+
+	// Sessions must be refetched from the database after the cache is reset
+	store.InitFields()
+	sessions, err := store.GetGroupSessionsForRooms(ctx, []id.RoomID{sess1.RoomID, sess2.RoomID}).AsList()
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	assert.Equal(t, 2, store.groupSessionCache.Len())
+	retrieved, err = store.GetGroupSession(ctx, sess2.RoomID, sess2.ID())
+	require.NoError(t, err)
+	assert.Equal(t, sess2.ID(), retrieved.ID())
+	assert.NotSame(t, sess2, retrieved)
+
+	require.NoError(t, store.RedactGroupSession(ctx, sess2.RoomID, sess2.ID(), "test"))
+	retrieved, err = store.GetGroupSession(ctx, sess2.RoomID, sess2.ID())
+	assert.ErrorIs(t, err, ErrGroupSessionWithheld)
+	assert.Nil(t, retrieved)
+
+	redacted, err := store.RedactGroupSessions(ctx, sess1.RoomID, "", "test")
+	require.NoError(t, err)
+	assert.Equal(t, []id.SessionID{sess1.ID()}, redacted)
+	_, err = store.GetGroupSession(ctx, sess1.RoomID, sess1.ID())
+	assert.ErrorIs(t, err, ErrGroupSessionWithheld)
+}
+
+func TestSQLStoreGroupSessionLateCache(t *testing.T) {
+	store := getCryptoStores(t)["sql"].(*SQLCryptoStore)
+	ctx := context.TODO()
+	sess := makeTestInboundGroupSession(t, "!room1:example.com")
+	require.NoError(t, store.PutGroupSession(ctx, sess))
+	pickled, err := sess.Internal.Pickle(store.PickleKey)
+	require.NoError(t, err)
+
+	// Simulate a GetGroupSession call that read the session from the database before it was redacted
+	store.InitFields()
+	_, generation, err := store.getCachedGroupSession(sess.RoomID, sess.ID())
+	require.NoError(t, err)
+	require.NoError(t, store.RedactGroupSession(ctx, sess.RoomID, sess.ID(), "test"))
+	store.cacheGroupSession(sess, pickled, generation)
+	assert.Equal(t, 0, store.groupSessionCache.Len(), "redacted session must not be cached by a late read")
+	_, err = store.GetGroupSession(ctx, sess.RoomID, sess.ID())
+	assert.ErrorIs(t, err, ErrGroupSessionWithheld)
+}
+
+func TestLRUCache(t *testing.T) {
+	cache := newLRUCache[string, int](2)
+	cache.Put("a", 1)
+	cache.Put("b", 2)
+	_, ok := cache.Get("a")
+	assert.True(t, ok)
+	cache.Put("c", 3)
+	_, ok = cache.Get("b")
+	assert.False(t, ok, "least recently used entry should've been evicted")
+	val, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, val)
+	assert.Equal(t, 2, cache.Len())
+}
+
+func TestStoreWithheldGroupSession(t *testing.T) {
+	stores := getCryptoStores(t)
+	for storeName, store := range stores {
+		t.Run(storeName, func(t *testing.T) {
+			content := event.RoomKeyWithheldEventContent{
+				RoomID:    "!room:example.com",
+				Algorithm: id.AlgorithmMegolmV1,
+				SessionID: "session",
+				SenderKey: "senderkey",
+				Code:      event.RoomKeyWithheldUnverified,
+				Reason:    "Device not verified",
+			}
+			err := store.PutWithheldGroupSession(context.TODO(), content)
+			require.NoError(t, err)
+
+			sess, err := store.GetGroupSession(context.TODO(), content.RoomID, content.SessionID)
+			assert.Nil(t, sess)
+			assert.ErrorIs(t, err, ErrGroupSessionWithheld)
+			var withheld *event.RoomKeyWithheldEventContent
+			require.True(t, errors.As(err, &withheld))
+			assert.Equal(t, event.RoomKeyWithheldUnverified, withheld.Code)
+			assert.Equal(t, "Device not verified", withheld.Reason)
 		})
 	}
 }
@@ -227,6 +360,28 @@ func TestStoreOutboundMegolmSession(t *testing.T) {
 	}
 }
 
+func TestStoreMegolmRotationOverride(t *testing.T) {
+	stores := getCryptoStores(t)
+	for storeName, store := range stores {
+		t.Run(storeName, func(t *testing.T) {
+			override, err := store.GetMegolmRotationOverride(context.TODO(), "room1")
+			require.NoError(t, err)
+			assert.Nil(t, override)
+
+			expected := &MegolmRotationOverride{MaxAge: 2 * time.Hour, MaxMessages: 5}
+			require.NoError(t, store.PutMegolmRotationOverride(context.TODO(), "room1", expected))
+			override, err = store.GetMegolmRotationOverride(context.TODO(), "room1")
+			require.NoError(t, err)
+			assert.Equal(t, expected, override)
+
+			require.NoError(t, store.PutMegolmRotationOverride(context.TODO(), "room1", nil))
+			override, err = store.GetMegolmRotationOverride(context.TODO(), "room1")
+			require.NoError(t, err)
+			assert.Nil(t, override)
+		})
+	}
+}
+
 func TestStoreOutboundMegolmSessionSharing(t *testing.T) {
 	stores := getCryptoStores(t)
 
@@ -20,8 +20,12 @@ var (
 	ErrInvalidQRCodeHeader  = errors.New("invalid QR code header")
 	ErrUnknownQRCodeVersion = errors.New("invalid QR code version")
 	ErrInvalidQRCodeMode    = errors.New("invalid QR code mode")
+	ErrQRCodeTooShort       = errors.New("QR code data is too short")
 )
 
+// MinQRCodeSharedSecretLength is the minimum length of the shared secret in a QR code as required by the spec.
+const MinQRCodeSharedSecretLength = 8
+
 type QRCodeMode byte
 
 const (
@@ -54,6 +58,8 @@ func NewQRCode(mode QRCodeMode, txnID id.VerificationTransactionID, key1, key2 [
 func NewQRCodeFromBytes(data []byte) (*QRCode, error) {
 	if !bytes.HasPrefix(data, []byte("MATRIX")) {
 		return nil, ErrInvalidQRCodeHeader
+	} else if len(data) < 10 {
+		return nil, ErrQRCodeTooShort
 	}
 	if data[6] != 0x02 {
 		return nil, ErrUnknownQRCodeVersion
@@ -61,7 +67,10 @@ func NewQRCodeFromBytes(data []byte) (*QRCode, error) {
 	if data[7] != 0x00 && data[7] != 0x01 && data[7] != 0x02 {
 		return nil, ErrInvalidQRCodeMode
 	}
-	transactionIDLength := binary.BigEndian.Uint16(data[8:10])
+	transactionIDLength := int(binary.BigEndian.Uint16(data[8:10]))
+	if len(data) < 10+transactionIDLength+64+MinQRCodeSharedSecretLength {
+		return nil, ErrQRCodeTooShort
+	}
 	transactionID := data[10 : 10+transactionIDLength]
 
 	var key1, key2 [32]byte
@@ -81,3 +81,15 @@ func TestQRCodeDecode(t *testing.T) {
 		})
 	}
 }
+
+func TestQRCodeDecode_Invalid(t *testing.T) {
+	var key1, key2 [32]byte
+	valid := verificationhelper.NewQRCode(verificationhelper.QRCodeModeCrossSigning, "txn", key1, key2).Bytes()
+
+	_, err := verificationhelper.NewQRCodeFromBytes([]byte("MATRIX"))
+	assert.ErrorIs(t, err, verificationhelper.ErrQRCodeTooShort)
+	_, err = verificationhelper.NewQRCodeFromBytes(valid[:len(valid)-10])
+	assert.ErrorIs(t, err, verificationhelper.ErrQRCodeTooShort)
+	_, err = verificationhelper.NewQRCodeFromBytes([]byte("NOTMATRIX"))
+	assert.ErrorIs(t, err, verificationhelper.ErrInvalidQRCodeHeader)
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"maunium.net/go/mautrix/crypto/attachment"
+	"maunium.net/go/mautrix/id"
+)
+
+// DefaultDownloadResumes is the number of times DownloadToWriter will resume an interrupted transfer
+// if ReqDownloadToWriter.MaxResumes is not set.
+const DefaultDownloadResumes = 5
+
+var ErrCantResumeEncryptedDownload = errors.New("encrypted downloads can't be resumed from an offset")
+
+// DownloadProgressFunc is called by DownloadToWriter after each write.
+// The total size is -1 if the server didn't specify it.
+type DownloadProgressFunc func(written, total int64)
+
+type ReqDownloadToWriter struct {
+	// If set, the downloaded data is decrypted with the given file info, and the hash is verified once
+	// the download is complete.
+	EncryptedFile *attachment.EncryptedFile
+	// Called after every write to the writer.
+	Progress DownloadProgressFunc
+	// The number of bytes that have already been written in a previous call, which should be skipped.
+	// This can't be used with EncryptedFile, as the hash must be calculated over the entire file.
+	Offset int64
+	// The maximum number of times an interrupted transfer is resumed using a Range request.
+	// Defaults to DefaultDownloadResumes, negative values disable resuming.
+	MaxResumes int
+}
+
+// DownloadToWriter downloads the given media and streams it into the given writer.
+//
+// If the transfer is interrupted, it's resumed from where it stopped using HTTP Range requests. If the server
+// doesn't support ranges, the already written bytes are skipped from the new response instead.
+//
+// The returned value is the total number of bytes written, including the offset given in the request,
+// so it can be passed back as the Offset to continue an unencrypted download that failed.
+//
+// For encrypted files, the hash is verified after all data has been written. If the hash doesn't match,
+// attachment.HashMismatch is returned, and the written data must be discarded.
+func (cli *Client) DownloadToWriter(ctx context.Context, mxcURL id.ContentURI, w io.Writer, req *ReqDownloadToWriter) (int64, error) {
+	if req == nil {
+		req = &ReqDownloadToWriter{}
+	}
+	if req.EncryptedFile != nil && req.Offset > 0 {
+		return 0, ErrCantResumeEncryptedDownload
+	}
+	maxResumes := req.MaxResumes
+	if maxResumes == 0 {
+		maxResumes = DefaultDownloadResumes
+	}
+	src := &resumingDownloadReader{
+		ctx:         ctx,
+		cli:         cli,
+		mxcURL:      mxcURL,
+		offset:      req.Offset,
+		total:       -1,
+		resumesLeft: maxResumes,
+	}
+	defer src.Close()
+	var reader io.Reader = src
+	var decrypter io.ReadCloser
+	if req.EncryptedFile != nil {
+		if err := req.EncryptedFile.PrepareForDecryption(); err != nil {
+			return 0, err
+		}
+		decrypter = req.EncryptedFile.DecryptStream(src)
+		reader = decrypter
+	}
+	pw := &progressWriter{w: w, written: req.Offset, total: &src.total, progress: req.Progress}
+	_, err := io.Copy(pw, reader)
+	if err != nil {
+		return pw.written, err
+	}
+	if decrypter != nil {
+		if err = decrypter.Close(); err != nil {
+			return pw.written, err
+		}
+	}
+	return pw.written, nil
+}
+
+type progressWriter struct {
+	w        io.Writer
+	written  int64
+	total    *int64
+	progress DownloadProgressFunc
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.written += int64(n)
+	if pw.progress != nil && n > 0 {
+		pw.progress(pw.written, *pw.total)
+	}
+	return n, err
+}
+
+type resumingDownloadReader struct {
+	ctx         context.Context
+	cli         *Client
+	mxcURL      id.ContentURI
+	body        io.ReadCloser
+	offset      int64
+	total       int64
+	resumesLeft int
+}
+
+func (r *resumingDownloadReader) open() error {
+	var headers http.Header
+	if r.offset > 0 {
+		headers = make(http.Header)
+		headers.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+	}
+	_, resp, err := r.cli.MakeFullRequestWithResp(r.ctx, FullRequest{
+		Method:           http.MethodGet,
+		URL:              r.cli.buildMediaURL(nil, "download", r.mxcURL.Homeserver, r.mxcURL.FileID),
+		Headers:          headers,
+		DontReadResponse: true,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		contentRange := resp.Header.Get("Content-Range")
+		if total, err := strconv.ParseInt(contentRange[strings.LastIndexByte(contentRange, '/')+1:], 10, 64); err == nil {
+			r.total = total
+		}
+	} else {
+		if resp.ContentLength >= 0 {
+			r.total = resp.ContentLength
+		}
+		// The server ignored the Range header, so skip the part that was already read
+		if r.offset > 0 {
+			_, err = io.CopyN(io.Discard, resp.Body, r.offset)
+			if err != nil {
+				_ = resp.Body.Close()
+				return fmt.Errorf("failed to skip already downloaded data: %w", err)
+			}
+		}
+	}
+	r.body = resp.Body
+	return nil
+}
+
+func (r *resumingDownloadReader) Read(p []byte) (int, error) {
+	for {
+		if r.body == nil {
+			if err := r.open(); err != nil {
+				return 0, err
+			}
+		}
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		if err == nil || errors.Is(err, io.EOF) || r.ctx.Err() != nil || r.resumesLeft <= 0 {
+			return n, err
+		}
+		r.resumesLeft--
+		r.cli.cliOrContextLog(r.ctx).Warn().Err(err).
+			Stringer("mxc_url", r.mxcURL).
+			Int64("offset", r.offset).
+			Int("resumes_left", r.resumesLeft).
+			Msg("Media download interrupted, resuming")
+		_ = r.body.Close()
+		r.body = nil
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (r *resumingDownloadReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/attachment"
+	"maunium.net/go/mautrix/id"
+)
+
+// newFlakyMediaServer returns a client for a media server that cuts off the first response halfway through
+// and supports Range requests for subsequent requests.
+func newFlakyMediaServer(t *testing.T, data []byte) (*mautrix.Client, *int) {
+	requests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /_matrix/client/v1/media/download/{server}/{mediaID}", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			_, _ = w.Write(data[:len(data)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+	return cli, &requests
+}
+
+var testMXC = id.ContentURI{Homeserver: "example.com", FileID: "media"}
+
+func TestClient_DownloadToWriter_Resume(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	cli, requests := newFlakyMediaServer(t, data)
+	var buf bytes.Buffer
+	var lastWritten, lastTotal int64
+	written, err := cli.DownloadToWriter(context.Background(), testMXC, &buf, &mautrix.ReqDownloadToWriter{
+		Progress: func(written, total int64) {
+			lastWritten, lastTotal = written, total
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, *requests)
+	assert.Equal(t, int64(len(data)), written)
+	assert.Equal(t, int64(len(data)), lastWritten)
+	assert.Equal(t, int64(len(data)), lastTotal)
+	assert.Equal(t, data, buf.Bytes())
+}
+
+func TestClient_DownloadToWriter_NoResume(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	cli, _ := newFlakyMediaServer(t, data)
+	var buf bytes.Buffer
+	written, err := cli.DownloadToWriter(context.Background(), testMXC, &buf, &mautrix.ReqDownloadToWriter{MaxResumes: -1})
+	require.Error(t, err)
+	assert.Equal(t, int64(buf.Len()), written)
+
+	// Continue the download from where it failed
+	written, err = cli.DownloadToWriter(context.Background(), testMXC, &buf, &mautrix.ReqDownloadToWriter{Offset: written})
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), written)
+	assert.Equal(t, data, buf.Bytes())
+}
+
+func TestClient_DownloadToWriter_Encrypted(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("0123456789"), 10000)
+	encryptFile := attachment.NewEncryptedFile()
+	ciphertext := encryptFile.Encrypt(plaintext)
+	fileJSON, err := json.Marshal(encryptFile)
+	require.NoError(t, err)
+	cli, requests := newFlakyMediaServer(t, ciphertext)
+
+	var file attachment.EncryptedFile
+	require.NoError(t, json.Unmarshal(fileJSON, &file))
+	var buf bytes.Buffer
+	_, err = cli.DownloadToWriter(context.Background(), testMXC, &buf, &mautrix.ReqDownloadToWriter{EncryptedFile: &file})
+	require.NoError(t, err)
+	assert.Equal(t, 2, *requests)
+	assert.Equal(t, plaintext, buf.Bytes())
+
+	var badFile attachment.EncryptedFile
+	require.NoError(t, json.Unmarshal(fileJSON, &badFile))
+	badFile.Hashes.SHA256 = base64.RawStdEncoding.EncodeToString(make([]byte, 32))
+	buf.Reset()
+	_, err = cli.DownloadToWriter(context.Background(), testMXC, &buf, &mautrix.ReqDownloadToWriter{EncryptedFile: &badFile})
+	assert.ErrorIs(t, err, attachment.HashMismatch)
+}
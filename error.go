@@ -57,6 +57,10 @@ var (
 	MBadState = RespError{ErrCode: "M_BAD_STATE"}
 	// The request or entity was too large.
 	MTooLarge = RespError{ErrCode: "M_TOO_LARGE", StatusCode: http.StatusRequestEntityTooLarge}
+	// The media has been created with the async upload API, but the content hasn't been uploaded yet.
+	MNotYetUploaded = RespError{ErrCode: "M_NOT_YET_UPLOADED", StatusCode: http.StatusGatewayTimeout}
+	// The media has been created with the async upload API, and the content has already been uploaded.
+	MCannotOverwriteMedia = RespError{ErrCode: "M_CANNOT_OVERWRITE_MEDIA", StatusCode: http.StatusConflict}
 	// The resource being requested is reserved by an application service, or the application service making the request has not created the resource.
 	MExclusive = RespError{ErrCode: "M_EXCLUSIVE", StatusCode: http.StatusBadRequest}
 	// The client's request to create a room used a room version that the server does not support.
@@ -2,10 +2,20 @@ package event
 
 import (
 	"encoding/json"
+	"fmt"
+	"time"
 )
 
+// MaxWaveformValue is the maximum value of a single sample in MSC1767Audio.Waveform.
+const MaxWaveformValue = 1024
+
+// MaxWaveformLength is the number of waveform samples that NormalizeWaveform reduces waveforms to.
+const MaxWaveformLength = 100
+
 type MSC1767Audio struct {
-	Duration int   `json:"duration"`
+	// Duration of the audio in milliseconds
+	Duration int `json:"duration"`
+	// Waveform samples, each between 0 and MaxWaveformValue
 	Waveform []int `json:"waveform"`
 }
 
@@ -19,3 +29,58 @@ func (ma *MSC1767Audio) MarshalJSON() ([]byte, error) {
 }
 
 type MSC3245Voice struct{}
+
+// NormalizeWaveform scales the given waveform samples from the range 0-maxValue to 0-MaxWaveformValue,
+// and downsamples it to at most MaxWaveformLength samples by averaging adjacent samples.
+func NormalizeWaveform(waveform []int, maxValue int) []int {
+	if len(waveform) == 0 || maxValue <= 0 {
+		return []int{}
+	}
+	outputLen := min(len(waveform), MaxWaveformLength)
+	output := make([]int, outputLen)
+	for i := range output {
+		start := i * len(waveform) / outputLen
+		end := (i + 1) * len(waveform) / outputLen
+		var sum int
+		for _, sample := range waveform[start:end] {
+			sum += min(max(sample, 0), maxValue)
+		}
+		output[i] = sum * MaxWaveformValue / maxValue / (end - start)
+	}
+	return output
+}
+
+// IsVoiceMessage returns true if the content is an audio message with the MSC3245 voice flag.
+func (content *MessageEventContent) IsVoiceMessage() bool {
+	return content.MsgType == MsgAudio && content.MSC3245Voice != nil
+}
+
+// MakeVoiceMessage turns the content into a MSC3245 voice message with the given duration and waveform.
+// The waveform must already be normalized (see NormalizeWaveform).
+//
+// The media URL or encrypted file and the file info (e.g. mimetype and size) should be set separately.
+// If the body is empty, it's set to a fallback text for clients that don't support voice messages.
+func (content *MessageEventContent) MakeVoiceMessage(duration time.Duration, waveform []int) {
+	content.MsgType = MsgAudio
+	if content.Info == nil {
+		content.Info = &FileInfo{}
+	}
+	content.Info.Duration = int(duration.Milliseconds())
+	content.MSC1767Audio = &MSC1767Audio{
+		Duration: int(duration.Milliseconds()),
+		Waveform: waveform,
+	}
+	content.MSC3245Voice = &MSC3245Voice{}
+	if content.Body == "" {
+		content.Body = VoiceMessageFallbackBody(duration)
+	}
+}
+
+// VoiceMessageFallbackBody returns a plaintext description of a voice message, e.g. "Voice message (1:05)".
+func VoiceMessageFallbackBody(duration time.Duration) string {
+	if duration <= 0 {
+		return "Voice message"
+	}
+	seconds := int(duration.Round(time.Second).Seconds())
+	return fmt.Sprintf("Voice message (%d:%02d)", seconds/60, seconds%60)
+}
@@ -10,6 +10,8 @@ import (
 	"encoding/base32"
 	"encoding/binary"
 	"fmt"
+	"html"
+	"strings"
 
 	"maunium.net/go/mautrix/id"
 )
@@ -108,6 +110,78 @@ type BeeperPerMessageProfile struct {
 	Displayname string               `json:"displayname,omitempty"`
 	AvatarURL   *id.ContentURIString `json:"avatar_url,omitempty"`
 	AvatarFile  *EncryptedFileInfo   `json:"avatar_file,omitempty"`
+	// Whether the body of the message has been prefixed with the displayname for clients that don't support MSC4144.
+	HasFallback bool `json:"has_fallback,omitempty"`
+}
+
+// GetAvatarURL returns the avatar URL of the profile, using the URL of the encrypted avatar file if there's no plain URL.
+func (pmp *BeeperPerMessageProfile) GetAvatarURL() id.ContentURIString {
+	if pmp.AvatarURL != nil {
+		return *pmp.AvatarURL
+	} else if pmp.AvatarFile != nil {
+		return pmp.AvatarFile.URL
+	}
+	return ""
+}
+
+// Apply returns a copy of the given member event content with the displayname and avatar overridden
+// by the per-message profile. This can be used by clients to render the effective sender profile of a message.
+func (pmp *BeeperPerMessageProfile) Apply(member *MemberEventContent) *MemberEventContent {
+	var output MemberEventContent
+	if member != nil {
+		output = *member
+	}
+	if pmp == nil {
+		return &output
+	}
+	if pmp.Displayname != "" {
+		output.Displayname = pmp.Displayname
+	}
+	if pmp.AvatarURL != nil || pmp.AvatarFile != nil {
+		output.AvatarURL = pmp.GetAvatarURL()
+	}
+	return &output
+}
+
+const perMessageProfileFallbackHTML = "<strong data-mx-profile-fallback>%s: </strong>"
+
+// SetPerMessageProfile sets the per-message profile (MSC4144) of the message. If addFallback is true and the message
+// is a text message, the body is prefixed with the displayname for clients that don't support per-message profiles.
+//
+// https://github.com/matrix-org/matrix-spec-proposals/pull/4144
+//
+// The profile is copied, so the given struct isn't modified and can be reused for other messages.
+func (content *MessageEventContent) SetPerMessageProfile(profile *BeeperPerMessageProfile, addFallback bool) {
+	content.RemovePerMessageProfileFallback()
+	if profile == nil {
+		content.BeeperPerMessageProfile = nil
+		return
+	}
+	profileCopy := *profile
+	profileCopy.HasFallback = false
+	content.BeeperPerMessageProfile = &profileCopy
+	if !addFallback || profile.Displayname == "" || !content.MsgType.IsText() {
+		return
+	}
+	content.EnsureHasHTML()
+	content.Body = fmt.Sprintf("%s: %s", profile.Displayname, content.Body)
+	content.FormattedBody = fmt.Sprintf(perMessageProfileFallbackHTML, html.EscapeString(profile.Displayname)) + content.FormattedBody
+	profileCopy.HasFallback = true
+}
+
+// RemovePerMessageProfileFallback removes the displayname prefix added by SetPerMessageProfile from the body.
+func (content *MessageEventContent) RemovePerMessageProfileFallback() {
+	profile := content.BeeperPerMessageProfile
+	if profile == nil || !profile.HasFallback || profile.Displayname == "" {
+		return
+	}
+	content.Body = strings.TrimPrefix(content.Body, profile.Displayname+": ")
+	if content.Format == FormatHTML {
+		content.FormattedBody = strings.TrimPrefix(content.FormattedBody, fmt.Sprintf(perMessageProfileFallbackHTML, html.EscapeString(profile.Displayname)))
+	}
+	profileCopy := *profile
+	profileCopy.HasFallback = false
+	content.BeeperPerMessageProfile = &profileCopy
 }
 
 type BeeperEncodedOrder struct {
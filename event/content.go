@@ -48,6 +48,7 @@ var TypeMap = map[Type]reflect.Type{
 	StateUnstablePolicyUser:   reflect.TypeOf(ModPolicyContent{}),
 
 	StateElementFunctionalMembers: reflect.TypeOf(ElementFunctionalMembersContent{}),
+	StateBeeperRoomFeatures:       reflect.TypeOf(RoomFeaturesEventContent{}),
 
 	EventMessage:   reflect.TypeOf(MessageEventContent{}),
 	EventSticker:   reflect.TypeOf(MessageEventContent{}),
@@ -57,6 +58,16 @@ var TypeMap = map[Type]reflect.Type{
 
 	EventUnstablePollStart:    reflect.TypeOf(PollStartEventContent{}),
 	EventUnstablePollResponse: reflect.TypeOf(PollResponseEventContent{}),
+	EventUnstablePollEnd:      reflect.TypeOf(PollEndEventContent{}),
+
+	EventExtensibleMessage:  reflect.TypeOf(ExtensibleEventContent{}),
+	EventExtensibleFile:     reflect.TypeOf(ExtensibleEventContent{}),
+	EventExtensibleImage:    reflect.TypeOf(ExtensibleEventContent{}),
+	EventExtensibleLocation: reflect.TypeOf(ExtensibleEventContent{}),
+
+	StateUnstableBeaconInfo: reflect.TypeOf(BeaconInfoEventContent{}),
+	StateUnstableImagePack:  reflect.TypeOf(ImagePackEventContent{}),
+	EventUnstableBeacon:     reflect.TypeOf(BeaconEventContent{}),
 
 	BeeperMessageStatus: reflect.TypeOf(BeeperMessageStatusEventContent{}),
 
@@ -67,6 +78,9 @@ var TypeMap = map[Type]reflect.Type{
 	AccountDataMarkedUnread:    reflect.TypeOf(MarkedUnreadEventContent{}),
 	AccountDataBeeperMute:      reflect.TypeOf(BeeperMuteEventContent{}),
 
+	AccountDataUnstableImagePack:      reflect.TypeOf(ImagePackEventContent{}),
+	AccountDataUnstableImagePackRooms: reflect.TypeOf(ImagePackRoomsEventContent{}),
+
 	EphemeralEventTyping:   reflect.TypeOf(TypingEventContent{}),
 	EphemeralEventReceipt:  reflect.TypeOf(ReceiptEventContent{}),
 	EphemeralEventPresence: reflect.TypeOf(PresenceEventContent{}),
@@ -397,6 +411,13 @@ func (content *Content) AsMessage() *MessageEventContent {
 	}
 	return casted
 }
+func (content *Content) AsExtensible() *ExtensibleEventContent {
+	casted, ok := content.Parsed.(*ExtensibleEventContent)
+	if !ok {
+		return &ExtensibleEventContent{}
+	}
+	return casted
+}
 func (content *Content) AsEncrypted() *EncryptedEventContent {
 	casted, ok := content.Parsed.(*EncryptedEventContent)
 	if !ok {
@@ -95,6 +95,9 @@ type RoomKeyEventContent struct {
 	SessionID  id.SessionID `json:"session_id"`
 	SessionKey string       `json:"session_key"`
 
+	// SharedHistory marks sessions that may be shared with users who join the room later (MSC3061).
+	SharedHistory bool `json:"org.matrix.msc3061.shared_history,omitempty"`
+
 	MaxAge      int64 `json:"com.beeper.max_age_ms"`
 	MaxMessages int   `json:"com.beeper.max_messages"`
 	IsScheduled bool  `json:"com.beeper.is_scheduled"`
@@ -148,6 +151,26 @@ const (
 	RoomKeyWithheldBeeperRedacted RoomKeyWithheldCode = "com.beeper.redacted"
 )
 
+// Description returns a human-readable explanation of the withheld code that can be shown to users.
+func (code RoomKeyWithheldCode) Description() string {
+	switch code {
+	case RoomKeyWithheldBlacklisted:
+		return "The sender has blocked this device"
+	case RoomKeyWithheldUnverified:
+		return "The sender only shares keys with verified devices"
+	case RoomKeyWithheldUnauthorized:
+		return "This device is not authorized to read the message"
+	case RoomKeyWithheldUnavailable:
+		return "The sender's device no longer has the key for the message"
+	case RoomKeyWithheldNoOlmSession:
+		return "The sender couldn't establish a secure channel with this device"
+	case RoomKeyWithheldBeeperRedacted:
+		return "The key for the message has been deleted"
+	default:
+		return "The sender has withheld the key for the message"
+	}
+}
+
 type RoomKeyWithheldEventContent struct {
 	RoomID    id.RoomID           `json:"room_id,omitempty"`
 	Algorithm id.Algorithm        `json:"algorithm"`
@@ -143,6 +143,7 @@ type Unsigned struct {
 	Relations       *Relations      `json:"m.relations,omitempty"`
 	RedactedBecause *Event          `json:"redacted_because,omitempty"`
 	InviteRoomState []StrippedState `json:"invite_room_state,omitempty"`
+	KnockRoomState  []StrippedState `json:"knock_room_state,omitempty"`
 
 	BeeperHSOrder       int64               `json:"com.beeper.hs.order,omitempty"`
 	BeeperHSSuborder    int16               `json:"com.beeper.hs.suborder,omitempty"`
@@ -152,6 +153,6 @@ type Unsigned struct {
 
 func (us *Unsigned) IsEmpty() bool {
 	return us.PrevContent == nil && us.PrevSender == "" && us.ReplacesState == "" && us.Age == 0 &&
-		us.TransactionID == "" && us.RedactedBecause == nil && us.InviteRoomState == nil && us.Relations == nil &&
+		us.TransactionID == "" && us.RedactedBecause == nil && us.InviteRoomState == nil && us.KnockRoomState == nil && us.Relations == nil &&
 		us.BeeperHSOrder == 0 && us.BeeperHSSuborder == 0 && us.BeeperHSOrderString.IsZero()
 }
@@ -24,10 +24,11 @@ const (
 	SourceEphemeral
 	SourceToDevice
 	SourceDecrypted
+	SourceKnock
 )
 
 const primaryTypes = SourcePresence | SourceAccountData | SourceToDevice | SourceTimeline | SourceState
-const roomSections = SourceJoin | SourceInvite | SourceLeave
+const roomSections = SourceJoin | SourceInvite | SourceLeave | SourceKnock
 const roomableTypes = SourceAccountData | SourceTimeline | SourceState
 const encryptableTypes = roomableTypes | SourceToDevice
 
@@ -55,6 +56,8 @@ func (es Source) String() string {
 			typeName = "invited room " + typeName
 		case SourceLeave:
 			typeName = "left room " + typeName
+		case SourceKnock:
+			typeName = "knocked room " + typeName
 		default:
 			return fmt.Sprintf("unknown (%s+%d)", typeName, es)
 		}
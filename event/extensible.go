@@ -0,0 +1,241 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package event
+
+import (
+	"maunium.net/go/mautrix/crypto/attachment"
+	"maunium.net/go/mautrix/id"
+)
+
+// TextRepresentation is a single representation of text in a m.text content block.
+type TextRepresentation struct {
+	MimeType string `json:"mimetype,omitempty"`
+	Body     string `json:"body"`
+}
+
+// TextBlock is a m.text content block as defined in MSC1767.
+//
+// https://github.com/matrix-org/matrix-spec-proposals/pull/1767
+type TextBlock []TextRepresentation
+
+// NewTextBlock creates a text block with the given plaintext and HTML representations.
+// The HTML representation is omitted if it's empty.
+func NewTextBlock(text, html string) TextBlock {
+	block := make(TextBlock, 0, 2)
+	if html != "" {
+		block = append(block, TextRepresentation{MimeType: "text/html", Body: html})
+	}
+	return append(block, TextRepresentation{MimeType: "text/plain", Body: text})
+}
+
+// Get returns the body of the representation with the given mimetype.
+// Representations without a mimetype are treated as text/plain.
+func (tb TextBlock) Get(mimeType string) (string, bool) {
+	for _, repr := range tb {
+		reprMime := repr.MimeType
+		if reprMime == "" {
+			reprMime = "text/plain"
+		}
+		if reprMime == mimeType {
+			return repr.Body, true
+		}
+	}
+	return "", false
+}
+
+// PlainText returns the plaintext representation, or the HTML representation converted to plaintext
+// if there's no plaintext one.
+func (tb TextBlock) PlainText() string {
+	if text, ok := tb.Get("text/plain"); ok {
+		return text
+	} else if html, ok := tb.Get("text/html"); ok {
+		return ReverseTextToHTML(html)
+	}
+	return ""
+}
+
+// HTML returns the HTML representation, or an empty string if there isn't one.
+func (tb TextBlock) HTML() string {
+	html, _ := tb.Get("text/html")
+	return html
+}
+
+// FileBlock is a m.file content block as defined in MSC3551.
+//
+// https://github.com/matrix-org/matrix-spec-proposals/pull/3551
+type FileBlock struct {
+	URL      id.ContentURIString `json:"url"`
+	Name     string              `json:"name"`
+	MimeType string              `json:"mimetype,omitempty"`
+	Size     int                 `json:"size,omitempty"`
+
+	// Encryption info for encrypted files. The fields are inlined in the file block.
+	*attachment.EncryptedFile
+}
+
+// ImageBlock is a m.image content block as defined in MSC3552.
+//
+// https://github.com/matrix-org/matrix-spec-proposals/pull/3552
+type ImageBlock struct {
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+}
+
+// CaptionBlock is a m.caption content block as defined in MSC3552.
+type CaptionBlock struct {
+	Text TextBlock `json:"m.text"`
+}
+
+// ExtensibleEventContent represents the content of m.message, m.file, m.image and m.location events
+// using the content blocks from MSC1767 and related proposals.
+type ExtensibleEventContent struct {
+	Text    TextBlock     `json:"m.text,omitempty"`
+	File    *FileBlock    `json:"m.file,omitempty"`
+	Image   *ImageBlock   `json:"m.image,omitempty"`
+	Caption *CaptionBlock `json:"m.caption,omitempty"`
+
+	Location  *LocationBlock `json:"m.location,omitempty"`
+	Asset     *AssetBlock    `json:"m.asset,omitempty"`
+	Timestamp int64          `json:"m.ts,omitempty"`
+
+	Mentions  *Mentions  `json:"m.mentions,omitempty"`
+	RelatesTo *RelatesTo `json:"m.relates_to,omitempty"`
+}
+
+func (content *ExtensibleEventContent) GetRelatesTo() *RelatesTo {
+	if content.RelatesTo == nil {
+		content.RelatesTo = &RelatesTo{}
+	}
+	return content.RelatesTo
+}
+
+func (content *ExtensibleEventContent) OptionalGetRelatesTo() *RelatesTo {
+	return content.RelatesTo
+}
+
+func (content *ExtensibleEventContent) SetRelatesTo(rel *RelatesTo) {
+	content.RelatesTo = rel
+}
+
+// EventType returns the extensible event type that matches the content blocks in the content.
+func (content *ExtensibleEventContent) EventType() Type {
+	if content.Location != nil {
+		return EventExtensibleLocation
+	} else if content.Image != nil {
+		return EventExtensibleImage
+	} else if content.File != nil {
+		return EventExtensibleFile
+	}
+	return EventExtensibleMessage
+}
+
+// ToLegacy converts the extensible event content into legacy m.room.message content,
+// which can be used as a fallback for clients that don't support extensible events.
+func (content *ExtensibleEventContent) ToLegacy() *MessageEventContent {
+	legacy := &MessageEventContent{
+		MsgType:   MsgText,
+		Mentions:  content.Mentions,
+		RelatesTo: content.RelatesTo,
+	}
+	text := content.Text
+	if content.Location != nil {
+		legacy.MsgType = MsgLocation
+		legacy.GeoURI = content.Location.URI
+		legacy.MSC3488Location = content.Location
+		legacy.MSC3488Asset = content.Asset
+		legacy.MSC3488Timestamp = content.Timestamp
+	} else if content.File != nil {
+		legacy.MsgType = MsgFile
+		if content.Image != nil {
+			legacy.MsgType = MsgImage
+		}
+		legacy.FileName = content.File.Name
+		legacy.Info = &FileInfo{
+			MimeType: content.File.MimeType,
+			Size:     content.File.Size,
+		}
+		if content.Image != nil {
+			legacy.Info.Width = content.Image.Width
+			legacy.Info.Height = content.Image.Height
+		}
+		if content.File.EncryptedFile != nil {
+			legacy.File = &EncryptedFileInfo{EncryptedFile: *content.File.EncryptedFile, URL: content.File.URL}
+		} else {
+			legacy.URL = content.File.URL
+		}
+		if content.Caption != nil {
+			text = content.Caption.Text
+		} else {
+			text = nil
+		}
+	}
+	legacy.Body = text.PlainText()
+	if html := text.HTML(); html != "" {
+		legacy.Format = FormatHTML
+		legacy.FormattedBody = html
+	}
+	if legacy.Body == "" && content.File != nil {
+		legacy.Body = content.File.Name
+	}
+	return legacy
+}
+
+// ToExtensible converts legacy m.room.message content into extensible event content.
+// The returned type is the extensible event type that should be used for the content.
+//
+// Only text-like (m.text, m.notice, m.emote), m.file, m.image and m.location messages are fully converted,
+// other message types are converted to m.message events with the body as text.
+func (content *MessageEventContent) ToExtensible() (Type, *ExtensibleEventContent) {
+	ext := &ExtensibleEventContent{
+		Mentions:  content.Mentions,
+		RelatesTo: content.RelatesTo,
+	}
+	var html string
+	if content.Format == FormatHTML {
+		html = content.FormattedBody
+	}
+	switch content.MsgType {
+	case MsgFile, MsgImage:
+		ext.File = &FileBlock{Name: content.GetFileName()}
+		if content.File != nil {
+			ext.File.URL = content.File.URL
+			encryptedFile := content.File.EncryptedFile
+			ext.File.EncryptedFile = &encryptedFile
+		} else {
+			ext.File.URL = content.URL
+		}
+		if content.Info != nil {
+			ext.File.MimeType = content.Info.MimeType
+			ext.File.Size = content.Info.Size
+		}
+		if content.MsgType == MsgImage {
+			ext.Image = &ImageBlock{}
+			if content.Info != nil {
+				ext.Image.Width = content.Info.Width
+				ext.Image.Height = content.Info.Height
+			}
+		}
+		if caption := content.GetCaption(); caption != "" {
+			ext.Caption = &CaptionBlock{Text: NewTextBlock(caption, content.GetFormattedCaption())}
+		}
+		ext.Text = NewTextBlock(content.Body, "")
+	case MsgLocation:
+		ext.Location = content.MSC3488Location
+		if ext.Location == nil {
+			ext.Location = &LocationBlock{URI: content.GeoURI}
+		}
+		ext.Asset = content.MSC3488Asset
+		if ext.Asset == nil {
+			ext.Asset = &AssetBlock{Type: AssetTypeSelf}
+		}
+		ext.Timestamp = content.MSC3488Timestamp
+		ext.Text = NewTextBlock(content.Body, html)
+	default:
+		ext.Text = NewTextBlock(content.Body, html)
+	}
+	return ext.EventType(), ext
+}
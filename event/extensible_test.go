@@ -0,0 +1,70 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package event_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const extensibleImageEvent = `{
+	"sender": "@tulir:maunium.net",
+	"type": "m.image",
+	"event_id": "$foo",
+	"room_id": "!bar",
+	"content": {
+		"m.text": [{"body": "cat.jpg"}],
+		"m.file": {"url": "mxc://example.com/cat", "name": "cat.jpg", "mimetype": "image/jpeg", "size": 1234},
+		"m.image": {"width": 640, "height": 480},
+		"m.caption": {"m.text": [{"mimetype": "text/html", "body": "<b>cute</b> cat"}, {"body": "cute cat"}]}
+	}
+}`
+
+func TestExtensibleEventContent_Parse(t *testing.T) {
+	var evt *event.Event
+	require.NoError(t, json.Unmarshal([]byte(extensibleImageEvent), &evt))
+	require.NoError(t, evt.Content.ParseRaw(evt.Type))
+	content := evt.Content.AsExtensible()
+	assert.Equal(t, event.EventExtensibleImage, content.EventType())
+	require.NotNil(t, content.File)
+	assert.Nil(t, content.File.EncryptedFile)
+	assert.Equal(t, 640, content.Image.Width)
+	assert.Equal(t, "cute cat", content.Caption.Text.PlainText())
+
+	legacy := content.ToLegacy()
+	assert.Equal(t, event.MsgImage, legacy.MsgType)
+	assert.Equal(t, "cute cat", legacy.Body)
+	assert.Equal(t, "<b>cute</b> cat", legacy.FormattedBody)
+	assert.Equal(t, "cat.jpg", legacy.FileName)
+	assert.Equal(t, id.ContentURIString("mxc://example.com/cat"), legacy.URL)
+	assert.Equal(t, 480, legacy.Info.Height)
+	assert.Equal(t, 1234, legacy.Info.Size)
+}
+
+func TestMessageEventContent_ToExtensible(t *testing.T) {
+	evtType, content := (&event.MessageEventContent{
+		MsgType:       event.MsgText,
+		Body:          "hello world",
+		Format:        event.FormatHTML,
+		FormattedBody: "<i>hello</i> world",
+	}).ToExtensible()
+	assert.Equal(t, event.EventExtensibleMessage, evtType)
+	data, err := json.Marshal(content)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"m.text": [{"mimetype": "text/html", "body": "<i>hello</i> world"}, {"mimetype": "text/plain", "body": "hello world"}]}`, string(data))
+
+	legacy := content.ToLegacy()
+	assert.Equal(t, event.MsgText, legacy.MsgType)
+	assert.Equal(t, "hello world", legacy.Body)
+	assert.Equal(t, "<i>hello</i> world", legacy.FormattedBody)
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package event
+
+import (
+	"slices"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// ImagePackUsage is a usage of an image in an image pack.
+type ImagePackUsage string
+
+const (
+	ImagePackUsageEmoticon ImagePackUsage = "emoticon"
+	ImagePackUsageSticker  ImagePackUsage = "sticker"
+)
+
+// ImagePackImage is a single image in an image pack.
+type ImagePackImage struct {
+	URL   id.ContentURIString `json:"url"`
+	Body  string              `json:"body,omitempty"`
+	Info  *FileInfo           `json:"info,omitempty"`
+	Usage []ImagePackUsage    `json:"usage,omitempty"`
+}
+
+// ImagePackInfo contains the metadata of an image pack.
+type ImagePackInfo struct {
+	DisplayName string              `json:"display_name,omitempty"`
+	AvatarURL   id.ContentURIString `json:"avatar_url,omitempty"`
+	Usage       []ImagePackUsage    `json:"usage,omitempty"`
+	Attribution string              `json:"attribution,omitempty"`
+}
+
+// ImagePackEventContent represents the content of a custom emoji and sticker pack (MSC2545), which is either
+// a im.ponies.room_emotes state event or im.ponies.user_emotes account data.
+//
+// https://github.com/matrix-org/matrix-spec-proposals/pull/2545
+type ImagePackEventContent struct {
+	Images map[string]*ImagePackImage `json:"images"`
+	Pack   ImagePackInfo              `json:"pack"`
+}
+
+// HasUsage returns true if the image can be used for the given purpose. If the image doesn't specify any usages,
+// the pack's usages are used. If neither specifies usages, the image can be used for anything.
+func (img *ImagePackImage) HasUsage(pack *ImagePackInfo, usage ImagePackUsage) bool {
+	usages := img.Usage
+	if len(usages) == 0 && pack != nil {
+		usages = pack.Usage
+	}
+	return len(usages) == 0 || slices.Contains(usages, usage)
+}
+
+// ImagePackRoomsEventContent represents the content of im.ponies.emote_rooms account data,
+// which lists the room image packs that the user has enabled globally.
+// The inner map is keyed by the state key of the pack.
+type ImagePackRoomsEventContent struct {
+	Rooms map[id.RoomID]map[string]struct{} `json:"rooms"`
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package event
+
+import (
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// LocationBlock is a m.location content block as defined in MSC3488.
+//
+// https://github.com/matrix-org/matrix-spec-proposals/pull/3488
+type LocationBlock struct {
+	// A geo URI (RFC 5870) of the location.
+	URI         string `json:"uri"`
+	Description string `json:"description,omitempty"`
+	// Optional zoom level hint for displaying the location on a map.
+	ZoomLevel int `json:"zoom_level,omitempty"`
+}
+
+type AssetType string
+
+const (
+	// AssetTypeSelf means the location is the sender's own location.
+	AssetTypeSelf AssetType = "m.self"
+	// AssetTypePin means the location is some other point of interest.
+	AssetTypePin AssetType = "m.pin"
+)
+
+// AssetBlock is a m.asset content block as defined in MSC3488, which describes what is being located.
+type AssetBlock struct {
+	Type AssetType `json:"type"`
+}
+
+// BeaconInfoEventContent represents the content of a live location beacon_info state event (MSC3672).
+// The state key is the user ID of the sharer, optionally followed by an underscore and a unique suffix.
+//
+// https://github.com/matrix-org/matrix-spec-proposals/pull/3672
+type BeaconInfoEventContent struct {
+	Description string `json:"description,omitempty"`
+	Live        bool   `json:"live"`
+	// How long the beacon is live for in milliseconds after Timestamp.
+	Timeout   int64       `json:"timeout"`
+	Timestamp int64       `json:"org.matrix.msc3488.ts"`
+	Asset     *AssetBlock `json:"org.matrix.msc3488.asset,omitempty"`
+}
+
+// ExpiresAt returns the time when the beacon stops being live.
+func (content *BeaconInfoEventContent) ExpiresAt() time.Time {
+	return time.UnixMilli(content.Timestamp + content.Timeout)
+}
+
+// IsActive returns true if the beacon is live and hasn't expired at the given time.
+func (content *BeaconInfoEventContent) IsActive(now time.Time) bool {
+	return content.Live && now.Before(content.ExpiresAt())
+}
+
+// BeaconEventContent represents the content of a live location update (MSC3672),
+// which references the beacon_info state event it belongs to.
+type BeaconEventContent struct {
+	RelatesTo RelatesTo     `json:"m.relates_to"`
+	Location  LocationBlock `json:"org.matrix.msc3488.location"`
+	Timestamp int64         `json:"org.matrix.msc3488.ts"`
+}
+
+// BeaconInfoEventID returns the ID of the beacon_info event that this update belongs to.
+func (content *BeaconEventContent) BeaconInfoEventID() id.EventID {
+	return content.RelatesTo.GetReferenceID()
+}
+
+func (content *BeaconEventContent) GetRelatesTo() *RelatesTo {
+	return &content.RelatesTo
+}
+
+func (content *BeaconEventContent) OptionalGetRelatesTo() *RelatesTo {
+	if content.RelatesTo.Type == "" {
+		return nil
+	}
+	return &content.RelatesTo
+}
+
+func (content *BeaconEventContent) SetRelatesTo(rel *RelatesTo) {
+	content.RelatesTo = *rel
+}
@@ -140,6 +140,11 @@ type MessageEventContent struct {
 
 	MSC1767Audio *MSC1767Audio `json:"org.matrix.msc1767.audio,omitempty"`
 	MSC3245Voice *MSC3245Voice `json:"org.matrix.msc3245.voice,omitempty"`
+
+	// Extensible location fields for m.location messages (MSC3488)
+	MSC3488Location  *LocationBlock `json:"org.matrix.msc3488.location,omitempty"`
+	MSC3488Asset     *AssetBlock    `json:"org.matrix.msc3488.asset,omitempty"`
+	MSC3488Timestamp int64          `json:"org.matrix.msc3488.ts,omitempty"`
 }
 
 func (content *MessageEventContent) GetFileName() string {
@@ -244,6 +249,64 @@ func (m *Mentions) Has(userID id.UserID) bool {
 	return m != nil && slices.Contains(m.UserIDs, userID)
 }
 
+// NewMentions creates a new intentional mentions object that mentions the given users.
+func NewMentions(userIDs ...id.UserID) *Mentions {
+	m := &Mentions{}
+	for _, userID := range userIDs {
+		m.Add(userID)
+	}
+	return m
+}
+
+// IsEmpty returns true if the mentions object doesn't mention anyone.
+func (m *Mentions) IsEmpty() bool {
+	return m == nil || (len(m.UserIDs) == 0 && !m.Room)
+}
+
+// Merge adds all users and the room mention from the other mentions object.
+func (m *Mentions) Merge(other *Mentions) {
+	if other == nil {
+		return
+	}
+	for _, userID := range other.UserIDs {
+		m.Add(userID)
+	}
+	m.Room = m.Room || other.Room
+}
+
+// Diff returns the mentions that are present in m, but weren't present in the previous mentions object.
+// If m is nil, the return value is also nil.
+func (m *Mentions) Diff(previous *Mentions) *Mentions {
+	if m == nil {
+		return nil
+	}
+	diff := &Mentions{Room: m.Room && (previous == nil || !previous.Room)}
+	for _, userID := range m.UserIDs {
+		if !previous.Has(userID) {
+			diff.UserIDs = append(diff.UserIDs, userID)
+		}
+	}
+	return diff
+}
+
+// SetEditMentions updates the mentions of an edit made with SetEdit, so that only users who weren't mentioned
+// in the previous version of the message are notified. The full list of mentions is kept in m.new_content.
+func (content *MessageEventContent) SetEditMentions(previous *Mentions) {
+	if content.NewContent == nil {
+		return
+	}
+	content.Mentions = content.NewContent.Mentions.Diff(previous)
+}
+
+// StripMentions replaces the mentions with an empty object, which ensures that the message doesn't notify anyone.
+// This should be used when forwarding or otherwise copying messages.
+func (content *MessageEventContent) StripMentions() {
+	content.Mentions = &Mentions{}
+	if content.NewContent != nil {
+		content.NewContent.Mentions = &Mentions{}
+	}
+}
+
 type EncryptedFileInfo struct {
 	attachment.EncryptedFile
 	URL id.ContentURIString `json:"url"`
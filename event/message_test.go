@@ -9,6 +9,7 @@ package event_test
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -166,3 +167,81 @@ func TestMessageEventContent__Marshal_Custom(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, expectedCustomMarshalResult, string(data))
 }
+
+func TestMessageEventContent_MakeVoiceMessage(t *testing.T) {
+	content := &event.MessageEventContent{
+		URL:  "mxc://example.com/voice",
+		Info: &event.FileInfo{MimeType: "audio/ogg", Size: 1234},
+	}
+	content.MakeVoiceMessage(65*time.Second, event.NormalizeWaveform([]int{0, 50, 100, 100}, 100))
+	assert.True(t, content.IsVoiceMessage())
+	assert.Equal(t, "Voice message (1:05)", content.Body)
+	data, err := json.Marshal(content)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"msgtype": "m.audio",
+		"body": "Voice message (1:05)",
+		"url": "mxc://example.com/voice",
+		"info": {"mimetype": "audio/ogg", "size": 1234, "duration": 65000},
+		"org.matrix.msc1767.audio": {"duration": 65000, "waveform": [0, 512, 1024, 1024]},
+		"org.matrix.msc3245.voice": {}
+	}`, string(data))
+}
+
+func TestNormalizeWaveform_Downsample(t *testing.T) {
+	waveform := make([]int, 250)
+	for i := range waveform {
+		waveform[i] = 255
+	}
+	normalized := event.NormalizeWaveform(waveform, 255)
+	assert.Len(t, normalized, event.MaxWaveformLength)
+	assert.Equal(t, event.MaxWaveformValue, normalized[0])
+}
+
+func TestMessageEventContent_Mentions(t *testing.T) {
+	content := &event.MessageEventContent{MsgType: event.MsgText, Body: "hi @bob and @carol"}
+	content.SetReply(&event.Event{ID: "$original", Sender: "@alice:example.com"})
+	assert.Nil(t, content.Mentions, "SetReply shouldn't add mentions to content without them")
+	content.SetReplyWithMention(&event.Event{ID: "$original", Sender: "@alice:example.com"})
+	content.Mentions.Merge(event.NewMentions("@bob:example.com", "@carol:example.com"))
+	assert.Equal(t, []id.UserID{"@alice:example.com", "@bob:example.com", "@carol:example.com"}, content.Mentions.UserIDs)
+
+	edit := &event.MessageEventContent{MsgType: event.MsgText, Body: "hi @carol and @dave", Mentions: event.NewMentions("@carol:example.com", "@dave:example.com")}
+	edit.SetEdit("$message")
+	edit.SetEditMentions(content.Mentions)
+	assert.Equal(t, []id.UserID{"@dave:example.com"}, edit.Mentions.UserIDs)
+	assert.Equal(t, []id.UserID{"@carol:example.com", "@dave:example.com"}, edit.NewContent.Mentions.UserIDs)
+
+	edit.StripMentions()
+	assert.True(t, edit.Mentions.IsEmpty())
+	assert.True(t, edit.NewContent.Mentions.IsEmpty())
+	assert.NotNil(t, edit.Mentions)
+}
+
+func TestMessageEventContent_SetPerMessageProfile(t *testing.T) {
+	avatar := id.ContentURIString("mxc://example.com/avatar")
+	content := &event.MessageEventContent{MsgType: event.MsgText, Body: "hello <world>"}
+	profile := &event.BeeperPerMessageProfile{ID: "alt", Displayname: "Alice & co", AvatarURL: &avatar}
+	content.SetPerMessageProfile(profile, true)
+	assert.Equal(t, "Alice & co: hello <world>", content.Body)
+	assert.Equal(t, "<strong data-mx-profile-fallback>Alice &amp; co: </strong>hello &lt;world&gt;", content.FormattedBody)
+	assert.True(t, content.BeeperPerMessageProfile.HasFallback)
+	assert.False(t, profile.HasFallback, "the caller's profile shouldn't be modified")
+
+	// Reusing the profile for another message must add the fallback there too
+	other := &event.MessageEventContent{MsgType: event.MsgText, Body: "second"}
+	other.SetPerMessageProfile(profile, true)
+	assert.Equal(t, "Alice & co: second", other.Body)
+	other.RemovePerMessageProfileFallback()
+	assert.Equal(t, "second", other.Body)
+	assert.True(t, content.BeeperPerMessageProfile.HasFallback)
+
+	member := content.BeeperPerMessageProfile.Apply(&event.MemberEventContent{Membership: event.MembershipJoin, Displayname: "alice"})
+	assert.Equal(t, "Alice & co", member.Displayname)
+	assert.Equal(t, avatar, member.AvatarURL)
+	assert.Equal(t, event.MembershipJoin, member.Membership)
+
+	content.RemovePerMessageProfileFallback()
+	assert.Equal(t, "hello <world>", content.Body)
+	assert.Equal(t, "hello &lt;world&gt;", content.FormattedBody)
+}
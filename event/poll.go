@@ -6,11 +6,26 @@
 
 package event
 
+import (
+	"slices"
+
+	"maunium.net/go/mautrix/id"
+)
+
+type PollKind string
+
+const (
+	PollKindDisclosed   PollKind = "org.matrix.msc3381.poll.disclosed"
+	PollKindUndisclosed PollKind = "org.matrix.msc3381.poll.undisclosed"
+)
+
+type PollResponse struct {
+	Answers []string `json:"answers"`
+}
+
 type PollResponseEventContent struct {
-	RelatesTo RelatesTo `json:"m.relates_to"`
-	Response  struct {
-		Answers []string `json:"answers"`
-	} `json:"org.matrix.msc3381.poll.response"`
+	RelatesTo RelatesTo    `json:"m.relates_to"`
+	Response  PollResponse `json:"org.matrix.msc3381.poll.response"`
 }
 
 func (content *PollResponseEventContent) GetRelatesTo() *RelatesTo {
@@ -37,18 +52,22 @@ type MSC1767Message struct {
 	} `json:"org.matrix.msc1767.message,omitempty"`
 }
 
+type PollAnswer struct {
+	ID string `json:"id"`
+	MSC1767Message
+}
+
+type PollStart struct {
+	Kind          PollKind       `json:"kind"`
+	MaxSelections int            `json:"max_selections"`
+	Question      MSC1767Message `json:"question"`
+	Answers       []PollAnswer   `json:"answers"`
+}
+
 type PollStartEventContent struct {
 	RelatesTo *RelatesTo `json:"m.relates_to"`
 	Mentions  *Mentions  `json:"m.mentions,omitempty"`
-	PollStart struct {
-		Kind          string         `json:"kind"`
-		MaxSelections int            `json:"max_selections"`
-		Question      MSC1767Message `json:"question"`
-		Answers       []struct {
-			ID string `json:"id"`
-			MSC1767Message
-		} `json:"answers"`
-	} `json:"org.matrix.msc3381.poll.start"`
+	PollStart PollStart  `json:"org.matrix.msc3381.poll.start"`
 }
 
 func (content *PollStartEventContent) GetRelatesTo() *RelatesTo {
@@ -65,3 +84,126 @@ func (content *PollStartEventContent) OptionalGetRelatesTo() *RelatesTo {
 func (content *PollStartEventContent) SetRelatesTo(rel *RelatesTo) {
 	content.RelatesTo = rel
 }
+
+type PollEndEventContent struct {
+	RelatesTo RelatesTo `json:"m.relates_to"`
+	PollEnd   struct{}  `json:"org.matrix.msc3381.poll.end"`
+	Text      string    `json:"org.matrix.msc1767.text,omitempty"`
+}
+
+func (content *PollEndEventContent) GetRelatesTo() *RelatesTo {
+	return &content.RelatesTo
+}
+
+func (content *PollEndEventContent) OptionalGetRelatesTo() *RelatesTo {
+	if content.RelatesTo.Type == "" {
+		return nil
+	}
+	return &content.RelatesTo
+}
+
+func (content *PollEndEventContent) SetRelatesTo(rel *RelatesTo) {
+	content.RelatesTo = *rel
+}
+
+// PollTally contains the aggregated results of a poll.
+type PollTally struct {
+	// The number of votes for each answer ID.
+	Counts map[string]int
+	// The answers that each user's latest valid response selected. Users whose latest response was spoiled
+	// (i.e. didn't contain any valid answers) are included with an empty list.
+	Votes map[id.UserID][]string
+	// The end event, if the poll has been ended.
+	EndEvent *Event
+	// Whether the poll is undisclosed, i.e. results shouldn't be shown before the poll is ended.
+	Undisclosed bool
+}
+
+// Ended returns true if the poll has been ended.
+func (pt *PollTally) Ended() bool {
+	return pt.EndEvent != nil
+}
+
+// ShouldShowResults returns true if the poll results can be displayed to users,
+// which is the case for disclosed polls and ended undisclosed polls.
+func (pt *PollTally) ShouldShowResults() bool {
+	return !pt.Undisclosed || pt.Ended()
+}
+
+// TotalVotes returns the number of users who have a non-spoiled vote in the poll.
+func (pt *PollTally) TotalVotes() (total int) {
+	for _, answers := range pt.Votes {
+		if len(answers) > 0 {
+			total++
+		}
+	}
+	return
+}
+
+// TallyPoll aggregates the responses to a poll according to the rules in MSC3381.
+//
+// The relations should contain all (decrypted) events that reference the poll start event, e.g. from /relations.
+// Only the latest response from each user is counted, invalid answers are ignored, excess answers beyond
+// max_selections are dropped, and responses sent after the poll was ended by its creator are ignored.
+// End events from other users are ignored, as checking whether they have permission to end the poll
+// requires the room's power levels.
+func TallyPoll(start *Event, relations []*Event) *PollTally {
+	_ = start.Content.ParseRaw(start.Type)
+	startContent, ok := start.Content.Parsed.(*PollStartEventContent)
+	if !ok {
+		startContent = &PollStartEventContent{}
+	}
+	poll := &startContent.PollStart
+	tally := &PollTally{
+		Counts:      make(map[string]int, len(poll.Answers)),
+		Votes:       make(map[id.UserID][]string),
+		Undisclosed: poll.Kind == PollKindUndisclosed,
+	}
+	validAnswers := make(map[string]struct{}, len(poll.Answers))
+	for _, answer := range poll.Answers {
+		validAnswers[answer.ID] = struct{}{}
+		tally.Counts[answer.ID] = 0
+	}
+	maxSelections := max(poll.MaxSelections, 1)
+
+	for _, evt := range relations {
+		if evt.Type == EventUnstablePollEnd && evt.Sender == start.Sender {
+			if tally.EndEvent == nil || evt.Timestamp < tally.EndEvent.Timestamp {
+				tally.EndEvent = evt
+			}
+		}
+	}
+	latestResponses := make(map[id.UserID]*Event)
+	for _, evt := range relations {
+		if evt.Type != EventUnstablePollResponse {
+			continue
+		} else if tally.EndEvent != nil && evt.Timestamp > tally.EndEvent.Timestamp {
+			continue
+		} else if existing, ok := latestResponses[evt.Sender]; ok && existing.Timestamp >= evt.Timestamp {
+			continue
+		}
+		latestResponses[evt.Sender] = evt
+	}
+	for userID, evt := range latestResponses {
+		_ = evt.Content.ParseRaw(evt.Type)
+		content, ok := evt.Content.Parsed.(*PollResponseEventContent)
+		if !ok {
+			continue
+		}
+		answers := make([]string, 0, maxSelections)
+		for _, answer := range content.Response.Answers {
+			if _, valid := validAnswers[answer]; !valid || slices.Contains(answers, answer) {
+				continue
+			}
+			answers = append(answers, answer)
+			if len(answers) >= maxSelections {
+				break
+			}
+		}
+		tally.Votes[userID] = answers
+		for _, answer := range answers {
+			tally.Counts[answer]++
+		}
+	}
+	return tally
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package event_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func makePollEvent(t *testing.T, evtType event.Type, sender id.UserID, ts int64, content any) *event.Event {
+	data, err := json.Marshal(content)
+	require.NoError(t, err)
+	return &event.Event{
+		Type:      evtType,
+		Sender:    sender,
+		Timestamp: ts,
+		Content:   event.Content{VeryRaw: data},
+	}
+}
+
+func makePollResponse(t *testing.T, sender id.UserID, ts int64, answers ...string) *event.Event {
+	return makePollEvent(t, event.EventUnstablePollResponse, sender, ts, map[string]any{
+		"m.relates_to":                     map[string]any{"rel_type": "m.reference", "event_id": "$poll"},
+		"org.matrix.msc3381.poll.response": map[string]any{"answers": answers},
+	})
+}
+
+func TestTallyPoll(t *testing.T) {
+	start := makePollEvent(t, event.EventUnstablePollStart, "@creator:example.com", 1000, map[string]any{
+		"org.matrix.msc3381.poll.start": map[string]any{
+			"kind":           event.PollKindUndisclosed,
+			"max_selections": 2,
+			"question":       map[string]any{"org.matrix.msc1767.text": "Which?"},
+			"answers":        []map[string]any{{"id": "a"}, {"id": "b"}, {"id": "c"}},
+		},
+	})
+	relations := []*event.Event{
+		makePollResponse(t, "@alice:example.com", 1100, "a"),
+		makePollResponse(t, "@alice:example.com", 1200, "b", "c", "a"),
+		makePollResponse(t, "@bob:example.com", 1300, "a", "invalid"),
+		makePollResponse(t, "@carol:example.com", 1400, "invalid"),
+		makePollResponse(t, "@dave:example.com", 1150, "c"),
+		makePollResponse(t, "@dave:example.com", 2500, "a"),
+		makePollEvent(t, event.EventUnstablePollEnd, "@mallory:example.com", 1250, map[string]any{}),
+	}
+
+	tally := event.TallyPoll(start, relations)
+	assert.False(t, tally.Ended())
+	assert.False(t, tally.ShouldShowResults())
+	assert.Equal(t, map[string]int{"a": 2, "b": 1, "c": 1}, tally.Counts)
+
+	relations = append(relations, makePollEvent(t, event.EventUnstablePollEnd, "@creator:example.com", 2000, map[string]any{}))
+	tally = event.TallyPoll(start, relations)
+	assert.True(t, tally.ShouldShowResults())
+	assert.Equal(t, map[string]int{"a": 1, "b": 1, "c": 2}, tally.Counts)
+	assert.Equal(t, []string{"b", "c"}, tally.Votes["@alice:example.com"])
+	assert.Empty(t, tally.Votes["@carol:example.com"])
+	assert.Equal(t, 3, tally.TotalVotes())
+}
@@ -198,12 +198,26 @@ func (ec *EventIDChunk) Serialize(typ RelationType) RelationChunk {
 	return ec.RelationChunk
 }
 
+// ThreadSummary is the bundled aggregation of m.thread relations on a thread root event.
+// See https://spec.matrix.org/v1.13/client-server-api/#server-side-aggregation-of-mthread-relationships
+type ThreadSummary struct {
+	LatestEvent             *Event `json:"latest_event"`
+	Count                   int    `json:"count"`
+	CurrentUserParticipated bool   `json:"current_user_participated"`
+}
+
 type Relations struct {
 	Raw map[RelationType]RelationChunk `json:"-"`
 
 	Annotations AnnotationChunk `json:"m.annotation,omitempty"`
 	References  EventIDChunk    `json:"m.reference,omitempty"`
-	Replaces    EventIDChunk    `json:"m.replace,omitempty"`
+	// Replaces contains the m.replace aggregation in the legacy chunk format (MSC2675).
+	Replaces EventIDChunk   `json:"m.replace,omitempty"`
+	Thread   *ThreadSummary `json:"m.thread,omitempty"`
+
+	// LatestEdit is the most recent edit of the event, which is bundled under m.replace in the current spec.
+	// See https://spec.matrix.org/v1.13/client-server-api/#server-side-aggregation-of-mreplace-relationships
+	LatestEdit *Event `json:"-"`
 }
 
 type serializableRelations Relations
@@ -212,7 +226,21 @@ func (relations *Relations) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, &relations.Raw); err != nil {
 		return err
 	}
-	return json.Unmarshal(data, (*serializableRelations)(relations))
+	if err := json.Unmarshal(data, (*serializableRelations)(relations)); err != nil {
+		return err
+	}
+	var replace struct {
+		LatestEdit *Event `json:"m.replace"`
+	}
+	if err := json.Unmarshal(data, &replace); err != nil {
+		return err
+	}
+	// The legacy chunk format will also unmarshal into an event, so only keep it if it actually looks like one
+	if replace.LatestEdit != nil && replace.LatestEdit.ID != "" {
+		relations.LatestEdit = replace.LatestEdit
+		delete(relations.Raw, RelReplace)
+	}
+	return nil
 }
 
 func (relations *Relations) MarshalJSON() ([]byte, error) {
@@ -222,6 +250,7 @@ func (relations *Relations) MarshalJSON() ([]byte, error) {
 	relations.Raw[RelAnnotation] = relations.Annotations.Serialize()
 	relations.Raw[RelReference] = relations.References.Serialize(RelReference)
 	relations.Raw[RelReplace] = relations.Replaces.Serialize(RelReplace)
+	delete(relations.Raw, RelThread)
 	for key, item := range relations.Raw {
 		if !item.Limited {
 			item.Count = len(item.Chunk)
@@ -230,5 +259,18 @@ func (relations *Relations) MarshalJSON() ([]byte, error) {
 			delete(relations.Raw, key)
 		}
 	}
+	if relations.Thread != nil || relations.LatestEdit != nil {
+		output := make(map[RelationType]any, len(relations.Raw)+2)
+		for key, item := range relations.Raw {
+			output[key] = item
+		}
+		if relations.Thread != nil {
+			output[RelThread] = relations.Thread
+		}
+		if relations.LatestEdit != nil {
+			output[RelReplace] = relations.LatestEdit
+		}
+		return json.Marshal(output)
+	}
 	return json.Marshal(relations.Raw)
 }
@@ -0,0 +1,79 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package event_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const bundledAggregationsEvent = `{
+	"type": "m.room.message",
+	"event_id": "$root",
+	"sender": "@alice:example.com",
+	"content": {"msgtype": "m.text", "body": "hello"},
+	"unsigned": {
+		"m.relations": {
+			"m.thread": {
+				"latest_event": {"type": "m.room.message", "event_id": "$reply", "sender": "@bob:example.com", "content": {"msgtype": "m.text", "body": "hi"}},
+				"count": 3,
+				"current_user_participated": true
+			},
+			"m.replace": {
+				"type": "m.room.message",
+				"event_id": "$edit",
+				"sender": "@alice:example.com",
+				"content": {"msgtype": "m.text", "body": "* hello!", "m.new_content": {"msgtype": "m.text", "body": "hello!"}, "m.relates_to": {"rel_type": "m.replace", "event_id": "$root"}}
+			},
+			"m.reference": {"chunk": [{"event_id": "$ref1"}, {"event_id": "$ref2"}]},
+			"m.annotation": {"chunk": [{"type": "m.reaction", "key": "👍", "count": 2}]}
+		}
+	}
+}`
+
+func TestRelations_BundledAggregations(t *testing.T) {
+	var evt event.Event
+	require.NoError(t, json.Unmarshal([]byte(bundledAggregationsEvent), &evt))
+	rel := evt.Unsigned.Relations
+	require.NotNil(t, rel)
+
+	require.NotNil(t, rel.Thread)
+	assert.Equal(t, 3, rel.Thread.Count)
+	assert.True(t, rel.Thread.CurrentUserParticipated)
+	assert.Equal(t, id.EventID("$reply"), rel.Thread.LatestEvent.ID)
+
+	require.NotNil(t, rel.LatestEdit)
+	assert.Equal(t, id.EventID("$edit"), rel.LatestEdit.ID)
+	require.NoError(t, rel.LatestEdit.Content.ParseRaw(rel.LatestEdit.Type))
+	assert.Equal(t, "hello!", rel.LatestEdit.Content.AsMessage().NewContent.Body)
+	assert.Empty(t, rel.Replaces.List)
+
+	assert.Equal(t, []string{"$ref1", "$ref2"}, rel.References.List)
+	assert.Equal(t, map[string]int{"👍": 2}, rel.Annotations.Map)
+
+	data, err := json.Marshal(&evt)
+	require.NoError(t, err)
+	var roundtrip event.Event
+	require.NoError(t, json.Unmarshal(data, &roundtrip))
+	require.NotNil(t, roundtrip.Unsigned.Relations.LatestEdit)
+	assert.Equal(t, id.EventID("$edit"), roundtrip.Unsigned.Relations.LatestEdit.ID)
+	assert.Equal(t, 3, roundtrip.Unsigned.Relations.Thread.Count)
+	assert.Equal(t, []string{"$ref1", "$ref2"}, roundtrip.Unsigned.Relations.References.List)
+}
+
+func TestRelations_LegacyReplaceChunk(t *testing.T) {
+	var rel event.Relations
+	require.NoError(t, json.Unmarshal([]byte(`{"m.replace": {"chunk": [{"type": "m.replace", "event_id": "$edit"}], "count": 1}}`), &rel))
+	assert.Nil(t, rel.LatestEdit)
+	assert.Equal(t, []string{"$edit"}, rel.Replaces.List)
+}
@@ -46,6 +46,20 @@ func (content *MessageEventContent) GetReplyTo() id.EventID {
 	return content.RelatesTo.GetReplyTo()
 }
 
+// SetReply makes the content a reply to the given event. If the content already has intentional mentions,
+// the sender of the replied-to event is added to them. Use SetReplyWithMention to always mention the sender.
 func (content *MessageEventContent) SetReply(inReplyTo *Event) {
 	content.RelatesTo = (&RelatesTo{}).SetReplyTo(inReplyTo.ID)
+	if content.Mentions != nil {
+		content.Mentions.Add(inReplyTo.Sender)
+	}
+}
+
+// SetReplyWithMention makes the content a reply to the given event and adds the sender of that event
+// to the intentional mentions, creating the mentions object if necessary.
+func (content *MessageEventContent) SetReplyWithMention(inReplyTo *Event) {
+	if content.Mentions == nil {
+		content.Mentions = &Mentions{}
+	}
+	content.SetReply(inReplyTo)
 }
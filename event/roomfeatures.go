@@ -0,0 +1,52 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package event
+
+// RoomFeaturesEventContent represents the content of a com.beeper.room_features state event.
+//
+// Bridges use it to tell clients what the remote network supports in the room,
+// so that clients can e.g. hide the edit button or limit message length.
+type RoomFeaturesEventContent struct {
+	// The message types that can be bridged to the remote network.
+	MessageTypes []MessageType `json:"message_types"`
+	// Size and mime type limits for file messages. The map key is the message type.
+	Files map[MessageType]*RoomFeaturesFileLimits `json:"files,omitempty"`
+
+	FormattedText    bool `json:"formatted_text,omitempty"`
+	UserMentions     bool `json:"user_mentions,omitempty"`
+	RoomMentions     bool `json:"room_mentions,omitempty"`
+	Captions         bool `json:"captions,omitempty"`
+	Polls            bool `json:"polls,omitempty"`
+	MaxTextLength    int  `json:"max_text_length,omitempty"`
+	MaxCaptionLength int  `json:"max_caption_length,omitempty"`
+
+	Threads bool `json:"threads,omitempty"`
+	Replies bool `json:"replies,omitempty"`
+
+	Edits bool `json:"edits,omitempty"`
+	// The maximum number of times a message can be edited.
+	EditMaxCount int `json:"edit_max_count,omitempty"`
+	// The maximum age of a message that can be edited, in seconds.
+	EditMaxAge int64 `json:"edit_max_age,omitempty"`
+
+	Deletes bool `json:"deletes,omitempty"`
+	// The maximum age of a message that can be deleted, in seconds.
+	DeleteMaxAge int64 `json:"delete_max_age,omitempty"`
+
+	ReadReceipts bool `json:"read_receipts,omitempty"`
+
+	Reactions bool `json:"reactions,omitempty"`
+	// The maximum number of reactions a single user can add to a message.
+	ReactionCount int `json:"reaction_count,omitempty"`
+	// If set, only these reactions are allowed.
+	AllowedReactions []string `json:"allowed_reactions,omitempty"`
+}
+
+type RoomFeaturesFileLimits struct {
+	MaxSize   int64    `json:"max_size,omitempty"`
+	MimeTypes []string `json:"mime_types,omitempty"`
+}
@@ -7,6 +7,10 @@
 package event
 
 import (
+	"net"
+
+	"go.mau.fi/util/glob"
+
 	"maunium.net/go/mautrix/id"
 )
 
@@ -39,6 +43,32 @@ type ServerACLEventContent struct {
 	Deny            []string `json:"deny,omitempty"`
 }
 
+// IsAllowed checks whether the given server is allowed to participate in the room according to the ACL.
+// The port of the server name is ignored, as specified in https://spec.matrix.org/v1.11/client-server-api/#server-access-control-lists-acls-for-rooms
+func (acl *ServerACLEventContent) IsAllowed(serverName string) bool {
+	if acl == nil {
+		return true
+	}
+	host, _, err := id.ParseServerName(serverName)
+	if err != nil {
+		return false
+	}
+	if !acl.AllowIPLiterals && net.ParseIP(host) != nil {
+		return false
+	}
+	for _, pattern := range acl.Deny {
+		if glob.Compile(pattern).Match(host) {
+			return false
+		}
+	}
+	for _, pattern := range acl.Allow {
+		if glob.Compile(pattern).Match(host) {
+			return true
+		}
+	}
+	return false
+}
+
 // TopicEventContent represents the content of a m.room.topic state event.
 // https://spec.matrix.org/v1.2/client-server-api/#mroomtopic
 type TopicEventContent struct {
@@ -71,8 +101,20 @@ const (
 	RoomV9  RoomVersion = "9"
 	RoomV10 RoomVersion = "10"
 	RoomV11 RoomVersion = "11"
+	RoomV12 RoomVersion = "12"
 )
 
+// PrivilegedCreators returns true if the room creators have infinite power level in the room version
+// and can't be demoted, kicked or banned (room version 12 onwards).
+func (rv RoomVersion) PrivilegedCreators() bool {
+	switch rv {
+	case "", RoomV1, RoomV2, RoomV3, RoomV4, RoomV5, RoomV6, RoomV7, RoomV8, RoomV9, RoomV10, RoomV11:
+		return false
+	default:
+		return true
+	}
+}
+
 // CreateEventContent represents the content of a m.room.create state event.
 // https://spec.matrix.org/v1.2/client-server-api/#mroomcreate
 type CreateEventContent struct {
@@ -81,6 +123,9 @@ type CreateEventContent struct {
 	Federate    bool         `json:"m.federate,omitempty"`
 	RoomVersion RoomVersion  `json:"room_version,omitempty"`
 	Predecessor *Predecessor `json:"predecessor,omitempty"`
+
+	// Only present in room version 12 onwards. The sender of the create event is always a creator too.
+	AdditionalCreators []id.UserID `json:"additional_creators,omitempty"`
 }
 
 // JoinRule specifies how open a room is to new members.
@@ -0,0 +1,40 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package event_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"maunium.net/go/mautrix/event"
+)
+
+func TestServerACLEventContent_IsAllowed(t *testing.T) {
+	acl := &event.ServerACLEventContent{
+		Allow: []string{"*"},
+		Deny:  []string{"evil.example", "*.evil.example", "bad?.example"},
+	}
+	assert.True(t, acl.IsAllowed("example.com"))
+	assert.True(t, acl.IsAllowed("example.com:8448"))
+	assert.False(t, acl.IsAllowed("evil.example"))
+	assert.False(t, acl.IsAllowed("evil.example:443"))
+	assert.False(t, acl.IsAllowed("sub.evil.example"))
+	assert.False(t, acl.IsAllowed("bad1.example"))
+	assert.True(t, acl.IsAllowed("bad12.example"))
+	assert.False(t, acl.IsAllowed("1.2.3.4"))
+	assert.False(t, acl.IsAllowed("[::1]:8448"))
+	acl.AllowIPLiterals = true
+	assert.True(t, acl.IsAllowed("1.2.3.4"))
+	assert.True(t, acl.IsAllowed("[::1]:8448"))
+
+	acl = &event.ServerACLEventContent{Allow: []string{"*.example.com"}}
+	assert.True(t, acl.IsAllowed("matrix.example.com"))
+	assert.False(t, acl.IsAllowed("example.org"))
+	assert.False(t, (&event.ServerACLEventContent{}).IsAllowed("example.com"))
+	assert.True(t, (*event.ServerACLEventContent)(nil).IsAllowed("example.com"))
+}
@@ -112,7 +112,8 @@ func (et *Type) GuessClass() TypeClass {
 		StatePowerLevels.Type, StateRoomName.Type, StateRoomAvatar.Type, StateServerACL.Type, StateTopic.Type,
 		StatePinnedEvents.Type, StateTombstone.Type, StateEncryption.Type, StateBridge.Type, StateHalfShotBridge.Type,
 		StateSpaceParent.Type, StateSpaceChild.Type, StatePolicyRoom.Type, StatePolicyServer.Type, StatePolicyUser.Type,
-		StateInsertionMarker.Type, StateElementFunctionalMembers.Type:
+		StateInsertionMarker.Type, StateElementFunctionalMembers.Type, StateBeeperRoomFeatures.Type,
+		StateUnstableBeaconInfo.Type, StateUnstableImagePack.Type:
 		return StateEventType
 	case EphemeralEventReceipt.Type, EphemeralEventTyping.Type, EphemeralEventPresence.Type:
 		return EphemeralEventType
@@ -120,13 +121,16 @@ func (et *Type) GuessClass() TypeClass {
 		AccountDataFullyRead.Type, AccountDataIgnoredUserList.Type, AccountDataMarkedUnread.Type,
 		AccountDataSecretStorageKey.Type, AccountDataSecretStorageDefaultKey.Type,
 		AccountDataCrossSigningMaster.Type, AccountDataCrossSigningSelf.Type, AccountDataCrossSigningUser.Type,
-		AccountDataFullyRead.Type, AccountDataMegolmBackupKey.Type:
+		AccountDataFullyRead.Type, AccountDataMegolmBackupKey.Type,
+		AccountDataUnstableImagePack.Type, AccountDataUnstableImagePackRooms.Type:
 		return AccountDataEventType
 	case EventRedaction.Type, EventMessage.Type, EventEncrypted.Type, EventReaction.Type, EventSticker.Type,
 		InRoomVerificationStart.Type, InRoomVerificationReady.Type, InRoomVerificationAccept.Type,
 		InRoomVerificationKey.Type, InRoomVerificationMAC.Type, InRoomVerificationCancel.Type,
 		CallInvite.Type, CallCandidates.Type, CallAnswer.Type, CallReject.Type, CallSelectAnswer.Type,
-		CallNegotiate.Type, CallHangup.Type, BeeperMessageStatus.Type, EventUnstablePollStart.Type, EventUnstablePollResponse.Type:
+		CallNegotiate.Type, CallHangup.Type, BeeperMessageStatus.Type, EventUnstablePollStart.Type, EventUnstablePollResponse.Type, EventUnstablePollEnd.Type,
+		EventExtensibleMessage.Type, EventExtensibleFile.Type, EventExtensibleImage.Type,
+		EventExtensibleLocation.Type, EventUnstableBeacon.Type:
 		return MessageEventType
 	case ToDeviceRoomKey.Type, ToDeviceRoomKeyRequest.Type, ToDeviceForwardedRoomKey.Type, ToDeviceRoomKeyWithheld.Type,
 		ToDeviceBeeperRoomKeyAck.Type:
@@ -203,6 +207,13 @@ var (
 	StateInsertionMarker = Type{"org.matrix.msc2716.marker", StateEventType}
 
 	StateElementFunctionalMembers = Type{"io.element.functional_members", StateEventType}
+	StateBeeperRoomFeatures       = Type{"com.beeper.room_features", StateEventType}
+
+	// Live location sharing (MSC3672)
+	StateUnstableBeaconInfo = Type{"org.matrix.msc3672.beacon_info", StateEventType}
+
+	// Custom emoji and sticker packs (MSC2545)
+	StateUnstableImagePack = Type{"im.ponies.room_emotes", StateEventType}
 )
 
 // Message events
@@ -235,6 +246,16 @@ var (
 
 	EventUnstablePollStart    = Type{Type: "org.matrix.msc3381.poll.start", Class: MessageEventType}
 	EventUnstablePollResponse = Type{Type: "org.matrix.msc3381.poll.response", Class: MessageEventType}
+	EventUnstablePollEnd      = Type{Type: "org.matrix.msc3381.poll.end", Class: MessageEventType}
+
+	// Extensible events (MSC1767, MSC3551, MSC3552, MSC3488)
+	EventExtensibleMessage  = Type{Type: "m.message", Class: MessageEventType}
+	EventExtensibleFile     = Type{Type: "m.file", Class: MessageEventType}
+	EventExtensibleImage    = Type{Type: "m.image", Class: MessageEventType}
+	EventExtensibleLocation = Type{Type: "m.location", Class: MessageEventType}
+
+	// Live location sharing (MSC3672)
+	EventUnstableBeacon = Type{Type: "org.matrix.msc3672.beacon", Class: MessageEventType}
 )
 
 // Ephemeral events
@@ -260,6 +281,10 @@ var (
 	AccountDataCrossSigningUser        = Type{string(id.SecretXSUserSigning), AccountDataEventType}
 	AccountDataCrossSigningSelf        = Type{string(id.SecretXSSelfSigning), AccountDataEventType}
 	AccountDataMegolmBackupKey         = Type{string(id.SecretMegolmBackupV1), AccountDataEventType}
+
+	// Custom emoji and sticker packs (MSC2545)
+	AccountDataUnstableImagePack      = Type{"im.ponies.user_emotes", AccountDataEventType}
+	AccountDataUnstableImagePackRooms = Type{"im.ponies.emote_rooms", AccountDataEventType}
 )
 
 // Device-to-device events
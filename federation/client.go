@@ -22,6 +22,7 @@ import (
 	"go.mau.fi/util/jsontime"
 
 	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 )
 
@@ -208,6 +209,54 @@ func (c *Client) QueryDirectory(ctx context.Context, serverName string, roomAlia
 	return
 }
 
+type ReqPublicRooms struct {
+	Limit                int                   `json:"limit,omitempty"`
+	Since                string                `json:"since,omitempty"`
+	Filter               *ReqPublicRoomsFilter `json:"filter,omitempty"`
+	IncludeAllNetworks   bool                  `json:"include_all_networks,omitempty"`
+	ThirdPartyInstanceID string                `json:"third_party_instance_id,omitempty"`
+}
+
+type ReqPublicRoomsFilter struct {
+	GenericSearchTerm string           `json:"generic_search_term,omitempty"`
+	RoomTypes         []event.RoomType `json:"room_types,omitempty"`
+}
+
+// GetPublicRooms fetches the public room directory of the given server.
+// If a filter is specified, the POST version of the endpoint is used. The request may be nil to use the defaults.
+func (c *Client) GetPublicRooms(ctx context.Context, serverName string, req *ReqPublicRooms) (resp *mautrix.RespPublicRooms, err error) {
+	if req == nil {
+		req = &ReqPublicRooms{}
+	}
+	params := RequestParams{
+		ServerName:   serverName,
+		Method:       http.MethodGet,
+		Path:         URLPath{"v1", "publicRooms"},
+		Authenticate: true,
+		ResponseJSON: &resp,
+	}
+	if req.Filter != nil {
+		params.Method = http.MethodPost
+		params.RequestJSON = req
+	} else {
+		params.Query = url.Values{}
+		if req.Limit > 0 {
+			params.Query.Set("limit", strconv.Itoa(req.Limit))
+		}
+		if req.Since != "" {
+			params.Query.Set("since", req.Since)
+		}
+		if req.IncludeAllNetworks {
+			params.Query.Set("include_all_networks", "true")
+		}
+		if req.ThirdPartyInstanceID != "" {
+			params.Query.Set("third_party_instance_id", req.ThirdPartyInstanceID)
+		}
+	}
+	_, _, err = c.MakeFullRequest(ctx, params)
+	return
+}
+
 func (c *Client) Query(ctx context.Context, serverName, queryType string, queryParams url.Values, respStruct any) (err error) {
 	_, _, err = c.MakeFullRequest(ctx, RequestParams{
 		ServerName:   serverName,
@@ -0,0 +1,63 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package federation
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/tidwall/sjson"
+
+	"maunium.net/go/mautrix/crypto/canonicaljson"
+	"maunium.net/go/mautrix/id"
+)
+
+// ReferenceHash calculates the reference hash of a PDU as specified in
+// https://spec.matrix.org/v1.11/server-server-api/#calculating-the-reference-hash-for-an-event
+//
+// The PDU must already be redacted using the redaction algorithm of the room version.
+// The signatures and unsigned fields are removed by this function.
+func ReferenceHash(redactedPDU []byte) ([32]byte, error) {
+	var err error
+	for _, key := range []string{"signatures", "unsigned"} {
+		redactedPDU, err = sjson.DeleteBytes(redactedPDU, key)
+		if err != nil {
+			return [32]byte{}, fmt.Errorf("failed to remove %s from PDU: %w", key, err)
+		}
+	}
+	canonical, err := canonicaljson.CanonicalJSON(redactedPDU)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("failed to canonicalize PDU: %w", err)
+	}
+	return sha256.Sum256(canonical), nil
+}
+
+// CalculateEventID calculates the event ID of a redacted PDU. If urlSafe is true, the room version 4+ format
+// is used, otherwise the room version 3 format is used. Event IDs in room versions 1 and 2 are not derived
+// from the reference hash and can't be calculated.
+func CalculateEventID(redactedPDU []byte, urlSafe bool) (id.EventID, error) {
+	hash, err := ReferenceHash(redactedPDU)
+	if err != nil {
+		return "", err
+	} else if urlSafe {
+		return id.EventIDV4FromReferenceHash(hash), nil
+	} else {
+		return id.EventIDV3FromReferenceHash(hash), nil
+	}
+}
+
+// VerifyEventID checks that the event ID matches the reference hash of the given redacted PDU.
+// Both the room version 3 and 4+ formats are accepted.
+func VerifyEventID(eventID id.EventID, redactedPDU []byte) error {
+	hash, err := ReferenceHash(redactedPDU)
+	if err != nil {
+		return err
+	} else if !eventID.MatchesReferenceHash(hash) {
+		return fmt.Errorf("%w: expected %s", id.ErrEventIDMismatch, id.EventIDV4FromReferenceHash(hash))
+	}
+	return nil
+}
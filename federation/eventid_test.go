@@ -0,0 +1,79 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package federation_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/federation"
+	"maunium.net/go/mautrix/id"
+)
+
+const testPDU = `{
+	"type": "m.room.message",
+	"room_id": "!room:example.org",
+	"sender": "@alice:example.org",
+	"origin_server_ts": 1700000000000,
+	"depth": 5,
+	"prev_events": ["$prev"],
+	"auth_events": ["$create", "$member"],
+	"hashes": {"sha256": "aGFzaA"},
+	"content": {}
+}`
+
+func calculate(t *testing.T, pdu string) id.EventID {
+	t.Helper()
+	eventID, err := federation.CalculateEventID([]byte(pdu), true)
+	require.NoError(t, err)
+	return eventID
+}
+
+func TestCalculateEventID_IgnoresFormatting(t *testing.T) {
+	reordered := `{"content":{},"hashes":{"sha256":"aGFzaA"},"auth_events":["$create","$member"],"prev_events":["$prev"],` +
+		`"depth":5,"origin_server_ts":1700000000000,"sender":"@alice:example.org","room_id":"!room:example.org","type":"m.room.message"}`
+	assert.Equal(t, calculate(t, testPDU), calculate(t, reordered))
+}
+
+func TestCalculateEventID_IgnoresSignaturesAndUnsigned(t *testing.T) {
+	withExtras := strings.Replace(testPDU, `"content": {}`,
+		`"content": {}, "signatures": {"example.org": {"ed25519:1": "sig"}}, "unsigned": {"age": 1234}`, 1)
+	assert.Equal(t, calculate(t, testPDU), calculate(t, withExtras))
+}
+
+func TestCalculateEventID_DependsOnContent(t *testing.T) {
+	changedContent := strings.Replace(testPDU, `"content": {}`, `"content": {"body": "meow"}`, 1)
+	assert.NotEqual(t, calculate(t, testPDU), calculate(t, changedContent))
+	changedDepth := strings.Replace(testPDU, `"depth": 5`, `"depth": 6`, 1)
+	assert.NotEqual(t, calculate(t, testPDU), calculate(t, changedDepth))
+}
+
+func TestCalculateEventID_Formats(t *testing.T) {
+	hash, err := federation.ReferenceHash([]byte(testPDU))
+	require.NoError(t, err)
+	v3, err := federation.CalculateEventID([]byte(testPDU), false)
+	require.NoError(t, err)
+	v4 := calculate(t, testPDU)
+	assert.Equal(t, id.EventIDV3FromReferenceHash(hash), v3)
+	assert.Equal(t, id.EventIDV4FromReferenceHash(hash), v4)
+	assert.Len(t, v4, 44)
+	assert.NotContains(t, v4, "+")
+	assert.NotContains(t, v4, "/")
+}
+
+func TestVerifyEventID(t *testing.T) {
+	v3, err := federation.CalculateEventID([]byte(testPDU), false)
+	require.NoError(t, err)
+	assert.NoError(t, federation.VerifyEventID(v3, []byte(testPDU)))
+	assert.NoError(t, federation.VerifyEventID(calculate(t, testPDU), []byte(testPDU)))
+	assert.ErrorIs(t, federation.VerifyEventID("$meow", []byte(testPDU)), id.ErrEventIDMismatch)
+	_, err = federation.ReferenceHash([]byte(`{"content":`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"go.mau.fi/util/exslices"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type ReqMakeMembership struct {
+	ServerName string
+	RoomID     id.RoomID
+	UserID     id.UserID
+	// The room versions that the requesting server supports. Not used for make_leave.
+	SupportedVersions []event.RoomVersion
+}
+
+// RespMakeMembership is the response to the make_join, make_leave and make_knock endpoints.
+// The event is a template that must be filled in, hashed and signed before sending it back.
+type RespMakeMembership struct {
+	RoomVersion event.RoomVersion `json:"room_version"`
+	Event       json.RawMessage   `json:"event"`
+}
+
+func (c *Client) makeMembership(ctx context.Context, endpoint string, req *ReqMakeMembership) (resp *RespMakeMembership, err error) {
+	var query url.Values
+	if len(req.SupportedVersions) > 0 {
+		query = url.Values{"ver": exslices.CastToString[string](req.SupportedVersions)}
+	}
+	_, _, err = c.MakeFullRequest(ctx, RequestParams{
+		ServerName:   req.ServerName,
+		Method:       http.MethodGet,
+		Path:         URLPath{"v1", endpoint, req.RoomID, req.UserID},
+		Query:        query,
+		Authenticate: true,
+		ResponseJSON: &resp,
+	})
+	return
+}
+
+// MakeJoin requests a join event template from a server that is already in the room.
+//
+// https://spec.matrix.org/v1.11/server-server-api/#get_matrixfederationv1make_joinroomiduserid
+func (c *Client) MakeJoin(ctx context.Context, req *ReqMakeMembership) (*RespMakeMembership, error) {
+	return c.makeMembership(ctx, "make_join", req)
+}
+
+// MakeLeave requests a leave event template from a server in the room.
+//
+// https://spec.matrix.org/v1.11/server-server-api/#get_matrixfederationv1make_leaveroomiduserid
+func (c *Client) MakeLeave(ctx context.Context, req *ReqMakeMembership) (*RespMakeMembership, error) {
+	return c.makeMembership(ctx, "make_leave", req)
+}
+
+// MakeKnock requests a knock event template from a server in the room.
+//
+// https://spec.matrix.org/v1.11/server-server-api/#get_matrixfederationv1make_knockroomiduserid
+func (c *Client) MakeKnock(ctx context.Context, req *ReqMakeMembership) (*RespMakeMembership, error) {
+	return c.makeMembership(ctx, "make_knock", req)
+}
+
+type ReqSendMembership struct {
+	ServerName string
+	RoomID     id.RoomID
+	EventID    id.EventID
+	Event      PDU
+	// Only used for send_join: request a partial state response without most membership events (MSC3706).
+	OmitMembers bool
+}
+
+type RespSendJoin struct {
+	Origin         string   `json:"origin"`
+	AuthChain      []PDU    `json:"auth_chain"`
+	State          []PDU    `json:"state"`
+	Event          PDU      `json:"event,omitempty"`
+	MembersOmitted bool     `json:"members_omitted,omitempty"`
+	ServersInRoom  []string `json:"servers_in_room,omitempty"`
+}
+
+// SendJoin sends a signed join event to a resident server and returns the current state of the room.
+//
+// https://spec.matrix.org/v1.11/server-server-api/#put_matrixfederationv2send_joinroomideventid
+func (c *Client) SendJoin(ctx context.Context, req *ReqSendMembership) (resp *RespSendJoin, err error) {
+	var query url.Values
+	if req.OmitMembers {
+		query = url.Values{"omit_members": {"true"}}
+	}
+	_, _, err = c.MakeFullRequest(ctx, RequestParams{
+		ServerName:   req.ServerName,
+		Method:       http.MethodPut,
+		Path:         URLPath{"v2", "send_join", req.RoomID, req.EventID},
+		Query:        query,
+		Authenticate: true,
+		RequestJSON:  req.Event,
+		ResponseJSON: &resp,
+	})
+	return
+}
+
+// SendLeave sends a signed leave event to a resident server.
+//
+// https://spec.matrix.org/v1.11/server-server-api/#put_matrixfederationv2send_leaveroomideventid
+func (c *Client) SendLeave(ctx context.Context, req *ReqSendMembership) error {
+	return c.MakeRequest(ctx, req.ServerName, true, http.MethodPut, URLPath{"v2", "send_leave", req.RoomID, req.EventID}, req.Event, nil)
+}
+
+type RespSendKnock struct {
+	KnockRoomState []PDU `json:"knock_room_state"`
+}
+
+// SendKnock sends a signed knock event to a resident server and returns the stripped state of the room.
+//
+// https://spec.matrix.org/v1.11/server-server-api/#put_matrixfederationv1send_knockroomideventid
+func (c *Client) SendKnock(ctx context.Context, req *ReqSendMembership) (resp *RespSendKnock, err error) {
+	err = c.MakeRequest(ctx, req.ServerName, true, http.MethodPut, URLPath{"v1", "send_knock", req.RoomID, req.EventID}, req.Event, &resp)
+	return
+}
+
+type ReqSendInvite struct {
+	ServerName      string            `json:"-"`
+	RoomID          id.RoomID         `json:"-"`
+	EventID         id.EventID        `json:"-"`
+	RoomVersion     event.RoomVersion `json:"room_version"`
+	Event           PDU               `json:"event"`
+	InviteRoomState []PDU             `json:"invite_room_state,omitempty"`
+}
+
+type RespSendInvite struct {
+	Event PDU `json:"event"`
+}
+
+// SendInvite sends an invite event to the server of the invited user, which signs and returns it.
+//
+// https://spec.matrix.org/v1.11/server-server-api/#put_matrixfederationv2inviteroomideventid
+func (c *Client) SendInvite(ctx context.Context, req *ReqSendInvite) (resp *RespSendInvite, err error) {
+	err = c.MakeRequest(ctx, req.ServerName, true, http.MethodPut, URLPath{"v2", "invite", req.RoomID, req.EventID}, req, &resp)
+	return
+}
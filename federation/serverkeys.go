@@ -0,0 +1,333 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package federation
+
+import (
+	"container/list"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"go.mau.fi/util/exgjson"
+
+	"maunium.net/go/mautrix/crypto/canonicaljson"
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	ErrKeyNotFound          = errors.New("server signing key not found")
+	ErrKeyExpired           = errors.New("server signing key was not valid at the given time")
+	ErrServerNameMismatch   = errors.New("server name in key response doesn't match requested server")
+	ErrNoValidSignatures    = errors.New("no valid signatures found")
+	ErrInvalidSignature     = errors.New("invalid signature")
+	ErrInvalidAuthorization = errors.New("invalid X-Matrix authorization header")
+)
+
+type serverKey struct {
+	key        id.SigningKey
+	validUntil time.Time
+	// expired is true for keys from old_verify_keys, in which case validUntil is the expiry time.
+	expired bool
+}
+
+type cachedServerKeys struct {
+	serverName string
+	keys       map[id.KeyID]*serverKey
+	fetchedAt  time.Time
+	lock       sync.Mutex
+}
+
+// DefaultMaxCachedServers is the default value for ServerKeyCache.MaxServers.
+const DefaultMaxCachedServers = 4096
+
+// ServerKeyCache fetches and caches the signing keys of other servers,
+// and can be used to verify signatures on events and federation requests.
+type ServerKeyCache struct {
+	Client *Client
+	// The minimum time to wait between refetching keys of a server that didn't have the requested key.
+	MinRefetchInterval time.Duration
+	// The maximum number of servers whose keys are kept in memory. When the limit is reached,
+	// the least recently used server is evicted, and its keys will be refetched if they're needed again.
+	// Zero means unlimited.
+	MaxServers int
+
+	servers     map[string]*list.Element
+	serverOrder *list.List
+	serversLock sync.Mutex
+}
+
+// NewServerKeyCache creates a new server key cache that uses the given federation client to fetch keys.
+func NewServerKeyCache(client *Client) *ServerKeyCache {
+	return &ServerKeyCache{
+		Client:             client,
+		MinRefetchInterval: 1 * time.Minute,
+		MaxServers:         DefaultMaxCachedServers,
+		servers:            make(map[string]*list.Element),
+		serverOrder:        list.New(),
+	}
+}
+
+// verifyJSONSignature verifies a single ed25519 signature in a signed JSON object.
+// Both standard and URL-safe unpadded base64 signatures are accepted.
+func verifyJSONSignature(data json.RawMessage, signer string, keyID id.KeyID, key id.SigningKey) error {
+	sig := gjson.GetBytes(data, exgjson.Path("signatures", signer, keyID.String()))
+	if sig.Type != gjson.String {
+		return ErrNoValidSignatures
+	}
+	sigBytes, err := base64.RawStdEncoding.DecodeString(sig.Str)
+	if err != nil {
+		sigBytes, err = base64.RawURLEncoding.DecodeString(sig.Str)
+		if err != nil {
+			return fmt.Errorf("failed to decode signature: %w", err)
+		}
+	}
+	pubKey, err := base64.RawStdEncoding.DecodeString(string(key))
+	if err != nil {
+		return fmt.Errorf("failed to decode key: %w", err)
+	} else if len(pubKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid key length %d", len(pubKey))
+	}
+	for _, field := range []string{"signatures", "unsigned"} {
+		data, err = sjson.DeleteBytes(data, field)
+		if err != nil {
+			return fmt.Errorf("failed to remove %s: %w", field, err)
+		}
+	}
+	canonical, err := canonicaljson.CanonicalJSON(data)
+	if err != nil {
+		return fmt.Errorf("failed to canonicalize JSON: %w", err)
+	}
+	if !ed25519.Verify(pubKey, canonical, sigBytes) {
+		return fmt.Errorf("%w from %s", ErrInvalidSignature, keyID)
+	}
+	return nil
+}
+
+func verifyServerKeySelfSignature(raw json.RawMessage, skr *ServerKeyResponse) error {
+	if len(skr.VerifyKeys) == 0 {
+		return ErrNoValidSignatures
+	}
+	for keyID, key := range skr.VerifyKeys {
+		if algorithm, _ := keyID.Parse(); algorithm != id.KeyAlgorithmEd25519 {
+			continue
+		}
+		if err := verifyJSONSignature(raw, skr.ServerName, keyID, key.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifySelfSignature checks that the key response is signed by all the current ed25519 verify keys in it.
+//
+// The response is re-marshaled for verification, so any fields not included in the struct are lost.
+// Responses fetched by ServerKeyCache are verified using the raw response body instead.
+func (skr *ServerKeyResponse) VerifySelfSignature() error {
+	raw, err := json.Marshal(skr)
+	if err != nil {
+		return err
+	}
+	return verifyServerKeySelfSignature(raw, skr)
+}
+
+func (skc *ServerKeyCache) getServer(serverName string) *cachedServerKeys {
+	skc.serversLock.Lock()
+	defer skc.serversLock.Unlock()
+	elem, ok := skc.servers[serverName]
+	if ok {
+		skc.serverOrder.MoveToFront(elem)
+		return elem.Value.(*cachedServerKeys)
+	}
+	server := &cachedServerKeys{serverName: serverName, keys: make(map[id.KeyID]*serverKey)}
+	skc.servers[serverName] = skc.serverOrder.PushFront(server)
+	for skc.MaxServers > 0 && skc.serverOrder.Len() > skc.MaxServers {
+		// Requests that are already using the evicted entry can keep using it, it's just not cached anymore
+		oldest := skc.serverOrder.Back()
+		skc.serverOrder.Remove(oldest)
+		delete(skc.servers, oldest.Value.(*cachedServerKeys).serverName)
+	}
+	return server
+}
+
+// ServerCount returns the number of servers whose keys are currently cached.
+func (skc *ServerKeyCache) ServerCount() int {
+	skc.serversLock.Lock()
+	defer skc.serversLock.Unlock()
+	return skc.serverOrder.Len()
+}
+
+// AddKeyResponse adds the keys in the given response to the cache after verifying the self-signature.
+func (skc *ServerKeyCache) AddKeyResponse(resp *ServerKeyResponse) error {
+	if err := resp.VerifySelfSignature(); err != nil {
+		return err
+	}
+	server := skc.getServer(resp.ServerName)
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	skc.addKeysLocked(server, resp)
+	return nil
+}
+
+func (skc *ServerKeyCache) addKeysLocked(server *cachedServerKeys, resp *ServerKeyResponse) {
+	for keyID, key := range resp.VerifyKeys {
+		server.keys[keyID] = &serverKey{key: key.Key, validUntil: resp.ValidUntilTS.Time}
+	}
+	for keyID, key := range resp.OldVerifyKeys {
+		server.keys[keyID] = &serverKey{key: key.Key, validUntil: key.ExpiredTS.Time, expired: true}
+	}
+}
+
+func (skc *ServerKeyCache) fetchKeysLocked(ctx context.Context, serverName string, server *cachedServerKeys) error {
+	if time.Since(server.fetchedAt) < skc.MinRefetchInterval {
+		return nil
+	}
+	var resp ServerKeyResponse
+	raw, _, err := skc.Client.MakeFullRequest(ctx, RequestParams{
+		ServerName:   serverName,
+		Method:       http.MethodGet,
+		Path:         KeyURLPath{"v2", "server"},
+		ResponseJSON: &resp,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch keys: %w", err)
+	} else if resp.ServerName != serverName {
+		return fmt.Errorf("%w (expected %s, got %s)", ErrServerNameMismatch, serverName, resp.ServerName)
+	} else if err = verifyServerKeySelfSignature(raw, &resp); err != nil {
+		return fmt.Errorf("failed to verify key response: %w", err)
+	}
+	server.fetchedAt = time.Now()
+	skc.addKeysLocked(server, &resp)
+	return nil
+}
+
+// GetKey returns the given signing key of the given server, fetching the server's keys if necessary.
+//
+// The key must have been valid at the given time. Current keys are refetched if the cached copy has
+// passed its valid_until_ts, while keys that the server has marked as expired are never refetched.
+func (skc *ServerKeyCache) GetKey(ctx context.Context, serverName string, keyID id.KeyID, validAt time.Time) (id.SigningKey, error) {
+	server := skc.getServer(serverName)
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	key, ok := server.keys[keyID]
+	if !ok || (!key.expired && key.validUntil.Before(validAt)) {
+		if err := skc.fetchKeysLocked(ctx, serverName, server); err != nil {
+			return "", err
+		}
+		key, ok = server.keys[keyID]
+	}
+	if !ok {
+		return "", ErrKeyNotFound
+	} else if key.validUntil.Before(validAt) {
+		return "", ErrKeyExpired
+	}
+	return key.key, nil
+}
+
+// VerifyJSON verifies that the given JSON object has a valid signature from the given server.
+// At least one of the server's signatures must be valid at the given time.
+//
+// Events must be redacted using the redaction algorithm of the room version before verifying them.
+func (skc *ServerKeyCache) VerifyJSON(ctx context.Context, serverName string, data json.RawMessage, validAt time.Time) error {
+	sigs := gjson.GetBytes(data, exgjson.Path("signatures", serverName))
+	if !sigs.IsObject() {
+		return ErrNoValidSignatures
+	}
+	var lastErr error = ErrNoValidSignatures
+	for keyID := range sigs.Map() {
+		if algorithm, _ := id.KeyID(keyID).Parse(); algorithm != id.KeyAlgorithmEd25519 {
+			continue
+		}
+		key, err := skc.GetKey(ctx, serverName, id.KeyID(keyID), validAt)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to get key %s: %w", keyID, err)
+		} else if lastErr = verifyJSONSignature(data, serverName, id.KeyID(keyID), key); lastErr == nil {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+// XMatrixAuth contains the parsed fields of an X-Matrix authorization header.
+type XMatrixAuth struct {
+	Origin      string
+	Destination string
+	KeyID       id.KeyID
+	Signature   string
+}
+
+// ParseXMatrixAuth parses an X-Matrix authorization header.
+func ParseXMatrixAuth(header string) (*XMatrixAuth, error) {
+	params, found := strings.CutPrefix(header, "X-Matrix ")
+	if !found {
+		return nil, fmt.Errorf("%w: missing X-Matrix prefix", ErrInvalidAuthorization)
+	}
+	var auth XMatrixAuth
+	for _, param := range strings.Split(params, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: malformed parameter", ErrInvalidAuthorization)
+		}
+		value = strings.Trim(value, `"`)
+		switch strings.ToLower(key) {
+		case "origin":
+			auth.Origin = value
+		case "destination":
+			auth.Destination = value
+		case "key":
+			auth.KeyID = id.KeyID(value)
+		case "sig":
+			auth.Signature = value
+		}
+	}
+	if auth.Origin == "" || auth.KeyID == "" || auth.Signature == "" {
+		return nil, fmt.Errorf("%w: missing origin, key or sig", ErrInvalidAuthorization)
+	}
+	return &auth, nil
+}
+
+// VerifyRequest verifies the X-Matrix authorization header of an incoming federation request
+// and returns the name of the origin server.
+//
+// The body must be the raw request body, or nil if the request doesn't have a body.
+func (skc *ServerKeyCache) VerifyRequest(ctx context.Context, r *http.Request, destination string, body json.RawMessage) (string, error) {
+	auth, err := ParseXMatrixAuth(r.Header.Get("Authorization"))
+	if err != nil {
+		return "", err
+	} else if auth.Destination != "" && auth.Destination != destination {
+		return "", fmt.Errorf("%w: destination mismatch", ErrInvalidAuthorization)
+	}
+	signed := map[string]any{
+		"method":      r.Method,
+		"uri":         r.URL.RequestURI(),
+		"origin":      auth.Origin,
+		"destination": destination,
+		"signatures": map[string]map[id.KeyID]string{
+			auth.Origin: {auth.KeyID: auth.Signature},
+		},
+	}
+	if len(body) > 0 {
+		signed["content"] = body
+	}
+	signedJSON, err := json.Marshal(signed)
+	if err != nil {
+		return "", err
+	}
+	err = skc.VerifyJSON(ctx, auth.Origin, signedJSON, time.Now())
+	if err != nil {
+		return "", err
+	}
+	return auth.Origin, nil
+}
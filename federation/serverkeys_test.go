@@ -0,0 +1,90 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package federation_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/federation"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestServerKeyCache_VerifyJSON(t *testing.T) {
+	ctx := context.Background()
+	key := federation.GenerateSigningKey()
+	cache := federation.NewServerKeyCache(nil)
+	require.NoError(t, cache.AddKeyResponse(key.GenerateKeyResponse("example.com", nil)))
+
+	data := map[string]any{"hello": "world"}
+	sig, err := key.SignJSON(data)
+	require.NoError(t, err)
+	data["signatures"] = map[string]map[id.KeyID]string{
+		"example.com": {key.ID: base64.RawStdEncoding.EncodeToString(sig)},
+	}
+	signed, err := json.Marshal(data)
+	require.NoError(t, err)
+	assert.NoError(t, cache.VerifyJSON(ctx, "example.com", signed, time.Now()))
+
+	data["hello"] = "tampered"
+	tampered, err := json.Marshal(data)
+	require.NoError(t, err)
+	assert.ErrorIs(t, cache.VerifyJSON(ctx, "example.com", tampered, time.Now()), federation.ErrInvalidSignature)
+	assert.ErrorIs(t, cache.VerifyJSON(ctx, "example.org", signed, time.Now()), federation.ErrNoValidSignatures)
+}
+
+func TestServerKeyCache_MaxServers(t *testing.T) {
+	ctx := context.Background()
+	cache := federation.NewServerKeyCache(nil)
+	cache.MaxServers = 2
+	keys := map[string]*federation.SigningKey{}
+	for _, serverName := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		keys[serverName] = federation.GenerateSigningKey()
+	}
+	require.NoError(t, cache.AddKeyResponse(keys["a.example.com"].GenerateKeyResponse("a.example.com", nil)))
+	require.NoError(t, cache.AddKeyResponse(keys["b.example.com"].GenerateKeyResponse("b.example.com", nil)))
+	// Using server A makes B the least recently used server
+	_, err := cache.GetKey(ctx, "a.example.com", keys["a.example.com"].ID, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, cache.AddKeyResponse(keys["c.example.com"].GenerateKeyResponse("c.example.com", nil)))
+	assert.Equal(t, 2, cache.ServerCount())
+
+	// The cache has no client, so only keys that are still cached can be returned without panicking
+	for _, serverName := range []string{"a.example.com", "c.example.com"} {
+		key, err := cache.GetKey(ctx, serverName, keys[serverName].ID, time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, keys[serverName].Pub, key)
+	}
+}
+
+func TestServerKeyResponse_VerifySelfSignature(t *testing.T) {
+	key := federation.GenerateSigningKey()
+	resp := key.GenerateKeyResponse("example.com", nil)
+	assert.NoError(t, resp.VerifySelfSignature())
+	resp.ServerName = "example.org"
+	assert.Error(t, resp.VerifySelfSignature())
+}
+
+func TestParseXMatrixAuth(t *testing.T) {
+	auth, err := federation.ParseXMatrixAuth(`X-Matrix origin="origin.example.com",destination="dest.example.com",key="ed25519:abc",sig="c2lnbmF0dXJl"`)
+	require.NoError(t, err)
+	assert.Equal(t, "origin.example.com", auth.Origin)
+	assert.Equal(t, "dest.example.com", auth.Destination)
+	assert.Equal(t, id.KeyID("ed25519:abc"), auth.KeyID)
+	assert.Equal(t, "c2lnbmF0dXJl", auth.Signature)
+
+	_, err = federation.ParseXMatrixAuth(`Bearer abc`)
+	assert.ErrorIs(t, err, federation.ErrInvalidAuthorization)
+	_, err = federation.ParseXMatrixAuth(`X-Matrix origin="origin.example.com"`)
+	assert.ErrorIs(t, err, federation.ErrInvalidAuthorization)
+}
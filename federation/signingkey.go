@@ -10,7 +10,10 @@ import (
 	"crypto/ed25519"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
 	"strings"
 	"time"
 
@@ -56,6 +59,23 @@ func ParseSynapseKey(key string) (*SigningKey, error) {
 	}, nil
 }
 
+// LoadOrGenerateSigningKey reads a Synapse-compatible signing key from the given file,
+// or generates a new key and saves it to the file if it doesn't exist yet.
+func LoadOrGenerateSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return ParseSynapseKey(strings.TrimSpace(string(data)))
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	key := GenerateSigningKey()
+	err = os.WriteFile(path, []byte(key.SynapseString()+"\n"), 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save generated signing key: %w", err)
+	}
+	return key, nil
+}
+
 // GenerateSigningKey generates a new random signing key.
 func GenerateSigningKey() *SigningKey {
 	pub, priv, err := ed25519.GenerateKey(nil)
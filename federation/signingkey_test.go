@@ -0,0 +1,27 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package federation_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/federation"
+)
+
+func TestLoadOrGenerateSigningKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signing.key")
+	generated, err := federation.LoadOrGenerateSigningKey(path)
+	require.NoError(t, err)
+	loaded, err := federation.LoadOrGenerateSigningKey(path)
+	require.NoError(t, err)
+	assert.Equal(t, generated.ID, loaded.ID)
+	assert.Equal(t, generated.Pub, loaded.Pub)
+}
@@ -93,3 +93,91 @@ func DefaultFilterPart() *FilterPart {
 		Types:      nil,
 	}
 }
+
+// NewLazyLoadingFilter returns a sync filter that lazy-loads room members and limits the timeline to the given
+// number of events. This is the recommended starting point for clients that don't need the full member list upfront.
+func NewLazyLoadingFilter(timelineLimit int) *Filter {
+	return (&Filter{}).WithLazyLoadMembers().WithTimelineLimit(timelineLimit)
+}
+
+func (filter *Filter) room() *RoomFilter {
+	if filter.Room == nil {
+		filter.Room = &RoomFilter{}
+	}
+	return filter.Room
+}
+
+func (rf *RoomFilter) state() *FilterPart {
+	if rf.State == nil {
+		rf.State = &FilterPart{}
+	}
+	return rf.State
+}
+
+func (rf *RoomFilter) timeline() *FilterPart {
+	if rf.Timeline == nil {
+		rf.Timeline = &FilterPart{}
+	}
+	return rf.Timeline
+}
+
+// WithLazyLoadMembers enables lazy-loading of room members in the state and timeline sections.
+// See https://spec.matrix.org/v1.13/client-server-api/#lazy-loading-room-members
+func (filter *Filter) WithLazyLoadMembers() *Filter {
+	room := filter.room()
+	room.state().LazyLoadMembers = true
+	room.timeline().LazyLoadMembers = true
+	return filter
+}
+
+// WithTimelineLimit sets the maximum number of timeline events returned per room.
+func (filter *Filter) WithTimelineLimit(limit int) *Filter {
+	filter.room().timeline().Limit = limit
+	return filter
+}
+
+// WithTimelineTypes only includes the given event types in room timelines.
+func (filter *Filter) WithTimelineTypes(types ...event.Type) *Filter {
+	timeline := filter.room().timeline()
+	timeline.Types = append(timeline.Types, types...)
+	return filter
+}
+
+// WithoutTimelineTypes excludes the given event types from room timelines.
+func (filter *Filter) WithoutTimelineTypes(types ...event.Type) *Filter {
+	timeline := filter.room().timeline()
+	timeline.NotTypes = append(timeline.NotTypes, types...)
+	return filter
+}
+
+// WithRooms only includes the given rooms in the room section of the sync response.
+func (filter *Filter) WithRooms(rooms ...id.RoomID) *Filter {
+	room := filter.room()
+	room.Rooms = append(room.Rooms, rooms...)
+	return filter
+}
+
+// WithoutRooms excludes the given rooms from the room section of the sync response.
+func (filter *Filter) WithoutRooms(rooms ...id.RoomID) *Filter {
+	room := filter.room()
+	room.NotRooms = append(room.NotRooms, rooms...)
+	return filter
+}
+
+// WithIncludeLeave includes rooms that the user has left in the sync response.
+func (filter *Filter) WithIncludeLeave() *Filter {
+	filter.room().IncludeLeave = true
+	return filter
+}
+
+// WithUnreadThreadNotifications requests notification counts to be split per thread.
+func (filter *Filter) WithUnreadThreadNotifications() *Filter {
+	filter.room().timeline().UnreadThreadNotifications = true
+	return filter
+}
+
+// WithoutPresence excludes all presence events from the sync response.
+func (filter *Filter) WithoutPresence() *Filter {
+	filter.Presence = &FilterPart{NotTypes: []event.Type{{Type: "*"}}}
+	return filter
+}
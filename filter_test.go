@@ -0,0 +1,70 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestFilter_Builder(t *testing.T) {
+	filter := mautrix.NewLazyLoadingFilter(20).
+		WithTimelineTypes(event.EventMessage, event.EventEncrypted).
+		WithoutRooms("!ignored:example.com").
+		WithUnreadThreadNotifications().
+		WithoutPresence()
+	data, err := json.Marshal(filter)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"presence": {"not_types": ["*"]},
+		"room": {
+			"not_rooms": ["!ignored:example.com"],
+			"state": {"lazy_load_members": true},
+			"timeline": {
+				"limit": 20,
+				"types": ["m.room.message", "m.room.encrypted"],
+				"lazy_load_members": true,
+				"unread_thread_notifications": true
+			}
+		}
+	}`, string(data))
+}
+
+func TestClient_SetSyncFilter(t *testing.T) {
+	var uploaded []byte
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /_matrix/client/v3/user/{userID}/filter", func(w http.ResponseWriter, r *http.Request) {
+		uploaded, _ = io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(`{"filter_id": "new_filter"}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, cli.Store.SaveFilterID(ctx, cli.UserID, "old_filter"))
+
+	filter := mautrix.NewLazyLoadingFilter(10)
+	filterID, err := cli.SetSyncFilter(ctx, filter)
+	require.NoError(t, err)
+	assert.Equal(t, "new_filter", filterID)
+	assert.JSONEq(t, `{"room": {"state": {"lazy_load_members": true}, "timeline": {"limit": 10, "lazy_load_members": true}}}`, string(uploaded))
+	storedID, err := cli.Store.LoadFilterID(ctx, cli.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, "new_filter", storedID)
+	assert.Same(t, filter, cli.Syncer.GetFilterJSON(cli.UserID))
+}
@@ -8,7 +8,9 @@ package format
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"strconv"
 	"strings"
@@ -16,6 +18,7 @@ import (
 	"golang.org/x/net/html"
 
 	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format/mdext"
 	"maunium.net/go/mautrix/id"
 )
 
@@ -34,12 +37,32 @@ func (ts TagStack) Has(tag string) bool {
 	return ts.Index(tag) >= 0
 }
 
+// MemberLookup is used by the HTML parser to find the member info of users mentioned in a message.
+//
+// The TryGetMember method of mautrix.StateStore implements this interface.
+type MemberLookup interface {
+	TryGetMember(ctx context.Context, roomID id.RoomID, userID id.UserID) (*event.MemberEventContent, error)
+}
+
 type Context struct {
 	Ctx        context.Context
 	ReturnData map[string]any
 	TagStack   TagStack
 
+	// RoomID is the room where the message being parsed was sent, if known.
+	RoomID id.RoomID
+	// Event is the event being parsed, if known.
+	Event *event.Event
+	// Members is used to look up members of the room, e.g. for rendering user pills. It may be nil.
+	Members MemberLookup
+
 	PreserveWhitespace bool
+	// CodeLanguage is the language of the code block being parsed, if known (i.e. the
+	// code block had a language-* class). It's only set when inside a <pre> tag.
+	CodeLanguage string
+
+	// output is the writer that the current node is being rendered into, used to limit the output length.
+	output *trimmingWriter
 }
 
 func NewContext(ctx context.Context) Context {
@@ -50,6 +73,31 @@ func NewContext(ctx context.Context) Context {
 	}
 }
 
+// NewEventContext creates a parser context for parsing the given event.
+// The members parameter is optional and may be nil.
+func NewEventContext(ctx context.Context, evt *event.Event, members MemberLookup) Context {
+	pctx := NewContext(ctx)
+	if evt != nil {
+		pctx.RoomID = evt.RoomID
+		pctx.Event = evt
+	}
+	pctx.Members = members
+	return pctx
+}
+
+// GetMember returns the member info of the given user in the room being parsed.
+// If the room or member lookup function aren't known, or the member isn't found, this returns nil.
+func (ctx Context) GetMember(userID id.UserID) *event.MemberEventContent {
+	if ctx.Members == nil || ctx.RoomID == "" {
+		return nil
+	}
+	member, err := ctx.Members.TryGetMember(ctx.Ctx, ctx.RoomID, userID)
+	if err != nil {
+		return nil
+	}
+	return member
+}
+
 func (ctx Context) WithTag(tag string) Context {
 	ctx.TagStack = append(ctx.TagStack, tag)
 	return ctx
@@ -60,13 +108,28 @@ func (ctx Context) WithWhitespace() Context {
 	return ctx
 }
 
+func (ctx Context) WithCodeLanguage(language string) Context {
+	ctx.CodeLanguage = language
+	return ctx
+}
+
+// CodeBlockLanguage returns the language of a <code> tag based on its language-* class.
+func CodeBlockLanguage(class string) string {
+	for _, cls := range strings.Fields(class) {
+		if strings.HasPrefix(cls, "language-") {
+			return cls[len("language-"):]
+		}
+	}
+	return ""
+}
+
 type TextConverter func(string, Context) string
 type SpoilerConverter func(text, reason string, ctx Context) string
 type LinkConverter func(text, href string, ctx Context) string
 type ColorConverter func(text, fg, bg string, ctx Context) string
 type CodeBlockConverter func(code, language string, ctx Context) string
 type PillConverter func(displayname, mxid, eventID string, ctx Context) string
-type ImageConverter func(src, alt, title, width, height string, isEmoji bool) string
+type ImageConverter func(src, alt, title, width, height string, isEmoji bool, ctx Context) string
 
 const ContextKeyMentions = "_mentions"
 
@@ -75,6 +138,12 @@ func DefaultPillConverter(displayname, mxid, eventID string, ctx Context) string
 	case len(mxid) == 0, mxid[0] == '@':
 		existingMentions, _ := ctx.ReturnData[ContextKeyMentions].([]id.UserID)
 		ctx.ReturnData[ContextKeyMentions] = append(existingMentions, id.UserID(mxid))
+		if displayname == mxid && len(mxid) > 0 {
+			// The link text is the raw user ID, use the room displayname instead if it's known
+			if member := ctx.GetMember(id.UserID(mxid)); member != nil && member.Displayname != "" {
+				return member.Displayname
+			}
+		}
 		// User link, always just show the displayname
 		return displayname
 	case len(eventID) > 0:
@@ -111,6 +180,28 @@ type HTMLParser struct {
 	MonospaceConverter      TextConverter
 	TextConverter           TextConverter
 	ImageConverter          ImageConverter
+	TableConverter          TableConverter
+
+	// MarkdownTables makes tables render as Markdown pipe tables instead of space-aligned plain text.
+	MarkdownTables bool
+	// MaxTableWidth is the maximum width of rendered tables in characters.
+	// Columns in wider tables are shrunk and long cells are truncated with an ellipsis.
+	MaxTableWidth int
+
+	// MaxInputLength is the maximum number of bytes of HTML to parse. Longer input is truncated before parsing.
+	MaxInputLength int
+	// MaxOutputLength is the maximum number of bytes to output. Longer output is truncated.
+	MaxOutputLength int
+
+	// Sanitizer, if set, makes the parser output sanitized Matrix HTML with only the tags and attributes allowed
+	// by the sanitizer, instead of converting the HTML into plain text. The converter functions aren't used in
+	// this mode. When MaxOutputLength is set, open tags are still closed after truncation, so the output may be
+	// slightly longer than the limit.
+	Sanitizer *HTMLSanitizer
+
+	// EmojiShortcodes, if set, is used to convert unicode emojis in text back into :shortcode: sequences.
+	// Text inside code blocks is never converted.
+	EmojiShortcodes mdext.EmojiDictionary
 }
 
 // TaggedString is a string that also contains a HTML tag.
@@ -320,54 +411,61 @@ func (parser *HTMLParser) imgToString(node *html.Node, ctx Context) string {
 	height := parser.getAttribute(node, "height")
 	_, isEmoji := parser.maybeGetAttribute(node, "data-mx-emoticon")
 	if parser.ImageConverter != nil {
-		return parser.ImageConverter(src, alt, title, width, height, isEmoji)
+		return parser.ImageConverter(src, alt, title, width, height, isEmoji, ctx)
 	}
 	return alt
 }
 
 func (parser *HTMLParser) tagToString(node *html.Node, ctx Context) string {
 	ctx = ctx.WithTag(node.Data)
+	if str, ok := parser.formatTag(node, ctx); ok {
+		return str
+	}
+	return parser.nodeToTagAwareString(node.FirstChild, ctx)
+}
+
+// formatTag converts tags that need special formatting into strings.
+// If the content of the tag is output as-is, this returns false.
+func (parser *HTMLParser) formatTag(node *html.Node, ctx Context) (string, bool) {
 	switch node.Data {
 	case "blockquote":
-		return parser.blockquoteToString(node, ctx)
+		return parser.blockquoteToString(node, ctx), true
 	case "ol", "ul":
-		return parser.listToString(node, ctx)
+		return parser.listToString(node, ctx), true
 	case "h1", "h2", "h3", "h4", "h5", "h6":
-		return parser.headerToString(node, ctx)
+		return parser.headerToString(node, ctx), true
 	case "br":
-		return parser.Newline
+		return parser.Newline, true
 	case "b", "strong", "i", "em", "s", "strike", "del", "u", "ins", "tt", "code":
-		return parser.basicFormatToString(node, ctx)
+		return parser.basicFormatToString(node, ctx), true
 	case "span", "font":
-		return parser.spanToString(node, ctx)
+		return parser.spanToString(node, ctx), true
 	case "a":
-		return parser.linkToString(node, ctx)
-	case "p":
-		return parser.nodeToTagAwareString(node.FirstChild, ctx)
+		return parser.linkToString(node, ctx), true
 	case "img":
-		return parser.imgToString(node, ctx)
+		return parser.imgToString(node, ctx), true
 	case "hr":
-		return parser.HorizontalLine
+		return parser.HorizontalLine, true
+	case "table":
+		return parser.tableToString(node, ctx), true
 	case "pre":
 		var preStr, language string
 		if node.FirstChild != nil && node.FirstChild.Type == html.ElementNode && node.FirstChild.Data == "code" {
-			class := parser.getAttribute(node.FirstChild, "class")
-			if strings.HasPrefix(class, "language-") {
-				language = class[len("language-"):]
-			}
+			language = CodeBlockLanguage(parser.getAttribute(node.FirstChild, "class"))
+			ctx = ctx.WithCodeLanguage(language)
 			preStr = parser.nodeToString(node.FirstChild.FirstChild, ctx.WithWhitespace())
 		} else {
 			preStr = parser.nodeToString(node.FirstChild, ctx.WithWhitespace())
 		}
 		if parser.MonospaceBlockConverter != nil {
-			return parser.MonospaceBlockConverter(preStr, language, ctx)
+			return parser.MonospaceBlockConverter(preStr, language, ctx), true
 		}
 		if len(preStr) == 0 || preStr[len(preStr)-1] != '\n' {
 			preStr += "\n"
 		}
-		return fmt.Sprintf("```%s\n%s```", language, preStr)
+		return fmt.Sprintf("```%s\n%s```", language, preStr), true
 	default:
-		return parser.nodeToTagAwareString(node.FirstChild, ctx)
+		return "", false
 	}
 }
 
@@ -377,6 +475,9 @@ func (parser *HTMLParser) singleNodeToString(node *html.Node, ctx Context) Tagge
 		if !ctx.PreserveWhitespace {
 			node.Data = strings.Replace(node.Data, "\n", "", -1)
 		}
+		if parser.EmojiShortcodes != nil && !ctx.TagStack.Has("code") && !ctx.TagStack.Has("pre") {
+			node.Data = parser.EmojiShortcodes.ReplaceEmojis(node.Data)
+		}
 		if parser.TextConverter != nil {
 			node.Data = parser.TextConverter(node.Data, ctx)
 		}
@@ -391,8 +492,16 @@ func (parser *HTMLParser) singleNodeToString(node *html.Node, ctx Context) Tagge
 }
 
 func (parser *HTMLParser) nodeToTaggedStrings(node *html.Node, ctx Context) (strs []TaggedString) {
+	limit := ctx.output.remaining()
+	length := 0
 	for ; node != nil; node = node.NextSibling {
-		strs = append(strs, parser.singleNodeToString(node, ctx))
+		if limit >= 0 && length > limit {
+			ctx.output.markTruncated()
+			break
+		}
+		str := parser.singleNodeToString(node, ctx)
+		length += len(str.string)
+		strs = append(strs, str)
 	}
 	return
 }
@@ -408,22 +517,60 @@ func (parser *HTMLParser) isBlockTag(tag string) bool {
 	return false
 }
 
+// nodeToTagAwareString converts the given node and its siblings into a string.
+//
+// The output is limited to what can still fit in the output of ctx, so long content inside
+// formatting tags is cut off during conversion instead of after the whole tag has been converted.
 func (parser *HTMLParser) nodeToTagAwareString(node *html.Node, ctx Context) string {
-	strs := parser.nodeToTaggedStrings(node, ctx)
+	if node == nil {
+		return ""
+	}
+	limit := ctx.output.remaining()
+	if limit == 0 {
+		ctx.output.markTruncated()
+		return ""
+	}
 	var output strings.Builder
-	for _, str := range strs {
-		tstr := str.string
-		if parser.isBlockTag(str.tag) {
-			tstr = fmt.Sprintf("\n%s\n", tstr)
+	tw := &trimmingWriter{w: &output, limit: max(limit, 0)}
+	parentOutput := ctx.output
+	ctx.output = tw
+	parser.writeNodes(tw, node, ctx)
+	if tw.Err() != nil {
+		parentOutput.markTruncated()
+	}
+	return output.String()
+}
+
+// writeNodes writes the given node and its siblings into the writer, surrounding block tags with newlines.
+// Tags whose content is output as-is are streamed into the writer instead of being converted into strings first.
+func (parser *HTMLParser) writeNodes(tw *trimmingWriter, node *html.Node, ctx Context) {
+	for ; node != nil && !tw.done(); node = node.NextSibling {
+		isBlock := node.Type == html.ElementNode && parser.isBlockTag(node.Data)
+		if isBlock {
+			tw.WriteString("\n")
+		}
+		switch node.Type {
+		case html.ElementNode:
+			tagCtx := ctx.WithTag(node.Data)
+			if str, ok := parser.formatTag(node, tagCtx); ok {
+				tw.WriteString(str)
+			} else {
+				parser.writeNodes(tw.child(), node.FirstChild, tagCtx)
+			}
+		case html.DocumentNode:
+			parser.writeNodes(tw.child(), node.FirstChild, ctx)
+		default:
+			tw.WriteString(parser.singleNodeToString(node, ctx).string)
+		}
+		if isBlock {
+			tw.WriteString("\n")
 		}
-		output.WriteString(tstr)
 	}
-	return strings.TrimSpace(output.String())
 }
 
 func (parser *HTMLParser) nodeToStrings(node *html.Node, ctx Context) (strs []string) {
-	for ; node != nil; node = node.NextSibling {
-		strs = append(strs, parser.singleNodeToString(node, ctx).string)
+	for _, str := range parser.nodeToTaggedStrings(node, ctx) {
+		strs = append(strs, str.string)
 	}
 	return
 }
@@ -434,11 +581,43 @@ func (parser *HTMLParser) nodeToString(node *html.Node, ctx Context) string {
 
 // Parse converts Matrix HTML into text using the settings in this parser.
 func (parser *HTMLParser) Parse(htmlData string, ctx Context) string {
+	var out strings.Builder
+	_ = parser.ParseTo(&out, htmlData, ctx)
+	return out.String()
+}
+
+// ErrOutputTooLong is returned by ParseTo if the output was truncated due to MaxOutputLength.
+var ErrOutputTooLong = errors.New("parsed output exceeds maximum length")
+
+// ParseTo converts Matrix HTML into text like Parse, but writes the output to the given writer while converting,
+// so that the output of large messages never has to be held in memory all at once.
+//
+// If MaxOutputLength is set and the output would exceed it, the output is truncated and ErrOutputTooLong is returned.
+// Conversion stops as soon as the limit is reached, including in the middle of nested formatting.
+func (parser *HTMLParser) ParseTo(w io.Writer, htmlData string, ctx Context) error {
+	if parser.MaxInputLength > 0 && len(htmlData) > parser.MaxInputLength {
+		htmlData = truncateUTF8(htmlData, parser.MaxInputLength)
+	}
+	if parser.Sanitizer != nil {
+		return parser.writeSanitized(w, htmlData)
+	}
 	if parser.TabsToSpaces >= 0 {
 		htmlData = strings.Replace(htmlData, "\t", strings.Repeat(" ", parser.TabsToSpaces), -1)
 	}
 	node, _ := html.Parse(strings.NewReader(htmlData))
-	return parser.nodeToTagAwareString(node, ctx)
+	tw := &trimmingWriter{w: w, limit: parser.MaxOutputLength}
+	ctx.output = tw
+	parser.writeNodes(tw, node, ctx)
+	return tw.Err()
+}
+
+func (parser *HTMLParser) writeSanitized(w io.Writer, htmlData string) error {
+	sanitized, truncated := parser.Sanitizer.sanitize(htmlData, parser.MaxOutputLength)
+	_, err := io.WriteString(w, sanitized)
+	if err == nil && truncated {
+		err = ErrOutputTooLong
+	}
+	return err
 }
 
 var TextHTMLParser = &HTMLParser{
@@ -448,6 +627,11 @@ var TextHTMLParser = &HTMLParser{
 	PillConverter:  DefaultPillConverter,
 }
 
+// SanitizingHTMLParser is a parser that outputs sanitized Matrix HTML using the default sanitizer settings.
+var SanitizingHTMLParser = &HTMLParser{
+	Sanitizer: DefaultSanitizer,
+}
+
 var MarkdownHTMLParser = &HTMLParser{
 	TabsToSpaces:   4,
 	Newline:        "\n",
@@ -468,6 +652,7 @@ var MarkdownHTMLParser = &HTMLParser{
 	UnderlineConverter: func(s string, c Context) string {
 		return fmt.Sprintf("<u>%s</u>", s)
 	},
+	MarkdownTables: true,
 }
 
 // HTMLToText converts Matrix HTML into text with the default settings.
@@ -481,10 +666,19 @@ func HTMLToText(html string) string {
 }
 
 func HTMLToMarkdownFull(parser *HTMLParser, html string) (parsed string, mentions *event.Mentions) {
+	return HTMLToMarkdownWithContext(parser, html, NewContext(context.TODO()))
+}
+
+// HTMLToMarkdownWithContext converts Matrix HTML into markdown using the given parser and context.
+//
+// The context can be created with NewEventContext to make pill rendering aware of the room the message is in.
+func HTMLToMarkdownWithContext(parser *HTMLParser, html string, ctx Context) (parsed string, mentions *event.Mentions) {
 	if parser == nil {
 		parser = MarkdownHTMLParser
 	}
-	ctx := NewContext(context.TODO())
+	if ctx.ReturnData == nil {
+		ctx.ReturnData = map[string]any{}
+	}
 	parsed = parser.Parse(html, ctx)
 	mentionList, _ := ctx.ReturnData[ContextKeyMentions].([]id.UserID)
 	mentions = &event.Mentions{
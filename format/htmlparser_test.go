@@ -0,0 +1,109 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package format_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestHTMLParser_ParseTo(t *testing.T) {
+	input := "<p>  hello</p><ul><li>foo</li><li>bar</li></ul> <b>world</b> <br>  "
+	var out strings.Builder
+	err := format.MarkdownHTMLParser.ParseTo(&out, input, format.NewContext(context.TODO()))
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n\n* foo\n* bar\n **world**", out.String())
+	assert.Equal(t, out.String(), format.MarkdownHTMLParser.Parse(input, format.NewContext(context.TODO())))
+}
+
+func TestHTMLParser_MaxOutputLength(t *testing.T) {
+	parser := &format.HTMLParser{
+		TabsToSpaces:    4,
+		Newline:         "\n",
+		MaxOutputLength: 10,
+	}
+	var out strings.Builder
+	err := parser.ParseTo(&out, strings.Repeat("<p>héllo wörld</p>", 10000), format.NewContext(context.TODO()))
+	assert.ErrorIs(t, err, format.ErrOutputTooLong)
+	assert.Equal(t, "héllo wö", out.String())
+}
+
+func TestHTMLParser_MaxOutputLength_Nested(t *testing.T) {
+	var boldInput string
+	parser := &format.HTMLParser{
+		TabsToSpaces:    4,
+		Newline:         "\n",
+		MaxOutputLength: 20,
+		BoldConverter: func(s string, ctx format.Context) string {
+			boldInput = s
+			return "**" + s + "**"
+		},
+	}
+	var out strings.Builder
+	input := "<blockquote><b>" + strings.Repeat("<i>word</i> ", 100000) + "</b></blockquote>"
+	err := parser.ParseTo(&out, input, format.NewContext(context.TODO()))
+	assert.ErrorIs(t, err, format.ErrOutputTooLong)
+	// The content of the bold tag must be cut off while converting it, not after
+	assert.Equal(t, "_word_ _word_ _word_", boldInput)
+	assert.Equal(t, "> **_word_ _word_ _w", out.String())
+}
+
+func TestHTMLParser_MaxInputLength(t *testing.T) {
+	parser := &format.HTMLParser{
+		TabsToSpaces:   4,
+		Newline:        "\n",
+		MaxInputLength: 12,
+	}
+	assert.Equal(t, "**hello**", parser.Parse("<b>hello</b> world", format.NewContext(context.TODO())))
+}
+
+type fakeMemberLookup map[id.UserID]*event.MemberEventContent
+
+func (fml fakeMemberLookup) TryGetMember(_ context.Context, roomID id.RoomID, userID id.UserID) (*event.MemberEventContent, error) {
+	if roomID != "!room:example.com" {
+		return nil, nil
+	}
+	return fml[userID], nil
+}
+
+func TestHTMLParser_EventContext(t *testing.T) {
+	members := fakeMemberLookup{"@user:example.com": {Displayname: "User"}}
+	evt := &event.Event{RoomID: "!room:example.com", ID: "$event"}
+	input := `hi <a href="https://matrix.to/#/@user:example.com">@user:example.com</a>`
+	parsed, mentions := format.HTMLToMarkdownWithContext(nil, input, format.NewEventContext(context.TODO(), evt, members))
+	assert.Equal(t, "hi User", parsed)
+	assert.Equal(t, []id.UserID{"@user:example.com"}, mentions.UserIDs)
+
+	parsed, _ = format.HTMLToMarkdownFull(nil, input)
+	assert.Equal(t, "hi @user:example.com", parsed)
+}
+
+func TestHTMLParser_Tables(t *testing.T) {
+	input := "<table><caption>People</caption><thead><tr><th>Name</th><th>Age</th></tr></thead>" +
+		"<tbody><tr><td>Alice</td><td>30</td></tr><tr><td>Bob | <b>Jr</b></td></tr></tbody></table>"
+	assert.Equal(t, "People\nName          Age\n------------  ---\nAlice         30\nBob | **Jr**", format.HTMLToText(input))
+	assert.Equal(t,
+		"People\n| Name          | Age |\n| ------------- | --- |\n| Alice         | 30  |\n| Bob \\| **Jr** |     |",
+		format.HTMLToMarkdown(input),
+	)
+
+	parser := &format.HTMLParser{Newline: "\n", MaxTableWidth: 12}
+	noHeader := "<p>before</p><table><tr><td>a very long cell</td><td>b</td></tr><tr><td>c</td><td>d</td></tr></table>"
+	assert.Equal(t, "before\n\na very l…  b\nc          d", parser.Parse(noHeader, format.NewContext(context.TODO())))
+	assert.Equal(t,
+		"|     |     |\n| --- | --- |\n| a   | b   |",
+		format.HTMLToMarkdown("<table><tr><td>a</td><td>b</td></tr></table>"),
+	)
+}
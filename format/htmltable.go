@@ -0,0 +1,199 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package format
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// minTableColumnWidth is the narrowest that MaxTableWidth will shrink a column to.
+const minTableColumnWidth = 3
+
+// Table is a HTML table that has been converted into text cells.
+type Table struct {
+	Caption string
+	// Rows contains the text of each cell. All rows are padded to have the same number of cells.
+	Rows [][]string
+	// HasHeader is true if the first row is a header row (i.e. it was in a <thead> or only had <th> cells).
+	HasHeader bool
+}
+
+// TableConverter is used to render tables in the HTML parser.
+type TableConverter func(table *Table, ctx Context) string
+
+func (parser *HTMLParser) tableRowToCells(node *html.Node, ctx Context) (cells []string, allHeaders bool) {
+	allHeaders = true
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode || (child.Data != "td" && child.Data != "th") {
+			continue
+		}
+		allHeaders = allHeaders && child.Data == "th"
+		str := parser.nodeToTagAwareString(child.FirstChild, ctx.WithTag(child.Data))
+		cells = append(cells, strings.Join(strings.Fields(str), " "))
+	}
+	return cells, allHeaders && len(cells) > 0
+}
+
+func (parser *HTMLParser) parseTable(node *html.Node, ctx Context) *Table {
+	var table Table
+	addRow := func(row *html.Node, ctx Context, isHeader bool) {
+		cells, allHeaders := parser.tableRowToCells(row, ctx.WithTag("tr"))
+		if len(cells) == 0 {
+			return
+		}
+		if len(table.Rows) == 0 {
+			table.HasHeader = isHeader || allHeaders
+		}
+		table.Rows = append(table.Rows, cells)
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode {
+			continue
+		}
+		switch child.Data {
+		case "caption":
+			table.Caption = parser.nodeToTagAwareString(child.FirstChild, ctx.WithTag(child.Data))
+		case "thead", "tbody", "tfoot":
+			sectionCtx := ctx.WithTag(child.Data)
+			for row := child.FirstChild; row != nil; row = row.NextSibling {
+				if row.Type == html.ElementNode && row.Data == "tr" {
+					addRow(row, sectionCtx, child.Data == "thead")
+				}
+			}
+		case "tr":
+			addRow(child, ctx, false)
+		}
+	}
+	columns := 0
+	for _, row := range table.Rows {
+		columns = max(columns, len(row))
+	}
+	for i, row := range table.Rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		table.Rows[i] = row
+	}
+	return &table
+}
+
+func truncateTableCell(cell string, width int) string {
+	if utf8.RuneCountInString(cell) <= width {
+		return cell
+	}
+	runes := []rune(cell)
+	return string(runes[:width-1]) + "…"
+}
+
+func (parser *HTMLParser) tableColumnWidths(table *Table, separatorWidth, edgeWidth int) []int {
+	widths := make([]int, len(table.Rows[0]))
+	for _, row := range table.Rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	if parser.MaxTableWidth <= 0 {
+		return widths
+	}
+	total := edgeWidth + separatorWidth*(len(widths)-1)
+	for _, width := range widths {
+		total += width
+	}
+	for total > parser.MaxTableWidth {
+		widest := 0
+		for i, width := range widths {
+			if width > widths[widest] {
+				widest = i
+			}
+		}
+		if widths[widest] <= minTableColumnWidth {
+			break
+		}
+		widths[widest]--
+		total--
+	}
+	return widths
+}
+
+func padTableCell(cell string, width int) string {
+	cell = truncateTableCell(cell, width)
+	return cell + strings.Repeat(" ", width-utf8.RuneCountInString(cell))
+}
+
+func (parser *HTMLParser) tableToString(node *html.Node, ctx Context) string {
+	table := parser.parseTable(node, ctx)
+	if parser.TableConverter != nil {
+		return parser.TableConverter(table, ctx)
+	} else if len(table.Rows) == 0 {
+		return table.Caption
+	}
+	var lines []string
+	if table.Caption != "" {
+		lines = append(lines, table.Caption)
+	}
+	if parser.MarkdownTables {
+		escaped := &Table{Rows: make([][]string, len(table.Rows)), HasHeader: table.HasHeader}
+		for i, row := range table.Rows {
+			escaped.Rows[i] = make([]string, len(row))
+			for j, cell := range row {
+				escaped.Rows[i][j] = strings.ReplaceAll(cell, "|", "\\|")
+			}
+		}
+		table = escaped
+		widths := parser.tableColumnWidths(table, 3, 4)
+		for i, width := range widths {
+			// The separator row needs at least three dashes
+			widths[i] = max(width, minTableColumnWidth)
+		}
+		writeRow := func(cells []string) {
+			padded := make([]string, len(cells))
+			for i, cell := range cells {
+				padded[i] = padTableCell(cell, widths[i])
+			}
+			lines = append(lines, "| "+strings.Join(padded, " | ")+" |")
+		}
+		rows := table.Rows
+		if table.HasHeader {
+			writeRow(rows[0])
+			rows = rows[1:]
+		} else {
+			// Markdown tables always need a header row
+			writeRow(make([]string, len(widths)))
+		}
+		separators := make([]string, len(widths))
+		for i, width := range widths {
+			separators[i] = strings.Repeat("-", width)
+		}
+		lines = append(lines, "| "+strings.Join(separators, " | ")+" |")
+		for _, row := range rows {
+			writeRow(row)
+		}
+	} else {
+		widths := parser.tableColumnWidths(table, 2, 0)
+		writeRow := func(cells []string) {
+			padded := make([]string, len(cells))
+			for i, cell := range cells {
+				padded[i] = padTableCell(cell, widths[i])
+			}
+			lines = append(lines, strings.TrimRight(strings.Join(padded, "  "), " "))
+		}
+		for i, row := range table.Rows {
+			writeRow(row)
+			if i == 0 && table.HasHeader {
+				separators := make([]string, len(widths))
+				for j, width := range widths {
+					separators[j] = strings.Repeat("-", width)
+				}
+				lines = append(lines, strings.Join(separators, "  "))
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
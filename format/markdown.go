@@ -27,6 +27,108 @@ var HTMLOptions = goldmark.WithRendererOptions(html.WithHardWraps(), html.WithUn
 var withHTML = goldmark.New(Extensions, HTMLOptions)
 var noHTML = goldmark.New(Extensions, HTMLOptions, goldmark.WithExtensions(mdext.EscapeHTML))
 
+// StrikethroughSyntax specifies which tilde syntax is used for strikethrough in MarkdownConfig.
+type StrikethroughSyntax int
+
+const (
+	// StrikethroughNone disables strikethrough.
+	StrikethroughNone StrikethroughSyntax = iota
+	// StrikethroughGFM allows both ~single~ and ~~double~~ tildes like GitHub-flavored markdown.
+	StrikethroughGFM
+	// StrikethroughShort only allows ~single~ tildes.
+	StrikethroughShort
+	// StrikethroughLong only allows ~~double~~ tildes.
+	StrikethroughLong
+)
+
+// SpoilerSyntax specifies which spoiler syntax is enabled in MarkdownConfig.
+type SpoilerSyntax int
+
+const (
+	// SpoilersNone disables spoilers.
+	SpoilersNone SpoilerSyntax = iota
+	// SpoilersSimple allows ||spoiler|| without reasons.
+	SpoilersSimple
+	// SpoilersWithReason allows ||spoiler|| and ||reason|spoiler||.
+	SpoilersWithReason
+)
+
+// MarkdownConfig specifies which markdown extensions are enabled in a renderer created with NewMarkdownRenderer.
+type MarkdownConfig struct {
+	// If false, HTML tags in the input are escaped instead of being passed through.
+	AllowHTML bool
+
+	Strikethrough StrikethroughSyntax
+	Spoilers      SpoilerSyntax
+	Tables        bool
+	// Treat __double underscores__ as underline instead of bold.
+	DiscordUnderline bool
+	// Enable H~2~O subscript and x^2^ superscript. If this is enabled, single tildes are always subscript,
+	// so StrikethroughShort doesn't work and StrikethroughGFM only works with double tildes.
+	SubSuperscript bool
+	// Enable PHP Markdown Extra style definition lists. The <dl> tag is not in the list of HTML tags
+	// recommended by the spec, so some clients may not render them.
+	DefinitionLists bool
+	// Enable $inline$ and $$block$$ math.
+	Math bool
+	// If set, the contents of fenced code blocks are passed through this function for syntax highlighting.
+	CodeHighlighter mdext.CodeHighlighter
+
+	// Additional extensions to enable.
+	Extra []goldmark.Extender
+}
+
+// DefaultMarkdownConfig matches the extensions used by RenderMarkdown.
+var DefaultMarkdownConfig = MarkdownConfig{
+	AllowHTML:     true,
+	Strikethrough: StrikethroughGFM,
+	Spoilers:      SpoilersWithReason,
+	Tables:        true,
+}
+
+// NewMarkdownRenderer creates a new markdown renderer with the extensions specified in the config.
+// The returned renderer can be used with RenderMarkdownCustom.
+func NewMarkdownRenderer(cfg MarkdownConfig) goldmark.Markdown {
+	var exts []goldmark.Extender
+	switch cfg.Strikethrough {
+	case StrikethroughGFM:
+		exts = append(exts, extension.Strikethrough)
+	case StrikethroughShort:
+		exts = append(exts, mdext.ShortStrike)
+	case StrikethroughLong:
+		exts = append(exts, mdext.LongStrike)
+	}
+	switch cfg.Spoilers {
+	case SpoilersSimple:
+		exts = append(exts, mdext.SimpleSpoiler)
+	case SpoilersWithReason:
+		exts = append(exts, mdext.Spoiler)
+	}
+	if cfg.Tables {
+		exts = append(exts, extension.Table)
+	}
+	if cfg.DiscordUnderline {
+		exts = append(exts, mdext.DiscordUnderline)
+	}
+	if cfg.SubSuperscript {
+		exts = append(exts, mdext.Subscript, mdext.Superscript)
+	}
+	if cfg.DefinitionLists {
+		exts = append(exts, extension.DefinitionList)
+	}
+	if cfg.Math {
+		exts = append(exts, mdext.Math)
+	}
+	if cfg.CodeHighlighter != nil {
+		exts = append(exts, mdext.NewCodeHighlight(cfg.CodeHighlighter))
+	}
+	if !cfg.AllowHTML {
+		exts = append(exts, mdext.EscapeHTML)
+	}
+	exts = append(exts, cfg.Extra...)
+	return goldmark.New(goldmark.WithExtensions(exts...), HTMLOptions)
+}
+
 // UnwrapSingleParagraph removes paragraph tags surrounding a string if the string only contains a single paragraph.
 func UnwrapSingleParagraph(html string) string {
 	html = strings.TrimRight(html, "\n")
@@ -7,6 +7,7 @@
 package format_test
 
 import (
+	"context"
 	"strings"
 	"testing"
 
@@ -196,3 +197,111 @@ func TestRenderMarkdown_CustomEmoji(t *testing.T) {
 		assert.Equal(t, html, rendered, "with input %q", markdown)
 	}
 }
+
+type testCustomEmojis map[string]id.ContentURIString
+
+func (tce testCustomEmojis) ShortcodeToCustomEmoji(shortcode string) (id.ContentURIString, bool) {
+	url, ok := tce[shortcode]
+	return url, ok
+}
+
+var customEmojiShortcodeTests = map[string]string{
+	"hi :meow:":     `hi <img src="mxc://example.com/meow" alt=":meow:" title="meow" data-mx-emoticon="" height="32">`,
+	":wave: :nope:": "👋 :nope:",
+}
+
+func TestRenderMarkdown_CustomEmojiShortcode(t *testing.T) {
+	dict := testCustomEmojis{"meow": "mxc://example.com/meow"}
+	renderer := goldmark.New(goldmark.WithExtensions(mdext.CustomEmoji, mdext.NewCustomEmojiShortcode(dict), mdext.EmojiShortcode), format.HTMLOptions)
+	for markdown, html := range customEmojiShortcodeTests {
+		rendered := format.UnwrapSingleParagraph(render(renderer, markdown))
+		assert.Equal(t, html, strings.TrimSpace(rendered), "with input %q", markdown)
+	}
+}
+
+var markdownConfigTests = map[string]string{
+	"H~2~O and x^2^":             "H<sub>2</sub>O and x<sup>2</sup>",
+	"~~strike~~ ||spoiler||":     `<del>strike</del> <span data-mx-spoiler>spoiler</span>`,
+	"__underline__ <b>html</b>":  "<u>underline</u> &lt;b&gt;html&lt;/b&gt;",
+	"Term\n: Definition":         "<dl>\n<dt>Term</dt>\n<dd>Definition</dd>\n</dl>",
+	"2^10 ~ 1000 and a~b c~d e~": "2^10 ~ 1000 and a<sub>b c</sub>d e~",
+}
+
+func TestNewMarkdownRenderer(t *testing.T) {
+	renderer := format.NewMarkdownRenderer(format.MarkdownConfig{
+		Strikethrough:    format.StrikethroughLong,
+		Spoilers:         format.SpoilersSimple,
+		DiscordUnderline: true,
+		SubSuperscript:   true,
+		DefinitionLists:  true,
+	})
+	for markdown, html := range markdownConfigTests {
+		rendered := format.UnwrapSingleParagraph(render(renderer, markdown))
+		assert.Equal(t, html, strings.TrimSpace(rendered), "with input %q", markdown)
+	}
+}
+
+var emojiShortcodeTests = map[string]string{
+	"hello :wave:":          "hello 👋",
+	":tada: :unknown: :+1:": "🎉 :unknown: 👍️",
+	"`:wave:`":              "<code>:wave:</code>",
+	"10:30:45":              "10:30:45",
+	"**:fire:**":            "<strong>🔥</strong>",
+}
+
+func TestRenderMarkdown_EmojiShortcode(t *testing.T) {
+	renderer := goldmark.New(goldmark.WithExtensions(mdext.EmojiShortcode), format.HTMLOptions)
+	for markdown, html := range emojiShortcodeTests {
+		rendered := format.UnwrapSingleParagraph(render(renderer, markdown))
+		assert.Equal(t, html, strings.TrimSpace(rendered), "with input %q", markdown)
+	}
+}
+
+func TestHTMLParser_EmojiShortcodes(t *testing.T) {
+	parser := &format.HTMLParser{
+		TabsToSpaces:    4,
+		Newline:         "\n",
+		EmojiShortcodes: mdext.DefaultEmojiMap,
+	}
+	parsed := parser.Parse("hello 👋 <code>👋</code> 🏳️‍🌈", format.NewContext(context.TODO()))
+	assert.Equal(t, "hello :wave: `👋` :rainbow_flag:", parsed)
+}
+
+func TestRenderMarkdown_CodeBlockLanguage(t *testing.T) {
+	content := format.RenderMarkdown("```go\nfmt.Println(\"<hi>\")\n```", true, true)
+	assert.Equal(t, "<pre><code class=\"language-go\">fmt.Println(&quot;&lt;hi&gt;&quot;)\n</code></pre>", content.FormattedBody)
+	assert.Equal(t, "```go\nfmt.Println(\"<hi>\")\n```", content.Body)
+
+	renderer := format.NewMarkdownRenderer(format.MarkdownConfig{
+		CodeHighlighter: func(code, language string) (string, bool) {
+			if language != "go" {
+				return "", false
+			}
+			return `<span data-mx-color="#ff0000">` + strings.TrimSpace(code) + "</span>\n", true
+		},
+	})
+	assert.Equal(t,
+		"<pre><code class=\"language-go\"><span data-mx-color=\"#ff0000\">x := 1</span>\n</code></pre>",
+		strings.TrimSpace(render(renderer, "```go\nx := 1\n```")),
+	)
+	assert.Equal(t,
+		"<pre><code class=\"language-py\">x &lt; 1\n</code></pre>",
+		strings.TrimSpace(render(renderer, "```py\nx < 1\n```")),
+	)
+}
+
+func TestHTMLParser_CodeLanguage(t *testing.T) {
+	var languages []string
+	parser := &format.HTMLParser{
+		Newline: "\n",
+		TextConverter: func(s string, ctx format.Context) string {
+			if ctx.TagStack.Has("pre") {
+				languages = append(languages, ctx.CodeLanguage)
+			}
+			return s
+		},
+	}
+	parsed := parser.Parse(`<pre><code class="hljs language-rust">let x = 1;</code></pre>`, format.NewContext(context.TODO()))
+	assert.Equal(t, "```rust\nlet x = 1;\n```", parsed)
+	assert.Equal(t, []string{"rust"}, languages)
+}
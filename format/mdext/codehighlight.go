@@ -0,0 +1,70 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mdext
+
+import (
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+)
+
+// CodeHighlighter is a function that syntax highlights the contents of a fenced code block.
+//
+// The returned HTML is placed inside the <code> tag as-is, so it must be escaped properly.
+// Matrix only allows a limited set of tags, so highlighting should generally be done
+// with <span data-mx-color="#rrggbb"> tags. If ok is false, the code is rendered normally.
+type CodeHighlighter func(code, language string) (highlighted string, ok bool)
+
+type codeHighlightRenderer struct {
+	html.Config
+	highlight CodeHighlighter
+}
+
+func (r *codeHighlightRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.renderFencedCodeBlock)
+}
+
+func (r *codeHighlightRenderer) renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		_, _ = w.WriteString("</code></pre>\n")
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.FencedCodeBlock)
+	_, _ = w.WriteString("<pre><code")
+	language := n.Language(source)
+	if language != nil {
+		_, _ = w.WriteString(` class="language-`)
+		r.Writer.Write(w, language)
+		_ = w.WriteByte('"')
+	}
+	_ = w.WriteByte('>')
+	code := n.Lines().Value(source)
+	if highlighted, ok := r.highlight(string(code), string(language)); ok {
+		_, _ = w.WriteString(highlighted)
+	} else {
+		r.Writer.RawWrite(w, code)
+	}
+	return ast.WalkContinue, nil
+}
+
+type codeHighlight struct {
+	highlight CodeHighlighter
+}
+
+// NewCodeHighlight creates an extension that passes the contents of fenced code blocks through the given
+// highlighter function. The language from the info string is still included as a language-* class.
+func NewCodeHighlight(highlight CodeHighlighter) goldmark.Extender {
+	return &codeHighlight{highlight: highlight}
+}
+
+func (e *codeHighlight) Extend(m goldmark.Markdown) {
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&codeHighlightRenderer{Config: html.NewConfig(), highlight: e.highlight}, 500),
+	))
+}
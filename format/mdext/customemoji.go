@@ -8,11 +8,16 @@ package mdext
 
 import (
 	"bytes"
+	"slices"
 
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
 	"github.com/yuin/goldmark/util"
+
+	"maunium.net/go/mautrix/id"
 )
 
 type extCustomEmoji struct{}
@@ -71,3 +76,61 @@ func (cer *customEmojiRenderer) renderImage(w util.BufWriter, source []byte, nod
 	}
 	return cer.funcs.renderImage(w, source, node, entering)
 }
+
+// CustomEmojiDictionary maps shortcodes (without the surrounding colons) to custom emoji images.
+type CustomEmojiDictionary interface {
+	ShortcodeToCustomEmoji(shortcode string) (id.ContentURIString, bool)
+}
+
+type customEmojiShortcodeParser struct {
+	dict CustomEmojiDictionary
+}
+
+func (s *customEmojiShortcodeParser) Trigger() []byte {
+	return []byte{':'}
+}
+
+func (s *customEmojiShortcodeParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	if len(line) < 3 {
+		return nil
+	}
+	end := 1
+	for end < len(line) && isShortcodeChar(line[end]) {
+		end++
+	}
+	if end == 1 || end >= len(line) || line[end] != ':' {
+		return nil
+	}
+	shortcode := string(line[1:end])
+	url, ok := s.dict.ShortcodeToCustomEmoji(shortcode)
+	if !ok {
+		return nil
+	}
+	block.Advance(end + 1)
+	link := ast.NewLink()
+	link.Destination = []byte(url)
+	link.Title = append(slices.Clone(emojiPrefix), shortcode...)
+	image := ast.NewImage(link)
+	image.AppendChild(image, ast.NewString([]byte(":"+shortcode+":")))
+	return image
+}
+
+type extCustomEmojiShortcode struct {
+	dict CustomEmojiDictionary
+}
+
+// NewCustomEmojiShortcode returns an extension that converts :shortcode: sequences into custom emoji images
+// using the given dictionary (e.g. a mautrix.ImagePackIndex). Shortcodes that aren't in the dictionary are left
+// for other extensions like EmojiShortcode.
+//
+// The CustomEmoji extension must also be enabled for the images to be rendered as custom emojis.
+func NewCustomEmojiShortcode(dict CustomEmojiDictionary) goldmark.Extender {
+	return &extCustomEmojiShortcode{dict: dict}
+}
+
+func (e *extCustomEmojiShortcode) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(
+		util.Prioritized(&customEmojiShortcodeParser{dict: e.dict}, 599),
+	))
+}
@@ -0,0 +1,276 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mdext
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// EmojiDictionary maps emoji shortcodes (without the surrounding colons) to unicode emojis and back.
+type EmojiDictionary interface {
+	// ShortcodeToEmoji returns the unicode emoji for the given shortcode.
+	ShortcodeToEmoji(shortcode string) (string, bool)
+	// ReplaceEmojis replaces all known unicode emojis in the given text with :shortcode: sequences.
+	ReplaceEmojis(text string) string
+}
+
+// EmojiMap is a simple EmojiDictionary backed by a map from shortcode to unicode emoji.
+type EmojiMap struct {
+	shortcodes   map[string]string
+	replacer     *strings.Replacer
+	replacerOnce sync.Once
+}
+
+var _ EmojiDictionary = (*EmojiMap)(nil)
+
+// NewEmojiMap creates an EmojiDictionary from the given shortcode -> emoji map.
+//
+// The map must not be modified after it's passed to this function.
+func NewEmojiMap(shortcodes map[string]string) *EmojiMap {
+	return &EmojiMap{shortcodes: shortcodes}
+}
+
+func (em *EmojiMap) ShortcodeToEmoji(shortcode string) (string, bool) {
+	emoji, ok := em.shortcodes[shortcode]
+	return emoji, ok
+}
+
+func (em *EmojiMap) buildReplacer() {
+	shortcodes := make([]string, 0, len(em.shortcodes))
+	for shortcode := range em.shortcodes {
+		shortcodes = append(shortcodes, shortcode)
+	}
+	// Prefer longer emojis (e.g. ZWJ sequences) and make the choice between aliases deterministic
+	sort.Slice(shortcodes, func(i, j int) bool {
+		a, b := em.shortcodes[shortcodes[i]], em.shortcodes[shortcodes[j]]
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		if a != b {
+			return a < b
+		}
+		return shortcodes[i] < shortcodes[j]
+	})
+	pairs := make([]string, 0, len(shortcodes)*2)
+	seen := make(map[string]struct{}, len(shortcodes))
+	for _, shortcode := range shortcodes {
+		emoji := em.shortcodes[shortcode]
+		if _, alreadyAdded := seen[emoji]; alreadyAdded {
+			continue
+		}
+		seen[emoji] = struct{}{}
+		pairs = append(pairs, emoji, ":"+shortcode+":")
+	}
+	em.replacer = strings.NewReplacer(pairs...)
+}
+
+func (em *EmojiMap) ReplaceEmojis(text string) string {
+	em.replacerOnce.Do(em.buildReplacer)
+	return em.replacer.Replace(text)
+}
+
+// DefaultEmojiMap contains a small set of commonly used emoji shortcodes.
+//
+// Applications that want full coverage should provide their own EmojiDictionary
+// (e.g. generated from the Unicode emoji data files).
+var DefaultEmojiMap = NewEmojiMap(map[string]string{
+	"+1":                  "👍️",
+	"thumbsup":            "👍️",
+	"-1":                  "👎️",
+	"thumbsdown":          "👎️",
+	"smile":               "😄",
+	"smiley":              "😃",
+	"grinning":            "😀",
+	"grin":                "😁",
+	"joy":                 "😂",
+	"rofl":                "🤣",
+	"laughing":            "😆",
+	"sweat_smile":         "😅",
+	"slight_smile":        "🙂",
+	"upside_down":         "🙃",
+	"wink":                "😉",
+	"blush":               "😊",
+	"innocent":            "😇",
+	"heart_eyes":          "😍",
+	"kissing_heart":       "😘",
+	"yum":                 "😋",
+	"stuck_out_tongue":    "😛",
+	"thinking":            "🤔",
+	"neutral_face":        "😐",
+	"expressionless":      "😑",
+	"no_mouth":            "😶",
+	"smirk":               "😏",
+	"unamused":            "😒",
+	"roll_eyes":           "🙄",
+	"grimacing":           "😬",
+	"relieved":            "😌",
+	"pensive":             "😔",
+	"sleepy":              "😪",
+	"sleeping":            "😴",
+	"mask":                "😷",
+	"sunglasses":          "😎",
+	"nerd":                "🤓",
+	"confused":            "😕",
+	"worried":             "😟",
+	"slight_frown":        "🙁",
+	"open_mouth":          "😮",
+	"astonished":          "😲",
+	"flushed":             "😳",
+	"pleading":            "🥺",
+	"cry":                 "😢",
+	"sob":                 "😭",
+	"scream":              "😱",
+	"angry":               "😠",
+	"rage":                "😡",
+	"skull":               "💀",
+	"poop":                "💩",
+	"clown":               "🤡",
+	"ghost":               "👻",
+	"robot":               "🤖",
+	"cat":                 "🐱",
+	"dog":                 "🐶",
+	"wave":                "👋",
+	"ok_hand":             "👌",
+	"v":                   "✌️",
+	"crossed_fingers":     "🤞",
+	"point_up":            "☝️",
+	"point_right":         "👉",
+	"point_left":          "👈",
+	"clap":                "👏",
+	"raised_hands":        "🙌",
+	"pray":                "🙏",
+	"muscle":              "💪",
+	"eyes":                "👀",
+	"heart":               "❤️",
+	"orange_heart":        "🧡",
+	"yellow_heart":        "💛",
+	"green_heart":         "💚",
+	"blue_heart":          "💙",
+	"purple_heart":        "💜",
+	"black_heart":         "🖤",
+	"broken_heart":        "💔",
+	"sparkling_heart":     "💖",
+	"100":                 "💯",
+	"fire":                "🔥",
+	"sparkles":            "✨",
+	"star":                "⭐️",
+	"zap":                 "⚡️",
+	"boom":                "💥",
+	"tada":                "🎉",
+	"confetti_ball":       "🎊",
+	"gift":                "🎁",
+	"rocket":              "🚀",
+	"warning":             "⚠️",
+	"x":                   "❌",
+	"white_check_mark":    "✅",
+	"heavy_check_mark":    "✔️",
+	"question":            "❓",
+	"exclamation":         "❗️",
+	"bulb":                "💡",
+	"lock":                "🔒",
+	"unlock":              "🔓",
+	"key":                 "🔑",
+	"bell":                "🔔",
+	"no_bell":             "🔕",
+	"pushpin":             "📌",
+	"memo":                "📝",
+	"coffee":              "☕️",
+	"beer":                "🍺",
+	"pizza":               "🍕",
+	"cake":                "🍰",
+	"sun":                 "☀️",
+	"rainbow":             "🌈",
+	"earth_africa":        "🌍",
+	"face_with_monocle":   "🧐",
+	"exploding_head":      "🤯",
+	"partying_face":       "🥳",
+	"face_palm":           "🤦",
+	"shrug":               "🤷",
+	"rainbow_flag":        "🏳️‍🌈",
+	"transgender_flag":    "🏳️‍⚧️",
+	"heavy_plus_sign":     "➕",
+	"heavy_minus_sign":    "➖",
+	"arrow_right":         "➡️",
+	"arrow_left":          "⬅️",
+	"arrow_up":            "⬆️",
+	"arrow_down":          "⬇️",
+	"hourglass":           "⌛️",
+	"hourglass_flowing":   "⏳",
+	"speech_balloon":      "💬",
+	"link":                "🔗",
+	"paperclip":           "📎",
+	"calendar":            "📅",
+	"mag":                 "🔍",
+	"wrench":              "🔧",
+	"hammer":              "🔨",
+	"gear":                "⚙️",
+	"bug":                 "🐛",
+	"see_no_evil":         "🙈",
+	"hear_no_evil":        "🙉",
+	"speak_no_evil":       "🙊",
+	"money_with_wings":    "💸",
+	"chart_with_upwards":  "📈",
+	"chart_with_downward": "📉",
+})
+
+type emojiShortcodeParser struct {
+	dict EmojiDictionary
+}
+
+func (s *emojiShortcodeParser) Trigger() []byte {
+	return []byte{':'}
+}
+
+func isShortcodeChar(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') || b == '_' || b == '-' || b == '+'
+}
+
+func (s *emojiShortcodeParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	if len(line) < 3 {
+		return nil
+	}
+	end := 1
+	for end < len(line) && isShortcodeChar(line[end]) {
+		end++
+	}
+	if end == 1 || end >= len(line) || line[end] != ':' {
+		return nil
+	}
+	emoji, ok := s.dict.ShortcodeToEmoji(string(line[1:end]))
+	if !ok {
+		return nil
+	}
+	block.Advance(end + 1)
+	return ast.NewString([]byte(emoji))
+}
+
+type extEmojiShortcode struct {
+	dict EmojiDictionary
+}
+
+// EmojiShortcode is an extension that converts :shortcode: sequences into unicode emojis using DefaultEmojiMap.
+var EmojiShortcode = &extEmojiShortcode{dict: DefaultEmojiMap}
+
+// NewEmojiShortcode returns an extension that converts :shortcode: sequences into unicode emojis using the given dictionary.
+func NewEmojiShortcode(dict EmojiDictionary) goldmark.Extender {
+	return &extEmojiShortcode{dict: dict}
+}
+
+func (e *extEmojiShortcode) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(
+		util.Prioritized(&emojiShortcodeParser{dict: e.dict}, 600),
+	))
+}
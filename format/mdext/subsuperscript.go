@@ -0,0 +1,119 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mdext
+
+import (
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+type astScript struct {
+	ast.BaseInline
+	kind ast.NodeKind
+}
+
+func (n *astScript) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+var astKindSubscript = ast.NewNodeKind("Subscript")
+var astKindSuperscript = ast.NewNodeKind("Superscript")
+
+func (n *astScript) Kind() ast.NodeKind {
+	return n.kind
+}
+
+type scriptDelimiterProcessor struct {
+	char byte
+	kind ast.NodeKind
+}
+
+func (p *scriptDelimiterProcessor) IsDelimiter(b byte) bool {
+	return b == p.char
+}
+
+func (p *scriptDelimiterProcessor) CanOpenCloser(opener, closer *parser.Delimiter) bool {
+	return opener.Char == closer.Char && opener.OriginalLength == 1 && closer.OriginalLength == 1
+}
+
+func (p *scriptDelimiterProcessor) OnMatch(consumes int) ast.Node {
+	return &astScript{kind: p.kind}
+}
+
+type scriptParser struct {
+	processor *scriptDelimiterProcessor
+}
+
+func (s *scriptParser) Trigger() []byte {
+	return []byte{s.processor.char}
+}
+
+func (s *scriptParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	before := block.PrecendingCharacter()
+	line, segment := block.PeekLine()
+	node := parser.ScanDelimiter(line, before, 1, s.processor)
+	// Only single characters are handled here, so that e.g. ~~strikethrough~~ is left for the strikethrough parser.
+	if node == nil || node.OriginalLength != 1 || before == rune(s.processor.char) {
+		return nil
+	}
+	node.Segment = segment.WithStop(segment.Start + node.OriginalLength)
+	block.Advance(node.OriginalLength)
+	pc.PushDelimiter(node)
+	return node
+}
+
+func (s *scriptParser) CloseBlock(parent ast.Node, pc parser.Context) {
+	// nothing to do
+}
+
+type scriptHTMLRenderer struct {
+	html.Config
+}
+
+func (r *scriptHTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(astKindSubscript, r.renderScript("sub"))
+	reg.Register(astKindSuperscript, r.renderScript("sup"))
+}
+
+func (r *scriptHTMLRenderer) renderScript(tag string) renderer.NodeRendererFunc {
+	return func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			_, _ = w.WriteString("<" + tag + ">")
+		} else {
+			_, _ = w.WriteString("</" + tag + ">")
+		}
+		return ast.WalkContinue, nil
+	}
+}
+
+type extScript struct {
+	processor *scriptDelimiterProcessor
+}
+
+// Subscript is an extension that allows you to use subscript expressions like 'H~2~O'.
+//
+// It only handles single tildes, so it can be combined with LongStrike, but not with ShortStrike.
+// When combined with the GFM strikethrough extension, single tildes will be treated as subscript.
+var Subscript goldmark.Extender = &extScript{processor: &scriptDelimiterProcessor{char: '~', kind: astKindSubscript}}
+
+// Superscript is an extension that allows you to use superscript expressions like 'x^2^'.
+var Superscript goldmark.Extender = &extScript{processor: &scriptDelimiterProcessor{char: '^', kind: astKindSuperscript}}
+
+func (e *extScript) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(
+		// This must be a higher priority than the strikethrough parsers so that single tildes become subscript.
+		util.Prioritized(&scriptParser{processor: e.processor}, 499),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&scriptHTMLRenderer{Config: html.NewConfig()}, 500),
+	))
+}
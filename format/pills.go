@@ -0,0 +1,117 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package format
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// PillURIStyle specifies which kind of link is used in pills.
+type PillURIStyle int
+
+const (
+	// PillMatrixTo makes pills link to https://matrix.to URLs, which are supported by all clients.
+	PillMatrixTo PillURIStyle = iota
+	// PillMatrixURI makes pills link to matrix: URIs.
+	PillMatrixURI
+)
+
+func (style PillURIStyle) format(uri *id.MatrixURI) string {
+	if uri == nil {
+		return ""
+	} else if style == PillMatrixURI {
+		return uri.String()
+	}
+	return uri.MatrixToURL()
+}
+
+func makePill(href, text string) string {
+	return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(href), html.EscapeString(text))
+}
+
+// UserPill returns a HTML link that mentions the given user. If the displayname is empty, the user ID is used.
+//
+// The displayname should be disambiguated using DisambiguateDisplaynames if there may be multiple
+// members with the same name in the room.
+func UserPill(userID id.UserID, displayname string, style PillURIStyle) string {
+	if displayname == "" {
+		displayname = userID.String()
+	}
+	return makePill(style.format(userID.URI()), displayname)
+}
+
+// RoomPill returns a HTML link to the given room ID. If the name is empty, the room ID is used.
+func RoomPill(roomID id.RoomID, name string, style PillURIStyle, via ...string) string {
+	if name == "" {
+		name = roomID.String()
+	}
+	return makePill(style.format(roomID.URI(via...)), name)
+}
+
+// RoomAliasPill returns a HTML link to the given room alias.
+func RoomAliasPill(alias id.RoomAlias, style PillURIStyle) string {
+	return makePill(style.format(alias.URI()), alias.String())
+}
+
+// DisambiguateDisplaynames returns the names that should be shown for the given room members.
+//
+// Members whose displayname is empty or shared with another member get their user ID included in the name,
+// e.g. "Alice (@alice:example.com)", like the client-server spec recommends for calculating display names.
+func DisambiguateDisplaynames(members map[id.UserID]string) map[id.UserID]string {
+	nameCounts := make(map[string]int, len(members))
+	for _, name := range members {
+		nameCounts[strings.TrimSpace(name)]++
+	}
+	output := make(map[id.UserID]string, len(members))
+	for userID, name := range members {
+		trimmed := strings.TrimSpace(name)
+		if trimmed == "" {
+			output[userID] = userID.String()
+		} else if nameCounts[trimmed] > 1 {
+			output[userID] = fmt.Sprintf("%s (%s)", trimmed, userID)
+		} else {
+			output[userID] = trimmed
+		}
+	}
+	return output
+}
+
+// ExtractMentions finds user pills (links to matrix.to URLs or matrix: URIs) in the given HTML
+// and returns a Mentions object containing the mentioned users. Links to events are ignored.
+func ExtractMentions(htmlData string) *event.Mentions {
+	mentions := &event.Mentions{}
+	node, err := html.Parse(strings.NewReader(htmlData))
+	if err != nil {
+		return mentions
+	}
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode && node.Data == "a" {
+			for _, attr := range node.Attr {
+				if attr.Key != "href" {
+					continue
+				}
+				uri, err := id.ParseMatrixURIOrMatrixToURL(attr.Val)
+				if err == nil && uri.Sigil1 == '@' && uri.Sigil2 == 0 && !slices.Contains(mentions.UserIDs, uri.UserID()) {
+					mentions.UserIDs = append(mentions.UserIDs, uri.UserID())
+				}
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(node)
+	return mentions
+}
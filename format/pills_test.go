@@ -0,0 +1,60 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package format_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestUserPill(t *testing.T) {
+	assert.Equal(t,
+		`<a href="https://matrix.to/#/@alice:example.com">Alice &lt;3</a>`,
+		format.UserPill("@alice:example.com", "Alice <3", format.PillMatrixTo),
+	)
+	assert.Equal(t,
+		`<a href="matrix:u/alice:example.com">@alice:example.com</a>`,
+		format.UserPill("@alice:example.com", "", format.PillMatrixURI),
+	)
+	assert.Equal(t,
+		`<a href="matrix:roomid/foo:example.com?via=example.com">Room</a>`,
+		format.RoomPill("!foo:example.com", "Room", format.PillMatrixURI, "example.com"),
+	)
+	assert.Equal(t,
+		`<a href="https://matrix.to/#/%23foo:example.com">#foo:example.com</a>`,
+		format.RoomAliasPill("#foo:example.com", format.PillMatrixTo),
+	)
+}
+
+func TestDisambiguateDisplaynames(t *testing.T) {
+	names := format.DisambiguateDisplaynames(map[id.UserID]string{
+		"@alice:a.com": "Alice",
+		"@alice:b.com": "Alice ",
+		"@bob:a.com":   "Bob",
+		"@empty:a.com": "",
+	})
+	assert.Equal(t, map[id.UserID]string{
+		"@alice:a.com": "Alice (@alice:a.com)",
+		"@alice:b.com": "Alice (@alice:b.com)",
+		"@bob:a.com":   "Bob",
+		"@empty:a.com": "@empty:a.com",
+	}, names)
+}
+
+func TestExtractMentions(t *testing.T) {
+	html := format.UserPill("@alice:example.com", "Alice", format.PillMatrixTo) + " and " +
+		format.UserPill("@bob:example.com", "Bob", format.PillMatrixURI) + " " +
+		format.UserPill("@alice:example.com", "Alice again", format.PillMatrixURI) +
+		` <a href="https://matrix.to/#/!room:example.com/$event">event</a> <a href="https://example.com">link</a>`
+	mentions := format.ExtractMentions(html)
+	assert.Equal(t, []id.UserID{"@alice:example.com", "@bob:example.com"}, mentions.UserIDs)
+	assert.False(t, mentions.Room)
+}
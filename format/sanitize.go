@@ -0,0 +1,244 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package format
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// HTMLSanitizer re-emits Matrix HTML with only the tags and attributes that are allowed in the spec.
+//
+// https://spec.matrix.org/v1.12/client-server-api/#mroommessage-msgtypes
+type HTMLSanitizer struct {
+	// AllowedTags maps allowed tag names to the list of attributes allowed on that tag.
+	AllowedTags map[string][]string
+	// AllowedLinkSchemes contains the URL schemes that are allowed in <a href>.
+	AllowedLinkSchemes []string
+	// DroppedTags contains tags that are removed along with all their content.
+	// Other disallowed tags are unwrapped, i.e. their children are kept.
+	DroppedTags []string
+	// MaxDepth is the maximum nesting depth of tags. Deeper tags are unwrapped.
+	MaxDepth int
+	// KeepReplyFallback can be set to keep <mx-reply> blocks instead of dropping them.
+	KeepReplyFallback bool
+}
+
+// DefaultSanitizer is an HTMLSanitizer using the tags and attributes recommended by the spec.
+var DefaultSanitizer = &HTMLSanitizer{
+	AllowedTags: map[string][]string{
+		"font":       {"data-mx-bg-color", "data-mx-color", "color"},
+		"del":        nil,
+		"s":          nil,
+		"strike":     nil,
+		"h1":         nil,
+		"h2":         nil,
+		"h3":         nil,
+		"h4":         nil,
+		"h5":         nil,
+		"h6":         nil,
+		"blockquote": nil,
+		"p":          nil,
+		"a":          {"name", "target", "href"},
+		"ul":         nil,
+		"ol":         {"start"},
+		"sup":        nil,
+		"sub":        nil,
+		"li":         nil,
+		"b":          nil,
+		"i":          nil,
+		"u":          nil,
+		"strong":     nil,
+		"em":         nil,
+		"code":       {"class"},
+		"hr":         nil,
+		"br":         nil,
+		"div":        {"data-mx-maths"},
+		"table":      nil,
+		"thead":      nil,
+		"tbody":      nil,
+		"tr":         nil,
+		"th":         nil,
+		"td":         nil,
+		"caption":    nil,
+		"pre":        nil,
+		"span":       {"data-mx-bg-color", "data-mx-color", "data-mx-spoiler", "data-mx-maths"},
+		"img":        {"width", "height", "alt", "title", "src", "data-mx-emoticon"},
+		"details":    nil,
+		"summary":    nil,
+	},
+	AllowedLinkSchemes: []string{"https", "http", "ftp", "mailto", "magnet", "matrix"},
+	DroppedTags:        []string{"script", "style", "head", "title", "iframe", "object", "embed", "template", "noscript", "mx-reply"},
+	MaxDepth:           100,
+}
+
+var voidTags = map[string]struct{}{
+	"br":  {},
+	"hr":  {},
+	"img": {},
+}
+
+var colorRegex = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+func (san *HTMLSanitizer) isDropped(tag string) bool {
+	if tag == "mx-reply" && san.KeepReplyFallback {
+		return false
+	}
+	for _, dropped := range san.DroppedTags {
+		if dropped == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (san *HTMLSanitizer) isAllowedLink(href string) bool {
+	scheme, _, hasScheme := strings.Cut(href, ":")
+	if !hasScheme {
+		return false
+	}
+	scheme = strings.ToLower(scheme)
+	for _, allowed := range san.AllowedLinkSchemes {
+		if scheme == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (san *HTMLSanitizer) isAllowedAttribute(tag, key, val string) bool {
+	allowed := false
+	for _, attr := range san.AllowedTags[tag] {
+		if attr == key {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return false
+	}
+	switch key {
+	case "href":
+		return san.isAllowedLink(val)
+	case "src":
+		return strings.HasPrefix(val, "mxc://")
+	case "color", "data-mx-color", "data-mx-bg-color":
+		return colorRegex.MatchString(val)
+	case "class":
+		return tag == "code" && strings.HasPrefix(val, "language-") && !strings.ContainsAny(val, " \t\n")
+	case "target":
+		return val == "_blank"
+	}
+	return true
+}
+
+func (san *HTMLSanitizer) writeTag(out *strings.Builder, node *html.Node) {
+	out.WriteByte('<')
+	out.WriteString(node.Data)
+	for _, attr := range node.Attr {
+		if attr.Namespace != "" || !san.isAllowedAttribute(node.Data, attr.Key, attr.Val) {
+			continue
+		}
+		out.WriteByte(' ')
+		out.WriteString(attr.Key)
+		out.WriteString(`="`)
+		out.WriteString(html.EscapeString(attr.Val))
+		out.WriteByte('"')
+	}
+	out.WriteByte('>')
+}
+
+type sanitizeOutput struct {
+	strings.Builder
+	// The maximum number of bytes of text to write, or zero for unlimited.
+	limit     int
+	truncated bool
+}
+
+func (out *sanitizeOutput) full() bool {
+	return out.truncated || (out.limit > 0 && out.Len() >= out.limit)
+}
+
+func (out *sanitizeOutput) writeText(text string) {
+	if out.limit > 0 && out.Len()+len(text) > out.limit {
+		text = truncateUTF8(text, out.limit-out.Len())
+		out.truncated = true
+	}
+	out.WriteString(html.EscapeString(text))
+}
+
+func (san *HTMLSanitizer) sanitizeNode(out *sanitizeOutput, node *html.Node, depth int) {
+	for ; node != nil && !out.full(); node = node.NextSibling {
+		switch node.Type {
+		case html.TextNode:
+			out.writeText(node.Data)
+		case html.DocumentNode:
+			san.sanitizeNode(out, node.FirstChild, depth)
+		case html.ElementNode:
+			if san.isDropped(node.Data) {
+				continue
+			}
+			_, allowed := san.AllowedTags[node.Data]
+			if !allowed || (san.MaxDepth > 0 && depth >= san.MaxDepth) {
+				san.sanitizeNode(out, node.FirstChild, depth)
+				continue
+			}
+			san.writeTag(&out.Builder, node)
+			if _, isVoid := voidTags[node.Data]; isVoid {
+				continue
+			}
+			san.sanitizeNode(out, node.FirstChild, depth+1)
+			// Closing tags are always written, so truncated output is still well-formed
+			out.WriteString("</")
+			out.WriteString(node.Data)
+			out.WriteByte('>')
+		}
+	}
+	if node != nil {
+		out.truncated = true
+	}
+}
+
+// Sanitize parses the given HTML and re-emits it with all disallowed tags, attributes and URLs removed.
+func (san *HTMLSanitizer) Sanitize(htmlData string) string {
+	out, _ := san.sanitize(htmlData, 0)
+	return out
+}
+
+// sanitize sanitizes the given HTML, stopping once about maxLength bytes have been written.
+// The output may be slightly longer than maxLength, as open tags are closed and text is escaped after truncation.
+func (san *HTMLSanitizer) sanitize(htmlData string, maxLength int) (string, bool) {
+	nodes, err := html.ParseFragment(strings.NewReader(htmlData), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		if maxLength > 0 && len(htmlData) > maxLength {
+			return html.EscapeString(truncateUTF8(htmlData, maxLength)), true
+		}
+		return html.EscapeString(htmlData), false
+	}
+	out := &sanitizeOutput{limit: maxLength}
+	out.Grow(len(htmlData))
+	for _, node := range nodes {
+		// ParseFragment returns siblings individually, so sanitize them one at a time.
+		next := node.NextSibling
+		node.NextSibling = nil
+		san.sanitizeNode(out, node, 0)
+		node.NextSibling = next
+	}
+	return out.String(), out.truncated
+}
+
+// SanitizeHTML sanitizes Matrix HTML using the default sanitizer settings.
+func SanitizeHTML(htmlData string) string {
+	return DefaultSanitizer.Sanitize(htmlData)
+}
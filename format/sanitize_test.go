@@ -0,0 +1,54 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package format_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"maunium.net/go/mautrix/format"
+)
+
+var sanitizeTests = map[string]string{
+	"<b>hello</b> world":                                       "<b>hello</b> world",
+	"<script>alert(1)</script>hi":                              "hi",
+	`<a href="javascript:alert(1)">x</a>`:                      `<a>x</a>`,
+	`<a href="https://example.com" onclick="x()">x</a>`:        `<a href="https://example.com">x</a>`,
+	`<img src="https://example.com/a.png" alt="a">`:            `<img alt="a">`,
+	`<img src="mxc://example.com/a" alt="a">`:                  `<img src="mxc://example.com/a" alt="a">`,
+	`<font color="red" data-mx-color="#ff0000">x</font>`:       `<font data-mx-color="#ff0000">x</font>`,
+	`<marquee><i>x</i></marquee>`:                              `<i>x</i>`,
+	`<pre><code class="language-go">a &lt; b</code></pre>`:     `<pre><code class="language-go">a &lt; b</code></pre>`,
+	`<mx-reply><blockquote>quote</blockquote></mx-reply>reply`: `reply`,
+	`<span data-mx-spoiler="reason">secret</span>`:             `<span data-mx-spoiler="reason">secret</span>`,
+	`foo<br/>bar<hr>`:                                          `foo<br>bar<hr>`,
+}
+
+func TestSanitizeHTML(t *testing.T) {
+	for input, expected := range sanitizeTests {
+		assert.Equal(t, expected, format.SanitizeHTML(input), "with input %q", input)
+	}
+}
+
+func TestHTMLParser_Sanitizer(t *testing.T) {
+	parser := &format.HTMLParser{Sanitizer: format.DefaultSanitizer}
+	for input, expected := range sanitizeTests {
+		assert.Equal(t, expected, parser.Parse(input, format.NewContext(context.TODO())), "with input %q", input)
+	}
+}
+
+func TestHTMLParser_Sanitizer_MaxOutputLength(t *testing.T) {
+	parser := &format.HTMLParser{Sanitizer: format.DefaultSanitizer, MaxOutputLength: 14}
+	var out strings.Builder
+	err := parser.ParseTo(&out, "<b>hello <i>world</i></b><p>more</p>", format.NewContext(context.TODO()))
+	assert.ErrorIs(t, err, format.ErrOutputTooLong)
+	// Open tags are closed after truncating
+	assert.Equal(t, "<b>hello <i>wo</i></b>", out.String())
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package format
+
+import (
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// trimmingWriter is an io.Writer wrapper that strips leading and trailing whitespace from the stream
+// (like strings.TrimSpace) and optionally limits the number of bytes written.
+type trimmingWriter struct {
+	w io.Writer
+	// If parent is set, the trimmed output is written to the parent instead of w, and the limit of the parent applies.
+	parent *trimmingWriter
+	limit  int
+
+	written      int
+	started      bool
+	pendingSpace strings.Builder
+	truncated    bool
+	err          error
+}
+
+// child returns a writer that trims its own output separately and then writes it into this writer.
+func (tw *trimmingWriter) child() *trimmingWriter {
+	return &trimmingWriter{parent: tw}
+}
+
+func (tw *trimmingWriter) root() *trimmingWriter {
+	for tw.parent != nil {
+		tw = tw.parent
+	}
+	return tw
+}
+
+// remaining returns the number of bytes that can still be written, or -1 if the output isn't limited.
+func (tw *trimmingWriter) remaining() int {
+	if tw == nil {
+		return -1
+	}
+	root := tw.root()
+	if root.done() {
+		return 0
+	} else if root.limit <= 0 {
+		return -1
+	}
+	return root.limit - root.written
+}
+
+// markTruncated marks the output as truncated without preventing the current write.
+// It's used when nested output was already cut off before being written into this writer.
+func (tw *trimmingWriter) markTruncated() {
+	if tw != nil {
+		tw.root().truncated = true
+	}
+}
+
+// done returns true if nothing more should be written, either because the limit was reached or writing failed.
+func (tw *trimmingWriter) done() bool {
+	root := tw.root()
+	return root.err != nil || root.truncated
+}
+
+// Err returns the error that stopped writing, or ErrOutputTooLong if the output was truncated.
+func (tw *trimmingWriter) Err() error {
+	if tw.err == nil && tw.truncated {
+		return ErrOutputTooLong
+	}
+	return tw.err
+}
+
+func (tw *trimmingWriter) write(data string) {
+	if tw.err != nil || len(data) == 0 {
+		return
+	}
+	if tw.parent != nil {
+		tw.parent.WriteString(data)
+		return
+	}
+	if tw.limit > 0 && tw.written+len(data) > tw.limit {
+		data = truncateUTF8(data, tw.limit-tw.written)
+		tw.err = ErrOutputTooLong
+	}
+	n, err := io.WriteString(tw.w, data)
+	tw.written += n
+	if err != nil {
+		tw.err = err
+	}
+}
+
+func (tw *trimmingWriter) WriteString(data string) {
+	if !tw.started {
+		data = strings.TrimLeftFunc(data, unicode.IsSpace)
+		if len(data) == 0 {
+			return
+		}
+		tw.started = true
+	}
+	trimmed := strings.TrimRightFunc(data, unicode.IsSpace)
+	if len(trimmed) == 0 {
+		tw.pendingSpace.WriteString(data)
+		return
+	}
+	if tw.pendingSpace.Len() > 0 {
+		tw.write(tw.pendingSpace.String())
+		tw.pendingSpace.Reset()
+	}
+	tw.write(trimmed)
+	tw.pendingSpace.WriteString(data[len(trimmed):])
+}
+
+// truncateUTF8 truncates the given string to at most maxLength bytes without splitting UTF-8 sequences.
+func truncateUTF8(str string, maxLength int) string {
+	if maxLength <= 0 {
+		return ""
+	} else if len(str) <= maxLength {
+		return str
+	}
+	for maxLength > 0 && !utf8.RuneStart(str[maxLength]) {
+		maxLength--
+	}
+	return str[:maxLength]
+}
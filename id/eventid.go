@@ -0,0 +1,34 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package id
+
+import (
+	"encoding/base64"
+	"errors"
+)
+
+var ErrEventIDMismatch = errors.New("event ID doesn't match reference hash")
+
+// EventIDV3FromReferenceHash formats a reference hash as an event ID using the format from room version 3,
+// i.e. standard unpadded base64.
+func EventIDV3FromReferenceHash(hash [32]byte) EventID {
+	return EventID("$" + base64.RawStdEncoding.EncodeToString(hash[:]))
+}
+
+// EventIDV4FromReferenceHash formats a reference hash as an event ID using the format
+// from room version 4 onwards, i.e. URL-safe unpadded base64.
+func EventIDV4FromReferenceHash(hash [32]byte) EventID {
+	return EventID("$" + base64.RawURLEncoding.EncodeToString(hash[:]))
+}
+
+// MatchesReferenceHash checks if the event ID is the given reference hash in either the room version 3
+// or the room version 4+ format.
+//
+// The reference hash itself can be calculated with federation.ReferenceHash.
+func (eventID EventID) MatchesReferenceHash(hash [32]byte) bool {
+	return eventID == EventIDV4FromReferenceHash(hash) || eventID == EventIDV3FromReferenceHash(hash)
+}
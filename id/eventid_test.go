@@ -0,0 +1,29 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package id_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// A hash whose base64 encoding contains both + and / in the standard alphabet.
+var testHash = [32]byte{0xfb, 0xff, 0xbf, 0x00, 0x01, 0x02}
+
+func TestEventIDFromReferenceHash(t *testing.T) {
+	v3 := id.EventIDV3FromReferenceHash(testHash)
+	v4 := id.EventIDV4FromReferenceHash(testHash)
+	assert.Equal(t, id.EventID("$+/+/AAECAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"), v3)
+	assert.Equal(t, id.EventID("$-_-_AAECAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"), v4)
+	assert.True(t, v3.MatchesReferenceHash(testHash))
+	assert.True(t, v4.MatchesReferenceHash(testHash))
+	assert.False(t, v4.MatchesReferenceHash([32]byte{}))
+	assert.False(t, id.EventID("$meow").MatchesReferenceHash(testHash))
+}
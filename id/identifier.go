@@ -0,0 +1,111 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package id
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrEmptyIdentifier   = errors.New("empty identifier")
+	ErrUnknownSigil      = errors.New("has an unknown sigil")
+	ErrEmptyOpaqueID     = errors.New("has an empty opaque ID")
+	ErrIdentifierTooLong = errors.New("is longer than 255 bytes")
+)
+
+// IdentifierMaxLength is the maximum length of any Matrix identifier including the sigil.
+const IdentifierMaxLength = 255
+
+// ParsedIdentifier is the result of ParseIdentifier. Exactly one of the typed identifier fields is set,
+// which one can be determined from the sigil.
+type ParsedIdentifier struct {
+	Sigil byte
+
+	UserID    UserID
+	RoomID    RoomID
+	RoomAlias RoomAlias
+	EventID   EventID
+
+	// The localpart (or opaque ID) of the identifier.
+	Localpart string
+	// The server name of the identifier. This is always set for user IDs and room aliases,
+	// but may be empty for room and event IDs, which don't contain a server name in newer room versions.
+	ServerName string
+}
+
+// String returns the identifier as a string.
+func (pi *ParsedIdentifier) String() string {
+	if pi == nil {
+		return ""
+	}
+	switch pi.Sigil {
+	case '@':
+		return string(pi.UserID)
+	case '!':
+		return string(pi.RoomID)
+	case '#':
+		return string(pi.RoomAlias)
+	case '$':
+		return string(pi.EventID)
+	default:
+		return ""
+	}
+}
+
+// ParseIdentifier detects the type of the given identifier from its sigil, validates it, and returns a typed result.
+//
+// User IDs are validated using the historical grammar (see UserID.ValidateHistorical), and server names are
+// validated using ValidateServerName. Room IDs and event IDs may omit the server name.
+func ParseIdentifier(identifier string) (*ParsedIdentifier, error) {
+	identifier = strings.TrimSpace(identifier)
+	if len(identifier) == 0 {
+		return nil, ErrEmptyIdentifier
+	} else if len(identifier) > IdentifierMaxLength {
+		return nil, fmt.Errorf("'%s' %w", identifier, ErrIdentifierTooLong)
+	}
+	sigil, localpart, serverName := ParseCommonIdentifier(identifier)
+	parsed := &ParsedIdentifier{
+		Sigil:      sigil,
+		Localpart:  localpart,
+		ServerName: serverName,
+	}
+	var err error
+	switch sigil {
+	case '@':
+		parsed.UserID = UserID(identifier)
+		err = parsed.UserID.ValidateHistorical()
+	case '#':
+		parsed.RoomAlias = RoomAlias(identifier)
+		_, _, err = parsed.RoomAlias.Parse()
+		if err == nil {
+			err = ValidateServerName(serverName)
+		}
+	case '!', '$':
+		if sigil == '!' {
+			parsed.RoomID = RoomID(identifier)
+		} else {
+			// Event IDs from room v3 onwards don't have a server name, but they may contain colons
+			// in the opaque part of the ID, so the whole thing is treated as the opaque ID.
+			parsed.EventID = EventID(identifier)
+			parsed.Localpart = identifier[1:]
+			parsed.ServerName = ""
+		}
+		if len(parsed.Localpart) == 0 {
+			err = fmt.Errorf("'%s' %w", identifier, ErrEmptyOpaqueID)
+		} else if sigil == '!' && strings.ContainsRune(identifier, ':') {
+			err = ValidateServerName(serverName)
+		}
+	default:
+		err = fmt.Errorf("'%s' %w", identifier, ErrUnknownSigil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package id_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/id"
+)
+
+func TestParseIdentifier(t *testing.T) {
+	parsed, err := id.ParseIdentifier(" @user:example.org ")
+	require.NoError(t, err)
+	assert.Equal(t, id.UserID("@user:example.org"), parsed.UserID)
+	assert.Equal(t, "user", parsed.Localpart)
+	assert.Equal(t, "example.org", parsed.ServerName)
+
+	parsed, err = id.ParseIdentifier("#room:example.org")
+	require.NoError(t, err)
+	assert.Equal(t, id.RoomAlias("#room:example.org"), parsed.RoomAlias)
+
+	parsed, err = id.ParseIdentifier("!opaque:example.org")
+	require.NoError(t, err)
+	assert.Equal(t, id.RoomID("!opaque:example.org"), parsed.RoomID)
+	assert.Equal(t, "!opaque:example.org", parsed.String())
+
+	parsed, err = id.ParseIdentifier("$uOH4C9cK4HhMeFWkUXMbdF_dtndJ0j9je-kIK3XpV1s")
+	require.NoError(t, err)
+	assert.Equal(t, id.EventID("$uOH4C9cK4HhMeFWkUXMbdF_dtndJ0j9je-kIK3XpV1s"), parsed.EventID)
+	assert.Equal(t, "", parsed.ServerName)
+}
+
+func TestParseIdentifier_Invalid(t *testing.T) {
+	_, err := id.ParseIdentifier("")
+	assert.ErrorIs(t, err, id.ErrEmptyIdentifier)
+	_, err = id.ParseIdentifier("+group:example.org")
+	assert.ErrorIs(t, err, id.ErrUnknownSigil)
+	_, err = id.ParseIdentifier("@user")
+	assert.ErrorIs(t, err, id.ErrInvalidUserID)
+	_, err = id.ParseIdentifier("#room:example_org")
+	assert.ErrorIs(t, err, id.ErrInvalidServerNameHost)
+	_, err = id.ParseIdentifier("$")
+	assert.ErrorIs(t, err, id.ErrEmptyOpaqueID)
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package id
+
+import (
+	"sync"
+)
+
+// Interner deduplicates identifier strings, so that all equal identifiers share the same backing memory.
+//
+// This is useful when the same identifiers are stored in many places, e.g. the member lists of many
+// large rooms, where most of the users are in multiple rooms.
+//
+// The interner is bounded: values are kept in two generations of at most maxSize/2 entries each.
+// When the current generation fills up, the previous one is dropped, so values that haven't been
+// interned since then are forgotten. Forgetting a value is always safe, it only means that the next
+// call will store a new copy instead of returning the one that's already in use elsewhere.
+//
+// Interners are safe for concurrent use.
+type Interner[T ~string] struct {
+	current  map[T]T
+	previous map[T]T
+	genSize  int
+	lock     sync.RWMutex
+}
+
+// DefaultInternerSize is the maximum number of values in the shared interners.
+const DefaultInternerSize = 1 << 16
+
+// NewInterner creates a new identifier interner that remembers at most maxSize values.
+func NewInterner[T ~string](maxSize int) *Interner[T] {
+	genSize := max(maxSize/2, 1)
+	return &Interner[T]{current: make(map[T]T), genSize: genSize}
+}
+
+// UserIDInterner and RoomIDInterner are shared interners that can be passed to sets and maps.
+var (
+	UserIDInterner = NewInterner[UserID](DefaultInternerSize)
+	RoomIDInterner = NewInterner[RoomID](DefaultInternerSize)
+)
+
+// Intern returns the canonical copy of the given value. If the interner is nil, the value is returned as-is.
+func (in *Interner[T]) Intern(val T) T {
+	if in == nil {
+		return val
+	}
+	in.lock.RLock()
+	existing, ok := in.current[val]
+	in.lock.RUnlock()
+	if ok {
+		return existing
+	}
+	in.lock.Lock()
+	defer in.lock.Unlock()
+	existing, ok = in.current[val]
+	if ok {
+		return existing
+	}
+	existing, ok = in.previous[val]
+	if ok {
+		// Promote recently used values so they survive the next generation swap
+		delete(in.previous, val)
+		in.store(existing)
+		return existing
+	}
+	// Clone the string so that the interned value doesn't keep a larger buffer
+	// (like a whole JSON response) alive if the input was a substring.
+	val = T(string(append([]byte(nil), val...)))
+	in.store(val)
+	return val
+}
+
+func (in *Interner[T]) store(val T) {
+	if len(in.current) >= in.genSize {
+		in.previous = in.current
+		in.current = make(map[T]T, in.genSize)
+	}
+	in.current[val] = val
+}
+
+// Len returns the number of interned values.
+func (in *Interner[T]) Len() int {
+	if in == nil {
+		return 0
+	}
+	in.lock.RLock()
+	defer in.lock.RUnlock()
+	return len(in.current) + len(in.previous)
+}
+
+// Set is a set of identifiers that optionally interns all added values.
+//
+// Sets are not safe for concurrent use.
+type Set[T ~string] struct {
+	interner *Interner[T]
+	items    map[T]struct{}
+}
+
+type UserIDSet = Set[UserID]
+type RoomIDSet = Set[RoomID]
+
+// NewSet creates a new identifier set. The interner may be nil to disable interning.
+func NewSet[T ~string](interner *Interner[T], capacity int) *Set[T] {
+	return &Set[T]{interner: interner, items: make(map[T]struct{}, capacity)}
+}
+
+// NewSetWithItems creates a new identifier set containing the given values.
+func NewSetWithItems[T ~string](interner *Interner[T], items ...T) *Set[T] {
+	set := NewSet(interner, len(items))
+	for _, item := range items {
+		set.Add(item)
+	}
+	return set
+}
+
+// Add adds the given value to the set. It returns true if the value was not already in the set.
+func (set *Set[T]) Add(val T) bool {
+	if _, exists := set.items[val]; exists {
+		return false
+	}
+	set.items[set.interner.Intern(val)] = struct{}{}
+	return true
+}
+
+// Has checks if the given value is in the set.
+func (set *Set[T]) Has(val T) bool {
+	if set == nil {
+		return false
+	}
+	_, exists := set.items[val]
+	return exists
+}
+
+// Remove removes the given value from the set. It returns true if the value was in the set.
+func (set *Set[T]) Remove(val T) bool {
+	if _, exists := set.items[val]; !exists {
+		return false
+	}
+	delete(set.items, val)
+	return true
+}
+
+// Len returns the number of values in the set.
+func (set *Set[T]) Len() int {
+	if set == nil {
+		return 0
+	}
+	return len(set.items)
+}
+
+// Each calls the given function for every value in the set until the function returns false.
+func (set *Set[T]) Each(fn func(T) bool) {
+	if set == nil {
+		return
+	}
+	for item := range set.items {
+		if !fn(item) {
+			return
+		}
+	}
+}
+
+// AsList returns all values in the set as a slice in no particular order.
+func (set *Set[T]) AsList() []T {
+	if set == nil {
+		return nil
+	}
+	list := make([]T, 0, len(set.items))
+	for item := range set.items {
+		list = append(list, item)
+	}
+	return list
+}
+
+// Map is a map with identifier keys that optionally interns all keys.
+//
+// Maps are not safe for concurrent use.
+type Map[K ~string, V any] struct {
+	interner *Interner[K]
+	items    map[K]V
+}
+
+// NewMap creates a new identifier map. The interner may be nil to disable interning.
+func NewMap[K ~string, V any](interner *Interner[K], capacity int) *Map[K, V] {
+	return &Map[K, V]{interner: interner, items: make(map[K]V, capacity)}
+}
+
+// Get returns the value for the given key.
+func (m *Map[K, V]) Get(key K) (val V, ok bool) {
+	if m == nil {
+		return
+	}
+	val, ok = m.items[key]
+	return
+}
+
+// Set sets the value for the given key.
+func (m *Map[K, V]) Set(key K, val V) {
+	if _, exists := m.items[key]; exists {
+		m.items[key] = val
+	} else {
+		m.items[m.interner.Intern(key)] = val
+	}
+}
+
+// Delete removes the given key from the map. It returns true if the key was in the map.
+func (m *Map[K, V]) Delete(key K) bool {
+	if _, exists := m.items[key]; !exists {
+		return false
+	}
+	delete(m.items, key)
+	return true
+}
+
+// Len returns the number of entries in the map.
+func (m *Map[K, V]) Len() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.items)
+}
+
+// Each calls the given function for every entry in the map until the function returns false.
+func (m *Map[K, V]) Each(fn func(K, V) bool) {
+	if m == nil {
+		return
+	}
+	for key, val := range m.items {
+		if !fn(key, val) {
+			return
+		}
+	}
+}
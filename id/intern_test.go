@@ -0,0 +1,73 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package id_test
+
+import (
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+
+	"maunium.net/go/mautrix/id"
+)
+
+func TestInterner_Intern(t *testing.T) {
+	interner := id.NewInterner[id.UserID](10)
+	first := interner.Intern(id.UserID(strings.Clone("@user:example.org")))
+	second := interner.Intern(id.UserID(strings.Clone("@user:example.org")))
+	assert.Equal(t, first, second)
+	assert.Same(t, unsafe.StringData(string(first)), unsafe.StringData(string(second)))
+	assert.Equal(t, 1, interner.Len())
+}
+
+func TestInterner_Bounded(t *testing.T) {
+	interner := id.NewInterner[id.UserID](4)
+	first := interner.Intern("@a:example.org")
+	for _, userID := range []id.UserID{"@b:example.org", "@c:example.org", "@d:example.org", "@e:example.org", "@f:example.org"} {
+		interner.Intern(userID)
+		assert.LessOrEqual(t, interner.Len(), 4)
+	}
+	// The first value has been forgotten, so a new copy is stored
+	again := interner.Intern(id.UserID(strings.Clone("@a:example.org")))
+	assert.Equal(t, first, again)
+	assert.NotSame(t, unsafe.StringData(string(first)), unsafe.StringData(string(again)))
+
+	// Values that are used again are kept when the generation is swapped
+	recent := interner.Intern("@f:example.org")
+	interner.Intern("@g:example.org")
+	interner.Intern("@h:example.org")
+	assert.Same(t, unsafe.StringData(string(recent)), unsafe.StringData(string(interner.Intern(id.UserID(strings.Clone("@f:example.org"))))))
+}
+
+func TestSet(t *testing.T) {
+	interner := id.NewInterner[id.UserID](10)
+	set1 := id.NewSetWithItems(interner, "@a:example.org", "@b:example.org")
+	set2 := id.NewSet(interner, 0)
+	assert.True(t, set2.Add("@a:example.org"))
+	assert.False(t, set2.Add("@a:example.org"))
+	assert.True(t, set1.Has("@b:example.org"))
+	assert.False(t, set2.Has("@b:example.org"))
+	assert.ElementsMatch(t, []id.UserID{"@a:example.org", "@b:example.org"}, set1.AsList())
+	assert.True(t, set1.Remove("@a:example.org"))
+	assert.False(t, set1.Remove("@a:example.org"))
+	assert.Equal(t, 1, set1.Len())
+	assert.Equal(t, 2, interner.Len())
+}
+
+func TestMap(t *testing.T) {
+	m := id.NewMap[id.RoomID, int](nil, 0)
+	m.Set("!a:example.org", 1)
+	m.Set("!a:example.org", 2)
+	val, ok := m.Get("!a:example.org")
+	assert.True(t, ok)
+	assert.Equal(t, 2, val)
+	assert.Equal(t, 1, m.Len())
+	assert.True(t, m.Delete("!a:example.org"))
+	_, ok = m.Get("!a:example.org")
+	assert.False(t, ok)
+}
@@ -34,6 +34,12 @@ var (
 
 var ErrNotMatrixToOrMatrixURI = errors.New("that URL is not a matrix.to URL nor matrix: URI")
 
+// Actions that can be specified in the action query parameter of matrix: URIs and matrix.to URLs.
+const (
+	MatrixURIActionJoin = "join"
+	MatrixURIActionChat = "chat"
+)
+
 // MatrixURI contains the result of parsing a matrix: URI using ParseMatrixURI
 type MatrixURI struct {
 	Sigil1 rune
@@ -41,7 +47,10 @@ type MatrixURI struct {
 	MXID1  string
 	MXID2  string
 	Via    []string
+	// The action the client should take when opening the link, e.g. MatrixURIActionJoin or MatrixURIActionChat.
 	Action string
+	// The client that created the link, as specified by the `client` query parameter.
+	Client string
 }
 
 // SigilToPathSegment contains a mapping from Matrix identifier sigils to matrix: URI path segments.
@@ -60,9 +69,51 @@ func (uri *MatrixURI) getQuery() url.Values {
 	if len(uri.Action) > 0 {
 		q.Set("action", uri.Action)
 	}
+	if len(uri.Client) > 0 {
+		q.Set("client", uri.Client)
+	}
 	return q
 }
 
+func (uri *MatrixURI) parseQuery(query url.Values) {
+	via, ok := query["via"]
+	if ok && len(via) > 0 {
+		uri.Via = via
+	}
+	action, ok := query["action"]
+	if ok && len(action) > 0 {
+		uri.Action = action[len(action)-1]
+	}
+	client, ok := query["client"]
+	if ok && len(client) > 0 {
+		uri.Client = client[len(client)-1]
+	}
+}
+
+// WithVia returns a copy of the URI with the given via servers.
+func (uri *MatrixURI) WithVia(via ...string) *MatrixURI {
+	if uri == nil {
+		return nil
+	}
+	cp := *uri
+	cp.Via = via
+	return &cp
+}
+
+// WithAction returns a copy of the URI with the given action.
+func (uri *MatrixURI) WithAction(action string) *MatrixURI {
+	if uri == nil {
+		return nil
+	}
+	cp := *uri
+	cp.Action = action
+	return &cp
+}
+
+func isMatrixToHost(host string) bool {
+	return host == "matrix.to" || strings.HasSuffix(host, ".matrix.to")
+}
+
 // String converts the parsed matrix: URI back into the string representation.
 func (uri *MatrixURI) String() string {
 	if uri == nil {
@@ -157,7 +208,7 @@ func ParseMatrixURIOrMatrixToURL(uri string) (*MatrixURI, error) {
 	}
 	if parsed.Scheme == "matrix" {
 		return ProcessMatrixURI(parsed)
-	} else if strings.HasSuffix(parsed.Hostname(), "matrix.to") {
+	} else if isMatrixToHost(parsed.Hostname()) {
 		return ProcessMatrixToURL(parsed)
 	} else {
 		return nil, ErrNotMatrixToOrMatrixURI
@@ -210,34 +261,34 @@ func ProcessMatrixURI(uri *url.URL) (*MatrixURI, error) {
 	if len(parts[1]) == 0 {
 		return nil, ErrEmptySecondSegment
 	}
-	parsed.MXID1 = parts[1]
+	var err error
+	parsed.MXID1, err = url.PathUnescape(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to unescape second segment: %w", err)
+	}
 
 	// Step 6: if the first part is a room and the URI has 4 segments, construct a second level identifier
-	if parsed.Sigil1 == '!' && len(parts) == 4 {
+	if (parsed.Sigil1 == '!' || parsed.Sigil1 == '#') && len(parts) == 4 {
 		// a: find the sigil from the third segment
 		switch parts[2] {
 		case "e", "event":
 			parsed.Sigil2 = '$'
 		default:
-			return nil, fmt.Errorf("%w: '%s'", ErrInvalidThirdSegment, parts[0])
+			return nil, fmt.Errorf("%w: '%s'", ErrInvalidThirdSegment, parts[2])
 		}
 
 		// b: find the identifier from the fourth segment
 		if len(parts[3]) == 0 {
 			return nil, ErrEmptyFourthSegment
 		}
-		parsed.MXID2 = parts[3]
+		parsed.MXID2, err = url.PathUnescape(parts[3])
+		if err != nil {
+			return nil, fmt.Errorf("failed to unescape fourth segment: %w", err)
+		}
 	}
 
 	// Step 7: parse the query and extract via and action items
-	via, ok := uri.Query()["via"]
-	if ok && len(via) > 0 {
-		parsed.Via = via
-	}
-	action, ok := uri.Query()["action"]
-	if ok && len(action) > 0 {
-		parsed.Action = action[len(action)-1]
-	}
+	parsed.parseQuery(uri.Query())
 
 	return &parsed, nil
 }
@@ -253,19 +304,28 @@ func ParseMatrixToURL(uri string) (*MatrixURI, error) {
 
 // ProcessMatrixToURL is the equivalent of ProcessMatrixURI for matrix.to URLs.
 func ProcessMatrixToURL(uri *url.URL) (*MatrixURI, error) {
-	if !strings.HasSuffix(uri.Hostname(), "matrix.to") {
+	if !isMatrixToHost(uri.Hostname()) {
 		return nil, ErrNotMatrixTo
 	}
 
-	initialSplit := strings.SplitN(uri.Fragment, "?", 2)
+	// Split the escaped fragment, so that escaped slashes inside identifiers don't create new segments
+	initialSplit := strings.SplitN(uri.EscapedFragment(), "?", 2)
 	parts := strings.Split(initialSplit[0], "/")
+	query := uri.Query()
 	if len(initialSplit) > 1 {
-		uri.RawQuery = initialSplit[1]
+		query, _ = url.ParseQuery(initialSplit[1])
 	}
 
 	if len(parts) < 2 || len(parts) > 3 {
 		return nil, ErrInvalidMatrixToPartCount
 	}
+	for i, part := range parts {
+		var err error
+		parts[i], err = url.PathUnescape(part)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unescape matrix.to URL: %w", err)
+		}
+	}
 
 	if len(parts[1]) == 0 {
 		return nil, ErrEmptyMatrixToPrimaryIdentifier
@@ -289,14 +349,7 @@ func ProcessMatrixToURL(uri *url.URL) (*MatrixURI, error) {
 		}
 	}
 
-	via, ok := uri.Query()["via"]
-	if ok && len(via) > 0 {
-		parsed.Via = via
-	}
-	action, ok := uri.Query()["action"]
-	if ok && len(action) > 0 {
-		parsed.Action = action[len(action)-1]
-	}
+	parsed.parseQuery(query)
 
 	return &parsed, nil
 }
@@ -157,3 +157,46 @@ func TestParseMatrixToURL_EventID(t *testing.T) {
 	assert.Equal(t, roomIDEventLink, *parsed)
 	assert.Equal(t, roomIDEventLink, *parsedEncoded)
 }
+
+func TestParseMatrixURI_Escaped(t *testing.T) {
+	parsed, err := id.ParseMatrixURI(escapeRoomIDEventLink.String())
+	require.NoError(t, err)
+	require.NotNil(t, parsed)
+
+	assert.Equal(t, escapeRoomIDEventLink, *parsed)
+}
+
+func TestParseMatrixToURL_Escaped(t *testing.T) {
+	parsed, err := id.ParseMatrixToURL(escapeRoomIDEventLink.MatrixToURL())
+	require.NoError(t, err)
+	require.NotNil(t, parsed)
+
+	assert.Equal(t, escapeRoomIDEventLink, *parsed)
+}
+
+func TestParseMatrixURI_RoomAliasEventID(t *testing.T) {
+	parsed, err := id.ParseMatrixURI("matrix:r/someroom:example.org/e/uOH4C9cK4HhMeFWkUXMbdF_dtndJ0j9je-kIK3XpV1s")
+	require.NoError(t, err)
+	require.NotNil(t, parsed)
+
+	assert.Equal(t, id.RoomAlias("#someroom:example.org"), parsed.RoomAlias())
+	assert.Equal(t, id.EventID("$uOH4C9cK4HhMeFWkUXMbdF_dtndJ0j9je-kIK3XpV1s"), parsed.EventID())
+}
+
+func TestParseMatrixURIOrMatrixToURL_ActionAndClient(t *testing.T) {
+	expected := userLink.WithAction(id.MatrixURIActionChat)
+	expected.Client = "im.example.client"
+	parsed1, err := id.ParseMatrixURIOrMatrixToURL("matrix:u/user:example.org?action=chat&client=im.example.client")
+	require.NoError(t, err)
+	parsed2, err := id.ParseMatrixURIOrMatrixToURL("https://matrix.to/#/@user:example.org?action=chat&client=im.example.client")
+	require.NoError(t, err)
+
+	assert.Equal(t, expected, parsed1)
+	assert.Equal(t, expected, parsed2)
+	assert.Equal(t, "matrix:u/user:example.org?action=chat&client=im.example.client", expected.String())
+}
+
+func TestParseMatrixURIOrMatrixToURL_NotMatrixTo(t *testing.T) {
+	_, err := id.ParseMatrixURIOrMatrixToURL("https://evilmatrix.to/#/@user:example.org")
+	assert.ErrorIs(t, err, id.ErrNotMatrixToOrMatrixURI)
+}
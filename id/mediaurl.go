@@ -0,0 +1,189 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package id
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Path prefixes of the different media repository APIs.
+const (
+	LegacyMediaPathPrefix     = "/_matrix/media/v3"
+	ClientMediaPathPrefix     = "/_matrix/client/v1/media"
+	FederationMediaPathPrefix = "/_matrix/federation/v1/media"
+)
+
+var (
+	ErrInvalidMediaID    = errors.New("media ID contains characters that are not allowed")
+	ErrNotMediaPath      = errors.New("path is not a media download or thumbnail endpoint")
+	ErrInvalidMediaPath  = errors.New("media endpoint path has an invalid number of segments")
+	ErrMissingServerName = errors.New("server name is required for parsing federation media paths")
+)
+
+// Validate checks that the content URI has a valid server name and media ID.
+//
+// Unlike IsValid, the server name is validated using the full server name grammar (see ValidateServerName).
+func (uri ContentURI) Validate() error {
+	if uri.IsEmpty() {
+		return InvalidContentURI
+	} else if err := ValidateServerName(uri.Homeserver); err != nil {
+		return fmt.Errorf("%w: %w", InvalidContentURI, err)
+	} else if !IsValidMediaID(uri.FileID) {
+		return fmt.Errorf("%w: '%s' %w", InvalidContentURI, uri.FileID, ErrInvalidMediaID)
+	}
+	return nil
+}
+
+// ParseAndValidateContentURI parses a Matrix content URI and validates it using ContentURI.Validate.
+// Unlike ParseContentURI, empty strings are not allowed.
+func ParseAndValidateContentURI(uri string) (ContentURI, error) {
+	parsed, err := ParseContentURI(uri)
+	if err != nil {
+		return parsed, err
+	}
+	return parsed, parsed.Validate()
+}
+
+// ThumbnailParams contains the parameters for a thumbnail request.
+type ThumbnailParams struct {
+	Width    int
+	Height   int
+	Method   string // "crop" or "scale"
+	Animated bool
+}
+
+func (tp ThumbnailParams) query() url.Values {
+	query := url.Values{}
+	query.Set("width", strconv.Itoa(tp.Width))
+	query.Set("height", strconv.Itoa(tp.Height))
+	if tp.Method != "" {
+		query.Set("method", tp.Method)
+	}
+	if tp.Animated {
+		query.Set("animated", "true")
+	}
+	return query
+}
+
+func mediaPathPrefix(authenticated bool) string {
+	if authenticated {
+		return ClientMediaPathPrefix
+	}
+	return LegacyMediaPathPrefix
+}
+
+// DownloadPath returns the path of the download endpoint for this content URI.
+//
+// If authenticated is true, the authenticated client media endpoint is used,
+// otherwise the legacy unauthenticated media endpoint is used.
+// The file name is optional and will be appended to the path if set.
+func (uri ContentURI) DownloadPath(authenticated bool, fileName string) string {
+	path := fmt.Sprintf("%s/download/%s/%s", mediaPathPrefix(authenticated), url.PathEscape(uri.Homeserver), url.PathEscape(uri.FileID))
+	if fileName != "" {
+		path += "/" + url.PathEscape(fileName)
+	}
+	return path
+}
+
+// ThumbnailPath returns the path and query string of the thumbnail endpoint for this content URI.
+func (uri ContentURI) ThumbnailPath(authenticated bool, params ThumbnailParams) string {
+	return fmt.Sprintf(
+		"%s/thumbnail/%s/%s?%s",
+		mediaPathPrefix(authenticated), url.PathEscape(uri.Homeserver), url.PathEscape(uri.FileID), params.query().Encode(),
+	)
+}
+
+// FederationDownloadPath returns the path of the federation download endpoint for this content URI.
+// The request must be sent to the server in the content URI.
+func (uri ContentURI) FederationDownloadPath() string {
+	return fmt.Sprintf("%s/download/%s", FederationMediaPathPrefix, url.PathEscape(uri.FileID))
+}
+
+// FederationThumbnailPath returns the path and query string of the federation thumbnail endpoint for this content URI.
+// The request must be sent to the server in the content URI.
+func (uri ContentURI) FederationThumbnailPath(params ThumbnailParams) string {
+	return fmt.Sprintf("%s/thumbnail/%s?%s", FederationMediaPathPrefix, url.PathEscape(uri.FileID), params.query().Encode())
+}
+
+// DownloadURL returns the full download URL for this content URI on the given homeserver.
+func (uri ContentURI) DownloadURL(homeserverURL string, authenticated bool, fileName string) string {
+	return strings.TrimSuffix(homeserverURL, "/") + uri.DownloadPath(authenticated, fileName)
+}
+
+// ThumbnailURL returns the full thumbnail URL for this content URI on the given homeserver.
+func (uri ContentURI) ThumbnailURL(homeserverURL string, authenticated bool, params ThumbnailParams) string {
+	return strings.TrimSuffix(homeserverURL, "/") + uri.ThumbnailPath(authenticated, params)
+}
+
+// MediaPath contains the result of parsing a media download or thumbnail endpoint path using ParseMediaPath.
+type MediaPath struct {
+	URI           ContentURI
+	Thumbnail     bool
+	Authenticated bool
+	Federation    bool
+	// The file name at the end of the path. Only download endpoints can have a file name.
+	FileName string
+}
+
+// ParseMediaPath parses the path of a download or thumbnail request to any of the
+// legacy, authenticated client or federation media endpoints.
+//
+// Federation media paths don't contain the server name, so the name of the server
+// receiving the request must be provided to parse them.
+func ParseMediaPath(path, serverName string) (*MediaPath, error) {
+	var parsed MediaPath
+	var rest string
+	var found bool
+	if rest, found = strings.CutPrefix(path, ClientMediaPathPrefix+"/"); found {
+		parsed.Authenticated = true
+	} else if rest, found = strings.CutPrefix(path, FederationMediaPathPrefix+"/"); found {
+		if serverName == "" {
+			return nil, ErrMissingServerName
+		}
+		parsed.Authenticated = true
+		parsed.Federation = true
+	} else if rest, found = strings.CutPrefix(path, LegacyMediaPathPrefix+"/"); !found {
+		return nil, ErrNotMediaPath
+	}
+	parts := strings.Split(rest, "/")
+	switch parts[0] {
+	case "download":
+	case "thumbnail":
+		parsed.Thumbnail = true
+	default:
+		return nil, ErrNotMediaPath
+	}
+	parts = parts[1:]
+	if parsed.Federation {
+		if len(parts) != 1 {
+			return nil, ErrInvalidMediaPath
+		}
+		parts = []string{serverName, parts[0]}
+	}
+	if len(parts) < 2 || len(parts) > 3 || (len(parts) == 3 && parsed.Thumbnail) {
+		return nil, ErrInvalidMediaPath
+	}
+	for i, part := range parts {
+		var err error
+		parts[i], err = url.PathUnescape(part)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unescape media path: %w", err)
+		}
+	}
+	parsed.URI = ContentURI{Homeserver: parts[0], FileID: parts[1]}
+	if len(parts) == 3 {
+		parsed.FileName = parts[2]
+	}
+	if err := parsed.URI.Validate(); err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
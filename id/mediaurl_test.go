@@ -0,0 +1,57 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package id_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/id"
+)
+
+var testMXC = id.ContentURI{Homeserver: "example.org", FileID: "abcDEF123_-"}
+
+func TestContentURI_Validate(t *testing.T) {
+	assert.NoError(t, testMXC.Validate())
+	assert.ErrorIs(t, id.ContentURI{}.Validate(), id.InvalidContentURI)
+	assert.ErrorIs(t, id.ContentURI{Homeserver: "example_org", FileID: "abc"}.Validate(), id.ErrInvalidServerNameHost)
+	assert.ErrorIs(t, id.ContentURI{Homeserver: "example.org", FileID: "a/b"}.Validate(), id.ErrInvalidMediaID)
+	_, err := id.ParseAndValidateContentURI("mxc://example.org/a.b")
+	assert.ErrorIs(t, err, id.InvalidContentURI)
+}
+
+func TestContentURI_URLs(t *testing.T) {
+	thumbParams := id.ThumbnailParams{Width: 64, Height: 32, Method: "crop"}
+	assert.Equal(t, "https://matrix.example.com/_matrix/media/v3/download/example.org/abcDEF123_-", testMXC.DownloadURL("https://matrix.example.com/", false, ""))
+	assert.Equal(t, "https://matrix.example.com/_matrix/client/v1/media/download/example.org/abcDEF123_-/file%20name.png", testMXC.DownloadURL("https://matrix.example.com", true, "file name.png"))
+	assert.Equal(t, "https://matrix.example.com/_matrix/client/v1/media/thumbnail/example.org/abcDEF123_-?height=32&method=crop&width=64", testMXC.ThumbnailURL("https://matrix.example.com", true, thumbParams))
+	assert.Equal(t, "/_matrix/federation/v1/media/download/abcDEF123_-", testMXC.FederationDownloadPath())
+	assert.Equal(t, "/_matrix/federation/v1/media/thumbnail/abcDEF123_-?height=32&method=crop&width=64", testMXC.FederationThumbnailPath(thumbParams))
+}
+
+func TestParseMediaPath(t *testing.T) {
+	parsed, err := id.ParseMediaPath(testMXC.DownloadPath(true, "file name.png"), "")
+	require.NoError(t, err)
+	assert.Equal(t, &id.MediaPath{URI: testMXC, Authenticated: true, FileName: "file name.png"}, parsed)
+
+	parsed, err = id.ParseMediaPath("/_matrix/media/v3/thumbnail/example.org/abcDEF123_-", "")
+	require.NoError(t, err)
+	assert.Equal(t, &id.MediaPath{URI: testMXC, Thumbnail: true}, parsed)
+
+	parsed, err = id.ParseMediaPath(testMXC.FederationDownloadPath(), "example.org")
+	require.NoError(t, err)
+	assert.Equal(t, &id.MediaPath{URI: testMXC, Authenticated: true, Federation: true}, parsed)
+
+	_, err = id.ParseMediaPath(testMXC.FederationDownloadPath(), "")
+	assert.ErrorIs(t, err, id.ErrMissingServerName)
+	_, err = id.ParseMediaPath("/_matrix/client/v1/media/config", "")
+	assert.ErrorIs(t, err, id.ErrNotMediaPath)
+	_, err = id.ParseMediaPath("/_matrix/client/v1/media/thumbnail/example.org/abc/file.png", "")
+	assert.ErrorIs(t, err, id.ErrInvalidMediaPath)
+}
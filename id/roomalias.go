@@ -0,0 +1,46 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package id
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidRoomAlias = errors.New("is not a valid room alias")
+
+// Parse parses the room alias into the localpart and server name.
+func (roomAlias RoomAlias) Parse() (localpart, server string, err error) {
+	var sigil byte
+	sigil, localpart, server = ParseCommonIdentifier(roomAlias)
+	if sigil != '#' || server == "" {
+		err = fmt.Errorf("'%s' %w", roomAlias, ErrInvalidRoomAlias)
+	}
+	return
+}
+
+// Normalize returns the room alias in a canonical form that can be used as a cache key.
+//
+// Server names are case-insensitive, so they're lowercased. Localparts are compared byte-for-byte
+// by homeservers, so they're left as-is: even aliases that only differ in Unicode normalization
+// are distinct. Surrounding whitespace is removed from both parts.
+//
+// If the alias can't be parsed, it's returned with only the whitespace trimmed.
+func (roomAlias RoomAlias) Normalize() RoomAlias {
+	trimmed := RoomAlias(strings.TrimSpace(string(roomAlias)))
+	localpart, server, err := trimmed.Parse()
+	if err != nil {
+		return trimmed
+	}
+	return NewRoomAlias(strings.TrimSpace(localpart), strings.ToLower(strings.TrimSpace(server)))
+}
+
+// Equals checks if the two room aliases are equal after normalization.
+func (roomAlias RoomAlias) Equals(other RoomAlias) bool {
+	return roomAlias == other || roomAlias.Normalize() == other.Normalize()
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package id_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"maunium.net/go/mautrix/id"
+)
+
+func TestRoomAlias_Parse(t *testing.T) {
+	localpart, server, err := id.RoomAlias("#room:example.org").Parse()
+	assert.NoError(t, err)
+	assert.Equal(t, "room", localpart)
+	assert.Equal(t, "example.org", server)
+	_, _, err = id.RoomAlias("@user:example.org").Parse()
+	assert.ErrorIs(t, err, id.ErrInvalidRoomAlias)
+}
+
+func TestRoomAlias_Normalize(t *testing.T) {
+	assert.Equal(t, id.RoomAlias("#Room:example.org"), id.RoomAlias(" #Room:Example.ORG ").Normalize())
+	// Localparts aren't Unicode-normalized, homeservers treat differently encoded characters as different aliases
+	assert.Equal(t, id.RoomAlias("#cafe\u0301:example.org"), id.RoomAlias("#cafe\u0301:example.org").Normalize())
+	assert.Equal(t, id.RoomAlias("not an alias"), id.RoomAlias(" not an alias ").Normalize())
+}
+
+func TestRoomAlias_Equals(t *testing.T) {
+	assert.True(t, id.RoomAlias("#room:example.org").Equals("#room:EXAMPLE.org"))
+	assert.False(t, id.RoomAlias("#room:example.org").Equals("#Room:example.org"))
+	assert.False(t, id.RoomAlias("#caf\u00e9:example.org").Equals("#cafe\u0301:example.org"))
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package id
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const ServerNameMaxLength = 255
+
+var (
+	ErrEmptyServerName       = errors.New("empty server names are not allowed")
+	ErrServerNameTooLong     = errors.New("the given server name is longer than 255 characters")
+	ErrInvalidServerNamePort = errors.New("has an invalid port")
+	ErrInvalidIPv6Literal    = errors.New("is not a valid IPv6 literal")
+	ErrInvalidServerNameHost = errors.New("is not a valid DNS name or IPv4 address")
+)
+
+// IdentifierError contains details about why an identifier doesn't match the grammar in the spec.
+type IdentifierError struct {
+	// The identifier or part of an identifier that failed validation.
+	Input string
+	// The byte index of the first invalid character in Input, or -1 if the error isn't caused by a specific character.
+	Index int
+	// The reason for the error, e.g. ErrNoncompliantLocalpart or ErrInvalidServerNameHost.
+	Err error
+}
+
+func (ie *IdentifierError) Error() string {
+	if ie.Index >= 0 && ie.Index < len(ie.Input) {
+		return fmt.Sprintf("'%s' %v (invalid character %q at index %d)", ie.Input, ie.Err, ie.Input[ie.Index], ie.Index)
+	}
+	return fmt.Sprintf("'%s' %v", ie.Input, ie.Err)
+}
+
+func (ie *IdentifierError) Unwrap() error {
+	return ie.Err
+}
+
+func newIdentifierError(input string, index int, err error) *IdentifierError {
+	return &IdentifierError{Input: input, Index: index, Err: err}
+}
+
+func indexInvalidIPv6Char(host string) int {
+	// IPv6char    = DIGIT / %x41-46 / %x61-66 / ":" / "."
+	//                  ; 0-9, A-F, a-f, :, .
+	return strings.IndexFunc(host, func(ch rune) bool {
+		return (ch < '0' || ch > '9') && (ch < 'a' || ch > 'f') && (ch < 'A' || ch > 'F') && ch != ':' && ch != '.'
+	})
+}
+
+func isValidIPv4Chunk(str string) bool {
+	if len(str) == 0 || len(str) > 3 {
+		return false
+	}
+	for _, ch := range str {
+		if ch < '0' || ch > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isSpecCompliantIPv4(host string) bool {
+	// IPv4address = 1*3DIGIT "." 1*3DIGIT "." 1*3DIGIT "." 1*3DIGIT
+	if len(host) < 7 || len(host) > 15 {
+		return false
+	}
+	parts := strings.Split(host, ".")
+	return len(parts) == 4 &&
+		isValidIPv4Chunk(parts[0]) &&
+		isValidIPv4Chunk(parts[1]) &&
+		isValidIPv4Chunk(parts[2]) &&
+		isValidIPv4Chunk(parts[3])
+}
+
+func indexInvalidDNSChar(host string) int {
+	// dns-char    = DIGIT / ALPHA / "-" / "."
+	return strings.IndexFunc(host, func(ch rune) bool {
+		return (ch < '0' || ch > '9') && (ch < 'a' || ch > 'z') && (ch < 'A' || ch > 'Z') && ch != '-' && ch != '.'
+	})
+}
+
+// ParseServerName parses the hostname and port from a Matrix server name and validates that
+// it matches the grammar specified in https://spec.matrix.org/v1.11/appendices/#server-name
+//
+// Validation errors are returned as *IdentifierError.
+func ParseServerName(serverName string) (host string, port uint16, err error) {
+	if len(serverName) == 0 {
+		return "", 0, ErrEmptyServerName
+	} else if len(serverName) > ServerNameMaxLength {
+		return "", 0, ErrServerNameTooLong
+	}
+	host = serverName
+	colonIdx := strings.LastIndexByte(serverName, ':')
+	// A colon is only a port separator if it's after the closing bracket of an IPv6 literal
+	if colonIdx > 0 && (serverName[0] != '[' || serverName[colonIdx-1] == ']') {
+		portStr := serverName[colonIdx+1:]
+		u64Port, parseErr := strconv.ParseUint(portStr, 10, 16)
+		if parseErr != nil || len(portStr) > 5 {
+			return "", 0, newIdentifierError(serverName, -1, ErrInvalidServerNamePort)
+		}
+		port = uint16(u64Port)
+		host = serverName[:colonIdx]
+	}
+	if len(host) > 0 && host[0] == '[' {
+		if len(host) < 4 || host[len(host)-1] != ']' {
+			return "", 0, newIdentifierError(serverName, -1, ErrInvalidIPv6Literal)
+		}
+		host = host[1 : len(host)-1]
+		if invalidIdx := indexInvalidIPv6Char(host); invalidIdx >= 0 {
+			return "", 0, newIdentifierError(serverName, invalidIdx+1, ErrInvalidIPv6Literal)
+		} else if len(host) > 45 || net.ParseIP(host) == nil {
+			return "", 0, newIdentifierError(serverName, -1, ErrInvalidIPv6Literal)
+		}
+	} else if !isSpecCompliantIPv4(host) {
+		if len(host) == 0 {
+			return "", 0, newIdentifierError(serverName, -1, ErrInvalidServerNameHost)
+		} else if invalidIdx := indexInvalidDNSChar(host); invalidIdx >= 0 {
+			return "", 0, newIdentifierError(serverName, invalidIdx, ErrInvalidServerNameHost)
+		}
+	}
+	return host, port, nil
+}
+
+// ValidateServerName checks that the given server name matches the grammar in the spec.
+func ValidateServerName(serverName string) error {
+	_, _, err := ParseServerName(serverName)
+	return err
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package id_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"maunium.net/go/mautrix/id"
+)
+
+func TestParseServerName_Valid(t *testing.T) {
+	testCases := []struct {
+		serverName string
+		host       string
+		port       uint16
+	}{
+		{"matrix.org", "matrix.org", 0},
+		{"matrix.org:8448", "matrix.org", 8448},
+		{"1.2.3.4", "1.2.3.4", 0},
+		{"1.2.3.4:1234", "1.2.3.4", 1234},
+		{"[1234:5678::abcd]", "1234:5678::abcd", 0},
+		{"[::1]:8448", "::1", 8448},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.serverName, func(t *testing.T) {
+			host, port, err := id.ParseServerName(tc.serverName)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.host, host)
+			assert.Equal(t, tc.port, port)
+		})
+	}
+}
+
+func TestParseServerName_Invalid(t *testing.T) {
+	testCases := []struct {
+		serverName string
+		err        error
+		index      int
+	}{
+		{"matrix.org:port", id.ErrInvalidServerNamePort, -1},
+		{"matrix.org:123456", id.ErrInvalidServerNamePort, -1},
+		{"1234:5678::abcd", id.ErrInvalidServerNamePort, -1},
+		{"[1234:5678::abcd", id.ErrInvalidIPv6Literal, -1},
+		{"[1234:5678::abcg]", id.ErrInvalidIPv6Literal, 15},
+		{"matrix_org", id.ErrInvalidServerNameHost, 6},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.serverName, func(t *testing.T) {
+			_, _, err := id.ParseServerName(tc.serverName)
+			assert.ErrorIs(t, err, tc.err)
+			var identErr *id.IdentifierError
+			if assert.True(t, errors.As(err, &identErr)) {
+				assert.Equal(t, tc.index, identErr.Index)
+			}
+		})
+	}
+	assert.ErrorIs(t, id.ValidateServerName(""), id.ErrEmptyServerName)
+}
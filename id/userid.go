@@ -94,15 +94,61 @@ var ValidLocalpartRegex = regexp.MustCompile("^[0-9a-z-.=_/+]+$")
 
 // ValidateUserLocalpart validates a Matrix user ID localpart using the grammar
 // in https://matrix.org/docs/spec/appendices#user-identifier
+//
+// If the localpart contains invalid characters, the returned error is an *IdentifierError.
 func ValidateUserLocalpart(localpart string) error {
 	if len(localpart) == 0 {
 		return ErrEmptyLocalpart
 	} else if !ValidLocalpartRegex.MatchString(localpart) {
-		return fmt.Errorf("'%s' %w", localpart, ErrNoncompliantLocalpart)
+		invalidIdx := strings.IndexFunc(localpart, func(ch rune) bool {
+			return (ch < '0' || ch > '9') && (ch < 'a' || ch > 'z') && !strings.ContainsRune("-.=_/+", ch)
+		})
+		return newIdentifierError(localpart, invalidIdx, ErrNoncompliantLocalpart)
+	}
+	return nil
+}
+
+// ValidateHistoricalUserLocalpart validates a Matrix user ID localpart using the historical grammar,
+// which allows any printable ASCII character except for colons.
+// New user IDs should always use the stricter grammar checked by ValidateUserLocalpart.
+func ValidateHistoricalUserLocalpart(localpart string) error {
+	if len(localpart) == 0 {
+		return ErrEmptyLocalpart
+	}
+	// extended_user_id_char = %x21-39 / %x3B-7E  ; all ASCII printing chars except :
+	invalidIdx := strings.IndexFunc(localpart, func(ch rune) bool {
+		return ch < 0x21 || ch > 0x7E || ch == ':'
+	})
+	if invalidIdx >= 0 {
+		return newIdentifierError(localpart, invalidIdx, ErrNoncompliantLocalpart)
 	}
 	return nil
 }
 
+func (userID UserID) validate(validateLocalpart func(string) error) error {
+	localpart, homeserver, err := userID.Parse()
+	if err != nil {
+		return err
+	} else if len(userID) > UserIDMaxLength {
+		return ErrUserIDTooLong
+	} else if err = validateLocalpart(localpart); err != nil {
+		return err
+	}
+	return ValidateServerName(homeserver)
+}
+
+// Validate checks that the entire user ID, including the server name, matches the grammar in the spec.
+// The localpart is validated using the strict grammar (see ValidateUserLocalpart).
+func (userID UserID) Validate() error {
+	return userID.validate(ValidateUserLocalpart)
+}
+
+// ValidateHistorical is like Validate, but allows localparts that only match the historical grammar
+// (see ValidateHistoricalUserLocalpart). This should be used for user IDs received from other servers.
+func (userID UserID) ValidateHistorical() error {
+	return userID.validate(ValidateHistoricalUserLocalpart)
+}
+
 // ParseAndValidate parses the user ID into the localpart and server name like Parse,
 // and also validates that the localpart is allowed according to the user identifiers spec.
 func (userID UserID) ParseAndValidate() (localpart, homeserver string, err error) {
@@ -84,3 +84,19 @@ func TestUserID_URI(t *testing.T) {
 	userID := id.NewUserID("hello", "example.com")
 	assert.Equal(t, userID.URI().String(), "matrix:u/hello:example.com")
 }
+
+func TestUserID_Validate(t *testing.T) {
+	assert.NoError(t, id.UserID("@user:example.org").Validate())
+	assert.ErrorIs(t, id.UserID("@User:example.org").Validate(), id.ErrNoncompliantLocalpart)
+	assert.ErrorIs(t, id.UserID("@user:example_org").Validate(), id.ErrInvalidServerNameHost)
+	var identErr *id.IdentifierError
+	if assert.ErrorAs(t, id.UserID("@us er:example.org").Validate(), &identErr) {
+		assert.Equal(t, 2, identErr.Index)
+	}
+}
+
+func TestUserID_ValidateHistorical(t *testing.T) {
+	assert.NoError(t, id.UserID("@User!:example.org").ValidateHistorical())
+	assert.ErrorIs(t, id.UserID("@us er:example.org").ValidateHistorical(), id.ErrNoncompliantLocalpart)
+	assert.ErrorIs(t, id.UserID("@:example.org").ValidateHistorical(), id.ErrEmptyLocalpart)
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package id
+
+import (
+	"cmp"
+	"net"
+	"slices"
+)
+
+// DefaultMaxViaServers is the number of via servers recommended by the spec.
+const DefaultMaxViaServers = 3
+
+// ViaServerMinPowerLevel is the minimum power level a user must have for their server to be picked as the first via server.
+const ViaServerMinPowerLevel = 50
+
+// RoomServerInfo contains the information about a room that is needed to compute via servers.
+type RoomServerInfo struct {
+	// The joined members of the room.
+	Members []UserID
+	// The power levels of users in the room. Users who aren't in the map are treated as having no power.
+	PowerLevels map[UserID]int
+	// An optional function to check whether the server is allowed by the room's server ACLs.
+	IsAllowed func(serverName string) bool
+}
+
+func (info *RoomServerInfo) isEligible(serverName string) bool {
+	if serverName == "" {
+		return false
+	}
+	host, _, err := ParseServerName(serverName)
+	// IP literals are not recommended, as they're unlikely to be around for a long time
+	if err != nil || net.ParseIP(host) != nil {
+		return false
+	}
+	return info.IsAllowed == nil || info.IsAllowed(serverName)
+}
+
+// ComputeViaServers picks the servers to include in the via parameter of matrix.to links, matrix: URIs
+// and invites as recommended in https://spec.matrix.org/v1.11/appendices/#routing
+//
+// The first server is the server of the highest power level user (if any user has power level 50 or higher),
+// and the rest are the servers with the most joined members. Servers that are IP literals or aren't allowed
+// by the server ACLs are skipped. If limit is zero or negative, DefaultMaxViaServers is used.
+func ComputeViaServers(info RoomServerInfo, limit int) []string {
+	if limit <= 0 {
+		limit = DefaultMaxViaServers
+	}
+	population := make(map[string]int)
+	var topUserServer string
+	topPowerLevel := ViaServerMinPowerLevel - 1
+	for _, member := range info.Members {
+		serverName := member.Homeserver()
+		count, seen := population[serverName]
+		if !seen && !info.isEligible(serverName) {
+			population[serverName] = -1
+			continue
+		} else if count < 0 {
+			continue
+		}
+		population[serverName] = count + 1
+		if pl := info.PowerLevels[member]; pl > topPowerLevel {
+			topPowerLevel = pl
+			topUserServer = serverName
+		}
+	}
+	servers := make([]string, 0, len(population))
+	for serverName, count := range population {
+		if count > 0 && serverName != topUserServer {
+			servers = append(servers, serverName)
+		}
+	}
+	slices.SortFunc(servers, func(a, b string) int {
+		if population[a] != population[b] {
+			return population[b] - population[a]
+		}
+		return cmp.Compare(a, b)
+	})
+	if topUserServer != "" {
+		servers = append([]string{topUserServer}, servers...)
+	}
+	if len(servers) > limit {
+		servers = servers[:limit]
+	}
+	return servers
+}
+
+// ViaURI returns a matrix: URI or matrix.to link to the room with via servers computed using ComputeViaServers.
+func (roomID RoomID) ViaURI(info RoomServerInfo) *MatrixURI {
+	return roomID.URI(ComputeViaServers(info, DefaultMaxViaServers)...)
+}
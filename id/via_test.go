@@ -0,0 +1,40 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package id_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"maunium.net/go/mautrix/id"
+)
+
+func TestComputeViaServers(t *testing.T) {
+	info := id.RoomServerInfo{
+		Members: []id.UserID{
+			"@admin:small.example",
+			"@a:big.example", "@b:big.example", "@c:big.example",
+			"@a:medium.example", "@b:medium.example",
+			"@a:other.example",
+			"@a:1.2.3.4", "@b:1.2.3.4", "@c:1.2.3.4", "@d:1.2.3.4",
+			"@a:banned.example", "@b:banned.example", "@c:banned.example", "@d:banned.example",
+		},
+		PowerLevels: map[id.UserID]int{
+			"@admin:small.example": 100,
+			"@a:medium.example":    50,
+			"@a:banned.example":    100,
+		},
+		IsAllowed: func(serverName string) bool {
+			return serverName != "banned.example"
+		},
+	}
+	assert.Equal(t, []string{"small.example", "big.example", "medium.example"}, id.ComputeViaServers(info, 0))
+	assert.Equal(t, []string{"small.example", "big.example", "medium.example", "other.example"}, id.ComputeViaServers(info, 10))
+	info.PowerLevels = nil
+	assert.Equal(t, []string{"big.example", "medium.example"}, id.ComputeViaServers(info, 2))
+}
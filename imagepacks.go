@@ -0,0 +1,167 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// ResolvedImage is an image from an image pack (MSC2545) along with information about the pack it came from.
+type ResolvedImage struct {
+	Shortcode string
+	Image     *event.ImagePackImage
+	Pack      *event.ImagePackInfo
+	// The room and state key of the pack. Both are empty for the user's own pack.
+	RoomID   id.RoomID
+	StateKey string
+}
+
+// HasUsage returns true if the image can be used for the given purpose, see event.ImagePackImage.HasUsage.
+func (ri *ResolvedImage) HasUsage(usage event.ImagePackUsage) bool {
+	return ri.Image.HasUsage(ri.Pack, usage)
+}
+
+// ImagePackIndex is a merged index of image packs keyed by shortcode.
+//
+// When multiple packs contain the same shortcode, the image from the pack that was added first is used.
+type ImagePackIndex struct {
+	images map[string]*ResolvedImage
+	order  []string
+}
+
+// NewImagePackIndex creates a new empty image pack index.
+func NewImagePackIndex() *ImagePackIndex {
+	return &ImagePackIndex{images: make(map[string]*ResolvedImage)}
+}
+
+// AddPack adds all images in the given pack to the index, except ones whose shortcodes are already in the index.
+func (ipi *ImagePackIndex) AddPack(roomID id.RoomID, stateKey string, pack *event.ImagePackEventContent) {
+	if pack == nil {
+		return
+	}
+	shortcodes := make([]string, 0, len(pack.Images))
+	for shortcode, image := range pack.Images {
+		if image != nil && image.URL != "" {
+			shortcodes = append(shortcodes, shortcode)
+		}
+	}
+	// Map iteration order is random, so sort the shortcodes to make the index order deterministic.
+	slices.Sort(shortcodes)
+	for _, shortcode := range shortcodes {
+		if _, exists := ipi.images[shortcode]; exists {
+			continue
+		}
+		ipi.images[shortcode] = &ResolvedImage{
+			Shortcode: shortcode,
+			Image:     pack.Images[shortcode],
+			Pack:      &pack.Pack,
+			RoomID:    roomID,
+			StateKey:  stateKey,
+		}
+		ipi.order = append(ipi.order, shortcode)
+	}
+}
+
+// Get returns the image with the given shortcode (without surrounding colons), or nil if there's no such image.
+func (ipi *ImagePackIndex) Get(shortcode string) *ResolvedImage {
+	return ipi.images[shortcode]
+}
+
+// Images returns all images in the index that can be used for the given purpose.
+func (ipi *ImagePackIndex) Images(usage event.ImagePackUsage) []*ResolvedImage {
+	output := make([]*ResolvedImage, 0, len(ipi.order))
+	for _, shortcode := range ipi.order {
+		if image := ipi.images[shortcode]; image.HasUsage(usage) {
+			output = append(output, image)
+		}
+	}
+	return output
+}
+
+// ShortcodeToCustomEmoji returns the URL of the emoticon with the given shortcode.
+// This implements the mdext.CustomEmojiDictionary interface for rendering custom emojis in markdown.
+func (ipi *ImagePackIndex) ShortcodeToCustomEmoji(shortcode string) (id.ContentURIString, bool) {
+	image := ipi.images[shortcode]
+	if image == nil || !image.HasUsage(event.ImagePackUsageEmoticon) {
+		return "", false
+	}
+	return image.Image.URL, true
+}
+
+// GetImagePacks resolves all image packs (MSC2545) that are available to the user in the given room.
+//
+// The user's own pack is preferred, followed by the packs in the given room (if not empty), followed by the packs
+// that the user has enabled globally in im.ponies.emote_rooms. Globally enabled packs that can't be fetched
+// (e.g. because the user has left the room) are skipped.
+func (cli *Client) GetImagePacks(ctx context.Context, roomID id.RoomID) (*ImagePackIndex, error) {
+	index := NewImagePackIndex()
+	var userPack event.ImagePackEventContent
+	err := cli.GetAccountData(ctx, event.AccountDataUnstableImagePack.Type, &userPack)
+	if err != nil && !errors.Is(err, MNotFound) {
+		return nil, fmt.Errorf("failed to get user image pack: %w", err)
+	}
+	index.AddPack("", "", &userPack)
+
+	if roomID != "" {
+		state, err := cli.State(ctx, roomID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get room state: %w", err)
+		}
+		stateKeys := make([]string, 0, len(state[event.StateUnstableImagePack]))
+		for stateKey := range state[event.StateUnstableImagePack] {
+			stateKeys = append(stateKeys, stateKey)
+		}
+		slices.Sort(stateKeys)
+		for _, stateKey := range stateKeys {
+			evt := state[event.StateUnstableImagePack][stateKey]
+			_ = evt.Content.ParseRaw(evt.Type)
+			if pack, ok := evt.Content.Parsed.(*event.ImagePackEventContent); ok {
+				index.AddPack(roomID, stateKey, pack)
+			}
+		}
+	}
+
+	var enabledRooms event.ImagePackRoomsEventContent
+	err = cli.GetAccountData(ctx, event.AccountDataUnstableImagePackRooms.Type, &enabledRooms)
+	if err != nil && !errors.Is(err, MNotFound) {
+		return nil, fmt.Errorf("failed to get enabled image pack rooms: %w", err)
+	}
+	log := cli.cliOrContextLog(ctx)
+	packRoomIDs := make([]id.RoomID, 0, len(enabledRooms.Rooms))
+	for packRoomID := range enabledRooms.Rooms {
+		if packRoomID != roomID {
+			packRoomIDs = append(packRoomIDs, packRoomID)
+		}
+	}
+	slices.Sort(packRoomIDs)
+	for _, packRoomID := range packRoomIDs {
+		stateKeys := make([]string, 0, len(enabledRooms.Rooms[packRoomID]))
+		for stateKey := range enabledRooms.Rooms[packRoomID] {
+			stateKeys = append(stateKeys, stateKey)
+		}
+		slices.Sort(stateKeys)
+		for _, stateKey := range stateKeys {
+			var pack event.ImagePackEventContent
+			err = cli.StateEvent(ctx, packRoomID, event.StateUnstableImagePack, stateKey, &pack)
+			if err != nil {
+				log.Warn().Err(err).
+					Stringer("pack_room_id", packRoomID).
+					Str("pack_state_key", stateKey).
+					Msg("Failed to get globally enabled image pack")
+				continue
+			}
+			index.AddPack(packRoomID, stateKey, &pack)
+		}
+	}
+	return index, nil
+}
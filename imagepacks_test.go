@@ -0,0 +1,63 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestClient_GetImagePacks(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /_matrix/client/v3/user/{userID}/account_data/im.ponies.user_emotes", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"images": {"meow": {"url": "mxc://example.com/user_meow"}}, "pack": {}}`))
+	})
+	mux.HandleFunc("GET /_matrix/client/v3/user/{userID}/account_data/im.ponies.emote_rooms", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"rooms": {"!global:example.com": {"": {}}, "!left:example.com": {"": {}}}}`))
+	})
+	mux.HandleFunc("GET /_matrix/client/v3/rooms/!room:example.com/state", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"type": "im.ponies.room_emotes", "state_key": "", "event_id": "$pack", "sender": "@admin:example.com", "content": {
+			"images": {"meow": {"url": "mxc://example.com/room_meow"}, "sticker": {"url": "mxc://example.com/sticker", "usage": ["sticker"]}},
+			"pack": {"display_name": "Room pack"}
+		}}]`))
+	})
+	mux.HandleFunc("GET /_matrix/client/v3/rooms/!global:example.com/state/im.ponies.room_emotes/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"images": {"wave": {"url": "mxc://example.com/wave"}}, "pack": {"usage": ["emoticon"]}}`))
+	})
+	mux.HandleFunc("GET /_matrix/client/v3/rooms/!left:example.com/state/im.ponies.room_emotes/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errcode": "M_FORBIDDEN", "error": "You are not in the room"}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+
+	index, err := cli.GetImagePacks(context.Background(), "!room:example.com")
+	require.NoError(t, err)
+	url, ok := index.ShortcodeToCustomEmoji("meow")
+	assert.True(t, ok)
+	assert.Equal(t, id.ContentURIString("mxc://example.com/user_meow"), url)
+	_, ok = index.ShortcodeToCustomEmoji("sticker")
+	assert.False(t, ok)
+	wave := index.Get("wave")
+	require.NotNil(t, wave)
+	assert.Equal(t, id.RoomID("!global:example.com"), wave.RoomID)
+	stickers := index.Images(event.ImagePackUsageSticker)
+	require.Len(t, stickers, 2)
+	assert.Equal(t, "meow", stickers[0].Shortcode)
+	assert.Equal(t, "sticker", stickers[1].Shortcode)
+}
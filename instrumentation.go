@@ -0,0 +1,181 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Instrumentation is an interface for collecting traces and metrics from a Client.
+//
+// It's meant to be implemented by adapters for e.g. OpenTelemetry or Prometheus.
+// Implementations should embed NoopInstrumentation so that methods added in the future won't break them.
+type Instrumentation interface {
+	// StartRequest is called before each HTTP request attempt is sent. The returned context is used for the request,
+	// which allows tracing implementations to attach a span (e.g. for propagating trace headers in a custom transport).
+	// The returned function is called once the request is done and must not be nil.
+	StartRequest(ctx context.Context, info *RequestInfo) (context.Context, func(*ResponseInfo))
+	// ObserveSync is called after each /sync request made by Client.SyncWithContext.
+	ObserveSync(ctx context.Context, info *SyncInfo)
+	// ObserveCryptoOperation is called after end-to-end encryption operations like encrypting or decrypting events.
+	ObserveCryptoOperation(ctx context.Context, op CryptoOperation, duration time.Duration, err error)
+}
+
+// RequestInfo contains information about a HTTP request for Instrumentation.
+type RequestInfo struct {
+	Method string
+	// Endpoint is the URL path with variable parts replaced by placeholders,
+	// e.g. /_matrix/client/v3/rooms/{roomID}/send/{eventType}/{txnID}.
+	// It's meant to be used as a low-cardinality span name or metric label.
+	Endpoint string
+	Request  *http.Request
+}
+
+// ResponseInfo contains information about the result of a HTTP request for Instrumentation.
+type ResponseInfo struct {
+	// StatusCode is the HTTP status code, or zero if the request failed before getting a response.
+	StatusCode int
+	// ErrCode is the Matrix error code in the response body, if there was one.
+	ErrCode  string
+	Duration time.Duration
+	Error    error
+}
+
+// SyncInfo contains information about a /sync request for Instrumentation.
+type SyncInfo struct {
+	Since    string
+	Streamed bool
+	// RequestDuration is how long the /sync request itself took, including long-polling.
+	RequestDuration time.Duration
+	// ProcessDuration is how long it took to process the response.
+	// Processing delays the next /sync request, so this is effectively the sync loop lag.
+	ProcessDuration time.Duration
+	Error           error
+}
+
+// CryptoOperation is the type of end-to-end encryption operation passed to Instrumentation.ObserveCryptoOperation.
+type CryptoOperation string
+
+const (
+	CryptoOpEncryptMegolm     CryptoOperation = "encrypt_megolm"
+	CryptoOpDecryptMegolm     CryptoOperation = "decrypt_megolm"
+	CryptoOpDecryptOlm        CryptoOperation = "decrypt_olm"
+	CryptoOpShareGroupSession CryptoOperation = "share_group_session"
+	CryptoOpFetchKeys         CryptoOperation = "fetch_keys"
+)
+
+// NoopInstrumentation is an Instrumentation implementation that does nothing.
+// It can be embedded in other implementations to only implement some methods.
+type NoopInstrumentation struct{}
+
+var _ Instrumentation = NoopInstrumentation{}
+
+func noopFinishRequest(*ResponseInfo) {}
+
+func (NoopInstrumentation) StartRequest(ctx context.Context, _ *RequestInfo) (context.Context, func(*ResponseInfo)) {
+	return ctx, noopFinishRequest
+}
+
+func (NoopInstrumentation) ObserveSync(context.Context, *SyncInfo) {}
+
+func (NoopInstrumentation) ObserveCryptoOperation(context.Context, CryptoOperation, time.Duration, error) {
+}
+
+func (cli *Client) instrumentRequest(req *http.Request) (*http.Request, func(res *http.Response, err error, duration time.Duration)) {
+	if cli.Instrumentation == nil {
+		return req, func(*http.Response, error, time.Duration) {}
+	}
+	ctx, finish := cli.Instrumentation.StartRequest(req.Context(), &RequestInfo{
+		Method:   req.Method,
+		Endpoint: EndpointTemplate(req.URL.Path),
+		Request:  req,
+	})
+	return req.WithContext(ctx), func(res *http.Response, err error, duration time.Duration) {
+		info := &ResponseInfo{Duration: duration, Error: err}
+		if res != nil {
+			info.StatusCode = res.StatusCode
+		}
+		var httpErr HTTPError
+		if errors.As(err, &httpErr) && httpErr.RespError != nil {
+			info.ErrCode = httpErr.RespError.ErrCode
+		}
+		finish(info)
+	}
+}
+
+func (cli *Client) observeSync(ctx context.Context, info *SyncInfo) {
+	if cli.Instrumentation != nil {
+		cli.Instrumentation.ObserveSync(ctx, info)
+	}
+}
+
+// ObserveCryptoOperation reports an end-to-end encryption operation to the client's Instrumentation, if set.
+// It's meant to be called by crypto implementations.
+func (cli *Client) ObserveCryptoOperation(ctx context.Context, op CryptoOperation, start time.Time, err error) {
+	if cli != nil && cli.Instrumentation != nil {
+		cli.Instrumentation.ObserveCryptoOperation(ctx, op, time.Since(start), err)
+	}
+}
+
+// Path segments after which the following segments are always variable, along with the placeholders to use for them.
+var variableSegmentsAfter = map[string][]string{
+	"rooms":        {"{roomID}"},
+	"room":         {"{roomAlias}"},
+	"user":         {"{userID}"},
+	"profile":      {"{userID}"},
+	"send":         {"{eventType}", "{txnID}"},
+	"sendToDevice": {"{eventType}", "{txnID}"},
+	"redact":       {"{eventID}", "{txnID}"},
+	"state":        {"{eventType}", "{stateKey}"},
+	"event":        {"{eventID}"},
+	"context":      {"{eventID}"},
+	"relations":    {"{eventID}", "{relType}", "{eventType}"},
+	"receipt":      {"{receiptType}", "{eventID}"},
+	"account_data": {"{eventType}"},
+	"tags":         {"{tag}"},
+	"devices":      {"{deviceID}"},
+	"filter":       {"{filterID}"},
+	"download":     {"{serverName}", "{mediaID}", "{fileName}"},
+	"thumbnail":    {"{serverName}", "{mediaID}"},
+	"upload":       {"{serverName}", "{mediaID}"},
+}
+
+// EndpointTemplate replaces the variable parts of a Matrix API path with placeholders,
+// e.g. /_matrix/client/v3/rooms/!foo:example.com/send/m.room.message/123 becomes
+// /_matrix/client/v3/rooms/{roomID}/send/{eventType}/{txnID}.
+//
+// The result is a best-effort approximation meant for low-cardinality labels, not an exact route match.
+func EndpointTemplate(path string) string {
+	parts := strings.Split(path, "/")
+	var pending []string
+	for i, part := range parts {
+		if part == "" {
+			continue
+		} else if len(pending) > 0 {
+			parts[i] = pending[0]
+			pending = pending[1:]
+			continue
+		}
+		switch part[0] {
+		case '!':
+			parts[i] = "{roomID}"
+		case '@':
+			parts[i] = "{userID}"
+		case '$':
+			parts[i] = "{eventID}"
+		case '#':
+			parts[i] = "{roomAlias}"
+		default:
+			pending = variableSegmentsAfter[part]
+		}
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+)
+
+func TestEndpointTemplate(t *testing.T) {
+	testCases := map[string]string{
+		"/_matrix/client/v3/rooms/!room:example.com/send/m.room.message/mautrix-go_123":    "/_matrix/client/v3/rooms/{roomID}/send/{eventType}/{txnID}",
+		"/_matrix/client/v3/rooms/!room:example.com/state/m.room.member/@user:example.com": "/_matrix/client/v3/rooms/{roomID}/state/{eventType}/{stateKey}",
+		"/_matrix/client/v3/rooms/!room:example.com/state":                                 "/_matrix/client/v3/rooms/{roomID}/state",
+		"/_matrix/client/v3/user/@user:example.com/filter":                                 "/_matrix/client/v3/user/{userID}/filter",
+		"/_matrix/client/v1/media/download/example.com/abcDEF123":                          "/_matrix/client/v1/media/download/{serverName}/{mediaID}",
+		"/_matrix/client/v3/directory/room/#alias:example.com":                             "/_matrix/client/v3/directory/room/{roomAlias}",
+		"/_matrix/client/v3/sync":                                                          "/_matrix/client/v3/sync",
+	}
+	for path, expected := range testCases {
+		assert.Equal(t, expected, mautrix.EndpointTemplate(path), path)
+	}
+}
+
+type recordingInstrumentation struct {
+	mautrix.NoopInstrumentation
+	requests  []*mautrix.RequestInfo
+	responses []*mautrix.ResponseInfo
+}
+
+type spanContextKey struct{}
+
+func (ri *recordingInstrumentation) StartRequest(ctx context.Context, info *mautrix.RequestInfo) (context.Context, func(*mautrix.ResponseInfo)) {
+	ri.requests = append(ri.requests, info)
+	return context.WithValue(ctx, spanContextKey{}, len(ri.requests)), func(info *mautrix.ResponseInfo) {
+		ri.responses = append(ri.responses, info)
+	}
+}
+
+func TestClient_Instrumentation(t *testing.T) {
+	var spanIDs []any
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /_matrix/client/v3/account/whoami", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"user_id":"@user:example.com"}`))
+	})
+	mux.HandleFunc("GET /_matrix/client/v3/profile/{userID}", func(w http.ResponseWriter, r *http.Request) {
+		mautrix.MNotFound.WithMessage("Profile not found").Write(w)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+	instr := &recordingInstrumentation{}
+	cli.Instrumentation = instr
+	cli.RequestHook = func(req *http.Request) {
+		spanIDs = append(spanIDs, req.Context().Value(spanContextKey{}))
+	}
+
+	_, err = cli.Whoami(context.Background())
+	require.NoError(t, err)
+	_, err = cli.GetProfile(context.Background(), "@other:example.com")
+	require.ErrorIs(t, err, mautrix.MNotFound)
+
+	require.Len(t, instr.requests, 2)
+	require.Len(t, instr.responses, 2)
+	assert.Equal(t, []any{1, 2}, spanIDs)
+	assert.Equal(t, "/_matrix/client/v3/account/whoami", instr.requests[0].Endpoint)
+	assert.Equal(t, http.StatusOK, instr.responses[0].StatusCode)
+	assert.NoError(t, instr.responses[0].Error)
+	assert.Equal(t, "/_matrix/client/v3/profile/{userID}", instr.requests[1].Endpoint)
+	assert.Equal(t, http.StatusNotFound, instr.responses[1].StatusCode)
+	assert.Equal(t, "M_NOT_FOUND", instr.responses[1].ErrCode)
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestClient_KnockRoom(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /_matrix/client/v3/knock/{roomIDOrAlias}", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "#room:example.com", r.PathValue("roomIDOrAlias"))
+		assert.Equal(t, []string{"example.com", "example.org"}, r.URL.Query()["via"])
+		var req mautrix.ReqKnockRoom
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		assert.Equal(t, "let me in", req.Reason)
+		_, _ = w.Write([]byte(`{"room_id":"!room:example.com"}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+	cli.StateStore = mautrix.NewMemoryStateStore()
+
+	resp, err := cli.Knock(context.Background(), "#room:example.com", "let me in", "example.com", "example.org")
+	require.NoError(t, err)
+	assert.Equal(t, id.RoomID("!room:example.com"), resp.RoomID)
+	member, err := cli.StateStore.GetMember(context.Background(), resp.RoomID, cli.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, event.MembershipKnock, member.Membership)
+}
+
+func TestClient_MoveKnockState(t *testing.T) {
+	cli, err := mautrix.NewClient("https://example.com", "@user:example.com", "token")
+	require.NoError(t, err)
+	var resp mautrix.RespSync
+	err = json.Unmarshal([]byte(`{"next_batch": "1", "rooms": {"knock": {"!room:example.com": {"knock_state": {"events": [
+		{"type": "m.room.name", "state_key": "", "sender": "@admin:example.com", "content": {"name": "Test room"}},
+		{"type": "m.room.member", "state_key": "@user:example.com", "sender": "@user:example.com", "content": {"membership": "knock"}}
+	]}}}}}`), &resp)
+	require.NoError(t, err)
+
+	cli.MoveKnockState(context.Background(), &resp, "")
+	events := resp.Rooms.Knock["!room:example.com"].State.Events
+	require.Len(t, events, 1)
+	assert.Equal(t, event.StateMember, events[0].Type)
+	require.Len(t, events[0].Unsigned.KnockRoomState, 1)
+	assert.Equal(t, "Test room", events[0].Unsigned.KnockRoomState[0].Content.AsRoomName().Name)
+}
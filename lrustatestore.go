@@ -0,0 +1,396 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"maps"
+	"sync"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type lruRoomState struct {
+	roomID  id.RoomID
+	members map[id.UserID]*event.MemberEventContent
+	// The user IDs of cached members, most recently used first.
+	memberOrder    *list.List
+	memberElems    map[id.UserID]*list.Element
+	membersFetched bool
+	// Set if members have been evicted, which means the member list can't be used for sharing room keys.
+	membersIncomplete bool
+	powerLevels       *event.PowerLevelsEventContent
+	// Set if the room was evicted from the cache and its power levels haven't been stored again since.
+	powerLevelsEvicted bool
+}
+
+func (room *lruRoomState) touchMember(userID id.UserID) {
+	if elem, ok := room.memberElems[userID]; ok {
+		room.memberOrder.MoveToFront(elem)
+	}
+}
+
+func (room *lruRoomState) setMember(userID id.UserID, member *event.MemberEventContent) {
+	room.members[userID] = member
+	if elem, ok := room.memberElems[userID]; ok {
+		room.memberOrder.MoveToFront(elem)
+	} else {
+		room.memberElems[userID] = room.memberOrder.PushFront(userID)
+	}
+}
+
+func (room *lruRoomState) deleteMember(userID id.UserID) {
+	delete(room.members, userID)
+	if elem, ok := room.memberElems[userID]; ok {
+		room.memberOrder.Remove(elem)
+		delete(room.memberElems, userID)
+	}
+}
+
+// ErrLRUStateEvicted is returned by [LRUStateStore] when the requested state was evicted from the cache,
+// i.e. when the member list of a room is incomplete or the power levels of a room were forgotten.
+// The state should be refetched from the server (and stored with ReplaceCachedMembers or SetPowerLevels).
+var ErrLRUStateEvicted = errors.New("state was evicted from the LRU state store")
+
+// LRUStateStore is an in-memory StateStore that only keeps the state of the most recently used rooms.
+//
+// When more than MaxRooms rooms are cached, the least recently used room is evicted. When a room has more than
+// MaxMembersPerRoom cached members, the least recently used members who aren't joined are evicted first, followed
+// by the least recently used joined members, and the room's member list is marked as not fetched so that it'll be
+// refetched when the full list is needed. The membership of OwnUserID is never evicted from a cached room.
+//
+// After members have been evicted, GetRoomJoinedOrInvitedMembers returns [ErrLRUStateEvicted] until the full
+// member list is stored again, and GetPowerLevels does the same for evicted rooms until the power levels are
+// stored again. Rooms that have never been cached are returned as empty without an error.
+//
+// Encryption state is never evicted, as forgetting it could cause messages to be sent unencrypted. Encrypted
+// rooms are also never evicted and their members are never trimmed, as room keys must be shared with every member.
+type LRUStateStore struct {
+	// The maximum number of rooms to keep in memory. Zero means unlimited.
+	MaxRooms int
+	// The maximum number of members to keep in memory per room. Zero means unlimited.
+	MaxMembersPerRoom int
+	// The user ID of the client using the store. Its membership is never evicted when trimming members,
+	// so that checks like IsInRoom on the own user don't start failing in large rooms.
+	OwnUserID id.UserID
+
+	rooms      map[id.RoomID]*list.Element
+	order      *list.List
+	encryption map[id.RoomID]*event.EncryptionEventContent
+	// IDs of rooms whose state was evicted, so that reads can return an error instead of empty state.
+	evicted map[id.RoomID]struct{}
+	lock    sync.Mutex
+}
+
+var _ StateStore = (*LRUStateStore)(nil)
+var _ RoomListingStateStore = (*LRUStateStore)(nil)
+
+// NewLRUStateStore creates a new bounded in-memory state store.
+func NewLRUStateStore(maxRooms, maxMembersPerRoom int) *LRUStateStore {
+	return &LRUStateStore{
+		MaxRooms:          maxRooms,
+		MaxMembersPerRoom: maxMembersPerRoom,
+
+		rooms:      make(map[id.RoomID]*list.Element),
+		order:      list.New(),
+		encryption: make(map[id.RoomID]*event.EncryptionEventContent),
+		evicted:    make(map[id.RoomID]struct{}),
+	}
+}
+
+// getRoom returns the cached state of the room and marks it as recently used.
+// If create is true, the room is added to the cache if it doesn't exist. The lock must be held when calling this.
+func (store *LRUStateStore) getRoom(roomID id.RoomID, create bool) *lruRoomState {
+	elem, ok := store.rooms[roomID]
+	if ok {
+		store.order.MoveToFront(elem)
+		return elem.Value.(*lruRoomState)
+	} else if !create {
+		return nil
+	}
+	_, wasEvicted := store.evicted[roomID]
+	delete(store.evicted, roomID)
+	room := &lruRoomState{
+		roomID:      roomID,
+		members:     make(map[id.UserID]*event.MemberEventContent),
+		memberOrder: list.New(),
+		memberElems: make(map[id.UserID]*list.Element),
+
+		membersIncomplete:  wasEvicted,
+		powerLevelsEvicted: wasEvicted,
+	}
+	store.rooms[roomID] = store.order.PushFront(room)
+	store.evictRooms()
+	return room
+}
+
+// evictRooms removes the least recently used unencrypted rooms until there are at most MaxRooms rooms.
+// If all remaining rooms are encrypted, the cache is allowed to grow past the limit.
+// The most recently used room is never evicted.
+func (store *LRUStateStore) evictRooms() {
+	for elem := store.order.Back(); elem != store.order.Front() && store.MaxRooms > 0 && store.order.Len() > store.MaxRooms; {
+		prev := elem.Prev()
+		room := elem.Value.(*lruRoomState)
+		if store.encryption[room.roomID] == nil {
+			store.order.Remove(elem)
+			delete(store.rooms, room.roomID)
+			store.evicted[room.roomID] = struct{}{}
+		}
+		elem = prev
+	}
+}
+
+func (store *LRUStateStore) trimMembers(room *lruRoomState) {
+	if store.MaxMembersPerRoom <= 0 || len(room.members) <= store.MaxMembersPerRoom || store.encryption[room.roomID] != nil {
+		return
+	}
+	room.membersFetched = false
+	room.membersIncomplete = true
+	for _, evictJoined := range []bool{false, true} {
+		for elem := room.memberOrder.Back(); elem != nil && len(room.members) > store.MaxMembersPerRoom; {
+			prev := elem.Prev()
+			userID := elem.Value.(id.UserID)
+			member := room.members[userID]
+			if userID != store.OwnUserID && (evictJoined || member == nil || member.Membership != event.MembershipJoin) {
+				room.deleteMember(userID)
+			}
+			elem = prev
+		}
+	}
+}
+
+// RoomCount returns the number of rooms currently in the cache.
+func (store *LRUStateStore) RoomCount() int {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return store.order.Len()
+}
+
+// GetAllRoomIDs returns the IDs of all rooms currently in the cache, including rooms where only the encryption
+// state is cached.
+func (store *LRUStateStore) GetAllRoomIDs(_ context.Context) ([]id.RoomID, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	roomIDs := make([]id.RoomID, 0, len(store.rooms))
+	for roomID := range store.rooms {
+		roomIDs = append(roomIDs, roomID)
+	}
+	for roomID := range store.encryption {
+		if _, ok := store.rooms[roomID]; !ok {
+			roomIDs = append(roomIDs, roomID)
+		}
+	}
+	return roomIDs, nil
+}
+
+func (store *LRUStateStore) IsInRoom(ctx context.Context, roomID id.RoomID, userID id.UserID) bool {
+	return store.IsMembership(ctx, roomID, userID, event.MembershipJoin)
+}
+
+func (store *LRUStateStore) IsInvited(ctx context.Context, roomID id.RoomID, userID id.UserID) bool {
+	return store.IsMembership(ctx, roomID, userID, event.MembershipJoin, event.MembershipInvite)
+}
+
+func (store *LRUStateStore) IsMembership(ctx context.Context, roomID id.RoomID, userID id.UserID, allowedMemberships ...event.Membership) bool {
+	member, _ := store.GetMember(ctx, roomID, userID)
+	for _, allowedMembership := range allowedMemberships {
+		if allowedMembership == member.Membership {
+			return true
+		}
+	}
+	return false
+}
+
+func (store *LRUStateStore) GetMember(ctx context.Context, roomID id.RoomID, userID id.UserID) (*event.MemberEventContent, error) {
+	member, err := store.TryGetMember(ctx, roomID, userID)
+	if member == nil && err == nil {
+		member = &event.MemberEventContent{Membership: event.MembershipLeave}
+	}
+	return member, err
+}
+
+func (store *LRUStateStore) TryGetMember(_ context.Context, roomID id.RoomID, userID id.UserID) (*event.MemberEventContent, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	room := store.getRoom(roomID, false)
+	if room == nil {
+		return nil, nil
+	}
+	room.touchMember(userID)
+	return room.members[userID], nil
+}
+
+func (store *LRUStateStore) SetMembership(_ context.Context, roomID id.RoomID, userID id.UserID, membership event.Membership) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	room := store.getRoom(roomID, true)
+	member, ok := room.members[userID]
+	if !ok {
+		member = &event.MemberEventContent{Membership: membership}
+	} else {
+		member.Membership = membership
+	}
+	room.setMember(userID, member)
+	store.trimMembers(room)
+	return nil
+}
+
+func (store *LRUStateStore) SetMember(_ context.Context, roomID id.RoomID, userID id.UserID, member *event.MemberEventContent) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	room := store.getRoom(roomID, true)
+	room.setMember(userID, member)
+	store.trimMembers(room)
+	return nil
+}
+
+func (store *LRUStateStore) IsConfusableName(ctx context.Context, roomID id.RoomID, currentUser id.UserID, name string) ([]id.UserID, error) {
+	return nil, nil
+}
+
+func (store *LRUStateStore) ClearCachedMembers(_ context.Context, roomID id.RoomID, memberships ...event.Membership) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	room := store.getRoom(roomID, false)
+	if room == nil {
+		return nil
+	}
+	for userID, member := range room.members {
+		if len(memberships) == 0 {
+			room.deleteMember(userID)
+			continue
+		}
+		for _, membership := range memberships {
+			if membership == member.Membership {
+				room.deleteMember(userID)
+				break
+			}
+		}
+	}
+	room.membersFetched = false
+	return nil
+}
+
+func (store *LRUStateStore) ReplaceCachedMembers(ctx context.Context, roomID id.RoomID, evts []*event.Event, onlyMemberships ...event.Membership) error {
+	_ = store.ClearCachedMembers(ctx, roomID, onlyMemberships...)
+	for _, evt := range evts {
+		UpdateStateStore(ctx, store, evt)
+	}
+	if len(onlyMemberships) == 0 {
+		_ = store.MarkMembersFetched(ctx, roomID)
+	}
+	return nil
+}
+
+func (store *LRUStateStore) SetPowerLevels(_ context.Context, roomID id.RoomID, levels *event.PowerLevelsEventContent) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	room := store.getRoom(roomID, true)
+	room.powerLevels = levels
+	room.powerLevelsEvicted = false
+	return nil
+}
+
+func (store *LRUStateStore) GetPowerLevels(_ context.Context, roomID id.RoomID) (*event.PowerLevelsEventContent, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	room := store.getRoom(roomID, false)
+	if room == nil {
+		if _, evicted := store.evicted[roomID]; evicted {
+			return nil, ErrLRUStateEvicted
+		}
+		return nil, nil
+	} else if room.powerLevels == nil && room.powerLevelsEvicted {
+		return nil, ErrLRUStateEvicted
+	}
+	return room.powerLevels, nil
+}
+
+func (store *LRUStateStore) HasFetchedMembers(_ context.Context, roomID id.RoomID) (bool, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	room := store.getRoom(roomID, false)
+	return room != nil && room.membersFetched, nil
+}
+
+func (store *LRUStateStore) MarkMembersFetched(_ context.Context, roomID id.RoomID) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	room := store.getRoom(roomID, true)
+	// If the member list didn't fit in the cache, it can't be marked as fully fetched
+	room.membersFetched = store.MaxMembersPerRoom <= 0 || len(room.members) <= store.MaxMembersPerRoom || store.encryption[roomID] != nil
+	if room.membersFetched {
+		room.membersIncomplete = false
+	}
+	return nil
+}
+
+func (store *LRUStateStore) GetAllMembers(_ context.Context, roomID id.RoomID) (map[id.UserID]*event.MemberEventContent, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	room := store.getRoom(roomID, false)
+	if room == nil {
+		return map[id.UserID]*event.MemberEventContent{}, nil
+	}
+	return maps.Clone(room.members), nil
+}
+
+func (store *LRUStateStore) SetEncryptionEvent(_ context.Context, roomID id.RoomID, content *event.EncryptionEventContent) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.encryption[roomID] = content
+	return nil
+}
+
+func (store *LRUStateStore) GetEncryptionEvent(_ context.Context, roomID id.RoomID) (*event.EncryptionEventContent, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return store.encryption[roomID], nil
+}
+
+func (store *LRUStateStore) IsEncrypted(ctx context.Context, roomID id.RoomID) (bool, error) {
+	cfg, err := store.GetEncryptionEvent(ctx, roomID)
+	return cfg != nil && cfg.Algorithm == id.AlgorithmMegolmV1, err
+}
+
+func (store *LRUStateStore) GetRoomJoinedOrInvitedMembers(_ context.Context, roomID id.RoomID) ([]id.UserID, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	room := store.getRoom(roomID, false)
+	if room == nil {
+		if _, evicted := store.evicted[roomID]; evicted {
+			return nil, ErrLRUStateEvicted
+		}
+		return nil, nil
+	} else if room.membersIncomplete {
+		return nil, ErrLRUStateEvicted
+	}
+	members := make([]id.UserID, 0, len(room.members))
+	for userID, member := range room.members {
+		if member.Membership == event.MembershipJoin || member.Membership == event.MembershipInvite {
+			members = append(members, userID)
+		}
+	}
+	return members, nil
+}
+
+// FindSharedRooms returns the cached rooms where the given user is joined or invited.
+// Rooms that have been evicted from the cache are not included.
+func (store *LRUStateStore) FindSharedRooms(_ context.Context, userID id.UserID) (rooms []id.RoomID, err error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for roomID, elem := range store.rooms {
+		member := elem.Value.(*lruRoomState).members[userID]
+		if member != nil && (member.Membership == event.MembershipJoin || member.Membership == event.MembershipInvite) {
+			rooms = append(rooms, roomID)
+		}
+	}
+	return rooms, nil
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestLRUStateStore_RoomEviction(t *testing.T) {
+	ctx := context.Background()
+	store := mautrix.NewLRUStateStore(2, 0)
+	_ = store.SetMembership(ctx, "!a:example.org", "@user:example.org", event.MembershipJoin)
+	_ = store.SetMembership(ctx, "!b:example.org", "@user:example.org", event.MembershipJoin)
+	_ = store.SetEncryptionEvent(ctx, "!a:example.org", &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1})
+	// Touch room A so that room B is the least recently used one
+	assert.True(t, store.IsInRoom(ctx, "!a:example.org", "@user:example.org"))
+	_ = store.SetMembership(ctx, "!c:example.org", "@user:example.org", event.MembershipJoin)
+
+	assert.Equal(t, 2, store.RoomCount())
+	assert.True(t, store.IsInRoom(ctx, "!a:example.org", "@user:example.org"))
+	assert.False(t, store.IsInRoom(ctx, "!b:example.org", "@user:example.org"))
+	assert.True(t, store.IsInRoom(ctx, "!c:example.org", "@user:example.org"))
+
+	_ = store.SetMembership(ctx, "!d:example.org", "@user:example.org", event.MembershipJoin)
+	encrypted, _ := store.IsEncrypted(ctx, "!a:example.org")
+	assert.True(t, encrypted)
+}
+
+func TestLRUStateStore_MemberCap(t *testing.T) {
+	ctx := context.Background()
+	store := mautrix.NewLRUStateStore(0, 2)
+	_ = store.SetMembership(ctx, "!a:example.org", "@joined1:example.org", event.MembershipJoin)
+	_ = store.SetMembership(ctx, "!a:example.org", "@left:example.org", event.MembershipLeave)
+	_ = store.MarkMembersFetched(ctx, "!a:example.org")
+	fetched, _ := store.HasFetchedMembers(ctx, "!a:example.org")
+	assert.True(t, fetched)
+
+	_ = store.SetMembership(ctx, "!a:example.org", "@joined2:example.org", event.MembershipJoin)
+	members, _ := store.GetAllMembers(ctx, "!a:example.org")
+	assert.Len(t, members, 2)
+	assert.NotContains(t, members, id.UserID("@left:example.org"))
+	fetched, _ = store.HasFetchedMembers(ctx, "!a:example.org")
+	assert.False(t, fetched)
+}
+
+func TestLRUStateStore_MemberCap_LRUOrder(t *testing.T) {
+	ctx := context.Background()
+	store := mautrix.NewLRUStateStore(0, 3)
+	store.OwnUserID = "@bot:example.org"
+	_ = store.SetMembership(ctx, "!a:example.org", "@bot:example.org", event.MembershipJoin)
+	_ = store.SetMembership(ctx, "!a:example.org", "@joined1:example.org", event.MembershipJoin)
+	_ = store.SetMembership(ctx, "!a:example.org", "@joined2:example.org", event.MembershipJoin)
+	_ = store.SetMembership(ctx, "!a:example.org", "@joined3:example.org", event.MembershipJoin)
+	// The own user is never evicted even though it's the least recently used member
+	members, _ := store.GetAllMembers(ctx, "!a:example.org")
+	assert.Len(t, members, 3)
+	assert.NotContains(t, members, id.UserID("@joined1:example.org"))
+	assert.True(t, store.IsInRoom(ctx, "!a:example.org", "@bot:example.org"))
+
+	// Reading a member marks it as recently used, so joined3 is evicted instead of joined2
+	assert.True(t, store.IsInRoom(ctx, "!a:example.org", "@joined2:example.org"))
+	_ = store.SetMembership(ctx, "!a:example.org", "@joined4:example.org", event.MembershipJoin)
+	// Members who aren't joined are evicted first, even if they were used more recently
+	_ = store.SetMembership(ctx, "!a:example.org", "@left:example.org", event.MembershipLeave)
+	members, _ = store.GetAllMembers(ctx, "!a:example.org")
+	assert.Len(t, members, 3)
+	assert.Contains(t, members, id.UserID("@bot:example.org"))
+	assert.Contains(t, members, id.UserID("@joined2:example.org"))
+	assert.Contains(t, members, id.UserID("@joined4:example.org"))
+}
+
+func TestLRUStateStore_FindSharedRooms(t *testing.T) {
+	ctx := context.Background()
+	store := mautrix.NewLRUStateStore(0, 0)
+	_ = store.SetMembership(ctx, "!a:example.org", "@user:example.org", event.MembershipJoin)
+	_ = store.SetMembership(ctx, "!b:example.org", "@user:example.org", event.MembershipInvite)
+	_ = store.SetMembership(ctx, "!c:example.org", "@user:example.org", event.MembershipLeave)
+	rooms, err := store.FindSharedRooms(ctx, "@user:example.org")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []id.RoomID{"!a:example.org", "!b:example.org"}, rooms)
+}
+
+func TestLRUStateStore_EvictedState(t *testing.T) {
+	ctx := context.Background()
+	store := mautrix.NewLRUStateStore(1, 2)
+	_ = store.SetPowerLevels(ctx, "!a:example.org", &event.PowerLevelsEventContent{UsersDefault: 50})
+	_ = store.SetMembership(ctx, "!a:example.org", "@user1:example.org", event.MembershipJoin)
+	_ = store.SetMembership(ctx, "!a:example.org", "@user2:example.org", event.MembershipJoin)
+	_ = store.SetMembership(ctx, "!a:example.org", "@user3:example.org", event.MembershipJoin)
+	// Trimmed member lists must not be returned as if they were complete
+	_, err := store.GetRoomJoinedOrInvitedMembers(ctx, "!a:example.org")
+	assert.ErrorIs(t, err, mautrix.ErrLRUStateEvicted)
+
+	_ = store.SetMembership(ctx, "!b:example.org", "@user1:example.org", event.MembershipJoin)
+	_, err = store.GetPowerLevels(ctx, "!a:example.org")
+	assert.ErrorIs(t, err, mautrix.ErrLRUStateEvicted)
+	_, err = store.GetRoomJoinedOrInvitedMembers(ctx, "!a:example.org")
+	assert.ErrorIs(t, err, mautrix.ErrLRUStateEvicted)
+	// Re-adding a single member to an evicted room doesn't make the state complete
+	_ = store.SetMembership(ctx, "!a:example.org", "@user1:example.org", event.MembershipJoin)
+	_, err = store.GetPowerLevels(ctx, "!a:example.org")
+	assert.ErrorIs(t, err, mautrix.ErrLRUStateEvicted)
+	_, err = store.GetRoomJoinedOrInvitedMembers(ctx, "!a:example.org")
+	assert.ErrorIs(t, err, mautrix.ErrLRUStateEvicted)
+
+	// Rooms that were never cached are just empty
+	members, err := store.GetRoomJoinedOrInvitedMembers(ctx, "!c:example.org")
+	assert.NoError(t, err)
+	assert.Empty(t, members)
+
+	// Storing the full state again makes it usable
+	assert.NoError(t, store.ReplaceCachedMembers(ctx, "!a:example.org", []*event.Event{
+		memberEvent("!a:example.org", "@user1:example.org", event.MembershipJoin),
+	}))
+	members, err = store.GetRoomJoinedOrInvitedMembers(ctx, "!a:example.org")
+	assert.NoError(t, err)
+	assert.Equal(t, []id.UserID{"@user1:example.org"}, members)
+	_ = store.SetPowerLevels(ctx, "!a:example.org", &event.PowerLevelsEventContent{UsersDefault: 50})
+	levels, err := store.GetPowerLevels(ctx, "!a:example.org")
+	assert.NoError(t, err)
+	assert.Equal(t, 50, levels.UsersDefault)
+}
+
+func TestLRUStateStore_EncryptedRoomsPinned(t *testing.T) {
+	ctx := context.Background()
+	store := mautrix.NewLRUStateStore(1, 2)
+	_ = store.SetEncryptionEvent(ctx, "!a:example.org", &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1})
+	for _, userID := range []id.UserID{"@user1:example.org", "@user2:example.org", "@user3:example.org"} {
+		_ = store.SetMembership(ctx, "!a:example.org", userID, event.MembershipJoin)
+	}
+	_ = store.SetMembership(ctx, "!b:example.org", "@user1:example.org", event.MembershipJoin)
+	_ = store.SetMembership(ctx, "!c:example.org", "@user1:example.org", event.MembershipJoin)
+
+	members, err := store.GetRoomJoinedOrInvitedMembers(ctx, "!a:example.org")
+	assert.NoError(t, err)
+	assert.Len(t, members, 3)
+	assert.Equal(t, 2, store.RoomCount())
+	_, err = store.GetRoomJoinedOrInvitedMembers(ctx, "!b:example.org")
+	assert.ErrorIs(t, err, mautrix.ErrLRUStateEvicted)
+}
+
+func memberEvent(roomID id.RoomID, userID id.UserID, membership event.Membership) *event.Event {
+	stateKey := userID.String()
+	return &event.Event{
+		RoomID:   roomID,
+		Type:     event.StateMember,
+		StateKey: &stateKey,
+		Content:  event.Content{Parsed: &event.MemberEventContent{Membership: membership}},
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestClient_AsyncUpload(t *testing.T) {
+	var uploaded bool
+	var downloadAttempts int
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /_matrix/media/v3/upload/example.com/abc", func(w http.ResponseWriter, r *http.Request) {
+		if uploaded {
+			mautrix.MCannotOverwriteMedia.WithMessage("Media already uploaded").Write(w)
+			return
+		}
+		uploaded = true
+		_, _ = w.Write([]byte("{}"))
+	})
+	mux.HandleFunc("GET /_matrix/client/v1/media/download/example.com/abc", func(w http.ResponseWriter, r *http.Request) {
+		downloadAttempts++
+		if downloadAttempts == 1 {
+			assert.NotEmpty(t, r.URL.Query().Get("timeout_ms"))
+			mautrix.MNotYetUploaded.WithMessage("Media not uploaded yet").Write(w)
+			return
+		}
+		_, _ = w.Write([]byte("hello"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	cli, err := mautrix.NewClient(srv.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+	mxc := id.ContentURI{Homeserver: "example.com", FileID: "abc"}
+
+	for i := 0; i < 2; i++ {
+		resp, err := cli.UploadToMXC(context.Background(), mxc, mautrix.ReqUploadMedia{
+			ContentBytes: []byte("hello"),
+			ContentType:  "text/plain",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, mxc, resp.ContentURI)
+	}
+
+	resp, err := cli.DownloadWithTimeout(context.Background(), mxc, 5*time.Second)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	assert.Equal(t, 2, downloadAttempts)
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mediaproxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix"
+)
+
+// Query parameters used for signed URLs.
+const (
+	SignedURLExpiryParam    = "mp_exp"
+	SignedURLAudienceParam  = "mp_aud"
+	SignedURLSignatureParam = "mp_sig"
+)
+
+var (
+	ErrMissingSignature = errors.New("missing URL signature")
+	ErrInvalidSignature = errors.New("invalid URL signature")
+	ErrURLExpired       = errors.New("signed URL has expired")
+	ErrInvalidAudience  = errors.New("signed URL audience is not allowed")
+)
+
+// URLSigner generates and verifies HMAC-signed media URLs with an expiry time and an optional audience.
+//
+// Only the path, expiry and audience are signed, so other query parameters (like thumbnail sizes)
+// can be changed without invalidating the signature.
+type URLSigner struct {
+	Key []byte
+}
+
+func (us *URLSigner) mac(path string, expiry int64, audience string) []byte {
+	h := hmac.New(sha256.New, us.Key)
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.FormatInt(expiry, 10)))
+	h.Write([]byte{0})
+	h.Write([]byte(audience))
+	return h.Sum(nil)
+}
+
+// SignURL adds the expiry, audience and signature query parameters to the given URL.
+// The audience is optional and can be used to restrict the URL to specific access policies.
+func (us *URLSigner) SignURL(u *url.URL, expiresAt time.Time, audience string) *url.URL {
+	expiry := expiresAt.Unix()
+	signed := *u
+	query := signed.Query()
+	query.Set(SignedURLExpiryParam, strconv.FormatInt(expiry, 10))
+	if audience != "" {
+		query.Set(SignedURLAudienceParam, audience)
+	} else {
+		query.Del(SignedURLAudienceParam)
+	}
+	query.Set(SignedURLSignatureParam, base64.RawURLEncoding.EncodeToString(us.mac(u.Path, expiry, audience)))
+	signed.RawQuery = query.Encode()
+	return &signed
+}
+
+// SignPath is a convenience wrapper for SignURL that takes and returns a path with an optional query string.
+func (us *URLSigner) SignPath(path string, expiresAt time.Time, audience string) (string, error) {
+	parsed, err := url.Parse(path)
+	if err != nil {
+		return "", err
+	}
+	return us.SignURL(parsed, expiresAt, audience).String(), nil
+}
+
+// Verify checks the signature of the given URL and returns the signed audience.
+func (us *URLSigner) Verify(u *url.URL) (audience string, err error) {
+	query := u.Query()
+	sigStr := query.Get(SignedURLSignatureParam)
+	if sigStr == "" {
+		return "", ErrMissingSignature
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigStr)
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+	expiry, err := strconv.ParseInt(query.Get(SignedURLExpiryParam), 10, 64)
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+	audience = query.Get(SignedURLAudienceParam)
+	if !hmac.Equal(sig, us.mac(u.Path, expiry, audience)) {
+		return "", ErrInvalidSignature
+	} else if time.Now().Unix() > expiry {
+		return "", ErrURLExpired
+	}
+	return audience, nil
+}
+
+// AccessPolicy restricts access to media download and thumbnail paths that start with PathPrefix.
+// Both the client and federation endpoints are checked, so policies for federation requests
+// must use a prefix like /_matrix/federation/v1/media/.
+//
+// Requests are allowed if the client IP is in AllowedIPs, or if the URL has a valid signature
+// and the signed audience is in Audiences (or Audiences is empty). If AllowedIPs is empty and
+// RequireSignedURL is false, the policy allows all requests.
+type AccessPolicy struct {
+	PathPrefix       string
+	AllowedIPs       []netip.Prefix
+	RequireSignedURL bool
+	Audiences        []string
+}
+
+func (ap *AccessPolicy) isIPAllowed(ip netip.Addr) bool {
+	if !ip.IsValid() {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range ap.AllowedIPs {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (mp *MediaProxy) isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range mp.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (mp *MediaProxy) getClientIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remoteAddr, _ := netip.ParseAddr(host)
+	if mp.ClientIPHeader == "" || (len(mp.TrustedProxies) > 0 && !mp.isTrustedProxy(remoteAddr)) {
+		return remoteAddr
+	}
+	var hops []string
+	for _, val := range r.Header.Values(mp.ClientIPHeader) {
+		hops = append(hops, strings.Split(val, ",")...)
+	}
+	if len(hops) == 0 {
+		return remoteAddr
+	}
+	// Entries to the left of the last trusted proxy may have been added by the client, so walk from the right
+	// and return the first address that wasn't added by a trusted proxy.
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return netip.Addr{}
+		} else if i == 0 || !mp.isTrustedProxy(addr) {
+			return addr
+		}
+	}
+	return remoteAddr
+}
+
+func (mp *MediaProxy) findAccessPolicy(path string) *AccessPolicy {
+	var best *AccessPolicy
+	for _, policy := range mp.AccessPolicies {
+		if strings.HasPrefix(path, policy.PathPrefix) && (best == nil || len(policy.PathPrefix) > len(best.PathPrefix)) {
+			best = policy
+		}
+	}
+	return best
+}
+
+// CheckAccess checks the request against the access policy with the longest matching path prefix.
+func (mp *MediaProxy) CheckAccess(r *http.Request) error {
+	policy := mp.findAccessPolicy(r.URL.Path)
+	if policy == nil || (len(policy.AllowedIPs) == 0 && !policy.RequireSignedURL) {
+		return nil
+	} else if policy.isIPAllowed(mp.getClientIP(r)) {
+		return nil
+	} else if mp.URLSigner == nil {
+		return ErrMissingSignature
+	}
+	audience, err := mp.URLSigner.Verify(r.URL)
+	if err != nil {
+		return err
+	} else if len(policy.Audiences) > 0 && !slices.Contains(policy.Audiences, audience) {
+		return ErrInvalidAudience
+	}
+	return nil
+}
+
+func (mp *MediaProxy) accessMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := mp.CheckAccess(r); err != nil {
+			mautrix.MForbidden.WithMessage("Access denied: %v", err).Write(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -15,6 +15,7 @@ import (
 	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/netip"
 	"net/textproto"
 	"net/url"
 	"os"
@@ -33,10 +34,11 @@ type GetMediaResponse interface {
 	isGetMediaResponse()
 }
 
-func (*GetMediaResponseURL) isGetMediaResponse()      {}
-func (*GetMediaResponseData) isGetMediaResponse()     {}
-func (*GetMediaResponseCallback) isGetMediaResponse() {}
-func (*GetMediaResponseFile) isGetMediaResponse()     {}
+func (*GetMediaResponseURL) isGetMediaResponse()        {}
+func (*GetMediaResponseData) isGetMediaResponse()       {}
+func (*GetMediaResponseCallback) isGetMediaResponse()   {}
+func (*GetMediaResponseFile) isGetMediaResponse()       {}
+func (*GetMediaResponseReadSeeker) isGetMediaResponse() {}
 
 type GetMediaResponseURL struct {
 	URL       string
@@ -53,6 +55,7 @@ type GetMediaResponseWriter interface {
 var (
 	_ GetMediaResponseWriter = (*GetMediaResponseCallback)(nil)
 	_ GetMediaResponseWriter = (*GetMediaResponseData)(nil)
+	_ GetMediaResponseWriter = (*GetMediaResponseReadSeeker)(nil)
 )
 
 type GetMediaResponseData struct {
@@ -91,6 +94,31 @@ func (d *GetMediaResponseCallback) GetContentType() string {
 	return d.ContentType
 }
 
+// GetMediaResponseReadSeeker is a media response backed by a seekable reader, such as a local file
+// or a reader that makes HTTP range requests to the upstream server.
+//
+// Range requests and conditional requests (If-None-Match, If-Modified-Since) to the client download
+// endpoints are handled automatically using [http.ServeContent]. Federation downloads always return the full file.
+type GetMediaResponseReadSeeker struct {
+	Reader      io.ReadSeekCloser
+	ContentType string
+	// Optional entity tag and modification time used for conditional requests.
+	ETag    string
+	ModTime time.Time
+}
+
+func (d *GetMediaResponseReadSeeker) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, d.Reader)
+}
+
+func (d *GetMediaResponseReadSeeker) GetContentType() string {
+	return d.ContentType
+}
+
+func (d *GetMediaResponseReadSeeker) GetContentLength() int64 {
+	return 0
+}
+
 type GetMediaResponseFile struct {
 	Callback    func(w *os.File) error
 	ContentType string
@@ -106,6 +134,18 @@ type MediaProxy struct {
 	GetMedia            GetMediaFunc
 	PrepareProxyRequest func(*http.Request)
 
+	// Optional signer for generating and verifying signed media URLs, used by AccessPolicies.
+	URLSigner *URLSigner
+	// Access policies for media download and thumbnail endpoints. See [AccessPolicy] for details.
+	AccessPolicies []*AccessPolicy
+	// The header to read the client IP from for access policies (e.g. X-Forwarded-For).
+	// If empty, the remote address of the connection is used.
+	ClientIPHeader string
+	// Reverse proxies whose entries in ClientIPHeader are trusted. The header is only used if the request
+	// comes from a trusted proxy, and the client IP is the rightmost address in it that isn't a trusted proxy.
+	// If empty, only the direct peer is trusted, i.e. the rightmost address in the header is used.
+	TrustedProxies []netip.Prefix
+
 	serverName string
 	serverKey  *federation.SigningKey
 
@@ -180,7 +220,9 @@ func (mp *MediaProxy) RegisterRoutes(router *mux.Router) {
 		mp.ClientMediaRouter = router.PathPrefix("/_matrix/client/v1/media").Subrouter()
 	}
 
-	mp.FederationRouter.HandleFunc("/v1/media/download/{mediaID}", mp.DownloadMediaFederation).Methods(http.MethodGet)
+	federationDownload := mp.accessMiddleware(http.HandlerFunc(mp.DownloadMediaFederation))
+	mp.FederationRouter.Handle("/v1/media/download/{mediaID}", federationDownload).Methods(http.MethodGet)
+	mp.FederationRouter.Handle("/v1/media/thumbnail/{mediaID}", federationDownload).Methods(http.MethodGet)
 	mp.FederationRouter.HandleFunc("/v1/version", mp.KeyServer.GetServerVersion).Methods(http.MethodGet)
 	mp.ClientMediaRouter.HandleFunc("/download/{serverName}/{mediaID}", mp.DownloadMedia).Methods(http.MethodGet)
 	mp.ClientMediaRouter.HandleFunc("/download/{serverName}/{mediaID}/{fileName}", mp.DownloadMedia).Methods(http.MethodGet)
@@ -204,6 +246,7 @@ func (mp *MediaProxy) RegisterRoutes(router *mux.Router) {
 		})
 	}
 	mp.ClientMediaRouter.Use(corsMiddleware)
+	mp.ClientMediaRouter.Use(mp.accessMiddleware)
 	mp.KeyServer.Register(router)
 }
 
@@ -278,6 +321,10 @@ func (mp *MediaProxy) DownloadMediaFederation(w http.ResponseWriter, r *http.Req
 	resp := mp.getMedia(w, r)
 	if resp == nil {
 		return
+	} else if seekResp, ok := resp.(*GetMediaResponseReadSeeker); ok {
+		defer func() {
+			_ = seekResp.Reader.Close()
+		}()
 	}
 
 	var mpw *multipart.Writer
@@ -294,7 +341,7 @@ func (mp *MediaProxy) DownloadMediaFederation(w http.ResponseWriter, r *http.Req
 			return
 		}
 	} else if fileResp, ok := resp.(*GetMediaResponseFile); ok {
-		responseStarted, err := doTempFileDownload(fileResp, func(wt io.WriterTo, size int64, mimeType string) error {
+		responseStarted, err := doTempFileDownload(fileResp, func(file *os.File, mimeType string) error {
 			mpw = startMultipart(ctx, w)
 			if mpw == nil {
 				return fmt.Errorf("failed to start multipart writer")
@@ -305,7 +352,7 @@ func (mp *MediaProxy) DownloadMediaFederation(w http.ResponseWriter, r *http.Req
 			if err != nil {
 				return fmt.Errorf("failed to create multipart data field: %w", err)
 			}
-			_, err = wt.WriteTo(dataPart)
+			_, err = file.WriteTo(dataPart)
 			return err
 		})
 		if err != nil {
@@ -392,12 +439,10 @@ func (mp *MediaProxy) DownloadMedia(w http.ResponseWriter, r *http.Request) {
 		}
 		w.WriteHeader(http.StatusTemporaryRedirect)
 	} else if fileResp, ok := resp.(*GetMediaResponseFile); ok {
-		responseStarted, err := doTempFileDownload(fileResp, func(wt io.WriterTo, size int64, mimeType string) error {
+		responseStarted, err := doTempFileDownload(fileResp, func(file *os.File, mimeType string) error {
 			mp.addHeaders(w, mimeType, vars["fileName"])
-			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
-			w.WriteHeader(http.StatusOK)
-			_, err := wt.WriteTo(w)
-			return err
+			http.ServeContent(w, r, "", time.Time{}, file)
+			return nil
 		})
 		if err != nil {
 			log.Err(err).Msg("Failed to do media proxy with temp file")
@@ -410,6 +455,15 @@ func (mp *MediaProxy) DownloadMedia(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 		}
+	} else if seekResp, ok := resp.(*GetMediaResponseReadSeeker); ok {
+		defer func() {
+			_ = seekResp.Reader.Close()
+		}()
+		mp.addHeaders(w, seekResp.ContentType, vars["fileName"])
+		if seekResp.ETag != "" {
+			w.Header().Set("ETag", seekResp.ETag)
+		}
+		http.ServeContent(w, r, "", seekResp.ModTime, seekResp.Reader)
 	} else if dataResp, ok := resp.(GetMediaResponseWriter); ok {
 		mp.addHeaders(w, dataResp.GetContentType(), vars["fileName"])
 		if dataResp.GetContentLength() != 0 {
@@ -427,7 +481,7 @@ func (mp *MediaProxy) DownloadMedia(w http.ResponseWriter, r *http.Request) {
 
 func doTempFileDownload(
 	data *GetMediaResponseFile,
-	respond func(w io.WriterTo, size int64, mimeType string) error,
+	respond func(file *os.File, mimeType string) error,
 ) (bool, error) {
 	tempFile, err := os.CreateTemp("", "mautrix-mediaproxy-*")
 	if err != nil {
@@ -445,10 +499,6 @@ func doTempFileDownload(
 	if err != nil {
 		return false, fmt.Errorf("failed to seek to start of temp file: %w", err)
 	}
-	fileInfo, err := tempFile.Stat()
-	if err != nil {
-		return false, fmt.Errorf("failed to stat temp file: %w", err)
-	}
 	mimeType := data.ContentType
 	if mimeType == "" {
 		buf := make([]byte, 512)
@@ -463,7 +513,7 @@ func doTempFileDownload(
 		}
 		mimeType = http.DetectContentType(buf)
 	}
-	err = respond(tempFile, fileInfo.Size(), mimeType)
+	err = respond(tempFile, mimeType)
 	if err != nil {
 		return true, err
 	}
@@ -0,0 +1,36 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"net/http"
+)
+
+// RoundTripFunc sends a single HTTP request and returns the response.
+type RoundTripFunc func(req *http.Request) (*http.Response, error)
+
+// RequestMiddleware wraps a RoundTripFunc. Middlewares can modify the request before calling next,
+// inspect or replace the response after calling next, or return a response without calling next at all
+// (e.g. to mock responses in tests).
+type RequestMiddleware func(next RoundTripFunc) RoundTripFunc
+
+// AddRequestHook adds a middleware to the client's request chain. Middlewares are called in the order
+// they were added, i.e. the first added middleware is the outermost one.
+//
+// Middlewares are called separately for each attempt of a request, so retries will go through the chain again.
+// This should be called before the client is used, as the middleware list is not safe for concurrent modification.
+func (cli *Client) AddRequestHook(middleware RequestMiddleware) {
+	cli.middlewares = append(cli.middlewares, middleware)
+}
+
+func (cli *Client) roundTrip(client *http.Client, req *http.Request) (*http.Response, error) {
+	next := RoundTripFunc(client.Do)
+	for i := len(cli.middlewares) - 1; i >= 0; i-- {
+		next = cli.middlewares[i](next)
+	}
+	return next(req)
+}
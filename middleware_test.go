@@ -0,0 +1,66 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+)
+
+func TestClient_AddRequestHook(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, []string{"outer", "inner"}, r.Header.Values("X-Test"))
+		_, _ = w.Write([]byte(`{"user_id": "@user:example.com"}`))
+	}))
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+
+	var order []string
+	addHeader := func(name string) mautrix.RequestMiddleware {
+		return func(next mautrix.RoundTripFunc) mautrix.RoundTripFunc {
+			return func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				req.Header.Add("X-Test", name)
+				return next(req)
+			}
+		}
+	}
+	cli.AddRequestHook(addHeader("outer"))
+	cli.AddRequestHook(addHeader("inner"))
+	resp, err := cli.Whoami(context.Background())
+	require.NoError(t, err)
+	assert.EqualValues(t, "@user:example.com", resp.UserID)
+	assert.Equal(t, []string{"outer", "inner"}, order)
+}
+
+func TestClient_AddRequestHook_Mock(t *testing.T) {
+	cli, err := mautrix.NewClient("https://matrix.example.invalid", "@user:example.com", "token")
+	require.NoError(t, err)
+	cli.AddRequestHook(func(next mautrix.RoundTripFunc) mautrix.RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(`{"user_id": "@mock:example.com"}`)),
+				Request:    req,
+			}, nil
+		}
+	})
+	resp, err := cli.Whoami(context.Background())
+	require.NoError(t, err)
+	assert.EqualValues(t, "@mock:example.com", resp.UserID)
+}
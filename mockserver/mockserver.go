@@ -0,0 +1,568 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package mockserver implements a minimal in-memory Matrix homeserver for tests.
+//
+// The server supports enough of the client-server API for end-to-end tests of clients and bridges, including
+// end-to-bridge encryption: password login, sync, sending message and state events, to-device messages,
+// account data, and key upload/query/claim. Sync responses can also be scripted with QueueSync.
+package mockserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/random"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/cryptohelper"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type deviceKey struct {
+	UserID   id.UserID
+	DeviceID id.DeviceID
+}
+
+type syncPosition struct {
+	timeline map[id.RoomID]int
+	sentRoom map[id.RoomID]bool
+}
+
+// MockServer is an in-memory Matrix homeserver wrapping an [httptest.Server].
+//
+// All the exported maps can be inspected and modified by tests, but the Lock must be held
+// while doing so if any client is running concurrently.
+type MockServer struct {
+	*httptest.Server
+	Router *http.ServeMux
+	Lock   sync.Mutex
+
+	ServerName string
+
+	accessTokens    map[string]deviceKey
+	DeviceInbox     map[id.UserID]map[id.DeviceID][]*event.Event
+	AccountData     map[id.UserID]map[event.Type]json.RawMessage
+	DeviceKeys      map[id.UserID]map[id.DeviceID]mautrix.DeviceKeys
+	OneTimeKeys     map[id.UserID]map[id.DeviceID]map[id.KeyID]mautrix.OneTimeKey
+	MasterKeys      map[id.UserID]mautrix.CrossSigningKeys
+	SelfSigningKeys map[id.UserID]mautrix.CrossSigningKeys
+	UserSigningKeys map[id.UserID]mautrix.CrossSigningKeys
+
+	// RoomMembers contains the users who receive events of each room in their syncs.
+	RoomMembers map[id.RoomID][]id.UserID
+	// RoomState contains the current state of each room, which is sent in the first sync that includes the room.
+	RoomState map[id.RoomID]map[event.Type]map[string]*event.Event
+	// RoomTimeline contains all events sent to each room.
+	RoomTimeline map[id.RoomID][]*event.Event
+
+	syncQueue     map[deviceKey][]*mautrix.RespSync
+	syncPositions map[deviceKey]*syncPosition
+	syncCounter   int
+	eventCounter  int
+}
+
+// Create starts a new mock server. The server is closed automatically when the test finishes.
+func Create(t testing.TB) *MockServer {
+	t.Helper()
+	ms := &MockServer{
+		Router:     http.NewServeMux(),
+		ServerName: "localhost",
+
+		accessTokens:    make(map[string]deviceKey),
+		DeviceInbox:     make(map[id.UserID]map[id.DeviceID][]*event.Event),
+		AccountData:     make(map[id.UserID]map[event.Type]json.RawMessage),
+		DeviceKeys:      make(map[id.UserID]map[id.DeviceID]mautrix.DeviceKeys),
+		OneTimeKeys:     make(map[id.UserID]map[id.DeviceID]map[id.KeyID]mautrix.OneTimeKey),
+		MasterKeys:      make(map[id.UserID]mautrix.CrossSigningKeys),
+		SelfSigningKeys: make(map[id.UserID]mautrix.CrossSigningKeys),
+		UserSigningKeys: make(map[id.UserID]mautrix.CrossSigningKeys),
+
+		RoomMembers:  make(map[id.RoomID][]id.UserID),
+		RoomState:    make(map[id.RoomID]map[event.Type]map[string]*event.Event),
+		RoomTimeline: make(map[id.RoomID][]*event.Event),
+
+		syncQueue:     make(map[deviceKey][]*mautrix.RespSync),
+		syncPositions: make(map[deviceKey]*syncPosition),
+	}
+	ms.Router.HandleFunc("POST /_matrix/client/v3/login", ms.postLogin)
+	ms.Router.HandleFunc("GET /_matrix/client/v3/sync", ms.authenticated(ms.getSync))
+	ms.Router.HandleFunc("PUT /_matrix/client/v3/rooms/{roomID}/send/{type}/{txnID}", ms.authenticated(ms.putSendEvent))
+	ms.Router.HandleFunc("PUT /_matrix/client/v3/rooms/{roomID}/state/{type}/{stateKey...}", ms.authenticated(ms.putStateEvent))
+	ms.Router.HandleFunc("PUT /_matrix/client/v3/sendToDevice/{type}/{txnID}", ms.authenticated(ms.putSendToDevice))
+	ms.Router.HandleFunc("PUT /_matrix/client/v3/user/{userID}/account_data/{type}", ms.authenticated(ms.putAccountData))
+	ms.Router.HandleFunc("GET /_matrix/client/v3/user/{userID}/account_data/{type}", ms.authenticated(ms.getAccountData))
+	ms.Router.HandleFunc("POST /_matrix/client/v3/keys/upload", ms.authenticated(ms.postKeysUpload))
+	ms.Router.HandleFunc("POST /_matrix/client/v3/keys/query", ms.authenticated(ms.postKeysQuery))
+	ms.Router.HandleFunc("POST /_matrix/client/v3/keys/claim", ms.authenticated(ms.postKeysClaim))
+	ms.Router.HandleFunc("POST /_matrix/client/v3/keys/device_signing/upload", ms.authenticated(ms.postDeviceSigningUpload))
+	ms.Router.HandleFunc("POST /_matrix/client/v3/keys/signatures/upload", ms.authenticated(ms.emptyResp))
+	ms.Server = httptest.NewServer(ms.Router)
+	t.Cleanup(ms.Close)
+	return ms
+}
+
+type authenticatedHandler func(w http.ResponseWriter, r *http.Request, device deviceKey)
+
+func (ms *MockServer) authenticated(handler authenticatedHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ms.Lock.Lock()
+		device, ok := ms.accessTokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+		ms.Lock.Unlock()
+		if !ok {
+			mautrix.MUnknownToken.WithMessage("Unknown access token").Write(w)
+			return
+		}
+		handler(w, r, device)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(data)
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, into any) bool {
+	err := json.NewDecoder(r.Body).Decode(into)
+	if err != nil {
+		mautrix.MNotJSON.WithMessage("Failed to parse request body: %v", err).Write(w)
+		return false
+	}
+	return true
+}
+
+func (ms *MockServer) emptyResp(w http.ResponseWriter, _ *http.Request, _ deviceKey) {
+	writeJSON(w, struct{}{})
+}
+
+func (ms *MockServer) postLogin(w http.ResponseWriter, r *http.Request) {
+	var req mautrix.ReqLogin
+	if !readJSON(w, r, &req) {
+		return
+	}
+	userID := id.UserID(req.Identifier.User)
+	if !strings.HasPrefix(req.Identifier.User, "@") {
+		userID = id.NewUserID(req.Identifier.User, ms.ServerName)
+	}
+	deviceID := req.DeviceID
+	if deviceID == "" {
+		deviceID = id.DeviceID(random.String(10))
+	}
+	accessToken := random.String(30)
+	ms.Lock.Lock()
+	ms.accessTokens[accessToken] = deviceKey{UserID: userID, DeviceID: deviceID}
+	ms.Lock.Unlock()
+	writeJSON(w, &mautrix.RespLogin{
+		AccessToken: accessToken,
+		DeviceID:    deviceID,
+		UserID:      userID,
+	})
+}
+
+// QueueSync adds a scripted sync response for the given device. Queued responses are returned before
+// anything else, with the next batch token, pending to-device events and new room events filled in by the server.
+func (ms *MockServer) QueueSync(userID id.UserID, deviceID id.DeviceID, resp *mautrix.RespSync) {
+	ms.Lock.Lock()
+	defer ms.Lock.Unlock()
+	key := deviceKey{UserID: userID, DeviceID: deviceID}
+	ms.syncQueue[key] = append(ms.syncQueue[key], resp)
+}
+
+func (ms *MockServer) getSync(w http.ResponseWriter, r *http.Request, device deviceKey) {
+	ms.Lock.Lock()
+	defer ms.Lock.Unlock()
+	var resp *mautrix.RespSync
+	if queue := ms.syncQueue[device]; len(queue) > 0 {
+		resp = queue[0]
+		ms.syncQueue[device] = queue[1:]
+	} else {
+		resp = &mautrix.RespSync{}
+	}
+	resp.ToDevice.Events = append(resp.ToDevice.Events, ms.DeviceInbox[device.UserID][device.DeviceID]...)
+	delete(ms.DeviceInbox[device.UserID], device.DeviceID)
+
+	pos, ok := ms.syncPositions[device]
+	if !ok || r.URL.Query().Get("since") == "" {
+		pos = &syncPosition{timeline: make(map[id.RoomID]int), sentRoom: make(map[id.RoomID]bool)}
+		ms.syncPositions[device] = pos
+	}
+	for roomID, members := range ms.RoomMembers {
+		if !slices.Contains(members, device.UserID) {
+			continue
+		}
+		newEvents := ms.RoomTimeline[roomID][pos.timeline[roomID]:]
+		if pos.sentRoom[roomID] && len(newEvents) == 0 {
+			continue
+		}
+		if resp.Rooms.Join == nil {
+			resp.Rooms.Join = make(map[id.RoomID]*mautrix.SyncJoinedRoom)
+		}
+		room, ok := resp.Rooms.Join[roomID]
+		if !ok {
+			room = &mautrix.SyncJoinedRoom{}
+			resp.Rooms.Join[roomID] = room
+		}
+		if !pos.sentRoom[roomID] {
+			for _, stateEvents := range ms.RoomState[roomID] {
+				for _, evt := range stateEvents {
+					room.State.Events = append(room.State.Events, evt)
+				}
+			}
+			pos.sentRoom[roomID] = true
+		}
+		room.Timeline.Events = append(room.Timeline.Events, newEvents...)
+		pos.timeline[roomID] = len(ms.RoomTimeline[roomID])
+	}
+	ms.syncCounter++
+	resp.NextBatch = strconv.Itoa(ms.syncCounter)
+	writeJSON(w, resp)
+}
+
+// AddRoomMembers makes the given users members of the room. Member state events are added to the room state
+// so that clients will have the users in their state stores after syncing.
+func (ms *MockServer) AddRoomMembers(roomID id.RoomID, userIDs ...id.UserID) {
+	for _, userID := range userIDs {
+		ms.Lock.Lock()
+		alreadyMember := slices.Contains(ms.RoomMembers[roomID], userID)
+		if !alreadyMember {
+			ms.RoomMembers[roomID] = append(ms.RoomMembers[roomID], userID)
+		}
+		ms.Lock.Unlock()
+		if !alreadyMember {
+			ms.AddStateEvent(roomID, userID, event.StateMember, userID.String(), &event.MemberEventContent{
+				Membership: event.MembershipJoin,
+			})
+		}
+	}
+}
+
+// AddStateEvent adds a state event to the room. The event is sent to all members in their next sync.
+func (ms *MockServer) AddStateEvent(roomID id.RoomID, sender id.UserID, evtType event.Type, stateKey string, content any) *event.Event {
+	evtType.Class = event.StateEventType
+	ms.Lock.Lock()
+	defer ms.Lock.Unlock()
+	evt := ms.makeEvent(roomID, sender, evtType, content)
+	evt.StateKey = &stateKey
+	ms.addStateEvent(evt)
+	ms.RoomTimeline[roomID] = append(ms.RoomTimeline[roomID], evt)
+	return evt
+}
+
+// AddEvent adds a message event to the room. The event is sent to all members in their next sync.
+func (ms *MockServer) AddEvent(roomID id.RoomID, sender id.UserID, evtType event.Type, content any) *event.Event {
+	evtType.Class = event.MessageEventType
+	ms.Lock.Lock()
+	defer ms.Lock.Unlock()
+	evt := ms.makeEvent(roomID, sender, evtType, content)
+	ms.RoomTimeline[roomID] = append(ms.RoomTimeline[roomID], evt)
+	return evt
+}
+
+func (ms *MockServer) addStateEvent(evt *event.Event) {
+	if _, ok := ms.RoomState[evt.RoomID]; !ok {
+		ms.RoomState[evt.RoomID] = make(map[event.Type]map[string]*event.Event)
+	}
+	if _, ok := ms.RoomState[evt.RoomID][evt.Type]; !ok {
+		ms.RoomState[evt.RoomID][evt.Type] = make(map[string]*event.Event)
+	}
+	ms.RoomState[evt.RoomID][evt.Type][*evt.StateKey] = evt
+}
+
+func (ms *MockServer) makeEvent(roomID id.RoomID, sender id.UserID, evtType event.Type, content any) *event.Event {
+	ms.eventCounter++
+	var parsedContent event.Content
+	switch typedContent := content.(type) {
+	case json.RawMessage:
+		parsedContent.VeryRaw = typedContent
+	default:
+		parsedContent.Parsed = content
+	}
+	rawContent, err := json.Marshal(&parsedContent)
+	if err != nil {
+		panic(fmt.Errorf("failed to marshal event content: %w", err))
+	}
+	return &event.Event{
+		ID:        id.EventID(fmt.Sprintf("$%d:%s", ms.eventCounter, ms.ServerName)),
+		RoomID:    roomID,
+		Sender:    sender,
+		Type:      evtType,
+		Timestamp: time.Now().UnixMilli(),
+		Content:   event.Content{VeryRaw: rawContent},
+	}
+}
+
+func (ms *MockServer) putSendEvent(w http.ResponseWriter, r *http.Request, device deviceKey) {
+	roomID := id.RoomID(r.PathValue("roomID"))
+	ms.Lock.Lock()
+	isRoomMember := slices.Contains(ms.RoomMembers[roomID], device.UserID)
+	ms.Lock.Unlock()
+	if !isRoomMember {
+		mautrix.MForbidden.WithMessage("You're not in the room").Write(w)
+		return
+	}
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		mautrix.MNotJSON.WithMessage("Failed to read request body").Write(w)
+		return
+	}
+	evt := ms.AddEvent(roomID, device.UserID, event.Type{Type: r.PathValue("type")}, json.RawMessage(content))
+	writeJSON(w, &mautrix.RespSendEvent{EventID: evt.ID})
+}
+
+func (ms *MockServer) putStateEvent(w http.ResponseWriter, r *http.Request, device deviceKey) {
+	roomID := id.RoomID(r.PathValue("roomID"))
+	ms.Lock.Lock()
+	isRoomMember := slices.Contains(ms.RoomMembers[roomID], device.UserID)
+	ms.Lock.Unlock()
+	if !isRoomMember {
+		mautrix.MForbidden.WithMessage("You're not in the room").Write(w)
+		return
+	}
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		mautrix.MNotJSON.WithMessage("Failed to read request body").Write(w)
+		return
+	}
+	evt := ms.AddStateEvent(roomID, device.UserID, event.Type{Type: r.PathValue("type")}, r.PathValue("stateKey"), json.RawMessage(content))
+	writeJSON(w, &mautrix.RespSendEvent{EventID: evt.ID})
+}
+
+func (ms *MockServer) putSendToDevice(w http.ResponseWriter, r *http.Request, device deviceKey) {
+	var req mautrix.ReqSendToDevice
+	if !readJSON(w, r, &req) {
+		return
+	}
+	evtType := event.Type{Type: r.PathValue("type"), Class: event.ToDeviceEventType}
+	ms.Lock.Lock()
+	defer ms.Lock.Unlock()
+	for userID, devices := range req.Messages {
+		if _, ok := ms.DeviceInbox[userID]; !ok {
+			ms.DeviceInbox[userID] = make(map[id.DeviceID][]*event.Event)
+		}
+		for deviceID, content := range devices {
+			targetDevices := []id.DeviceID{deviceID}
+			if deviceID == "*" {
+				targetDevices = targetDevices[:0]
+				for _, key := range ms.accessTokens {
+					if key.UserID == userID && !slices.Contains(targetDevices, key.DeviceID) {
+						targetDevices = append(targetDevices, key.DeviceID)
+					}
+				}
+			}
+			for _, targetDeviceID := range targetDevices {
+				ms.DeviceInbox[userID][targetDeviceID] = append(ms.DeviceInbox[userID][targetDeviceID], &event.Event{
+					Sender:  device.UserID,
+					Type:    evtType,
+					Content: event.Content{VeryRaw: content.VeryRaw},
+				})
+			}
+		}
+	}
+	writeJSON(w, struct{}{})
+}
+
+func (ms *MockServer) putAccountData(w http.ResponseWriter, r *http.Request, device deviceKey) {
+	userID := id.UserID(r.PathValue("userID"))
+	if userID != device.UserID {
+		mautrix.MForbidden.WithMessage("Can't set account data for other users").Write(w)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		mautrix.MNotJSON.WithMessage("Failed to read request body").Write(w)
+		return
+	}
+	ms.Lock.Lock()
+	if _, ok := ms.AccountData[userID]; !ok {
+		ms.AccountData[userID] = make(map[event.Type]json.RawMessage)
+	}
+	ms.AccountData[userID][event.Type{Type: r.PathValue("type"), Class: event.AccountDataEventType}] = data
+	ms.Lock.Unlock()
+	writeJSON(w, struct{}{})
+}
+
+func (ms *MockServer) getAccountData(w http.ResponseWriter, r *http.Request, device deviceKey) {
+	userID := id.UserID(r.PathValue("userID"))
+	if userID != device.UserID {
+		mautrix.MForbidden.WithMessage("Can't get account data of other users").Write(w)
+		return
+	}
+	ms.Lock.Lock()
+	data, ok := ms.AccountData[userID][event.Type{Type: r.PathValue("type"), Class: event.AccountDataEventType}]
+	ms.Lock.Unlock()
+	if !ok {
+		mautrix.MNotFound.WithMessage("Account data not found").Write(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+func (ms *MockServer) postKeysUpload(w http.ResponseWriter, r *http.Request, device deviceKey) {
+	var req mautrix.ReqUploadKeys
+	if !readJSON(w, r, &req) {
+		return
+	}
+	ms.Lock.Lock()
+	defer ms.Lock.Unlock()
+	if req.DeviceKeys != nil {
+		if _, ok := ms.DeviceKeys[device.UserID]; !ok {
+			ms.DeviceKeys[device.UserID] = make(map[id.DeviceID]mautrix.DeviceKeys)
+		}
+		ms.DeviceKeys[device.UserID][device.DeviceID] = *req.DeviceKeys
+	}
+	if _, ok := ms.OneTimeKeys[device.UserID]; !ok {
+		ms.OneTimeKeys[device.UserID] = make(map[id.DeviceID]map[id.KeyID]mautrix.OneTimeKey)
+	}
+	if _, ok := ms.OneTimeKeys[device.UserID][device.DeviceID]; !ok {
+		ms.OneTimeKeys[device.UserID][device.DeviceID] = make(map[id.KeyID]mautrix.OneTimeKey)
+	}
+	otks := ms.OneTimeKeys[device.UserID][device.DeviceID]
+	for keyID, key := range req.OneTimeKeys {
+		otks[keyID] = key
+	}
+	writeJSON(w, &mautrix.RespUploadKeys{
+		OneTimeKeyCounts: mautrix.OTKCount{SignedCurve25519: len(otks)},
+	})
+}
+
+func (ms *MockServer) postKeysQuery(w http.ResponseWriter, r *http.Request, _ deviceKey) {
+	var req mautrix.ReqQueryKeys
+	if !readJSON(w, r, &req) {
+		return
+	}
+	resp := &mautrix.RespQueryKeys{
+		MasterKeys:      make(map[id.UserID]mautrix.CrossSigningKeys),
+		SelfSigningKeys: make(map[id.UserID]mautrix.CrossSigningKeys),
+		UserSigningKeys: make(map[id.UserID]mautrix.CrossSigningKeys),
+		DeviceKeys:      make(map[id.UserID]map[id.DeviceID]mautrix.DeviceKeys),
+	}
+	ms.Lock.Lock()
+	defer ms.Lock.Unlock()
+	for userID, deviceIDs := range req.DeviceKeys {
+		if key, ok := ms.MasterKeys[userID]; ok {
+			resp.MasterKeys[userID] = key
+		}
+		if key, ok := ms.SelfSigningKeys[userID]; ok {
+			resp.SelfSigningKeys[userID] = key
+		}
+		if key, ok := ms.UserSigningKeys[userID]; ok {
+			resp.UserSigningKeys[userID] = key
+		}
+		devices := make(map[id.DeviceID]mautrix.DeviceKeys)
+		for deviceID, keys := range ms.DeviceKeys[userID] {
+			if len(deviceIDs) == 0 || slices.Contains(deviceIDs, deviceID) {
+				devices[deviceID] = keys
+			}
+		}
+		resp.DeviceKeys[userID] = devices
+	}
+	writeJSON(w, resp)
+}
+
+func (ms *MockServer) postKeysClaim(w http.ResponseWriter, r *http.Request, _ deviceKey) {
+	var req mautrix.ReqClaimKeys
+	if !readJSON(w, r, &req) {
+		return
+	}
+	resp := &mautrix.RespClaimKeys{
+		OneTimeKeys: make(map[id.UserID]map[id.DeviceID]map[id.KeyID]mautrix.OneTimeKey),
+	}
+	ms.Lock.Lock()
+	defer ms.Lock.Unlock()
+	for userID, devices := range req.OneTimeKeys {
+		for deviceID, algorithm := range devices {
+			for keyID, key := range ms.OneTimeKeys[userID][deviceID] {
+				if keyAlgorithm, _ := keyID.Parse(); keyAlgorithm != algorithm {
+					continue
+				}
+				if _, ok := resp.OneTimeKeys[userID]; !ok {
+					resp.OneTimeKeys[userID] = make(map[id.DeviceID]map[id.KeyID]mautrix.OneTimeKey)
+				}
+				resp.OneTimeKeys[userID][deviceID] = map[id.KeyID]mautrix.OneTimeKey{keyID: key}
+				delete(ms.OneTimeKeys[userID][deviceID], keyID)
+				break
+			}
+		}
+	}
+	writeJSON(w, resp)
+}
+
+func (ms *MockServer) postDeviceSigningUpload(w http.ResponseWriter, r *http.Request, device deviceKey) {
+	var req mautrix.UploadCrossSigningKeysReq
+	if !readJSON(w, r, &req) {
+		return
+	}
+	ms.Lock.Lock()
+	ms.MasterKeys[device.UserID] = req.Master
+	ms.SelfSigningKeys[device.UserID] = req.SelfSigning
+	ms.UserSigningKeys[device.UserID] = req.UserSigning
+	ms.Lock.Unlock()
+	writeJSON(w, struct{}{})
+}
+
+// Login creates a new client and logs it in with the given user and device IDs.
+// The client has an in-memory state store, which is updated automatically by the syncer.
+func (ms *MockServer) Login(t testing.TB, ctx context.Context, userID id.UserID, deviceID id.DeviceID) *mautrix.Client {
+	t.Helper()
+	client, err := mautrix.NewClient(ms.URL, "", "")
+	require.NoError(t, err)
+	client.StateStore = mautrix.NewMemoryStateStore()
+	client.Syncer.(mautrix.ExtensibleSyncer).OnEvent(client.StateStoreSyncHandler)
+	_, err = client.Login(ctx, &mautrix.ReqLogin{
+		Type: mautrix.AuthTypePassword,
+		Identifier: mautrix.UserIdentifier{
+			Type: mautrix.IdentifierTypeUser,
+			User: userID.String(),
+		},
+		DeviceID:         deviceID,
+		Password:         "password",
+		StoreCredentials: true,
+	})
+	require.NoError(t, err)
+	return client
+}
+
+// LoginWithCrypto logs in like Login, and additionally sets up a crypto helper with an in-memory crypto store.
+func (ms *MockServer) LoginWithCrypto(t testing.TB, ctx context.Context, userID id.UserID, deviceID id.DeviceID) (*mautrix.Client, crypto.Store) {
+	t.Helper()
+	client := ms.Login(t, ctx, userID, deviceID)
+	cryptoStore := crypto.NewMemoryStore(nil)
+	cryptoHelper, err := cryptohelper.NewCryptoHelper(client, []byte("test"), cryptoStore)
+	require.NoError(t, err)
+	client.Crypto = cryptoHelper
+	err = cryptoHelper.Init(ctx)
+	require.NoError(t, err)
+	err = cryptoHelper.Machine().ShareKeys(ctx, 50)
+	require.NoError(t, err)
+	return client, cryptoStore
+}
+
+// SyncOnce makes a single sync request with the given client and dispatches the response to the client's syncer.
+func (ms *MockServer) SyncOnce(t testing.TB, ctx context.Context, client *mautrix.Client) {
+	t.Helper()
+	since, err := client.Store.LoadNextBatch(ctx, client.UserID)
+	require.NoError(t, err)
+	resp, err := client.FullSyncRequest(ctx, mautrix.ReqSync{Since: since})
+	require.NoError(t, err)
+	err = client.Syncer.ProcessResponse(ctx, resp, since)
+	require.NoError(t, err)
+	err = client.Store.SaveNextBatch(ctx, client.UserID, resp.NextBatch)
+	require.NoError(t, err)
+}
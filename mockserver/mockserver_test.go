@@ -0,0 +1,100 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mockserver_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mautrix/mockserver"
+)
+
+const (
+	alice   = id.UserID("@alice:localhost")
+	bob     = id.UserID("@bob:localhost")
+	testRID = id.RoomID("!room:localhost")
+)
+
+func TestMockServer_ScriptedSync(t *testing.T) {
+	ctx := context.Background()
+	ms := mockserver.Create(t)
+	client := ms.Login(t, ctx, alice, "ALICEDEVICE")
+
+	ms.QueueSync(alice, "ALICEDEVICE", &mautrix.RespSync{
+		Presence: mautrix.SyncEventsList{Events: []*event.Event{{
+			Type:    event.EphemeralEventPresence,
+			Sender:  bob,
+			Content: event.Content{Parsed: &event.PresenceEventContent{Presence: event.PresenceOnline}},
+		}}},
+	})
+	var gotPresence bool
+	client.Syncer.(mautrix.ExtensibleSyncer).OnEventType(event.EphemeralEventPresence, func(ctx context.Context, evt *event.Event) {
+		gotPresence = evt.Sender == bob
+	})
+	ms.SyncOnce(t, ctx, client)
+	assert.True(t, gotPresence)
+}
+
+func TestMockServer_Messages(t *testing.T) {
+	ctx := context.Background()
+	ms := mockserver.Create(t)
+	aliceClient := ms.Login(t, ctx, alice, "ALICEDEVICE")
+	bobClient := ms.Login(t, ctx, bob, "BOBDEVICE")
+	ms.AddRoomMembers(testRID, alice, bob)
+
+	ms.SyncOnce(t, ctx, bobClient)
+	assert.True(t, bobClient.StateStore.IsInRoom(ctx, testRID, alice))
+
+	resp, err := aliceClient.SendText(ctx, testRID, "hello")
+	require.NoError(t, err)
+	var received *event.Event
+	bobClient.Syncer.(mautrix.ExtensibleSyncer).OnEventType(event.EventMessage, func(ctx context.Context, evt *event.Event) {
+		received = evt
+	})
+	ms.SyncOnce(t, ctx, bobClient)
+	require.NotNil(t, received)
+	assert.Equal(t, resp.EventID, received.ID)
+	assert.Equal(t, "hello", received.Content.AsMessage().Body)
+
+	// Nothing new to sync
+	received = nil
+	ms.SyncOnce(t, ctx, bobClient)
+	assert.Nil(t, received)
+}
+
+func TestMockServer_Encryption(t *testing.T) {
+	ctx := context.Background()
+	ms := mockserver.Create(t)
+	aliceClient, _ := ms.LoginWithCrypto(t, ctx, alice, "ALICEDEVICE")
+	bobClient, _ := ms.LoginWithCrypto(t, ctx, bob, "BOBDEVICE")
+	ms.AddRoomMembers(testRID, alice, bob)
+	ms.AddStateEvent(testRID, alice, event.StateEncryption, "", &event.EncryptionEventContent{
+		Algorithm: id.AlgorithmMegolmV1,
+	})
+	ms.SyncOnce(t, ctx, aliceClient)
+	ms.SyncOnce(t, ctx, bobClient)
+
+	_, err := aliceClient.SendText(ctx, testRID, "secret")
+	require.NoError(t, err)
+	require.Len(t, ms.RoomTimeline[testRID], 4)
+	assert.Equal(t, event.EventEncrypted.Type, ms.RoomTimeline[testRID][3].Type.Type)
+
+	var received *event.Event
+	bobClient.Syncer.(mautrix.ExtensibleSyncer).OnEventType(event.EventMessage, func(ctx context.Context, evt *event.Event) {
+		received = evt
+	})
+	ms.SyncOnce(t, ctx, bobClient)
+	require.NotNil(t, received)
+	assert.Equal(t, "secret", received.Content.AsMessage().Body)
+	assert.NotZero(t, received.Mautrix.EventSource&event.SourceDecrypted)
+}
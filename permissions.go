@@ -0,0 +1,147 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// ErrPermissionStateNotCached is returned by the permission check helpers if the state store doesn't have
+// the state needed for the check, i.e. the power levels of the room or the membership of the user whose
+// permissions are being checked (when the full member list hasn't been fetched either).
+// The caller should fetch the missing state and try again.
+var ErrPermissionStateNotCached = errors.New("room state needed for permission check is not cached")
+
+type permissionState struct {
+	pl       *event.PowerLevelsEventContent
+	creators []id.UserID
+}
+
+// getLevel returns the power level of the user, taking the room version's creator rules into account.
+func (ps *permissionState) getLevel(userID id.UserID) int {
+	if slices.Contains(ps.creators, userID) {
+		return math.MaxInt
+	}
+	return ps.pl.GetUserLevel(userID)
+}
+
+func getPrivilegedCreators(createEvt *event.Event) ([]id.UserID, error) {
+	if createEvt == nil {
+		return nil, nil
+	} else if createEvt.Content.Parsed == nil {
+		if err := createEvt.Content.ParseRaw(event.StateCreate); err != nil {
+			return nil, fmt.Errorf("failed to parse create event: %w", err)
+		}
+	}
+	content := createEvt.Content.AsCreate()
+	if !content.RoomVersion.PrivilegedCreators() {
+		return nil, nil
+	}
+	return append([]id.UserID{createEvt.Sender}, content.AdditionalCreators...), nil
+}
+
+// getActorPermissionState returns the power levels of the room if the user is joined.
+// If the user isn't joined, nil is returned with no error.
+func getActorPermissionState(ctx context.Context, store StateStore, roomID id.RoomID, createEvt *event.Event, userID id.UserID) (*permissionState, error) {
+	member, err := store.TryGetMember(ctx, roomID, userID)
+	if err != nil {
+		return nil, err
+	} else if member == nil {
+		// A missing member is only known to not be in the room if the full member list is cached
+		fetched, err := store.HasFetchedMembers(ctx, roomID)
+		if err != nil {
+			return nil, err
+		} else if !fetched {
+			return nil, ErrPermissionStateNotCached
+		}
+		return nil, nil
+	} else if member.Membership != event.MembershipJoin {
+		return nil, nil
+	}
+	pl, err := store.GetPowerLevels(ctx, roomID)
+	if err != nil {
+		return nil, err
+	} else if pl == nil {
+		return nil, ErrPermissionStateNotCached
+	}
+	creators, err := getPrivilegedCreators(createEvt)
+	if err != nil {
+		return nil, err
+	}
+	return &permissionState{pl: pl, creators: creators}, nil
+}
+
+// CanUserSendEvent checks if the given user is allowed to send an event of the given type in the room
+// according to the cached membership and power levels.
+//
+// The create event of the room is used to apply room version specific rules, like the infinite power level
+// of room creators in room version 12. It may be nil if the room is known to be on an older room version.
+//
+// Membership events are not checked here, use CanUserInvite, CanUserKick or CanUserBan for those.
+func CanUserSendEvent(ctx context.Context, store StateStore, roomID id.RoomID, createEvt *event.Event, userID id.UserID, evtType event.Type) (bool, error) {
+	ps, err := getActorPermissionState(ctx, store, roomID, createEvt, userID)
+	if ps == nil {
+		return false, err
+	}
+	return ps.getLevel(userID) >= ps.pl.GetEventLevel(evtType), nil
+}
+
+// CanUserRedact checks if the given user is allowed to redact an event sent by targetSender.
+//
+// Users can always redact their own events if they're allowed to send redaction events,
+// while redacting other users' events additionally requires the redact power level.
+func CanUserRedact(ctx context.Context, store StateStore, roomID id.RoomID, createEvt *event.Event, userID, targetSender id.UserID) (bool, error) {
+	ps, err := getActorPermissionState(ctx, store, roomID, createEvt, userID)
+	if ps == nil {
+		return false, err
+	}
+	userLevel := ps.getLevel(userID)
+	if userLevel < ps.pl.GetEventLevel(event.EventRedaction) {
+		return false, nil
+	}
+	return userID == targetSender || userLevel >= ps.pl.Redact(), nil
+}
+
+// CanUserInvite checks if the given user is allowed to invite other users to the room.
+func CanUserInvite(ctx context.Context, store StateStore, roomID id.RoomID, createEvt *event.Event, userID id.UserID) (bool, error) {
+	ps, err := getActorPermissionState(ctx, store, roomID, createEvt, userID)
+	if ps == nil {
+		return false, err
+	}
+	return ps.getLevel(userID) >= ps.pl.Invite(), nil
+}
+
+// CanUserKick checks if the given user is allowed to kick the target user from the room.
+// Kicking requires the kick power level and a higher power level than the target,
+// which means that privileged room creators can't be kicked at all.
+func CanUserKick(ctx context.Context, store StateStore, roomID id.RoomID, createEvt *event.Event, userID, target id.UserID) (bool, error) {
+	ps, err := getActorPermissionState(ctx, store, roomID, createEvt, userID)
+	if ps == nil {
+		return false, err
+	}
+	userLevel := ps.getLevel(userID)
+	return userLevel >= ps.pl.Kick() && userLevel > ps.getLevel(target), nil
+}
+
+// CanUserBan checks if the given user is allowed to ban the target user from the room.
+// Banning requires the ban power level and a higher power level than the target,
+// which means that privileged room creators can't be banned at all.
+func CanUserBan(ctx context.Context, store StateStore, roomID id.RoomID, createEvt *event.Event, userID, target id.UserID) (bool, error) {
+	ps, err := getActorPermissionState(ctx, store, roomID, createEvt, userID)
+	if ps == nil {
+		return false, err
+	}
+	userLevel := ps.getLevel(userID)
+	return userLevel >= ps.pl.Ban() && userLevel > ps.getLevel(target), nil
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	permRoom  = id.RoomID("!room:example.org")
+	permAdmin = id.UserID("@admin:example.org")
+	permMod   = id.UserID("@mod:example.org")
+	permUser  = id.UserID("@user:example.org")
+)
+
+func newPermissionTestStore(t *testing.T) mautrix.StateStore {
+	ctx := context.Background()
+	store := mautrix.NewMemoryStateStore()
+	for _, userID := range []id.UserID{permAdmin, permMod, permUser} {
+		require.NoError(t, store.SetMembership(ctx, permRoom, userID, event.MembershipJoin))
+	}
+	require.NoError(t, store.SetPowerLevels(ctx, permRoom, &event.PowerLevelsEventContent{
+		Users: map[id.UserID]int{permAdmin: 100, permMod: 50},
+	}))
+	require.NoError(t, store.MarkMembersFetched(ctx, permRoom))
+	return store
+}
+
+func TestCanUserSendEvent(t *testing.T) {
+	ctx := context.Background()
+	store := newPermissionTestStore(t)
+	ok, err := mautrix.CanUserSendEvent(ctx, store, permRoom, nil, permUser, event.EventMessage)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = mautrix.CanUserSendEvent(ctx, store, permRoom, nil, permUser, event.StateRoomName)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = mautrix.CanUserSendEvent(ctx, store, permRoom, nil, permMod, event.StateRoomName)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = mautrix.CanUserSendEvent(ctx, store, permRoom, nil, "@stranger:example.org", event.EventMessage)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestCanUserSendEvent_NoPowerLevels(t *testing.T) {
+	ctx := context.Background()
+	store := mautrix.NewMemoryStateStore()
+	require.NoError(t, store.SetMembership(ctx, permRoom, permUser, event.MembershipJoin))
+	_, err := mautrix.CanUserSendEvent(ctx, store, permRoom, nil, permUser, event.EventMessage)
+	assert.ErrorIs(t, err, mautrix.ErrPermissionStateNotCached)
+}
+
+func TestCanUserSendEvent_MembershipNotCached(t *testing.T) {
+	ctx := context.Background()
+	store := mautrix.NewMemoryStateStore()
+	require.NoError(t, store.SetPowerLevels(ctx, permRoom, &event.PowerLevelsEventContent{}))
+	// Unknown members can't be assumed to have left unless the full member list is cached
+	_, err := mautrix.CanUserSendEvent(ctx, store, permRoom, nil, permUser, event.EventMessage)
+	assert.ErrorIs(t, err, mautrix.ErrPermissionStateNotCached)
+	require.NoError(t, store.SetMembership(ctx, permRoom, permUser, event.MembershipLeave))
+	ok, err := mautrix.CanUserSendEvent(ctx, store, permRoom, nil, permUser, event.EventMessage)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestPermissions_PrivilegedCreators(t *testing.T) {
+	ctx := context.Background()
+	store := newPermissionTestStore(t)
+	createEvt := func(version event.RoomVersion) *event.Event {
+		return &event.Event{
+			Type:   event.StateCreate,
+			Sender: permUser,
+			Content: event.Content{Parsed: &event.CreateEventContent{
+				RoomVersion:        version,
+				AdditionalCreators: []id.UserID{permMod},
+			}},
+		}
+	}
+	v11, v12 := createEvt(event.RoomV11), createEvt(event.RoomV12)
+
+	ok, err := mautrix.CanUserSendEvent(ctx, store, permRoom, v11, permUser, event.StatePowerLevels)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = mautrix.CanUserSendEvent(ctx, store, permRoom, v12, permUser, event.StatePowerLevels)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// Creators can't be kicked or banned in room version 12, not even by other creators
+	ok, _ = mautrix.CanUserKick(ctx, store, permRoom, v11, permAdmin, permMod)
+	assert.True(t, ok)
+	ok, _ = mautrix.CanUserKick(ctx, store, permRoom, v12, permAdmin, permMod)
+	assert.False(t, ok)
+	ok, _ = mautrix.CanUserBan(ctx, store, permRoom, v12, permUser, permMod)
+	assert.False(t, ok)
+	ok, _ = mautrix.CanUserBan(ctx, store, permRoom, v12, permMod, permAdmin)
+	assert.True(t, ok)
+}
+
+func TestCanUserRedact(t *testing.T) {
+	ctx := context.Background()
+	store := newPermissionTestStore(t)
+	ok, _ := mautrix.CanUserRedact(ctx, store, permRoom, nil, permUser, permUser)
+	assert.True(t, ok)
+	ok, _ = mautrix.CanUserRedact(ctx, store, permRoom, nil, permUser, permMod)
+	assert.False(t, ok)
+	ok, _ = mautrix.CanUserRedact(ctx, store, permRoom, nil, permMod, permUser)
+	assert.True(t, ok)
+}
+
+func TestCanUserKickAndBan(t *testing.T) {
+	ctx := context.Background()
+	store := newPermissionTestStore(t)
+	ok, _ := mautrix.CanUserKick(ctx, store, permRoom, nil, permMod, permUser)
+	assert.True(t, ok)
+	ok, _ = mautrix.CanUserKick(ctx, store, permRoom, nil, permMod, permAdmin)
+	assert.False(t, ok)
+	ok, _ = mautrix.CanUserBan(ctx, store, permRoom, nil, permUser, permMod)
+	assert.False(t, ok)
+	ok, _ = mautrix.CanUserBan(ctx, store, permRoom, nil, permAdmin, permMod)
+	assert.True(t, ok)
+	ok, _ = mautrix.CanUserInvite(ctx, store, permRoom, nil, permUser)
+	assert.True(t, ok)
+}
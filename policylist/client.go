@@ -0,0 +1,70 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package policylist
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// StateKeyForEntity returns the state key that is used for rules created by this package.
+//
+// The state key is the hash of the entity, which means there can only be one rule per entity
+// and changing the recommendation replaces the previous rule.
+func StateKeyForEntity(entity string) string {
+	hash := sha256.Sum256([]byte(entity))
+	return base64.RawStdEncoding.EncodeToString(hash[:])
+}
+
+// SetRule creates or replaces a rule in a policy room.
+func SetRule(ctx context.Context, cli *mautrix.Client, policyRoom id.RoomID, entityType EntityType, content *event.ModPolicyContent) (*mautrix.RespSendEvent, error) {
+	evtType := entityType.EventType()
+	if evtType.Type == "" {
+		return nil, fmt.Errorf("unknown entity type %q", entityType)
+	}
+	return cli.SendStateEvent(ctx, policyRoom, evtType, StateKeyForEntity(content.Entity), content)
+}
+
+// Ban creates a rule in a policy room recommending that the given entity (which may be a glob) is banned.
+func Ban(ctx context.Context, cli *mautrix.Client, policyRoom id.RoomID, entityType EntityType, entity, reason string) (*mautrix.RespSendEvent, error) {
+	return SetRule(ctx, cli, policyRoom, entityType, &event.ModPolicyContent{
+		Entity:         entity,
+		Reason:         reason,
+		Recommendation: event.PolicyRecommendationBan,
+	})
+}
+
+// RemoveRule removes a rule created with SetRule or Ban from a policy room.
+func RemoveRule(ctx context.Context, cli *mautrix.Client, policyRoom id.RoomID, entityType EntityType, entity string) (*mautrix.RespSendEvent, error) {
+	evtType := entityType.EventType()
+	if evtType.Type == "" {
+		return nil, fmt.Errorf("unknown entity type %q", entityType)
+	}
+	return cli.SendStateEvent(ctx, policyRoom, evtType, StateKeyForEntity(entity), struct{}{})
+}
+
+// Subscribe joins the given policy room and loads its current rules into the store.
+//
+// To keep the rules up to date afterwards, the store must also receive the policy events from /sync (see Store.Register).
+func (s *Store) Subscribe(ctx context.Context, cli *mautrix.Client, roomIDOrAlias string, via ...string) (id.RoomID, error) {
+	resp, err := cli.JoinRoom(ctx, roomIDOrAlias, &mautrix.ReqJoinRoom{Via: via})
+	if err != nil {
+		return "", fmt.Errorf("failed to join policy room: %w", err)
+	}
+	state, err := cli.State(ctx, resp.RoomID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get policy room state: %w", err)
+	}
+	s.Add(resp.RoomID, state)
+	return resp.RoomID, nil
+}
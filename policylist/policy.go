@@ -0,0 +1,114 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package policylist implements helpers for moderation policy lists.
+//
+// See https://spec.matrix.org/v1.13/client-server-api/#moderation-policy-lists for more info.
+package policylist
+
+import (
+	"time"
+
+	"go.mau.fi/util/glob"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// EntityType is the type of entity that a policy rule applies to.
+type EntityType string
+
+const (
+	EntityTypeUser   EntityType = "user"
+	EntityTypeRoom   EntityType = "room"
+	EntityTypeServer EntityType = "server"
+)
+
+// EventType returns the stable state event type used for rules of this entity type.
+func (et EntityType) EventType() event.Type {
+	switch et {
+	case EntityTypeUser:
+		return event.StatePolicyUser
+	case EntityTypeRoom:
+		return event.StatePolicyRoom
+	case EntityTypeServer:
+		return event.StatePolicyServer
+	default:
+		return event.Type{}
+	}
+}
+
+// EntityTypeFromEventType returns the entity type for the given policy event type.
+// Legacy (m.room.rule.*) and unstable (org.matrix.mjolnir.rule.*) event types are also supported.
+func EntityTypeFromEventType(evtType event.Type) (EntityType, bool) {
+	switch evtType.Type {
+	case event.StatePolicyUser.Type, event.StateLegacyPolicyUser.Type, event.StateUnstablePolicyUser.Type:
+		return EntityTypeUser, true
+	case event.StatePolicyRoom.Type, event.StateLegacyPolicyRoom.Type, event.StateUnstablePolicyRoom.Type:
+		return EntityTypeRoom, true
+	case event.StatePolicyServer.Type, event.StateLegacyPolicyServer.Type, event.StateUnstablePolicyServer.Type:
+		return EntityTypeServer, true
+	default:
+		return "", false
+	}
+}
+
+// EventTypes contains all the stable, legacy and unstable policy rule event types.
+var EventTypes = []event.Type{
+	event.StatePolicyUser, event.StatePolicyRoom, event.StatePolicyServer,
+	event.StateLegacyPolicyUser, event.StateLegacyPolicyRoom, event.StateLegacyPolicyServer,
+	event.StateUnstablePolicyUser, event.StateUnstablePolicyRoom, event.StateUnstablePolicyServer,
+}
+
+// Policy is a single rule in a policy list.
+type Policy struct {
+	*event.ModPolicyContent
+	Pattern glob.Glob
+
+	EntityType EntityType
+	RoomID     id.RoomID
+	EventType  event.Type
+	StateKey   string
+	Sender     id.UserID
+	EventID    id.EventID
+	Timestamp  time.Time
+}
+
+// PolicyFromEvent parses a policy rule state event. It returns nil if the event isn't a policy rule
+// or if the rule has been removed (i.e. the content doesn't have an entity).
+func PolicyFromEvent(evt *event.Event) *Policy {
+	entityType, ok := EntityTypeFromEventType(evt.Type)
+	if !ok || evt.StateKey == nil {
+		return nil
+	}
+	_ = evt.Content.ParseRaw(evt.Type)
+	content, ok := evt.Content.Parsed.(*event.ModPolicyContent)
+	if !ok || content.Entity == "" || content.Recommendation == "" {
+		return nil
+	}
+	return &Policy{
+		ModPolicyContent: content,
+		Pattern:          glob.Compile(content.Entity),
+
+		EntityType: entityType,
+		RoomID:     evt.RoomID,
+		EventType:  evt.Type,
+		StateKey:   *evt.StateKey,
+		Sender:     evt.Sender,
+		EventID:    evt.ID,
+		Timestamp:  time.UnixMilli(evt.Timestamp),
+	}
+}
+
+// IsBan returns true if the policy recommends banning the entity.
+func (p *Policy) IsBan() bool {
+	return p.Recommendation == event.PolicyRecommendationBan || p.Recommendation == event.PolicyRecommendationUnstableBan
+}
+
+// Match checks if the given entity matches the policy's glob pattern.
+func (p *Policy) Match(entity string) bool {
+	return p.Pattern.Match(entity)
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package policylist
+
+import (
+	"context"
+	"sync"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type policyKey struct {
+	EventType string
+	StateKey  string
+}
+
+type room struct {
+	policies map[EntityType]map[policyKey]*Policy
+}
+
+func newRoom() *room {
+	return &room{policies: map[EntityType]map[policyKey]*Policy{
+		EntityTypeUser:   {},
+		EntityTypeRoom:   {},
+		EntityTypeServer: {},
+	}}
+}
+
+// Store keeps track of the policies in a set of subscribed policy rooms.
+type Store struct {
+	rooms map[id.RoomID]*room
+	lock  sync.RWMutex
+}
+
+// NewStore creates a new empty policy store.
+func NewStore() *Store {
+	return &Store{rooms: make(map[id.RoomID]*room)}
+}
+
+// Add starts tracking the given policy room, replacing any existing policies for it with the policies in the given state.
+func (s *Store) Add(roomID id.RoomID, state mautrix.RoomStateMap) {
+	r := newRoom()
+	for _, evtType := range EventTypes {
+		for _, evt := range state[evtType] {
+			evt.RoomID = roomID
+			if policy := PolicyFromEvent(evt); policy != nil {
+				r.policies[policy.EntityType][policyKey{policy.EventType.Type, policy.StateKey}] = policy
+			}
+		}
+	}
+	s.lock.Lock()
+	s.rooms[roomID] = r
+	s.lock.Unlock()
+}
+
+// Remove stops tracking the given policy room.
+func (s *Store) Remove(roomID id.RoomID) {
+	s.lock.Lock()
+	delete(s.rooms, roomID)
+	s.lock.Unlock()
+}
+
+// Rooms returns the IDs of all tracked policy rooms.
+func (s *Store) Rooms() []id.RoomID {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	rooms := make([]id.RoomID, 0, len(s.rooms))
+	for roomID := range s.rooms {
+		rooms = append(rooms, roomID)
+	}
+	return rooms
+}
+
+// Update applies a policy state event to the store. Events in rooms that aren't tracked are ignored.
+//
+// The returned values are the new policy (nil if the policy was removed) and the policy that was replaced (if any).
+func (s *Store) Update(evt *event.Event) (added, removed *Policy) {
+	entityType, ok := EntityTypeFromEventType(evt.Type)
+	if !ok || evt.StateKey == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r, ok := s.rooms[evt.RoomID]
+	if !ok {
+		return
+	}
+	key := policyKey{evt.Type.Type, *evt.StateKey}
+	removed = r.policies[entityType][key]
+	added = PolicyFromEvent(evt)
+	if added != nil {
+		r.policies[entityType][key] = added
+	} else {
+		delete(r.policies[entityType], key)
+	}
+	return
+}
+
+// HandleEvent is an event handler that updates the store with policy events from /sync.
+//
+// Use Register to register it for all policy event types.
+func (s *Store) HandleEvent(_ context.Context, evt *event.Event) {
+	s.Update(evt)
+}
+
+// Register registers HandleEvent for all policy event types in the given syncer.
+func (s *Store) Register(syncer mautrix.ExtensibleSyncer) {
+	for _, evtType := range EventTypes {
+		syncer.OnEventType(evtType, s.HandleEvent)
+	}
+}
+
+func (s *Store) match(entityType EntityType, entity string, onlyFirstBan bool) (output []*Policy) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, r := range s.rooms {
+		for _, policy := range r.policies[entityType] {
+			if policy.Match(entity) {
+				if onlyFirstBan {
+					if policy.IsBan() {
+						return []*Policy{policy}
+					}
+					continue
+				}
+				output = append(output, policy)
+			}
+		}
+	}
+	return
+}
+
+// MatchUser returns all user policies whose entity glob matches the given user ID.
+func (s *Store) MatchUser(userID id.UserID) []*Policy {
+	return s.match(EntityTypeUser, string(userID), false)
+}
+
+// MatchRoom returns all room policies whose entity glob matches the given room ID.
+func (s *Store) MatchRoom(roomID id.RoomID) []*Policy {
+	return s.match(EntityTypeRoom, string(roomID), false)
+}
+
+// MatchServer returns all server policies whose entity glob matches the given server name.
+func (s *Store) MatchServer(serverName string) []*Policy {
+	return s.match(EntityTypeServer, serverName, false)
+}
+
+// IsUserBanned checks if there's a ban policy for the given user ID or the server of the user.
+// The returned policy is one of the matching bans, or nil if the user isn't banned.
+func (s *Store) IsUserBanned(userID id.UserID) *Policy {
+	if bans := s.match(EntityTypeUser, string(userID), true); len(bans) > 0 {
+		return bans[0]
+	}
+	return s.IsServerBanned(userID.Homeserver())
+}
+
+// IsServerBanned checks if there's a ban policy for the given server name.
+func (s *Store) IsServerBanned(serverName string) *Policy {
+	if bans := s.match(EntityTypeServer, serverName, true); len(bans) > 0 {
+		return bans[0]
+	}
+	return nil
+}
+
+// IsRoomBanned checks if there's a ban policy for the given room ID.
+func (s *Store) IsRoomBanned(roomID id.RoomID) *Policy {
+	if bans := s.match(EntityTypeRoom, string(roomID), true); len(bans) > 0 {
+		return bans[0]
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package policylist_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/exerrors"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mautrix/policylist"
+)
+
+const policyRoom = id.RoomID("!policies:example.com")
+
+func makePolicyEvent(evtType event.Type, entity string, recommendation event.PolicyRecommendation) *event.Event {
+	stateKey := policylist.StateKeyForEntity(entity)
+	content := map[string]any{}
+	if entity != "" {
+		content = map[string]any{"entity": entity, "recommendation": string(recommendation), "reason": "spam"}
+	}
+	return &event.Event{
+		Type:     evtType,
+		RoomID:   policyRoom,
+		StateKey: &stateKey,
+		Sender:   "@mod:example.com",
+		Content:  event.Content{VeryRaw: exerrors.Must(json.Marshal(content))},
+	}
+}
+
+func TestStore_Match(t *testing.T) {
+	store := policylist.NewStore()
+	userBan := makePolicyEvent(event.StatePolicyUser, "@spam*:example.com", event.PolicyRecommendationBan)
+	store.Add(policyRoom, mautrix.RoomStateMap{
+		event.StatePolicyUser: {*userBan.StateKey: userBan},
+		event.StateUnstablePolicyServer: {
+			"evil": makePolicyEvent(event.StateUnstablePolicyServer, "*.evil.example", event.PolicyRecommendationUnstableBan),
+		},
+	})
+
+	assert.NotNil(t, store.IsUserBanned("@spammer:example.com"))
+	assert.Nil(t, store.IsUserBanned("@user:example.com"))
+	assert.NotNil(t, store.IsUserBanned("@user:matrix.evil.example"))
+	assert.NotNil(t, store.IsServerBanned("matrix.evil.example"))
+	assert.Nil(t, store.IsRoomBanned("!room:example.com"))
+	require.Len(t, store.MatchUser("@spam:example.com"), 1)
+
+	added, removed := store.Update(makePolicyEvent(event.StatePolicyUser, "", ""))
+	assert.Nil(t, added)
+	assert.Nil(t, removed)
+	removeEvt := makePolicyEvent(event.StatePolicyUser, "", "")
+	removeEvt.StateKey = userBan.StateKey
+	added, removed = store.Update(removeEvt)
+	assert.Nil(t, added)
+	require.NotNil(t, removed)
+	assert.Equal(t, "@spam*:example.com", removed.Entity)
+	assert.Nil(t, store.IsUserBanned("@spammer:example.com"))
+}
@@ -6,7 +6,9 @@
 
 package pushrules
 
-import "encoding/json"
+import (
+	"encoding/json"
+)
 
 // PushActionType is the type of a PushAction
 type PushActionType string
@@ -45,6 +47,10 @@ type PushActionArrayShould struct {
 	PlaySound bool
 	// The name of the sound to play if PlaySound is true.
 	SoundName string
+
+	// Any other tweaks set in the array, e.g. custom tweaks defined by push gateways.
+	// The well-known tweaks (highlight and sound) are not included here.
+	Tweaks map[PushActionTweak]any
 }
 
 // Should parses this push action array and returns the relevant details wrapped in a PushActionArrayShould struct.
@@ -67,8 +73,14 @@ func (actions PushActionArray) Should() (should PushActionArrayShould) {
 					should.Highlight = true
 				}
 			case TweakSound:
-				should.SoundName = action.Value.(string)
+				// Sound values are required to be strings, so treat other types the same as no sound.
+				should.SoundName, _ = action.Value.(string)
 				should.PlaySound = len(should.SoundName) > 0
+			default:
+				if should.Tweaks == nil {
+					should.Tweaks = make(map[PushActionTweak]any)
+				}
+				should.Tweaks[action.Tweak] = action.Value
 			}
 		}
 	}
@@ -80,6 +92,18 @@ type PushAction struct {
 	Action PushActionType
 	Tweak  PushActionTweak
 	Value  interface{}
+
+	// raw contains the original JSON of actions that couldn't be parsed (i.e. objects without set_tweak),
+	// so that they can be serialized back as-is. It's stored as a string to keep PushAction comparable.
+	raw string
+}
+
+// Raw returns the original JSON of actions that couldn't be parsed, or nil for known actions.
+func (action *PushAction) Raw() json.RawMessage {
+	if action.raw == "" {
+		return nil
+	}
+	return json.RawMessage(action.raw)
 }
 
 // UnmarshalJSON parses JSON into this PushAction.
@@ -88,7 +112,7 @@ type PushAction struct {
 //   - If the JSON is an object with the set_tweak field, Action will be set to
 //     "set_tweak", Tweak will be set to the value of the set_tweak field and
 //     and Value will be set to the value of the value field.
-//   - In any other case, the function does nothing.
+//   - In any other case, the raw JSON is stored and can be accessed with the Raw method.
 func (action *PushAction) UnmarshalJSON(raw []byte) error {
 	var data interface{}
 
@@ -106,14 +130,20 @@ func (action *PushAction) UnmarshalJSON(raw []byte) error {
 			action.Action = ActionSetTweak
 			action.Tweak = PushActionTweak(tweak)
 			action.Value, _ = val["value"]
+		} else {
+			action.raw = string(raw)
 		}
+	default:
+		action.raw = string(raw)
 	}
 	return nil
 }
 
 // MarshalJSON is the reverse of UnmarshalJSON()
 func (action *PushAction) MarshalJSON() (raw []byte, err error) {
-	if action.Action == ActionSetTweak {
+	if action.Action == "" && action.raw != "" {
+		return json.RawMessage(action.raw), nil
+	} else if action.Action == ActionSetTweak {
 		data := map[string]interface{}{
 			"set_tweak": action.Tweak,
 			"value":     action.Value,
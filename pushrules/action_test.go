@@ -7,9 +7,11 @@
 package pushrules_test
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"maunium.net/go/mautrix/pushrules"
 )
@@ -199,3 +201,33 @@ func TestPushAction_MarshalJSON_OtherOutputWorks(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, []byte(`"something else"`), data)
 }
+
+func TestPushActionArray_Should_InvalidSoundType(t *testing.T) {
+	should := pushrules.PushActionArray{
+		{Action: pushrules.ActionNotify},
+		{Action: pushrules.ActionSetTweak, Tweak: pushrules.TweakSound, Value: 123.0},
+	}.Should()
+	assert.True(t, should.Notify)
+	assert.False(t, should.PlaySound)
+	assert.Empty(t, should.SoundName)
+}
+
+func TestPushActionArray_Should_CustomTweaks(t *testing.T) {
+	should := pushrules.PushActionArray{
+		{Action: pushrules.ActionNotify},
+		{Action: pushrules.ActionSetTweak, Tweak: "com.example.vibrate", Value: true},
+	}.Should()
+	assert.Equal(t, map[pushrules.PushActionTweak]any{"com.example.vibrate": true}, should.Tweaks)
+}
+
+func TestPushAction_UnknownObjectRoundtrip(t *testing.T) {
+	var pa, pa2 pushrules.PushAction
+	require.NoError(t, json.Unmarshal([]byte(`{"com.example.action":true}`), &pa))
+	require.NoError(t, json.Unmarshal([]byte(`{"com.example.action":true}`), &pa2))
+	assert.Equal(t, json.RawMessage(`{"com.example.action":true}`), pa.Raw())
+	assert.True(t, pa == pa2)
+	data, err := pa.MarshalJSON()
+	require.NoError(t, err)
+	assert.Equal(t, `{"com.example.action":true}`, string(data))
+	assert.Nil(t, (&pushrules.PushAction{Action: pushrules.ActionNotify}).Raw())
+}
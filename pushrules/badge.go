@@ -0,0 +1,252 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pushrules
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// UnreadCounts contains the number of unread notifying and highlighting events, like the
+// unread_notifications object in the /sync response.
+type UnreadCounts struct {
+	NotificationCount int `json:"notification_count"`
+	HighlightCount    int `json:"highlight_count"`
+}
+
+func (uc UnreadCounts) Add(other UnreadCounts) UnreadCounts {
+	return UnreadCounts{
+		NotificationCount: uc.NotificationCount + other.NotificationCount,
+		HighlightCount:    uc.HighlightCount + other.HighlightCount,
+	}
+}
+
+// CountedEvent is an event that has been evaluated by a BadgeCounter.
+type CountedEvent struct {
+	RoomID    id.RoomID
+	EventID   id.EventID
+	Notify    bool
+	Highlight bool
+}
+
+// BadgeStore is the storage used by BadgeCounter.
+//
+// Implementations must remember the order in which events were added to each room,
+// so that MarkRead can clear all events up to and including the given event.
+type BadgeStore interface {
+	// AddEvent stores an event after the existing ones in the room.
+	AddEvent(ctx context.Context, evt CountedEvent) error
+	// MarkRead clears all events in the room up to and including the given event ID.
+	// If the event ID is not known, nothing should be changed.
+	MarkRead(ctx context.Context, roomID id.RoomID, eventID id.EventID) error
+	// GetCounts returns the number of unread notifying and highlighting events in the room.
+	GetCounts(ctx context.Context, roomID id.RoomID) (UnreadCounts, error)
+	// GetTotalCounts returns the sum of unread counts in all rooms.
+	GetTotalCounts(ctx context.Context) (UnreadCounts, error)
+}
+
+// BadgeCounter maintains per-room and global unread counts by evaluating push rules for incoming events
+// and clearing the counts based on the user's own read receipts.
+type BadgeCounter struct {
+	UserID id.UserID
+	Rules  PushRuleCollection
+	Store  BadgeStore
+}
+
+// NewBadgeCounter creates a new BadgeCounter with an in-memory store.
+func NewBadgeCounter(userID id.UserID, rules PushRuleCollection) *BadgeCounter {
+	return &BadgeCounter{
+		UserID: userID,
+		Rules:  rules,
+		Store:  NewMemoryBadgeStore(),
+	}
+}
+
+// HandleEvent evaluates push rules for the given timeline event and updates the counts of the room.
+//
+// Events sent by the user themselves are treated as implicit read receipts.
+func (bc *BadgeCounter) HandleEvent(ctx context.Context, room Room, evt *event.Event) (UnreadCounts, error) {
+	counted := CountedEvent{RoomID: evt.RoomID, EventID: evt.ID}
+	if evt.Sender != bc.UserID {
+		should := bc.Rules.GetActions(room, evt).Should()
+		counted.Notify = should.Notify
+		counted.Highlight = should.Notify && should.Highlight
+	}
+	err := bc.Store.AddEvent(ctx, counted)
+	if err != nil {
+		return UnreadCounts{}, fmt.Errorf("failed to store event: %w", err)
+	}
+	if evt.Sender == bc.UserID {
+		err = bc.Store.MarkRead(ctx, evt.RoomID, evt.ID)
+		if err != nil {
+			return UnreadCounts{}, fmt.Errorf("failed to mark own event as read: %w", err)
+		}
+	}
+	return bc.Store.GetCounts(ctx, evt.RoomID)
+}
+
+// HandleReceipts clears counts based on the user's own read receipts in the given m.receipt event.
+//
+// Both public and private read receipts are used. Threaded receipts are ignored,
+// as the counts are not tracked per thread.
+func (bc *BadgeCounter) HandleReceipts(ctx context.Context, evt *event.Event) error {
+	content, ok := evt.Content.Parsed.(*event.ReceiptEventContent)
+	if !ok {
+		return fmt.Errorf("unexpected content type %T", evt.Content.Parsed)
+	}
+	for eventID, receipts := range *content {
+		for _, receiptType := range []event.ReceiptType{event.ReceiptTypeRead, event.ReceiptTypeReadPrivate} {
+			receipt, ok := receipts[receiptType][bc.UserID]
+			if !ok || (receipt.ThreadID != "" && receipt.ThreadID != event.ReadReceiptThreadMain) {
+				continue
+			}
+			err := bc.Store.MarkRead(ctx, evt.RoomID, eventID)
+			if err != nil {
+				return fmt.Errorf("failed to mark %s as read: %w", eventID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// GetRoomCounts returns the unread counts of a single room.
+func (bc *BadgeCounter) GetRoomCounts(ctx context.Context, roomID id.RoomID) (UnreadCounts, error) {
+	return bc.Store.GetCounts(ctx, roomID)
+}
+
+// GetTotalCounts returns the unread counts summed over all rooms.
+func (bc *BadgeCounter) GetTotalCounts(ctx context.Context) (UnreadCounts, error) {
+	return bc.Store.GetTotalCounts(ctx)
+}
+
+type memoryBadgeRoom struct {
+	events  []CountedEvent
+	indexes map[id.EventID]int
+	// Counts of events that were dropped because the room had too many unread events.
+	// The dropped events are always older than the stored ones, so these are cleared by any MarkRead call.
+	dropped UnreadCounts
+	counts  UnreadCounts
+}
+
+func (room *memoryBadgeRoom) recount() {
+	room.indexes = make(map[id.EventID]int, len(room.events))
+	room.counts = room.dropped
+	for i, evt := range room.events {
+		room.indexes[evt.EventID] = i
+		if evt.Notify {
+			room.counts.NotificationCount++
+		}
+		if evt.Highlight {
+			room.counts.HighlightCount++
+		}
+	}
+}
+
+// DefaultMaxBadgeEventsPerRoom is the default value for MemoryBadgeStore.MaxEventsPerRoom.
+const DefaultMaxBadgeEventsPerRoom = 1000
+
+// MemoryBadgeStore is a BadgeStore that keeps everything in memory.
+//
+// Only events after the latest read receipt are stored, and at most MaxEventsPerRoom events are kept
+// per room. When a room has more unread events, the oldest ones are dropped, but they're still included
+// in the counts until a read receipt is received for any of the newer events.
+type MemoryBadgeStore struct {
+	MaxEventsPerRoom int
+
+	rooms map[id.RoomID]*memoryBadgeRoom
+	lock  sync.RWMutex
+}
+
+var _ BadgeStore = (*MemoryBadgeStore)(nil)
+
+func NewMemoryBadgeStore() *MemoryBadgeStore {
+	return &MemoryBadgeStore{
+		MaxEventsPerRoom: DefaultMaxBadgeEventsPerRoom,
+		rooms:            make(map[id.RoomID]*memoryBadgeRoom),
+	}
+}
+
+func (mbs *MemoryBadgeStore) AddEvent(_ context.Context, evt CountedEvent) error {
+	mbs.lock.Lock()
+	defer mbs.lock.Unlock()
+	room, ok := mbs.rooms[evt.RoomID]
+	if !ok {
+		room = &memoryBadgeRoom{indexes: make(map[id.EventID]int)}
+		mbs.rooms[evt.RoomID] = room
+	}
+	if _, alreadyAdded := room.indexes[evt.EventID]; alreadyAdded {
+		return nil
+	}
+	room.indexes[evt.EventID] = len(room.events)
+	room.events = append(room.events, evt)
+	if evt.Notify {
+		room.counts.NotificationCount++
+	}
+	if evt.Highlight {
+		room.counts.HighlightCount++
+	}
+	if mbs.MaxEventsPerRoom > 0 && len(room.events) > mbs.MaxEventsPerRoom {
+		overflow := len(room.events) - mbs.MaxEventsPerRoom
+		for _, droppedEvt := range room.events[:overflow] {
+			if droppedEvt.Notify {
+				room.dropped.NotificationCount++
+			}
+			if droppedEvt.Highlight {
+				room.dropped.HighlightCount++
+			}
+		}
+		room.events = slices.Clone(room.events[overflow:])
+		room.recount()
+	}
+	return nil
+}
+
+func (mbs *MemoryBadgeStore) MarkRead(_ context.Context, roomID id.RoomID, eventID id.EventID) error {
+	mbs.lock.Lock()
+	defer mbs.lock.Unlock()
+	room, ok := mbs.rooms[roomID]
+	if !ok {
+		return nil
+	}
+	index, ok := room.indexes[eventID]
+	if !ok {
+		return nil
+	}
+	remaining := room.events[index+1:]
+	if len(remaining) == 0 {
+		delete(mbs.rooms, roomID)
+		return nil
+	}
+	room.events = slices.Clone(remaining)
+	room.dropped = UnreadCounts{}
+	room.recount()
+	return nil
+}
+
+func (mbs *MemoryBadgeStore) GetCounts(_ context.Context, roomID id.RoomID) (UnreadCounts, error) {
+	mbs.lock.RLock()
+	defer mbs.lock.RUnlock()
+	room, ok := mbs.rooms[roomID]
+	if !ok {
+		return UnreadCounts{}, nil
+	}
+	return room.counts, nil
+}
+
+func (mbs *MemoryBadgeStore) GetTotalCounts(_ context.Context) (total UnreadCounts, err error) {
+	mbs.lock.RLock()
+	defer mbs.lock.RUnlock()
+	for _, room := range mbs.rooms {
+		total = total.Add(room.counts)
+	}
+	return
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pushrules_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mautrix/pushrules"
+)
+
+func TestBadgeCounter(t *testing.T) {
+	ctx := context.Background()
+	const ownUserID = id.UserID("@tulir:maunium.net")
+	bc := pushrules.NewBadgeCounter(ownUserID, pushrules.DefaultRuleset(ownUserID).Compile())
+	room := newFakeRoom(4)
+	makeEvent := func(eventID id.EventID, sender id.UserID, content *event.MessageEventContent) *event.Event {
+		evt := newFakeEvent(event.EventMessage, content)
+		evt.ID = eventID
+		evt.Sender = sender
+		return evt
+	}
+	handle := func(evt *event.Event) pushrules.UnreadCounts {
+		counts, err := bc.HandleEvent(ctx, room, evt)
+		require.NoError(t, err)
+		return counts
+	}
+
+	other := id.UserID("@extrauser_0:matrix.org")
+	assert.Equal(t, pushrules.UnreadCounts{NotificationCount: 1}, handle(makeEvent("$1", other, &event.MessageEventContent{MsgType: event.MsgText, Body: "hi"})))
+	assert.Equal(t, pushrules.UnreadCounts{NotificationCount: 1}, handle(makeEvent("$2", other, &event.MessageEventContent{MsgType: event.MsgNotice, Body: "beep"})))
+	assert.Equal(t, pushrules.UnreadCounts{NotificationCount: 2, HighlightCount: 1}, handle(makeEvent("$3", other, &event.MessageEventContent{
+		MsgType:  event.MsgText,
+		Body:     "hey",
+		Mentions: &event.Mentions{UserIDs: []id.UserID{ownUserID}},
+	})))
+
+	receipts := event.ReceiptEventContent{}
+	receipts.Set("$2", event.ReceiptTypeRead, ownUserID, event.ReadReceipt{})
+	receipts.Set("$3", event.ReceiptTypeRead, other, event.ReadReceipt{})
+	require.NoError(t, bc.HandleReceipts(ctx, &event.Event{RoomID: "!fakeroom:maunium.net", Content: event.Content{Parsed: &receipts}}))
+	counts, err := bc.GetTotalCounts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pushrules.UnreadCounts{NotificationCount: 1, HighlightCount: 1}, counts)
+
+	assert.Equal(t, pushrules.UnreadCounts{}, handle(makeEvent("$4", ownUserID, &event.MessageEventContent{MsgType: event.MsgText, Body: "ok"})))
+	counts, err = bc.GetTotalCounts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pushrules.UnreadCounts{}, counts)
+}
+
+func TestMemoryBadgeStore_MaxEventsPerRoom(t *testing.T) {
+	ctx := context.Background()
+	const roomID = id.RoomID("!fakeroom:maunium.net")
+	store := pushrules.NewMemoryBadgeStore()
+	store.MaxEventsPerRoom = 2
+	for _, eventID := range []id.EventID{"$1", "$2", "$3", "$4"} {
+		require.NoError(t, store.AddEvent(ctx, pushrules.CountedEvent{RoomID: roomID, EventID: eventID, Notify: true}))
+	}
+	// Dropped events are still counted
+	counts, err := store.GetCounts(ctx, roomID)
+	require.NoError(t, err)
+	assert.Equal(t, pushrules.UnreadCounts{NotificationCount: 4}, counts)
+
+	// Receipts for dropped events are ignored
+	require.NoError(t, store.MarkRead(ctx, roomID, "$1"))
+	counts, err = store.GetCounts(ctx, roomID)
+	require.NoError(t, err)
+	assert.Equal(t, pushrules.UnreadCounts{NotificationCount: 4}, counts)
+
+	// Receipts for stored events clear the dropped events too
+	require.NoError(t, store.MarkRead(ctx, roomID, "$3"))
+	counts, err = store.GetCounts(ctx, roomID)
+	require.NoError(t, err)
+	assert.Equal(t, pushrules.UnreadCounts{NotificationCount: 1}, counts)
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pushrules
+
+import (
+	"go.mau.fi/util/glob"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type compiledRule struct {
+	*PushRule
+	conditions []*compiledCondition
+	pattern    glob.Glob
+}
+
+func (rule *PushRule) compile() *compiledRule {
+	cr := &compiledRule{PushRule: rule}
+	switch rule.Type {
+	case OverrideRule, UnderrideRule:
+		cr.conditions = make([]*compiledCondition, len(rule.Conditions))
+		for i, cond := range rule.Conditions {
+			cr.conditions[i] = cond.compile()
+		}
+	case ContentRule:
+		cr.pattern = glob.CompileWithImplicitContains(rule.Pattern)
+	}
+	return cr
+}
+
+func (cr *compiledRule) Match(room Room, evt *event.Event) bool {
+	if cr == nil || !cr.Enabled || cr.isDisabledByMentions(evt) {
+		return false
+	}
+	switch cr.Type {
+	case OverrideRule, UnderrideRule:
+		for _, cond := range cr.conditions {
+			if !cond.Match(room, evt) {
+				return false
+			}
+		}
+		return true
+	case ContentRule:
+		if cr.pattern == nil {
+			return false
+		}
+		msg, ok := evt.Content.Raw["body"].(string)
+		return ok && cr.pattern.Match(msg)
+	case RoomRule:
+		return id.RoomID(cr.RuleID) == evt.RoomID
+	case SenderRule:
+		return id.UserID(cr.RuleID) == evt.Sender
+	default:
+		return false
+	}
+}
+
+func compileRuleArray(rules PushRuleArray) []*compiledRule {
+	compiled := make([]*compiledRule, len(rules))
+	for i, rule := range rules {
+		compiled[i] = rule.compile()
+	}
+	return compiled
+}
+
+func compileRuleMap[T ~string](rules PushRuleMap) map[T]*compiledRule {
+	compiled := make(map[T]*compiledRule, len(rules.Map))
+	for key, rule := range rules.Map {
+		compiled[T(key)] = rule.compile()
+	}
+	return compiled
+}
+
+// CompiledRuleset is a PushRuleset where all glob patterns and condition keys have been parsed in advance.
+//
+// Matching events against a compiled ruleset is significantly faster than using the PushRuleset directly,
+// which is useful when evaluating lots of events (e.g. during initial sync). The compiled ruleset is not
+// updated automatically, so Compile must be called again if the underlying rules change.
+type CompiledRuleset struct {
+	Ruleset *PushRuleset
+
+	override  []*compiledRule
+	content   []*compiledRule
+	room      map[id.RoomID]*compiledRule
+	sender    map[id.UserID]*compiledRule
+	underride []*compiledRule
+}
+
+var _ PushRuleCollection = (*CompiledRuleset)(nil)
+
+// Compile pre-parses all the rules in this ruleset into a CompiledRuleset.
+func (rs *PushRuleset) Compile() *CompiledRuleset {
+	if rs == nil {
+		return nil
+	}
+	return &CompiledRuleset{
+		Ruleset: rs,
+
+		override:  compileRuleArray(rs.Override),
+		content:   compileRuleArray(rs.Content),
+		room:      compileRuleMap[id.RoomID](rs.Room),
+		sender:    compileRuleMap[id.UserID](rs.Sender),
+		underride: compileRuleArray(rs.Underride),
+	}
+}
+
+func matchCompiledArray(rules []*compiledRule, room Room, evt *event.Event) *PushRule {
+	for _, rule := range rules {
+		if rule.Match(room, evt) {
+			return rule.PushRule
+		}
+	}
+	return nil
+}
+
+// GetMatchingRule returns the highest priority rule that matches the given event.
+func (crs *CompiledRuleset) GetMatchingRule(room Room, evt *event.Event) *PushRule {
+	if crs == nil {
+		return nil
+	}
+	if rule := matchCompiledArray(crs.override, room, evt); rule != nil {
+		return rule
+	} else if rule = matchCompiledArray(crs.content, room, evt); rule != nil {
+		return rule
+	} else if rule := crs.room[evt.RoomID]; rule.Match(room, evt) {
+		return rule.PushRule
+	} else if rule := crs.sender[evt.Sender]; rule.Match(room, evt) {
+		return rule.PushRule
+	}
+	return matchCompiledArray(crs.underride, room, evt)
+}
+
+// GetActions returns the actions of the highest priority rule that matches the given event,
+// or DefaultPushActions if no rule matches.
+func (crs *CompiledRuleset) GetActions(room Room, evt *event.Event) PushActionArray {
+	actions := crs.GetMatchingRule(room, evt).GetActions()
+	if actions == nil {
+		return DefaultPushActions
+	}
+	return actions
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pushrules_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mautrix/pushrules"
+)
+
+func makeBenchmarkEvents() []*event.Event {
+	return []*event.Event{
+		newFakeEvent(event.EventMessage, &event.MessageEventContent{MsgType: event.MsgText, Body: "hello world"}),
+		newFakeEvent(event.EventMessage, &event.MessageEventContent{MsgType: event.MsgNotice, Body: "beep boop"}),
+		newFakeEvent(event.EventMessage, &event.MessageEventContent{
+			MsgType:  event.MsgText,
+			Body:     "hi tulir",
+			Mentions: &event.Mentions{UserIDs: []id.UserID{"@tulir:maunium.net"}},
+		}),
+		newFakeEvent(event.EventReaction, &event.ReactionEventContent{RelatesTo: event.RelatesTo{Type: event.RelAnnotation, EventID: "$foo", Key: "👍️"}}),
+		newFakeEvent(event.EventEncrypted, map[string]any{"algorithm": "m.megolm.v1.aes-sha2"}),
+	}
+}
+
+func TestCompiledRuleset_MatchesUncompiled(t *testing.T) {
+	rs := pushrules.DefaultRuleset("@tulir:maunium.net")
+	rs.Content = append(rs.Content, &pushrules.PushRule{
+		Type:    pushrules.ContentRule,
+		RuleID:  "world",
+		Pattern: "world",
+		Enabled: true,
+		Actions: pushrules.PushActionArray{{Action: pushrules.ActionNotify}, {Action: pushrules.ActionSetTweak, Tweak: pushrules.TweakHighlight}},
+	})
+	crs := rs.Compile()
+	for _, room := range []pushrules.Room{newFakeRoom(2), newFakeRoom(10)} {
+		for _, evt := range makeBenchmarkEvents() {
+			assert.Equal(t, rs.GetMatchingRule(room, evt), crs.GetMatchingRule(room, evt))
+			assert.Equal(t, rs.GetActions(room, evt), crs.GetActions(room, evt))
+		}
+	}
+}
+
+func BenchmarkPushRuleset_GetActions(b *testing.B) {
+	rs := pushrules.DefaultRuleset("@tulir:maunium.net")
+	room := newFakeRoom(10)
+	events := makeBenchmarkEvents()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rs.GetActions(room, events[i%len(events)])
+	}
+}
+
+func BenchmarkCompiledRuleset_GetActions(b *testing.B) {
+	crs := pushrules.DefaultRuleset("@tulir:maunium.net").Compile()
+	room := newFakeRoom(10)
+	events := makeBenchmarkEvents()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		crs.GetActions(room, events[i%len(events)])
+	}
+}
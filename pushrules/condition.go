@@ -10,6 +10,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"unicode"
@@ -54,6 +55,10 @@ const (
 
 	KindRelatedEventMatch         PushCondKind = "related_event_match"
 	KindUnstableRelatedEventMatch PushCondKind = "im.nheko.msc3664.related_event_match"
+
+	// KindExtensibleEventMatch is like event_match, but it also traverses arrays in the content,
+	// so that values inside MSC1767 extensible event blocks and poll answers can be matched.
+	KindExtensibleEventMatch PushCondKind = "fi.mau.extensible_event_match"
 )
 
 // PushCondition wraps a condition that is required for a specific PushRule to be used.
@@ -72,6 +77,54 @@ type PushCondition struct {
 
 	// The relation type for related_event_match from MSC3664
 	RelType event.RelationType `json:"rel_type,omitempty"`
+
+	// Raw contains the original JSON of conditions with unknown kinds,
+	// so that they can be serialized back without losing any fields.
+	Raw json.RawMessage `json:"-"`
+}
+
+// IsKnownKind returns true if the kind of this condition is supported by this library.
+// Conditions with unknown kinds never match.
+func (cond *PushCondition) IsKnownKind() bool {
+	switch cond.Kind {
+	case KindEventMatch, KindContainsDisplayName, KindRoomMemberCount, KindEventPropertyIs,
+		KindEventPropertyContains, KindSenderNotificationPermission, KindRelatedEventMatch,
+		KindUnstableRelatedEventMatch, KindExtensibleEventMatch:
+		return true
+	default:
+		return false
+	}
+}
+
+type marshalablePushCondition PushCondition
+
+// UnmarshalJSON parses JSON into this PushCondition.
+// If the condition kind is unknown, the raw JSON is also stored in the Raw field.
+func (cond *PushCondition) UnmarshalJSON(raw []byte) error {
+	*cond = PushCondition{}
+	err := json.Unmarshal(raw, (*marshalablePushCondition)(cond))
+	if err != nil {
+		return err
+	}
+	if !cond.IsKnownKind() {
+		cond.Raw = slices.Clone(raw)
+	}
+	return nil
+}
+
+// MarshalJSON marshals the condition into JSON. Conditions with unknown kinds are marshaled from the Raw field
+// if it's set. Otherwise, this is only needed to ensure that the value field is always included for
+// event_property_is and event_property_contains, as false, 0 and null are all valid values that would otherwise be omitted.
+func (cond *PushCondition) MarshalJSON() ([]byte, error) {
+	if cond.Raw != nil && !cond.IsKnownKind() {
+		return cond.Raw, nil
+	} else if cond.Kind != KindEventPropertyIs && cond.Kind != KindEventPropertyContains {
+		return json.Marshal((*marshalablePushCondition)(cond))
+	}
+	return json.Marshal(&struct {
+		*marshalablePushCondition
+		Value any `json:"value"`
+	}{(*marshalablePushCondition)(cond), cond.Value})
 }
 
 // MemberCountFilterRegex is the regular expression to parse the MemberCountCondition of PushConditions.
@@ -79,22 +132,69 @@ var MemberCountFilterRegex = regexp.MustCompile("^(==|[<>]=?)?([0-9]+)$")
 
 // Match checks if this condition is fulfilled for the given event in the given room.
 func (cond *PushCondition) Match(room Room, evt *event.Event) bool {
+	return cond.compile().Match(room, evt)
+}
+
+// conditionKey is a pre-parsed version of the Key field in a PushCondition.
+type conditionKey struct {
+	field string
+	path  []string
+}
+
+// compiledCondition is a PushCondition with the key and glob pattern pre-parsed,
+// so that it can be matched against many events efficiently.
+type compiledCondition struct {
+	*PushCondition
+	key conditionKey
+	// pattern is the compiled glob pattern, or nil if the condition doesn't use patterns or the pattern is invalid.
+	pattern glob.Glob
+}
+
+func (cond *PushCondition) parseKey() conditionKey {
+	field, subkey, _ := strings.Cut(cond.Key, ".")
+	key := conditionKey{field: field}
+	if field == "content" {
+		// Split the match key with escaping to implement https://github.com/matrix-org/matrix-spec-proposals/pull/3873
+		key.path = splitWithEscaping(subkey, '.', '\\')
+	}
+	return key
+}
+
+func (cond *PushCondition) compile() *compiledCondition {
+	cc := &compiledCondition{PushCondition: cond}
 	switch cond.Kind {
+	case KindEventMatch, KindRelatedEventMatch, KindUnstableRelatedEventMatch, KindExtensibleEventMatch:
+		cc.key = cond.parseKey()
+		cc.pattern = glob.CompileWithImplicitContains(cond.Pattern)
+	case KindEventPropertyIs, KindEventPropertyContains:
+		cc.key = cond.parseKey()
+	}
+	return cc
+}
+
+// Match checks if this condition is fulfilled for the given event in the given room.
+func (cc *compiledCondition) Match(room Room, evt *event.Event) bool {
+	switch cc.Kind {
 	case KindEventMatch, KindEventPropertyIs, KindEventPropertyContains:
-		return cond.matchValue(evt)
+		return cc.matchValue(evt)
+	case KindExtensibleEventMatch:
+		return cc.matchExtensible(evt)
 	case KindRelatedEventMatch, KindUnstableRelatedEventMatch:
-		return cond.matchRelatedEvent(room, evt)
+		return cc.matchRelatedEvent(room, evt)
 	case KindContainsDisplayName:
-		return cond.matchDisplayName(room, evt)
+		return cc.matchDisplayName(room, evt)
 	case KindRoomMemberCount:
-		return cond.matchMemberCount(room)
+		return cc.matchMemberCount(room)
 	case KindSenderNotificationPermission:
-		return cond.matchSenderNotificationPermission(room, evt.Sender, cond.Key)
+		return cc.matchSenderNotificationPermission(room, evt.Sender, cc.Key)
 	default:
 		return false
 	}
 }
 
+// splitWithEscaping splits a dotted path as specified in https://spec.matrix.org/v1.12/appendices/#dot-separated-property-paths
+//
+// Backslashes followed by a dot or another backslash are escape characters. Other backslashes are treated literally.
 func splitWithEscaping(s string, separator, escape byte) []string {
 	var token []byte
 	var tokens []string
@@ -102,7 +202,7 @@ func splitWithEscaping(s string, separator, escape byte) []string {
 		if s[i] == separator {
 			tokens = append(tokens, string(token))
 			token = token[:0]
-		} else if s[i] == escape && i+1 < len(s) {
+		} else if s[i] == escape && i+1 < len(s) && (s[i+1] == separator || s[i+1] == escape) {
 			i++
 			token = append(token, s[i])
 		} else {
@@ -113,6 +213,19 @@ func splitWithEscaping(s string, separator, escape byte) []string {
 	return tokens
 }
 
+// strictNestedGet gets a value from nested maps without any of the backwards-compatibility hacks.
+func strictNestedGet(data map[string]any, path []string) (any, bool) {
+	val, ok := data[path[0]]
+	if !ok || len(path) == 1 {
+		return val, ok
+	}
+	mapVal, ok := val.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	return strictNestedGet(mapVal, path[1:])
+}
+
 func hackyNestedGet(data map[string]any, path []string) (any, bool) {
 	val, ok := data[path[0]]
 	if len(path) == 1 {
@@ -133,6 +246,41 @@ func hackyNestedGet(data map[string]any, path []string) (any, bool) {
 	return hackyNestedGet(data, path[1:])
 }
 
+// hackyNestedGetAll finds all values at the given path for KindExtensibleEventMatch conditions.
+//
+// Like hackyNestedGet, the first two parts of the path are combined if a key isn't found.
+// Additionally, arrays are traversed, so that values inside MSC1767 extensible event blocks can be matched
+// (e.g. content.m\.text.body will find the body of every representation). If the final value is an array,
+// each item is returned separately.
+func hackyNestedGetAll(val any, path []string, output []any) []any {
+	switch typed := val.(type) {
+	case []any:
+		for _, item := range typed {
+			output = hackyNestedGetAll(item, path, output)
+		}
+	case map[string]any:
+		if len(path) == 0 {
+			return append(output, typed)
+		}
+		if child, ok := typed[path[0]]; ok {
+			prevLen := len(output)
+			output = hackyNestedGetAll(child, path[1:], output)
+			if len(output) > prevLen {
+				return output
+			}
+		}
+		if len(path) > 1 {
+			combined := append([]string{path[0] + "." + path[1]}, path[2:]...)
+			return hackyNestedGetAll(typed, combined, output)
+		}
+	default:
+		if len(path) == 0 {
+			return append(output, typed)
+		}
+	}
+	return output
+}
+
 func stringifyForPushCondition(val interface{}) string {
 	// Implement MSC3862 to allow matching any type of field
 	// https://github.com/matrix-org/matrix-spec-proposals/pull/3862
@@ -150,10 +298,8 @@ func stringifyForPushCondition(val interface{}) string {
 	}
 }
 
-func (cond *PushCondition) getValue(evt *event.Event) (any, bool) {
-	key, subkey, _ := strings.Cut(cond.Key, ".")
-
-	switch key {
+func (cc *compiledCondition) getValue(evt *event.Event) (any, bool) {
+	switch cc.key.field {
 	case "type":
 		return evt.Type.Type, true
 	case "sender":
@@ -166,10 +312,13 @@ func (cond *PushCondition) getValue(evt *event.Event) (any, bool) {
 		}
 		return *evt.StateKey, true
 	case "content":
-		// Split the match key with escaping to implement https://github.com/matrix-org/matrix-spec-proposals/pull/3873
-		splitKey := splitWithEscaping(subkey, '.', '\\')
-		// Then do a hacky nested get that supports combining parts for the backwards-compat part of MSC3873
-		return hackyNestedGet(evt.Content.Raw, splitKey)
+		if cc.Kind == KindEventPropertyIs || cc.Kind == KindEventPropertyContains {
+			// The event_property_* conditions are newer than MSC3873, so they don't need backwards-compatibility
+			return strictNestedGet(evt.Content.Raw, cc.key.path)
+		}
+		// Do a hacky nested get that supports combining parts for the backwards-compat part of MSC3873.
+		// The path is mutated by hackyNestedGet, so make a copy first.
+		return hackyNestedGet(evt.Content.Raw, slices.Clone(cc.key.path))
 	default:
 		return nil, false
 	}
@@ -206,6 +355,19 @@ func numberToInt64(a any) int64 {
 	}
 }
 
+func isIntegral(a any) bool {
+	switch typed := a.(type) {
+	case float64:
+		return typed == float64(int64(typed))
+	case float32:
+		return typed == float32(int64(typed))
+	default:
+		return true
+	}
+}
+
+// valueEquals implements the exact value comparison of event_property_is and event_property_contains.
+// Only strings, integers, booleans and null can match: arrays and objects never match anything.
 func valueEquals(a, b any) bool {
 	// Convert floats to ints when comparing numbers (the JSON parser generates floats, but Matrix only allows integers)
 	// Also allow other numeric types in case something generates events manually without json
@@ -213,40 +375,58 @@ func valueEquals(a, b any) bool {
 	case float64, float32, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
 		switch b.(type) {
 		case float64, float32, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
-			return numberToInt64(a) == numberToInt64(b)
+			// Non-integer floats aren't valid canonical JSON, so they never match
+			return isIntegral(a) && isIntegral(b) && numberToInt64(a) == numberToInt64(b)
+		}
+		return false
+	case string, bool, nil:
+		return a == b
+	default:
+		return false
+	}
+}
+
+func (cc *compiledCondition) matchExtensible(evt *event.Event) bool {
+	if cc.pattern == nil {
+		return false
+	} else if cc.key.field != "content" {
+		return cc.matchValue(evt)
+	}
+	for _, val := range hackyNestedGetAll(evt.Content.Raw, cc.key.path, nil) {
+		if cc.pattern.Match(stringifyForPushCondition(val)) {
+			return true
 		}
 	}
-	return a == b
+	return false
 }
 
-func (cond *PushCondition) matchValue(evt *event.Event) bool {
-	val, ok := cond.getValue(evt)
+func (cc *compiledCondition) matchValue(evt *event.Event) bool {
+	val, ok := cc.getValue(evt)
 	if !ok {
 		return false
 	}
 
-	switch cond.Kind {
-	case KindEventMatch, KindRelatedEventMatch, KindUnstableRelatedEventMatch:
-		pattern := glob.CompileWithImplicitContains(cond.Pattern)
-		if pattern == nil {
+	switch cc.Kind {
+	case KindEventMatch, KindRelatedEventMatch, KindUnstableRelatedEventMatch, KindExtensibleEventMatch:
+		if cc.pattern == nil {
 			return false
 		}
-		return pattern.Match(stringifyForPushCondition(val))
+		return cc.pattern.Match(stringifyForPushCondition(val))
 	case KindEventPropertyIs:
-		return valueEquals(val, cond.Value)
+		return valueEquals(val, cc.Value)
 	case KindEventPropertyContains:
 		valArr, ok := val.([]any)
 		if !ok {
 			return false
 		}
 		for _, item := range valArr {
-			if valueEquals(item, cond.Value) {
+			if valueEquals(item, cc.Value) {
 				return true
 			}
 		}
 		return false
 	default:
-		panic(fmt.Errorf("matchValue called for unknown condition kind %s", cond.Kind))
+		panic(fmt.Errorf("matchValue called for unknown condition kind %s", cc.Kind))
 	}
 }
 
@@ -270,7 +450,7 @@ func (cond *PushCondition) getRelationEventID(relatesTo *event.RelatesTo) id.Eve
 	}
 }
 
-func (cond *PushCondition) matchRelatedEvent(room Room, evt *event.Event) bool {
+func (cc *compiledCondition) matchRelatedEvent(room Room, evt *event.Event) bool {
 	var relatesTo *event.RelatesTo
 	if relatable, ok := evt.Content.Parsed.(event.Relatable); ok {
 		relatesTo = relatable.OptionalGetRelatesTo()
@@ -280,14 +460,14 @@ func (cond *PushCondition) matchRelatedEvent(room Room, evt *event.Event) bool {
 			_ = json.Unmarshal([]byte(res.Raw), &relatesTo)
 		}
 	}
-	if evtID := cond.getRelationEventID(relatesTo); evtID == "" {
+	if evtID := cc.getRelationEventID(relatesTo); evtID == "" {
 		return false
 	} else if eventfulRoom, ok := room.(EventfulRoom); !ok {
 		return false
 	} else if evt = eventfulRoom.GetEvent(relatesTo.EventID); evt == nil {
 		return false
 	} else {
-		return cond.matchValue(evt)
+		return cc.matchValue(evt)
 	}
 }
 
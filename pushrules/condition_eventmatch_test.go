@@ -89,3 +89,43 @@ func TestPushCondition_Match_KindEvent_UnknownKey(t *testing.T) {
 	evt := newFakeEvent(event.NewEventType("m.room.foo"), &struct{}{})
 	assert.False(t, condition.Match(blankTestRoom, evt))
 }
+
+func TestPushCondition_Match_KindExtensibleEvent_ExtensibleEventBlock(t *testing.T) {
+	evt := newFakeEvent(event.NewEventType("m.message"), map[string]any{
+		"m.text": []any{
+			map[string]any{"mimetype": "text/html", "body": "<b>meow</b>"},
+			map[string]any{"body": "**meow**"},
+		},
+	})
+	assert.True(t, newExtensibleMatchPushCondition(`content.m\.text.body`, "**meow**").Match(blankTestRoom, evt))
+	assert.True(t, newExtensibleMatchPushCondition(`content.m\.text.mimetype`, "text/html").Match(blankTestRoom, evt))
+	assert.False(t, newExtensibleMatchPushCondition(`content.m\.text.body`, "woof").Match(blankTestRoom, evt))
+}
+
+func TestPushCondition_Match_KindExtensibleEvent_PollContent(t *testing.T) {
+	evt := newFakeEvent(event.EventUnstablePollStart, map[string]any{
+		"org.matrix.msc3381.poll.start": map[string]any{
+			"question": map[string]any{"org.matrix.msc1767.text": "Pizza or pasta?"},
+			"answers": []any{
+				map[string]any{"id": "1", "org.matrix.msc1767.text": "Pizza"},
+				map[string]any{"id": "2", "org.matrix.msc1767.text": "Pasta"},
+			},
+		},
+	})
+	assert.True(t, newExtensibleMatchPushCondition("content.org.matrix.msc3381.poll.start.question.org.matrix.msc1767.text", "Pizza or pasta?").Match(blankTestRoom, evt))
+	assert.True(t, newExtensibleMatchPushCondition(`content.org\.matrix\.msc3381\.poll\.start.answers.org\.matrix\.msc1767\.text`, "Pasta").Match(blankTestRoom, evt))
+	assert.False(t, newExtensibleMatchPushCondition(`content.org\.matrix\.msc3381\.poll\.start.answers.id`, "3").Match(blankTestRoom, evt))
+}
+
+func TestPushCondition_Match_KindExtensibleEvent_ArrayMembership(t *testing.T) {
+	evt := newFakeEvent(event.NewEventType("m.room.foo"), map[string]any{"tags": []any{"cat", "dog"}})
+	assert.True(t, newExtensibleMatchPushCondition("content.tags", "dog").Match(blankTestRoom, evt))
+	assert.False(t, newExtensibleMatchPushCondition("content.tags", "fox").Match(blankTestRoom, evt))
+}
+
+func TestPushCondition_Match_KindEvent_DoesntTraverseArrays(t *testing.T) {
+	evt := newFakeEvent(event.NewEventType("m.message"), map[string]any{
+		"m.text": []any{map[string]any{"body": "meow"}},
+	})
+	assert.False(t, newMatchPushCondition(`content.m\.text.body`, "meow").Match(blankTestRoom, evt))
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pushrules_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestPushCondition_Match_KindEventPropertyContains_String(t *testing.T) {
+	condition := newEventPropertyContainsPushCondition("content.m\\.mentions.user_ids", "@tulir:maunium.net")
+	evt := newFakeEvent(event.EventMessage, &event.MessageEventContent{
+		MsgType:  event.MsgText,
+		Body:     "hi",
+		Mentions: &event.Mentions{UserIDs: []id.UserID{"@foo:example.com", "@tulir:maunium.net"}},
+	})
+	assert.True(t, condition.Match(blankTestRoom, evt))
+	evt = newFakeEvent(event.EventMessage, &event.MessageEventContent{
+		MsgType:  event.MsgText,
+		Body:     "hi @tulir:maunium.net",
+		Mentions: &event.Mentions{UserIDs: []id.UserID{"@foo:example.com"}},
+	})
+	assert.False(t, condition.Match(blankTestRoom, evt))
+}
+
+func TestPushCondition_Match_KindEventPropertyContains_Types(t *testing.T) {
+	evt := newFakeEvent(event.NewEventType("m.room.foo"), map[string]any{"meow": []any{"5", 1, true, nil}})
+	assert.True(t, newEventPropertyContainsPushCondition("content.meow", "5").Match(blankTestRoom, evt))
+	assert.True(t, newEventPropertyContainsPushCondition("content.meow", 1).Match(blankTestRoom, evt))
+	assert.True(t, newEventPropertyContainsPushCondition("content.meow", true).Match(blankTestRoom, evt))
+	assert.True(t, newEventPropertyContainsPushCondition("content.meow", nil).Match(blankTestRoom, evt))
+	assert.False(t, newEventPropertyContainsPushCondition("content.meow", 5).Match(blankTestRoom, evt))
+	assert.False(t, newEventPropertyContainsPushCondition("content.meow", "1").Match(blankTestRoom, evt))
+	assert.False(t, newEventPropertyContainsPushCondition("content.meow", false).Match(blankTestRoom, evt))
+}
+
+func TestPushCondition_Match_KindEventPropertyContains_NotArray(t *testing.T) {
+	evt := newFakeEvent(event.NewEventType("m.room.foo"), map[string]any{"meow": "foo"})
+	assert.False(t, newEventPropertyContainsPushCondition("content.meow", "foo").Match(blankTestRoom, evt))
+}
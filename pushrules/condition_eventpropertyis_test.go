@@ -7,11 +7,12 @@
 package pushrules_test
 
 import (
-	"maunium.net/go/mautrix/event"
-
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"maunium.net/go/mautrix/event"
 )
 
 func TestPushCondition_Match_KindEventPropertyIs_MsgType(t *testing.T) {
@@ -89,3 +90,37 @@ func TestPushCondition_Match_KindEventPropertyIs_Bool_NoMatch(t *testing.T) {
 	evt = newFakeEvent(event.NewEventType("m.room.foo"), map[string]any{"meow": ""})
 	assert.False(t, condition.Match(blankTestRoom, evt))
 }
+
+func TestPushCondition_Match_KindEventPropertyIs_EscapedPath(t *testing.T) {
+	condition := newEventPropertyIsPushCondition(`content.m\.foo.bar\\baz`, "yes")
+	evt := newFakeEvent(event.NewEventType("m.room.foo"), map[string]any{"m.foo": map[string]any{`bar\baz`: "yes"}})
+	assert.True(t, condition.Match(blankTestRoom, evt))
+	condition = newEventPropertyIsPushCondition(`content.m\.foo.bar\baz`, "yes")
+	assert.True(t, condition.Match(blankTestRoom, evt), "backslashes not followed by a dot or backslash should be literal")
+}
+
+func TestPushCondition_Match_KindEventPropertyIs_NoLegacyPathCombining(t *testing.T) {
+	condition := newEventPropertyIsPushCondition("content.m.foo", "yes")
+	evt := newFakeEvent(event.NewEventType("m.room.foo"), map[string]any{"m.foo": "yes"})
+	assert.False(t, condition.Match(blankTestRoom, evt))
+}
+
+func TestPushCondition_Match_KindEventPropertyIs_NoTypeCoercion(t *testing.T) {
+	evt := newFakeEvent(event.NewEventType("m.room.foo"), map[string]any{"meow": 1.5, "arr": []any{"a"}, "num": "5"})
+	assert.False(t, newEventPropertyIsPushCondition("content.meow", 1).Match(blankTestRoom, evt), "non-integer floats should never match")
+	assert.False(t, newEventPropertyIsPushCondition("content.arr", []any{"a"}).Match(blankTestRoom, evt), "arrays should never match")
+	assert.False(t, newEventPropertyIsPushCondition("content.num", 5).Match(blankTestRoom, evt), "strings shouldn't match integers")
+}
+
+func TestPushCondition_MarshalJSON_KindEventPropertyIs_FalsyValues(t *testing.T) {
+	for _, value := range []any{false, nil, 0, ""} {
+		condition := newEventPropertyIsPushCondition("content.meow", value)
+		data, err := json.Marshal(condition)
+		assert.NoError(t, err)
+		var parsed map[string]any
+		assert.NoError(t, json.Unmarshal(data, &parsed))
+		val, ok := parsed["value"]
+		assert.True(t, ok, "value %v should be included in JSON", value)
+		assert.True(t, val == value || (val == float64(0) && value == 0))
+	}
+}
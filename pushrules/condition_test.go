@@ -94,6 +94,14 @@ func newMatchPushCondition(key, pattern string) *pushrules.PushCondition {
 	}
 }
 
+func newExtensibleMatchPushCondition(key, pattern string) *pushrules.PushCondition {
+	return &pushrules.PushCondition{
+		Kind:    pushrules.KindExtensibleEventMatch,
+		Key:     key,
+		Pattern: pattern,
+	}
+}
+
 func newEventPropertyIsPushCondition(key string, value any) *pushrules.PushCondition {
 	return &pushrules.PushCondition{
 		Kind:  pushrules.KindEventPropertyIs,
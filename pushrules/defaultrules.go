@@ -0,0 +1,69 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pushrules
+
+import (
+	_ "embed"
+	"encoding/json"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// IDs of the predefined push rules as specified in https://spec.matrix.org/v1.12/client-server-api/#predefined-rules
+const (
+	RuleMaster              = ".m.rule.master"
+	RuleSuppressNotices     = ".m.rule.suppress_notices"
+	RuleInviteForMe         = ".m.rule.invite_for_me"
+	RuleMemberEvent         = ".m.rule.member_event"
+	RuleIsUserMention       = ".m.rule.is_user_mention"
+	RuleContainsDisplayName = ".m.rule.contains_display_name"
+	RuleIsRoomMention       = ".m.rule.is_room_mention"
+	RuleRoomNotif           = ".m.rule.roomnotif"
+	RuleTombstone           = ".m.rule.tombstone"
+	RuleReaction            = ".m.rule.reaction"
+	RuleServerACL           = ".m.rule.room.server_acl"
+	RuleSuppressEdits       = ".m.rule.suppress_edits"
+
+	RuleContainsUserName = ".m.rule.contains_user_name"
+
+	RuleCall                  = ".m.rule.call"
+	RuleEncryptedRoomOneToOne = ".m.rule.encrypted_room_one_to_one"
+	RuleRoomOneToOne          = ".m.rule.room_one_to_one"
+	RuleMessage               = ".m.rule.message"
+	RuleEncrypted             = ".m.rule.encrypted"
+	RuleUnstablePollResponse  = ".org.matrix.msc3930.rule.poll_response"
+	RuleUnstablePollStartDM   = ".org.matrix.msc3930.rule.poll_start_one_to_one"
+	RuleUnstablePollStart     = ".org.matrix.msc3930.rule.poll_start"
+	RuleUnstablePollEndDM     = ".org.matrix.msc3930.rule.poll_end_one_to_one"
+	RuleUnstablePollEnd       = ".org.matrix.msc3930.rule.poll_end"
+)
+
+//go:embed defaultrules.json
+var defaultRulesJSON string
+
+// DefaultRuleset returns the server-default push rules for the given user as defined in the spec (v1.7+),
+// plus the unstable poll rules from MSC3930.
+//
+// The returned ruleset is a fresh copy and can be freely modified.
+func DefaultRuleset(userID id.UserID) *PushRuleset {
+	localpart, _, _ := userID.ParseAndDecode()
+	escape := func(val string) string {
+		marshaled, _ := json.Marshal(val)
+		return string(marshaled[1 : len(marshaled)-1])
+	}
+	data := strings.NewReplacer(
+		"$USER_ID", escape(userID.String()),
+		"$LOCALPART", escape(localpart),
+	).Replace(defaultRulesJSON)
+	var rs PushRuleset
+	err := json.Unmarshal([]byte(data), &rs)
+	if err != nil {
+		panic(err)
+	}
+	return &rs
+}
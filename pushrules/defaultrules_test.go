@@ -0,0 +1,161 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pushrules_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mautrix/pushrules"
+)
+
+func ruleIDs(rules pushrules.PushRuleArray) []string {
+	ids := make([]string, len(rules))
+	for i, rule := range rules {
+		ids[i] = rule.RuleID
+	}
+	return ids
+}
+
+func TestDefaultRuleset_MatchesSpec(t *testing.T) {
+	rs := pushrules.DefaultRuleset("@tulir:maunium.net")
+	require.NotNil(t, rs)
+	assert.Equal(t, []string{
+		pushrules.RuleMaster,
+		pushrules.RuleSuppressNotices,
+		pushrules.RuleInviteForMe,
+		pushrules.RuleMemberEvent,
+		pushrules.RuleIsUserMention,
+		pushrules.RuleContainsDisplayName,
+		pushrules.RuleIsRoomMention,
+		pushrules.RuleRoomNotif,
+		pushrules.RuleTombstone,
+		pushrules.RuleReaction,
+		pushrules.RuleServerACL,
+		pushrules.RuleSuppressEdits,
+		pushrules.RuleUnstablePollResponse,
+	}, ruleIDs(rs.Override))
+	assert.Equal(t, []string{pushrules.RuleContainsUserName}, ruleIDs(rs.Content))
+	assert.Equal(t, "tulir", rs.Content[0].Pattern)
+	assert.Equal(t, []string{
+		pushrules.RuleCall,
+		pushrules.RuleEncryptedRoomOneToOne,
+		pushrules.RuleRoomOneToOne,
+		pushrules.RuleMessage,
+		pushrules.RuleEncrypted,
+		pushrules.RuleUnstablePollStartDM,
+		pushrules.RuleUnstablePollStart,
+		pushrules.RuleUnstablePollEndDM,
+		pushrules.RuleUnstablePollEnd,
+	}, ruleIDs(rs.Underride))
+	assert.False(t, rs.Override[0].Enabled)
+	for _, rule := range rs.Override {
+		assert.True(t, rule.Default)
+		assert.Equal(t, pushrules.OverrideRule, rule.Type)
+	}
+}
+
+func TestDefaultRuleset_Evaluate(t *testing.T) {
+	rs := pushrules.DefaultRuleset("@tulir:maunium.net")
+	groupRoom := newFakeRoom(4)
+	dmRoom := newFakeRoom(2)
+
+	mention := newFakeEvent(event.EventMessage, &event.MessageEventContent{
+		MsgType:  event.MsgText,
+		Body:     "hey",
+		Mentions: &event.Mentions{UserIDs: []id.UserID{"@tulir:maunium.net"}},
+	})
+	should := rs.GetActions(groupRoom, mention).Should()
+	assert.True(t, should.Notify)
+	assert.True(t, should.Highlight)
+	assert.Equal(t, pushrules.RuleIsUserMention, rs.GetMatchingRule(groupRoom, mention).RuleID)
+
+	edit := newFakeEvent(event.EventMessage, &event.MessageEventContent{
+		MsgType:   event.MsgText,
+		Body:      "* hey",
+		RelatesTo: (&event.RelatesTo{}).SetReplace("$original"),
+	})
+	assert.Equal(t, pushrules.RuleSuppressEdits, rs.GetMatchingRule(groupRoom, edit).RuleID)
+	assert.False(t, rs.GetActions(groupRoom, edit).Should().Notify)
+
+	notice := newFakeEvent(event.EventMessage, &event.MessageEventContent{MsgType: event.MsgNotice, Body: "beep"})
+	assert.False(t, rs.GetActions(groupRoom, notice).Should().Notify)
+
+	plain := newFakeEvent(event.EventMessage, &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"})
+	should = rs.GetActions(groupRoom, plain).Should()
+	assert.True(t, should.Notify)
+	assert.False(t, should.PlaySound)
+	should = rs.GetActions(dmRoom, plain).Should()
+	assert.True(t, should.Notify)
+	assert.Equal(t, "default", should.SoundName)
+
+	pollStart := newFakeEvent(event.EventUnstablePollStart, map[string]any{})
+	assert.Equal(t, pushrules.RuleUnstablePollStart, rs.GetMatchingRule(groupRoom, pollStart).RuleID)
+}
+
+func TestPushRuleset_GetSound(t *testing.T) {
+	rs := pushrules.DefaultRuleset("@tulir:maunium.net")
+	_, err := rs.AddRule(pushrules.ContentRule, &pushrules.PushRule{
+		RuleID:  "keyword",
+		Pattern: "meow",
+		Enabled: true,
+		Actions: pushrules.PushActionArray{{Action: pushrules.ActionNotify}},
+	}, "", "")
+	require.NoError(t, err)
+	keyword := newFakeEvent(event.EventMessage, &event.MessageEventContent{MsgType: event.MsgText, Body: "meow"})
+	plain := newFakeEvent(event.EventMessage, &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"})
+	call := newFakeEvent(event.CallInvite, map[string]any{})
+	assert.Equal(t, "default", rs.GetSound(newFakeRoom(2), keyword))
+	assert.Equal(t, "", rs.GetSound(newFakeRoom(4), keyword))
+	assert.Equal(t, "", rs.GetSound(newFakeRoom(4), plain))
+	assert.Equal(t, "ring", rs.GetSound(newFakeRoom(4), call))
+}
+
+func TestPushRuleset_GetActionsForEncrypted(t *testing.T) {
+	rs := pushrules.DefaultRuleset("@tulir:maunium.net")
+	_, err := rs.AddRule(pushrules.OverrideRule, pushrules.NewEncryptedEventRule(), "", "")
+	require.NoError(t, err)
+	groupRoom := newFakeRoom(4)
+
+	encrypted := newFakeEvent(event.EventEncrypted, map[string]any{"algorithm": "m.megolm.v1.aes-sha2"})
+	assert.Equal(t, pushrules.RuleUnstableEncryptedEvent, rs.GetMatchingRule(groupRoom, encrypted).RuleID)
+	assert.True(t, rs.GetActionsForEncrypted(groupRoom, encrypted, nil).Should().Notify)
+
+	notice := newFakeEvent(event.EventMessage, &event.MessageEventContent{MsgType: event.MsgNotice, Body: "beep"})
+	notice.Content.Raw = nil
+	notice.Sender = ""
+	actions := rs.GetActionsForEncrypted(groupRoom, encrypted, notice)
+	assert.False(t, actions.Should().Notify)
+
+	mention := newFakeEvent(event.EventMessage, &event.MessageEventContent{
+		MsgType:  event.MsgText,
+		Body:     "hey",
+		Mentions: &event.Mentions{UserIDs: []id.UserID{"@tulir:maunium.net"}},
+	})
+	should := rs.Compile().GetActionsForEncrypted(groupRoom, encrypted, mention).Should()
+	assert.True(t, should.Notify)
+	assert.True(t, should.Highlight)
+}
+
+func TestDefaultRuleset_IntentionalMentionsDisableLegacyRules(t *testing.T) {
+	rs := pushrules.DefaultRuleset("@tulir:maunium.net")
+	groupRoom := newFakeRoom(4)
+
+	legacy := newFakeEvent(event.EventMessage, &event.MessageEventContent{MsgType: event.MsgText, Body: "hey tulir"})
+	assert.True(t, rs.GetActions(groupRoom, legacy).Should().Highlight)
+
+	forwardedContent := &event.MessageEventContent{MsgType: event.MsgText, Body: "hey tulir"}
+	forwardedContent.StripMentions()
+	forwarded := newFakeEvent(event.EventMessage, forwardedContent)
+	should := rs.GetActions(groupRoom, forwarded).Should()
+	assert.True(t, should.Notify)
+	assert.False(t, should.Highlight)
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pushrules
+
+import (
+	"encoding/json"
+
+	"maunium.net/go/mautrix/event"
+)
+
+// RuleUnstableEncryptedEvent is the rule ID of the MSC4028 rule that makes the server push all encrypted events,
+// so that the client can decrypt them and evaluate push rules locally.
+//
+// https://github.com/matrix-org/matrix-spec-proposals/pull/4028
+const RuleUnstableEncryptedEvent = ".org.matrix.msc4028.encrypted_event"
+
+// NewEncryptedEventRule returns the MSC4028 override rule that forces notifications for all encrypted events.
+//
+// The rule is not included in DefaultRuleset, as it's only meant to be enabled when the client
+// is able to decrypt events and evaluate push rules locally (e.g. using GetActionsForEncrypted).
+func NewEncryptedEventRule() *PushRule {
+	return &PushRule{
+		Type:    OverrideRule,
+		RuleID:  RuleUnstableEncryptedEvent,
+		Default: true,
+		Enabled: true,
+		Conditions: []*PushCondition{{
+			Kind:    KindEventMatch,
+			Key:     "type",
+			Pattern: event.EventEncrypted.Type,
+		}},
+		Actions: PushActionArray{{Action: ActionNotify}},
+	}
+}
+
+// prepareDecryptedEvent makes a shallow copy of the decrypted event with the outer metadata
+// filled from the encrypted event and the raw content parsed, so that conditions can access it.
+func prepareDecryptedEvent(encrypted, decrypted *event.Event) *event.Event {
+	evt := *decrypted
+	if evt.RoomID == "" {
+		evt.RoomID = encrypted.RoomID
+	}
+	if evt.Sender == "" {
+		evt.Sender = encrypted.Sender
+	}
+	if evt.ID == "" {
+		evt.ID = encrypted.ID
+	}
+	if evt.Timestamp == 0 {
+		evt.Timestamp = encrypted.Timestamp
+	}
+	if evt.Content.Raw == nil && len(evt.Content.VeryRaw) > 0 {
+		_ = json.Unmarshal(evt.Content.VeryRaw, &evt.Content.Raw)
+	}
+	return &evt
+}
+
+func getActionsForEncrypted(rules PushRuleCollection, room Room, encrypted, decrypted *event.Event) PushActionArray {
+	if decrypted == nil {
+		// The event couldn't be decrypted, so the best we can do is evaluate the encrypted event.
+		// With the MSC4028 rule enabled, this will always notify.
+		return rules.GetActions(room, encrypted)
+	}
+	return rules.GetActions(room, prepareDecryptedEvent(encrypted, decrypted))
+}
+
+// GetActionsForEncrypted evaluates push rules for an encrypted event using the decrypted content supplied by the caller.
+//
+// If decrypted is nil, the event is assumed to be un-decryptable and the encrypted event itself is evaluated,
+// which means the MSC4028 rule (if enabled) or .m.rule.encrypted will determine whether to notify.
+func (rs *PushRuleset) GetActionsForEncrypted(room Room, encrypted, decrypted *event.Event) PushActionArray {
+	return getActionsForEncrypted(rs, room, encrypted, decrypted)
+}
+
+// GetActionsForEncrypted is the compiled version of PushRuleset.GetActionsForEncrypted.
+func (crs *CompiledRuleset) GetActionsForEncrypted(room Room, encrypted, decrypted *event.Event) PushActionArray {
+	return getActionsForEncrypted(crs, room, encrypted, decrypted)
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pushrules
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalidKeyword = errors.New("keyword must not be empty or start with a dot")
+
+// KeywordActions are the actions used for keyword rules created with AddKeyword.
+// They match the actions of the default mention rules.
+var KeywordActions = PushActionArray{
+	{Action: ActionNotify},
+	{Action: ActionSetTweak, Tweak: TweakSound, Value: "default"},
+	{Action: ActionSetTweak, Tweak: TweakHighlight},
+}
+
+// GetKeywords returns the patterns of all enabled user-defined content rules, i.e. the notification keywords of the account.
+func (rs *PushRuleset) GetKeywords() (keywords []string) {
+	if rs == nil {
+		return nil
+	}
+	for _, rule := range rs.Content {
+		if !rule.Default && rule.Enabled && rule.Pattern != "" {
+			keywords = append(keywords, rule.Pattern)
+		}
+	}
+	return
+}
+
+// AddKeyword adds a content rule that notifies for messages containing the given keyword.
+// The keyword is used as both the rule ID and the pattern.
+//
+// Like other mutation methods, the change is applied to this ruleset and returned so that it can be sent to the server.
+func (rs *PushRuleset) AddKeyword(keyword string) (*PushRuleChange, error) {
+	keyword = strings.TrimSpace(keyword)
+	if keyword == "" || strings.HasPrefix(keyword, ".") {
+		return nil, ErrInvalidKeyword
+	}
+	return rs.AddRule(ContentRule, &PushRule{
+		RuleID:  keyword,
+		Enabled: true,
+		Pattern: keyword,
+		Actions: KeywordActions,
+	}, "", "")
+}
+
+// RemoveKeyword deletes the content rule for the given keyword.
+func (rs *PushRuleset) RemoveKeyword(keyword string) (*PushRuleChange, error) {
+	return rs.DeleteRule(ContentRule, strings.TrimSpace(keyword))
+}
@@ -0,0 +1,442 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pushrules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/event"
+)
+
+var (
+	ErrRuleNotFound          = errors.New("push rule not found")
+	ErrRuleAlreadyExists     = errors.New("push rule already exists")
+	ErrCannotModifyDefault   = errors.New("server-default push rules can't be created or deleted")
+	ErrInvalidRulePosition   = errors.New("before and after must refer to existing user-defined rules")
+	ErrUnknownPushRuleChange = errors.New("unknown push rule change type")
+)
+
+// PushRuleChangeType is the type of modification made in a PushRuleChange.
+type PushRuleChangeType string
+
+const (
+	// ChangePut creates a new rule or replaces an existing user-defined rule.
+	ChangePut PushRuleChangeType = "put"
+	// ChangeDelete deletes a user-defined rule.
+	ChangeDelete PushRuleChangeType = "delete"
+	// ChangeEnabled enables or disables a rule.
+	ChangeEnabled PushRuleChangeType = "enabled"
+	// ChangeActions replaces the actions of a rule.
+	ChangeActions PushRuleChangeType = "actions"
+)
+
+// PushRuleChange describes a single modification to a push ruleset.
+//
+// Changes can be applied to a local PushRuleset using PushRuleset.ApplyChange,
+// and sent to the server using the Method, PathComponents, Query and Body methods
+// (or mautrix.Client.ApplyPushRuleChange, which uses those methods).
+type PushRuleChange struct {
+	Type   PushRuleChangeType
+	Kind   PushRuleType
+	RuleID string
+
+	// The new rule for ChangePut.
+	Rule *PushRule
+	// The position of the new rule for ChangePut. At most one of these should be set.
+	Before string
+	After  string
+
+	// The new enabled state for ChangeEnabled.
+	Enabled bool
+	// The new actions for ChangeActions.
+	Actions PushActionArray
+}
+
+// Method returns the HTTP method used for sending this change to the server.
+func (change *PushRuleChange) Method() string {
+	if change.Type == ChangeDelete {
+		return http.MethodDelete
+	}
+	return http.MethodPut
+}
+
+// PathComponents returns the URL path components after /_matrix/client/v3/pushrules/{scope}/
+// that are used for sending this change to the server.
+func (change *PushRuleChange) PathComponents() []any {
+	switch change.Type {
+	case ChangeEnabled, ChangeActions:
+		return []any{change.Kind, change.RuleID, string(change.Type)}
+	default:
+		return []any{change.Kind, change.RuleID}
+	}
+}
+
+// Query returns the query parameters used for sending this change to the server.
+func (change *PushRuleChange) Query() map[string]string {
+	query := make(map[string]string)
+	if change.Type == ChangePut {
+		if change.Before != "" {
+			query["before"] = change.Before
+		}
+		if change.After != "" {
+			query["after"] = change.After
+		}
+	}
+	return query
+}
+
+// Body returns the JSON request body used for sending this change to the server.
+func (change *PushRuleChange) Body() any {
+	switch change.Type {
+	case ChangePut:
+		actions := change.Rule.Actions
+		if actions == nil {
+			actions = PushActionArray{}
+		}
+		body := map[string]any{"actions": actions}
+		switch change.Kind {
+		case OverrideRule, UnderrideRule:
+			conditions := change.Rule.Conditions
+			if conditions == nil {
+				conditions = []*PushCondition{}
+			}
+			body["conditions"] = conditions
+		case ContentRule:
+			body["pattern"] = change.Rule.Pattern
+		}
+		return body
+	case ChangeEnabled:
+		return map[string]any{"enabled": change.Enabled}
+	case ChangeActions:
+		actions := change.Actions
+		if actions == nil {
+			actions = PushActionArray{}
+		}
+		return map[string]any{"actions": actions}
+	default:
+		return nil
+	}
+}
+
+func (change *PushRuleChange) String() string {
+	return fmt.Sprintf("%s %s/%s", change.Type, change.Kind, change.RuleID)
+}
+
+// Clone returns a deep copy of the ruleset.
+func (rs *PushRuleset) Clone() *PushRuleset {
+	if rs == nil {
+		return nil
+	}
+	data, err := json.Marshal(rs)
+	if err != nil {
+		panic(fmt.Errorf("failed to marshal push ruleset for cloning: %w", err))
+	}
+	var cloned PushRuleset
+	err = json.Unmarshal(data, &cloned)
+	if err != nil {
+		panic(fmt.Errorf("failed to unmarshal push ruleset for cloning: %w", err))
+	}
+	return &cloned
+}
+
+func (rs *PushRuleset) getArray(kind PushRuleType) *PushRuleArray {
+	switch kind {
+	case OverrideRule:
+		return &rs.Override
+	case ContentRule:
+		return &rs.Content
+	case UnderrideRule:
+		return &rs.Underride
+	default:
+		return nil
+	}
+}
+
+func (rs *PushRuleset) getMap(kind PushRuleType) *PushRuleMap {
+	switch kind {
+	case RoomRule:
+		return &rs.Room
+	case SenderRule:
+		return &rs.Sender
+	default:
+		return nil
+	}
+}
+
+// GetRule finds the rule with the given kind and ID.
+func (rs *PushRuleset) GetRule(kind PushRuleType, ruleID string) *PushRule {
+	if arr := rs.getArray(kind); arr != nil {
+		for _, rule := range *arr {
+			if rule.RuleID == ruleID {
+				return rule
+			}
+		}
+	} else if ruleMap := rs.getMap(kind); ruleMap != nil {
+		return ruleMap.Map[ruleID]
+	}
+	return nil
+}
+
+func (rs *PushRuleset) put(change *PushRuleChange) error {
+	rule := *change.Rule
+	rule.Type = change.Kind
+	rule.RuleID = change.RuleID
+	rule.Default = false
+	if existing := rs.GetRule(change.Kind, change.RuleID); existing != nil && existing.Default {
+		return ErrCannotModifyDefault
+	}
+	if ruleMap := rs.getMap(change.Kind); ruleMap != nil {
+		if ruleMap.Map == nil {
+			ruleMap.Map = make(map[string]*PushRule)
+			ruleMap.Type = change.Kind
+		}
+		ruleMap.Map[change.RuleID] = &rule
+		return nil
+	}
+	arr := rs.getArray(change.Kind)
+	if arr == nil {
+		return fmt.Errorf("unknown push rule kind %q", change.Kind)
+	}
+	*arr = slices.DeleteFunc(*arr, func(r *PushRule) bool {
+		return r.RuleID == change.RuleID
+	})
+	index := -1
+	if change.Before != "" || change.After != "" {
+		relativeTo := change.Before
+		if relativeTo == "" {
+			relativeTo = change.After
+		}
+		index = slices.IndexFunc(*arr, func(r *PushRule) bool {
+			return r.RuleID == relativeTo && !r.Default
+		})
+		if index < 0 {
+			return ErrInvalidRulePosition
+		} else if change.Before == "" {
+			index++
+		}
+	} else {
+		// New rules are the highest priority user-defined rules, but the master rule always stays at the top.
+		index = 0
+		if len(*arr) > 0 && (*arr)[0].RuleID == RuleMaster {
+			index = 1
+		}
+	}
+	*arr = slices.Insert(*arr, index, &rule)
+	return nil
+}
+
+func (rs *PushRuleset) delete(change *PushRuleChange) error {
+	existing := rs.GetRule(change.Kind, change.RuleID)
+	if existing == nil {
+		return ErrRuleNotFound
+	} else if existing.Default {
+		return ErrCannotModifyDefault
+	}
+	if ruleMap := rs.getMap(change.Kind); ruleMap != nil {
+		delete(ruleMap.Map, change.RuleID)
+	} else if arr := rs.getArray(change.Kind); arr != nil {
+		*arr = slices.DeleteFunc(*arr, func(r *PushRule) bool {
+			return r.RuleID == change.RuleID
+		})
+	}
+	return nil
+}
+
+// ApplyChange applies the given change to this ruleset in-place.
+func (rs *PushRuleset) ApplyChange(change *PushRuleChange) error {
+	switch change.Type {
+	case ChangePut:
+		return rs.put(change)
+	case ChangeDelete:
+		return rs.delete(change)
+	case ChangeEnabled, ChangeActions:
+		rule := rs.GetRule(change.Kind, change.RuleID)
+		if rule == nil {
+			return ErrRuleNotFound
+		}
+		if change.Type == ChangeEnabled {
+			rule.Enabled = change.Enabled
+		} else {
+			rule.Actions = change.Actions
+		}
+		return nil
+	default:
+		return ErrUnknownPushRuleChange
+	}
+}
+
+// AddRule creates a new user-defined rule (or replaces an existing one) and returns the change that was applied.
+// The before and after parameters are optional and may be used to position the rule relative to other user-defined rules.
+func (rs *PushRuleset) AddRule(kind PushRuleType, rule *PushRule, before, after string) (*PushRuleChange, error) {
+	change := &PushRuleChange{Type: ChangePut, Kind: kind, RuleID: rule.RuleID, Rule: rule, Before: before, After: after}
+	return change, rs.ApplyChange(change)
+}
+
+// DeleteRule deletes a user-defined rule and returns the change that was applied.
+func (rs *PushRuleset) DeleteRule(kind PushRuleType, ruleID string) (*PushRuleChange, error) {
+	change := &PushRuleChange{Type: ChangeDelete, Kind: kind, RuleID: ruleID}
+	return change, rs.ApplyChange(change)
+}
+
+// SetRuleEnabled enables or disables a rule and returns the change that was applied.
+func (rs *PushRuleset) SetRuleEnabled(kind PushRuleType, ruleID string, enabled bool) (*PushRuleChange, error) {
+	change := &PushRuleChange{Type: ChangeEnabled, Kind: kind, RuleID: ruleID, Enabled: enabled}
+	return change, rs.ApplyChange(change)
+}
+
+// SetRuleActions replaces the actions of a rule and returns the change that was applied.
+func (rs *PushRuleset) SetRuleActions(kind PushRuleType, ruleID string, actions PushActionArray) (*PushRuleChange, error) {
+	change := &PushRuleChange{Type: ChangeActions, Kind: kind, RuleID: ruleID, Actions: actions}
+	return change, rs.ApplyChange(change)
+}
+
+// jsonEqual compares two arrays by their JSON representation. Nil and empty arrays are considered equal,
+// as the server may return either one for a rule without actions or conditions.
+func jsonEqual[T any](a, b []T) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	dataA, errA := json.Marshal(a)
+	dataB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
+}
+
+// IsAppliedIn checks whether the effects of this change are visible in the given ruleset.
+func (change *PushRuleChange) IsAppliedIn(rs *PushRuleset) bool {
+	rule := rs.GetRule(change.Kind, change.RuleID)
+	switch change.Type {
+	case ChangePut:
+		return rule != nil &&
+			jsonEqual(rule.Actions, change.Rule.Actions) &&
+			jsonEqual(rule.Conditions, change.Rule.Conditions) &&
+			rule.Pattern == change.Rule.Pattern
+	case ChangeDelete:
+		return rule == nil
+	case ChangeEnabled:
+		return rule != nil && rule.Enabled == change.Enabled
+	case ChangeActions:
+		return rule != nil && jsonEqual(rule.Actions, change.Actions)
+	default:
+		return true
+	}
+}
+
+// SyncedRuleset keeps track of the push rules of an account, including local changes that
+// haven't been echoed back from the server in the m.push_rules account data event yet.
+//
+// Local changes are applied on top of every ruleset received from the server until the server
+// ruleset reflects the change, so that the local state doesn't temporarily revert when an
+// unrelated push rule update comes down sync before the local change has been processed.
+type SyncedRuleset struct {
+	lock     sync.RWMutex
+	server   *PushRuleset
+	local    *PushRuleset
+	compiled *CompiledRuleset
+	pending  []*PushRuleChange
+}
+
+// NewSyncedRuleset creates a new SyncedRuleset with the given initial server ruleset.
+func NewSyncedRuleset(initial *PushRuleset) *SyncedRuleset {
+	sr := &SyncedRuleset{}
+	sr.UpdateFromServer(initial)
+	return sr
+}
+
+func (sr *SyncedRuleset) rebuild() {
+	sr.local = sr.server.Clone()
+	if sr.local == nil {
+		sr.local = &PushRuleset{}
+	}
+	sr.pending = slices.DeleteFunc(sr.pending, func(change *PushRuleChange) bool {
+		if change.IsAppliedIn(sr.local) {
+			return true
+		}
+		// If the change can't be applied anymore (e.g. the rule was deleted on another device), drop it
+		return sr.local.ApplyChange(change) != nil
+	})
+	sr.compiled = sr.local.Compile()
+}
+
+// UpdateFromServer replaces the server ruleset, e.g. after receiving a m.push_rules account data event from sync.
+// Pending local changes that are now reflected in the server ruleset are removed from the pending list.
+func (sr *SyncedRuleset) UpdateFromServer(rs *PushRuleset) {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	sr.server = rs
+	sr.rebuild()
+}
+
+// HandleEvent updates the server ruleset from a m.push_rules account data event.
+// It can be registered as a sync event handler for event.AccountDataPushRules.
+func (sr *SyncedRuleset) HandleEvent(ctx context.Context, evt *event.Event) {
+	if evt.Type.Type != event.AccountDataPushRules.Type {
+		return
+	}
+	rs, err := EventToPushRules(evt)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to parse push rules from account data")
+		return
+	}
+	sr.UpdateFromServer(rs)
+}
+
+// Apply applies a local change and marks it as pending until the server ruleset reflects it.
+// The change should be sent to the server separately.
+func (sr *SyncedRuleset) Apply(change *PushRuleChange) error {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	// The current local ruleset may be in use by callers of Ruleset or Compiled, so apply the change to a copy.
+	local := sr.local.Clone()
+	err := local.ApplyChange(change)
+	if err != nil {
+		return err
+	}
+	sr.local = local
+	sr.pending = append(sr.pending, change)
+	sr.compiled = local.Compile()
+	return nil
+}
+
+// Revert removes a pending local change, e.g. if sending it to the server failed.
+func (sr *SyncedRuleset) Revert(change *PushRuleChange) {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	sr.pending = slices.DeleteFunc(sr.pending, func(c *PushRuleChange) bool {
+		return c == change
+	})
+	sr.rebuild()
+}
+
+// Pending returns the list of local changes that haven't been reflected in the server ruleset yet.
+func (sr *SyncedRuleset) Pending() []*PushRuleChange {
+	sr.lock.RLock()
+	defer sr.lock.RUnlock()
+	return slices.Clone(sr.pending)
+}
+
+// Ruleset returns the current local ruleset. The returned value must not be modified.
+func (sr *SyncedRuleset) Ruleset() *PushRuleset {
+	sr.lock.RLock()
+	defer sr.lock.RUnlock()
+	return sr.local
+}
+
+// Compiled returns the current local ruleset in compiled form.
+func (sr *SyncedRuleset) Compiled() *CompiledRuleset {
+	sr.lock.RLock()
+	defer sr.lock.RUnlock()
+	return sr.compiled
+}
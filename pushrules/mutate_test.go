@@ -0,0 +1,139 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pushrules_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/pushrules"
+)
+
+func TestPushRuleset_AddRule(t *testing.T) {
+	rs := pushrules.DefaultRuleset("@tulir:maunium.net")
+	change, err := rs.AddRule(pushrules.OverrideRule, &pushrules.PushRule{
+		RuleID:     "mute_foo",
+		Enabled:    true,
+		Conditions: []*pushrules.PushCondition{newMatchPushCondition("content.body", "foo")},
+	}, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, pushrules.RuleMaster, rs.Override[0].RuleID)
+	assert.Equal(t, "mute_foo", rs.Override[1].RuleID)
+	assert.Equal(t, http.MethodPut, change.Method())
+	assert.Equal(t, []any{pushrules.OverrideRule, "mute_foo"}, change.PathComponents())
+
+	_, err = rs.AddRule(pushrules.OverrideRule, &pushrules.PushRule{RuleID: "after_foo", Enabled: true}, "", "mute_foo")
+	require.NoError(t, err)
+	assert.Equal(t, "after_foo", rs.Override[2].RuleID)
+
+	_, err = rs.AddRule(pushrules.OverrideRule, &pushrules.PushRule{RuleID: "x"}, pushrules.RuleSuppressNotices, "")
+	assert.ErrorIs(t, err, pushrules.ErrInvalidRulePosition)
+
+	_, err = rs.DeleteRule(pushrules.OverrideRule, pushrules.RuleSuppressNotices)
+	assert.ErrorIs(t, err, pushrules.ErrCannotModifyDefault)
+	change, err = rs.DeleteRule(pushrules.OverrideRule, "mute_foo")
+	require.NoError(t, err)
+	assert.Equal(t, http.MethodDelete, change.Method())
+	assert.Nil(t, rs.GetRule(pushrules.OverrideRule, "mute_foo"))
+
+	_, err = rs.AddRule(pushrules.RoomRule, &pushrules.PushRule{RuleID: "!room:example.com", Enabled: true}, "", "")
+	require.NoError(t, err)
+	assert.NotNil(t, rs.GetRule(pushrules.RoomRule, "!room:example.com"))
+}
+
+func TestSyncedRuleset(t *testing.T) {
+	server := pushrules.DefaultRuleset("@tulir:maunium.net")
+	sr := pushrules.NewSyncedRuleset(server)
+	change := &pushrules.PushRuleChange{
+		Type:    pushrules.ChangeEnabled,
+		Kind:    pushrules.OverrideRule,
+		RuleID:  pushrules.RuleMaster,
+		Enabled: true,
+	}
+	require.NoError(t, sr.Apply(change))
+	assert.True(t, sr.Ruleset().GetRule(pushrules.OverrideRule, pushrules.RuleMaster).Enabled)
+	assert.Len(t, sr.Pending(), 1)
+
+	// An unrelated update from the server shouldn't revert the local change
+	sr.UpdateFromServer(pushrules.DefaultRuleset("@tulir:maunium.net"))
+	assert.True(t, sr.Ruleset().GetRule(pushrules.OverrideRule, pushrules.RuleMaster).Enabled)
+	assert.Len(t, sr.Pending(), 1)
+
+	// Once the server echoes the change, it's no longer pending
+	updated := pushrules.DefaultRuleset("@tulir:maunium.net")
+	updated.Override[0].Enabled = true
+	sr.UpdateFromServer(updated)
+	assert.Empty(t, sr.Pending())
+	assert.True(t, sr.Ruleset().GetRule(pushrules.OverrideRule, pushrules.RuleMaster).Enabled)
+}
+
+func TestSyncedRuleset_ApplyDoesntMutatePrevious(t *testing.T) {
+	sr := pushrules.NewSyncedRuleset(pushrules.DefaultRuleset("@tulir:maunium.net"))
+	previous := sr.Ruleset()
+	require.NoError(t, sr.Apply(&pushrules.PushRuleChange{
+		Type:    pushrules.ChangeEnabled,
+		Kind:    pushrules.OverrideRule,
+		RuleID:  pushrules.RuleMaster,
+		Enabled: true,
+	}))
+	assert.False(t, previous.GetRule(pushrules.OverrideRule, pushrules.RuleMaster).Enabled)
+	assert.True(t, sr.Ruleset().GetRule(pushrules.OverrideRule, pushrules.RuleMaster).Enabled)
+}
+
+func TestPushRuleChange_IsAppliedIn_EmptyArrays(t *testing.T) {
+	rs := pushrules.DefaultRuleset("@tulir:maunium.net")
+	_, err := rs.AddRule(pushrules.OverrideRule, &pushrules.PushRule{
+		RuleID:     "empty",
+		Enabled:    true,
+		Actions:    pushrules.PushActionArray{},
+		Conditions: []*pushrules.PushCondition{},
+	}, "", "")
+	require.NoError(t, err)
+	change := &pushrules.PushRuleChange{
+		Type:   pushrules.ChangePut,
+		Kind:   pushrules.OverrideRule,
+		RuleID: "empty",
+		Rule:   &pushrules.PushRule{RuleID: "empty"},
+	}
+	assert.True(t, change.IsAppliedIn(rs))
+	change.Rule.Actions = pushrules.PushActionArray{{Action: pushrules.ActionNotify}}
+	assert.False(t, change.IsAppliedIn(rs))
+}
+
+func TestSyncedRuleset_HandleEvent(t *testing.T) {
+	sr := pushrules.NewSyncedRuleset(nil)
+	sr.HandleEvent(context.Background(), &event.Event{
+		Type:    event.AccountDataPushRules,
+		Content: event.Content{VeryRaw: json.RawMessage(JSONExamplePushRules)},
+	})
+	assert.Len(t, sr.Ruleset().Content, 1)
+	assert.NotNil(t, sr.Compiled())
+}
+
+func TestPushRuleset_Keywords(t *testing.T) {
+	rs := pushrules.DefaultRuleset("@tulir:maunium.net")
+	assert.Empty(t, rs.GetKeywords())
+	change, err := rs.AddKeyword(" meow ")
+	require.NoError(t, err)
+	assert.Equal(t, []any{pushrules.ContentRule, "meow"}, change.PathComponents())
+	assert.Equal(t, []string{"meow"}, rs.GetKeywords())
+
+	evt := newFakeEvent(event.EventMessage, &event.MessageEventContent{MsgType: event.MsgText, Body: "hello meow"})
+	assert.True(t, rs.GetActions(blankTestRoom, evt).Should().Highlight)
+
+	_, err = rs.AddKeyword(".m.rule.contains_user_name")
+	assert.ErrorIs(t, err, pushrules.ErrInvalidKeyword)
+	_, err = rs.RemoveKeyword("meow")
+	require.NoError(t, err)
+	assert.Empty(t, rs.GetKeywords())
+}
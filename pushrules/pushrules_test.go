@@ -238,3 +238,18 @@ const JSONExamplePushRules = `{
     ]
   }
 }`
+
+func TestPushRuleset_UnknownRoundTrip(t *testing.T) {
+	input := `{"override":[{"rule_id":"com.example.rule","default":false,"enabled":true,` +
+		`"conditions":[{"kind":"com.example.condition","foo":{"bar":1}},{"kind":"event_match","key":"type","pattern":"m.room.message"}],` +
+		`"actions":["notify",{"com.example.action":true},{"set_tweak":"com.example.tweak","value":"meow"}]}],` +
+		`"content":[],"room":[],"sender":[],"underride":[]}`
+	var rs pushrules.PushRuleset
+	err := json.Unmarshal([]byte(input), &rs)
+	assert.NoError(t, err)
+	assert.False(t, rs.Override[0].Conditions[0].IsKnownKind())
+	assert.False(t, rs.Override[0].Match(newFakeRoom(2), newFakeEvent(event.EventMessage, &event.MessageEventContent{})))
+	output, err := json.Marshal(&rs)
+	assert.NoError(t, err)
+	assert.JSONEq(t, input, string(output))
+}
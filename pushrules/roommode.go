@@ -0,0 +1,119 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pushrules
+
+import (
+	"fmt"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// RoomNotificationMode is a high-level notification setting for a single room.
+//
+// The modes and their representation as push rules match what Element uses.
+type RoomNotificationMode string
+
+const (
+	// RoomModeAllMessagesLoud notifies for all messages with a sound.
+	// This is represented as a room rule with notify and sound actions.
+	RoomModeAllMessagesLoud RoomNotificationMode = "all_messages_loud"
+	// RoomModeAllMessages uses the account-wide default rules (i.e. there are no room-specific rules).
+	RoomModeAllMessages RoomNotificationMode = "all_messages"
+	// RoomModeMentionsAndKeywords only notifies for mentions and keywords.
+	// This is represented as a room rule with no actions.
+	RoomModeMentionsAndKeywords RoomNotificationMode = "mentions_and_keywords"
+	// RoomModeMute doesn't notify for anything.
+	// This is represented as an override rule that matches the room ID and has no actions.
+	RoomModeMute RoomNotificationMode = "mute"
+)
+
+func isRoomMuteRule(rule *PushRule, roomID id.RoomID) bool {
+	if rule.RuleID != string(roomID) || !rule.Enabled || len(rule.Conditions) != 1 || rule.Actions.Should().Notify {
+		return false
+	}
+	cond := rule.Conditions[0]
+	return cond.Kind == KindEventMatch && cond.Key == "room_id" && cond.Pattern == string(roomID)
+}
+
+// GetRoomNotificationMode returns the notification mode of the given room based on the push rules in this ruleset.
+func (rs *PushRuleset) GetRoomNotificationMode(roomID id.RoomID) RoomNotificationMode {
+	if rs == nil {
+		return RoomModeAllMessages
+	}
+	for _, rule := range rs.Override {
+		if isRoomMuteRule(rule, roomID) {
+			return RoomModeMute
+		}
+	}
+	roomRule := rs.Room.Map[string(roomID)]
+	if roomRule == nil || !roomRule.Enabled {
+		return RoomModeAllMessages
+	}
+	should := roomRule.Actions.Should()
+	if !should.Notify {
+		return RoomModeMentionsAndKeywords
+	} else if should.PlaySound {
+		return RoomModeAllMessagesLoud
+	}
+	return RoomModeAllMessages
+}
+
+// SetRoomNotificationMode applies the push rule changes needed to set the notification mode of the given room.
+//
+// The changes are applied to this ruleset and returned so that they can be sent to the server
+// (e.g. using mautrix.Client.ApplyPushRuleChange).
+func (rs *PushRuleset) SetRoomNotificationMode(roomID id.RoomID, mode RoomNotificationMode) ([]*PushRuleChange, error) {
+	var changes []*PushRuleChange
+	apply := func(change *PushRuleChange) error {
+		if err := rs.ApplyChange(change); err != nil {
+			return fmt.Errorf("failed to apply %s: %w", change, err)
+		}
+		changes = append(changes, change)
+		return nil
+	}
+	if existing := rs.GetRule(OverrideRule, string(roomID)); existing != nil && mode != RoomModeMute {
+		if err := apply(&PushRuleChange{Type: ChangeDelete, Kind: OverrideRule, RuleID: string(roomID)}); err != nil {
+			return changes, err
+		}
+	}
+	if existing := rs.GetRule(RoomRule, string(roomID)); existing != nil && mode != RoomModeAllMessagesLoud && mode != RoomModeMentionsAndKeywords {
+		if err := apply(&PushRuleChange{Type: ChangeDelete, Kind: RoomRule, RuleID: string(roomID)}); err != nil {
+			return changes, err
+		}
+	}
+	var err error
+	switch mode {
+	case RoomModeAllMessages:
+		// No rules needed, the existing ones were deleted above
+	case RoomModeAllMessagesLoud:
+		err = apply(&PushRuleChange{Type: ChangePut, Kind: RoomRule, RuleID: string(roomID), Rule: &PushRule{
+			Enabled: true,
+			Actions: PushActionArray{
+				{Action: ActionNotify},
+				{Action: ActionSetTweak, Tweak: TweakSound, Value: "default"},
+			},
+		}})
+	case RoomModeMentionsAndKeywords:
+		err = apply(&PushRuleChange{Type: ChangePut, Kind: RoomRule, RuleID: string(roomID), Rule: &PushRule{
+			Enabled: true,
+			Actions: PushActionArray{},
+		}})
+	case RoomModeMute:
+		err = apply(&PushRuleChange{Type: ChangePut, Kind: OverrideRule, RuleID: string(roomID), Rule: &PushRule{
+			Enabled: true,
+			Actions: PushActionArray{},
+			Conditions: []*PushCondition{{
+				Kind:    KindEventMatch,
+				Key:     "room_id",
+				Pattern: string(roomID),
+			}},
+		}})
+	default:
+		err = fmt.Errorf("unknown room notification mode %q", mode)
+	}
+	return changes, err
+}
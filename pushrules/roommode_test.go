@@ -0,0 +1,47 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pushrules_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/pushrules"
+)
+
+func TestPushRuleset_RoomNotificationMode(t *testing.T) {
+	rs := pushrules.DefaultRuleset("@tulir:maunium.net")
+	room := newFakeRoom(4)
+	evt := newFakeEvent(event.EventMessage, &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"})
+	roomID := evt.RoomID
+	assert.Equal(t, pushrules.RoomModeAllMessages, rs.GetRoomNotificationMode(roomID))
+
+	changes, err := rs.SetRoomNotificationMode(roomID, pushrules.RoomModeMute)
+	require.NoError(t, err)
+	assert.Len(t, changes, 1)
+	assert.Equal(t, pushrules.RoomModeMute, rs.GetRoomNotificationMode(roomID))
+	assert.False(t, rs.GetActions(room, evt).Should().Notify)
+
+	changes, err = rs.SetRoomNotificationMode(roomID, pushrules.RoomModeAllMessagesLoud)
+	require.NoError(t, err)
+	assert.Len(t, changes, 2)
+	assert.Equal(t, pushrules.RoomModeAllMessagesLoud, rs.GetRoomNotificationMode(roomID))
+	assert.True(t, rs.GetActions(room, evt).Should().PlaySound)
+
+	_, err = rs.SetRoomNotificationMode(roomID, pushrules.RoomModeMentionsAndKeywords)
+	require.NoError(t, err)
+	assert.Equal(t, pushrules.RoomModeMentionsAndKeywords, rs.GetRoomNotificationMode(roomID))
+	assert.False(t, rs.GetActions(room, evt).Should().Notify)
+
+	changes, err = rs.SetRoomNotificationMode(roomID, pushrules.RoomModeAllMessages)
+	require.NoError(t, err)
+	assert.Equal(t, []*pushrules.PushRuleChange{{Type: pushrules.ChangeDelete, Kind: pushrules.RoomRule, RuleID: string(roomID)}}, changes)
+	assert.Equal(t, pushrules.RoomModeAllMessages, rs.GetRoomNotificationMode(roomID))
+}
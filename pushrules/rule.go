@@ -132,15 +132,9 @@ func (rule *PushRule) GetActions() PushActionArray {
 }
 
 func (rule *PushRule) Match(room Room, evt *event.Event) bool {
-	if rule == nil || !rule.Enabled {
+	if rule == nil || !rule.Enabled || rule.isDisabledByMentions(evt) {
 		return false
 	}
-	if rule.RuleID == ".m.rule.contains_display_name" || rule.RuleID == ".m.rule.contains_user_name" || rule.RuleID == ".m.rule.roomnotif" {
-		if _, containsMentions := evt.Content.Raw["m.mentions"]; containsMentions {
-			// Disable legacy mention push rules when the event contains the new mentions key
-			return false
-		}
-	}
 	switch rule.Type {
 	case OverrideRule, UnderrideRule:
 		return rule.matchConditions(room, evt)
@@ -155,6 +149,15 @@ func (rule *PushRule) Match(room Room, evt *event.Event) bool {
 	}
 }
 
+func (rule *PushRule) isDisabledByMentions(evt *event.Event) bool {
+	if rule.RuleID == RuleContainsDisplayName || rule.RuleID == RuleContainsUserName || rule.RuleID == RuleRoomNotif {
+		// Disable legacy mention push rules when the event contains the new mentions key
+		_, containsMentions := evt.Content.Raw["m.mentions"]
+		return containsMentions
+	}
+	return false
+}
+
 func (rule *PushRule) matchConditions(room Room, evt *event.Event) bool {
 	for _, cond := range rule.Conditions {
 		if !cond.Match(room, evt) {
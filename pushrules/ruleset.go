@@ -98,3 +98,39 @@ func (rs *PushRuleset) GetActions(room Room, evt *event.Event) (match PushAction
 	}
 	return actions
 }
+
+// defaultRulesOnly returns a copy of this ruleset that only contains the server-default rules.
+func (rs *PushRuleset) defaultRulesOnly() *PushRuleset {
+	filter := func(rules PushRuleArray) (out PushRuleArray) {
+		for _, rule := range rules {
+			if rule.Default {
+				out = append(out, rule)
+			}
+		}
+		return
+	}
+	// Room and sender rules are never server-default rules, so they're left empty.
+	return &PushRuleset{
+		Override:  filter(rs.Override),
+		Content:   filter(rs.Content),
+		Underride: filter(rs.Underride),
+	}
+}
+
+// GetSound returns the name of the sound that should be played for the given event,
+// or an empty string if the event shouldn't make a sound.
+//
+// If the event matches a user-defined rule that notifies without specifying a sound,
+// the sound is resolved using the server-default rules in this ruleset. For example,
+// a keyword rule without a sound tweak will play the default sound in DMs,
+// because the .m.rule.room_one_to_one rule does.
+func (rs *PushRuleset) GetSound(room Room, evt *event.Event) string {
+	rule := rs.GetMatchingRule(room, evt)
+	should := rule.GetActions().Should()
+	if !should.Notify {
+		return ""
+	} else if should.PlaySound || rule == nil || rule.Default {
+		return should.SoundName
+	}
+	return rs.defaultRulesOnly().GetActions(room, evt).Should().SoundName
+}
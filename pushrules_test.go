@@ -0,0 +1,52 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/pushrules"
+)
+
+func TestClient_ApplySyncedPushRuleChanges(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /_matrix/client/v3/pushrules/global/content/{ruleID}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("ruleID") == "fail" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errcode": "M_INVALID_PARAM", "error": "nope"}`))
+			return
+		}
+		var rule pushrules.PushRule
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&rule))
+		assert.Equal(t, r.PathValue("ruleID"), rule.Pattern)
+		_, _ = w.Write([]byte(`{}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+
+	sr := pushrules.NewSyncedRuleset(pushrules.DefaultRuleset("@user:example.com"))
+	ok := &pushrules.PushRuleChange{Type: pushrules.ChangePut, Kind: pushrules.ContentRule, RuleID: "meow", Rule: &pushrules.PushRule{
+		Enabled: true, Pattern: "meow", Actions: pushrules.KeywordActions,
+	}}
+	fail := &pushrules.PushRuleChange{Type: pushrules.ChangePut, Kind: pushrules.ContentRule, RuleID: "fail", Rule: &pushrules.PushRule{
+		Enabled: true, Pattern: "fail", Actions: pushrules.KeywordActions,
+	}}
+	err = cli.ApplySyncedPushRuleChanges(context.Background(), sr, ok, fail)
+	assert.ErrorIs(t, err, mautrix.MInvalidParam)
+	assert.Equal(t, []string{"meow"}, sr.Ruleset().GetKeywords())
+	assert.Equal(t, []*pushrules.PushRuleChange{ok}, sr.Pending())
+}
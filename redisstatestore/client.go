@@ -0,0 +1,38 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package redisstatestore
+
+import (
+	"context"
+)
+
+// Client is the subset of Redis commands used by the state store.
+//
+// This package doesn't depend on any specific Redis library, so the client must be provided by the caller.
+// Each method corresponds to the Redis command of the same name, but the signatures don't match any library
+// exactly, so a small adapter is needed. For example, with go-redis, HGet must translate redis.Nil into
+// a false boolean, the Pipe methods must pass a context to the pipeliner, and Pipelined can be implemented
+// with redis.Client.Pipelined (or TxPipelined if writes should be atomic).
+type Client interface {
+	// HGet returns the value of the field in the hash. The boolean is false if the field or key doesn't exist.
+	HGet(ctx context.Context, key, field string) (string, bool, error)
+	// HGetAll returns all fields and values in the hash.
+	HGetAll(ctx context.Context, key string) (map[string]string, error)
+	// SMembers returns all members of the set.
+	SMembers(ctx context.Context, key string) ([]string, error)
+	// Pipelined queues all the writes done by fn and sends them in a single round trip.
+	Pipelined(ctx context.Context, fn func(pipe Pipe)) error
+}
+
+// Pipe is the set of write commands that can be queued inside Client.Pipelined.
+type Pipe interface {
+	Del(keys ...string)
+	HSet(key, field, value string)
+	HDel(key string, fields ...string)
+	SAdd(key string, members ...string)
+	SRem(key string, members ...string)
+}
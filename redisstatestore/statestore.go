@@ -0,0 +1,451 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package redisstatestore contains a state store backed by Redis, which allows sharing
+// state between multiple instances of a horizontally scaled bot or appservice.
+package redisstatestore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"go.mau.fi/util/confusable"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/appservice"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	fieldPowerLevels    = "power_levels"
+	fieldEncryption     = "encryption"
+	fieldMembersFetched = "members_fetched"
+)
+
+// RedisStateStore is a state store backed by Redis.
+//
+// Member lists are stored as hashes from user ID to member event content, other room state is stored in a hash
+// per room, and a set of rooms is stored for each user to implement FindSharedRooms.
+// All writes that touch multiple keys are pipelined.
+type RedisStateStore struct {
+	Client Client
+	// The prefix for all keys written by the store.
+	Prefix string
+	// An optional read-through cache for member and room state. Writes always go to both the cache and Redis,
+	// but writes made by other instances won't be visible in the cache, so the cache should only be used
+	// if a single instance is responsible for each room, or if slightly stale reads are acceptable.
+	Cache *mautrix.LRUStateStore
+
+	DisableNameDisambiguation bool
+}
+
+var _ mautrix.StateStore = (*RedisStateStore)(nil)
+var _ appservice.StateStore = (*RedisStateStore)(nil)
+
+// NewRedisStateStore creates a new state store using the given Redis client.
+func NewRedisStateStore(client Client, prefix string) *RedisStateStore {
+	return &RedisStateStore{
+		Client: client,
+		Prefix: prefix,
+	}
+}
+
+func (store *RedisStateStore) membersKey(roomID id.RoomID) string {
+	return fmt.Sprintf("%s:members:%s", store.Prefix, roomID)
+}
+
+func (store *RedisStateStore) nameSkeletonKey(roomID id.RoomID) string {
+	return fmt.Sprintf("%s:name_skeletons:%s", store.Prefix, roomID)
+}
+
+func (store *RedisStateStore) roomKey(roomID id.RoomID) string {
+	return fmt.Sprintf("%s:room:%s", store.Prefix, roomID)
+}
+
+func (store *RedisStateStore) userRoomsKey(userID id.UserID) string {
+	return fmt.Sprintf("%s:user_rooms:%s", store.Prefix, userID)
+}
+
+func (store *RedisStateStore) registrationsKey() string {
+	return fmt.Sprintf("%s:registrations", store.Prefix)
+}
+
+func (store *RedisStateStore) IsRegistered(ctx context.Context, userID id.UserID) (bool, error) {
+	_, found, err := store.Client.HGet(ctx, store.registrationsKey(), userID.String())
+	return found, err
+}
+
+func (store *RedisStateStore) MarkRegistered(ctx context.Context, userID id.UserID) error {
+	return store.Client.Pipelined(ctx, func(pipe Pipe) {
+		pipe.HSet(store.registrationsKey(), userID.String(), "1")
+	})
+}
+
+func (store *RedisStateStore) IsInRoom(ctx context.Context, roomID id.RoomID, userID id.UserID) bool {
+	return store.IsMembership(ctx, roomID, userID, event.MembershipJoin)
+}
+
+func (store *RedisStateStore) IsInvited(ctx context.Context, roomID id.RoomID, userID id.UserID) bool {
+	return store.IsMembership(ctx, roomID, userID, event.MembershipInvite, event.MembershipJoin)
+}
+
+func (store *RedisStateStore) IsMembership(ctx context.Context, roomID id.RoomID, userID id.UserID, allowedMemberships ...event.Membership) bool {
+	member, err := store.GetMember(ctx, roomID, userID)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(allowedMemberships, member.Membership)
+}
+
+func (store *RedisStateStore) GetMember(ctx context.Context, roomID id.RoomID, userID id.UserID) (*event.MemberEventContent, error) {
+	member, err := store.TryGetMember(ctx, roomID, userID)
+	if member == nil && err == nil {
+		member = &event.MemberEventContent{Membership: event.MembershipLeave}
+	}
+	return member, err
+}
+
+func (store *RedisStateStore) TryGetMember(ctx context.Context, roomID id.RoomID, userID id.UserID) (*event.MemberEventContent, error) {
+	if store.Cache != nil {
+		cached, _ := store.Cache.TryGetMember(ctx, roomID, userID)
+		if cached != nil {
+			return cached, nil
+		}
+	}
+	raw, found, err := store.Client.HGet(ctx, store.membersKey(roomID), userID.String())
+	if err != nil || !found {
+		return nil, err
+	}
+	var member event.MemberEventContent
+	err = json.Unmarshal([]byte(raw), &member)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal member content: %w", err)
+	}
+	if store.Cache != nil {
+		_ = store.Cache.SetMember(ctx, roomID, userID, &member)
+	}
+	return &member, nil
+}
+
+func (store *RedisStateStore) SetMembership(ctx context.Context, roomID id.RoomID, userID id.UserID, membership event.Membership) error {
+	member, err := store.TryGetMember(ctx, roomID, userID)
+	if err != nil {
+		return err
+	} else if member == nil {
+		member = &event.MemberEventContent{Membership: membership}
+	} else {
+		memberCopy := *member
+		memberCopy.Membership = membership
+		member = &memberCopy
+	}
+	return store.SetMember(ctx, roomID, userID, member)
+}
+
+func (store *RedisStateStore) queueSetMember(pipe Pipe, roomID id.RoomID, userID id.UserID, member *event.MemberEventContent) error {
+	data, err := json.Marshal(member)
+	if err != nil {
+		return fmt.Errorf("failed to marshal member content: %w", err)
+	}
+	pipe.HSet(store.membersKey(roomID), userID.String(), string(data))
+	if member.Membership == event.MembershipJoin || member.Membership == event.MembershipInvite {
+		pipe.SAdd(store.userRoomsKey(userID), roomID.String())
+	} else {
+		pipe.SRem(store.userRoomsKey(userID), roomID.String())
+	}
+	if !store.DisableNameDisambiguation && member.Displayname != "" {
+		skeleton := confusable.SkeletonHash(member.Displayname)
+		pipe.HSet(store.nameSkeletonKey(roomID), userID.String(), fmt.Sprintf("%x", skeleton))
+	} else {
+		pipe.HDel(store.nameSkeletonKey(roomID), userID.String())
+	}
+	return nil
+}
+
+func (store *RedisStateStore) SetMember(ctx context.Context, roomID id.RoomID, userID id.UserID, member *event.MemberEventContent) error {
+	var queueErr error
+	err := store.Client.Pipelined(ctx, func(pipe Pipe) {
+		queueErr = store.queueSetMember(pipe, roomID, userID, member)
+	})
+	if queueErr != nil {
+		return queueErr
+	} else if err != nil {
+		return err
+	}
+	if store.Cache != nil {
+		_ = store.Cache.SetMember(ctx, roomID, userID, member)
+	}
+	return nil
+}
+
+func (store *RedisStateStore) IsConfusableName(ctx context.Context, roomID id.RoomID, currentUser id.UserID, name string) ([]id.UserID, error) {
+	if store.DisableNameDisambiguation {
+		return nil, nil
+	}
+	skeletons, err := store.Client.HGetAll(ctx, store.nameSkeletonKey(roomID))
+	if err != nil {
+		return nil, err
+	}
+	target := fmt.Sprintf("%x", confusable.SkeletonHash(name))
+	var confusables []id.UserID
+	for userID, skeleton := range skeletons {
+		if skeleton == target && id.UserID(userID) != currentUser {
+			confusables = append(confusables, id.UserID(userID))
+		}
+	}
+	return confusables, nil
+}
+
+func (store *RedisStateStore) GetAllMembers(ctx context.Context, roomID id.RoomID) (map[id.UserID]*event.MemberEventContent, error) {
+	raw, err := store.Client.HGetAll(ctx, store.membersKey(roomID))
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[id.UserID]*event.MemberEventContent, len(raw))
+	for userID, data := range raw {
+		var member event.MemberEventContent
+		err = json.Unmarshal([]byte(data), &member)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal member content of %s: %w", userID, err)
+		}
+		members[id.UserID(userID)] = &member
+	}
+	return members, nil
+}
+
+func (store *RedisStateStore) GetRoomJoinedOrInvitedMembers(ctx context.Context, roomID id.RoomID) ([]id.UserID, error) {
+	members, err := store.GetAllMembers(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	userIDs := make([]id.UserID, 0, len(members))
+	for userID, member := range members {
+		if member.Membership == event.MembershipJoin || member.Membership == event.MembershipInvite {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	return userIDs, nil
+}
+
+func (store *RedisStateStore) ClearCachedMembers(ctx context.Context, roomID id.RoomID, memberships ...event.Membership) error {
+	// The members are needed even when clearing everything to remove the room from the user_rooms sets
+	members, err := store.GetAllMembers(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	var toDelete []string
+	for userID, member := range members {
+		if len(memberships) == 0 || slices.Contains(memberships, member.Membership) {
+			toDelete = append(toDelete, userID.String())
+		}
+	}
+	err = store.Client.Pipelined(ctx, func(pipe Pipe) {
+		store.queueClearMembers(pipe, roomID, toDelete, len(memberships) == 0)
+	})
+	if err != nil {
+		return err
+	}
+	if store.Cache != nil {
+		_ = store.Cache.ClearCachedMembers(ctx, roomID, memberships...)
+	}
+	return nil
+}
+
+func (store *RedisStateStore) queueClearMembers(pipe Pipe, roomID id.RoomID, userIDs []string, all bool) {
+	if all {
+		pipe.Del(store.membersKey(roomID), store.nameSkeletonKey(roomID))
+	} else if len(userIDs) > 0 {
+		pipe.HDel(store.membersKey(roomID), userIDs...)
+		pipe.HDel(store.nameSkeletonKey(roomID), userIDs...)
+	}
+	for _, userID := range userIDs {
+		pipe.SRem(store.userRoomsKey(id.UserID(userID)), roomID.String())
+	}
+	pipe.HDel(store.roomKey(roomID), fieldMembersFetched)
+}
+
+func (store *RedisStateStore) ReplaceCachedMembers(ctx context.Context, roomID id.RoomID, evts []*event.Event, onlyMemberships ...event.Membership) error {
+	existing, err := store.GetAllMembers(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	var toDelete []string
+	for userID, member := range existing {
+		if len(onlyMemberships) == 0 || slices.Contains(onlyMemberships, member.Membership) {
+			toDelete = append(toDelete, userID.String())
+		}
+	}
+	var queueErr error
+	err = store.Client.Pipelined(ctx, func(pipe Pipe) {
+		store.queueClearMembers(pipe, roomID, toDelete, false)
+		for _, evt := range evts {
+			content, ok := evt.Content.Parsed.(*event.MemberEventContent)
+			if !ok || evt.StateKey == nil {
+				continue
+			}
+			if queueErr = store.queueSetMember(pipe, roomID, id.UserID(*evt.StateKey), content); queueErr != nil {
+				return
+			}
+		}
+		if len(onlyMemberships) == 0 {
+			pipe.HSet(store.roomKey(roomID), fieldMembersFetched, "1")
+		}
+	})
+	if queueErr != nil {
+		return queueErr
+	} else if err != nil {
+		return err
+	}
+	if store.Cache != nil {
+		_ = store.Cache.ReplaceCachedMembers(ctx, roomID, evts, onlyMemberships...)
+	}
+	return nil
+}
+
+func (store *RedisStateStore) HasFetchedMembers(ctx context.Context, roomID id.RoomID) (bool, error) {
+	_, found, err := store.Client.HGet(ctx, store.roomKey(roomID), fieldMembersFetched)
+	return found, err
+}
+
+func (store *RedisStateStore) MarkMembersFetched(ctx context.Context, roomID id.RoomID) error {
+	return store.Client.Pipelined(ctx, func(pipe Pipe) {
+		pipe.HSet(store.roomKey(roomID), fieldMembersFetched, "1")
+	})
+}
+
+func (store *RedisStateStore) setRoomJSON(ctx context.Context, roomID id.RoomID, field string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", field, err)
+	}
+	return store.Client.Pipelined(ctx, func(pipe Pipe) {
+		pipe.HSet(store.roomKey(roomID), field, string(raw))
+	})
+}
+
+func (store *RedisStateStore) getRoomJSON(ctx context.Context, roomID id.RoomID, field string, into any) (bool, error) {
+	raw, found, err := store.Client.HGet(ctx, store.roomKey(roomID), field)
+	if err != nil || !found {
+		return false, err
+	}
+	err = json.Unmarshal([]byte(raw), into)
+	if err != nil {
+		return false, fmt.Errorf("failed to unmarshal %s: %w", field, err)
+	}
+	return true, nil
+}
+
+func (store *RedisStateStore) SetPowerLevels(ctx context.Context, roomID id.RoomID, levels *event.PowerLevelsEventContent) error {
+	err := store.setRoomJSON(ctx, roomID, fieldPowerLevels, levels)
+	if err == nil && store.Cache != nil {
+		_ = store.Cache.SetPowerLevels(ctx, roomID, levels)
+	}
+	return err
+}
+
+func (store *RedisStateStore) GetPowerLevels(ctx context.Context, roomID id.RoomID) (*event.PowerLevelsEventContent, error) {
+	if store.Cache != nil {
+		cached, _ := store.Cache.GetPowerLevels(ctx, roomID)
+		if cached != nil {
+			return cached, nil
+		}
+	}
+	var levels event.PowerLevelsEventContent
+	found, err := store.getRoomJSON(ctx, roomID, fieldPowerLevels, &levels)
+	if err != nil || !found {
+		return nil, err
+	}
+	if store.Cache != nil {
+		_ = store.Cache.SetPowerLevels(ctx, roomID, &levels)
+	}
+	return &levels, nil
+}
+
+// getPowerLevelsOrDefault returns the stored power levels of the room,
+// or empty power levels (i.e. the defaults from the spec) if they haven't been stored.
+func (store *RedisStateStore) getPowerLevelsOrDefault(ctx context.Context, roomID id.RoomID) (*event.PowerLevelsEventContent, error) {
+	levels, err := store.GetPowerLevels(ctx, roomID)
+	if err != nil {
+		return nil, err
+	} else if levels == nil {
+		levels = &event.PowerLevelsEventContent{}
+	}
+	return levels, nil
+}
+
+func (store *RedisStateStore) GetPowerLevel(ctx context.Context, roomID id.RoomID, userID id.UserID) (int, error) {
+	levels, err := store.getPowerLevelsOrDefault(ctx, roomID)
+	if err != nil {
+		return 0, err
+	}
+	return levels.GetUserLevel(userID), nil
+}
+
+func (store *RedisStateStore) GetPowerLevelRequirement(ctx context.Context, roomID id.RoomID, eventType event.Type) (int, error) {
+	levels, err := store.getPowerLevelsOrDefault(ctx, roomID)
+	if err != nil {
+		return 0, err
+	}
+	return levels.GetEventLevel(eventType), nil
+}
+
+func (store *RedisStateStore) HasPowerLevel(ctx context.Context, roomID id.RoomID, userID id.UserID, eventType event.Type) (bool, error) {
+	levels, err := store.getPowerLevelsOrDefault(ctx, roomID)
+	if err != nil {
+		return false, err
+	}
+	return levels.GetUserLevel(userID) >= levels.GetEventLevel(eventType), nil
+}
+
+func (store *RedisStateStore) SetEncryptionEvent(ctx context.Context, roomID id.RoomID, content *event.EncryptionEventContent) error {
+	err := store.setRoomJSON(ctx, roomID, fieldEncryption, content)
+	if err == nil && store.Cache != nil {
+		_ = store.Cache.SetEncryptionEvent(ctx, roomID, content)
+	}
+	return err
+}
+
+func (store *RedisStateStore) GetEncryptionEvent(ctx context.Context, roomID id.RoomID) (*event.EncryptionEventContent, error) {
+	if store.Cache != nil {
+		cached, _ := store.Cache.GetEncryptionEvent(ctx, roomID)
+		if cached != nil {
+			return cached, nil
+		}
+	}
+	var content event.EncryptionEventContent
+	found, err := store.getRoomJSON(ctx, roomID, fieldEncryption, &content)
+	if err != nil || !found {
+		return nil, err
+	}
+	if store.Cache != nil {
+		_ = store.Cache.SetEncryptionEvent(ctx, roomID, &content)
+	}
+	return &content, nil
+}
+
+func (store *RedisStateStore) IsEncrypted(ctx context.Context, roomID id.RoomID) (bool, error) {
+	cfg, err := store.GetEncryptionEvent(ctx, roomID)
+	return cfg != nil && cfg.Algorithm == id.AlgorithmMegolmV1, err
+}
+
+func (store *RedisStateStore) FindSharedRooms(ctx context.Context, userID id.UserID) ([]id.RoomID, error) {
+	rooms, err := store.Client.SMembers(ctx, store.userRoomsKey(userID))
+	if err != nil {
+		return nil, err
+	}
+	roomIDs := make([]id.RoomID, 0, len(rooms))
+	for _, roomID := range rooms {
+		encrypted, err := store.IsEncrypted(ctx, id.RoomID(roomID))
+		if err != nil {
+			return nil, err
+		} else if encrypted {
+			roomIDs = append(roomIDs, id.RoomID(roomID))
+		}
+	}
+	return roomIDs, nil
+}
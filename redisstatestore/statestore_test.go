@@ -0,0 +1,311 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package redisstatestore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// fakeClient is an in-memory implementation of Client. Pipelined writes are applied immediately.
+type fakeClient struct {
+	hashes    map[string]map[string]string
+	sets      map[string]map[string]struct{}
+	pipelines int
+}
+
+var _ Client = (*fakeClient)(nil)
+var _ Pipe = (*fakeClient)(nil)
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		hashes: make(map[string]map[string]string),
+		sets:   make(map[string]map[string]struct{}),
+	}
+}
+
+func (fc *fakeClient) HGet(_ context.Context, key, field string) (string, bool, error) {
+	val, ok := fc.hashes[key][field]
+	return val, ok, nil
+}
+
+func (fc *fakeClient) HGetAll(_ context.Context, key string) (map[string]string, error) {
+	out := make(map[string]string, len(fc.hashes[key]))
+	for field, val := range fc.hashes[key] {
+		out[field] = val
+	}
+	return out, nil
+}
+
+func (fc *fakeClient) SMembers(_ context.Context, key string) ([]string, error) {
+	out := make([]string, 0, len(fc.sets[key]))
+	for member := range fc.sets[key] {
+		out = append(out, member)
+	}
+	return out, nil
+}
+
+func (fc *fakeClient) Pipelined(_ context.Context, fn func(pipe Pipe)) error {
+	fc.pipelines++
+	fn(fc)
+	return nil
+}
+
+func (fc *fakeClient) Del(keys ...string) {
+	for _, key := range keys {
+		delete(fc.hashes, key)
+		delete(fc.sets, key)
+	}
+}
+
+func (fc *fakeClient) HSet(key, field, value string) {
+	if fc.hashes[key] == nil {
+		fc.hashes[key] = make(map[string]string)
+	}
+	fc.hashes[key][field] = value
+}
+
+func (fc *fakeClient) HDel(key string, fields ...string) {
+	for _, field := range fields {
+		delete(fc.hashes[key], field)
+	}
+}
+
+func (fc *fakeClient) SAdd(key string, members ...string) {
+	if fc.sets[key] == nil {
+		fc.sets[key] = make(map[string]struct{})
+	}
+	for _, member := range members {
+		fc.sets[key][member] = struct{}{}
+	}
+}
+
+func (fc *fakeClient) SRem(key string, members ...string) {
+	for _, member := range members {
+		delete(fc.sets[key], member)
+	}
+}
+
+const (
+	testRoomID  = id.RoomID("!room:example.com")
+	testRoomID2 = id.RoomID("!room2:example.com")
+	alice       = id.UserID("@alice:example.com")
+	bob         = id.UserID("@bob:example.com")
+)
+
+func memberEvent(userID id.UserID, membership event.Membership, displayname string) *event.Event {
+	stateKey := userID.String()
+	return &event.Event{
+		Type:     event.StateMember,
+		StateKey: &stateKey,
+		Content: event.Content{Parsed: &event.MemberEventContent{
+			Membership:  membership,
+			Displayname: displayname,
+		}},
+	}
+}
+
+func TestRedisStateStore_Members(t *testing.T) {
+	ctx := context.Background()
+	store := NewRedisStateStore(newFakeClient(), "test")
+
+	member, err := store.TryGetMember(ctx, testRoomID, alice)
+	require.NoError(t, err)
+	assert.Nil(t, member)
+	assert.False(t, store.IsInRoom(ctx, testRoomID, alice))
+
+	require.NoError(t, store.SetMember(ctx, testRoomID, alice, &event.MemberEventContent{Membership: event.MembershipJoin, Displayname: "Alice"}))
+	require.NoError(t, store.SetMembership(ctx, testRoomID, bob, event.MembershipInvite))
+	assert.True(t, store.IsInRoom(ctx, testRoomID, alice))
+	assert.False(t, store.IsInRoom(ctx, testRoomID, bob))
+	assert.True(t, store.IsInvited(ctx, testRoomID, bob))
+	member, err = store.GetMember(ctx, testRoomID, alice)
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", member.Displayname)
+
+	// Changing the membership keeps the rest of the member content
+	require.NoError(t, store.SetMembership(ctx, testRoomID, alice, event.MembershipLeave))
+	member, err = store.GetMember(ctx, testRoomID, alice)
+	require.NoError(t, err)
+	assert.Equal(t, event.MembershipLeave, member.Membership)
+	assert.Equal(t, "Alice", member.Displayname)
+
+	members, err := store.GetRoomJoinedOrInvitedMembers(ctx, testRoomID)
+	require.NoError(t, err)
+	assert.Equal(t, []id.UserID{bob}, members)
+}
+
+func TestRedisStateStore_ReplaceCachedMembers(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient()
+	store := NewRedisStateStore(client, "test")
+	require.NoError(t, store.SetMembership(ctx, testRoomID, alice, event.MembershipJoin))
+	require.NoError(t, store.SetMembership(ctx, testRoomID, bob, event.MembershipInvite))
+
+	fetched, err := store.HasFetchedMembers(ctx, testRoomID)
+	require.NoError(t, err)
+	assert.False(t, fetched)
+
+	pipelinesBefore := client.pipelines
+	require.NoError(t, store.ReplaceCachedMembers(ctx, testRoomID, []*event.Event{
+		memberEvent(bob, event.MembershipJoin, "Bob"),
+	}))
+	assert.Equal(t, pipelinesBefore+1, client.pipelines, "all writes should be in a single pipeline")
+	fetched, err = store.HasFetchedMembers(ctx, testRoomID)
+	require.NoError(t, err)
+	assert.True(t, fetched)
+
+	members, err := store.GetAllMembers(ctx, testRoomID)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, "Bob", members[bob].Displayname)
+	assert.Empty(t, client.sets[store.userRoomsKey(alice)])
+	assert.Contains(t, client.sets[store.userRoomsKey(bob)], testRoomID.String())
+
+	require.NoError(t, store.ClearCachedMembers(ctx, testRoomID, event.MembershipJoin))
+	members, err = store.GetAllMembers(ctx, testRoomID)
+	require.NoError(t, err)
+	assert.Empty(t, members)
+	assert.Empty(t, client.sets[store.userRoomsKey(bob)])
+	fetched, err = store.HasFetchedMembers(ctx, testRoomID)
+	require.NoError(t, err)
+	assert.False(t, fetched)
+}
+
+func TestRedisStateStore_ClearAllCachedMembers(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient()
+	store := NewRedisStateStore(client, "test")
+	require.NoError(t, store.SetEncryptionEvent(ctx, testRoomID, &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1}))
+	require.NoError(t, store.SetMembership(ctx, testRoomID, alice, event.MembershipJoin))
+	require.NoError(t, store.SetMembership(ctx, testRoomID, bob, event.MembershipInvite))
+
+	require.NoError(t, store.ClearCachedMembers(ctx, testRoomID))
+	members, err := store.GetAllMembers(ctx, testRoomID)
+	require.NoError(t, err)
+	assert.Empty(t, members)
+	// Clearing all members must also remove the room from the reverse index
+	rooms, err := store.FindSharedRooms(ctx, alice)
+	require.NoError(t, err)
+	assert.Empty(t, rooms)
+	assert.Empty(t, client.sets[store.userRoomsKey(bob)])
+}
+
+func TestRedisStateStore_IsConfusableName(t *testing.T) {
+	ctx := context.Background()
+	store := NewRedisStateStore(newFakeClient(), "test")
+	require.NoError(t, store.SetMember(ctx, testRoomID, alice, &event.MemberEventContent{Membership: event.MembershipJoin, Displayname: "Alice"}))
+	require.NoError(t, store.SetMember(ctx, testRoomID, bob, &event.MemberEventContent{Membership: event.MembershipJoin, Displayname: "Bob"}))
+
+	confusables, err := store.IsConfusableName(ctx, testRoomID, bob, "A1ice")
+	require.NoError(t, err)
+	assert.Equal(t, []id.UserID{alice}, confusables)
+	confusables, err = store.IsConfusableName(ctx, testRoomID, alice, "Alice")
+	require.NoError(t, err)
+	assert.Empty(t, confusables)
+
+	// Removing the displayname removes the skeleton
+	require.NoError(t, store.SetMember(ctx, testRoomID, alice, &event.MemberEventContent{Membership: event.MembershipJoin}))
+	confusables, err = store.IsConfusableName(ctx, testRoomID, bob, "Alice")
+	require.NoError(t, err)
+	assert.Empty(t, confusables)
+}
+
+func TestRedisStateStore_PowerLevels(t *testing.T) {
+	ctx := context.Background()
+	store := NewRedisStateStore(newFakeClient(), "test")
+
+	levels, err := store.GetPowerLevels(ctx, testRoomID)
+	require.NoError(t, err)
+	assert.Nil(t, levels)
+	// Missing power levels must fall back to the defaults instead of panicking
+	level, err := store.GetPowerLevel(ctx, testRoomID, alice)
+	require.NoError(t, err)
+	assert.Equal(t, 0, level)
+	requirement, err := store.GetPowerLevelRequirement(ctx, testRoomID, event.StateRoomName)
+	require.NoError(t, err)
+	assert.Equal(t, 50, requirement)
+	hasLevel, err := store.HasPowerLevel(ctx, testRoomID, alice, event.EventMessage)
+	require.NoError(t, err)
+	assert.True(t, hasLevel)
+
+	require.NoError(t, store.SetPowerLevels(ctx, testRoomID, &event.PowerLevelsEventContent{
+		Users:         map[id.UserID]int{alice: 100},
+		EventsDefault: 10,
+	}))
+	level, err = store.GetPowerLevel(ctx, testRoomID, alice)
+	require.NoError(t, err)
+	assert.Equal(t, 100, level)
+	hasLevel, err = store.HasPowerLevel(ctx, testRoomID, bob, event.EventMessage)
+	require.NoError(t, err)
+	assert.False(t, hasLevel)
+}
+
+func TestRedisStateStore_FindSharedRooms(t *testing.T) {
+	ctx := context.Background()
+	store := NewRedisStateStore(newFakeClient(), "test")
+	require.NoError(t, store.SetEncryptionEvent(ctx, testRoomID, &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1}))
+	require.NoError(t, store.SetMembership(ctx, testRoomID, alice, event.MembershipJoin))
+	require.NoError(t, store.SetMembership(ctx, testRoomID2, alice, event.MembershipJoin))
+
+	encrypted, err := store.IsEncrypted(ctx, testRoomID)
+	require.NoError(t, err)
+	assert.True(t, encrypted)
+	// Only encrypted rooms are returned
+	rooms, err := store.FindSharedRooms(ctx, alice)
+	require.NoError(t, err)
+	assert.Equal(t, []id.RoomID{testRoomID}, rooms)
+
+	require.NoError(t, store.SetMembership(ctx, testRoomID, alice, event.MembershipLeave))
+	rooms, err = store.FindSharedRooms(ctx, alice)
+	require.NoError(t, err)
+	assert.Empty(t, rooms)
+}
+
+func TestRedisStateStore_Cache(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient()
+	store := NewRedisStateStore(client, "test")
+	store.Cache = mautrix.NewLRUStateStore(10, 10)
+	require.NoError(t, store.SetMembership(ctx, testRoomID, alice, event.MembershipJoin))
+
+	// Writes go to both Redis and the cache
+	assert.Contains(t, client.hashes[store.membersKey(testRoomID)], alice.String())
+	cached, err := store.Cache.TryGetMember(ctx, testRoomID, alice)
+	require.NoError(t, err)
+	require.NotNil(t, cached)
+
+	// Reads are served from the cache when possible
+	delete(client.hashes, store.membersKey(testRoomID))
+	assert.True(t, store.IsInRoom(ctx, testRoomID, alice))
+
+	// Values read from Redis are stored in the cache
+	require.NoError(t, NewRedisStateStore(client, "test").SetMembership(ctx, testRoomID, bob, event.MembershipJoin))
+	assert.True(t, store.IsInRoom(ctx, testRoomID, bob))
+	cached, err = store.Cache.TryGetMember(ctx, testRoomID, bob)
+	require.NoError(t, err)
+	assert.NotNil(t, cached)
+}
+
+func TestRedisStateStore_Registrations(t *testing.T) {
+	ctx := context.Background()
+	store := NewRedisStateStore(newFakeClient(), "test")
+	registered, err := store.IsRegistered(ctx, alice)
+	require.NoError(t, err)
+	assert.False(t, registered)
+	require.NoError(t, store.MarkRegistered(ctx, alice))
+	registered, err = store.IsRegistered(ctx, alice)
+	require.NoError(t, err)
+	assert.True(t, registered)
+}
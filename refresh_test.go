@@ -0,0 +1,101 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+)
+
+func newRefreshTestClient(t *testing.T) (*mautrix.Client, *int) {
+	var refreshCount int
+	var lock sync.Mutex
+	validToken := "token1"
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /_matrix/client/v3/refresh", func(w http.ResponseWriter, r *http.Request) {
+		var req mautrix.ReqRefresh
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		lock.Lock()
+		defer lock.Unlock()
+		if req.RefreshToken != "refresh1" {
+			mautrix.MUnknownToken.WithMessage("Invalid refresh token").Write(w)
+			return
+		}
+		refreshCount++
+		validToken = "token2"
+		_ = json.NewEncoder(w).Encode(&mautrix.RespRefresh{AccessToken: "token2", RefreshToken: "refresh2", ExpiresInMS: 300000})
+	})
+	mux.HandleFunc("GET /_matrix/client/v3/account/whoami", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if r.Header.Get("Authorization") != "Bearer "+validToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"errcode":"M_UNKNOWN_TOKEN","error":"Access token has expired","soft_logout":true}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"user_id":"@user:example.com"}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@user:example.com", "token0")
+	require.NoError(t, err)
+	cli.RefreshToken = "refresh1"
+	return cli, &refreshCount
+}
+
+func TestClient_RefreshOnSoftLogout(t *testing.T) {
+	cli, refreshCount := newRefreshTestClient(t)
+	var refreshed *mautrix.RespRefresh
+	cli.OnTokensRefreshed = func(ctx context.Context, resp *mautrix.RespRefresh) {
+		refreshed = resp
+	}
+	resp, err := cli.Whoami(context.Background())
+	require.NoError(t, err)
+	assert.EqualValues(t, "@user:example.com", resp.UserID)
+	assert.Equal(t, 1, *refreshCount)
+	accessToken, refreshToken, expiresAt := cli.GetTokens()
+	assert.Equal(t, "token2", accessToken)
+	assert.Equal(t, "refresh2", refreshToken)
+	assert.False(t, expiresAt.IsZero())
+	require.NotNil(t, refreshed)
+	assert.Equal(t, "token2", refreshed.AccessToken)
+}
+
+func TestClient_RefreshBeforeExpiry(t *testing.T) {
+	cli, refreshCount := newRefreshTestClient(t)
+	cli.SetTokens("token1", "refresh1", time.Now().Add(5*time.Second))
+	_, err := cli.Whoami(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, *refreshCount)
+	assert.Equal(t, "token2", cli.GetAccessToken())
+}
+
+func TestClient_RefreshConcurrent(t *testing.T) {
+	cli, refreshCount := newRefreshTestClient(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cli.Whoami(context.Background())
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, *refreshCount)
+	assert.Equal(t, "token2", cli.GetAccessToken())
+}
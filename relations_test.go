@@ -0,0 +1,76 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func newRelationsTestClient(t *testing.T) *mautrix.Client {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /_matrix/client/v1/rooms/{roomID}/relations/{eventID}/{relType}/{eventType}", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "$root", r.PathValue("eventID"))
+		assert.Equal(t, "m.thread", r.PathValue("relType"))
+		assert.Equal(t, "m.room.message", r.PathValue("eventType"))
+		assert.Equal(t, "true", r.URL.Query().Get("recurse"))
+		assert.Equal(t, "b", r.URL.Query().Get("dir"))
+		_, _ = w.Write([]byte(`{"chunk": [{"event_id": "$reply", "type": "m.room.message", "content": {}}], "next_batch": "next", "recursion_depth": 1}`))
+	})
+	mux.HandleFunc("GET /_matrix/client/v1/rooms/{roomID}/threads", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "participated", r.URL.Query().Get("include"))
+		_, _ = w.Write([]byte(`{"chunk": [{"event_id": "$root", "type": "m.room.message", "content": {}, "unsigned": {"m.relations": {
+			"m.thread": {"latest_event": {"event_id": "$reply", "type": "m.room.message", "content": {}}, "count": 3, "current_user_participated": true}
+		}}}]}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+	return cli
+}
+
+func TestClient_GetRelations(t *testing.T) {
+	cli := newRelationsTestClient(t)
+	resp, err := cli.GetRelations(context.Background(), "!room:example.com", "$root", &mautrix.ReqGetRelations{
+		RelationType: event.RelThread,
+		EventType:    event.EventMessage,
+		Dir:          mautrix.DirectionBackward,
+		Recurse:      true,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Chunk, 1)
+	assert.Equal(t, id.EventID("$reply"), resp.Chunk[0].ID)
+	assert.Equal(t, "next", resp.NextBatch)
+	assert.Equal(t, 1, resp.RecursionDepth)
+}
+
+func TestClient_GetThreads(t *testing.T) {
+	cli := newRelationsTestClient(t)
+	resp, err := cli.GetThreads(context.Background(), "!room:example.com", &mautrix.ReqGetThreads{Include: mautrix.ThreadIncludeParticipated})
+	require.NoError(t, err)
+	require.Len(t, resp.Chunk, 1)
+	summary := resp.Chunk[0].Unsigned.Relations.Thread
+	require.NotNil(t, summary)
+	assert.Equal(t, 3, summary.Count)
+	assert.True(t, summary.CurrentUserParticipated)
+	assert.Equal(t, id.EventID("$reply"), summary.LatestEvent.ID)
+
+	data, err := json.Marshal(resp.Chunk[0].Unsigned.Relations)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"m.thread": {"latest_event": {"event_id": "$reply", "type": "m.room.message", "content": {}}, "count": 3, "current_user_participated": true}}`, string(data))
+}
@@ -23,6 +23,8 @@ const (
 	AuthTypeDummy      AuthType = "m.login.dummy"
 	AuthTypeAppservice AuthType = "m.login.application_service"
 
+	AuthTypeRegistrationToken AuthType = "m.login.registration_token"
+
 	AuthTypeSynapseJWT AuthType = "org.matrix.login.jwt"
 
 	AuthTypeDevtureSharedSecret AuthType = "com.devture.shared_secret_auth"
@@ -75,6 +77,40 @@ type UserIdentifier struct {
 	Phone   string `json:"phone,omitempty"`
 }
 
+type ThumbnailMethod string
+
+const (
+	ThumbnailMethodCrop  ThumbnailMethod = "crop"
+	ThumbnailMethodScale ThumbnailMethod = "scale"
+)
+
+// ReqThumbnail contains the query parameters for https://spec.matrix.org/v1.11/client-server-api/#get_matrixclientv1mediathumbnailservernamemediaid
+type ReqThumbnail struct {
+	Width    int
+	Height   int
+	Method   ThumbnailMethod
+	Animated bool
+}
+
+func (req *ReqThumbnail) query() map[string]string {
+	query := map[string]string{
+		"width":  strconv.Itoa(req.Width),
+		"height": strconv.Itoa(req.Height),
+	}
+	if req.Method != "" {
+		query["method"] = string(req.Method)
+	}
+	if req.Animated {
+		query["animated"] = "true"
+	}
+	return query
+}
+
+// ReqRefresh is the JSON request for https://spec.matrix.org/v1.11/client-server-api/#post_matrixclientv3refresh
+type ReqRefresh struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 // ReqLogin is the JSON request for https://spec.matrix.org/v1.2/client-server-api/#post_matrixclientv3login
 type ReqLogin struct {
 	Type                     AuthType       `json:"type"`
@@ -150,6 +186,11 @@ type ReqJoinRoom struct {
 	ThirdPartySigned any      `json:"third_party_signed,omitempty"`
 }
 
+type ReqKnockRoom struct {
+	Via    []string `json:"-"`
+	Reason string   `json:"reason,omitempty"`
+}
+
 type ReqMutualRooms struct {
 	From string `json:"-"`
 }
@@ -242,8 +283,9 @@ func (otk *OneTimeKey) MarshalJSON() ([]byte, error) {
 }
 
 type ReqUploadKeys struct {
-	DeviceKeys  *DeviceKeys             `json:"device_keys,omitempty"`
-	OneTimeKeys map[id.KeyID]OneTimeKey `json:"one_time_keys,omitempty"`
+	DeviceKeys   *DeviceKeys             `json:"device_keys,omitempty"`
+	OneTimeKeys  map[id.KeyID]OneTimeKey `json:"one_time_keys,omitempty"`
+	FallbackKeys map[id.KeyID]OneTimeKey `json:"fallback_keys,omitempty"`
 }
 
 type ReqKeysSignatures struct {
@@ -351,6 +393,14 @@ type ReqPutPushRule struct {
 	Pattern    string                     `json:"pattern"`
 }
 
+type ReqSetPushRuleEnabled struct {
+	Enabled bool `json:"enabled"`
+}
+
+type ReqSetPushRuleActions struct {
+	Actions pushrules.PushActionArray `json:"actions"`
+}
+
 // Deprecated: MSC2716 was abandoned
 type ReqBatchSend struct {
 	PrevEventID id.EventID `json:"-"`
@@ -436,6 +486,88 @@ func (req *ReqHierarchy) Query() map[string]string {
 	return query
 }
 
+// ReqGetRelations contains the parameters for https://spec.matrix.org/v1.13/client-server-api/#get_matrixclientv1roomsroomidrelationseventid
+//
+// As it's a GET method, there is no JSON body, so this is only query parameters and path components.
+type ReqGetRelations struct {
+	// Only return events with this relation type. Required if EventType is set.
+	RelationType event.RelationType
+	// Only return events with this event type.
+	EventType event.Type
+
+	Dir   Direction
+	From  string
+	To    string
+	Limit int
+	// Whether to also include events which relate indirectly to the given event (e.g. reactions to thread replies).
+	Recurse bool
+}
+
+// PathSuffix returns the optional relation type and event type path components.
+func (rgr *ReqGetRelations) PathSuffix() ClientURLPath {
+	if rgr == nil || rgr.RelationType == "" {
+		return ClientURLPath{}
+	} else if rgr.EventType.Type == "" {
+		return ClientURLPath{rgr.RelationType}
+	}
+	return ClientURLPath{rgr.RelationType, rgr.EventType.Type}
+}
+
+func (rgr *ReqGetRelations) Query() map[string]string {
+	query := map[string]string{}
+	if rgr == nil {
+		return query
+	}
+	if rgr.Dir != 0 {
+		query["dir"] = string(rgr.Dir)
+	}
+	if rgr.From != "" {
+		query["from"] = rgr.From
+	}
+	if rgr.To != "" {
+		query["to"] = rgr.To
+	}
+	if rgr.Limit > 0 {
+		query["limit"] = strconv.Itoa(rgr.Limit)
+	}
+	if rgr.Recurse {
+		query["recurse"] = "true"
+	}
+	return query
+}
+
+// ThreadInclude is the value of the include parameter for the thread list endpoint.
+type ThreadInclude string
+
+const (
+	ThreadIncludeAll          ThreadInclude = "all"
+	ThreadIncludeParticipated ThreadInclude = "participated"
+)
+
+// ReqGetThreads contains the parameters for https://spec.matrix.org/v1.13/client-server-api/#get_matrixclientv1roomsroomidthreads
+type ReqGetThreads struct {
+	From    string
+	Include ThreadInclude
+	Limit   int
+}
+
+func (rgt *ReqGetThreads) Query() map[string]string {
+	query := map[string]string{}
+	if rgt == nil {
+		return query
+	}
+	if rgt.From != "" {
+		query["from"] = rgt.From
+	}
+	if rgt.Include != "" {
+		query["include"] = string(rgt.Include)
+	}
+	if rgt.Limit > 0 {
+		query["limit"] = strconv.Itoa(rgt.Limit)
+	}
+	return query
+}
+
 type ReqAppservicePing struct {
 	TxnID string `json:"transaction_id,omitempty"`
 }
@@ -0,0 +1,163 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by requests that weren't sent because the circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open, homeserver appears to be down")
+
+// RetryPolicy configures how failed HTTP requests are retried.
+//
+// Requests are retried if they fail entirely, return a gateway error (502-504),
+// or are rate limited (429, unless [Client.IgnoreRateLimit] is set).
+type RetryPolicy struct {
+	// EndpointRetries overrides [Client.DefaultHTTPRetries] for requests whose URL path starts with the key,
+	// e.g. "/_matrix/client/v3/sync". If multiple prefixes match, the longest one is used.
+	// Requests that set [FullRequest.MaxAttempts] explicitly are not affected.
+	EndpointRetries map[string]int
+	// MaxBackoff caps the exponential backoff between retries. Zero means no limit.
+	// Delays requested by the server using Retry-After or retry_after_ms are not capped.
+	MaxBackoff time.Duration
+	// Jitter is the maximum fraction of the backoff that is randomly added to each delay, e.g. 0.2 for up to 20%.
+	Jitter float64
+	// OnRetry is called before waiting to retry a request.
+	OnRetry func(req *http.Request, cause error, retriesLeft int, backoff time.Duration)
+	// CircuitBreaker makes requests fail fast with ErrCircuitOpen when the homeserver appears to be down.
+	CircuitBreaker *CircuitBreaker
+}
+
+func (rp *RetryPolicy) getEndpointRetries(path string) (retries int, ok bool) {
+	var longestPrefix int
+	for prefix, prefixRetries := range rp.EndpointRetries {
+		if strings.HasPrefix(path, prefix) && (!ok || len(prefix) > longestPrefix) {
+			retries, ok, longestPrefix = prefixRetries, true, len(prefix)
+		}
+	}
+	return
+}
+
+func (rp *RetryPolicy) nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if rp != nil && rp.MaxBackoff > 0 {
+		backoff = min(backoff, rp.MaxBackoff)
+	}
+	return backoff
+}
+
+func (rp *RetryPolicy) addJitter(backoff time.Duration) time.Duration {
+	if rp == nil || rp.Jitter <= 0 || backoff <= 0 {
+		return backoff
+	}
+	return backoff + time.Duration(rand.Int64N(int64(float64(backoff)*rp.Jitter)+1))
+}
+
+func (rp *RetryPolicy) getCircuitBreaker() *CircuitBreaker {
+	if rp == nil {
+		return nil
+	}
+	return rp.CircuitBreaker
+}
+
+// parseRetryAfterMS reads the retry_after_ms field from a M_LIMIT_EXCEEDED error response.
+func parseRetryAfterMS(res *http.Response) (time.Duration, bool) {
+	var body struct {
+		RetryAfterMS int64 `json:"retry_after_ms"`
+	}
+	if res.Body == nil || json.NewDecoder(res.Body).Decode(&body) != nil || body.RetryAfterMS <= 0 {
+		return 0, false
+	}
+	return time.Duration(body.RetryAfterMS) * time.Millisecond, true
+}
+
+// CircuitBreaker tracks consecutive request failures and stops sending requests to the homeserver
+// for a while after too many of them.
+//
+// After ResetTimeout, a single trial request is let through. If it succeeds, the circuit is closed again,
+// otherwise it stays open for another ResetTimeout.
+type CircuitBreaker struct {
+	// FailureThreshold is the number of consecutive failed requests after which the circuit opens.
+	FailureThreshold int
+	// ResetTimeout is how long the circuit stays open before a trial request is allowed.
+	ResetTimeout time.Duration
+	// OnStateChange is called when the circuit opens or closes.
+	OnStateChange func(open bool)
+
+	lock     sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+	trialing bool
+}
+
+// IsOpen returns true if requests are currently being rejected.
+func (cb *CircuitBreaker) IsOpen() bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	return cb.open
+}
+
+// Allow checks whether a request may be sent. It returns ErrCircuitOpen if the circuit is open.
+func (cb *CircuitBreaker) Allow() error {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if !cb.open {
+		return nil
+	} else if !cb.trialing && time.Since(cb.openedAt) >= cb.ResetTimeout {
+		cb.trialing = true
+		return nil
+	}
+	return ErrCircuitOpen
+}
+
+// RecordSuccess marks a request as successful, which closes the circuit.
+func (cb *CircuitBreaker) RecordSuccess() {
+	cb.lock.Lock()
+	wasOpen := cb.open
+	cb.failures = 0
+	cb.open = false
+	cb.trialing = false
+	cb.lock.Unlock()
+	if wasOpen && cb.OnStateChange != nil {
+		cb.OnStateChange(false)
+	}
+}
+
+// RecordCanceled marks a request as canceled by the caller. Canceled requests don't count as failures
+// or successes, but a canceled trial request lets the next request through as a new trial.
+func (cb *CircuitBreaker) RecordCanceled() {
+	cb.lock.Lock()
+	cb.trialing = false
+	cb.lock.Unlock()
+}
+
+// RecordFailure marks a request as failed, which opens the circuit if the failure threshold is reached.
+func (cb *CircuitBreaker) RecordFailure() {
+	cb.lock.Lock()
+	cb.failures++
+	cb.trialing = false
+	opened := false
+	if cb.open {
+		cb.openedAt = time.Now()
+	} else if cb.FailureThreshold > 0 && cb.failures >= cb.FailureThreshold {
+		cb.open = true
+		cb.openedAt = time.Now()
+		opened = true
+	}
+	cb.lock.Unlock()
+	if opened && cb.OnStateChange != nil {
+		cb.OnStateChange(true)
+	}
+}
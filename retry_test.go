@@ -0,0 +1,118 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+)
+
+func newRetryTestClient(t *testing.T, handler http.HandlerFunc) *mautrix.Client {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+	return cli
+}
+
+func TestRetryPolicy_RetryAfterMS(t *testing.T) {
+	var requests atomic.Int32
+	cli := newRetryTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"errcode":"M_LIMIT_EXCEEDED","error":"Too many requests","retry_after_ms":10}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"user_id":"@user:example.com"}`))
+	})
+	var retryBackoff time.Duration
+	cli.RetryPolicy = &mautrix.RetryPolicy{
+		EndpointRetries: map[string]int{"/_matrix/client/v3/account/whoami": 1},
+		OnRetry: func(req *http.Request, cause error, retriesLeft int, backoff time.Duration) {
+			retryBackoff = backoff
+		},
+	}
+	resp, err := cli.Whoami(context.Background())
+	require.NoError(t, err)
+	assert.EqualValues(t, "@user:example.com", resp.UserID)
+	assert.EqualValues(t, 2, requests.Load())
+	assert.Equal(t, 10*time.Millisecond, retryBackoff)
+}
+
+func TestRetryPolicy_CircuitBreaker(t *testing.T) {
+	var requests atomic.Int32
+	cli := newRetryTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	var stateChanges []bool
+	cli.RetryPolicy = &mautrix.RetryPolicy{
+		CircuitBreaker: &mautrix.CircuitBreaker{
+			FailureThreshold: 2,
+			ResetTimeout:     time.Hour,
+			OnStateChange: func(open bool) {
+				stateChanges = append(stateChanges, open)
+			},
+		},
+	}
+	for i := 0; i < 2; i++ {
+		_, err := cli.Whoami(context.Background())
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, mautrix.ErrCircuitOpen)
+	}
+	assert.True(t, cli.RetryPolicy.CircuitBreaker.IsOpen())
+	_, err := cli.Whoami(context.Background())
+	assert.ErrorIs(t, err, mautrix.ErrCircuitOpen)
+	assert.EqualValues(t, 2, requests.Load())
+	assert.Equal(t, []bool{true}, stateChanges)
+
+	cli.RetryPolicy.CircuitBreaker.RecordSuccess()
+	assert.False(t, cli.RetryPolicy.CircuitBreaker.IsOpen())
+	assert.Equal(t, []bool{true, false}, stateChanges)
+}
+
+func TestRetryPolicy_CircuitBreakerCanceledTrial(t *testing.T) {
+	var requests atomic.Int32
+	cli := newRetryTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			<-r.Context().Done()
+			return
+		}
+		_, _ = w.Write([]byte(`{"user_id":"@user:example.com"}`))
+	})
+	breaker := &mautrix.CircuitBreaker{FailureThreshold: 1, ResetTimeout: time.Millisecond}
+	cli.RetryPolicy = &mautrix.RetryPolicy{CircuitBreaker: breaker}
+	breaker.RecordFailure()
+	require.True(t, breaker.IsOpen())
+	time.Sleep(2 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for requests.Load() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	_, err := cli.Whoami(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.True(t, breaker.IsOpen())
+
+	// The canceled trial must not block further trials
+	_, err = cli.Whoami(context.Background())
+	require.NoError(t, err)
+	assert.False(t, breaker.IsOpen())
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// FollowTombstoneOptions contains options for following room upgrades with Client.FollowTombstone.
+type FollowTombstoneOptions struct {
+	// Additional via servers to use when joining the replacement room.
+	// The server of the tombstone sender is always tried first.
+	Via []string
+	// If true, the notification mode of the old room is copied to the replacement room.
+	MigrateNotificationMode bool
+	// If true, the tags of the old room (e.g. favourite or low priority) are copied to the replacement room.
+	MigrateTags bool
+}
+
+// FollowTombstone joins the replacement room of an upgraded room based on the given m.room.tombstone event,
+// and optionally copies the user's settings from the old room to the new one.
+//
+// Migrating settings is best-effort: if joining succeeds but migration fails, the join response is returned
+// along with an error.
+func (cli *Client) FollowTombstone(ctx context.Context, evt *event.Event, opts *FollowTombstoneOptions) (*RespJoinRoom, error) {
+	if evt.Type != event.StateTombstone {
+		return nil, fmt.Errorf("event is not a tombstone")
+	}
+	_ = evt.Content.ParseRaw(evt.Type)
+	content, ok := evt.Content.Parsed.(*event.TombstoneEventContent)
+	if !ok || content.ReplacementRoom == "" {
+		return nil, fmt.Errorf("tombstone doesn't have a replacement room")
+	}
+	if opts == nil {
+		opts = &FollowTombstoneOptions{}
+	}
+	// The user who upgraded the room is the most likely to be in the new room
+	via := make([]string, 0, len(opts.Via)+1)
+	if senderServer := evt.Sender.Homeserver(); senderServer != "" {
+		via = append(via, senderServer)
+	}
+	for _, server := range opts.Via {
+		if !slices.Contains(via, server) {
+			via = append(via, server)
+		}
+	}
+	resp, err := cli.JoinRoom(ctx, content.ReplacementRoom.String(), &ReqJoinRoom{Via: via})
+	if err != nil {
+		return nil, fmt.Errorf("failed to join replacement room: %w", err)
+	}
+	var errs []error
+	if opts.MigrateNotificationMode {
+		if err = cli.migrateNotificationMode(ctx, evt.RoomID, resp.RoomID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to migrate notification mode: %w", err))
+		}
+	}
+	if opts.MigrateTags {
+		if err = cli.migrateTags(ctx, evt.RoomID, resp.RoomID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to migrate tags: %w", err))
+		}
+	}
+	return resp, errors.Join(errs...)
+}
+
+func (cli *Client) migrateNotificationMode(ctx context.Context, oldRoomID, newRoomID id.RoomID) error {
+	rules, err := cli.GetPushRules(ctx)
+	if err != nil {
+		return err
+	}
+	mode := rules.GetRoomNotificationMode(oldRoomID)
+	if mode == rules.GetRoomNotificationMode(newRoomID) {
+		return nil
+	}
+	changes, err := rules.SetRoomNotificationMode(newRoomID, mode)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if err = cli.ApplyPushRuleChange(ctx, "global", change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cli *Client) migrateTags(ctx context.Context, oldRoomID, newRoomID id.RoomID) error {
+	tags, err := cli.GetTags(ctx, oldRoomID)
+	if err != nil {
+		return err
+	}
+	for tag, meta := range tags.Tags {
+		if err = cli.AddTagWithCustomData(ctx, newRoomID, tag, &meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FollowTombstoneHandler returns a sync event handler that automatically follows room upgrades
+// by calling FollowTombstone for every m.room.tombstone event received in a joined room.
+//
+// The handler should be registered for the m.room.tombstone event type, e.g.
+//
+//	syncer.OnEventType(event.StateTombstone, cli.FollowTombstoneHandler(&mautrix.FollowTombstoneOptions{MigrateTags: true}))
+func (cli *Client) FollowTombstoneHandler(opts *FollowTombstoneOptions) EventHandler {
+	return func(ctx context.Context, evt *event.Event) {
+		if evt.GetStateKey() != "" || evt.Mautrix.EventSource&event.SourceJoin == 0 {
+			return
+		}
+		log := cli.cliOrContextLog(ctx).With().
+			Stringer("room_id", evt.RoomID).
+			Stringer("tombstone_event_id", evt.ID).
+			Logger()
+		resp, err := cli.FollowTombstone(ctx, evt, opts)
+		if resp == nil {
+			log.Err(err).Msg("Failed to follow room upgrade")
+		} else if err != nil {
+			log.Warn().Err(err).Stringer("new_room_id", resp.RoomID).Msg("Joined replacement room, but failed to migrate settings")
+		} else {
+			log.Debug().Stringer("new_room_id", resp.RoomID).Msg("Followed room upgrade")
+		}
+	}
+}
+
+// GetPredecessor returns the room that the given room replaced, based on the predecessor field
+// of its m.room.create event. Nil is returned if the room isn't an upgraded room.
+func (cli *Client) GetPredecessor(ctx context.Context, roomID id.RoomID) (*event.Predecessor, error) {
+	var content event.CreateEventContent
+	err := cli.StateEvent(ctx, roomID, event.StateCreate, "", &content)
+	if err != nil {
+		return nil, err
+	}
+	return content.Predecessor, nil
+}
+
+// GetSuccessor returns the ID of the room that replaced the given room, based on its m.room.tombstone event.
+// An empty string is returned if the room hasn't been upgraded.
+func (cli *Client) GetSuccessor(ctx context.Context, roomID id.RoomID) (id.RoomID, error) {
+	var content event.TombstoneEventContent
+	err := cli.StateEvent(ctx, roomID, event.StateTombstone, "", &content)
+	if errors.Is(err, MNotFound) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return content.ReplacementRoom, nil
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestClient_FollowTombstone(t *testing.T) {
+	const oldRoomID = id.RoomID("!old:example.com")
+	const newRoomID = id.RoomID("!new:example.com")
+	var joinVia []string
+	putTags := make(map[string]json.RawMessage)
+	var putRoomRule string
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /_matrix/client/v3/join/{roomID}", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, newRoomID.String(), r.PathValue("roomID"))
+		joinVia = r.URL.Query()["via"]
+		_, _ = fmt.Fprintf(w, `{"room_id": %q}`, newRoomID)
+	})
+	mux.HandleFunc("GET /_matrix/client/v3/user/{userID}/rooms/{roomID}/tags", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, oldRoomID.String(), r.PathValue("roomID"))
+		_, _ = w.Write([]byte(`{"tags": {"m.favourite": {"order": 0.5}}}`))
+	})
+	mux.HandleFunc("PUT /_matrix/client/v3/user/{userID}/rooms/{roomID}/tags/{tag}", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, newRoomID.String(), r.PathValue("roomID"))
+		var body json.RawMessage
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		putTags[r.PathValue("tag")] = body
+		_, _ = w.Write([]byte(`{}`))
+	})
+	mux.HandleFunc("GET /_matrix/client/v3/pushrules/global/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `{"room": [{"rule_id": %q, "default": false, "enabled": true, "actions": []}]}`, oldRoomID)
+	})
+	mux.HandleFunc("PUT /_matrix/client/v3/pushrules/global/room/{ruleID}", func(w http.ResponseWriter, r *http.Request) {
+		putRoomRule = r.PathValue("ruleID")
+		_, _ = w.Write([]byte(`{}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+
+	evt := &event.Event{
+		Type:     event.StateTombstone,
+		StateKey: new(string),
+		Sender:   "@admin:upgrader.example",
+		RoomID:   oldRoomID,
+		Content: event.Content{Parsed: &event.TombstoneEventContent{
+			Body:            "This room has been replaced",
+			ReplacementRoom: newRoomID,
+		}},
+	}
+	resp, err := cli.FollowTombstone(context.Background(), evt, &mautrix.FollowTombstoneOptions{
+		Via:                     []string{"upgrader.example", "other.example"},
+		MigrateNotificationMode: true,
+		MigrateTags:             true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, newRoomID, resp.RoomID)
+	assert.Equal(t, []string{"upgrader.example", "other.example"}, joinVia)
+	assert.JSONEq(t, `{"order": 0.5}`, string(putTags["m.favourite"]))
+	assert.Equal(t, newRoomID.String(), putRoomRule)
+}
+
+func TestClient_FollowTombstone_NoReplacement(t *testing.T) {
+	cli, err := mautrix.NewClient("https://example.com", "@user:example.com", "token")
+	require.NoError(t, err)
+	_, err = cli.FollowTombstone(context.Background(), &event.Event{
+		Type:     event.StateTombstone,
+		StateKey: new(string),
+		Content:  event.Content{Parsed: &event.TombstoneEventContent{}},
+	}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// MaxViaServers is the maximum number of servers that ComputeVia returns.
+const MaxViaServers = 3
+
+// HierarchyAll fetches all pages of the room's hierarchy. See Hierarchy for more info.
+//
+// The From field of the request is ignored and the Limit field is used as the page size.
+func (cli *Client) HierarchyAll(ctx context.Context, roomID id.RoomID, req *ReqHierarchy) ([]*ChildRoomsChunk, error) {
+	var pageReq ReqHierarchy
+	if req != nil {
+		pageReq = *req
+	}
+	pageReq.From = ""
+	var rooms []*ChildRoomsChunk
+	for {
+		resp, err := cli.Hierarchy(ctx, roomID, &pageReq)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, resp.Rooms...)
+		if resp.NextBatch == "" || resp.NextBatch == pageReq.From {
+			return rooms, nil
+		}
+		pageReq.From = resp.NextBatch
+	}
+}
+
+// ComputeVia computes a list of servers that can be used in the via field of m.space.child and m.space.parent events.
+//
+// The list follows the recommendations in https://spec.matrix.org/v1.13/appendices/#routing: the server of the
+// highest power level user is first, followed by the servers with the most joined members. The server of the
+// client is used as a fallback if the room member list can't be determined.
+func (cli *Client) ComputeVia(ctx context.Context, roomID id.RoomID) ([]string, error) {
+	members, err := cli.JoinedMembers(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get joined members: %w", err)
+	}
+	var pl event.PowerLevelsEventContent
+	err = cli.StateEvent(ctx, roomID, event.StatePowerLevels, "", &pl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get power levels: %w", err)
+	}
+	return computeVia(members.Joined, &pl, cli.UserID.Homeserver()), nil
+}
+
+func computeVia(members map[id.UserID]JoinedMember, pl *event.PowerLevelsEventContent, fallback string) []string {
+	serverCounts := make(map[string]int)
+	var highestUser id.UserID
+	highestLevel := pl.UsersDefault
+	for userID := range members {
+		serverCounts[userID.Homeserver()]++
+		level := pl.GetUserLevel(userID)
+		if level > highestLevel || (level == highestLevel && highestUser != "" && userID < highestUser) {
+			highestLevel = level
+			highestUser = userID
+		}
+	}
+	servers := make([]string, 0, len(serverCounts))
+	for server := range serverCounts {
+		servers = append(servers, server)
+	}
+	slices.SortFunc(servers, func(a, b string) int {
+		if diff := serverCounts[b] - serverCounts[a]; diff != 0 {
+			return diff
+		} else if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+		return 0
+	})
+	if highestUser != "" {
+		hs := highestUser.Homeserver()
+		servers = slices.DeleteFunc(servers, func(server string) bool { return server == hs })
+		servers = slices.Insert(servers, 0, hs)
+	}
+	if len(servers) == 0 && fallback != "" {
+		servers = []string{fallback}
+	}
+	if len(servers) > MaxViaServers {
+		servers = servers[:MaxViaServers]
+	}
+	return servers
+}
+
+// AddSpaceChild adds a room to a space by sending a m.space.child event in the space.
+// If the via list in the content is empty, it will be computed using ComputeVia on the child room.
+//
+// See https://spec.matrix.org/v1.13/client-server-api/#mspacechild
+func (cli *Client) AddSpaceChild(ctx context.Context, spaceID, childID id.RoomID, content *event.SpaceChildEventContent) (*RespSendEvent, error) {
+	if content == nil {
+		content = &event.SpaceChildEventContent{}
+	}
+	if len(content.Via) == 0 {
+		via, err := cli.ComputeVia(ctx, childID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute via servers: %w", err)
+		}
+		content.Via = via
+	}
+	return cli.SendStateEvent(ctx, spaceID, event.StateSpaceChild, childID.String(), content)
+}
+
+// RemoveSpaceChild removes a room from a space by replacing the m.space.child event with an empty one.
+func (cli *Client) RemoveSpaceChild(ctx context.Context, spaceID, childID id.RoomID) (*RespSendEvent, error) {
+	return cli.SendStateEvent(ctx, spaceID, event.StateSpaceChild, childID.String(), struct{}{})
+}
+
+// ReorderSpaceChildren sets the order field of the m.space.child events in a space so that the children are sorted
+// in the given order. Other fields of the existing child events are preserved.
+//
+// Children that are not in the space will return an error. Children of the space that aren't in the list are not modified,
+// which means they'll be sorted after the given children if they don't have an order, or based on their existing order.
+func (cli *Client) ReorderSpaceChildren(ctx context.Context, spaceID id.RoomID, children []id.RoomID) error {
+	for i, childID := range children {
+		var content event.SpaceChildEventContent
+		err := cli.StateEvent(ctx, spaceID, event.StateSpaceChild, childID.String(), &content)
+		if err != nil {
+			return fmt.Errorf("failed to get space child event for %s: %w", childID, err)
+		} else if len(content.Via) == 0 {
+			return fmt.Errorf("%s is not a child of the space", childID)
+		}
+		order := spaceChildOrder(i)
+		if content.Order == order {
+			continue
+		}
+		content.Order = order
+		_, err = cli.SendStateEvent(ctx, spaceID, event.StateSpaceChild, childID.String(), &content)
+		if err != nil {
+			return fmt.Errorf("failed to update space child event for %s: %w", childID, err)
+		}
+	}
+	return nil
+}
+
+func spaceChildOrder(index int) string {
+	// Leave gaps between the order values so that rooms can be inserted in between without reordering everything.
+	return fmt.Sprintf("%08d", (index+1)*1000)
+}
+
+// SetSpaceParent adds a m.space.parent event to a room. If the via list in the content is empty,
+// it will be computed using ComputeVia on the parent space.
+//
+// If the new parent is canonical, any other canonical parent events in the room are updated to not be canonical,
+// as a room should only have one canonical parent.
+func (cli *Client) SetSpaceParent(ctx context.Context, roomID, parentID id.RoomID, content *event.SpaceParentEventContent) (*RespSendEvent, error) {
+	if content == nil {
+		content = &event.SpaceParentEventContent{}
+	}
+	if len(content.Via) == 0 {
+		via, err := cli.ComputeVia(ctx, parentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute via servers: %w", err)
+		}
+		content.Via = via
+	}
+	if content.Canonical {
+		state, err := cli.State(ctx, roomID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get room state: %w", err)
+		}
+		for stateKey, evt := range state[event.StateSpaceParent] {
+			if stateKey == parentID.String() {
+				continue
+			}
+			_ = evt.Content.ParseRaw(evt.Type)
+			otherParent, ok := evt.Content.Parsed.(*event.SpaceParentEventContent)
+			if !ok || !otherParent.Canonical {
+				continue
+			}
+			otherParent.Canonical = false
+			_, err = cli.SendStateEvent(ctx, roomID, event.StateSpaceParent, stateKey, otherParent)
+			if err != nil {
+				return nil, fmt.Errorf("failed to remove canonical flag from parent %s: %w", stateKey, err)
+			}
+		}
+	}
+	return cli.SendStateEvent(ctx, roomID, event.StateSpaceParent, parentID.String(), content)
+}
+
+// RemoveSpaceParent removes a m.space.parent event from a room by replacing it with an empty one.
+func (cli *Client) RemoveSpaceParent(ctx context.Context, roomID, parentID id.RoomID) (*RespSendEvent, error) {
+	return cli.SendStateEvent(ctx, roomID, event.StateSpaceParent, parentID.String(), struct{}{})
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+)
+
+func newSpacesTestClient(t *testing.T, mux *http.ServeMux) *mautrix.Client {
+	mux.HandleFunc("GET /_matrix/client/v3/rooms/{roomID}/joined_members", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"joined": {
+			"@admin:small.example": {}, "@a:big.example": {}, "@b:big.example": {},
+			"@c:medium.example": {}, "@d:medium.example": {}, "@e:other.example": {}
+		}}`))
+	})
+	mux.HandleFunc("GET /_matrix/client/v3/rooms/{roomID}/state/m.room.power_levels/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"users": {"@admin:small.example": 100}}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+	return cli
+}
+
+func TestClient_HierarchyAll(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /_matrix/client/v1/rooms/{roomID}/hierarchy", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "2", r.URL.Query().Get("max_depth"))
+		if r.URL.Query().Get("from") == "" {
+			_, _ = w.Write([]byte(`{"rooms": [{"room_id": "!a:example.com"}, {"room_id": "!b:example.com"}], "next_batch": "page2"}`))
+		} else {
+			assert.Equal(t, "page2", r.URL.Query().Get("from"))
+			_, _ = w.Write([]byte(`{"rooms": [{"room_id": "!c:example.com"}]}`))
+		}
+	})
+	cli := newSpacesTestClient(t, mux)
+	maxDepth := 2
+	rooms, err := cli.HierarchyAll(context.Background(), "!space:example.com", &mautrix.ReqHierarchy{MaxDepth: &maxDepth})
+	require.NoError(t, err)
+	require.Len(t, rooms, 3)
+	assert.EqualValues(t, "!c:example.com", rooms[2].RoomID)
+}
+
+func TestClient_AddSpaceChild(t *testing.T) {
+	var sentContent event.SpaceChildEventContent
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /_matrix/client/v3/rooms/{roomID}/state/m.space.child/{stateKey}", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "!space:example.com", r.PathValue("roomID"))
+		assert.Equal(t, "!child:example.com", r.PathValue("stateKey"))
+		_ = json.NewDecoder(r.Body).Decode(&sentContent)
+		_, _ = w.Write([]byte(`{"event_id": "$event"}`))
+	})
+	cli := newSpacesTestClient(t, mux)
+	_, err := cli.AddSpaceChild(context.Background(), "!space:example.com", "!child:example.com", &event.SpaceChildEventContent{Suggested: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"small.example", "big.example", "medium.example"}, sentContent.Via)
+	assert.True(t, sentContent.Suggested)
+}
@@ -0,0 +1,362 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sqlstatestore
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type cachedRoomState struct {
+	roomID id.RoomID
+	// Cached members. A nil value means the member is known to not be in the database.
+	members     map[id.UserID]*event.MemberEventContent
+	powerLevels *event.PowerLevelsEventContent
+	hasPLs      bool
+	create      *event.CreateEventContent
+	// Incremented on every write, so that values read from the database without holding the lock
+	// aren't cached if the room was written to while the read was in progress.
+	version uint64
+}
+
+// CachedSQLStateStore is a write-through in-memory cache on top of a SQLStateStore.
+//
+// Member and power level lookups are served from memory after the first database query, and all writes
+// go to both the database and the cache. The m.room.create content of rooms is also cached when it's seen
+// via UpdateStateStore (e.g. from sync), but it's not stored in the database.
+//
+// The cache assumes that all writes to the database go through this struct (or through UpdateStateStore
+// with this struct as the store). If the database is modified elsewhere, InvalidateRoom must be called.
+type CachedSQLStateStore struct {
+	*SQLStateStore
+	// The maximum number of rooms to keep in the cache. When the limit is reached, the least recently used
+	// room is evicted. Zero means unlimited.
+	MaxRooms int
+
+	rooms map[id.RoomID]*list.Element
+	order *list.List
+	lock  sync.Mutex
+}
+
+var _ mautrix.StateStore = (*CachedSQLStateStore)(nil)
+var _ mautrix.StateStoreUpdater = (*CachedSQLStateStore)(nil)
+
+// NewCachedSQLStateStore wraps the given SQL state store with an in-memory cache.
+func NewCachedSQLStateStore(store *SQLStateStore, maxRooms int) *CachedSQLStateStore {
+	return &CachedSQLStateStore{
+		SQLStateStore: store,
+		MaxRooms:      maxRooms,
+		rooms:         make(map[id.RoomID]*list.Element),
+		order:         list.New(),
+	}
+}
+
+// getRoom returns the cached state of the given room and marks it as recently used.
+// If create is true, the room is added to the cache if it doesn't exist. The lock must be held when calling this.
+func (store *CachedSQLStateStore) getRoom(roomID id.RoomID, create bool) *cachedRoomState {
+	elem, ok := store.rooms[roomID]
+	if ok {
+		store.order.MoveToFront(elem)
+		return elem.Value.(*cachedRoomState)
+	} else if !create {
+		return nil
+	}
+	room := &cachedRoomState{
+		roomID:  roomID,
+		members: make(map[id.UserID]*event.MemberEventContent),
+	}
+	store.rooms[roomID] = store.order.PushFront(room)
+	for store.MaxRooms > 0 && store.order.Len() > store.MaxRooms {
+		oldest := store.order.Back()
+		store.order.Remove(oldest)
+		delete(store.rooms, oldest.Value.(*cachedRoomState).roomID)
+	}
+	return room
+}
+
+// startRead returns the cached state of the given room (creating it if necessary) and its current version.
+// The lock must be held when calling this.
+func (store *CachedSQLStateStore) startRead(roomID id.RoomID) (*cachedRoomState, uint64) {
+	room := store.getRoom(roomID, true)
+	return room, room.version
+}
+
+// isUnchanged checks that the given room is still in the cache and hasn't been written to since startRead
+// returned the given version. The lock must be held when calling this.
+func (store *CachedSQLStateStore) isUnchanged(room *cachedRoomState, version uint64) bool {
+	elem, ok := store.rooms[room.roomID]
+	return ok && elem.Value.(*cachedRoomState) == room && room.version == version
+}
+
+// InvalidateRoom removes all cached data of the given room.
+func (store *CachedSQLStateStore) InvalidateRoom(roomID id.RoomID) {
+	store.lock.Lock()
+	if elem, ok := store.rooms[roomID]; ok {
+		store.order.Remove(elem)
+		delete(store.rooms, roomID)
+	}
+	store.lock.Unlock()
+}
+
+// trimCachedMember returns a copy of the member content that only has the fields stored in the database.
+func trimCachedMember(member *event.MemberEventContent) *event.MemberEventContent {
+	if member == nil {
+		return nil
+	}
+	return &event.MemberEventContent{
+		Membership:  member.Membership,
+		Displayname: member.Displayname,
+		AvatarURL:   member.AvatarURL,
+	}
+}
+
+// fillMember caches a member that was read from the database, unless the room was written to during the read.
+func (store *CachedSQLStateStore) fillMember(room *cachedRoomState, version uint64, userID id.UserID, member *event.MemberEventContent) {
+	store.lock.Lock()
+	if store.isUnchanged(room, version) {
+		room.members[userID] = trimCachedMember(member)
+	}
+	store.lock.Unlock()
+}
+
+func (store *CachedSQLStateStore) TryGetMember(ctx context.Context, roomID id.RoomID, userID id.UserID) (*event.MemberEventContent, error) {
+	store.lock.Lock()
+	room, version := store.startRead(roomID)
+	member, ok := room.members[userID]
+	store.lock.Unlock()
+	if ok {
+		if member == nil {
+			return nil, nil
+		}
+		memberCopy := *member
+		return &memberCopy, nil
+	}
+	member, err := store.SQLStateStore.TryGetMember(ctx, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+	store.fillMember(room, version, userID, member)
+	return member, nil
+}
+
+func (store *CachedSQLStateStore) GetMember(ctx context.Context, roomID id.RoomID, userID id.UserID) (*event.MemberEventContent, error) {
+	member, err := store.TryGetMember(ctx, roomID, userID)
+	if member == nil && err == nil {
+		member = &event.MemberEventContent{Membership: event.MembershipLeave}
+	}
+	return member, err
+}
+
+func (store *CachedSQLStateStore) GetMembership(ctx context.Context, roomID id.RoomID, userID id.UserID) (event.Membership, error) {
+	member, err := store.GetMember(ctx, roomID, userID)
+	if err != nil {
+		return "", err
+	}
+	return member.Membership, nil
+}
+
+func (store *CachedSQLStateStore) IsInRoom(ctx context.Context, roomID id.RoomID, userID id.UserID) bool {
+	return store.IsMembership(ctx, roomID, userID, event.MembershipJoin)
+}
+
+func (store *CachedSQLStateStore) IsInvited(ctx context.Context, roomID id.RoomID, userID id.UserID) bool {
+	return store.IsMembership(ctx, roomID, userID, event.MembershipJoin, event.MembershipInvite)
+}
+
+func (store *CachedSQLStateStore) IsMembership(ctx context.Context, roomID id.RoomID, userID id.UserID, allowedMemberships ...event.Membership) bool {
+	membership, err := store.GetMembership(ctx, roomID, userID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get membership")
+		return false
+	}
+	for _, allowedMembership := range allowedMemberships {
+		if allowedMembership == membership {
+			return true
+		}
+	}
+	return false
+}
+
+func (store *CachedSQLStateStore) SetMembership(ctx context.Context, roomID id.RoomID, userID id.UserID, membership event.Membership) error {
+	err := store.SQLStateStore.SetMembership(ctx, roomID, userID, membership)
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	room := store.getRoom(roomID, err == nil)
+	if room == nil {
+		return err
+	}
+	room.version++
+	if err != nil {
+		delete(room.members, userID)
+	} else if existing := room.members[userID]; existing != nil {
+		room.members[userID] = &event.MemberEventContent{
+			Membership:  membership,
+			Displayname: existing.Displayname,
+			AvatarURL:   existing.AvatarURL,
+		}
+	} else {
+		room.members[userID] = &event.MemberEventContent{Membership: membership}
+	}
+	return err
+}
+
+func (store *CachedSQLStateStore) SetMember(ctx context.Context, roomID id.RoomID, userID id.UserID, member *event.MemberEventContent) error {
+	err := store.SQLStateStore.SetMember(ctx, roomID, userID, member)
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	room := store.getRoom(roomID, err == nil)
+	if room == nil {
+		return err
+	}
+	room.version++
+	if err != nil {
+		delete(room.members, userID)
+	} else {
+		room.members[userID] = trimCachedMember(member)
+	}
+	return err
+}
+
+func (store *CachedSQLStateStore) invalidateMembers(roomID id.RoomID) {
+	store.lock.Lock()
+	if room := store.getRoom(roomID, false); room != nil {
+		room.version++
+		clear(room.members)
+	}
+	store.lock.Unlock()
+}
+
+func (store *CachedSQLStateStore) ClearCachedMembers(ctx context.Context, roomID id.RoomID, memberships ...event.Membership) error {
+	defer store.invalidateMembers(roomID)
+	return store.SQLStateStore.ClearCachedMembers(ctx, roomID, memberships...)
+}
+
+func (store *CachedSQLStateStore) ReplaceCachedMembers(ctx context.Context, roomID id.RoomID, evts []*event.Event, onlyMemberships ...event.Membership) error {
+	defer store.invalidateMembers(roomID)
+	return store.SQLStateStore.ReplaceCachedMembers(ctx, roomID, evts, onlyMemberships...)
+}
+
+func (store *CachedSQLStateStore) SetPowerLevels(ctx context.Context, roomID id.RoomID, levels *event.PowerLevelsEventContent) error {
+	err := store.SQLStateStore.SetPowerLevels(ctx, roomID, levels)
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	room := store.getRoom(roomID, err == nil)
+	if room != nil {
+		room.version++
+		room.powerLevels = levels
+		room.hasPLs = err == nil
+	}
+	return err
+}
+
+// GetPowerLevels returns the power levels of the room. The returned value is shared with the cache and must not be modified.
+func (store *CachedSQLStateStore) GetPowerLevels(ctx context.Context, roomID id.RoomID) (*event.PowerLevelsEventContent, error) {
+	store.lock.Lock()
+	room, version := store.startRead(roomID)
+	if room.hasPLs {
+		store.lock.Unlock()
+		return room.powerLevels, nil
+	}
+	store.lock.Unlock()
+	levels, err := store.SQLStateStore.GetPowerLevels(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	store.fillPowerLevels(room, version, levels)
+	return levels, nil
+}
+
+// fillPowerLevels caches power levels that were read from the database, unless the room was written to during the read.
+func (store *CachedSQLStateStore) fillPowerLevels(room *cachedRoomState, version uint64, levels *event.PowerLevelsEventContent) {
+	store.lock.Lock()
+	if store.isUnchanged(room, version) {
+		room.powerLevels = levels
+		room.hasPLs = true
+	}
+	store.lock.Unlock()
+}
+
+// getPowerLevelsOrDefault returns the power levels of the room,
+// or empty power levels (i.e. the defaults from the spec) if they haven't been stored.
+func (store *CachedSQLStateStore) getPowerLevelsOrDefault(ctx context.Context, roomID id.RoomID) (*event.PowerLevelsEventContent, error) {
+	levels, err := store.GetPowerLevels(ctx, roomID)
+	if err != nil {
+		return nil, err
+	} else if levels == nil {
+		levels = &event.PowerLevelsEventContent{}
+	}
+	return levels, nil
+}
+
+func (store *CachedSQLStateStore) GetPowerLevel(ctx context.Context, roomID id.RoomID, userID id.UserID) (int, error) {
+	levels, err := store.getPowerLevelsOrDefault(ctx, roomID)
+	if err != nil {
+		return 0, err
+	}
+	return levels.GetUserLevel(userID), nil
+}
+
+func (store *CachedSQLStateStore) GetPowerLevelRequirement(ctx context.Context, roomID id.RoomID, eventType event.Type) (int, error) {
+	levels, err := store.getPowerLevelsOrDefault(ctx, roomID)
+	if err != nil {
+		return 0, err
+	}
+	return levels.GetEventLevel(eventType), nil
+}
+
+func (store *CachedSQLStateStore) HasPowerLevel(ctx context.Context, roomID id.RoomID, userID id.UserID, eventType event.Type) (bool, error) {
+	levels, err := store.getPowerLevelsOrDefault(ctx, roomID)
+	if err != nil {
+		return false, err
+	}
+	return levels.GetUserLevel(userID) >= levels.GetEventLevel(eventType), nil
+}
+
+// GetCreateContent returns the cached m.room.create content of the room, or nil if it hasn't been seen.
+func (store *CachedSQLStateStore) GetCreateContent(roomID id.RoomID) *event.CreateEventContent {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if room := store.getRoom(roomID, false); room != nil {
+		return room.create
+	}
+	return nil
+}
+
+// UpdateState updates the cache and database based on a state event. It's called automatically by
+// mautrix.UpdateStateStore, which also stores the state delta before calling this.
+func (store *CachedSQLStateStore) UpdateState(ctx context.Context, evt *event.Event) {
+	// We only care about events without a state key (power levels, encryption, create) or member events with state key
+	if evt.Type != event.StateMember && evt.GetStateKey() != "" {
+		return
+	}
+	var err error
+	switch content := evt.Content.Parsed.(type) {
+	case *event.MemberEventContent:
+		err = store.SetMember(ctx, evt.RoomID, id.UserID(evt.GetStateKey()), content)
+	case *event.PowerLevelsEventContent:
+		err = store.SetPowerLevels(ctx, evt.RoomID, content)
+	case *event.EncryptionEventContent:
+		err = store.SetEncryptionEvent(ctx, evt.RoomID, content)
+	case *event.CreateEventContent:
+		store.lock.Lock()
+		store.getRoom(evt.RoomID, true).create = content
+		store.lock.Unlock()
+	}
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).
+			Stringer("event_id", evt.ID).
+			Str("event_type", evt.Type.Type).
+			Msg("Failed to update state store")
+	}
+}
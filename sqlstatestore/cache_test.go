@@ -0,0 +1,146 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sqlstatestore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestCachedSQLStateStore_WriteThrough(t *testing.T) {
+	ctx := context.Background()
+	store := NewCachedSQLStateStore(newTestStateStore(t), 0)
+	require.NoError(t, store.SetMember(ctx, testRoomID, "@alice:example.com", &event.MemberEventContent{
+		Membership:  event.MembershipJoin,
+		Displayname: "Alice",
+	}))
+	dbMember, err := store.SQLStateStore.TryGetMember(ctx, testRoomID, "@alice:example.com")
+	require.NoError(t, err)
+	require.NotNil(t, dbMember)
+	assert.Equal(t, "Alice", dbMember.Displayname)
+
+	require.NoError(t, store.SetMembership(ctx, testRoomID, "@alice:example.com", event.MembershipLeave))
+	dbMember, err = store.SQLStateStore.TryGetMember(ctx, testRoomID, "@alice:example.com")
+	require.NoError(t, err)
+	assert.Equal(t, event.MembershipLeave, dbMember.Membership)
+	cached, err := store.TryGetMember(ctx, testRoomID, "@alice:example.com")
+	require.NoError(t, err)
+	assert.Equal(t, event.MembershipLeave, cached.Membership)
+	assert.Equal(t, "Alice", cached.Displayname)
+}
+
+func TestCachedSQLStateStore_Invalidation(t *testing.T) {
+	ctx := context.Background()
+	store := NewCachedSQLStateStore(newTestStateStore(t), 0)
+
+	// Missing members are cached too
+	member, err := store.TryGetMember(ctx, testRoomID, "@alice:example.com")
+	require.NoError(t, err)
+	assert.Nil(t, member)
+	require.NoError(t, store.SQLStateStore.SetMembership(ctx, testRoomID, "@alice:example.com", event.MembershipJoin))
+	assert.False(t, store.IsInRoom(ctx, testRoomID, "@alice:example.com"))
+
+	store.InvalidateRoom(testRoomID)
+	assert.True(t, store.IsInRoom(ctx, testRoomID, "@alice:example.com"))
+
+	require.NoError(t, store.SQLStateStore.SetMembership(ctx, testRoomID, "@bob:example.com", event.MembershipJoin))
+	require.NoError(t, store.ReplaceCachedMembers(ctx, testRoomID, []*event.Event{
+		memberEvent("$bob", "@bob:example.com", "Bob", time.Now()),
+	}))
+	assert.False(t, store.IsInRoom(ctx, testRoomID, "@alice:example.com"))
+	member, err = store.TryGetMember(ctx, testRoomID, "@bob:example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "Bob", member.Displayname)
+
+	require.NoError(t, store.ClearCachedMembers(ctx, testRoomID))
+	assert.False(t, store.IsInRoom(ctx, testRoomID, "@bob:example.com"))
+}
+
+func TestCachedSQLStateStore_Eviction(t *testing.T) {
+	ctx := context.Background()
+	store := NewCachedSQLStateStore(newTestStateStore(t), 2)
+	room1, room2, room3 := id.RoomID("!room1:example.com"), id.RoomID("!room2:example.com"), id.RoomID("!room3:example.com")
+	for _, roomID := range []id.RoomID{room1, room2} {
+		require.NoError(t, store.SetMembership(ctx, roomID, "@alice:example.com", event.MembershipJoin))
+	}
+	// Using room1 makes room2 the least recently used room
+	assert.True(t, store.IsInRoom(ctx, room1, "@alice:example.com"))
+	require.NoError(t, store.SetMembership(ctx, room3, "@alice:example.com", event.MembershipJoin))
+
+	assert.Len(t, store.rooms, 2)
+	assert.Contains(t, store.rooms, room1)
+	assert.NotContains(t, store.rooms, room2)
+	assert.Contains(t, store.rooms, room3)
+	// Evicted rooms are read from the database again
+	assert.True(t, store.IsInRoom(ctx, room2, "@alice:example.com"))
+	assert.NotContains(t, store.rooms, room1)
+}
+
+func TestCachedSQLStateStore_PowerLevels(t *testing.T) {
+	ctx := context.Background()
+	store := NewCachedSQLStateStore(newTestStateStore(t), 0)
+
+	levels, err := store.GetPowerLevels(ctx, testRoomID)
+	require.NoError(t, err)
+	assert.Nil(t, levels)
+	// Missing power levels must fall back to the defaults instead of panicking
+	level, err := store.GetPowerLevel(ctx, testRoomID, "@alice:example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 0, level)
+	requirement, err := store.GetPowerLevelRequirement(ctx, testRoomID, event.StateRoomName)
+	require.NoError(t, err)
+	assert.Equal(t, 50, requirement)
+	hasLevel, err := store.HasPowerLevel(ctx, testRoomID, "@alice:example.com", event.EventMessage)
+	require.NoError(t, err)
+	assert.True(t, hasLevel)
+
+	require.NoError(t, store.SetPowerLevels(ctx, testRoomID, &event.PowerLevelsEventContent{
+		Users: map[id.UserID]int{"@alice:example.com": 100},
+	}))
+	level, err = store.GetPowerLevel(ctx, testRoomID, "@alice:example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 100, level)
+	dbLevels, err := store.SQLStateStore.GetPowerLevels(ctx, testRoomID)
+	require.NoError(t, err)
+	assert.Equal(t, 100, dbLevels.GetUserLevel("@alice:example.com"))
+}
+
+func TestCachedSQLStateStore_StaleFill(t *testing.T) {
+	ctx := context.Background()
+	store := NewCachedSQLStateStore(newTestStateStore(t), 0)
+
+	// Simulate a database read that started before concurrent writes and finishes after them
+	store.lock.Lock()
+	room, version := store.startRead(testRoomID)
+	store.lock.Unlock()
+	require.NoError(t, store.SetMembership(ctx, testRoomID, "@alice:example.com", event.MembershipJoin))
+	require.NoError(t, store.SetPowerLevels(ctx, testRoomID, &event.PowerLevelsEventContent{
+		Users: map[id.UserID]int{"@alice:example.com": 100},
+	}))
+	store.fillMember(room, version, "@alice:example.com", nil)
+	store.fillPowerLevels(room, version, nil)
+
+	assert.True(t, store.IsInRoom(ctx, testRoomID, "@alice:example.com"))
+	level, err := store.GetPowerLevel(ctx, testRoomID, "@alice:example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 100, level)
+
+	// Reads are also discarded if the room was invalidated in the meantime
+	store.lock.Lock()
+	room, version = store.startRead(testRoomID)
+	store.lock.Unlock()
+	store.InvalidateRoom(testRoomID)
+	store.fillMember(room, version, "@bob:example.com", &event.MemberEventContent{Membership: event.MembershipJoin})
+	assert.NotContains(t, store.rooms, testRoomID)
+}
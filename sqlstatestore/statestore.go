@@ -15,12 +15,15 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 	"go.mau.fi/util/confusable"
 	"go.mau.fi/util/dbutil"
 	"go.mau.fi/util/exslices"
 
+	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 )
@@ -39,8 +42,35 @@ const VersionTableName = "mx_version"
 type SQLStateStore struct {
 	*dbutil.Database
 	IsBridge bool
+	// The ID of the appservice registration, used to scope transaction IDs
+	// in case multiple appservices share the same database.
+	AppServiceID string
 
 	DisableNameDisambiguation bool
+	// If true, every state event is stored in the state history, which allows querying the state of a room
+	// as it was at an older event using GetStateEventAt. This is disabled by default, as the history includes
+	// the full content of every state event, including all member events.
+	StoreStateHistory bool
+	// How long state history entries are kept. Entries older than this are deleted, unless they're the latest entry
+	// for their type and state key (i.e. still the current state). Defaults to DefaultStateHistoryRetention,
+	// negative values disable pruning.
+	StateHistoryRetention time.Duration
+	// The maximum number of changes to keep per room for each of the room name, topic and avatar.
+	// Defaults to DefaultRoomProfileHistoryLimit, negative values disable pruning.
+	// Pruned changes are also no longer available via GetStateEventAt.
+	// The history is only recorded if StoreStateHistory is enabled.
+	RoomProfileHistoryLimit int
+
+	// Encryption can't be disabled after it's enabled, so encryption events are cached in memory
+	// to avoid hitting the database every time a message is sent.
+	encryptionCache     map[id.RoomID]*event.EncryptionEventContent
+	encryptionCacheLock sync.RWMutex
+
+	lastStateHistoryPrune     time.Time
+	lastStateHistoryPruneLock sync.Mutex
+
+	lastTransactionPrune     time.Time
+	lastTransactionPruneLock sync.Mutex
 }
 
 func NewSQLStateStore(db *dbutil.Database, log dbutil.DatabaseLogger, isBridge bool) *SQLStateStore {
@@ -66,6 +96,58 @@ func (store *SQLStateStore) MarkRegistered(ctx context.Context, userID id.UserID
 	return err
 }
 
+// DefaultRoomProfileHistoryLimit is the default value for SQLStateStore.RoomProfileHistoryLimit.
+const DefaultRoomProfileHistoryLimit = 20
+
+// DefaultStateHistoryRetention is the default value for SQLStateStore.StateHistoryRetention.
+const DefaultStateHistoryRetention = 30 * 24 * time.Hour
+
+// StateHistoryPruneInterval is how often AddStateDelta prunes old state history entries.
+var StateHistoryPruneInterval = 1 * time.Hour
+
+// TransactionRetention is how long processed appservice transaction IDs are remembered.
+var TransactionRetention = 7 * 24 * time.Hour
+
+// TransactionPruneInterval is how often MarkTransactionProcessed prunes old transaction IDs.
+var TransactionPruneInterval = 1 * time.Hour
+
+func (store *SQLStateStore) IsTransactionProcessed(ctx context.Context, txnID string) (bool, error) {
+	var isProcessed bool
+	err := store.
+		QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM mx_appservice_txn WHERE appservice_id=$1 AND txn_id=$2)", store.AppServiceID, txnID).
+		Scan(&isProcessed)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	return isProcessed, err
+}
+
+func (store *SQLStateStore) MarkTransactionProcessed(ctx context.Context, txnID string) error {
+	now := time.Now()
+	_, err := store.Exec(ctx, `
+		INSERT INTO mx_appservice_txn (appservice_id, txn_id, processed_at) VALUES ($1, $2, $3)
+		ON CONFLICT (appservice_id, txn_id) DO NOTHING
+	`, store.AppServiceID, txnID, now.UnixMilli())
+	if err != nil {
+		return err
+	}
+	return store.maybePruneTransactions(ctx, now)
+}
+
+func (store *SQLStateStore) maybePruneTransactions(ctx context.Context, now time.Time) error {
+	store.lastTransactionPruneLock.Lock()
+	defer store.lastTransactionPruneLock.Unlock()
+	if now.Sub(store.lastTransactionPrune) < TransactionPruneInterval {
+		return nil
+	}
+	store.lastTransactionPrune = now
+	_, err := store.Exec(
+		ctx, "DELETE FROM mx_appservice_txn WHERE appservice_id=$1 AND processed_at<$2",
+		store.AppServiceID, now.Add(-TransactionRetention).UnixMilli(),
+	)
+	return err
+}
+
 type Member struct {
 	id.UserID
 	event.MemberEventContent
@@ -98,6 +180,42 @@ func (store *SQLStateStore) GetRoomMembers(ctx context.Context, roomID id.RoomID
 	})
 }
 
+func membershipFilter(startIndex int, memberships []event.Membership) (string, []any) {
+	if len(memberships) == 0 {
+		return "", nil
+	}
+	placeholders := make([]string, len(memberships))
+	args := make([]any, len(memberships))
+	for i, membership := range memberships {
+		args[i] = string(membership)
+		placeholders[i] = "$" + strconv.Itoa(startIndex+i)
+	}
+	return fmt.Sprintf(" AND membership IN (%s)", strings.Join(placeholders, ",")), args
+}
+
+// GetRoomMembersPage returns up to limit members of the room sorted by user ID, starting after the given user ID.
+// To get the first page, pass an empty user ID. The next page can be fetched by passing the user ID of the last member.
+func (store *SQLStateStore) GetRoomMembersPage(ctx context.Context, roomID id.RoomID, after id.UserID, limit int, memberships ...event.Membership) ([]*Member, error) {
+	filter, filterArgs := membershipFilter(4, memberships)
+	query := "SELECT user_id, membership, displayname, avatar_url FROM mx_user_profile WHERE room_id=$1 AND user_id>$2" +
+		filter + " ORDER BY user_id LIMIT $3"
+	args := append([]any{roomID, after, limit}, filterArgs...)
+	rows, err := store.Query(ctx, query, args...)
+	return dbutil.NewRowIterWithError(rows, func(row dbutil.Scannable) (*Member, error) {
+		var member Member
+		err := row.Scan(&member.UserID, &member.Membership, &member.Displayname, &member.AvatarURL)
+		return &member, err
+	}, err).AsList()
+}
+
+// CountRoomMembers returns the number of room members with the given memberships, or all members if no memberships are specified.
+func (store *SQLStateStore) CountRoomMembers(ctx context.Context, roomID id.RoomID, memberships ...event.Membership) (count int, err error) {
+	filter, filterArgs := membershipFilter(2, memberships)
+	args := append([]any{roomID}, filterArgs...)
+	err = store.QueryRow(ctx, "SELECT COUNT(*) FROM mx_user_profile WHERE room_id=$1"+filter, args...).Scan(&count)
+	return
+}
+
 func (store *SQLStateStore) GetRoomJoinedOrInvitedMembers(ctx context.Context, roomID id.RoomID) (members []id.UserID, err error) {
 	var memberMap map[id.UserID]*event.MemberEventContent
 	memberMap, err = store.GetRoomMembers(ctx, roomID, event.MembershipJoin, event.MembershipInvite)
@@ -298,7 +416,7 @@ func (store *SQLStateStore) ClearCachedMembers(ctx context.Context, roomID id.Ro
 	if err != nil {
 		return err
 	}
-	_, err = store.Exec(ctx, "UPDATE mx_room_state SET members_fetched=false WHERE room_id=$1", roomID)
+	_, err = store.Exec(ctx, "UPDATE mx_room_state SET members_fetched=false, members_fetched_at=NULL WHERE room_id=$1", roomID)
 	return err
 }
 
@@ -312,12 +430,45 @@ func (store *SQLStateStore) HasFetchedMembers(ctx context.Context, roomID id.Roo
 
 func (store *SQLStateStore) MarkMembersFetched(ctx context.Context, roomID id.RoomID) error {
 	_, err := store.Exec(ctx, `
-		INSERT INTO mx_room_state (room_id, members_fetched) VALUES ($1, true)
-		ON CONFLICT (room_id) DO UPDATE SET members_fetched=true
-	`, roomID)
+		INSERT INTO mx_room_state (room_id, members_fetched, members_fetched_at) VALUES ($1, true, $2)
+		ON CONFLICT (room_id) DO UPDATE SET members_fetched=true, members_fetched_at=excluded.members_fetched_at
+	`, roomID, time.Now().UnixMilli())
 	return err
 }
 
+// GetMembersFetchedAt returns the time when the full member list of the room was last stored.
+// If the member list hasn't been fetched, a zero time is returned.
+func (store *SQLStateStore) GetMembersFetchedAt(ctx context.Context, roomID id.RoomID) (time.Time, error) {
+	var fetchedAt sql.NullInt64
+	err := store.QueryRow(ctx, "SELECT members_fetched_at FROM mx_room_state WHERE room_id=$1 AND members_fetched=true", roomID).Scan(&fetchedAt)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !fetchedAt.Valid) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(fetchedAt.Int64), nil
+}
+
+// ShouldFetchMembers returns true if the full member list of the room should be fetched using the /members endpoint,
+// i.e. if the stored member list is incomplete (for example due to lazy-loading) or older than maxAge.
+// If maxAge is zero, the stored member list never expires.
+func (store *SQLStateStore) ShouldFetchMembers(ctx context.Context, roomID id.RoomID, maxAge time.Duration) (bool, error) {
+	fetched, err := store.HasFetchedMembers(ctx, roomID)
+	if err != nil {
+		return false, err
+	} else if !fetched {
+		return true, nil
+	} else if maxAge <= 0 {
+		return false, nil
+	}
+	fetchedAt, err := store.GetMembersFetchedAt(ctx, roomID)
+	if err != nil {
+		return false, err
+	}
+	// Member lists that were fetched before the timestamp was stored are treated as expired
+	return fetchedAt.IsZero() || time.Since(fetchedAt) > maxAge, nil
+}
+
 type userAndMembership struct {
 	UserID id.UserID
 	event.MemberEventContent
@@ -339,6 +490,11 @@ func (store *SQLStateStore) GetAllMembers(ctx context.Context, roomID id.RoomID)
 	return output, err
 }
 
+func (store *SQLStateStore) GetAllRoomIDs(ctx context.Context) ([]id.RoomID, error) {
+	rows, err := store.Query(ctx, "SELECT room_id FROM mx_room_state UNION SELECT DISTINCT room_id FROM mx_user_profile")
+	return dbutil.NewRowIterWithError(rows, dbutil.ScanSingleColumn[id.RoomID], err).AsList()
+}
+
 func (store *SQLStateStore) SetEncryptionEvent(ctx context.Context, roomID id.RoomID, content *event.EncryptionEventContent) error {
 	contentBytes, err := json.Marshal(content)
 	if err != nil {
@@ -348,10 +504,31 @@ func (store *SQLStateStore) SetEncryptionEvent(ctx context.Context, roomID id.Ro
 		INSERT INTO mx_room_state (room_id, encryption) VALUES ($1, $2)
 		ON CONFLICT (room_id) DO UPDATE SET encryption=excluded.encryption
 	`, roomID, contentBytes)
+	if err == nil {
+		store.cacheEncryptionEvent(roomID, content)
+	}
 	return err
 }
 
+func (store *SQLStateStore) cacheEncryptionEvent(roomID id.RoomID, content *event.EncryptionEventContent) {
+	store.encryptionCacheLock.Lock()
+	defer store.encryptionCacheLock.Unlock()
+	if content == nil {
+		delete(store.encryptionCache, roomID)
+		return
+	} else if store.encryptionCache == nil {
+		store.encryptionCache = make(map[id.RoomID]*event.EncryptionEventContent)
+	}
+	store.encryptionCache[roomID] = content
+}
+
 func (store *SQLStateStore) GetEncryptionEvent(ctx context.Context, roomID id.RoomID) (*event.EncryptionEventContent, error) {
+	store.encryptionCacheLock.RLock()
+	cached, ok := store.encryptionCache[roomID]
+	store.encryptionCacheLock.RUnlock()
+	if ok {
+		return cached, nil
+	}
 	var data []byte
 	err := store.
 		QueryRow(ctx, "SELECT encryption FROM mx_room_state WHERE room_id=$1", roomID).
@@ -368,6 +545,7 @@ func (store *SQLStateStore) GetEncryptionEvent(ctx context.Context, roomID id.Ro
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse content JSON: %w", err)
 	}
+	store.cacheEncryptionEvent(roomID, &content)
 	return &content, nil
 }
 
@@ -471,3 +649,133 @@ func (store *SQLStateStore) HasPowerLevel(ctx context.Context, roomID id.RoomID,
 		return levels.GetUserLevel(userID) >= levels.GetEventLevel(eventType), nil
 	}
 }
+
+var _ mautrix.StateAtEventStore = (*SQLStateStore)(nil)
+var _ mautrix.RoomListingStateStore = (*SQLStateStore)(nil)
+
+// AddStateDelta stores the given state event in the state history if StoreStateHistory is enabled.
+// Old entries are pruned periodically based on StateHistoryRetention.
+func (store *SQLStateStore) AddStateDelta(ctx context.Context, evt *event.Event) error {
+	if !store.StoreStateHistory {
+		return nil
+	} else if evt.StateKey == nil {
+		return fmt.Errorf("event is not a state event")
+	}
+	_, err := store.Exec(ctx, `
+		INSERT INTO mx_state_delta (room_id, event_id, event_type, state_key, sender, timestamp, content)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (room_id, event_id) DO NOTHING
+	`, evt.RoomID, evt.ID, evt.Type.Type, *evt.StateKey, evt.Sender, evt.Timestamp, dbutil.JSON{Data: &evt.Content})
+	if err == nil && store.RoomProfileHistoryLimit >= 0 && *evt.StateKey == "" && isRoomProfileEvent(evt.Type) {
+		err = store.pruneRoomProfileHistory(ctx, evt.RoomID, evt.Type)
+	}
+	if err == nil {
+		err = store.maybePruneStateHistory(ctx)
+	}
+	return err
+}
+
+func (store *SQLStateStore) maybePruneStateHistory(ctx context.Context) error {
+	store.lastStateHistoryPruneLock.Lock()
+	defer store.lastStateHistoryPruneLock.Unlock()
+	if time.Since(store.lastStateHistoryPrune) < StateHistoryPruneInterval {
+		return nil
+	}
+	store.lastStateHistoryPrune = time.Now()
+	return store.PruneStateHistory(ctx)
+}
+
+// PruneStateHistory deletes state history entries that are older than StateHistoryRetention
+// and have been replaced by a newer entry with the same type and state key.
+func (store *SQLStateStore) PruneStateHistory(ctx context.Context) error {
+	retention := store.StateHistoryRetention
+	if retention == 0 {
+		retention = DefaultStateHistoryRetention
+	} else if retention < 0 {
+		return nil
+	}
+	_, err := store.Exec(ctx, `
+		DELETE FROM mx_state_delta
+		WHERE timestamp<$1 AND EXISTS(
+			SELECT 1 FROM mx_state_delta newer
+			WHERE newer.room_id=mx_state_delta.room_id
+				AND newer.event_type=mx_state_delta.event_type
+				AND newer.state_key=mx_state_delta.state_key
+				AND newer.timestamp>mx_state_delta.timestamp
+		)
+	`, time.Now().Add(-retention).UnixMilli())
+	return err
+}
+
+// GetStateEventAt finds the state event that was in effect at the given event. This always returns nil
+// if StoreStateHistory is disabled.
+func (store *SQLStateStore) GetStateEventAt(ctx context.Context, roomID id.RoomID, evtType event.Type, stateKey string, at *event.Event) (*event.Event, error) {
+	if !store.StoreStateHistory {
+		return nil, nil
+	}
+	row := store.QueryRow(ctx, `
+		SELECT event_id, event_type, state_key, sender, timestamp, content FROM mx_state_delta
+		WHERE room_id=$1 AND event_type=$2 AND state_key=$3 AND (timestamp<$4 OR event_id=$5)
+		ORDER BY timestamp DESC LIMIT 1
+	`, roomID, evtType.Type, stateKey, at.Timestamp, at.ID)
+	evt, err := scanStateDelta(roomID, row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return evt, err
+}
+
+func scanStateDelta(roomID id.RoomID, row dbutil.Scannable) (*event.Event, error) {
+	var stateKey string
+	var content json.RawMessage
+	evt := &event.Event{RoomID: roomID, StateKey: &stateKey}
+	err := row.Scan(&evt.ID, &evt.Type.Type, &stateKey, &evt.Sender, &evt.Timestamp, &dbutil.JSON{Data: &content})
+	if err != nil {
+		return nil, err
+	}
+	evt.Type.Class = event.StateEventType
+	evt.Content.VeryRaw = content
+	err = evt.Content.ParseRaw(evt.Type)
+	if err != nil && !errors.Is(err, event.ErrUnsupportedContentType) {
+		return nil, fmt.Errorf("failed to parse content: %w", err)
+	}
+	return evt, nil
+}
+
+var _ mautrix.RoomProfileHistoryStore = (*SQLStateStore)(nil)
+
+func isRoomProfileEvent(evtType event.Type) bool {
+	switch evtType.Type {
+	case event.StateRoomName.Type, event.StateTopic.Type, event.StateRoomAvatar.Type:
+		return true
+	default:
+		return false
+	}
+}
+
+func (store *SQLStateStore) pruneRoomProfileHistory(ctx context.Context, roomID id.RoomID, evtType event.Type) error {
+	limit := store.RoomProfileHistoryLimit
+	if limit == 0 {
+		limit = DefaultRoomProfileHistoryLimit
+	}
+	_, err := store.Exec(ctx, `
+		DELETE FROM mx_state_delta
+		WHERE room_id=$1 AND event_type=$2 AND state_key='' AND event_id NOT IN (
+			SELECT event_id FROM mx_state_delta
+			WHERE room_id=$1 AND event_type=$2 AND state_key=''
+			ORDER BY timestamp DESC LIMIT $3
+		)
+	`, roomID, evtType.Type, limit)
+	return err
+}
+
+func (store *SQLStateStore) GetRoomProfileHistory(ctx context.Context, roomID id.RoomID, limit int) ([]*event.Event, error) {
+	rows, err := store.Query(ctx, `
+		SELECT event_id, event_type, state_key, sender, timestamp, content FROM mx_state_delta
+		WHERE room_id=$1 AND event_type IN ($2, $3, $4) AND state_key=''
+		ORDER BY timestamp DESC LIMIT $5
+	`, roomID, event.StateRoomName.Type, event.StateTopic.Type, event.StateRoomAvatar.Type, limit)
+	return dbutil.NewRowIterWithError(rows, func(row dbutil.Scannable) (*event.Event, error) {
+		return scanStateDelta(roomID, row)
+	}, err).AsList()
+}
@@ -0,0 +1,264 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sqlstatestore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/util/dbutil"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const testRoomID = id.RoomID("!room:example.com")
+
+func newTestStateStore(t *testing.T) *SQLStateStore {
+	rawDB, err := sql.Open("sqlite3", ":memory:?_busy_timeout=5000")
+	require.NoError(t, err)
+	// Every connection to :memory: is a separate database
+	rawDB.SetMaxOpenConns(1)
+	db, err := dbutil.NewWithDB(rawDB, "sqlite3")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	store := NewSQLStateStore(db, nil, false)
+	require.NoError(t, store.Upgrade(context.Background()))
+	return store
+}
+
+func memberEvent(eventID id.EventID, userID id.UserID, displayname string, ts time.Time) *event.Event {
+	stateKey := userID.String()
+	return &event.Event{
+		ID:        eventID,
+		RoomID:    testRoomID,
+		Type:      event.StateMember,
+		StateKey:  &stateKey,
+		Sender:    userID,
+		Timestamp: ts.UnixMilli(),
+		Content: event.Content{Parsed: &event.MemberEventContent{
+			Membership:  event.MembershipJoin,
+			Displayname: displayname,
+		}},
+	}
+}
+
+func countStateDeltas(t *testing.T, store *SQLStateStore) (count int) {
+	require.NoError(t, store.QueryRow(context.Background(), "SELECT COUNT(*) FROM mx_state_delta").Scan(&count))
+	return
+}
+
+func TestSQLStateStore_StateHistoryDisabled(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStateStore(t)
+	evt := memberEvent("$join", "@alice:example.com", "Alice", time.Now())
+	require.NoError(t, store.AddStateDelta(ctx, evt))
+	assert.Equal(t, 0, countStateDeltas(t, store))
+	at, err := store.GetStateEventAt(ctx, testRoomID, event.StateMember, "@alice:example.com", evt)
+	require.NoError(t, err)
+	assert.Nil(t, at)
+}
+
+func TestSQLStateStore_StateHistory(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStateStore(t)
+	store.StoreStateHistory = true
+	now := time.Now()
+	first := memberEvent("$first", "@alice:example.com", "Alice", now.Add(-2*time.Minute))
+	second := memberEvent("$second", "@alice:example.com", "Alice 2", now.Add(-1*time.Minute))
+	require.NoError(t, store.AddStateDelta(ctx, first))
+	require.NoError(t, store.AddStateDelta(ctx, second))
+
+	msg := &event.Event{ID: "$msg", RoomID: testRoomID, Timestamp: now.Add(-90 * time.Second).UnixMilli()}
+	at, err := store.GetStateEventAt(ctx, testRoomID, event.StateMember, "@alice:example.com", msg)
+	require.NoError(t, err)
+	require.NotNil(t, at)
+	assert.Equal(t, id.EventID("$first"), at.ID)
+	assert.Equal(t, "Alice", at.Content.AsMember().Displayname)
+}
+
+func TestSQLStateStore_PruneStateHistory(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStateStore(t)
+	store.StoreStateHistory = true
+	store.StateHistoryRetention = 24 * time.Hour
+	old := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, store.AddStateDelta(ctx, memberEvent("$alice1", "@alice:example.com", "Alice", old)))
+	require.NoError(t, store.AddStateDelta(ctx, memberEvent("$alice2", "@alice:example.com", "Alice 2", old.Add(time.Minute))))
+	require.NoError(t, store.AddStateDelta(ctx, memberEvent("$alice3", "@alice:example.com", "Alice 3", time.Now())))
+	require.NoError(t, store.AddStateDelta(ctx, memberEvent("$bob1", "@bob:example.com", "Bob", old)))
+	assert.Equal(t, 4, countStateDeltas(t, store))
+
+	require.NoError(t, store.PruneStateHistory(ctx))
+	// Old replaced entries are deleted, but old entries that are still the current state are kept
+	assert.Equal(t, 2, countStateDeltas(t, store))
+	latest := &event.Event{ID: "$msg", RoomID: testRoomID, Timestamp: time.Now().Add(time.Minute).UnixMilli()}
+	at, err := store.GetStateEventAt(ctx, testRoomID, event.StateMember, "@bob:example.com", latest)
+	require.NoError(t, err)
+	require.NotNil(t, at)
+	assert.Equal(t, id.EventID("$bob1"), at.ID)
+}
+
+func TestSQLStateStore_RoomProfileHistoryLimit(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStateStore(t)
+	store.StoreStateHistory = true
+	start := time.Now().Add(-time.Hour)
+	emptyStateKey := ""
+	for i := 0; i < DefaultRoomProfileHistoryLimit+5; i++ {
+		require.NoError(t, store.AddStateDelta(ctx, &event.Event{
+			ID:        id.EventID(fmt.Sprintf("$name%d", i)),
+			RoomID:    testRoomID,
+			Type:      event.StateRoomName,
+			StateKey:  &emptyStateKey,
+			Sender:    "@alice:example.com",
+			Timestamp: start.Add(time.Duration(i) * time.Second).UnixMilli(),
+			Content:   event.Content{Parsed: &event.RoomNameEventContent{Name: fmt.Sprintf("Name %d", i)}},
+		}))
+	}
+	history, err := store.GetRoomProfileHistory(ctx, testRoomID, 100)
+	require.NoError(t, err)
+	require.Len(t, history, DefaultRoomProfileHistoryLimit)
+	assert.Equal(t, id.EventID(fmt.Sprintf("$name%d", DefaultRoomProfileHistoryLimit+4)), history[0].ID)
+}
+
+func TestSQLStateStore_TransactionIDs(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStateStore(t)
+	store.AppServiceID = "as1"
+	other := NewSQLStateStore(store.Database, nil, false)
+	other.AppServiceID = "as2"
+
+	require.NoError(t, store.MarkTransactionProcessed(ctx, "txn1"))
+	processed, err := store.IsTransactionProcessed(ctx, "txn1")
+	require.NoError(t, err)
+	assert.True(t, processed)
+	// Transaction IDs are scoped to the appservice
+	processed, err = other.IsTransactionProcessed(ctx, "txn1")
+	require.NoError(t, err)
+	assert.False(t, processed)
+
+	old := time.Now().Add(-TransactionRetention - time.Hour).UnixMilli()
+	_, err = store.Exec(ctx, "INSERT INTO mx_appservice_txn (appservice_id, txn_id, processed_at) VALUES ('as1', 'old', $1), ('as2', 'old', $1)", old)
+	require.NoError(t, err)
+	// Pruning only happens once per interval
+	require.NoError(t, store.MarkTransactionProcessed(ctx, "txn2"))
+	processed, err = store.IsTransactionProcessed(ctx, "old")
+	require.NoError(t, err)
+	assert.True(t, processed)
+
+	store.lastTransactionPrune = time.Time{}
+	require.NoError(t, store.MarkTransactionProcessed(ctx, "txn3"))
+	processed, err = store.IsTransactionProcessed(ctx, "old")
+	require.NoError(t, err)
+	assert.False(t, processed)
+	processed, err = other.IsTransactionProcessed(ctx, "old")
+	require.NoError(t, err)
+	assert.True(t, processed)
+}
+
+func TestSQLStateStore_GetRoomMembersPage(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStateStore(t)
+	require.NoError(t, store.SetMembership(ctx, testRoomID, "@d:example.com", event.MembershipJoin))
+	require.NoError(t, store.SetMembership(ctx, testRoomID, "@b:example.com", event.MembershipInvite))
+	require.NoError(t, store.SetMembership(ctx, testRoomID, "@a:example.com", event.MembershipJoin))
+	require.NoError(t, store.SetMembership(ctx, testRoomID, "@c:example.com", event.MembershipLeave))
+	require.NoError(t, store.SetMembership(ctx, "!other:example.com", "@e:example.com", event.MembershipJoin))
+
+	userIDs := func(members []*Member) (out []id.UserID) {
+		for _, member := range members {
+			out = append(out, member.UserID)
+		}
+		return
+	}
+	page, err := store.GetRoomMembersPage(ctx, testRoomID, "", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []id.UserID{"@a:example.com", "@b:example.com"}, userIDs(page))
+	assert.Equal(t, event.MembershipInvite, page[1].Membership)
+	page, err = store.GetRoomMembersPage(ctx, testRoomID, page[1].UserID, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []id.UserID{"@c:example.com", "@d:example.com"}, userIDs(page))
+	page, err = store.GetRoomMembersPage(ctx, testRoomID, page[1].UserID, 2)
+	require.NoError(t, err)
+	assert.Empty(t, page)
+
+	page, err = store.GetRoomMembersPage(ctx, testRoomID, "", 10, event.MembershipJoin, event.MembershipInvite)
+	require.NoError(t, err)
+	assert.Equal(t, []id.UserID{"@a:example.com", "@b:example.com", "@d:example.com"}, userIDs(page))
+	page, err = store.GetRoomMembersPage(ctx, testRoomID, "@a:example.com", 1, event.MembershipJoin)
+	require.NoError(t, err)
+	assert.Equal(t, []id.UserID{"@d:example.com"}, userIDs(page))
+
+	count, err := store.CountRoomMembers(ctx, testRoomID)
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+	count, err = store.CountRoomMembers(ctx, testRoomID, event.MembershipJoin, event.MembershipInvite)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	count, err = store.CountRoomMembers(ctx, testRoomID, event.MembershipBan)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestSQLStateStore_MembersFetched(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStateStore(t)
+
+	fetchedAt, err := store.GetMembersFetchedAt(ctx, testRoomID)
+	require.NoError(t, err)
+	assert.True(t, fetchedAt.IsZero())
+	shouldFetch, err := store.ShouldFetchMembers(ctx, testRoomID, 0)
+	require.NoError(t, err)
+	assert.True(t, shouldFetch)
+
+	before := time.Now().Add(-time.Millisecond)
+	require.NoError(t, store.MarkMembersFetched(ctx, testRoomID))
+	fetchedAt, err = store.GetMembersFetchedAt(ctx, testRoomID)
+	require.NoError(t, err)
+	assert.WithinRange(t, fetchedAt, before, time.Now())
+	shouldFetch, err = store.ShouldFetchMembers(ctx, testRoomID, 0)
+	require.NoError(t, err)
+	assert.False(t, shouldFetch)
+	shouldFetch, err = store.ShouldFetchMembers(ctx, testRoomID, time.Hour)
+	require.NoError(t, err)
+	assert.False(t, shouldFetch)
+
+	// Member lists older than the max age must be refetched
+	_, err = store.Exec(ctx, "UPDATE mx_room_state SET members_fetched_at=$1 WHERE room_id=$2", time.Now().Add(-2*time.Hour).UnixMilli(), testRoomID)
+	require.NoError(t, err)
+	shouldFetch, err = store.ShouldFetchMembers(ctx, testRoomID, time.Hour)
+	require.NoError(t, err)
+	assert.True(t, shouldFetch)
+
+	// Member lists fetched before the timestamp column existed are treated as expired
+	_, err = store.Exec(ctx, "UPDATE mx_room_state SET members_fetched_at=NULL WHERE room_id=$1", testRoomID)
+	require.NoError(t, err)
+	shouldFetch, err = store.ShouldFetchMembers(ctx, testRoomID, time.Hour)
+	require.NoError(t, err)
+	assert.True(t, shouldFetch)
+	shouldFetch, err = store.ShouldFetchMembers(ctx, testRoomID, 0)
+	require.NoError(t, err)
+	assert.False(t, shouldFetch)
+
+	require.NoError(t, store.MarkMembersFetched(ctx, testRoomID))
+	require.NoError(t, store.ClearCachedMembers(ctx, testRoomID))
+	fetched, err := store.HasFetchedMembers(ctx, testRoomID)
+	require.NoError(t, err)
+	assert.False(t, fetched)
+	fetchedAt, err = store.GetMembersFetchedAt(ctx, testRoomID)
+	require.NoError(t, err)
+	assert.True(t, fetchedAt.IsZero())
+}
@@ -1,4 +1,4 @@
--- v0 -> v7 (compatible with v3+): Latest revision
+-- v0 -> v10 (compatible with v3+): Latest revision
 
 CREATE TABLE mx_registrations (
 	user_id TEXT PRIMARY KEY
@@ -26,5 +26,30 @@ CREATE TABLE mx_room_state (
 	room_id         TEXT PRIMARY KEY,
 	power_levels    jsonb,
 	encryption      jsonb,
-	members_fetched BOOLEAN NOT NULL DEFAULT false
+	members_fetched BOOLEAN NOT NULL DEFAULT false,
+	members_fetched_at BIGINT
 );
+
+CREATE TABLE mx_appservice_txn (
+	appservice_id TEXT   NOT NULL,
+	txn_id        TEXT   NOT NULL,
+	processed_at  BIGINT NOT NULL,
+
+	PRIMARY KEY (appservice_id, txn_id)
+);
+
+CREATE INDEX mx_appservice_txn_processed_at_idx ON mx_appservice_txn (processed_at);
+
+CREATE TABLE mx_state_delta (
+	room_id    TEXT   NOT NULL,
+	event_id   TEXT   NOT NULL,
+	event_type TEXT   NOT NULL,
+	state_key  TEXT   NOT NULL,
+	sender     TEXT   NOT NULL,
+	timestamp  BIGINT NOT NULL,
+	content    jsonb  NOT NULL,
+
+	PRIMARY KEY (room_id, event_id)
+);
+
+CREATE INDEX mx_state_delta_lookup_idx ON mx_state_delta (room_id, event_type, state_key, timestamp);
@@ -0,0 +1,10 @@
+-- v8 (compatible with v3+): Add table for deduplicating appservice transactions
+CREATE TABLE mx_appservice_txn (
+	appservice_id TEXT   NOT NULL,
+	txn_id        TEXT   NOT NULL,
+	processed_at  BIGINT NOT NULL,
+
+	PRIMARY KEY (appservice_id, txn_id)
+);
+
+CREATE INDEX mx_appservice_txn_processed_at_idx ON mx_appservice_txn (processed_at);
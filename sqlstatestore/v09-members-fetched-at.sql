@@ -0,0 +1,2 @@
+-- v9 (compatible with v3+): Add timestamp for when the full member list was fetched
+ALTER TABLE mx_room_state ADD COLUMN members_fetched_at BIGINT;
@@ -0,0 +1,14 @@
+-- v10 (compatible with v3+): Add table for state history
+CREATE TABLE mx_state_delta (
+	room_id    TEXT   NOT NULL,
+	event_id   TEXT   NOT NULL,
+	event_type TEXT   NOT NULL,
+	state_key  TEXT   NOT NULL,
+	sender     TEXT   NOT NULL,
+	timestamp  BIGINT NOT NULL,
+	content    jsonb  NOT NULL,
+
+	PRIMARY KEY (room_id, event_id)
+);
+
+CREATE INDEX mx_state_delta_lookup_idx ON mx_state_delta (room_id, event_type, state_key, timestamp);
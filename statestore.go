@@ -39,6 +39,9 @@ type StateStore interface {
 	GetAllMembers(ctx context.Context, roomID id.RoomID) (map[id.UserID]*event.MemberEventContent, error)
 
 	SetEncryptionEvent(ctx context.Context, roomID id.RoomID, content *event.EncryptionEventContent) error
+	// GetEncryptionEvent returns the full m.room.encryption content of the room, or nil if the room isn't encrypted.
+	GetEncryptionEvent(ctx context.Context, roomID id.RoomID) (*event.EncryptionEventContent, error)
+	// IsEncrypted returns true if the room has a supported encryption algorithm enabled.
 	IsEncrypted(ctx context.Context, roomID id.RoomID) (bool, error)
 
 	GetRoomJoinedOrInvitedMembers(ctx context.Context, roomID id.RoomID) ([]id.UserID, error)
@@ -48,10 +51,57 @@ type StateStoreUpdater interface {
 	UpdateState(ctx context.Context, evt *event.Event)
 }
 
+// StateAtEventStore is an optional extension of StateStore for stores that keep a history of state changes.
+// It allows finding the state of a room as it was when an older event was sent, which is needed for
+// e.g. rendering the correct historical displaynames and avatars of backfilled messages.
+//
+// UpdateStateStore will automatically call AddStateDelta for all state events if the store implements this interface.
+type StateAtEventStore interface {
+	// AddStateDelta stores a state event in the state history.
+	AddStateDelta(ctx context.Context, evt *event.Event) error
+	// GetStateEventAt finds the state event with the given type and state key that was in effect when the given
+	// event was sent. If the given event is itself the state event, it is returned.
+	// If there's no state event in the history, this returns nil without an error.
+	GetStateEventAt(ctx context.Context, roomID id.RoomID, evtType event.Type, stateKey string, at *event.Event) (*event.Event, error)
+}
+
+// RoomProfileHistoryStore is an optional extension of StateStore for stores that keep a history of changes to the
+// room name, topic and avatar. The changes are recorded using the same state deltas as StateAtEventStore.
+type RoomProfileHistoryStore interface {
+	// GetRoomProfileHistory returns the most recent room name, topic and avatar changes in the room, newest first.
+	GetRoomProfileHistory(ctx context.Context, roomID id.RoomID, limit int) ([]*event.Event, error)
+}
+
+// GetMemberAtEvent returns the member info of the given user as of the given event.
+// If the state store doesn't support historical state queries or the history doesn't contain the user,
+// the current member info is returned instead.
+func GetMemberAtEvent(ctx context.Context, store StateStore, userID id.UserID, at *event.Event) (*event.MemberEventContent, error) {
+	if historyStore, ok := store.(StateAtEventStore); ok {
+		evt, err := historyStore.GetStateEventAt(ctx, at.RoomID, event.StateMember, userID.String(), at)
+		if err != nil {
+			return nil, err
+		} else if evt != nil {
+			if member, ok := evt.Content.Parsed.(*event.MemberEventContent); ok {
+				return member, nil
+			}
+		}
+	}
+	return store.GetMember(ctx, at.RoomID, userID)
+}
+
 func UpdateStateStore(ctx context.Context, store StateStore, evt *event.Event) {
 	if store == nil || evt == nil || evt.StateKey == nil {
 		return
 	}
+	if historyStore, ok := store.(StateAtEventStore); ok && evt.ID != "" {
+		err := historyStore.AddStateDelta(ctx, evt)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).
+				Stringer("event_id", evt.ID).
+				Str("event_type", evt.Type.Type).
+				Msg("Failed to add state delta to state store")
+		}
+	}
 	if directUpdater, ok := store.(StateStoreUpdater); ok {
 		directUpdater.UpdateState(ctx, evt)
 		return
@@ -132,6 +182,30 @@ func (store *MemoryStateStore) MarkRegistered(_ context.Context, userID id.UserI
 	return nil
 }
 
+func (store *MemoryStateStore) GetAllRoomIDs(_ context.Context) ([]id.RoomID, error) {
+	roomIDs := make(map[id.RoomID]struct{})
+	store.membersLock.RLock()
+	for roomID := range store.Members {
+		roomIDs[roomID] = struct{}{}
+	}
+	store.membersLock.RUnlock()
+	store.powerLevelsLock.RLock()
+	for roomID := range store.PowerLevels {
+		roomIDs[roomID] = struct{}{}
+	}
+	store.powerLevelsLock.RUnlock()
+	store.encryptionLock.RLock()
+	for roomID := range store.Encryption {
+		roomIDs[roomID] = struct{}{}
+	}
+	store.encryptionLock.RUnlock()
+	roomIDList := make([]id.RoomID, 0, len(roomIDs))
+	for roomID := range roomIDs {
+		roomIDList = append(roomIDList, roomID)
+	}
+	return roomIDList, nil
+}
+
 func (store *MemoryStateStore) GetRoomMembers(_ context.Context, roomID id.RoomID) (map[id.UserID]*event.MemberEventContent, error) {
 	store.membersLock.RLock()
 	members, ok := store.Members[roomID]
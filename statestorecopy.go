@@ -0,0 +1,102 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// RoomListingStateStore is an optional extension of StateStore for stores that can list all rooms they have state for.
+// It's required for the source store in CopyStateStore.
+type RoomListingStateStore interface {
+	GetAllRoomIDs(ctx context.Context) ([]id.RoomID, error)
+}
+
+// ErrStateStoreNotListable is returned by CopyStateStore if the source store doesn't implement RoomListingStateStore.
+var ErrStateStoreNotListable = errors.New("source state store doesn't support listing rooms")
+
+// CopyStateStore copies the members, power levels and encryption state of all rooms from one state store to another.
+// This can be used to migrate e.g. from the in-memory store to a database-backed store.
+//
+// The progress callback is optional and is called after each room is copied.
+// Appservice user registrations and state history are not copied.
+func CopyStateStore(ctx context.Context, src, dst StateStore, progress func(done, total int)) error {
+	lister, ok := src.(RoomListingStateStore)
+	if !ok {
+		return ErrStateStoreNotListable
+	}
+	roomIDs, err := lister.GetAllRoomIDs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list rooms: %w", err)
+	}
+	for i, roomID := range roomIDs {
+		if err = ctx.Err(); err != nil {
+			return err
+		} else if err = copyRoomState(ctx, src, dst, roomID); err != nil {
+			return fmt.Errorf("failed to copy state of %s: %w", roomID, err)
+		}
+		if progress != nil {
+			progress(i+1, len(roomIDs))
+		}
+	}
+	return nil
+}
+
+func copyRoomState(ctx context.Context, src, dst StateStore, roomID id.RoomID) error {
+	members, err := src.GetAllMembers(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("failed to get members: %w", err)
+	}
+	fetched, err := src.HasFetchedMembers(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("failed to check if members are fetched: %w", err)
+	}
+	memberEvts := make([]*event.Event, 0, len(members))
+	for userID, member := range members {
+		stateKey := userID.String()
+		memberEvts = append(memberEvts, &event.Event{
+			Type:     event.StateMember,
+			RoomID:   roomID,
+			StateKey: &stateKey,
+			Content:  event.Content{Parsed: member},
+		})
+	}
+	if fetched && len(memberEvts) == 0 {
+		err = dst.MarkMembersFetched(ctx, roomID)
+	} else if fetched {
+		err = dst.ReplaceCachedMembers(ctx, roomID, memberEvts)
+	} else {
+		for _, evt := range memberEvts {
+			if err = dst.SetMember(ctx, roomID, id.UserID(*evt.StateKey), evt.Content.AsMember()); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set members: %w", err)
+	}
+	if pl, err := src.GetPowerLevels(ctx, roomID); err != nil {
+		return fmt.Errorf("failed to get power levels: %w", err)
+	} else if pl != nil {
+		if err = dst.SetPowerLevels(ctx, roomID, pl); err != nil {
+			return fmt.Errorf("failed to set power levels: %w", err)
+		}
+	}
+	if encryption, err := src.GetEncryptionEvent(ctx, roomID); err != nil {
+		return fmt.Errorf("failed to get encryption event: %w", err)
+	} else if encryption != nil {
+		if err = dst.SetEncryptionEvent(ctx, roomID, encryption); err != nil {
+			return fmt.Errorf("failed to set encryption event: %w", err)
+		}
+	}
+	return nil
+}
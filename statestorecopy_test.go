@@ -0,0 +1,49 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestCopyStateStore(t *testing.T) {
+	ctx := context.Background()
+	src := mautrix.NewMemoryStateStore()
+	require.NoError(t, src.SetMember(ctx, "!a:example.org", "@user:example.org", &event.MemberEventContent{Membership: event.MembershipJoin, Displayname: "User"}))
+	require.NoError(t, src.MarkMembersFetched(ctx, "!a:example.org"))
+	require.NoError(t, src.SetPowerLevels(ctx, "!a:example.org", &event.PowerLevelsEventContent{UsersDefault: 10}))
+	require.NoError(t, src.SetEncryptionEvent(ctx, "!b:example.org", &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1}))
+
+	dst := mautrix.NewLRUStateStore(0, 0)
+	var progressCalls int
+	err := mautrix.CopyStateStore(ctx, src, dst, func(done, total int) {
+		progressCalls++
+		assert.Equal(t, 2, total)
+		assert.Equal(t, progressCalls, done)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, progressCalls)
+
+	member, err := dst.GetMember(ctx, "!a:example.org", "@user:example.org")
+	require.NoError(t, err)
+	assert.Equal(t, "User", member.Displayname)
+	fetched, _ := dst.HasFetchedMembers(ctx, "!a:example.org")
+	assert.True(t, fetched)
+	pl, _ := dst.GetPowerLevels(ctx, "!a:example.org")
+	require.NotNil(t, pl)
+	assert.Equal(t, 10, pl.UsersDefault)
+	encrypted, _ := dst.IsEncrypted(ctx, "!b:example.org")
+	assert.True(t, encrypted)
+}
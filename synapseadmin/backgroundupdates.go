@@ -0,0 +1,65 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package synapseadmin
+
+import (
+	"context"
+	"net/http"
+)
+
+type BackgroundUpdate struct {
+	Name              string  `json:"name"`
+	TotalItemCount    int64   `json:"total_item_count"`
+	TotalDurationMS   float64 `json:"total_duration_ms"`
+	AverageItemsPerMS float64 `json:"average_items_per_ms"`
+}
+
+type RespBackgroundUpdatesStatus struct {
+	Enabled bool `json:"enabled"`
+	// The currently running background update of each database, keyed by database name.
+	CurrentUpdates map[string]BackgroundUpdate `json:"current_updates"`
+}
+
+// GetBackgroundUpdatesStatus gets whether background updates are enabled and which updates are currently running.
+//
+// https://matrix-org.github.io/synapse/latest/usage/administration/admin_api/background_updates.html#status
+func (cli *Client) GetBackgroundUpdatesStatus(ctx context.Context) (resp *RespBackgroundUpdatesStatus, err error) {
+	_, err = cli.MakeRequest(ctx, http.MethodGet, cli.BuildAdminURL("v1", "background_updates", "status"), nil, &resp)
+	return
+}
+
+type ReqSetBackgroundUpdatesEnabled struct {
+	Enabled bool `json:"enabled"`
+}
+
+// SetBackgroundUpdatesEnabled pauses or resumes background updates. The setting is not persisted across restarts.
+//
+// https://matrix-org.github.io/synapse/latest/usage/administration/admin_api/background_updates.html#enabled
+func (cli *Client) SetBackgroundUpdatesEnabled(ctx context.Context, enabled bool) error {
+	req := ReqSetBackgroundUpdatesEnabled{Enabled: enabled}
+	_, err := cli.MakeRequest(ctx, http.MethodPost, cli.BuildAdminURL("v1", "background_updates", "enabled"), &req, nil)
+	return err
+}
+
+// Background update jobs that can be started with StartBackgroundUpdateJob.
+const (
+	BackgroundJobPopulateStatsProcessRooms = "populate_stats_process_rooms"
+	BackgroundJobRegenerateDirectory       = "regenerate_directory"
+)
+
+type ReqStartBackgroundUpdateJob struct {
+	JobName string `json:"job_name"`
+}
+
+// StartBackgroundUpdateJob starts a background update job, such as regenerating the user directory.
+//
+// https://matrix-org.github.io/synapse/latest/usage/administration/admin_api/background_updates.html#run
+func (cli *Client) StartBackgroundUpdateJob(ctx context.Context, jobName string) error {
+	req := ReqStartBackgroundUpdateJob{JobName: jobName}
+	_, err := cli.MakeRequest(ctx, http.MethodPost, cli.BuildAdminURL("v1", "background_updates", "start_job"), &req, nil)
+	return err
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package synapseadmin
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// QuarantineMedia quarantines a single piece of media, which prevents it from being downloaded.
+//
+// https://matrix-org.github.io/synapse/latest/admin_api/media_admin_api.html#quarantining-media-by-id
+func (cli *Client) QuarantineMedia(ctx context.Context, mxc id.ContentURI) error {
+	_, err := cli.MakeRequest(ctx, http.MethodPost, cli.BuildAdminURL("v1", "media", "quarantine", mxc.Homeserver, mxc.FileID), nil, nil)
+	return err
+}
+
+// UnquarantineMedia removes a single piece of media from quarantine.
+//
+// https://matrix-org.github.io/synapse/latest/admin_api/media_admin_api.html#remove-media-from-quarantine-by-id
+func (cli *Client) UnquarantineMedia(ctx context.Context, mxc id.ContentURI) error {
+	_, err := cli.MakeRequest(ctx, http.MethodPost, cli.BuildAdminURL("v1", "media", "unquarantine", mxc.Homeserver, mxc.FileID), nil, nil)
+	return err
+}
+
+type RespQuarantineMedia struct {
+	NumQuarantined int `json:"num_quarantined"`
+}
+
+// QuarantineRoomMedia quarantines all media in a room.
+//
+// https://matrix-org.github.io/synapse/latest/admin_api/media_admin_api.html#quarantining-media-in-a-room
+func (cli *Client) QuarantineRoomMedia(ctx context.Context, roomID id.RoomID) (resp *RespQuarantineMedia, err error) {
+	_, err = cli.MakeRequest(ctx, http.MethodPost, cli.BuildAdminURL("v1", "room", roomID, "media", "quarantine"), nil, &resp)
+	return
+}
+
+// QuarantineUserMedia quarantines all local media uploaded by a user.
+//
+// https://matrix-org.github.io/synapse/latest/admin_api/media_admin_api.html#quarantining-all-media-of-a-user
+func (cli *Client) QuarantineUserMedia(ctx context.Context, userID id.UserID) (resp *RespQuarantineMedia, err error) {
+	_, err = cli.MakeRequest(ctx, http.MethodPost, cli.BuildAdminURL("v1", "user", userID, "media", "quarantine"), nil, &resp)
+	return
+}
+
+// ProtectMedia protects or unprotects a piece of local media from being quarantined.
+//
+// https://matrix-org.github.io/synapse/latest/admin_api/media_admin_api.html#protecting-media-from-being-quarantined
+func (cli *Client) ProtectMedia(ctx context.Context, mediaID string, protect bool) error {
+	action := "protect"
+	if !protect {
+		action = "unprotect"
+	}
+	_, err := cli.MakeRequest(ctx, http.MethodPost, cli.BuildAdminURL("v1", "media", action, mediaID), nil, nil)
+	return err
+}
+
+type RespListRoomMedia struct {
+	Local  []id.ContentURIString `json:"local"`
+	Remote []id.ContentURIString `json:"remote"`
+}
+
+// ListRoomMedia lists all media in a room.
+//
+// https://matrix-org.github.io/synapse/latest/admin_api/media_admin_api.html#list-all-media-in-a-room
+func (cli *Client) ListRoomMedia(ctx context.Context, roomID id.RoomID) (resp *RespListRoomMedia, err error) {
+	_, err = cli.MakeRequest(ctx, http.MethodGet, cli.BuildAdminURL("v1", "room", roomID, "media"), nil, &resp)
+	return
+}
+
+type RespDeleteMedia struct {
+	DeletedMedia []string `json:"deleted_media"`
+	Total        int      `json:"total"`
+}
+
+// DeleteMedia deletes a single piece of local media.
+//
+// https://matrix-org.github.io/synapse/latest/admin_api/media_admin_api.html#delete-a-specific-local-media
+func (cli *Client) DeleteMedia(ctx context.Context, mxc id.ContentURI) (resp *RespDeleteMedia, err error) {
+	_, err = cli.MakeRequest(ctx, http.MethodDelete, cli.BuildAdminURL("v1", "media", mxc.Homeserver, mxc.FileID), nil, &resp)
+	return
+}
+
+type RespPurgeMediaCache struct {
+	Deleted int `json:"deleted"`
+}
+
+// PurgeRemoteMediaCache deletes cached copies of remote media that were last accessed before the given time.
+//
+// https://matrix-org.github.io/synapse/latest/admin_api/media_admin_api.html#purge-remote-media-api
+func (cli *Client) PurgeRemoteMediaCache(ctx context.Context, before time.Time) (resp *RespPurgeMediaCache, err error) {
+	reqURL := cli.BuildURLWithQuery(mautrix.SynapseAdminURLPath{"v1", "purge_media_cache"}, map[string]string{
+		"before_ts": strconv.FormatInt(before.UnixMilli(), 10),
+	})
+	_, err = cli.MakeRequest(ctx, http.MethodPost, reqURL, nil, &resp)
+	return
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package synapseadmin
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"go.mau.fi/util/jsontime"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type ReqListEventReports struct {
+	From      int
+	Limit     int
+	Direction mautrix.Direction
+	UserID    id.UserID
+	RoomID    id.RoomID
+}
+
+func (req *ReqListEventReports) BuildQuery() map[string]string {
+	query := map[string]string{
+		"from": strconv.Itoa(req.From),
+	}
+	if req.Limit != 0 {
+		query["limit"] = strconv.Itoa(req.Limit)
+	}
+	if req.Direction != 0 {
+		query["dir"] = string(req.Direction)
+	}
+	if req.UserID != "" {
+		query["user_id"] = string(req.UserID)
+	}
+	if req.RoomID != "" {
+		query["room_id"] = string(req.RoomID)
+	}
+	return query
+}
+
+type EventReport struct {
+	ID             int                `json:"id"`
+	ReceivedTS     jsontime.UnixMilli `json:"received_ts"`
+	RoomID         id.RoomID          `json:"room_id"`
+	RoomName       string             `json:"name"`
+	CanonicalAlias id.RoomAlias       `json:"canonical_alias"`
+	EventID        id.EventID         `json:"event_id"`
+	Sender         id.UserID          `json:"sender"`
+	UserID         id.UserID          `json:"user_id"`
+	Reason         string             `json:"reason"`
+	Score          *int               `json:"score"`
+
+	// The reported event. Only included when getting a single report.
+	Event *event.Event `json:"event_json,omitempty"`
+}
+
+type RespListEventReports struct {
+	EventReports []*EventReport `json:"event_reports"`
+	NextToken    int            `json:"next_token"`
+	Total        int            `json:"total"`
+}
+
+// ListEventReports lists events reported by users.
+//
+// https://matrix-org.github.io/synapse/latest/admin_api/event_reports.html#show-reported-events
+func (cli *Client) ListEventReports(ctx context.Context, req ReqListEventReports) (resp *RespListEventReports, err error) {
+	reqURL := cli.BuildURLWithQuery(mautrix.SynapseAdminURLPath{"v1", "event_reports"}, req.BuildQuery())
+	_, err = cli.MakeRequest(ctx, http.MethodGet, reqURL, nil, &resp)
+	return
+}
+
+// GetEventReport gets the details of a single event report, including the reported event.
+//
+// https://matrix-org.github.io/synapse/latest/admin_api/event_reports.html#show-details-of-a-specific-event-report
+func (cli *Client) GetEventReport(ctx context.Context, reportID int) (resp *EventReport, err error) {
+	_, err = cli.MakeRequest(ctx, http.MethodGet, cli.BuildAdminURL("v1", "event_reports", reportID), nil, &resp)
+	return
+}
+
+// DeleteEventReport deletes an event report.
+//
+// https://matrix-org.github.io/synapse/latest/admin_api/event_reports.html#delete-a-specific-event-report
+func (cli *Client) DeleteEventReport(ctx context.Context, reportID int) error {
+	_, err := cli.MakeRequest(ctx, http.MethodDelete, cli.BuildAdminURL("v1", "event_reports", reportID), nil, nil)
+	return err
+}
@@ -133,6 +133,62 @@ func (cli *Client) DeleteRoom(ctx context.Context, roomID id.RoomID, req ReqDele
 	return resp, err
 }
 
+type DeleteRoomStatus string
+
+const (
+	DeleteRoomStatusShuttingDown DeleteRoomStatus = "shutting_down"
+	DeleteRoomStatusPurging      DeleteRoomStatus = "purging"
+	DeleteRoomStatusComplete     DeleteRoomStatus = "complete"
+	DeleteRoomStatusFailed       DeleteRoomStatus = "failed"
+)
+
+type RespDeleteRoomStatus struct {
+	DeleteID     string           `json:"delete_id,omitempty"`
+	Status       DeleteRoomStatus `json:"status"`
+	Error        string           `json:"error,omitempty"`
+	ShutdownRoom struct {
+		KickedUsers       []id.UserID    `json:"kicked_users"`
+		FailedToKickUsers []id.UserID    `json:"failed_to_kick_users"`
+		LocalAliases      []id.RoomAlias `json:"local_aliases"`
+		NewRoomID         id.RoomID      `json:"new_room_id"`
+	} `json:"shutdown_room"`
+}
+
+// GetDeleteRoomStatus gets the status of a room deletion started with DeleteRoom.
+//
+// https://matrix-org.github.io/synapse/latest/admin_api/rooms.html#query-by-delete_id
+func (cli *Client) GetDeleteRoomStatus(ctx context.Context, deleteID string) (resp *RespDeleteRoomStatus, err error) {
+	_, err = cli.MakeRequest(ctx, http.MethodGet, cli.BuildAdminURL("v2", "rooms", "delete_status", deleteID), nil, &resp)
+	return
+}
+
+type RespListDeleteRoomStatus struct {
+	Results []*RespDeleteRoomStatus `json:"results"`
+}
+
+// ListDeleteRoomStatus gets the status of all recent deletions of the given room.
+//
+// https://matrix-org.github.io/synapse/latest/admin_api/rooms.html#query-by-room_id
+func (cli *Client) ListDeleteRoomStatus(ctx context.Context, roomID id.RoomID) (resp *RespListDeleteRoomStatus, err error) {
+	_, err = cli.MakeRequest(ctx, http.MethodGet, cli.BuildAdminURL("v2", "rooms", roomID, "delete_status"), nil, &resp)
+	return
+}
+
+type RespRoomDetails struct {
+	RoomInfo
+	Topic     string              `json:"topic"`
+	Avatar    id.ContentURIString `json:"avatar"`
+	Forgotten bool                `json:"forgotten"`
+}
+
+// GetRoomDetails gets information about a single room.
+//
+// https://matrix-org.github.io/synapse/latest/admin_api/rooms.html#room-details-api
+func (cli *Client) GetRoomDetails(ctx context.Context, roomID id.RoomID) (resp *RespRoomDetails, err error) {
+	_, err = cli.MakeRequest(ctx, http.MethodGet, cli.BuildAdminURL("v1", "rooms", roomID), nil, &resp)
+	return
+}
+
 type RespRoomsMembers struct {
 	Members []id.UserID `json:"members"`
 	Total   int         `json:"total"`
@@ -10,6 +10,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"go.mau.fi/util/jsontime"
 
@@ -161,3 +162,115 @@ func (cli *Client) DeleteUserRatelimit(ctx context.Context, userID id.UserID) (e
 	_, err = cli.MakeRequest(ctx, http.MethodDelete, cli.BuildAdminURL("v1", "users", userID, "override_ratelimit"), nil, nil)
 	return
 }
+
+// ReqListUsers is the request content for Client.ListUsers.
+type ReqListUsers struct {
+	UserID      string
+	Name        string
+	Guests      *bool
+	Admins      *bool
+	Deactivated bool
+	Locked      bool
+	OrderBy     string
+	Direction   mautrix.Direction
+	From        string
+	Limit       int
+}
+
+func (req *ReqListUsers) BuildQuery() map[string]string {
+	query := map[string]string{}
+	if req.UserID != "" {
+		query["user_id"] = req.UserID
+	}
+	if req.Name != "" {
+		query["name"] = req.Name
+	}
+	if req.Guests != nil {
+		query["guests"] = strconv.FormatBool(*req.Guests)
+	}
+	if req.Admins != nil {
+		query["admins"] = strconv.FormatBool(*req.Admins)
+	}
+	if req.Deactivated {
+		query["deactivated"] = "true"
+	}
+	if req.Locked {
+		query["locked"] = "true"
+	}
+	if req.OrderBy != "" {
+		query["order_by"] = req.OrderBy
+	}
+	if req.Direction != 0 {
+		query["dir"] = string(req.Direction)
+	}
+	if req.From != "" {
+		query["from"] = req.From
+	}
+	if req.Limit != 0 {
+		query["limit"] = strconv.Itoa(req.Limit)
+	}
+	return query
+}
+
+type UserListEntry struct {
+	UserID       id.UserID           `json:"name"`
+	DisplayName  string              `json:"displayname"`
+	AvatarURL    id.ContentURIString `json:"avatar_url"`
+	Guest        bool                `json:"is_guest"`
+	Admin        bool                `json:"admin"`
+	Deactivated  bool                `json:"deactivated"`
+	Erased       bool                `json:"erased"`
+	Locked       bool                `json:"locked"`
+	ShadowBanned bool                `json:"shadow_banned"`
+	UserType     string              `json:"user_type"`
+	CreationTS   jsontime.UnixMilli  `json:"creation_ts"`
+	LastSeenTS   jsontime.UnixMilli  `json:"last_seen_ts"`
+}
+
+type RespListUsers struct {
+	Users     []UserListEntry `json:"users"`
+	NextToken string          `json:"next_token"`
+	Total     int             `json:"total"`
+}
+
+// ListUsers returns a list of user accounts on the server.
+//
+// https://matrix-org.github.io/synapse/latest/admin_api/user_admin_api.html#list-accounts
+func (cli *Client) ListUsers(ctx context.Context, req ReqListUsers) (resp *RespListUsers, err error) {
+	reqURL := cli.BuildURLWithQuery(mautrix.SynapseAdminURLPath{"v2", "users"}, req.BuildQuery())
+	_, err = cli.MakeRequest(ctx, http.MethodGet, reqURL, nil, &resp)
+	return
+}
+
+type RespJoinedRooms struct {
+	JoinedRooms []id.RoomID `json:"joined_rooms"`
+	Total       int         `json:"total"`
+}
+
+// ListJoinedRooms gets the list of rooms that a user is in.
+//
+// https://matrix-org.github.io/synapse/latest/admin_api/user_admin_api.html#list-joined-rooms-of-a-user
+func (cli *Client) ListJoinedRooms(ctx context.Context, userID id.UserID) (resp *RespJoinedRooms, err error) {
+	_, err = cli.MakeRequest(ctx, http.MethodGet, cli.BuildAdminURL("v1", "users", userID, "joined_rooms"), nil, &resp)
+	return
+}
+
+// DeleteDevice deletes a device of a user, which also invalidates its access token.
+//
+// https://matrix-org.github.io/synapse/latest/admin_api/user_admin_api.html#delete-a-device
+func (cli *Client) DeleteDevice(ctx context.Context, userID id.UserID, deviceID id.DeviceID) error {
+	_, err := cli.MakeRequest(ctx, http.MethodDelete, cli.BuildAdminURL("v2", "users", userID, "devices", deviceID), nil, nil)
+	return err
+}
+
+// SetShadowBanned shadow-bans or un-shadow-bans a user.
+//
+// https://matrix-org.github.io/synapse/latest/admin_api/user_admin_api.html#controlling-whether-a-user-is-shadow-banned
+func (cli *Client) SetShadowBanned(ctx context.Context, userID id.UserID, shadowBanned bool) error {
+	method := http.MethodPost
+	if !shadowBanned {
+		method = http.MethodDelete
+	}
+	_, err := cli.MakeRequest(ctx, method, cli.BuildAdminURL("v1", "users", userID, "shadow_ban"), nil, nil)
+	return err
+}
@@ -50,6 +50,8 @@ type DispatchableSyncer interface {
 type DefaultSyncer struct {
 	// syncListeners want the whole sync response, e.g. the crypto machine
 	syncListeners []SyncHandler
+	// roomChunkListeners want individual rooms from streamed sync responses
+	roomChunkListeners []SyncHandler
 	// globalListeners want all events
 	globalListeners []EventHandler
 	// listeners want a specific event type
@@ -61,18 +63,24 @@ type DefaultSyncer struct {
 	ParseErrorHandler func(evt *event.Event, err error) bool
 	// FilterJSON is used when the client starts syncing and doesn't get an existing filter ID from SyncStore's LoadFilterID.
 	FilterJSON *Filter
+	// StreamRooms enables processing streamed sync responses room by room (see StreamingSyncer).
+	// When enabled, listeners registered with OnSync don't see rooms, so room-specific sync handlers
+	// (e.g. MoveInviteState) must also be registered with OnRoomChunk.
+	StreamRooms bool
 }
 
 var _ Syncer = (*DefaultSyncer)(nil)
 var _ ExtensibleSyncer = (*DefaultSyncer)(nil)
+var _ StreamingSyncer = (*DefaultSyncer)(nil)
 
 // NewDefaultSyncer returns an instantiated DefaultSyncer
 func NewDefaultSyncer() *DefaultSyncer {
 	return &DefaultSyncer{
-		listeners:         make(map[event.Type][]EventHandler),
-		syncListeners:     []SyncHandler{},
-		globalListeners:   []EventHandler{},
-		ParseEventContent: true,
+		listeners:          make(map[event.Type][]EventHandler),
+		syncListeners:      []SyncHandler{},
+		roomChunkListeners: []SyncHandler{},
+		globalListeners:    []EventHandler{},
+		ParseEventContent:  true,
 		ParseErrorHandler: func(evt *event.Event, err error) bool {
 			// By default, drop known events that can't be parsed, but let unknown events through
 			return errors.Is(err, event.ErrUnsupportedContentType) ||
@@ -85,6 +93,22 @@ func NewDefaultSyncer() *DefaultSyncer {
 // ProcessResponse processes the /sync response in a way suitable for bots. "Suitable for bots" means a stream of
 // unrepeating events. Returns a fatal error if a listener panics.
 func (s *DefaultSyncer) ProcessResponse(ctx context.Context, res *RespSync, since string) (err error) {
+	_, err = s.processResponse(ctx, res, since)
+	return
+}
+
+// CanStreamRooms returns the value of StreamRooms.
+func (s *DefaultSyncer) CanStreamRooms() bool {
+	return s.StreamRooms
+}
+
+// ProcessStreamedResponse processes everything except rooms in a streamed sync response.
+// If a listener registered with OnSync returns false, the rooms in the response won't be processed either.
+func (s *DefaultSyncer) ProcessStreamedResponse(ctx context.Context, res *RespSync, since string) (bool, error) {
+	return s.processResponse(ctx, res, since)
+}
+
+func (s *DefaultSyncer) processResponse(ctx context.Context, res *RespSync, since string) (cont bool, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("ProcessResponse panicked! since=%s panic=%s\n%s", since, r, debug.Stack())
@@ -100,21 +124,48 @@ func (s *DefaultSyncer) ProcessResponse(ctx context.Context, res *RespSync, sinc
 	s.processSyncEvents(ctx, "", res.ToDevice.Events, event.SourceToDevice)
 	s.processSyncEvents(ctx, "", res.Presence.Events, event.SourcePresence)
 	s.processSyncEvents(ctx, "", res.AccountData.Events, event.SourceAccountData)
+	s.processRooms(ctx, &res.Rooms)
+	cont = true
+	return
+}
+
+// ProcessRoomChunk processes a single room from a streamed sync response. Listeners registered with OnSync
+// are not called for room chunks, as they already received the rest of the response via ProcessResponse.
+// Listeners that need to see rooms in streamed responses should be registered with OnRoomChunk instead.
+func (s *DefaultSyncer) ProcessRoomChunk(ctx context.Context, res *RespSync, since string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("ProcessRoomChunk panicked! since=%s panic=%s\n%s", since, r, debug.Stack())
+		}
+	}()
+
+	for _, listener := range s.roomChunkListeners {
+		if !listener(ctx, res, since) {
+			return
+		}
+	}
+
+	s.processRooms(ctx, &res.Rooms)
+	return
+}
 
-	for roomID, roomData := range res.Rooms.Join {
+func (s *DefaultSyncer) processRooms(ctx context.Context, rooms *RespSyncRooms) {
+	for roomID, roomData := range rooms.Join {
 		s.processSyncEvents(ctx, roomID, roomData.State.Events, event.SourceJoin|event.SourceState)
 		s.processSyncEvents(ctx, roomID, roomData.Timeline.Events, event.SourceJoin|event.SourceTimeline)
 		s.processSyncEvents(ctx, roomID, roomData.Ephemeral.Events, event.SourceJoin|event.SourceEphemeral)
 		s.processSyncEvents(ctx, roomID, roomData.AccountData.Events, event.SourceJoin|event.SourceAccountData)
 	}
-	for roomID, roomData := range res.Rooms.Invite {
+	for roomID, roomData := range rooms.Invite {
 		s.processSyncEvents(ctx, roomID, roomData.State.Events, event.SourceInvite|event.SourceState)
 	}
-	for roomID, roomData := range res.Rooms.Leave {
+	for roomID, roomData := range rooms.Knock {
+		s.processSyncEvents(ctx, roomID, roomData.State.Events, event.SourceKnock|event.SourceState)
+	}
+	for roomID, roomData := range rooms.Leave {
 		s.processSyncEvents(ctx, roomID, roomData.State.Events, event.SourceLeave|event.SourceState)
 		s.processSyncEvents(ctx, roomID, roomData.Timeline.Events, event.SourceLeave|event.SourceTimeline)
 	}
-	return
 }
 
 func (s *DefaultSyncer) processSyncEvents(ctx context.Context, roomID id.RoomID, events []*event.Event, source event.Source) {
@@ -178,6 +229,13 @@ func (s *DefaultSyncer) OnSync(callback SyncHandler) {
 	s.syncListeners = append(s.syncListeners, callback)
 }
 
+// OnRoomChunk registers a listener for individual rooms in streamed sync responses (see StreamingSyncer).
+// Handlers that only care about rooms, like DontProcessOldEvents, MoveInviteState and MoveKnockState,
+// should be registered with both OnSync and OnRoomChunk if StreamRooms is enabled.
+func (s *DefaultSyncer) OnRoomChunk(callback SyncHandler) {
+	s.roomChunkListeners = append(s.roomChunkListeners, callback)
+}
+
 func (s *DefaultSyncer) OnEvent(callback EventHandler) {
 	s.globalListeners = append(s.globalListeners, callback)
 }
@@ -257,22 +315,7 @@ func dontProcessOldEvents(userID id.UserID, resp *RespSync, since string) bool {
 //	cli.Syncer.(mautrix.ExtensibleSyncer).OnSync(cli.MoveInviteState)
 func (cli *Client) MoveInviteState(ctx context.Context, resp *RespSync, _ string) bool {
 	for _, meta := range resp.Rooms.Invite {
-		var inviteState []event.StrippedState
-		var inviteEvt *event.Event
-		for _, evt := range meta.State.Events {
-			if evt.Type == event.StateMember && evt.GetStateKey() == cli.UserID.String() {
-				inviteEvt = evt
-			} else {
-				evt.Type.Class = event.StateEventType
-				_ = evt.Content.ParseRaw(evt.Type)
-				inviteState = append(inviteState, event.StrippedState{
-					Content:  evt.Content,
-					Type:     evt.Type,
-					StateKey: evt.GetStateKey(),
-					Sender:   evt.Sender,
-				})
-			}
-		}
+		inviteEvt, inviteState := cli.splitStrippedState(meta.State.Events)
 		if inviteEvt != nil {
 			inviteEvt.Unsigned.InviteRoomState = inviteState
 			meta.State.Events = []*event.Event{inviteEvt}
@@ -282,3 +325,41 @@ func (cli *Client) MoveInviteState(ctx context.Context, resp *RespSync, _ string
 }
 
 var _ SyncHandler = (*Client)(nil).MoveInviteState
+
+// MoveKnockState is a sync handler that moves events from the state event list to the KnockRoomState in the knock event.
+//
+// To use it, register it with your Syncer, e.g.:
+//
+//	cli.Syncer.(mautrix.ExtensibleSyncer).OnSync(cli.MoveKnockState)
+func (cli *Client) MoveKnockState(ctx context.Context, resp *RespSync, _ string) bool {
+	for _, meta := range resp.Rooms.Knock {
+		knockEvt, knockState := cli.splitStrippedState(meta.State.Events)
+		if knockEvt != nil {
+			knockEvt.Unsigned.KnockRoomState = knockState
+			meta.State.Events = []*event.Event{knockEvt}
+		}
+	}
+	return true
+}
+
+var _ SyncHandler = (*Client)(nil).MoveKnockState
+
+// splitStrippedState finds our own member event from the stripped state of an invited or knocked room,
+// and converts the rest of the events into StrippedState structs.
+func (cli *Client) splitStrippedState(events []*event.Event) (memberEvt *event.Event, state []event.StrippedState) {
+	for _, evt := range events {
+		if evt.Type == event.StateMember && evt.GetStateKey() == cli.UserID.String() {
+			memberEvt = evt
+		} else {
+			evt.Type.Class = event.StateEventType
+			_ = evt.Content.ParseRaw(evt.Type)
+			state = append(state, event.StrippedState{
+				Content:  evt.Content,
+				Type:     evt.Type,
+				StateKey: evt.GetStateKey(),
+				Sender:   evt.Sender,
+			})
+		}
+	}
+	return
+}
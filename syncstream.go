@@ -0,0 +1,218 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/rs/zerolog"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// StreamingSyncer is an optional interface for Syncers that can process a sync response room by room.
+//
+// When the client streams a sync response (see Client.StreamSyncMinAge) and the syncer implements this interface
+// and CanStreamRooms returns true, the response is decoded incrementally instead of being unmarshaled into memory
+// all at once. ProcessStreamedResponse is called first with all the top-level data (to-device events, device lists,
+// etc.) and no rooms. If it returns true, ProcessRoomChunk is called separately for every room. The resp passed to
+// ProcessRoomChunk only contains the next batch token and the single room in the relevant Rooms map.
+type StreamingSyncer interface {
+	Syncer
+	CanStreamRooms() bool
+	ProcessStreamedResponse(ctx context.Context, resp *RespSync, since string) (bool, error)
+	ProcessRoomChunk(ctx context.Context, resp *RespSync, since string) error
+}
+
+type streamedSync struct {
+	log  *zerolog.Logger
+	file *os.File
+	top  *RespSync
+}
+
+func (cli *Client) streamingSyncRequest(ctx context.Context, req ReqSync) (*streamedSync, error) {
+	ss := &streamedSync{log: zerolog.Ctx(ctx)}
+	if ss.log.GetLevel() == zerolog.Disabled {
+		ss.log = &cli.Log
+	}
+	err := cli.doSyncRequest(ctx, req, nil, func(req *http.Request, res *http.Response, _ any) ([]byte, error) {
+		file, err := os.CreateTemp("", "mautrix-sync-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temporary file for sync response: %w", err)
+		}
+		ss.file = file
+		if _, err = io.Copy(file, res.Body); err != nil {
+			return nil, fmt.Errorf("failed to copy response to file: %w", err)
+		}
+		return nil, nil
+	})
+	if err == nil {
+		err = ss.decodeTopLevel()
+	}
+	if err != nil {
+		ss.close()
+		return nil, err
+	}
+	return ss, nil
+}
+
+func (ss *streamedSync) close() {
+	if ss.file != nil {
+		closeTemp(ss.log, ss.file)
+		ss.file = nil
+	}
+}
+
+func (ss *streamedSync) walk(fn func(dec *json.Decoder, key string) error) error {
+	if _, err := ss.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to beginning of response file: %w", err)
+	}
+	dec := json.NewDecoder(ss.file)
+	return walkJSONObject(dec, func(key string) error {
+		return fn(dec, key)
+	})
+}
+
+// decodeTopLevel decodes everything in the sync response except rooms.
+func (ss *streamedSync) decodeTopLevel() error {
+	fields := make(map[string]json.RawMessage)
+	err := ss.walk(func(dec *json.Decoder, key string) error {
+		if key == "rooms" {
+			return skipJSONValue(dec)
+		}
+		var val json.RawMessage
+		err := dec.Decode(&val)
+		fields[key] = val
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to decode sync response: %w", err)
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, &ss.top)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal sync response: %w", err)
+	}
+	return nil
+}
+
+// decodeRooms decodes the rooms in the sync response one by one and calls fn with each room.
+func (ss *streamedSync) decodeRooms(fn func(chunk *RespSync) error) error {
+	return ss.walk(func(dec *json.Decoder, key string) error {
+		if key != "rooms" {
+			return skipJSONValue(dec)
+		}
+		return walkJSONObject(dec, func(category string) error {
+			var newChunk func(roomID id.RoomID) (*RespSync, any)
+			switch category {
+			case "join":
+				newChunk = func(roomID id.RoomID) (*RespSync, any) {
+					room := &SyncJoinedRoom{}
+					return &RespSync{Rooms: RespSyncRooms{Join: map[id.RoomID]*SyncJoinedRoom{roomID: room}}}, room
+				}
+			case "invite":
+				newChunk = func(roomID id.RoomID) (*RespSync, any) {
+					room := &SyncInvitedRoom{}
+					return &RespSync{Rooms: RespSyncRooms{Invite: map[id.RoomID]*SyncInvitedRoom{roomID: room}}}, room
+				}
+			case "leave":
+				newChunk = func(roomID id.RoomID) (*RespSync, any) {
+					room := &SyncLeftRoom{}
+					return &RespSync{Rooms: RespSyncRooms{Leave: map[id.RoomID]*SyncLeftRoom{roomID: room}}}, room
+				}
+			case "knock":
+				newChunk = func(roomID id.RoomID) (*RespSync, any) {
+					room := &SyncKnockedRoom{}
+					return &RespSync{Rooms: RespSyncRooms{Knock: map[id.RoomID]*SyncKnockedRoom{roomID: room}}}, room
+				}
+			default:
+				return skipJSONValue(dec)
+			}
+			return walkJSONObject(dec, func(roomID string) error {
+				chunk, room := newChunk(id.RoomID(roomID))
+				if err := dec.Decode(room); err != nil {
+					return fmt.Errorf("failed to decode %s room %s: %w", category, roomID, err)
+				}
+				chunk.NextBatch = ss.top.NextBatch
+				return fn(chunk)
+			})
+		})
+	})
+}
+
+func (ss *streamedSync) process(ctx context.Context, syncer StreamingSyncer, since string) error {
+	if cont, err := syncer.ProcessStreamedResponse(ctx, ss.top, since); err != nil || !cont {
+		return err
+	}
+	var processErr error
+	err := ss.decodeRooms(func(chunk *RespSync) error {
+		processErr = syncer.ProcessRoomChunk(ctx, chunk, since)
+		return processErr
+	})
+	if processErr != nil {
+		return processErr
+	} else if err != nil {
+		return fmt.Errorf("failed to decode rooms in sync response: %w", err)
+	}
+	return nil
+}
+
+// walkJSONObject reads a JSON object from the decoder and calls fn for each key.
+// fn must consume the value corresponding to the key. A null value is treated as an empty object.
+func walkJSONObject(dec *json.Decoder, fn func(key string) error) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	} else if tok == nil {
+		return nil
+	} else if tok != json.Delim('{') {
+		return fmt.Errorf("unexpected token %v, expected object", tok)
+	}
+	for dec.More() {
+		tok, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return fmt.Errorf("unexpected token %v, expected object key", tok)
+		} else if err = fn(key); err != nil {
+			return err
+		}
+	}
+	// Consume the closing brace
+	_, err = dec.Token()
+	return err
+}
+
+// skipJSONValue reads and discards the next JSON value without buffering it in memory.
+func skipJSONValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
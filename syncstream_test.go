@@ -0,0 +1,155 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const streamedSyncResponse = `{
+	"rooms": {
+		"join": {
+			"!room1:example.com": {"timeline": {"events": [
+				{"type": "m.room.message", "event_id": "$msg1", "sender": "@alice:example.com", "content": {"msgtype": "m.text", "body": "hello"}}
+			]}},
+			"!room2:example.com": {"timeline": {"events": [
+				{"type": "m.room.message", "event_id": "$msg2", "sender": "@bob:example.com", "content": {"msgtype": "m.text", "body": "hi"}}
+			]}}
+		},
+		"invite": {
+			"!room3:example.com": {"invite_state": {"events": [
+				{"type": "m.room.member", "state_key": "@user:example.com", "sender": "@alice:example.com", "content": {"membership": "invite"}}
+			]}}
+		},
+		"unknown": {"foo": [1, 2, {"bar": null}]}
+	},
+	"to_device": {"events": [{"type": "com.example.test", "sender": "@alice:example.com", "content": {}}]},
+	"next_batch": "batch1"
+}`
+
+type chunkRecordingSyncer struct {
+	*mautrix.DefaultSyncer
+	top    *mautrix.RespSync
+	chunks []*mautrix.RespSync
+}
+
+func (s *chunkRecordingSyncer) ProcessStreamedResponse(ctx context.Context, resp *mautrix.RespSync, since string) (bool, error) {
+	s.top = resp
+	return s.DefaultSyncer.ProcessStreamedResponse(ctx, resp, since)
+}
+
+func (s *chunkRecordingSyncer) ProcessRoomChunk(ctx context.Context, resp *mautrix.RespSync, since string) error {
+	s.chunks = append(s.chunks, resp)
+	return s.DefaultSyncer.ProcessRoomChunk(ctx, resp, since)
+}
+
+// newStreamingSyncClient returns a client that always streams sync responses. The server returns
+// streamedSyncResponse for the initial sync and the one after it, and stops syncing on the third request.
+func newStreamingSyncClient(t *testing.T) *mautrix.Client {
+	var cli *mautrix.Client
+	var requests int
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /_matrix/client/v3/user/{userID}/filter", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"filter_id":"1"}`))
+	})
+	mux.HandleFunc("GET /_matrix/client/v3/sync", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests > 2 {
+			cli.StopSync()
+		}
+		_, _ = w.Write([]byte(streamedSyncResponse))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+	// Stream every sync response
+	cli.StreamSyncMinAge = time.Nanosecond
+	return cli
+}
+
+func TestClient_StreamedSync(t *testing.T) {
+	cli := newStreamingSyncClient(t)
+	syncer := &chunkRecordingSyncer{DefaultSyncer: mautrix.NewDefaultSyncer()}
+	syncer.StreamRooms = true
+	cli.Syncer = syncer
+	var messages []id.EventID
+	syncer.OnEventType(event.EventMessage, func(ctx context.Context, evt *event.Event) {
+		messages = append(messages, evt.ID)
+	})
+
+	// Stop after the first sync
+	cli.Syncer.(mautrix.ExtensibleSyncer).OnSync(func(ctx context.Context, resp *mautrix.RespSync, since string) bool {
+		cli.StopSync()
+		return true
+	})
+	require.NoError(t, cli.SyncWithContext(context.Background()))
+
+	require.NotNil(t, syncer.top)
+	assert.Equal(t, "batch1", syncer.top.NextBatch)
+	assert.Len(t, syncer.top.ToDevice.Events, 1)
+	assert.Empty(t, syncer.top.Rooms.Join)
+	require.Len(t, syncer.chunks, 3)
+	for _, chunk := range syncer.chunks {
+		assert.Equal(t, "batch1", chunk.NextBatch)
+		assert.Equal(t, 1, len(chunk.Rooms.Join)+len(chunk.Rooms.Invite))
+	}
+	assert.Contains(t, syncer.chunks[0].Rooms.Join, id.RoomID("!room1:example.com"))
+	assert.Contains(t, syncer.chunks[1].Rooms.Join, id.RoomID("!room2:example.com"))
+	assert.Len(t, syncer.chunks[2].Rooms.Invite[id.RoomID("!room3:example.com")].State.Events, 1)
+	assert.Equal(t, []id.EventID{"$msg1", "$msg2"}, messages)
+}
+
+func TestClient_StreamedSync_NotEnabled(t *testing.T) {
+	cli := newStreamingSyncClient(t)
+	syncer := &chunkRecordingSyncer{DefaultSyncer: mautrix.NewDefaultSyncer()}
+	cli.Syncer = syncer
+	var rooms int
+	syncer.OnSync(func(ctx context.Context, resp *mautrix.RespSync, since string) bool {
+		rooms = len(resp.Rooms.Join)
+		cli.StopSync()
+		return true
+	})
+	require.NoError(t, cli.SyncWithContext(context.Background()))
+	// Without StreamRooms, the whole response is processed at once
+	assert.Nil(t, syncer.top)
+	assert.Empty(t, syncer.chunks)
+	assert.Equal(t, 2, rooms)
+}
+
+func TestClient_StreamedSync_DontProcessOldEvents(t *testing.T) {
+	cli := newStreamingSyncClient(t)
+	syncer := mautrix.NewDefaultSyncer()
+	syncer.StreamRooms = true
+	cli.Syncer = syncer
+	syncer.OnSync(cli.DontProcessOldEvents)
+	syncer.OnRoomChunk(cli.DontProcessOldEvents)
+	var messages []string
+	syncer.OnEventType(event.EventMessage, func(ctx context.Context, evt *event.Event) {
+		messages = append(messages, evt.Content.AsMessage().Body)
+	})
+	var toDevice int
+	syncer.OnEventType(event.Type{Type: "com.example.test", Class: event.ToDeviceEventType}, func(ctx context.Context, evt *event.Event) {
+		toDevice++
+	})
+
+	require.NoError(t, cli.SyncWithContext(context.Background()))
+	// The initial sync is dropped entirely, only the second one is processed
+	assert.Equal(t, []string{"hello", "hi"}, messages)
+	assert.Equal(t, 1, toDevice)
+}
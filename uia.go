@@ -0,0 +1,217 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+var (
+	ErrNoSupportedUIAFlow = errors.New("no supported user-interactive auth flow")
+	ErrTooManyUIAAttempts = errors.New("too many user-interactive auth attempts")
+)
+
+// MaxUIAAttempts is the maximum number of requests DoUIA will make before giving up.
+const MaxUIAAttempts = 10
+
+// UIAStageHandler produces the auth dict for a single user-interactive auth stage.
+//
+// The handler receives the latest UIA response from the server, which contains the session ID and stage parameters.
+// The returned value is sent as the auth field of the request, so it must include the stage type and session ID.
+// Returning an error aborts the flow.
+type UIAStageHandler func(ctx context.Context, stage AuthType, uia *RespUserInteractive) (any, error)
+
+// UIARequestFunc makes the request that requires user-interactive auth. The auth parameter is nil on the first call
+// and must be placed in the auth field of the request body. The returned values should be the response body and
+// error returned by the client (e.g. from MakeRequest), so that UIA responses can be detected.
+type UIARequestFunc func(ctx context.Context, auth any) ([]byte, error)
+
+// ParseUIAResponse checks if the given response is a user-interactive auth response (HTTP 401 with a list of flows)
+// and returns the parsed response if it is.
+func ParseUIAResponse(content []byte, err error) *RespUserInteractive {
+	var httpErr HTTPError
+	if !errors.As(err, &httpErr) || !httpErr.IsStatus(http.StatusUnauthorized) {
+		return nil
+	}
+	var uia RespUserInteractive
+	if json.Unmarshal(content, &uia) != nil || len(uia.Flows) == 0 {
+		return nil
+	}
+	return &uia
+}
+
+// IsCompleted returns true if the given stage has been completed in this UIA session.
+func (r *RespUserInteractive) IsCompleted(stage AuthType) bool {
+	return slices.Contains(r.Completed, string(stage))
+}
+
+// NextStage finds the first flow whose remaining stages all have handlers and returns the next stage in it.
+func (r *RespUserInteractive) NextStage(supported func(AuthType) bool) (AuthType, bool) {
+	for _, flow := range r.Flows {
+		var next AuthType
+		allSupported := true
+		for _, stage := range flow.Stages {
+			if r.IsCompleted(stage) {
+				continue
+			} else if !supported(stage) {
+				allSupported = false
+				break
+			} else if next == "" {
+				next = stage
+			}
+		}
+		if allSupported && next != "" {
+			return next, true
+		}
+	}
+	return "", false
+}
+
+// DoUIA makes a request and completes user-interactive authentication for it if the server requires it.
+//
+// The handlers map contains the stages the caller supports. When the server responds with a list of flows,
+// the first flow where all uncompleted stages have handlers is picked, and the handler for the next stage is called.
+// The request is then retried with the returned auth data until it succeeds, fails with a non-UIA error,
+// or MaxUIAAttempts is reached. If the server rejects the stage that was just submitted (e.g. because of
+// a wrong password), the error is returned instead of calling the same handler again.
+//
+// Handlers for common stages can be created with UIAPassword, UIADummy, UIARegistrationToken, UIAThreepid
+// and UIAFallback.
+func (cli *Client) DoUIA(ctx context.Context, handlers map[AuthType]UIAStageHandler, makeRequest UIARequestFunc) error {
+	var auth any
+	supported := func(stage AuthType) bool {
+		_, ok := handlers[stage]
+		return ok
+	}
+	var prevStage AuthType
+	for i := 0; i < MaxUIAAttempts; i++ {
+		content, err := makeRequest(ctx, auth)
+		uia := ParseUIAResponse(content, err)
+		if uia == nil {
+			return err
+		} else if prevStage != "" && uia.ErrCode != "" && !uia.IsCompleted(prevStage) {
+			return fmt.Errorf("%s stage failed: %w", prevStage, err)
+		}
+		stage, ok := uia.NextStage(supported)
+		if !ok {
+			return fmt.Errorf("%w (server flows: %v)", ErrNoSupportedUIAFlow, uia.Flows)
+		}
+		cli.cliOrContextLog(ctx).Debug().
+			Str("stage", string(stage)).
+			Strs("completed", uia.Completed).
+			Str("errcode", uia.ErrCode).
+			Msg("Handling user-interactive auth stage")
+		auth, err = handlers[stage](ctx, stage, uia)
+		if err != nil {
+			return fmt.Errorf("failed to handle %s stage: %w", stage, err)
+		}
+		prevStage = stage
+	}
+	return ErrTooManyUIAAttempts
+}
+
+// ReqUIAuthPassword is the auth dict for the m.login.password stage.
+type ReqUIAuthPassword struct {
+	BaseAuthData
+	Identifier UserIdentifier `json:"identifier"`
+	Password   string         `json:"password"`
+}
+
+// ReqUIAuthToken is the auth dict for the m.login.registration_token stage.
+type ReqUIAuthToken struct {
+	BaseAuthData
+	Token string `json:"token"`
+}
+
+// ThreepidCreds contains the credentials of a validated third-party identifier.
+type ThreepidCreds struct {
+	SID           string `json:"sid"`
+	ClientSecret  string `json:"client_secret"`
+	IDServer      string `json:"id_server,omitempty"`
+	IDAccessToken string `json:"id_access_token,omitempty"`
+}
+
+// ReqUIAuthThreepid is the auth dict for the m.login.email.identity and m.login.msisdn stages.
+type ReqUIAuthThreepid struct {
+	BaseAuthData
+	ThreepidCreds ThreepidCreds `json:"threepid_creds"`
+}
+
+// UIAPassword returns a stage handler for the m.login.password stage with the given username or user ID and password.
+func UIAPassword(user, password string) UIAStageHandler {
+	return func(ctx context.Context, stage AuthType, uia *RespUserInteractive) (any, error) {
+		return &ReqUIAuthPassword{
+			BaseAuthData: BaseAuthData{Type: stage, Session: uia.Session},
+			Identifier:   UserIdentifier{Type: IdentifierTypeUser, User: user},
+			Password:     password,
+		}, nil
+	}
+}
+
+// UIADummy returns a stage handler for the m.login.dummy stage.
+func UIADummy() UIAStageHandler {
+	return func(ctx context.Context, stage AuthType, uia *RespUserInteractive) (any, error) {
+		return &BaseAuthData{Type: stage, Session: uia.Session}, nil
+	}
+}
+
+// UIARegistrationToken returns a stage handler for the m.login.registration_token stage.
+// The getToken function is called to get the token, e.g. by prompting the user.
+func UIARegistrationToken(getToken func(ctx context.Context) (string, error)) UIAStageHandler {
+	return func(ctx context.Context, stage AuthType, uia *RespUserInteractive) (any, error) {
+		token, err := getToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &ReqUIAuthToken{
+			BaseAuthData: BaseAuthData{Type: stage, Session: uia.Session},
+			Token:        token,
+		}, nil
+	}
+}
+
+// UIAThreepid returns a stage handler for the m.login.email.identity or m.login.msisdn stages.
+// The validate function is called to get the credentials after the user has validated their email or phone number.
+func UIAThreepid(validate func(ctx context.Context, stage AuthType) (*ThreepidCreds, error)) UIAStageHandler {
+	return func(ctx context.Context, stage AuthType, uia *RespUserInteractive) (any, error) {
+		creds, err := validate(ctx, stage)
+		if err != nil {
+			return nil, err
+		}
+		return &ReqUIAuthThreepid{
+			BaseAuthData:  BaseAuthData{Type: stage, Session: uia.Session},
+			ThreepidCreds: *creds,
+		}, nil
+	}
+}
+
+// FallbackURL returns the URL of the fallback web page for completing the given stage, as specified in
+// https://spec.matrix.org/v1.11/client-server-api/#fallback
+func (cli *Client) FallbackURL(stage AuthType, session string) string {
+	return cli.BuildURLWithQuery(ClientURLPath{"v3", "auth", string(stage), "fallback", "web"}, map[string]string{
+		"session": session,
+	})
+}
+
+// UIAFallback returns a stage handler for stages that are completed using the fallback web page, such as m.login.sso.
+// The complete function is called with the fallback URL, and it must return once the user has finished the stage
+// in a web browser.
+func UIAFallback(cli *Client, complete func(ctx context.Context, stage AuthType, fallbackURL string) error) UIAStageHandler {
+	return func(ctx context.Context, stage AuthType, uia *RespUserInteractive) (any, error) {
+		err := complete(ctx, stage, cli.FallbackURL(stage, uia.Session))
+		if err != nil {
+			return nil, err
+		}
+		// Stages completed via fallback are confirmed by sending only the session ID
+		return map[string]string{"session": uia.Session}, nil
+	}
+}
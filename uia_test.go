@@ -0,0 +1,98 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestClient_DoUIA(t *testing.T) {
+	var requests []map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /_matrix/client/v3/delete_devices", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Auth map[string]any `json:"auth"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req.Auth)
+		flows := `"flows": [{"stages": ["m.login.sso"]}, {"stages": ["m.login.registration_token", "m.login.password"]}], "session": "abc"`
+		switch {
+		case req.Auth == nil:
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{` + flows + `}`))
+		case req.Auth["type"] == string(mautrix.AuthTypeRegistrationToken):
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{` + flows + `, "completed": ["m.login.registration_token"]}`))
+		case req.Auth["password"] == "wrong":
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{` + flows + `, "completed": ["m.login.registration_token"], "errcode": "M_FORBIDDEN", "error": "Invalid password"}`))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+
+	passwords := []string{"wrong", "correct"}
+	handlers := map[mautrix.AuthType]mautrix.UIAStageHandler{
+		mautrix.AuthTypeRegistrationToken: mautrix.UIARegistrationToken(func(ctx context.Context) (string, error) {
+			return "token123", nil
+		}),
+		mautrix.AuthTypePassword: func(ctx context.Context, stage mautrix.AuthType, uia *mautrix.RespUserInteractive) (any, error) {
+			password := passwords[0]
+			passwords = passwords[1:]
+			return mautrix.UIAPassword("@user:example.com", password)(ctx, stage, uia)
+		},
+	}
+	deleteDevices := func(ctx context.Context, auth any) ([]byte, error) {
+		return cli.MakeRequest(ctx, http.MethodPost, cli.BuildClientURL("v3", "delete_devices"), &mautrix.ReqDeleteDevices{
+			Devices: []id.DeviceID{"DEVICE"},
+			Auth:    auth,
+		}, nil)
+	}
+	// A rejected stage must return the error instead of calling the handler again
+	err = cli.DoUIA(context.Background(), handlers, deleteDevices)
+	assert.ErrorIs(t, err, mautrix.MForbidden)
+	require.Len(t, requests, 3)
+	assert.Nil(t, requests[0])
+	assert.Equal(t, map[string]any{"type": "m.login.registration_token", "session": "abc", "token": "token123"}, requests[1])
+	assert.Equal(t, "wrong", requests[2]["password"])
+
+	requests = nil
+	err = cli.DoUIA(context.Background(), handlers, deleteDevices)
+	require.NoError(t, err)
+	require.Len(t, requests, 3)
+	assert.Equal(t, "correct", requests[2]["password"])
+	assert.Equal(t, map[string]any{"type": "m.id.user", "user": "@user:example.com"}, requests[2]["identifier"])
+
+	requests = nil
+	err = cli.DoUIA(context.Background(), map[mautrix.AuthType]mautrix.UIAStageHandler{
+		mautrix.AuthTypeDummy: mautrix.UIADummy(),
+	}, func(ctx context.Context, auth any) ([]byte, error) {
+		return cli.MakeRequest(ctx, http.MethodPost, cli.BuildClientURL("v3", "delete_devices"), &mautrix.ReqDeleteDevices{Auth: auth}, nil)
+	})
+	assert.ErrorIs(t, err, mautrix.ErrNoSupportedUIAFlow)
+	assert.Len(t, requests, 1)
+}
+
+func TestClient_FallbackURL(t *testing.T) {
+	cli, err := mautrix.NewClient("https://example.com", "@user:example.com", "token")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/_matrix/client/v3/auth/m.login.sso/fallback/web?session=abc", cli.FallbackURL(mautrix.AuthTypeSSO, "abc"))
+}
@@ -109,7 +109,7 @@ func (cli *Client) BuildURLWithQuery(urlPath PrefixableURLPath, urlQuery map[str
 // BuildURLWithQuery builds a URL with query parameters in addition to the Client's homeserver
 // and appservice user ID set already.
 func (cli *Client) BuildURLWithFullQuery(urlPath PrefixableURLPath, fn func(q url.Values)) string {
-	hsURL := *BuildURL(cli.HomeserverURL, urlPath.FullPath()...)
+	hsURL := *BuildURL(cli.GetHomeserverURL(), urlPath.FullPath()...)
 	query := hsURL.Query()
 	if cli.SetAppServiceUserID {
 		query.Set("user_id", string(cli.UserID))
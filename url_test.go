@@ -12,6 +12,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
 )
 
 func TestClient_BuildURL(t *testing.T) {
@@ -63,3 +64,21 @@ func TestClient_BuildURL_MissingSchemeWithPath(t *testing.T) {
 	built := cli.BuildClientURL("v3", "foo/bar%2F🐈 1", "hello", "world")
 	assert.Equal(t, "https://example.com/base/_matrix/client/v3/foo%2Fbar%252F%F0%9F%90%88%201/hello/world", built)
 }
+
+func TestClient_GetDownloadURL(t *testing.T) {
+	cli, err := mautrix.NewClient("https://example.com", "@user:example.com", "token")
+	assert.NoError(t, err)
+	mxc := id.ContentURI{Homeserver: "example.org", FileID: "abc"}
+
+	cli.SpecVersions = &mautrix.RespVersions{Versions: []mautrix.SpecVersion{mautrix.SpecV111}}
+	downloadURL, headers := cli.GetDownloadURL(mxc)
+	assert.Equal(t, "https://example.com/_matrix/client/v1/media/download/example.org/abc", downloadURL)
+	assert.Equal(t, "Bearer token", headers.Get("Authorization"))
+	thumbnailURL, _ := cli.GetThumbnailURL(mxc, &mautrix.ReqThumbnail{Width: 64, Height: 64, Method: mautrix.ThumbnailMethodCrop})
+	assert.Equal(t, "https://example.com/_matrix/client/v1/media/thumbnail/example.org/abc?height=64&method=crop&width=64", thumbnailURL)
+
+	cli.SpecVersions = &mautrix.RespVersions{Versions: []mautrix.SpecVersion{mautrix.SpecV110}}
+	downloadURL, headers = cli.GetDownloadURL(mxc)
+	assert.Equal(t, "https://example.com/_matrix/media/v3/download/example.org/abc", downloadURL)
+	assert.Empty(t, headers.Get("Authorization"))
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// GetViaServers computes the via servers to use when linking to the given room, based on the current
+// members and power levels of the room (see [id.ComputeViaServers]).
+//
+// The member list is read from the state store. If the full member list hasn't been fetched yet,
+// the joined members are fetched from the server first. The room's server ACL is fetched from the server
+// so that denied servers aren't included.
+func (cli *Client) GetViaServers(ctx context.Context, roomID id.RoomID) ([]string, error) {
+	if cli.StateStore == nil {
+		return nil, errors.New("client has no state store")
+	}
+	var members []id.UserID
+	if fetched, err := cli.StateStore.HasFetchedMembers(ctx, roomID); err != nil {
+		return nil, fmt.Errorf("failed to check if members have been fetched: %w", err)
+	} else if !fetched {
+		resp, err := cli.JoinedMembers(ctx, roomID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get joined members: %w", err)
+		}
+		members = make([]id.UserID, 0, len(resp.Joined))
+		for userID := range resp.Joined {
+			members = append(members, userID)
+		}
+	} else {
+		allMembers, err := cli.StateStore.GetAllMembers(ctx, roomID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get members from state store: %w", err)
+		}
+		members = make([]id.UserID, 0, len(allMembers))
+		for userID, member := range allMembers {
+			if member.Membership == event.MembershipJoin {
+				members = append(members, userID)
+			}
+		}
+	}
+	levels, err := cli.StateStore.GetPowerLevels(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get power levels from state store: %w", err)
+	}
+	info := id.RoomServerInfo{
+		Members:     members,
+		PowerLevels: make(map[id.UserID]int, len(members)),
+	}
+	if levels != nil {
+		for _, userID := range members {
+			info.PowerLevels[userID] = levels.GetUserLevel(userID)
+		}
+	}
+	var acl event.ServerACLEventContent
+	err = cli.StateEvent(ctx, roomID, event.StateServerACL, "", &acl)
+	if err == nil {
+		info.IsAllowed = acl.IsAllowed
+	} else if !errors.Is(err, MNotFound) {
+		return nil, fmt.Errorf("failed to get server ACLs: %w", err)
+	}
+	return id.ComputeViaServers(info, id.DefaultMaxViaServers), nil
+}
+
+// GetViaURI returns a matrix: URI or matrix.to link to the given room or event, including via servers
+// computed with GetViaServers.
+func (cli *Client) GetViaURI(ctx context.Context, roomID id.RoomID, eventID id.EventID) (*id.MatrixURI, error) {
+	via, err := cli.GetViaServers(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	return roomID.EventURI(eventID, via...), nil
+}
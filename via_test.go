@@ -0,0 +1,53 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestClient_GetViaServers(t *testing.T) {
+	const roomID = id.RoomID("!room:small.example")
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /_matrix/client/v3/rooms/{roomID}/joined_members", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"joined": {
+			"@admin:small.example": {}, "@a:big.example": {}, "@b:big.example": {},
+			"@a:medium.example": {}, "@a:banned.example": {}, "@b:banned.example": {}, "@c:banned.example": {}
+		}}`))
+	})
+	mux.HandleFunc("GET /_matrix/client/v3/rooms/{roomID}/state/m.room.server_acl/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"allow": ["*"], "deny": ["banned.example"]}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	cli, err := mautrix.NewClient(srv.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+	cli.StateStore = mautrix.NewMemoryStateStore()
+	ctx := context.Background()
+	err = cli.StateStore.SetPowerLevels(ctx, roomID, &event.PowerLevelsEventContent{
+		Users: map[id.UserID]int{"@admin:small.example": 100},
+	})
+	require.NoError(t, err)
+
+	via, err := cli.GetViaServers(ctx, roomID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"small.example", "big.example", "medium.example"}, via)
+
+	uri, err := cli.GetViaURI(ctx, roomID, "$event")
+	require.NoError(t, err)
+	assert.Equal(t, "matrix:roomid/room:small.example/e/event?via=small.example&via=big.example&via=medium.example", uri.String())
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultWellKnownTTL is the default time that .well-known responses are cached for.
+const DefaultWellKnownTTL = 1 * time.Hour
+
+// RediscoverAfterConnectionFailures is the number of consecutive connection failures after which
+// the client re-resolves the homeserver URL if Client.WellKnownServerName is set.
+const RediscoverAfterConnectionFailures = 3
+
+const (
+	rediscoverMinBackoff = 30 * time.Second
+	rediscoverMaxBackoff = 30 * time.Minute
+	rediscoverTimeout    = 30 * time.Second
+)
+
+type cachedWellKnown struct {
+	wellKnown *ClientWellKnown
+	expires   time.Time
+}
+
+// WellKnownCache caches the results of .well-known/matrix/client lookups.
+type WellKnownCache struct {
+	// How long to cache results for. Responses where the server didn't have a .well-known file are cached too.
+	TTL time.Duration
+	// The function used to fetch the .well-known file. Defaults to DiscoverClientAPI.
+	Fetch func(ctx context.Context, serverName string) (*ClientWellKnown, error)
+
+	cache map[string]cachedWellKnown
+	lock  sync.Mutex
+}
+
+// DefaultWellKnownCache is the cache used by clients that don't have their own WellKnownCache set.
+var DefaultWellKnownCache = NewWellKnownCache(DefaultWellKnownTTL)
+
+// NewWellKnownCache creates a new .well-known cache with the given TTL.
+func NewWellKnownCache(ttl time.Duration) *WellKnownCache {
+	return &WellKnownCache{
+		TTL:   ttl,
+		Fetch: DiscoverClientAPI,
+		cache: make(map[string]cachedWellKnown),
+	}
+}
+
+// Discover returns the cached .well-known data for the given server name, or fetches it if it's not cached or has expired.
+// The returned value is nil if the server doesn't have a .well-known file. Errors are not cached.
+func (wkc *WellKnownCache) Discover(ctx context.Context, serverName string) (*ClientWellKnown, error) {
+	wkc.lock.Lock()
+	cached, ok := wkc.cache[serverName]
+	wkc.lock.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.wellKnown, nil
+	}
+	wellKnown, err := wkc.Fetch(ctx, serverName)
+	if err != nil {
+		return nil, err
+	}
+	wkc.lock.Lock()
+	wkc.cache[serverName] = cachedWellKnown{wellKnown: wellKnown, expires: time.Now().Add(wkc.TTL)}
+	wkc.lock.Unlock()
+	return wellKnown, nil
+}
+
+// Invalidate removes the given server name from the cache.
+func (wkc *WellKnownCache) Invalidate(serverName string) {
+	wkc.lock.Lock()
+	delete(wkc.cache, serverName)
+	wkc.lock.Unlock()
+}
+
+func (cli *Client) getWellKnownCache() *WellKnownCache {
+	if cli.WellKnownCache != nil {
+		return cli.WellKnownCache
+	}
+	return DefaultWellKnownCache
+}
+
+// DiscoverWellKnown returns the .well-known data of the client's server, using the client's WellKnownCache.
+// The server name is WellKnownServerName if set, or the server name of the client's user ID otherwise.
+func (cli *Client) DiscoverWellKnown(ctx context.Context) (*ClientWellKnown, error) {
+	serverName := cli.WellKnownServerName
+	if serverName == "" {
+		serverName = cli.UserID.Homeserver()
+	}
+	return cli.getWellKnownCache().Discover(ctx, serverName)
+}
+
+// GetHomeserverURL returns the current base homeserver URL. Unlike reading the HomeserverURL field directly,
+// this is safe to call while requests may be re-resolving the URL.
+func (cli *Client) GetHomeserverURL() *url.URL {
+	cli.homeserverURLLock.RLock()
+	defer cli.homeserverURLLock.RUnlock()
+	return cli.HomeserverURL
+}
+
+// SetHomeserverURL replaces the base homeserver URL.
+func (cli *Client) SetHomeserverURL(hsURL *url.URL) {
+	cli.homeserverURLLock.Lock()
+	cli.HomeserverURL = hsURL
+	cli.homeserverURLLock.Unlock()
+}
+
+func (cli *Client) recordConnectionResult(failed bool) {
+	if cli.WellKnownServerName == "" {
+		return
+	} else if !failed {
+		if cli.connectionFailures.Swap(0) != 0 {
+			cli.rediscoverLock.Lock()
+			cli.rediscoverAttempts = 0
+			cli.nextRediscover = time.Time{}
+			cli.rediscoverLock.Unlock()
+		}
+		return
+	}
+	if cli.connectionFailures.Add(1) < RediscoverAfterConnectionFailures {
+		return
+	} else if !cli.rediscoverLock.TryLock() {
+		// Another request is already re-resolving the URL
+		return
+	}
+	defer cli.rediscoverLock.Unlock()
+	if time.Now().Before(cli.nextRediscover) {
+		return
+	}
+	cli.rediscoverAttempts++
+	cli.nextRediscover = time.Now().Add(min(rediscoverMinBackoff<<min(cli.rediscoverAttempts-1, 16), rediscoverMaxBackoff))
+	cli.rediscoverHomeserverURL()
+}
+
+func (cli *Client) rediscoverHomeserverURL() {
+	// The lookup isn't tied to the request that happened to notice the failures
+	ctx, cancel := context.WithTimeout(cli.Log.WithContext(context.Background()), rediscoverTimeout)
+	defer cancel()
+	log := &cli.Log
+	cache := cli.getWellKnownCache()
+	cache.Invalidate(cli.WellKnownServerName)
+	wellKnown, err := cache.Discover(ctx, cli.WellKnownServerName)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to re-resolve homeserver URL after connection failures")
+		return
+	} else if wellKnown == nil || wellKnown.Homeserver.BaseURL == "" {
+		return
+	}
+	newURL, err := ParseAndNormalizeBaseURL(wellKnown.Homeserver.BaseURL)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to parse re-resolved homeserver URL")
+		return
+	}
+	oldURL := cli.GetHomeserverURL()
+	if sameBaseURL(newURL, oldURL) {
+		return
+	}
+	log.Info().
+		Stringer("old_homeserver_url", oldURL).
+		Stringer("new_homeserver_url", newURL).
+		Msg("Homeserver URL changed after connection failures")
+	cli.SetHomeserverURL(newURL)
+}
+
+func sameBaseURL(a, b *url.URL) bool {
+	return a != nil && b != nil && a.String() == b.String()
+}
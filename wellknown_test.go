@@ -0,0 +1,108 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mautrix_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maunium.net/go/mautrix"
+)
+
+func TestWellKnownCache_Discover(t *testing.T) {
+	var calls int
+	fetchErr := errors.New("fetch failed")
+	cache := mautrix.NewWellKnownCache(time.Hour)
+	cache.Fetch = func(ctx context.Context, serverName string) (*mautrix.ClientWellKnown, error) {
+		calls++
+		if serverName == "broken.example" {
+			return nil, fetchErr
+		} else if serverName == "none.example" {
+			return nil, nil
+		}
+		return &mautrix.ClientWellKnown{Homeserver: mautrix.HomeserverInfo{BaseURL: "https://matrix." + serverName}}, nil
+	}
+	ctx := context.Background()
+
+	wk, err := cache.Discover(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "https://matrix.example.com", wk.Homeserver.BaseURL)
+	_, _ = cache.Discover(ctx, "example.com")
+	assert.Equal(t, 1, calls)
+
+	wk, err = cache.Discover(ctx, "none.example")
+	require.NoError(t, err)
+	assert.Nil(t, wk)
+	_, _ = cache.Discover(ctx, "none.example")
+	assert.Equal(t, 2, calls)
+
+	_, err = cache.Discover(ctx, "broken.example")
+	assert.ErrorIs(t, err, fetchErr)
+	_, _ = cache.Discover(ctx, "broken.example")
+	assert.Equal(t, 4, calls)
+
+	cache.Invalidate("example.com")
+	_, _ = cache.Discover(ctx, "example.com")
+	assert.Equal(t, 5, calls)
+}
+
+func TestClient_RediscoverOnConnectionFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"user_id": "@user:example.com"}`))
+	}))
+	t.Cleanup(srv.Close)
+	deadSrv := httptest.NewServer(http.NotFoundHandler())
+	deadSrv.Close()
+
+	cli, err := mautrix.NewClient(deadSrv.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+	cli.WellKnownServerName = "example.com"
+	cli.WellKnownCache = mautrix.NewWellKnownCache(time.Hour)
+	cli.WellKnownCache.Fetch = func(ctx context.Context, serverName string) (*mautrix.ClientWellKnown, error) {
+		return &mautrix.ClientWellKnown{Homeserver: mautrix.HomeserverInfo{BaseURL: srv.URL}}, nil
+	}
+	cli.DefaultHTTPRetries = 0
+
+	for i := 0; i < mautrix.RediscoverAfterConnectionFailures; i++ {
+		_, err = cli.Whoami(context.Background())
+		require.Error(t, err)
+	}
+	assert.Equal(t, srv.URL, cli.GetHomeserverURL().String())
+	resp, err := cli.Whoami(context.Background())
+	require.NoError(t, err)
+	assert.EqualValues(t, "@user:example.com", resp.UserID)
+}
+
+func TestClient_RediscoverBackoff(t *testing.T) {
+	deadSrv := httptest.NewServer(http.NotFoundHandler())
+	deadSrv.Close()
+
+	cli, err := mautrix.NewClient(deadSrv.URL, "@user:example.com", "token")
+	require.NoError(t, err)
+	var fetches atomic.Int32
+	cli.WellKnownServerName = "example.com"
+	cli.WellKnownCache = mautrix.NewWellKnownCache(time.Hour)
+	cli.WellKnownCache.Fetch = func(ctx context.Context, serverName string) (*mautrix.ClientWellKnown, error) {
+		fetches.Add(1)
+		return &mautrix.ClientWellKnown{Homeserver: mautrix.HomeserverInfo{BaseURL: deadSrv.URL}}, nil
+	}
+	cli.DefaultHTTPRetries = 0
+
+	for i := 0; i < mautrix.RediscoverAfterConnectionFailures+3; i++ {
+		_, err = cli.Whoami(context.Background())
+		require.Error(t, err)
+	}
+	assert.EqualValues(t, 1, fetches.Load(), "re-resolving should back off while failures continue")
+}